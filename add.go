@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// addFiles inserts every --add local:dest file or directory into the bundle. Existing entries
+// at a destination are replaced with --add-overwrite and are an error otherwise (directories
+// landing on directories are fine). Local files are read at zip time, not buffered here;
+// writeZipFile fails one that changes while it's being written.
+//
+// The returned plist data is non-nil when an added file replaced Info.plist.
+func addFiles(entries []BundleEntry, opts Options) ([]BundleEntry, []string, []byte, error) {
+	index := make(map[string]int, len(entries))
+	for i, entry := range entries {
+		index[entry.RelPath] = i
+	}
+
+	var added []string
+	var plistOverride []byte
+
+	for _, spec := range opts.Add {
+		// Split on the last colon so Windows drive letters ("C:\x:dest") survive
+		sep := strings.LastIndex(spec, ":")
+		if sep <= 0 || sep == len(spec)-1 {
+			return nil, nil, nil, fmt.Errorf("--add %q: want local/path:Bundle/Relative/Dest", spec)
+		}
+		source, dest := spec[:sep], strings.Trim(filepath.ToSlash(spec[sep+1:]), "/")
+		if dest == "" || !isLocalPath(dest) {
+			return nil, nil, nil, fmt.Errorf("--add %q: destination must stay inside the app bundle", spec)
+		}
+		dest = path.Clean(dest)
+
+		err := filepath.WalkDir(source, func(localPath string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(source, localPath)
+			if err != nil {
+				return err
+			}
+			relPath := dest
+			if rel != "." {
+				relPath = path.Join(dest, filepath.ToSlash(rel))
+			}
+
+			info, err := os.Lstat(localPath)
+			if err != nil {
+				return err
+			}
+			vf := &VirtualFile{Name: localPath, ModTime: info.ModTime()}
+			switch {
+			case info.Mode()&os.ModeSymlink != 0:
+				if vf.LinkDest, err = os.Readlink(localPath); err != nil {
+					return err
+				}
+				vf.IsLink = true
+				vf.Mode = 0777
+			case info.IsDir():
+				vf.IsDir = true
+				vf.Mode = 0755
+			case info.Mode().IsRegular():
+				vf.DiskPath = localPath
+				vf.Size = info.Size()
+				vf.Mode = 0644
+				if info.Mode()&0111 != 0 || strings.HasSuffix(localPath, ".dylib") {
+					vf.Mode = 0755
+				}
+			default:
+				return fmt.Errorf("%s: not a regular file, directory or symlink", localPath)
+			}
+
+			if i, exists := index[relPath]; exists {
+				if vf.IsDir && entries[i].File.IsDir {
+					return nil
+				}
+				if !opts.AddOverwrite {
+					return fmt.Errorf("%s already exists in the bundle (use --add-overwrite)", relPath)
+				}
+				entries[i] = BundleEntry{File: vf, RelPath: relPath}
+			} else {
+				index[relPath] = len(entries)
+				entries = append(entries, BundleEntry{File: vf, RelPath: relPath})
+			}
+
+			if !vf.IsDir {
+				added = append(added, relPath)
+			}
+			if vf.DiskPath != "" {
+				if relPath == "Info.plist" {
+					if plistOverride, err = os.ReadFile(localPath); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("--add %q: %w", spec, err)
+		}
+	}
+
+	printPathList("Added", added)
+	return entries, added, plistOverride, nil
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// AltStoreVersion is one entry of an app's "versions" array in an AltStore source
+type AltStoreVersion struct {
+	Version              string `json:"version"`
+	BuildVersion         string `json:"buildVersion,omitempty"`
+	Date                 string `json:"date"`
+	LocalizedDescription string `json:"localizedDescription,omitempty"`
+	DownloadURL          string `json:"downloadURL"`
+	Size                 int64  `json:"size"`
+	SHA256               string `json:"sha256"`
+	MinOSVersion         string `json:"minOSVersion,omitempty"`
+}
+
+// AltStoreApp is a new app entry for an AltStore source
+type AltStoreApp struct {
+	Name                 string            `json:"name"`
+	BundleIdentifier     string            `json:"bundleIdentifier"`
+	DeveloperName        string            `json:"developerName"`
+	LocalizedDescription string            `json:"localizedDescription"`
+	IconURL              string            `json:"iconURL"`
+	Versions             []AltStoreVersion `json:"versions"`
+	AppPermissions       map[string]any    `json:"appPermissions"`
+}
+
+// publishAltStoreEntry builds the AltStore app entry for a converted IPA and prints it,
+// adds it to the source file, or both.
+func publishAltStoreEntry(result *Result, opts Options) error {
+	iconURL, err := altStoreIconURL(opts)
+	if err != nil {
+		return err
+	}
+	app, err := altStoreApp(result, opts.DownloadURL, iconURL)
+	if err != nil {
+		return err
+	}
+
+	if opts.PrintSourceEntry {
+		out, err := json.MarshalIndent(app, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+	}
+
+	if opts.AltStoreSource != "" {
+		if err := updateAltStoreSource(opts.AltStoreSource, app); err != nil {
+			return err
+		}
+		fmt.Printf("   Updated AltStore source %s\n", opts.AltStoreSource)
+	}
+	return nil
+}
+
+// altStoreIconURL is --altstore-icon-url, else where --export-icon's file is served from
+// when it's uploaded beside the IPA
+func altStoreIconURL(opts Options) (string, error) {
+	if opts.AltStoreIconURL != "" {
+		return opts.AltStoreIconURL, nil
+	}
+	base, err := url.Parse(opts.DownloadURL)
+	if err != nil {
+		return "", fmt.Errorf("--download-url: %w", err)
+	}
+	return base.ResolveReference(&url.URL{Path: filepath.Base(opts.ExportIcon)}).String(), nil
+}
+
+func altStoreApp(result *Result, downloadURL, iconURL string) (*AltStoreApp, error) {
+	size, sum, err := fileSHA256(result.OutputPath)
+	if err != nil {
+		return nil, err
+	}
+
+	name := appDisplayName(result)
+	version := plistValue(result.InfoPlist, "CFBundleShortVersionString")
+	build := plistValue(result.InfoPlist, "CFBundleVersion")
+	if version == "" {
+		version = result.Version
+	}
+
+	description := result.Control["Description"]
+	developer := controlAuthor(result.Control)
+
+	return &AltStoreApp{
+		Name:                 name,
+		BundleIdentifier:     result.BundleID,
+		DeveloperName:        developer,
+		LocalizedDescription: description,
+		IconURL:              iconURL,
+		Versions: []AltStoreVersion{{
+			Version:              version,
+			BuildVersion:         build,
+			Date:                 time.Now().UTC().Format(time.RFC3339),
+			LocalizedDescription: description,
+			DownloadURL:          downloadURL,
+			Size:                 size,
+			SHA256:               sum,
+			MinOSVersion:         plistValue(result.InfoPlist, "MinimumOSVersion"),
+		}},
+		AppPermissions: map[string]any{},
+	}, nil
+}
+
+// appDisplayName is the name the app shows under its icon, else its folder's name
+func appDisplayName(result *Result) string {
+	name := plistValue(result.InfoPlist, "CFBundleDisplayName")
+	if name == "" {
+		name = plistValue(result.InfoPlist, "CFBundleName")
+	}
+	if name == "" {
+		name = strings.TrimSuffix(result.AppName, ".app")
+	}
+	return name
+}
+
+// controlAuthor is the deb's Author, else its Maintainer, without the email address
+func controlAuthor(control map[string]string) string {
+	developer := control["Author"]
+	if developer == "" {
+		developer = control["Maintainer"]
+	}
+	// "Name <email>" -> "Name"
+	if i := strings.Index(developer, "<"); i > 0 {
+		developer = strings.TrimSpace(developer[:i])
+	}
+	return developer
+}
+
+// updateAltStoreSource appends or updates the app in an AltStore source file.
+// Existing apps get the new version prepended (newest first, as AltStore expects), and
+// the top-level copies of its fields that sources from before "versions" carry are
+// refreshed; everything else in the file, including keys we don't know about, is kept as is.
+func updateAltStoreSource(sourcePath string, app *AltStoreApp) error {
+	source := map[string]any{}
+
+	data, err := os.ReadFile(sourcePath)
+	switch {
+	case os.IsNotExist(err):
+		name := strings.TrimSuffix(filepath.Base(sourcePath), filepath.Ext(sourcePath))
+		source["name"] = name
+		source["identifier"] = "com.example." + strings.ToLower(strings.ReplaceAll(name, " ", "-"))
+		source["apps"] = []any{}
+		source["news"] = []any{}
+	case err != nil:
+		return err
+	default:
+		if err := json.Unmarshal(data, &source); err != nil {
+			return fmt.Errorf("%s is not valid JSON: %w", sourcePath, err)
+		}
+	}
+
+	apps, _ := source["apps"].([]any)
+
+	// Round-trip our typed structs into the same generic shape as the file
+	var appMap map[string]any
+	raw, _ := json.Marshal(app)
+	json.Unmarshal(raw, &appMap)
+	newVersion := appMap["versions"].([]any)[0].(map[string]any)
+
+	found := false
+	for _, a := range apps {
+		existing, ok := a.(map[string]any)
+		if !ok || existing["bundleIdentifier"] != app.BundleIdentifier {
+			continue
+		}
+		found = true
+
+		versions, _ := existing["versions"].([]any)
+		kept := []any{newVersion}
+		for _, v := range versions {
+			// Re-publishing the same version replaces it rather than duplicating it
+			if vm, ok := v.(map[string]any); ok && vm["version"] == newVersion["version"] && vm["buildVersion"] == newVersion["buildVersion"] {
+				continue
+			}
+			kept = append(kept, v)
+		}
+		existing["versions"] = kept
+		for key, value := range map[string]any{
+			"version":            newVersion["version"],
+			"versionDate":        newVersion["date"],
+			"versionDescription": newVersion["localizedDescription"],
+			"downloadURL":        newVersion["downloadURL"],
+			"size":               newVersion["size"],
+		} {
+			if _, ok := existing[key]; !ok {
+				continue
+			}
+			if value == nil {
+				value = "" // A version without a description
+			}
+			existing[key] = value
+		}
+		if icon, _ := existing["iconURL"].(string); icon == "" {
+			existing["iconURL"] = app.IconURL
+		}
+		break
+	}
+	if !found {
+		apps = append(apps, appMap)
+	}
+	source["apps"] = apps
+
+	out, err := json.MarshalIndent(source, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file and rename, so a crash never leaves a truncated source
+	tmp := sourcePath + ".tmp"
+	if err := os.WriteFile(tmp, append(out, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, sourcePath)
+}
+
+// fileSHA256 returns the size and hex SHA256 of a file
+func fileSHA256(filePath string) (int64, string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", err
+	}
+	return n, hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestAltStoreIconURL takes --altstore-icon-url as given, else puts --export-icon's file
+// beside the IPA's URL
+func TestAltStoreIconURL(t *testing.T) {
+	for _, c := range []struct {
+		opts Options
+		want string
+	}{
+		{Options{DownloadURL: "https://example.com/apps/App.ipa", AltStoreIconURL: "https://cdn.example.com/icon.png"}, "https://cdn.example.com/icon.png"},
+		{Options{DownloadURL: "https://example.com/apps/App.ipa", ExportIcon: "out/App.png"}, "https://example.com/apps/App.png"},
+	} {
+		if got, err := altStoreIconURL(c.opts); err != nil || got != c.want {
+			t.Errorf("icon URL %q (%v), want %q", got, err, c.want)
+		}
+	}
+}
+
+// TestAltStoreSourceLegacy adds a version to a source from before "versions": its
+// top-level copies of the latest version's fields are refreshed
+func TestAltStoreSourceLegacy(t *testing.T) {
+	sourcePath := filepath.Join(t.TempDir(), "source.json")
+	if err := os.WriteFile(sourcePath, []byte(`{"name": "Source", "apps": [{
+		"bundleIdentifier": "com.example.fixture", "iconURL": "",
+		"version": "1.0", "versionDate": "2020-01-01", "downloadURL": "https://example.com/1.0.ipa", "size": 1,
+		"versions": [{"version": "1.0", "downloadURL": "https://example.com/1.0.ipa", "size": 1}]
+	}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	app := &AltStoreApp{BundleIdentifier: "com.example.fixture", IconURL: "https://example.com/icon.png", Versions: []AltStoreVersion{{
+		Version: "2.0", Date: "2026-01-01T00:00:00Z", DownloadURL: "https://example.com/2.0.ipa", Size: 2,
+	}}}
+	if err := updateAltStoreSource(sourcePath, app); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(sourcePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var source struct {
+		Apps []map[string]any `json:"apps"`
+	}
+	if err := json.Unmarshal(data, &source); err != nil {
+		t.Fatal(err)
+	}
+	if len(source.Apps) != 1 {
+		t.Fatalf("%d apps, want the one updated", len(source.Apps))
+	}
+	got := source.Apps[0]
+	for key, want := range map[string]any{
+		"version":     "2.0",
+		"versionDate": "2026-01-01T00:00:00Z",
+		"downloadURL": "https://example.com/2.0.ipa",
+		"size":        float64(2),
+		"iconURL":     "https://example.com/icon.png",
+	} {
+		if got[key] != want {
+			t.Errorf("%s = %v, want %v", key, got[key], want)
+		}
+	}
+	if _, ok := got["versionDescription"]; ok {
+		t.Error("versionDescription added to a source that didn't have it")
+	}
+	if versions, _ := got["versions"].([]any); len(versions) != 2 {
+		t.Errorf("%d versions, want 2.0 ahead of 1.0", len(versions))
+	}
+}
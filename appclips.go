@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// --- App Clips: AppClips/<Clip>.app nested in the app ---
+// An App Clip ships inside its parent app, as an extension does in PlugIns/, and stays
+// there in the IPA. Detection never takes its folder for the app's (see bundlePrefix), its
+// executable is the one its own Info.plist names, and installd wants its bundle ID to be
+// the parent's plus a suffix, with a MinimumOSVersion of its own no older than the iOS
+// that introduced App Clips. --strip-appclips leaves them all out.
+
+// appClipMinOS is the first iOS that runs App Clips
+const appClipMinOS = "14.0"
+
+// AppClip is an App Clip nested in the app
+type AppClip struct {
+	Path       string `json:"path"` // e.g. "AppClips/Clip.app"
+	BundleID   string `json:"bundleId"`
+	Executable string `json:"executable,omitempty"`       // From its own Info.plist
+	MinOS      string `json:"minimumOSVersion,omitempty"` // Likewise
+	ValidID    bool   `json:"validId"`                    // Prefixed by the app's ID
+	Removed    bool   `json:"removed,omitempty"`          // Left out with --strip-appclips
+}
+
+// isAppClipPlist reports whether relPath is the Info.plist of an AppClips/ app
+func isAppClipPlist(relPath string) bool {
+	dir, file := path.Split(relPath)
+	dir = strings.TrimSuffix(dir, "/")
+	return file == "Info.plist" && strings.HasSuffix(dir, BundleExtApp) && path.Dir(dir) == "AppClips"
+}
+
+// findAppClips reads every App Clip's Info.plist, checking its ID against mainID
+func findAppClips(entries []BundleEntry, mainID string) ([]AppClip, error) {
+	var clips []AppClip
+	for _, entry := range entries {
+		if !isAppClipPlist(entry.RelPath) || entry.File.IsDir || entry.File.IsLink {
+			continue
+		}
+		data, err := readAll(entry.File)
+		if err != nil {
+			return nil, err
+		}
+		clip := AppClip{
+			Path:       path.Dir(entry.RelPath),
+			BundleID:   plistValue(data, "CFBundleIdentifier"),
+			Executable: plistValue(data, "CFBundleExecutable"),
+			MinOS:      plistValue(data, "MinimumOSVersion"),
+		}
+		clip.ValidID = mainID != "" && strings.HasPrefix(clip.BundleID, mainID+".")
+		clips = append(clips, clip)
+	}
+	return clips, nil
+}
+
+// stripAppClips leaves out the App Clips, marking them removed
+func stripAppClips(entries []BundleEntry, clips []AppClip) []BundleEntry {
+	if len(clips) == 0 {
+		return entries
+	}
+	for i := range clips {
+		clips[i].Removed = true
+	}
+	kept := entries[:0]
+	for _, entry := range entries {
+		if entry.RelPath != "AppClips" && !strings.HasPrefix(entry.RelPath, "AppClips/") {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
+
+// markAppClipExecutables makes each App Clip's executable, as its Info.plist names it,
+// executable before modes are laundered, whatever the deb recorded or its first bytes say.
+// Each change is printed when verbose; the number changed is returned.
+func markAppClipExecutables(entries []BundleEntry, clips []AppClip, verbose bool) int {
+	executables := make(map[string]bool)
+	for _, clip := range clips {
+		if clip.Executable != "" && !clip.Removed {
+			executables[clip.Path+"/"+clip.Executable] = true
+		}
+	}
+	changed := 0
+	for _, entry := range entries {
+		vf := entry.File
+		if !executables[entry.RelPath] || vf.IsDir || vf.IsLink || vf.Mode&0777 == 0755 {
+			continue
+		}
+		mode := launderedMode(vf, true)
+		if verbose {
+			fmt.Printf("   mode %s: %04o -> %04o (App Clip executable)\n", terminalSafe(entry.RelPath), vf.Mode, mode)
+		}
+		vf.Mode = int64(mode)
+		changed++
+	}
+	return changed
+}
+
+// printAppClips lists the App Clips, flagging what installd will refuse
+func printAppClips(clips []AppClip, mainID string, warnings *warningLog) {
+	for _, clip := range clips {
+		if clip.Removed {
+			fmt.Printf("   Removed App Clip %s (--strip-appclips)\n", terminalSafe(clip.Path))
+			continue
+		}
+		fmt.Printf("   App Clip: %s -> %s\n", terminalSafe(clip.Path), terminalSafe(clip.BundleID))
+		if !clip.ValidID {
+			warnings.add("appclip-id", clip.Path, "App Clip %s has ID %q, which isn't prefixed by %s; iOS will refuse to install the IPA (or use --strip-appclips)",
+				clip.Path, clip.BundleID, mainID+".")
+		}
+		switch {
+		case clip.MinOS == "":
+			warnings.add("appclip-min-os", clip.Path, "App Clip %s has no MinimumOSVersion of its own", clip.Path)
+		case compareDottedVersions(clip.MinOS, appClipMinOS) < 0:
+			warnings.add("appclip-min-os", clip.Path, "App Clip %s has MinimumOSVersion %s; App Clips need iOS %s or later", clip.Path, clip.MinOS, appClipMinOS)
+		}
+	}
+}
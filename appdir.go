@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// --- Bundle folders: a Foo.app already on disk ---
+// Given a .app folder instead of a deb, its files become the entries a deb's data.tar
+// would have held, named <Foo.app>/..., and go through the same metadata, mode and zip
+// steps. Files are read from where they are at zip time, as with --add.
+
+// isDirectory reports whether a path is a directory, to read with readAppDir
+func isDirectory(p string) bool {
+	info, err := os.Stat(p)
+	return err == nil && info.IsDir()
+}
+
+// readAppDir reads a bundle folder on disk as if it were a deb holding just that folder.
+// Symlinks stay symlinks. Modes come from the file system, except on Windows, which has no
+// execute bits: there every file starts at 0644, and the Mach-O check that launders modes
+// decides what's executable.
+func readAppDir(dir string, store *SpillStore, ro readOptions) (*DebContents, error) {
+	dir = filepath.Clean(dir)
+	name := filepath.Base(dir)
+	if path.Ext(name) != ro.bundleExt() {
+		return nil, fmt.Errorf("%s is a directory but not a %s folder; pass the bundle folder itself, or a deb", dir, ro.bundleExt())
+	}
+	if !ro.Quiet {
+		fmt.Printf("=> [2/5] Found the bundle folder %s. Reading...\n", name)
+	}
+
+	prefix := name + "/"
+	deb := &DebContents{AppDirPrefix: prefix}
+	limiter := newEntryLimiter(ro.Limits)
+	err := filepath.WalkDir(dir, func(localPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, localPath)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		entryName := prefix
+		if rel != "." {
+			entryName += rel
+		}
+		if rel != "." && ro.Filter != nil && ro.Filter.Excluded(rel, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		info, err := os.Lstat(localPath)
+		if err != nil {
+			return err
+		}
+		size := int64(0)
+		if info.Mode().IsRegular() {
+			size = info.Size()
+		}
+		if err := limiter.check(entryName, info.Mode()&os.ModeSymlink != 0, size); err != nil {
+			return err
+		}
+		vf := &VirtualFile{Name: entryName, ModTime: info.ModTime(), Mode: int64(info.Mode().Perm())}
+		if runtime.GOOS == "windows" {
+			vf.Mode = 0644
+		}
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			if vf.LinkDest, err = os.Readlink(localPath); err != nil {
+				return err
+			}
+			vf.IsLink = true
+			vf.LinkDest = filepath.ToSlash(vf.LinkDest)
+		case info.IsDir():
+			vf.IsDir = true
+			if !strings.HasSuffix(vf.Name, "/") {
+				vf.Name += "/"
+			}
+		case info.Mode().IsRegular():
+			vf.DiskPath = localPath
+			vf.Size = info.Size()
+			if rel == "Info.plist" {
+				if deb.InfoPlistData, err = os.ReadFile(localPath); err != nil {
+					return err
+				}
+			}
+		default:
+			return fmt.Errorf("%s: not a regular file, directory or symlink", localPath)
+		}
+		store.track(vf)
+		deb.Files = append(deb.Files, vf)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", dir, err)
+	}
+	return deb, nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// --- AppleDouble: macOS metadata that rides along in debs packed on a Mac ---
+// Tar on macOS stores a file's extended attributes either as PAX xattr records on its header
+// or as a "._name" AppleDouble sibling. Neither means anything in an IPA, where "._" files
+// are junk codesign can trip over, so they're left out unless --keep-appledouble, as are the
+// .DS_Store files Finder leaves in every folder it opens. A resource
+// fork is the exception worth knowing about: some very old apps read theirs, and an IPA
+// can't carry one, so forks are reported rather than dropped quietly.
+
+// appleDoubleMagic starts every AppleDouble file
+const appleDoubleMagic = 0x00051607
+
+// appleDoubleResourceFork is the AppleDouble entry ID holding the resource fork
+const appleDoubleResourceFork = 2
+
+// xattrResourceFork is the extended attribute a resource fork lives in
+const xattrResourceFork = "com.apple.ResourceFork"
+
+// paxXattrPrefix starts the PAX records bsdtar and GNU tar store extended attributes in
+const paxXattrPrefix = "SCHILY.xattr."
+
+// AppleDoubleResult is what the bundle carried of macOS metadata
+type AppleDoubleResult struct {
+	Removed       int            `json:"removed"`                 // "._" files left out
+	RemovedBytes  int64          `json:"removedBytes"`            // Their size, with the .DS_Store files
+	Kept          int            `json:"kept,omitempty"`          // "._" files kept by --keep-appledouble
+	DSStore       int            `json:"dsStore,omitempty"`       // .DS_Store files left out
+	Xattrs        int            `json:"xattrs,omitempty"`        // Entries whose PAX xattr records the IPA can't hold
+	ResourceForks []ResourceFork `json:"resourceForks,omitempty"` // Non-empty forks, which the IPA loses either way
+}
+
+// ResourceFork is a file's resource fork found in an AppleDouble file or an xattr record
+type ResourceFork struct {
+	Path  string `json:"path"`  // The file the fork belongs to
+	Bytes int64  `json:"bytes"` // The fork's size
+}
+
+// isAppleDoubleName reports whether a path's file name has the "._" AppleDouble prefix
+func isAppleDoubleName(relPath string) bool {
+	return strings.HasPrefix(path.Base(relPath), "._")
+}
+
+// appleDoubleForkSize is the resource fork length an AppleDouble file records; ok is false
+// for data that isn't AppleDouble at all
+func appleDoubleForkSize(data []byte) (size int64, ok bool) {
+	const header = 26 // Magic, version, 16 filler bytes, entry count
+	if len(data) < header || binary.BigEndian.Uint32(data) != appleDoubleMagic {
+		return 0, false
+	}
+	count := int(binary.BigEndian.Uint16(data[24:]))
+	for i := 0; i < count; i++ {
+		e := data[header+i*12:]
+		if len(e) < 12 {
+			break
+		}
+		if binary.BigEndian.Uint32(e) == appleDoubleResourceFork {
+			return int64(binary.BigEndian.Uint32(e[8:])), true
+		}
+	}
+	return 0, true
+}
+
+// paxXattrs reads a tar header's PAX records for extended attributes, returning the size of
+// the resource fork among them (the only one worth reporting); ok is false when there are none
+func paxXattrs(records map[string]string) (fork int64, ok bool) {
+	for key, value := range records {
+		if name, found := strings.CutPrefix(key, paxXattrPrefix); found {
+			ok = true
+			if name == xattrResourceFork {
+				fork = int64(len(value))
+			}
+		}
+	}
+	return fork, ok
+}
+
+// stripAppleDouble leaves out the "._" AppleDouble and .DS_Store files (keeping them with
+// keep) and reports resource forks, from those files and from xattrs (see
+// DebContents.Xattrs). It returns nil when the bundle has none of them.
+func stripAppleDouble(entries []BundleEntry, xattrs map[string]int64, keep bool) ([]BundleEntry, *AppleDoubleResult) {
+	result := &AppleDoubleResult{}
+	kept := entries[:0]
+	for _, entry := range entries {
+		vf := entry.File
+		if fork, ok := xattrs[vf.Name]; ok {
+			result.Xattrs++
+			if fork > 0 {
+				result.ResourceForks = append(result.ResourceForks, ResourceFork{Path: entry.RelPath, Bytes: fork})
+			}
+		}
+
+		if !vf.IsDir && !vf.IsLink && path.Base(entry.RelPath) == ".DS_Store" && !keep {
+			result.DSStore++
+			result.RemovedBytes += vf.Size
+			continue
+		}
+		if vf.IsDir || vf.IsLink || !isAppleDoubleName(entry.RelPath) {
+			kept = append(kept, entry)
+			continue
+		}
+		data, err := readAll(vf)
+		if err != nil {
+			kept = append(kept, entry)
+			continue
+		}
+		fork, ok := appleDoubleForkSize(data)
+		if !ok {
+			kept = append(kept, entry) // Named like one, but something else
+			continue
+		}
+		if fork > 0 {
+			owner := path.Join(path.Dir(entry.RelPath), strings.TrimPrefix(path.Base(entry.RelPath), "._"))
+			result.ResourceForks = append(result.ResourceForks, ResourceFork{Path: owner, Bytes: fork})
+		}
+		if keep {
+			result.Kept++
+			kept = append(kept, entry)
+			continue
+		}
+		result.Removed++
+		result.RemovedBytes += vf.Size
+	}
+	if result.Removed == 0 && result.Kept == 0 && result.Xattrs == 0 && result.DSStore == 0 {
+		return kept, nil
+	}
+	return kept, result
+}
+
+// printAppleDouble reports what was left out, and the resource forks the IPA loses
+func printAppleDouble(result *AppleDoubleResult, warnings *warningLog) {
+	if result == nil {
+		return
+	}
+	switch {
+	case result.Removed > 0 && result.DSStore > 0:
+		fmt.Printf("   Left out %d AppleDouble \"._\" and %d .DS_Store file(s) (%s); --keep-appledouble keeps them\n", result.Removed, result.DSStore, formatBytes(result.RemovedBytes))
+	case result.Removed > 0:
+		fmt.Printf("   Left out %d AppleDouble \"._\" file(s) (%s); --keep-appledouble keeps them\n", result.Removed, formatBytes(result.RemovedBytes))
+	case result.DSStore > 0:
+		fmt.Printf("   Left out %d .DS_Store file(s) (%s); --keep-appledouble keeps them\n", result.DSStore, formatBytes(result.RemovedBytes))
+	}
+	if result.Kept > 0 {
+		fmt.Printf("   Kept %d AppleDouble \"._\" file(s)\n", result.Kept)
+	}
+	if result.Xattrs > 0 {
+		fmt.Printf("   Dropped the extended attributes of %d entries (quarantine flags and the like); an IPA can't hold them\n", result.Xattrs)
+	}
+	for _, fork := range result.ResourceForks {
+		warnings.add("resource-fork", fork.Path, "%s has a %s resource fork, which an IPA can't carry; apps that read it will miss it",
+			fork.Path, formatBytes(fork.Bytes))
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// --- --app-prefix: naming the app folder instead of detecting it ---
+// Detection takes the first ".app/" in data.tar, which is wrong for debs shipping several
+// apps, or nesting the bundle somewhere odd. A given prefix is used for everything the
+// detected one is: which entries are the app, their bundle paths and the Info.plist read.
+
+// Bundle folder extensions: apps, and with --appex extensions converted on their own
+const (
+	BundleExtApp   = ".app"
+	BundleExtAppex = ".appex"
+)
+
+// bundlePrefix returns a tar entry's path up to and including the first folder ending in
+// ext, e.g. "Applications/MyApp.app/", or "" if there is none. The folder needs a name
+// before ext: a folder called just ".app" is no bundle. An .appex inside a .app is that
+// app's plug-in, not a bundle of its own, so it doesn't count, and neither does an App
+// Clip's .app in an AppClips/ folder, even one the deb has outside its app.
+func bundlePrefix(name, ext string) string {
+	for end := 0; ; {
+		idx := strings.Index(name[end:], ext+"/")
+		if idx == -1 {
+			return ""
+		}
+		idx += end
+		end = idx + len(ext) + 1
+		if idx == 0 || name[idx-1] == '/' || path.Base(path.Dir(name[:idx])) == "AppClips" {
+			continue
+		}
+		if ext == BundleExtAppex && strings.Contains(name[:idx], BundleExtApp+"/") {
+			return ""
+		}
+		return name[:end]
+	}
+}
+
+// versionedBundle matches a bundle folder renamed with a version while packaging, e.g.
+// "Foo.app-1.3" or "Foo.app-v2": the name and extension, then the version
+var versionedBundle = regexp.MustCompile(`^(.+\.(?:app|appex))-v?[0-9][0-9A-Za-z.+~_-]*$`)
+
+// versionedBundleName returns a versioned bundle folder's name without the version, e.g.
+// "Foo.app" for "Foo.app-1.3", or "" when folder isn't an ext folder with a version
+func versionedBundleName(folder, ext string) string {
+	m := versionedBundle.FindStringSubmatch(folder)
+	if m == nil || path.Ext(m[1]) != ext {
+		return ""
+	}
+	return m[1]
+}
+
+// versionedBundlePrefix is bundlePrefix for a versioned folder: a tar entry's path up to
+// and including the first one, e.g. "Applications/Foo.app-1.3/". Detection never takes
+// these for the app; the not-an-app error points at --app-prefix instead.
+func versionedBundlePrefix(name, ext string) string {
+	parts := strings.Split(name, "/")
+	for i, part := range parts[:len(parts)-1] {
+		if versionedBundleName(part, ext) != "" {
+			return strings.Join(parts[:i+1], "/") + "/"
+		}
+	}
+	return ""
+}
+
+// normalizeAppPrefix cleans a --app-prefix up the way tar entry names are: forward slashes,
+// no leading "./" or "/", one trailing slash. It must name an ext folder, or one with a
+// version after the extension (see versionedBundleName). "" stays "" (detect the prefix).
+func normalizeAppPrefix(prefix, ext string) (string, error) {
+	if prefix == "" {
+		return "", nil
+	}
+	cleaned := path.Clean(tarEntryName(strings.ReplaceAll(prefix, "\\", "/")))
+	if cleaned == "." || (!strings.HasSuffix(cleaned, ext) && versionedBundleName(path.Base(cleaned), ext) == "") {
+		return "", fmt.Errorf("--app-prefix %q: want the path of a %s folder inside the deb, e.g. Applications/MyApp%s/", prefix, ext, ext)
+	}
+	if !isLocalPath(cleaned) {
+		return "", fmt.Errorf("--app-prefix %q: the path must stay inside the deb", prefix)
+	}
+	return cleaned + "/", nil
+}
+
+// inAppPrefix reports whether a tar entry belongs to the app folder, the folder's own
+// entry included (some tars write it without a trailing slash)
+func inAppPrefix(name, prefix string) bool {
+	return strings.HasPrefix(name, prefix) || name+"/" == prefix
+}
+
+// appRelPath is a tar entry's path inside the app folder, "" for the folder itself
+func appRelPath(name, prefix string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(name+"/", prefix), "/")
+}
+
+// prefixStragglerExamples is how many left-out entries a prefix mismatch warning names
+const prefixStragglerExamples = 3
+
+// checkPrefixStragglers warns about entries left out of the app that look like they
+// belong in it: their path matches the app folder's once backslashes become slashes or
+// case is ignored, which iOS's case-sensitive file system won't. Names are already
+// canonical (see tarEntryName), so these are the inconsistencies left to explain rather
+// than silently drop.
+func checkPrefixStragglers(deb *DebContents, warnings *warningLog) {
+	prefix := deb.AppDirPrefix
+	folded := strings.ToLower(prefix)
+	reasons := make(map[string][]string)
+	var order []string
+	consider := func(name string) {
+		if inAppPrefix(name, prefix) {
+			return
+		}
+		reason := ""
+		switch slashed := tarEntryName(strings.ReplaceAll(name, "\\", "/")); {
+		case inAppPrefix(slashed, prefix):
+			reason = "the path uses backslashes for folders"
+		case inAppPrefix(strings.ToLower(slashed), folded):
+			reason = "the path differs in case"
+		default:
+			return
+		}
+		if reasons[reason] == nil {
+			order = append(order, reason)
+		}
+		reasons[reason] = append(reasons[reason], name)
+	}
+	for _, vf := range deb.Files {
+		consider(vf.Name)
+	}
+	for _, e := range deb.Outside {
+		consider(e.Name)
+	}
+	for _, reason := range order {
+		names := reasons[reason]
+		var examples []string
+		for _, name := range names[:min(len(names), prefixStragglerExamples)] {
+			examples = append(examples, fmt.Sprintf("%q", name))
+		}
+		more := ""
+		if len(names) > len(examples) {
+			more = fmt.Sprintf(" and %d more", len(names)-len(examples))
+		}
+		warnings.add("app-prefix-mismatch", "", "Left out %d entr%s looking like part of %s, but %s, e.g. %s%s; the deb's archive is inconsistent, so the IPA may be missing files",
+			len(names), map[bool]string{true: "y", false: "ies"}[len(names) == 1], prefix, reason, strings.Join(examples, ", "), more)
+	}
+}
+
+// appPrefixesSeen collects every bundle folder in data.tar, for when --app-prefix matches none
+type appPrefixesSeen map[string]bool
+
+func (s appPrefixesSeen) note(name, ext string) {
+	if prefix := bundlePrefix(name, ext); prefix != "" {
+		s[prefix] = true
+	}
+}
+
+// unmatchedAppPrefix is the error for a --app-prefix no entry is under
+func unmatchedAppPrefix(prefix, ext string, seen appPrefixesSeen) error {
+	if len(seen) == 0 {
+		return fmt.Errorf("--app-prefix %s matches nothing in the deb, which has no %s folders", prefix, ext)
+	}
+	prefixes := make([]string, 0, len(seen))
+	for p := range seen {
+		prefixes = append(prefixes, p)
+	}
+	sort.Strings(prefixes)
+	return fmt.Errorf("--app-prefix %s matches nothing in the deb; its %s folders are: %s", prefix, ext, strings.Join(prefixes, ", "))
+}
+
+// checkPayloadDirName validates --payload-dir-name: a single folder name with the bundle's
+// extension
+func checkPayloadDirName(name, ext string) error {
+	switch {
+	case strings.ContainsAny(name, `/\`):
+		return fmt.Errorf("%q is a path; want a folder name like MyApp%s", name, ext)
+	case path.Ext(name) != ext || name == ext:
+		return fmt.Errorf("%q must be a folder name ending in %s", name, ext)
+	case name != strings.TrimSpace(name):
+		return fmt.Errorf("%q starts or ends with a space", name)
+	}
+	return nil
+}
+
+// appNameUnsafe matches what --sanitize-app-name replaces with a dash in a bundle folder's
+// name: anything but ASCII letters, digits, dots, dashes and underscores, spaces included
+var appNameUnsafe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// sanitizedAppName is a bundle folder name every file system and signer takes, e.g.
+// "Foo-2.app" for "Foo 2.app"
+func sanitizedAppName(name, ext string) string {
+	stem := strings.Trim(appNameUnsafe.ReplaceAllString(strings.TrimSuffix(name, ext), "-"), "-.")
+	return valueOr(stem, "App") + ext
+}
+
+// printPayloadDirRename reports the archive's new folder name, warning when it no longer
+// matches CFBundleName, which some tools expect the folder to be named after
+func printPayloadDirRename(from, to string, infoPlistData []byte, warnings *warningLog) {
+	fmt.Printf("   Folder: %s (renamed from %s)\n", to, from)
+	if bundleName := plistValue(infoPlistData, "CFBundleName"); bundleName != "" && bundleName != strings.TrimSuffix(to, path.Ext(to)) {
+		warnings.add("payload-dir-name", to, "The folder %s doesn't match CFBundleName %q; some tools expect the two to agree", to, bundleName)
+	}
+}
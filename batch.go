@@ -0,0 +1,488 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- batch subcommand ---
+// deb-to-ipa batch [--jobs N] [--out-dir dir] [--recipes dir] [--quiet] [--no-progress] <dir|deb>... [-- flags]
+// converts many debs at once. A conversion prints straight to the process's stdout, so
+// each runs as a child process, and reports to the batch as it would to a front end: over
+// --ipc (see ipc.go), its phase and progress events giving the stage and how many of its
+// bytes are through, and its result event the outcome. The batch owns the terminal: one line per
+// running job and a totals line, redrawn in place, or a periodic summary where stdout
+// can't redraw. Finished jobs are reported above that, and in a table at the end.
+// A job that exits 10 under --warn-exit-level still converted, and is listed as WARN.
+// With --recipes, each job first reads its input's package name and bundle ID and passes
+// the recipe matching them (see recipe.go) to its conversion.
+// Exit status: 0 all converted, 1 any failed, 2 usage error.
+
+// batchRedrawInterval is how often the dashboard is redrawn on a terminal
+const batchRedrawInterval = 200 * time.Millisecond
+
+// batchSummaryInterval is how often the totals are printed where stdout can't redraw
+const batchSummaryInterval = 10 * time.Second
+
+// batchOutputTail is how much of a job's console output is kept, to explain a failure
+// that came before it connected over --ipc
+const batchOutputTail = 4 << 10
+
+// batchJob is one input, and what its conversion has reported so far
+type batchJob struct {
+	Input   string
+	Output  string // -o given to the conversion; "" to use its default
+	Recipe  string // --recipe given to the conversion, from --recipes
+	Stage   string // The phase it's in, e.g. "Writing IPA"
+	Percent int    // Of the phase's bytes
+	Running bool
+	Done    bool
+	Result  *IPCEvent // Its result event
+	Err     error     // Why it failed, when it did without a result event
+	Elapsed time.Duration
+	started time.Time
+	ipc     string // Where the batch listens for its events
+}
+
+// failed reports whether the job finished without converting
+func (j *batchJob) failed() bool {
+	return j.Done && (j.Err != nil || j.Result == nil || (j.Result.Status != ResultStatusOK && j.Result.Status != ResultStatusWarnings))
+}
+
+// failure is a failed job's reason, from its result event where it sent one
+func (j *batchJob) failure() string {
+	if j.Err != nil {
+		return j.Err.Error()
+	}
+	if j.Result != nil {
+		return valueOr(j.Result.Error, "failed")
+	}
+	return "failed"
+}
+
+// output is where a converted job wrote its output
+func (j *batchJob) output() string {
+	if j.Result == nil || j.Result.Result == nil {
+		return ""
+	}
+	return j.Result.Result.OutputPath
+}
+
+// apply takes in one of the job's events; the caller holds the board's lock
+func (j *batchJob) apply(event IPCEvent) {
+	switch event.Event {
+	case "phase":
+		j.Stage, j.Percent = event.Phase, 0
+	case "progress":
+		if event.Total > 0 {
+			j.Percent = int(100 * event.Done / event.Total)
+		}
+	case "result":
+		j.Result = &event
+	}
+}
+
+// runBatch implements the batch subcommand and returns the exit status
+func runBatch(args []string) int {
+	fs := flag.NewFlagSet("batch", flag.ContinueOnError)
+	jobs := fs.Int("jobs", runtime.NumCPU(), "conversions to run at once")
+	outDir := fs.String("out-dir", "", "write every output here instead of next to its deb")
+	quiet := fs.Bool("quiet", false, "print only failures and the final table")
+	noProgress := fs.Bool("no-progress", false, "don't show the running jobs, only each as it finishes")
+	recipesDir := fs.String("recipes", "", "convert each input with the recipe in this directory (*.yaml, *.yml, *.json) whose match fits its package name or bundle ID")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: deb-to-ipa batch [--jobs N] [--out-dir dir] [--recipes dir] [--quiet] [--no-progress] <dir|deb>... [-- conversion flags]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	inputs, convFlags := fs.Args(), []string(nil)
+	if i := slices.Index(inputs, "--"); i >= 0 {
+		inputs, convFlags = inputs[:i], inputs[i+1:]
+	}
+	if len(inputs) == 0 || *jobs < 1 {
+		fs.Usage()
+		return 2
+	}
+	debs, err := batchInputs(inputs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Error: %v\n", err)
+		return 2
+	}
+	if len(debs) == 0 {
+		fmt.Fprintln(os.Stderr, "❌ Error: no .deb files to convert")
+		return 2
+	}
+	var recipes []*Recipe
+	if *recipesDir != "" {
+		// Every recipe is checked up front: one bad file shouldn't surface halfway through
+		if recipes, err = loadRecipeDir(*recipesDir); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Error: %v\n", err)
+			return 2
+		}
+		if hasBatchFlag(convFlags, "recipe") {
+			fmt.Fprintln(os.Stderr, "❌ Error: --recipes picks each input's recipe; don't also pass --recipe")
+			return 2
+		}
+	}
+	if hasBatchFlag(convFlags, "ipc") {
+		fmt.Fprintln(os.Stderr, "❌ Error: batch follows each conversion over its own --ipc; don't pass one")
+		return 2
+	}
+	if *outDir != "" {
+		if err := os.MkdirAll(*outDir, 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Error: --out-dir: %v\n", err)
+			return 2
+		}
+	}
+	self, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Error: %v\n", err)
+		return 2
+	}
+	ipcDir, err := os.MkdirTemp("", "deb-to-ipa-")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Error: %v\n", err)
+		return 2
+	}
+	defer os.RemoveAll(ipcDir)
+
+	all := make([]*batchJob, len(debs))
+	for i, deb := range debs {
+		all[i] = &batchJob{Input: deb, ipc: ipcAddress(ipcDir, i)}
+		if *outDir != "" {
+			all[i].Output = filepath.Join(*outDir, filepath.Base(packageBasePath(deb))+batchOutputExt(convFlags))
+		}
+	}
+	board := &batchBoard{jobs: all, quiet: *quiet, live: !*quiet && !*noProgress && stdoutRewrites, summary: !*quiet && !*noProgress && !stdoutRewrites}
+	start := time.Now()
+	board.start()
+
+	queue := make(chan *batchJob)
+	var wg sync.WaitGroup
+	for range min(*jobs, len(all)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				if recipes != nil && !matchBatchRecipe(job, recipes, board) {
+					continue
+				}
+				runBatchJob(self, job, convFlags, board)
+			}
+		}()
+	}
+	for _, job := range all {
+		queue <- job
+	}
+	close(queue)
+	wg.Wait()
+	board.finish()
+
+	failed := printBatchTable(all, time.Since(start))
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// batchInputs expands the arguments: a directory stands for the .deb files in it, sorted
+func batchInputs(args []string) ([]string, error) {
+	var debs []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			debs = append(debs, arg)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(arg, "*.deb"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(matches)
+		debs = append(debs, matches...)
+	}
+	return debs, nil
+}
+
+// hasBatchFlag reports whether the conversion flags set --name
+func hasBatchFlag(convFlags []string, name string) bool {
+	return slices.ContainsFunc(convFlags, func(f string) bool {
+		flagName, _, _ := strings.Cut(strings.TrimLeft(f, "-"), "=")
+		return strings.HasPrefix(f, "-") && flagName == name
+	})
+}
+
+// batchOutputExt is the extension a conversion with these flags writes, as outputPathFor picks it
+func batchOutputExt(convFlags []string) string {
+	for i, f := range convFlags {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(f, "-"), "=")
+		if name != "layout" {
+			continue
+		}
+		if !hasValue && i+1 < len(convFlags) {
+			value = convFlags[i+1]
+		}
+		if value != LayoutPayload {
+			return ".zip"
+		}
+	}
+	return ".ipa"
+}
+
+// runBatchJob converts one deb in a child process, passing the events it sends over --ipc to board
+func runBatchJob(self string, job *batchJob, convFlags []string, board *batchBoard) {
+	fail := func(err error) {
+		board.update(job, func() { job.Running, job.Done, job.Err = false, true, err })
+	}
+	listener, err := listenIPC(job.ipc)
+	if err != nil {
+		fail(fmt.Errorf("listening for its events: %w", err))
+		return
+	}
+	defer listener.Close()
+
+	args := append([]string{"--ipc", job.ipc}, convFlags...)
+	if job.Output != "" {
+		args = append(args, "-o", job.Output)
+	}
+	if job.Recipe != "" {
+		args = append(args, "--recipe", job.Recipe)
+	}
+	args = append(args, job.Input)
+	cmd := exec.Command(self, args...)
+	tail := &outputTail{}
+	cmd.Stdout, cmd.Stderr = tail, tail
+
+	events := make(chan struct{})
+	go func() {
+		defer close(events)
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		// Closing it is the hang-up the conversion waits for after its result
+		defer conn.Close()
+		for {
+			data, err := readIPCMessage(conn, ipcMaxEvent)
+			if err != nil {
+				return
+			}
+			var event IPCEvent
+			if json.Unmarshal(data, &event) == nil {
+				board.update(job, func() { job.apply(event) })
+			}
+		}
+	}()
+
+	board.update(job, func() { job.Running, job.started, job.Stage = true, time.Now(), "Starting" })
+	if err := cmd.Start(); err != nil {
+		listener.Close()
+		<-events
+		fail(err)
+		return
+	}
+	waitErr := cmd.Wait()
+	listener.Close() // Lets go of a wait for a connection that never came
+	<-events
+	board.update(job, func() {
+		job.Running, job.Done, job.Elapsed = false, true, time.Since(job.started)
+		if job.Result == nil {
+			job.Err = fmt.Errorf("exited without a result: %v", waitErr)
+			if line := tail.lastLine(); line != "" {
+				job.Err = fmt.Errorf("%w: %s", job.Err, line)
+			}
+		}
+	})
+}
+
+// outputTail keeps the last batchOutputTail bytes of a job's console output
+type outputTail struct {
+	buf []byte
+}
+
+func (t *outputTail) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > batchOutputTail {
+		t.buf = t.buf[len(t.buf)-batchOutputTail:]
+	}
+	return len(p), nil
+}
+
+// lastLine is the last line of output, as a redrawn line ended
+func (t *outputTail) lastLine() string {
+	text := strings.TrimSpace(string(t.buf))
+	if i := strings.LastIndexByte(text, '\n'); i >= 0 {
+		text = text[i+1:]
+	}
+	if i := strings.LastIndexByte(text, '\r'); i >= 0 {
+		text = text[i+1:]
+	}
+	return strings.TrimSpace(text)
+}
+
+// matchBatchRecipe sets the job's recipe from its input's package name and bundle ID,
+// failing the job when the input can't be read to find them. An input no recipe matches is
+// converted with the batch's flags alone.
+func matchBatchRecipe(job *batchJob, recipes []*Recipe, board *batchBoard) bool {
+	board.update(job, func() { job.Running, job.started, job.Stage = true, time.Now(), "Matching recipe" })
+	pkg, bundleID, err := packageIdentity(job.Input)
+	if err != nil {
+		board.update(job, func() {
+			job.Running, job.Done, job.Elapsed = false, true, time.Since(job.started)
+			job.Err = fmt.Errorf("reading it to match a recipe: %w", err)
+		})
+		return false
+	}
+	if recipe := pickRecipe(recipes, pkg, bundleID); recipe != nil {
+		board.update(job, func() { job.Recipe = recipe.Path })
+	}
+	return true
+}
+
+// batchBoard owns the terminal while a batch runs: finished jobs are printed above a
+// dashboard of the running ones, which is redrawn in place
+type batchBoard struct {
+	jobs    []*batchJob
+	quiet   bool // Only failures are printed as they finish
+	live    bool // Redraw the dashboard in place
+	summary bool // Print the totals every batchSummaryInterval instead
+
+	mu    sync.Mutex
+	drawn int // Dashboard lines on screen, to move back over
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// update changes a job under the board's lock, printing it if that finished it
+func (b *batchBoard) update(job *batchJob, change func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	change()
+	if !job.Done || (b.quiet && !job.failed()) {
+		return
+	}
+	b.erase()
+	if job.failed() {
+		fmt.Printf("❌ %s: %s\n", filepath.Base(job.Input), terminalSafe(job.failure()))
+	} else {
+		fmt.Printf("✅ %s -> %s (%s)\n", filepath.Base(job.Input), job.output(), job.Elapsed.Round(time.Millisecond))
+	}
+	b.draw()
+}
+
+// start redraws the dashboard, or prints the summary, until finish
+func (b *batchBoard) start() {
+	b.stop, b.done = make(chan struct{}), make(chan struct{})
+	interval := batchRedrawInterval
+	if !b.live {
+		interval = batchSummaryInterval
+	}
+	go func() {
+		defer close(b.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-b.stop:
+				return
+			}
+			b.mu.Lock()
+			if b.live {
+				b.erase()
+				b.draw()
+			} else if b.summary {
+				fmt.Printf("   Batch: %s\n", b.totals())
+			}
+			b.mu.Unlock()
+		}
+	}()
+}
+
+// finish stops the redraws and clears the dashboard
+func (b *batchBoard) finish() {
+	close(b.stop)
+	<-b.done
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.erase()
+}
+
+// totals counts the jobs by state, e.g. "4/10 done, 1 failed, 3 running"
+func (b *batchBoard) totals() string {
+	done, failed, running := 0, 0, 0
+	for _, job := range b.jobs {
+		switch {
+		case job.failed():
+			failed++
+			done++
+		case job.Done:
+			done++
+		case job.Running:
+			running++
+		}
+	}
+	return fmt.Sprintf("%d/%d done, %d failed, %d running", done, len(b.jobs), failed, running)
+}
+
+// draw writes one line per running job and the totals; the caller holds the lock
+func (b *batchBoard) draw() {
+	if !b.live {
+		return
+	}
+	for _, job := range b.jobs {
+		if job.Running {
+			fmt.Printf("   %3d%%  %-32s  %s (%s)\n", job.Percent, filepath.Base(job.Input), job.Stage, time.Since(job.started).Round(time.Second))
+			b.drawn++
+		}
+	}
+	fmt.Printf("   %s\n", b.totals())
+	b.drawn++
+}
+
+// erase moves back over the dashboard and clears it; the caller holds the lock
+func (b *batchBoard) erase() {
+	for ; b.drawn > 0; b.drawn-- {
+		fmt.Print("\x1b[1A\x1b[2K")
+	}
+}
+
+// printBatchTable prints every job's outcome and returns how many failed
+func printBatchTable(jobs []*batchJob, elapsed time.Duration) int {
+	failed := 0
+	fmt.Printf("\n%-6s  %-32s  %9s  %s\n", "STATUS", "INPUT", "TIME", "OUTPUT / ERROR")
+	for _, job := range jobs {
+		status, detail := "ok", job.output()
+		if job.Result != nil && job.Result.Status == ResultStatusWarnings {
+			warnings := 0
+			if job.Result.Result != nil {
+				warnings = len(job.Result.Result.Warnings)
+			}
+			status, detail = "WARN", fmt.Sprintf("%s (%d)", detail, warnings)
+		}
+		if job.failed() {
+			failed++
+			status, detail = "FAILED", terminalSafe(job.failure())
+		} else if job.Recipe != "" {
+			detail += " [" + filepath.Base(job.Recipe) + "]"
+		}
+		fmt.Printf("%-6s  %-32s  %9s  %s\n", status, filepath.Base(job.Input), job.Elapsed.Round(time.Millisecond), detail)
+	}
+	fmt.Printf("\n%d converted, %d failed in %s\n", len(jobs)-failed, failed, elapsed.Round(time.Millisecond))
+	return failed
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"deb-to-ipa/pkg/schema"
+)
+
+// --- bench subcommand ---
+// deb-to-ipa bench [-n runs] [--compare baseline.json] <deb> [deb...] converts each input
+// repeatedly and reports time per stage, memory and throughput.
+// Exit status: 0 ok, 1 regressions against --compare, 2 error.
+
+// benchStages are the stages convert reports to a benchClock, in order
+var benchStages = []string{"read", "process", "write"}
+
+// benchClock collects stage timings from convert; a nil clock records nothing
+type benchClock struct {
+	last   time.Time
+	stages map[string]time.Duration
+	spills int
+}
+
+func newBenchClock() *benchClock {
+	return &benchClock{last: time.Now(), stages: make(map[string]time.Duration)}
+}
+
+// mark ends a stage: the time since the previous mark is added to it
+func (c *benchClock) mark(stage string) {
+	if c == nil {
+		return
+	}
+	now := time.Now()
+	c.stages[stage] += now.Sub(c.last)
+	c.last = now
+}
+
+// spilled records how many files the conversion spilled to disk
+func (c *benchClock) spilled(n int) {
+	if c != nil {
+		c.spills = n
+	}
+}
+
+// BenchResult is the averaged measurements for one input
+type BenchResult struct {
+	Input      string             `json:"input"`
+	Runs       int                `json:"runs"`
+	InputBytes int64              `json:"inputBytes"`
+	WallMS     float64            `json:"wallMs"`   // Mean per run
+	StagesMS   map[string]float64 `json:"stagesMs"` // Mean per run
+	PeakRSS    int64              `json:"peakRss,omitempty"`
+	Allocs     uint64             `json:"allocs"`     // Mean per run
+	AllocBytes uint64             `json:"allocBytes"` // Mean per run
+	Spills     int                `json:"spills"`
+	MBPerSec   float64            `json:"mbPerSec"` // Input bytes over mean wall time
+}
+
+// BenchReport is a whole bench run, as saved with --save and read by --compare
+type BenchReport struct {
+	Schema    string        `json:"schema,omitempty"` // schema.Bench; empty in baselines saved before it
+	GoVersion string        `json:"goVersion"`
+	OS        string        `json:"os"`
+	Arch      string        `json:"arch"`
+	Results   []BenchResult `json:"results"`
+
+	Regressions []BenchRegression `json:"regressions,omitempty"` // With --compare, printed with --json; never saved
+}
+
+// BenchRegression is a metric that got worse than --threshold allows
+type BenchRegression struct {
+	Input  string  `json:"input"`
+	Metric string  `json:"metric"`
+	Old    float64 `json:"old"`
+	New    float64 `json:"new"`
+	Change float64 `json:"change"` // Percent
+}
+
+// runBench implements the bench subcommand and returns the exit status
+func runBench(args []string) int {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	runs := fs.Int("n", 3, "conversions per input")
+	compare := fs.String("compare", "", "baseline JSON from --save to compare against")
+	threshold := fs.Float64("threshold", 10, "with --compare, percent slowdown or growth counted as a regression")
+	save := fs.String("save", "", "write the results as JSON to this path, for a later --compare")
+	asJSON := fs.Bool("json", false, "print the results as JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: deb-to-ipa bench [-n runs] [--save out.json] [--compare baseline.json] <deb> [deb...]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 || *runs < 1 {
+		fs.Usage()
+		return 2
+	}
+
+	var baseline *BenchReport
+	if *compare != "" {
+		data, err := os.ReadFile(*compare)
+		if err == nil {
+			baseline = &BenchReport{}
+			if err = json.Unmarshal(data, baseline); err == nil {
+				err = schema.Check(baseline.Schema, schema.Bench)
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Error: %s: %v\n", *compare, err)
+			return 2
+		}
+	}
+
+	report := &BenchReport{Schema: schema.Bench, GoVersion: runtime.Version(), OS: runtime.GOOS, Arch: runtime.GOARCH}
+	for _, input := range fs.Args() {
+		if !*asJSON {
+			fmt.Printf("⏱️  %s (%d run(s))...\n", input, *runs)
+		}
+		res, err := benchInput(input, *runs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Error: %s: %v\n", input, err)
+			return 2
+		}
+		report.Results = append(report.Results, *res)
+	}
+
+	var regressions []BenchRegression
+	if baseline != nil {
+		regressions = compareBench(baseline, report, *threshold)
+	}
+
+	if *save != "" {
+		out, _ := json.MarshalIndent(report, "", "  ")
+		if err := os.WriteFile(*save, append(out, '\n'), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Error: %v\n", err)
+			return 2
+		}
+	}
+
+	if *asJSON {
+		report.Regressions = regressions
+		out, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(out))
+	} else {
+		printBench(report, baseline != nil, regressions, *threshold)
+	}
+
+	if len(regressions) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// benchInput converts one deb runs times into a temp dir, with convert's output silenced
+func benchInput(input string, runs int) (*BenchResult, error) {
+	info, err := os.Stat(input)
+	if err != nil {
+		return nil, err
+	}
+	tempDir, err := os.MkdirTemp("", "ipa-bench")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tempDir)
+
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer devNull.Close()
+
+	res := &BenchResult{Input: input, Runs: runs, InputBytes: info.Size(), StagesMS: make(map[string]float64)}
+	var wall time.Duration
+	for i := 0; i < runs; i++ {
+		clock := newBenchClock()
+		opts := Options{Layout: LayoutPayload, Output: filepath.Join(tempDir, "bench.ipa"), Clock: clock}
+
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+
+		stdout := os.Stdout
+		os.Stdout = devNull
+		start := time.Now()
+		_, err := convert(input, opts)
+		elapsed := time.Since(start)
+		os.Stdout = stdout
+		if err != nil {
+			return nil, err
+		}
+		runtime.ReadMemStats(&after)
+
+		wall += elapsed
+		for stage, d := range clock.stages {
+			res.StagesMS[stage] += milliseconds(d) / float64(runs)
+		}
+		res.Allocs += (after.Mallocs - before.Mallocs) / uint64(runs)
+		res.AllocBytes += (after.TotalAlloc - before.TotalAlloc) / uint64(runs)
+		res.Spills = clock.spills
+	}
+
+	res.WallMS = milliseconds(wall) / float64(runs)
+	if res.WallMS > 0 {
+		res.MBPerSec = float64(res.InputBytes) / (1 << 20) / (res.WallMS / 1000)
+	}
+	res.PeakRSS = peakRSS()
+	return res, nil
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// compareBench flags inputs whose wall time, peak RSS or allocated bytes grew by more
+// than threshold percent. Inputs are matched by file name so baselines move between machines.
+func compareBench(baseline, current *BenchReport, threshold float64) []BenchRegression {
+	old := make(map[string]BenchResult)
+	for _, r := range baseline.Results {
+		old[filepath.Base(r.Input)] = r
+	}
+
+	var regressions []BenchRegression
+	for _, r := range current.Results {
+		prev, ok := old[filepath.Base(r.Input)]
+		if !ok {
+			continue
+		}
+		for _, m := range []struct {
+			name     string
+			old, new float64
+		}{
+			{"wallMs", prev.WallMS, r.WallMS},
+			{"peakRss", float64(prev.PeakRSS), float64(r.PeakRSS)},
+			{"allocBytes", float64(prev.AllocBytes), float64(r.AllocBytes)},
+		} {
+			if m.old <= 0 || m.new <= 0 {
+				continue
+			}
+			if change := (m.new - m.old) / m.old * 100; change > threshold {
+				regressions = append(regressions, BenchRegression{Input: r.Input, Metric: m.name, Old: m.old, New: m.new, Change: change})
+			}
+		}
+	}
+	return regressions
+}
+
+// printBench renders results, and regressions when comparing, for humans
+func printBench(report *BenchReport, compared bool, regressions []BenchRegression, threshold float64) {
+	fmt.Printf("\n%s %s/%s\n", report.GoVersion, report.OS, report.Arch)
+	for _, r := range report.Results {
+		fmt.Printf("\n   %s (%s, %d run(s))\n", r.Input, formatBytes(r.InputBytes), r.Runs)
+		fmt.Printf("     Wall:       %.1f ms (%.1f MB/s)\n", r.WallMS, r.MBPerSec)
+
+		for _, stage := range benchStages {
+			if ms, ok := r.StagesMS[stage]; ok {
+				fmt.Printf("       %-9s %.1f ms\n", stage+":", ms)
+			}
+		}
+
+		fmt.Printf("     Allocs:     %d (%s)\n", r.Allocs, formatBytes(int64(r.AllocBytes)))
+		if r.PeakRSS > 0 {
+			fmt.Printf("     Peak RSS:   %s\n", formatBytes(r.PeakRSS))
+		}
+		fmt.Printf("     Spills:     %d\n", r.Spills)
+	}
+
+	if !compared {
+		return
+	}
+	fmt.Println()
+	if len(regressions) == 0 {
+		fmt.Printf("✅ No regressions beyond %.0f%%\n", threshold)
+		return
+	}
+	fmt.Printf("❌ %d regression(s) beyond %.0f%%:\n", len(regressions), threshold)
+	for _, r := range regressions {
+		fmt.Printf("   %s %s: %.1f -> %.1f (%+.1f%%)\n", r.Input, r.Metric, r.Old, r.New, r.Change)
+	}
+}
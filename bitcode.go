@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"debug/macho"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// --- Bitcode stripping: removing the __LLVM segment from binaries ---
+// Bitcode was only ever for App Store recompilation; devices never load it. The segment is
+// cut out of each slice and its load command dropped. Whatever follows it in the file
+// (normally __LINKEDIT) moves down, and every file offset into it is adjusted. Slices with a
+// load command not known to be safe to move are left alone with a warning. The code
+// signature is invalidated either way; the IPA has to be signed again.
+
+// BitcodeStrip is one binary --strip-bitcode rewrote or had to skip
+type BitcodeStrip struct {
+	Path    string `json:"path"`
+	Slices  int    `json:"slices"`            // Slices the segment was removed from
+	Saved   int64  `json:"saved"`             // Bytes the file shrank by
+	Skipped string `json:"skipped,omitempty"` // Why a slice with bitcode was left alone
+}
+
+// Mach-O constants for rewriting load commands; debug/macho only reads
+const (
+	lcSegment             = 0x1
+	lcSymtab              = 0x2
+	lcDysymtab            = 0xb
+	lcSegment64           = 0x19
+	lcDyldInfo            = 0x22
+	lcDyldInfoOnly        = 0x80000022
+	lcMain                = 0x80000028
+	fatMagic              = 0xcafebabe
+	machoPageSizeArm64    = 0x4000
+	machoPageSizeArmv7    = 0x1000
+	machoHeaderSize32     = 28
+	machoHeaderSize64     = 32
+	machoCPUArm64         = 0x0100000c
+	machoSegmentHeader32  = 56
+	machoSegmentHeader64  = 72
+	machoSectionHeader32  = 68
+	machoSectionHeader64  = 80
+	fatArchHeaderSize     = 20
+	fatHeaderSize         = 8
+	bitcodeSegmentName    = "__LLVM"
+	machoSegmentNameBytes = 16
+)
+
+// linkeditDataCommands are load commands holding a dataoff/datasize pair at offset 8
+var linkeditDataCommands = map[uint32]bool{
+	0x1d: true, 0x1e: true, 0x26: true, 0x29: true, 0x2b: true, 0x2e: true, 0x36: true, // Code signature, split info, function starts, data in code, code sign DRs, linker hints, atom info
+	0x80000033: true, 0x80000034: true, // Exports trie, chained fixups
+}
+
+// offsetFreeCommands are load commands without file offsets, which moving data can't break
+var offsetFreeCommands = map[uint32]bool{
+	0xc: true, 0xd: true, 0xe: true, 0xf: true, // Load/ID dylib, load/ID dylinker
+	0x12: true, 0x13: true, 0x14: true, 0x15: true, // Sub framework/umbrella/client/library
+	0x1b: true, 0x20: true, 0x24: true, 0x25: true, 0x27: true, // UUID, lazy load dylib, version min macOS/iOS, dyld environment
+	0x2a: true, 0x2d: true, 0x2f: true, 0x30: true, 0x32: true, // Source version, linker option, version min tvOS/watchOS, build version
+	0x80000018: true, 0x8000001c: true, 0x8000001f: true, 0x80000023: true, // Weak dylib, rpath, reexport dylib, upward dylib
+	0x21: true, 0x2c: true, // Encryption info: cryptoff lies in __TEXT, before any __LLVM
+}
+
+// stripBitcode removes the __LLVM segment from every Mach-O in the bundle that has one
+func stripBitcode(entries []BundleEntry, store *SpillStore) ([]BitcodeStrip, error) {
+	var stripped []BitcodeStrip
+	for _, entry := range entries {
+		vf := entry.File
+		if vf.IsDir || vf.IsLink || !sniffMachO(vf) {
+			continue
+		}
+		data, err := readAll(vf)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", entry.RelPath, err)
+		}
+		out, slices, skipped := stripBitcodeFile(data)
+		if slices == 0 && skipped == "" {
+			continue // No bitcode
+		}
+		s := BitcodeStrip{Path: entry.RelPath, Slices: slices, Skipped: skipped}
+		if slices > 0 {
+			s.Saved = int64(len(data) - len(out))
+			if err := store.Replace(vf, out); err != nil {
+				return nil, err
+			}
+		}
+		stripped = append(stripped, s)
+	}
+	sort.SliceStable(stripped, func(i, j int) bool { return stripped[i].Saved > stripped[j].Saved })
+	return stripped, nil
+}
+
+// stripBitcodeFile strips a thin or fat binary, returning it rewritten and how many slices
+// lost their bitcode. A fat binary is only rewritten when every slice with bitcode can be.
+func stripBitcodeFile(data []byte) (out []byte, slices int, skipped string) {
+	if len(data) < fatHeaderSize || binary.BigEndian.Uint32(data) != fatMagic {
+		out, skipped = stripBitcodeSlice(data)
+		if out == nil {
+			return data, 0, skipped
+		}
+		return out, 1, ""
+	}
+
+	type arch struct {
+		header []byte
+		data   []byte
+		align  uint32
+	}
+	n := int(binary.BigEndian.Uint32(data[4:]))
+	if fatHeaderSize+n*fatArchHeaderSize > len(data) {
+		return data, 0, ""
+	}
+	archs := make([]arch, n)
+	for i := range archs {
+		h := data[fatHeaderSize+i*fatArchHeaderSize:][:fatArchHeaderSize]
+		off, size := binary.BigEndian.Uint32(h[8:]), binary.BigEndian.Uint32(h[12:])
+		if uint64(off)+uint64(size) > uint64(len(data)) {
+			return data, 0, ""
+		}
+		archs[i] = arch{header: h, data: data[off : off+size], align: binary.BigEndian.Uint32(h[16:])}
+		stripped, reason := stripBitcodeSlice(archs[i].data)
+		if reason != "" {
+			return data, 0, reason
+		}
+		if stripped != nil {
+			archs[i].data = stripped
+			slices++
+		}
+	}
+	if slices == 0 {
+		return data, 0, ""
+	}
+
+	// Lay the slices out again, each at its alignment, after the same header gap as before
+	var buf bytes.Buffer
+	buf.Write(data[:fatHeaderSize+n*fatArchHeaderSize])
+	next := uint64(binary.BigEndian.Uint32(archs[0].header[8:]))
+	offsets := make([]uint64, n)
+	for i, a := range archs {
+		alignment := uint64(1) << min(a.align, 31)
+		next = (next + alignment - 1) / alignment * alignment
+		offsets[i] = next
+		next += uint64(len(a.data))
+	}
+	if next > 1<<32-1 {
+		return data, 0, "" // Can't shrink past what a 32-bit fat header holds; never happens
+	}
+	out = make([]byte, next)
+	copy(out, buf.Bytes())
+	for i, a := range archs {
+		h := out[fatHeaderSize+i*fatArchHeaderSize:]
+		binary.BigEndian.PutUint32(h[8:], uint32(offsets[i]))
+		binary.BigEndian.PutUint32(h[12:], uint32(len(a.data)))
+		copy(out[offsets[i]:], a.data)
+	}
+	return out, slices, ""
+}
+
+// stripBitcodeSlice removes the __LLVM segment from one slice. It returns nil with no
+// reason when there is none, and nil with a reason when it can't be removed safely.
+func stripBitcodeSlice(data []byte) (out []byte, skipped string) {
+	le := binary.LittleEndian
+	if len(data) < machoHeaderSize64 {
+		return nil, ""
+	}
+	var is64 bool
+	switch le.Uint32(data) {
+	case 0xfeedface:
+	case 0xfeedfacf:
+		is64 = true
+	default:
+		return nil, "" // Big-endian or not Mach-O: nothing iOS runs
+	}
+	headerSize, segHeader, sectHeader := machoHeaderSize32, machoSegmentHeader32, machoSectionHeader32
+	if is64 {
+		headerSize, segHeader, sectHeader = machoHeaderSize64, machoSegmentHeader64, machoSectionHeader64
+	}
+	pageSize := uint64(machoPageSizeArmv7)
+	if le.Uint32(data[4:]) == machoCPUArm64 {
+		pageSize = machoPageSizeArm64
+	}
+	ncmds, sizeofcmds := le.Uint32(data[16:]), le.Uint32(data[20:])
+	cmdsEnd := headerSize + int(sizeofcmds)
+	if cmdsEnd > len(data) {
+		return nil, ""
+	}
+
+	// Segment fields, by width
+	field := func(cmd []byte, off32, off64 int) uint64 {
+		if is64 {
+			return le.Uint64(cmd[off64:])
+		}
+		return uint64(le.Uint32(cmd[off32:]))
+	}
+	setField := func(cmd []byte, off32, off64 int, v uint64) {
+		if is64 {
+			le.PutUint64(cmd[off64:], v)
+		} else {
+			le.PutUint32(cmd[off32:], uint32(v))
+		}
+	}
+	isSegment := func(cmd uint32) bool { return cmd == lcSegment || cmd == lcSegment64 }
+
+	// Find the segment and check every command is one whose offsets can be adjusted
+	var cmds [][]byte
+	llvm := -1
+	var llvmOff, llvmEnd uint64
+	for i, off := uint32(0), headerSize; i < ncmds; i++ {
+		if off+8 > cmdsEnd {
+			return nil, ""
+		}
+		cmd, size := le.Uint32(data[off:]), int(le.Uint32(data[off+4:]))
+		if size < 8 || off+size > cmdsEnd {
+			return nil, ""
+		}
+		c := data[off : off+size]
+		if isSegment(cmd) && size >= segHeader && string(bytes.TrimRight(c[8:8+machoSegmentNameBytes], "\x00")) == bitcodeSegmentName {
+			llvm = len(cmds)
+			llvmOff, llvmEnd = field(c, 32, 40), field(c, 32, 40)+field(c, 36, 48)
+		}
+		cmds = append(cmds, c)
+		off += size
+	}
+	if llvm == -1 {
+		return nil, ""
+	}
+	if llvmEnd > uint64(len(data)) || llvmOff < uint64(cmdsEnd) || llvmEnd == llvmOff {
+		return nil, "the __LLVM segment's file range is malformed"
+	}
+	delta := llvmEnd - llvmOff
+
+	// shift moves one file offset down past the removed range; ok is false for an offset
+	// pointing into it
+	shift := func(v uint64) (uint64, bool) {
+		switch {
+		case v >= llvmEnd:
+			return v - delta, true
+		case v > llvmOff || (v == llvmOff && v != 0):
+			return v, false
+		}
+		return v, true
+	}
+
+	var rewritten [][]byte
+	for i, orig := range cmds {
+		if i == llvm {
+			continue
+		}
+		c := bytes.Clone(orig)
+		cmd := le.Uint32(c)
+		var offsets []int // Positions of 32-bit file offsets in the command
+		switch {
+		case isSegment(cmd):
+			if len(c) < segHeader {
+				return nil, ""
+			}
+			fileoff, filesize := field(c, 32, 40), field(c, 36, 48)
+			if filesize > 0 {
+				moved, ok := shift(fileoff)
+				if !ok || (fileoff < llvmOff && fileoff+filesize > llvmOff) {
+					return nil, "another segment overlaps __LLVM"
+				}
+				if moved != fileoff && moved%pageSize != 0 {
+					return nil, "the segments after __LLVM would lose their page alignment"
+				}
+				setField(c, 32, 40, moved)
+			}
+			nsects := int(le.Uint32(c[segHeader-8:]))
+			if segHeader+nsects*sectHeader > len(c) {
+				return nil, ""
+			}
+			for s := 0; s < nsects; s++ {
+				sect := segHeader + s*sectHeader
+				if is64 {
+					offsets = append(offsets, sect+48, sect+56)
+				} else {
+					offsets = append(offsets, sect+40, sect+48)
+				}
+			}
+		case cmd == lcSymtab:
+			offsets = []int{8, 16}
+		case cmd == lcDysymtab:
+			offsets = []int{32, 40, 48, 56, 64, 72}
+		case cmd == lcDyldInfo || cmd == lcDyldInfoOnly:
+			offsets = []int{8, 16, 24, 32, 40}
+		case linkeditDataCommands[cmd]:
+			offsets = []int{8}
+		case cmd == lcMain:
+			if v, ok := shift(le.Uint64(c[8:])); ok {
+				le.PutUint64(c[8:], v)
+			} else {
+				return nil, "the entry point lies in __LLVM"
+			}
+		case offsetFreeCommands[cmd]:
+		default:
+			return nil, fmt.Sprintf("load command 0x%x isn't known to be safe to move", cmd)
+		}
+		for _, at := range offsets {
+			if at+4 > len(c) {
+				return nil, ""
+			}
+			v, ok := shift(uint64(le.Uint32(c[at:])))
+			if !ok {
+				return nil, fmt.Sprintf("load command 0x%x points into __LLVM", cmd)
+			}
+			le.PutUint32(c[at:], uint32(v))
+		}
+		rewritten = append(rewritten, c)
+	}
+
+	// The header and commands keep their original footprint, the freed command zeroed
+	out = make([]byte, 0, len(data)-int(delta))
+	out = append(out, data[:headerSize]...)
+	le.PutUint32(out[16:], ncmds-1)
+	le.PutUint32(out[20:], sizeofcmds-uint32(len(cmds[llvm])))
+	for _, c := range rewritten {
+		out = append(out, c...)
+	}
+	out = append(out, make([]byte, len(cmds[llvm]))...)
+	out = append(out, data[cmdsEnd:llvmOff]...)
+	out = append(out, data[llvmEnd:]...)
+
+	if _, err := macho.NewFile(bytes.NewReader(out)); err != nil {
+		return nil, fmt.Sprintf("the rewritten slice doesn't parse (%v)", err)
+	}
+	return out, ""
+}
+
+// printBitcodeStrips reports what --strip-bitcode saved, and the binaries it left alone
+func printBitcodeStrips(stripped []BitcodeStrip, warnings *warningLog) {
+	var saved int64
+	files := 0
+	for _, s := range stripped {
+		if s.Skipped != "" {
+			warnings.add("bitcode-not-stripped", s.Path, "Left the bitcode in %s: %s", s.Path, s.Skipped)
+			continue
+		}
+		fmt.Printf("   Stripped bitcode from %s (%d slice(s)): %s saved\n", s.Path, s.Slices, formatBytes(s.Saved))
+		saved += s.Saved
+		files++
+	}
+	if files > 1 {
+		fmt.Printf("   Stripped bitcode from %d binaries, saving %s\n", files, formatBytes(saved))
+	}
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+	"unicode/utf16"
+)
+
+// --- Binary Property Lists (bplist00) ---
+// Xcode ships Info.plist as XML, but plutil'd and App Store bundles often carry the binary form.
+// Layout: "bplist00", the objects, an offset table, and a 32 byte trailer saying where the
+// table is, how wide offsets and object references are, and which object is the root.
+// Values decode to the same Go types as parsePlist.
+
+const bplistMagic = "bplist00"
+
+// plistEpoch is the reference date of binary plist dates (2001-01-01 UTC)
+var plistEpoch = time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// isBinaryPlist reports whether data is a binary plist
+func isBinaryPlist(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(bplistMagic))
+}
+
+type bplistDecoder struct {
+	data     []byte
+	offsets  []uint64
+	refSize  int
+	decoding map[uint64]bool // Objects on the current path, to reject reference cycles
+}
+
+// parseBinaryPlist decodes a bplist00 document
+func parseBinaryPlist(data []byte) (any, error) {
+	if !isBinaryPlist(data) || len(data) < len(bplistMagic)+32 {
+		return nil, errors.New("bplist: not a binary plist")
+	}
+	trailer := data[len(data)-32:]
+	offsetSize, refSize := int(trailer[6]), int(trailer[7])
+	numObjects := binary.BigEndian.Uint64(trailer[8:16])
+	topObject := binary.BigEndian.Uint64(trailer[16:24])
+	tableOffset := binary.BigEndian.Uint64(trailer[24:32])
+
+	if offsetSize < 1 || offsetSize > 8 || refSize < 1 || refSize > 8 {
+		return nil, errors.New("bplist: bad trailer")
+	}
+	if tableOffset >= uint64(len(data)) || numObjects > (uint64(len(data))-tableOffset)/uint64(offsetSize) {
+		return nil, errors.New("bplist: offset table out of range")
+	}
+
+	d := &bplistDecoder{data: data, refSize: refSize, decoding: make(map[uint64]bool)}
+	for i := uint64(0); i < numObjects; i++ {
+		pos := tableOffset + i*uint64(offsetSize)
+		d.offsets = append(d.offsets, readUint(data[pos:pos+uint64(offsetSize)]))
+	}
+	return d.object(topObject)
+}
+
+func readUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+// bytesAt returns n bytes at pos, or an error if that runs past the end
+func (d *bplistDecoder) bytesAt(pos, n uint64) ([]byte, error) {
+	if pos > uint64(len(d.data)) || n > uint64(len(d.data))-pos {
+		return nil, errors.New("bplist: object runs past end of file")
+	}
+	return d.data[pos : pos+n], nil
+}
+
+// length reads the count of a data/string/array/dict object: the marker's low nibble,
+// or a following int object when the nibble is 0xF. Returns the count and where content starts.
+func (d *bplistDecoder) length(pos uint64, info byte) (uint64, uint64, error) {
+	if info != 0x0F {
+		return uint64(info), pos + 1, nil
+	}
+	marker, err := d.bytesAt(pos+1, 1)
+	if err != nil {
+		return 0, 0, err
+	}
+	if marker[0]>>4 != 0x1 {
+		return 0, 0, errors.New("bplist: bad length")
+	}
+	size := uint64(1) << (marker[0] & 0x0F)
+	b, err := d.bytesAt(pos+2, size)
+	if err != nil {
+		return 0, 0, err
+	}
+	return readUint(b), pos + 2 + size, nil
+}
+
+func (d *bplistDecoder) refs(pos, count uint64) ([]uint64, error) {
+	b, err := d.bytesAt(pos, count*uint64(d.refSize))
+	if err != nil {
+		return nil, err
+	}
+	refs := make([]uint64, count)
+	for i := range refs {
+		refs[i] = readUint(b[i*d.refSize : (i+1)*d.refSize])
+	}
+	return refs, nil
+}
+
+func (d *bplistDecoder) object(ref uint64) (any, error) {
+	if ref >= uint64(len(d.offsets)) {
+		return nil, fmt.Errorf("bplist: object %d out of range", ref)
+	}
+	if d.decoding[ref] {
+		return nil, errors.New("bplist: reference cycle")
+	}
+	d.decoding[ref] = true
+	defer delete(d.decoding, ref)
+
+	pos := d.offsets[ref]
+	head, err := d.bytesAt(pos, 1)
+	if err != nil {
+		return nil, err
+	}
+	kind, info := head[0]>>4, head[0]&0x0F
+
+	switch kind {
+	case 0x0:
+		switch info {
+		case 0x8:
+			return false, nil
+		case 0x9:
+			return true, nil
+		}
+		return nil, nil
+
+	case 0x1: // int, 2^info bytes; 16 byte ints keep their low 8 bytes
+		size := uint64(1) << info
+		b, err := d.bytesAt(pos+1, size)
+		if err != nil {
+			return nil, err
+		}
+		if size > 8 {
+			b = b[size-8:]
+		}
+		return int64(readUint(b)), nil
+
+	case 0x2: // real
+		size := uint64(1) << info
+		b, err := d.bytesAt(pos+1, size)
+		if err != nil {
+			return nil, err
+		}
+		switch size {
+		case 4:
+			return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+		case 8:
+			return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+		}
+		return nil, errors.New("bplist: bad real size")
+
+	case 0x3: // date
+		b, err := d.bytesAt(pos+1, 8)
+		if err != nil {
+			return nil, err
+		}
+		seconds := math.Float64frombits(binary.BigEndian.Uint64(b))
+		return plistEpoch.Add(time.Duration(seconds * float64(time.Second))), nil
+
+	case 0x4, 0x5, 0x6: // data, ASCII string, UTF-16 string
+		count, start, err := d.length(pos, info)
+		if err != nil {
+			return nil, err
+		}
+		if kind == 0x6 {
+			b, err := d.bytesAt(start, count*2)
+			if err != nil {
+				return nil, err
+			}
+			units := make([]uint16, count)
+			for i := range units {
+				units[i] = binary.BigEndian.Uint16(b[i*2:])
+			}
+			return string(utf16.Decode(units)), nil
+		}
+		b, err := d.bytesAt(start, count)
+		if err != nil {
+			return nil, err
+		}
+		if kind == 0x4 {
+			return append([]byte(nil), b...), nil
+		}
+		return string(b), nil
+
+	case 0x8: // UID (keyed archives only): surface as its integer value
+		b, err := d.bytesAt(pos+1, uint64(info)+1)
+		if err != nil {
+			return nil, err
+		}
+		return int64(readUint(b)), nil
+
+	case 0xA: // array
+		count, start, err := d.length(pos, info)
+		if err != nil {
+			return nil, err
+		}
+		refs, err := d.refs(start, count)
+		if err != nil {
+			return nil, err
+		}
+		array := make([]any, 0, len(refs))
+		for _, r := range refs {
+			value, err := d.object(r)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, value)
+		}
+		return array, nil
+
+	case 0xD: // dict: all key refs, then all value refs
+		count, start, err := d.length(pos, info)
+		if err != nil {
+			return nil, err
+		}
+		refs, err := d.refs(start, count*2)
+		if err != nil {
+			return nil, err
+		}
+		dict := make(map[string]any, count)
+		for i := uint64(0); i < count; i++ {
+			key, err := d.object(refs[i])
+			if err != nil {
+				return nil, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, errors.New("bplist: non-string dict key")
+			}
+			if dict[k], err = d.object(refs[count+i]); err != nil {
+				return nil, err
+			}
+		}
+		return dict, nil
+	}
+	return nil, fmt.Errorf("bplist: unknown object type 0x%x", head[0])
+}
+
+// encodeBinaryPlist writes a value (of the types parsePlist produces) as bplist00.
+// Dict keys are written sorted, so output is deterministic.
+func encodeBinaryPlist(value any) ([]byte, error) {
+	// Flatten the tree into a numbered object list; containers refer to children by index
+	type object struct {
+		value any
+		refs  []int
+	}
+	var objects []*object
+	var flatten func(v any) (int, error)
+	flatten = func(v any) (int, error) {
+		index := len(objects)
+		obj := &object{value: v}
+		objects = append(objects, obj)
+		switch v := v.(type) {
+		case map[string]any:
+			keys := make([]string, 0, len(v))
+			for k := range v {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			var valueRefs []int
+			for _, k := range keys {
+				ref, _ := flatten(k)
+				obj.refs = append(obj.refs, ref)
+			}
+			for _, k := range keys {
+				ref, err := flatten(v[k])
+				if err != nil {
+					return 0, err
+				}
+				valueRefs = append(valueRefs, ref)
+			}
+			obj.refs = append(obj.refs, valueRefs...)
+		case []any:
+			for _, item := range v {
+				ref, err := flatten(item)
+				if err != nil {
+					return 0, err
+				}
+				obj.refs = append(obj.refs, ref)
+			}
+		case string, []byte, bool, int64, int, float64, time.Time:
+		default:
+			return 0, fmt.Errorf("bplist: can't encode %T", v)
+		}
+		return index, nil
+	}
+	if _, err := flatten(value); err != nil {
+		return nil, err
+	}
+
+	refSize := 1
+	for len(objects) >= 1<<(8*refSize) {
+		refSize *= 2
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(bplistMagic)
+	offsets := make([]uint64, len(objects))
+
+	writeUint := func(v uint64, size int) {
+		for i := size - 1; i >= 0; i-- {
+			buf.WriteByte(byte(v >> (8 * i)))
+		}
+	}
+	writeInt := func(v int64) {
+		switch {
+		case v < 0 || v > math.MaxUint32:
+			buf.WriteByte(0x13)
+			writeUint(uint64(v), 8)
+		case v > math.MaxUint16:
+			buf.WriteByte(0x12)
+			writeUint(uint64(v), 4)
+		case v > math.MaxUint8:
+			buf.WriteByte(0x11)
+			writeUint(uint64(v), 2)
+		default:
+			buf.WriteByte(0x10)
+			writeUint(uint64(v), 1)
+		}
+	}
+	writeHeader := func(kind byte, count int) {
+		if count < 0x0F {
+			buf.WriteByte(kind<<4 | byte(count))
+			return
+		}
+		buf.WriteByte(kind<<4 | 0x0F)
+		writeInt(int64(count))
+	}
+
+	for i, obj := range objects {
+		offsets[i] = uint64(buf.Len())
+		switch v := obj.value.(type) {
+		case bool:
+			if v {
+				buf.WriteByte(0x09)
+			} else {
+				buf.WriteByte(0x08)
+			}
+		case int:
+			writeInt(int64(v))
+		case int64:
+			writeInt(v)
+		case float64:
+			buf.WriteByte(0x23)
+			writeUint(math.Float64bits(v), 8)
+		case time.Time:
+			buf.WriteByte(0x33)
+			writeUint(math.Float64bits(v.Sub(plistEpoch).Seconds()), 8)
+		case []byte:
+			writeHeader(0x4, len(v))
+			buf.Write(v)
+		case string:
+			ascii := true
+			for j := 0; j < len(v); j++ {
+				if v[j] >= 0x80 {
+					ascii = false
+					break
+				}
+			}
+			if ascii {
+				writeHeader(0x5, len(v))
+				buf.WriteString(v)
+				break
+			}
+			units := utf16.Encode([]rune(v))
+			writeHeader(0x6, len(units))
+			for _, u := range units {
+				writeUint(uint64(u), 2)
+			}
+		case map[string]any:
+			writeHeader(0xD, len(obj.refs)/2)
+			for _, r := range obj.refs {
+				writeUint(uint64(r), refSize)
+			}
+		case []any:
+			writeHeader(0xA, len(obj.refs))
+			for _, r := range obj.refs {
+				writeUint(uint64(r), refSize)
+			}
+		}
+	}
+
+	tableOffset := uint64(buf.Len())
+	offsetSize := 1
+	for tableOffset >= 1<<(8*offsetSize) {
+		offsetSize *= 2
+	}
+	for _, off := range offsets {
+		writeUint(off, offsetSize)
+	}
+
+	buf.Write(make([]byte, 6))
+	buf.WriteByte(byte(offsetSize))
+	buf.WriteByte(byte(refSize))
+	writeUint(uint64(len(objects)), 8)
+	writeUint(0, 8) // Root is always object 0
+	writeUint(tableOffset, 8)
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// --- Ranking candidate app folders ---
+// Without --app-prefix the app folder used to be the first ".app/" in data.tar. Themes
+// and widgets ship folders named like apps (Library/Themes/Foo.theme/Bundles/X.app) that
+// aren't, and converting one of those "succeeds" with a nonsense IPA. Every candidate is
+// now ranked: under Applications/ (rootful or rootless) first, then with an Info.plist
+// naming a CFBundleExecutable, then with a Mach-O at its root. The first seen wins among
+// equals, as before. A candidate with none of the three is never chosen; when every one
+// is like that, the deb is reported as not an app, decoys listed.
+//
+// Some ports install the real bundle elsewhere, e.g. /usr/share/<pkg>/Foo.app or /opt,
+// and put only a launcher symlink at /Applications/Foo.app. Which of the two turned up
+// first used to decide the result. Now a candidate a launcher points at ranks as under
+// Applications/, and the IPA takes the launcher's name for it. A launcher whose target
+// isn't named like a bundle makes that folder the bundle root, given an Info.plist naming
+// a CFBundleExecutable at it.
+
+// candidatePlistLimit is the largest Info.plist read to rank a candidate
+const candidatePlistLimit = 1 << 20
+
+// bundleCandidate is one folder that could be the app, and the evidence that it is
+type bundleCandidate struct {
+	Prefix       string
+	Applications bool // Under Applications/ or var/jb/Applications/
+	Executable   bool // Its Info.plist names a CFBundleExecutable
+	MachO        bool // A Mach-O file sits at its root
+}
+
+// rank orders candidates by the evidence, Applications/ counting most; 0 is a decoy
+func (c *bundleCandidate) rank() int {
+	rank := 0
+	for i, evidence := range []bool{c.MachO, c.Executable, c.Applications} {
+		if evidence {
+			rank |= 1 << i
+		}
+	}
+	return rank
+}
+
+// bundleLauncher is a symlink in Applications/ named like a bundle
+type bundleLauncher struct {
+	Name   string // The symlink's name, e.g. "Foo.app"
+	Target string // The folder it points at, as a prefix, e.g. "usr/share/foo/Foo.app/"
+}
+
+// bundleCandidates collects the bundle folders in data.tar, in the order seen
+type bundleCandidates struct {
+	ext       string
+	list      []*bundleCandidate
+	byPrefix  map[string]*bundleCandidate
+	launchers []bundleLauncher
+	plists    map[string]bool // Folders outside any bundle with an Info.plist, by prefix: whether it names an executable
+}
+
+func newBundleCandidates(ext string) *bundleCandidates {
+	return &bundleCandidates{ext: ext, byPrefix: make(map[string]*bundleCandidate), plists: make(map[string]bool)}
+}
+
+// wants returns how much of a regular file note needs to see: all of an Info.plist at a
+// bundle's root or outside any bundle (a launcher may point at its folder), the magic
+// number of other files at a bundle's root, nothing of the rest
+func (c *bundleCandidates) wants(name string, size int64) int64 {
+	prefix := bundlePrefix(name, c.ext)
+	if prefix == "" {
+		if path.Base(name) != "Info.plist" || size > candidatePlistLimit {
+			return 0
+		}
+		return size
+	}
+	switch rel := appRelPath(name, prefix); {
+	case rel == "Info.plist":
+		if size > candidatePlistLimit {
+			return 0
+		}
+		return size
+	case rel != "" && !strings.Contains(rel, "/"):
+		return min(size, 4)
+	}
+	return 0
+}
+
+// note records an entry under its bundle folder, if any. head is the start of a regular
+// file's data, as much as wants asked for, or nil when it wasn't read.
+func (c *bundleCandidates) note(name string, head []byte) {
+	if c == nil {
+		return
+	}
+	prefix := bundlePrefix(name, c.ext)
+	if prefix == "" {
+		if head != nil && path.Base(name) == "Info.plist" {
+			dir := path.Dir(name) + "/"
+			c.plists[dir] = c.plists[dir] || plistValue(head, "CFBundleExecutable") != ""
+		}
+		return
+	}
+	candidate := c.byPrefix[prefix]
+	if candidate == nil {
+		candidate = &bundleCandidate{Prefix: prefix, Applications: underApplications(prefix)}
+		c.byPrefix[prefix] = candidate
+		c.list = append(c.list, candidate)
+	}
+	switch rel := appRelPath(name, prefix); {
+	case head == nil:
+	case rel == "Info.plist":
+		candidate.Executable = candidate.Executable || plistValue(head, "CFBundleExecutable") != ""
+	case !strings.Contains(rel, "/"):
+		candidate.MachO = candidate.MachO || isMachO(head)
+	}
+}
+
+// noteLink is note for a symlink, which is a launcher when it sits in Applications/ named
+// like a bundle
+func (c *bundleCandidates) noteLink(name, dest string) {
+	if c == nil {
+		return
+	}
+	c.note(name, nil)
+	name = strings.TrimSuffix(name, "/")
+	if path.Ext(name) != c.ext || !underApplications(name+"/") {
+		return
+	}
+	if target := resolveLink(name, dest); target != "" {
+		c.launchers = append(c.launchers, bundleLauncher{Name: path.Base(name), Target: target + "/"})
+	}
+}
+
+// launcher returns the name of the first launcher pointing at prefix, "" when none does
+func (c *bundleCandidates) launcher(prefix string) string {
+	if c == nil {
+		return ""
+	}
+	for _, l := range c.launchers {
+		if l.Target == prefix {
+			return l.Name
+		}
+	}
+	return ""
+}
+
+// noteReader is note for an entry whose data is still to be read from r; it reads only
+// what wants asks for
+func (c *bundleCandidates) noteReader(name string, size int64, r io.Reader) error {
+	if c == nil {
+		return nil
+	}
+	n := c.wants(name, size)
+	if n == 0 {
+		c.note(name, nil)
+		return nil
+	}
+	head := make([]byte, n)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return err
+	}
+	c.note(name, head)
+	return nil
+}
+
+// noteFile is note for a file already read, in RAM or spilled
+func (c *bundleCandidates) noteFile(vf *VirtualFile) error {
+	if c == nil {
+		return nil
+	}
+	if vf.Data != nil || c.wants(vf.Name, vf.Size) == 0 {
+		c.note(vf.Name, vf.Data)
+		return nil
+	}
+	r, err := vf.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return c.noteReader(vf.Name, vf.Size, r)
+}
+
+// choose returns the best candidate's prefix, "" when there is none worth converting,
+// and the decoys: every candidate with no evidence at all
+func (c *bundleCandidates) choose() (prefix string, decoys []string) {
+	if c == nil {
+		return "", nil
+	}
+	// A launcher's target not named like a bundle is one when it has an executable's Info.plist
+	list := c.list
+	for _, l := range c.launchers {
+		if c.byPrefix[l.Target] == nil && c.plists[l.Target] {
+			candidate := &bundleCandidate{Prefix: l.Target, Executable: true}
+			c.byPrefix[l.Target] = candidate
+			list = append(list, candidate)
+		}
+	}
+	best := 0
+	for _, candidate := range list {
+		candidate.Applications = candidate.Applications || c.launcher(candidate.Prefix) != ""
+		if rank := candidate.rank(); rank == 0 {
+			decoys = append(decoys, candidate.Prefix)
+		} else if rank > best {
+			best, prefix = rank, candidate.Prefix
+		}
+	}
+	return prefix, decoys
+}
+
+// appFolderName is the app folder's name in the IPA, before any renaming: its launcher's,
+// when it has one
+func (d *DebContents) appFolderName() string {
+	return valueOr(d.Launcher, path.Base(d.AppDirPrefix))
+}
+
+// underApplications reports whether a bundle prefix sits in Applications/, rootful or rootless
+func underApplications(prefix string) bool {
+	return path.Dir(strings.TrimSuffix(strings.TrimPrefix(prefix, "var/jb/"), "/")) == "Applications"
+}
+
+// printDecoys tells what was passed over for the app folder, and why
+func printDecoys(prefix string, decoys []string) {
+	if len(decoys) == 0 {
+		return
+	}
+	verbs := map[bool][2]string{true: {"isn't", "has"}, false: {"aren't", "have"}}[len(decoys) == 1]
+	fmt.Printf("   Chose %s over %s, which %s under Applications/ and %s no Info.plist naming an executable or Mach-O at the root\n",
+		terminalSafe(prefix), terminalSafe(strings.Join(decoys, ", ")), verbs[0], verbs[1])
+}
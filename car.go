@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"sort"
+)
+
+// --- Assets.car (compiled asset catalog) ---
+// An Assets.car is a BOM store: a big-endian block table plus named variables, some of which
+// are B+trees. The trees we need are FACETKEYS (asset name -> attribute list including its
+// identifier) and RENDITIONS (rendition key -> CSI header + pixel data), with KEYFORMAT saying
+// which attribute each rendition key slot holds. Everything inside CAR structures is little-endian.
+//
+// This is deliberately minimal: enough to list asset names and pull the largest AppIcon
+// rendition out as an image. Renditions in formats we can't decode are skipped.
+
+const (
+	carAttrIdentifier = 17 // kCRThemeIdentifierName
+	csiHeaderSize     = 184
+	carMaxDimension   = 16384 // Larger bitmaps are taken as corrupt rather than allocated
+)
+
+// CarRendition is one image variant of an asset
+type CarRendition struct {
+	Identifier  uint16
+	Width       int
+	Height      int
+	Scale       int    // x100
+	PixelFormat string // "ARGB", "DATA", "JPEG", ...
+	Name        string // File name recorded by actool, e.g. "AppIcon60x60@3x.png"
+	data        []byte // Everything after the CSI header
+}
+
+// AssetCatalog is a parsed Assets.car
+type AssetCatalog struct {
+	Names      map[string]uint16 // Asset (facet) name -> identifier
+	Renditions []CarRendition
+}
+
+type bomStore struct {
+	data   []byte
+	blocks [][2]uint32 // address, length
+	vars   map[string]uint32
+}
+
+func openBOM(data []byte) (*bomStore, error) {
+	if len(data) < 32 || string(data[:8]) != "BOMStore" {
+		return nil, errors.New("car: not a BOM store")
+	}
+	be := binary.BigEndian
+	indexOffset, varsOffset := be.Uint32(data[16:20]), be.Uint32(data[24:28])
+
+	bom := &bomStore{data: data, vars: make(map[string]uint32)}
+
+	if int(indexOffset)+4 > len(data) {
+		return nil, errors.New("car: block table out of range")
+	}
+	count := be.Uint32(data[indexOffset:])
+	pos := int(indexOffset) + 4
+	for i := uint32(0); i < count && pos+8 <= len(data); i++ {
+		bom.blocks = append(bom.blocks, [2]uint32{be.Uint32(data[pos:]), be.Uint32(data[pos+4:])})
+		pos += 8
+	}
+
+	if int(varsOffset)+4 > len(data) {
+		return nil, errors.New("car: variables out of range")
+	}
+	count = be.Uint32(data[varsOffset:])
+	pos = int(varsOffset) + 4
+	for i := uint32(0); i < count && pos+5 <= len(data); i++ {
+		index := be.Uint32(data[pos:])
+		nameLen := int(data[pos+4])
+		if pos+5+nameLen > len(data) {
+			break
+		}
+		bom.vars[string(data[pos+5:pos+5+nameLen])] = index
+		pos += 5 + nameLen
+	}
+	return bom, nil
+}
+
+func (b *bomStore) block(index uint32) ([]byte, error) {
+	if int(index) >= len(b.blocks) {
+		return nil, fmt.Errorf("car: block %d out of range", index)
+	}
+	addr, length := b.blocks[index][0], b.blocks[index][1]
+	if uint64(addr)+uint64(length) > uint64(len(b.data)) {
+		return nil, fmt.Errorf("car: block %d extends past end of file", index)
+	}
+	return b.data[addr : addr+length], nil
+}
+
+// walkTree calls fn with the key and value of every leaf entry in a named BOM tree
+func (b *bomStore) walkTree(name string, fn func(key, value []byte)) error {
+	index, ok := b.vars[name]
+	if !ok {
+		return fmt.Errorf("car: no %s tree", name)
+	}
+	tree, err := b.block(index)
+	if err != nil {
+		return err
+	}
+	if len(tree) < 12 || string(tree[:4]) != "tree" {
+		return fmt.Errorf("car: %s is not a tree", name)
+	}
+	be := binary.BigEndian
+	node := be.Uint32(tree[8:12])
+
+	// Descend along the first child to the leftmost leaf, then follow forward links.
+	// visited guards against corrupt files whose links loop.
+	visited := make(map[uint32]bool)
+	for {
+		if visited[node] {
+			return errors.New("car: tree loop")
+		}
+		visited[node] = true
+
+		paths, err := b.block(node)
+		if err != nil {
+			return err
+		}
+		if len(paths) < 12 {
+			return errors.New("car: short tree node")
+		}
+		isLeaf := be.Uint16(paths[0:2]) != 0
+		count := int(be.Uint16(paths[2:4]))
+		forward := be.Uint32(paths[4:8])
+
+		if !isLeaf {
+			if count == 0 || len(paths) < 20 {
+				return errors.New("car: empty branch node")
+			}
+			node = be.Uint32(paths[12:16])
+			continue
+		}
+
+		for i := 0; i < count && 12+i*8+8 <= len(paths); i++ {
+			valueIndex := be.Uint32(paths[12+i*8:])
+			keyIndex := be.Uint32(paths[12+i*8+4:])
+			key, err := b.block(keyIndex)
+			if err != nil {
+				continue
+			}
+			value, err := b.block(valueIndex)
+			if err != nil {
+				continue
+			}
+			fn(key, value)
+		}
+
+		if forward == 0 {
+			return nil
+		}
+		node = forward
+	}
+}
+
+// parseAssetCatalog reads asset names and rendition headers from an Assets.car.
+// withRenditions false skips the (potentially large) rendition tree, for listing names only.
+func parseAssetCatalog(data []byte, withRenditions bool) (*AssetCatalog, error) {
+	bom, err := openBOM(data)
+	if err != nil {
+		return nil, err
+	}
+	le := binary.LittleEndian
+	catalog := &AssetCatalog{Names: make(map[string]uint16)}
+
+	// FACETKEYS: key is the asset name, value is a rendition key token:
+	// hotspot x/y (u16 each), attribute count (u16), then (name, value) u16 pairs
+	err = bom.walkTree("FACETKEYS", func(key, value []byte) {
+		if len(value) < 6 {
+			return
+		}
+		count := int(le.Uint16(value[4:6]))
+		for i := 0; i < count && 6+i*4+4 <= len(value); i++ {
+			if le.Uint16(value[6+i*4:]) == carAttrIdentifier {
+				catalog.Names[string(key)] = le.Uint16(value[6+i*4+2:])
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !withRenditions {
+		return catalog, nil
+	}
+
+	// KEYFORMAT: which attribute each u16 slot of a rendition key holds
+	identifierSlot := -1
+	if index, ok := bom.vars["KEYFORMAT"]; ok {
+		if kf, err := bom.block(index); err == nil && len(kf) >= 12 {
+			count := int(le.Uint32(kf[8:12]))
+			for i := 0; i < count && 12+i*4+4 <= len(kf); i++ {
+				if le.Uint32(kf[12+i*4:]) == carAttrIdentifier {
+					identifierSlot = i
+				}
+			}
+		}
+	}
+	if identifierSlot < 0 {
+		return nil, errors.New("car: KEYFORMAT has no identifier attribute")
+	}
+
+	err = bom.walkTree("RENDITIONS", func(key, value []byte) {
+		if len(key) < identifierSlot*2+2 || len(value) < csiHeaderSize || string(value[:4]) != "ISTC" {
+			return
+		}
+		// The TLV block between the header and the rendition data
+		tlvLength := le.Uint32(value[168:172])
+		if uint64(tlvLength) > uint64(len(value)-csiHeaderSize) {
+			return
+		}
+		name := value[40:168]
+		if i := bytes.IndexByte(name, 0); i >= 0 {
+			name = name[:i]
+		}
+		catalog.Renditions = append(catalog.Renditions, CarRendition{
+			Identifier:  le.Uint16(key[identifierSlot*2:]),
+			Width:       int(le.Uint32(value[12:16])),
+			Height:      int(le.Uint32(value[16:20])),
+			Scale:       int(le.Uint32(value[20:24])),
+			PixelFormat: reverseTag(value[24:28]),
+			Name:        string(name),
+			data:        value[csiHeaderSize+int(tlvLength):],
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return catalog, nil
+}
+
+// carAppIcon decodes the largest decodable rendition of the named icon set in a catalog
+// parsed with its renditions
+func carAppIcon(catalog *AssetCatalog, iconName string) (image.Image, *CarRendition, error) {
+	identifier, ok := catalog.Names[iconName]
+	if !ok {
+		return nil, nil, fmt.Errorf("no %q asset in Assets.car", iconName)
+	}
+
+	var candidates []CarRendition
+	for _, r := range catalog.Renditions {
+		if r.Identifier == identifier {
+			candidates = append(candidates, r)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Width*candidates[i].Height > candidates[j].Width*candidates[j].Height
+	})
+
+	for i := range candidates {
+		if img, err := candidates[i].decode(); err == nil {
+			return img, &candidates[i], nil
+		}
+	}
+	return nil, nil, fmt.Errorf("none of the %d %q renditions are in a supported format", len(candidates), iconName)
+}
+
+// decode turns a rendition's payload into an image. Supported: raw embedded images
+// (DATA/JPEG/PNG in a RAWD wrapper) and ARGB bitmaps stored uncompressed or zlib-compressed.
+func (r *CarRendition) decode() (image.Image, error) {
+	le := binary.LittleEndian
+	if len(r.data) < 12 {
+		return nil, errors.New("car: empty rendition")
+	}
+
+	switch reverseTag(r.data[:4]) {
+	case "RAWD": // tag, version, length, bytes
+		length := int(le.Uint32(r.data[8:12]))
+		if 12+length > len(r.data) {
+			return nil, errors.New("car: truncated raw rendition")
+		}
+		img, _, err := image.Decode(bytes.NewReader(r.data[12 : 12+length]))
+		if err != nil {
+			return decodePNG(r.data[12 : 12+length])
+		}
+		return img, nil
+
+	case "CELM": // tag, version, compression, length, bytes
+		if r.PixelFormat != "ARGB" || len(r.data) < 16 {
+			return nil, fmt.Errorf("car: unsupported pixel format %s", r.PixelFormat)
+		}
+		if r.Width <= 0 || r.Height <= 0 || r.Width > carMaxDimension || r.Height > carMaxDimension {
+			return nil, fmt.Errorf("car: bitmap of %dx%d", r.Width, r.Height)
+		}
+		compression := le.Uint32(r.data[8:12])
+		length := int(le.Uint32(r.data[12:16]))
+		if 16+length > len(r.data) {
+			return nil, errors.New("car: truncated bitmap rendition")
+		}
+		payload := r.data[16 : 16+length]
+
+		var pixels []byte
+		switch compression {
+		case 0:
+			pixels = payload
+		case 2:
+			zr, err := zlib.NewReader(bytes.NewReader(payload))
+			if err != nil {
+				return nil, err
+			}
+			// No more than the bitmap needs: anything past it is a bomb, not an icon
+			size := r.Width * r.Height * 4
+			if pixels, err = io.ReadAll(io.LimitReader(zr, int64(size)+1)); err != nil {
+				return nil, err
+			}
+			if len(pixels) > size {
+				return nil, errors.New("car: bitmap inflates past its dimensions")
+			}
+		default:
+			// LZVN, LZFSE, ASTC, palette... not worth a decoder here
+			return nil, fmt.Errorf("car: unsupported compression %d", compression)
+		}
+		return premultipliedBGRAImage(pixels, r.Width, r.Height)
+	}
+	return nil, fmt.Errorf("car: unsupported rendition %q", reverseTag(r.data[:4]))
+}
+
+// premultipliedBGRAImage converts CoreGraphics-style pixels to an image
+func premultipliedBGRAImage(pixels []byte, width, height int) (image.Image, error) {
+	if width <= 0 || height <= 0 || width > carMaxDimension || height > carMaxDimension || len(pixels) < width*height*4 {
+		return nil, errors.New("car: bitmap smaller than its dimensions")
+	}
+	rowBytes := len(pixels) / height // Rows may be padded
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		row := pixels[y*rowBytes:]
+		out := img.Pix[y*img.Stride:]
+		for x := 0; x < width; x++ {
+			b, g, r, a := row[x*4], row[x*4+1], row[x*4+2], row[x*4+3]
+			if a != 0 && a != 255 {
+				r = byte(min(255, int(r)*255/int(a)))
+				g = byte(min(255, int(g)*255/int(a)))
+				b = byte(min(255, int(b)*255/int(a)))
+			}
+			out[x*4], out[x*4+1], out[x*4+2], out[x*4+3] = r, g, b, a
+		}
+	}
+	return img, nil
+}
+
+// reverseTag reads a little-endian four character code: "ISTC" -> "CTSI", "BGRA" -> "ARGB"
+func reverseTag(b []byte) string {
+	return string([]byte{b[3], b[2], b[1], b[0]})
+}
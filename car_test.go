@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"testing"
+)
+
+// TestCarRenditionLimits decodes bitmap renditions that lie about their size: dimensions
+// whose pixel count overflows, and a zlib stream inflating far past them. Both fail
+// without allocating what they claim.
+func TestCarRenditionLimits(t *testing.T) {
+	if _, err := premultipliedBGRAImage(make([]byte, 16), 1<<31, 1<<31); err == nil {
+		t.Error("a 2^31x2^31 bitmap from 16 bytes decoded")
+	}
+
+	var payload bytes.Buffer
+	zw := zlib.NewWriter(&payload)
+	zw.Write(make([]byte, 8<<20))
+	zw.Close()
+	data := binary.LittleEndian.AppendUint32(nil, 0)
+	copy(data, "MLEC") // CELM, little-endian
+	data = binary.LittleEndian.AppendUint32(data, 0)
+	data = binary.LittleEndian.AppendUint32(data, 2) // zlib
+	data = binary.LittleEndian.AppendUint32(data, uint32(payload.Len()))
+	data = append(data, payload.Bytes()...)
+	r := CarRendition{Width: 4, Height: 4, PixelFormat: "ARGB", data: data}
+	if _, err := r.decode(); err == nil {
+		t.Error("a 4x4 bitmap inflating to 8 MiB decoded")
+	}
+	r.Width, r.Height = 1<<20, 1<<20
+	if _, err := r.decode(); err == nil {
+		t.Error("a 2^20x2^20 bitmap decoded")
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// --- CI: annotations, a step summary and outputs for GitHub Actions ---
+// --ci github speaks the workflow commands Actions reads from a step's output: each warning
+// becomes a ::warning:: annotation (::error:: for error-severity codes), with file= when it's
+// about a bundle path, and a failed run ends with an ::error:: titled with its RESULT code.
+// When the runner sets them, $GITHUB_STEP_SUMMARY gets the conversion as a Markdown table,
+// and $GITHUB_OUTPUT the ipa, bundle-id, version and sha256 outputs for later steps.
+// --ci auto does all that only when GITHUB_ACTIONS is "true". Without --ci nothing changes,
+// whatever the environment.
+
+// Values of --ci
+const (
+	CIGitHub = "github" // GitHub Actions workflow commands
+	CIAuto   = "auto"   // CIGitHub when running in GitHub Actions, otherwise nothing
+)
+
+// ci is the run's CI integration, nil (doing nothing) without --ci
+var ci *ciOutput
+
+// ciOutput writes workflow commands to w and appends to the runner's files
+type ciOutput struct {
+	w           io.Writer
+	summaryPath string // $GITHUB_STEP_SUMMARY; "" leaves the summary out
+	outputPath  string // $GITHUB_OUTPUT; "" leaves the outputs out
+}
+
+// newCIOutput returns the integration --ci mode asks for, nil when there's none; getenv
+// is os.Getenv outside tests
+func newCIOutput(mode string, w io.Writer, getenv func(string) string) (*ciOutput, error) {
+	switch mode {
+	case "":
+		return nil, nil
+	case CIAuto:
+		if getenv("GITHUB_ACTIONS") != "true" {
+			return nil, nil
+		}
+	case CIGitHub:
+	default:
+		return nil, fmt.Errorf("unknown --ci %q (want github or auto)", mode)
+	}
+	return &ciOutput{w: w, summaryPath: getenv("GITHUB_STEP_SUMMARY"), outputPath: getenv("GITHUB_OUTPUT")}, nil
+}
+
+// annotate writes a workflow command per warning
+func (c *ciOutput) annotate(warnings []Warning) {
+	if c == nil {
+		return
+	}
+	for _, w := range warnings {
+		command := "warning"
+		if w.Severity == SeverityError {
+			command = "error"
+		}
+		properties := "title=" + ciProperty(w.Code)
+		if w.Path != "" {
+			properties = "file=" + ciProperty(w.Path) + "," + properties
+		}
+		fmt.Fprintf(c.w, "::%s %s::%s\n", command, properties, ciData(terminalSafe(w.Message)))
+	}
+}
+
+// fail annotates a failed run and notes it in the step summary
+func (c *ciOutput) fail(code string, err error) {
+	if c == nil {
+		return
+	}
+	fmt.Fprintf(c.w, "::error title=%s::%s\n", ciProperty("deb-to-ipa: "+code), ciData(terminalSafe(err.Error())))
+	if c.summaryPath != "" {
+		summary := fmt.Sprintf("### ❌ DebToIPA: conversion failed (%s)\n\n%s\n\n", ciCell(code), ciCell(err.Error()))
+		if err := appendFile(c.summaryPath, summary); err != nil {
+			fmt.Fprintf(c.w, "::warning::%s\n", ciData("$GITHUB_STEP_SUMMARY: "+err.Error()))
+		}
+	}
+}
+
+// finish writes a successful run's step summary and outputs
+func (c *ciOutput) finish(input string, result *Result) error {
+	if c == nil || (c.summaryPath == "" && c.outputPath == "") {
+		return nil
+	}
+	size, sha := int64(0), ""
+	if result.OutputPath != "" {
+		var err error
+		if size, sha, err = fileSHA256(result.OutputPath); err != nil {
+			return fmt.Errorf("hashing the IPA: %w", err)
+		}
+	}
+	if c.summaryPath != "" {
+		if err := appendFile(c.summaryPath, ciSummary(input, result, size, sha)); err != nil {
+			return fmt.Errorf("$GITHUB_STEP_SUMMARY: %w", err)
+		}
+	}
+	if c.outputPath != "" {
+		var b strings.Builder
+		for _, output := range [][2]string{{"ipa", result.OutputPath}, {"bundle-id", result.BundleID}, {"version", result.Version}, {"sha256", sha}} {
+			b.WriteString(ciOutputLine(output[0], output[1]))
+		}
+		if err := appendFile(c.outputPath, b.String()); err != nil {
+			return fmt.Errorf("$GITHUB_OUTPUT: %w", err)
+		}
+	}
+	return nil
+}
+
+// ciSummary is the Markdown for a successful run: the conversion, then its warnings
+func ciSummary(input string, result *Result, size int64, sha string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "### ✅ DebToIPA: %s\n\n| | |\n|---|---|\n", ciCell(result.AppName))
+	rows := [][2]string{
+		{"Input", input},
+		{"IPA", result.OutputPath},
+		{"Bundle ID", result.BundleID},
+		{"Version", result.Version},
+		{"Executable", result.Executable},
+		{"Status", valueOr(result.Status, ResultStatusOK)},
+	}
+	if result.OutputPath != "" {
+		rows = append(rows, [2]string{"Size", formatBytes(size)}, [2]string{"SHA256", sha})
+	}
+	for _, row := range rows {
+		if row[1] != "" {
+			fmt.Fprintf(&b, "| %s | `%s` |\n", row[0], ciCell(row[1]))
+		}
+	}
+	if len(result.Warnings) > 0 {
+		fmt.Fprintf(&b, "\n#### ⚠️ Warnings (%d)\n\n| Severity | Code | Path | Message |\n|---|---|---|---|\n", len(result.Warnings))
+		for _, w := range result.Warnings {
+			fmt.Fprintf(&b, "| %s | `%s` | %s | %s |\n", w.Severity, ciCell(w.Code), ciCell(w.Path), ciCell(w.Message))
+		}
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// ciOutputLine is one $GITHUB_OUTPUT entry; a value with a line break goes between
+// delimiter lines, the delimiter random so the value can't end it early
+func ciOutputLine(name, value string) string {
+	if !strings.ContainsAny(value, "\r\n") {
+		return name + "=" + value + "\n"
+	}
+	random := make([]byte, 8)
+	rand.Read(random)
+	delimiter := "ghadelimiter_" + hex.EncodeToString(random)
+	return name + "<<" + delimiter + "\n" + value + "\n" + delimiter + "\n"
+}
+
+// ciData escapes a workflow command's message
+func ciData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// ciProperty escapes a workflow command property's value
+func ciProperty(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
+
+// ciCell makes a value safe inside a Markdown table cell
+func ciCell(s string) string {
+	return strings.NewReplacer("|", "\\|", "`", "'", "\r", " ", "\n", " ").Replace(terminalSafe(s))
+}
+
+// appendFile appends s to the file at name, creating it if need be
+func appendFile(name, s string) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(s); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
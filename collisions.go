@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// --- Path collisions: entries that land on the same path somewhere ---
+// iOS file systems are case-sensitive, but macOS and Windows disks usually aren't, so
+// "Icon.png" and "icon.png" extract as one file there and the signature no longer matches
+// the bundle. Checked on the final archive names, just before writing.
+
+// Kinds of PathCollision
+const (
+	CollisionDuplicate = "duplicate" // The same name twice; the later entry wins, as when extracting a tar
+	CollisionCase      = "case"      // Names differing only in case
+	CollisionFileDir   = "file-dir"  // A file where another entry needs a directory, ignoring case
+)
+
+// PathCollision is an entry that collides with another
+type PathCollision struct {
+	Path    string `json:"path"`    // Archive entry name of the entry left out, or that would be
+	Other   string `json:"other"`   // The entry it collides with, which is kept
+	Kind    string `json:"kind"`    // One of the Collision* constants
+	Dropped bool   `json:"dropped"` // Left out of the output; directories colliding by case stay
+}
+
+// checkPathCollisions finds duplicate, case-insensitive and file-vs-directory collisions
+// among the entries' archive names. Exact duplicates are always resolved, keeping the later
+// entry. The others are an error unless allow is set; then the later of two case-colliding
+// files is kept, and a file in the way of a directory is left out.
+func checkPathCollisions(entries []BundleEntry, layout, appNameFolder string, allow bool, warnings *warningLog) ([]BundleEntry, []PathCollision, error) {
+	names := make([]string, len(entries))
+	last := make(map[string]int)
+	for i, entry := range entries {
+		names[i] = zipEntryName(layout, appNameFolder, entry.RelPath)
+		if names[i] != "" {
+			last[names[i]] = i
+		}
+	}
+
+	var collisions []PathCollision
+	drop := make([]bool, len(entries))
+	for i, name := range names {
+		if name == "" || last[name] == i {
+			continue
+		}
+		drop[i] = true
+		// The same directory twice is merely redundant
+		if !entries[i].File.IsDir || !entries[last[name]].File.IsDir {
+			collisions = append(collisions, PathCollision{Path: name, Other: name, Kind: CollisionDuplicate, Dropped: true})
+		}
+	}
+
+	// Case: group what's left by lowercased name, the last of each group being kept
+	folded := make(map[string]int)
+	for i, name := range names {
+		if name != "" && !drop[i] {
+			folded[strings.ToLower(name)] = i
+		}
+	}
+	for i, name := range names {
+		if name == "" || drop[i] {
+			continue
+		}
+		kept := folded[strings.ToLower(name)]
+		if kept == i {
+			continue
+		}
+		bothDirs := entries[i].File.IsDir && entries[kept].File.IsDir
+		collisions = append(collisions, PathCollision{Path: name, Other: names[kept], Kind: CollisionCase, Dropped: !bothDirs})
+		drop[i] = !bothDirs
+	}
+
+	// File vs directory: every parent of a remaining entry must not be a file
+	parents := make(map[string]string)
+	for i, name := range names {
+		if name == "" || drop[i] {
+			continue
+		}
+		for dir := path.Dir(strings.TrimSuffix(name, "/")); dir != "."; dir = path.Dir(dir) {
+			parents[strings.ToLower(dir)] = name
+		}
+	}
+	for i, name := range names {
+		if name == "" || drop[i] || entries[i].File.IsDir {
+			continue
+		}
+		if child, ok := parents[strings.ToLower(name)]; ok {
+			collisions = append(collisions, PathCollision{Path: name, Other: child, Kind: CollisionFileDir, Dropped: true})
+			drop[i] = true
+		}
+	}
+
+	if len(collisions) == 0 {
+		return entries, nil, nil
+	}
+
+	var conflicts []string
+	for _, c := range collisions {
+		if c.Kind != CollisionDuplicate {
+			conflicts = append(conflicts, c.Path+" vs "+c.Other)
+		}
+	}
+	if len(conflicts) > 0 && !allow {
+		if len(conflicts) > 10 {
+			conflicts = append(conflicts[:10], fmt.Sprintf("and %d more", len(conflicts)-10))
+		}
+		return nil, nil, fmt.Errorf("paths that collide on case-insensitive file systems (use --allow-case-collisions to keep one of each): %s",
+			strings.Join(conflicts, "; "))
+	}
+
+	for _, c := range collisions {
+		switch {
+		case c.Kind == CollisionDuplicate:
+			warnings.add("duplicate-entry", c.Path, "%s appears more than once in the deb; kept the last copy", c.Path)
+		case c.Kind == CollisionFileDir:
+			warnings.add("path-collision", c.Path, "Left out file %s, which is in the way of %s", c.Path, c.Other)
+		case c.Dropped:
+			warnings.add("path-collision", c.Path, "Left out %s, which differs from %s only in case", c.Path, c.Other)
+		default:
+			warnings.add("path-collision", c.Path, "Directories %s and %s differ only in case and merge on macOS and Windows", c.Path, c.Other)
+		}
+	}
+
+	kept := entries[:0]
+	for i, entry := range entries {
+		if !drop[i] {
+			kept = append(kept, entry)
+		}
+	}
+	return kept, collisions, nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"strings"
+)
+
+// --- Compression method: Stored or Deflated, per entry ---
+// This is the storage policy, decided apart from the permission policy (see Mode
+// laundering): an executable isn't necessarily stored, nor a stored file executable.
+// The main executable is stored, and so is any other Mach-O file of at least
+// --store-macho-min (1 MiB by default): framework binaries and big dylibs barely deflate
+// and signing tools read them over and over. Smaller dylibs and plug-in binaries are
+// deflated like everything else. Signing tools and installers are sometimes picky about
+// which is which, so --store-glob and --deflate-glob override that for matching bundle
+// paths, with the same glob syntax as --exclude. When both match an entry, the flag given
+// last wins. Symlinks and directories are always stored.
+
+// defaultStoreMachOMin is --store-macho-min's default
+const defaultStoreMachOMin = 1 << 20
+
+// methodGlob is one --store-glob or --deflate-glob, in command-line order
+type methodGlob struct {
+	Pattern string
+	Method  uint16 // zip.Store or zip.Deflate
+}
+
+// methodGlobFlag appends to the shared list with its method, so the two flags interleave
+type methodGlobFlag struct {
+	globs  *[]methodGlob
+	method uint16
+}
+
+func (f methodGlobFlag) String() string {
+	if f.globs == nil {
+		return ""
+	}
+	var patterns []string
+	for _, g := range *f.globs {
+		if g.Method == f.method {
+			patterns = append(patterns, g.Pattern)
+		}
+	}
+	return strings.Join(patterns, ",")
+}
+
+func (f methodGlobFlag) Set(v string) error {
+	*f.globs = append(*f.globs, methodGlob{Pattern: v, Method: f.method})
+	return nil
+}
+
+// methodRule is a compiled methodGlob
+type methodRule struct {
+	rule   *globRule
+	method uint16
+}
+
+// MethodOverrides picks the compression method for bundle paths matching a glob, and for
+// Mach-O files by size. A nil *MethodOverrides overrides nothing.
+type MethodOverrides struct {
+	rules         []methodRule
+	storeMachOMin int64 // Mach-O files this big or bigger are stored; 0 for none
+}
+
+// newMethodOverrides compiles the globs; nil when there are none and no Mach-O size
+func newMethodOverrides(globs []methodGlob, storeMachOMin int64) (*MethodOverrides, error) {
+	if len(globs) == 0 && storeMachOMin <= 0 {
+		return nil, nil
+	}
+	o := &MethodOverrides{storeMachOMin: max(storeMachOMin, 0)}
+	for _, g := range globs {
+		rule, err := compileGlob(g.Pattern, methodFlagName(g.Method))
+		if err != nil {
+			return nil, err
+		}
+		o.rules = append(o.rules, methodRule{rule: rule, method: g.Method})
+	}
+	return o, nil
+}
+
+// method returns the method for relPath and the glob that chose it, nil when none matches.
+// Later globs are tried first, so the last one given wins.
+func (o *MethodOverrides) method(relPath string) (uint16, *globRule) {
+	if o == nil || relPath == "" {
+		return 0, nil
+	}
+	segments := strings.Split(relPath, "/")
+	for i := len(o.rules) - 1; i >= 0; i-- {
+		r := o.rules[i]
+		if matchRules([]*globRule{r.rule}, segments) != nil {
+			r.rule.Matches++
+			return r.method, r.rule
+		}
+	}
+	return 0, nil
+}
+
+// storesMachO reports whether a file is stored for being a Mach-O binary of at least
+// --store-macho-min; only files that big have their header read
+func (o *MethodOverrides) storesMachO(vf *VirtualFile) bool {
+	if o == nil || o.storeMachOMin == 0 || vf.IsDir || vf.IsLink || vf.Size < o.storeMachOMin {
+		return false
+	}
+	return sniffMachO(vf)
+}
+
+// printSummary reports what each glob matched and warns about globs that matched nothing
+func (o *MethodOverrides) printSummary(warnings *warningLog) {
+	if o == nil {
+		return
+	}
+	for _, r := range o.rules {
+		if r.rule.Matches == 0 {
+			warnings.add("method-glob-unmatched", "", "--%s %q matched nothing", r.rule.Flag, r.rule.Pattern)
+			continue
+		}
+		fmt.Printf("   %s %d entr%s matching %q\n", map[uint16]string{zip.Store: "Stored", zip.Deflate: "Deflated"}[r.method],
+			r.rule.Matches, map[bool]string{true: "y", false: "ies"}[r.rule.Matches == 1], r.rule.Pattern)
+	}
+}
+
+// methodFlagName is the flag that asks for method
+func methodFlagName(method uint16) string {
+	if method == zip.Store {
+		return "store-glob"
+	}
+	return "deflate-glob"
+}
+
+// methodName names a zip compression method for manifests and verbose output
+func methodName(method uint16) string {
+	switch method {
+	case zip.Store:
+		return "store"
+	case zip.Deflate:
+		return "deflate"
+	}
+	return fmt.Sprintf("method %d", method)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMethodOverrides(t *testing.T) {
+	var none *MethodOverrides
+	if method, rule := none.method("Fixture"); method != 0 || rule != nil {
+		t.Errorf("nil overrides chose %d", method)
+	}
+	o, err := newMethodOverrides([]methodGlob{
+		{"**/*.png", zip.Store},
+		{"Assets/**", zip.Deflate},
+		{"Assets/keep.png", zip.Store},
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for relPath, want := range map[string]uint16{
+		"icon.png":        zip.Store,   // Only the first glob matches
+		"Assets/a.png":    zip.Deflate, // Both: the later one wins
+		"Assets/keep.png": zip.Store,   // All three: the last wins
+		"Assets/a.car":    zip.Deflate,
+		"Info.plist":      0,
+	} {
+		if got, _ := o.method(relPath); got != want {
+			t.Errorf("%s: %s, want %s", relPath, methodName(got), methodName(want))
+		}
+	}
+	if _, err := newMethodOverrides([]methodGlob{{"[", zip.Store}}, 0); err == nil {
+		t.Error("bad pattern accepted")
+	}
+}
+
+// TestConvertMethodGlobs checks the method in the central directory, and the manifest's
+// record of it, for entries the globs override and those they leave to the defaults
+func TestConvertMethodGlobs(t *testing.T) {
+	framework := "Frameworks/Fixture.framework/"
+	opts := Options{
+		StoreMachOMin: defaultStoreMachOMin,
+		Manifest:      true,
+		MethodGlobs: []methodGlob{
+			{"Fixture", zip.Deflate}, // The main executable, stored by default
+			{"*.dylib", zip.Store},   // Overridden by the next
+			{"*.dylib", zip.Deflate},
+			{framework + "**", zip.Deflate}, // Overridden for Info.plist by the next
+			{framework + "Info.plist", zip.Store},
+		},
+	}
+	result, zr := convertFixture(t, FixtureSpec{Framework: true, FrameworkPad: 2 << 20, Dylib: true}, opts)
+	entries := zipEntries(zr)
+	want := map[string]uint16{
+		"Fixture":                zip.Deflate,
+		"libFixture.dylib":       zip.Deflate,
+		framework + "Fixture":    zip.Deflate, // Over --store-macho-min, but a glob says deflate
+		framework + "Info.plist": zip.Store,
+		"Info.plist":             zip.Deflate, // No glob: the default
+	}
+	for relPath, method := range want {
+		if f := entries[fixtureApp+relPath]; f == nil || f.Method != method {
+			t.Errorf("%s: %v, want %s", relPath, f, methodName(method))
+		}
+	}
+	if result.Manifest == nil {
+		t.Fatal("no manifest")
+	}
+	for _, e := range result.Manifest.Entries {
+		if f := entries[e.Name]; f == nil || e.Method != methodName(f.Method) {
+			t.Errorf("manifest records %s as %s, the archive has %v", e.Name, e.Method, f)
+		}
+		delete(want, e.Path)
+	}
+	if len(want) > 0 {
+		t.Errorf("not in the manifest: %v", want)
+	}
+}
+
+// truncatingWriter empties path on the first write it sees
+type truncatingWriter struct{ path string }
+
+func (w truncatingWriter) Write(p []byte) (int, error) {
+	return len(p), os.Truncate(w.path, 0)
+}
+
+// TestStoreSourceChanged stores a file that's cut short between the checksum pass and the
+// copy, as an --add source edited mid-run would be: the entry fails instead of carrying a
+// CRC and sizes for other data
+func TestStoreSourceChanged(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "added.bin")
+	if err := os.WriteFile(source, make([]byte, 256<<10), 0644); err != nil {
+		t.Fatal(err)
+	}
+	vf := &VirtualFile{Name: source, DiskPath: source, Size: 256 << 10, Mode: 0644}
+	zw := zip.NewWriter(io.Discard)
+	header := &zip.FileHeader{Name: "Payload/Fixture.app/added.bin", Method: zip.Store}
+	err := writeZipFile(zw, header, vf, t.TempDir(), truncatingWriter{source})
+	if err == nil || !strings.Contains(err.Error(), "changed while it was being written") {
+		t.Errorf("writing a source cut short mid-copy: %v, want it reported as changed", err)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/schollz/progressbar/v3"
+)
+
+// --- Console output: status lines are redrawn in place only where that works ---
+// Progress bars and the "scanned" counter rewrite their line with \r and ANSI codes, which
+// garbles piped output, CI logs and Windows consoles without VT processing. Those get
+// plain lines instead.
+
+// stdoutRewrites and stderrRewrites tell whether each stream can redraw a line in place
+var (
+	stdoutRewrites = canRewrite(os.Stdout)
+	stderrRewrites = canRewrite(os.Stderr)
+)
+
+// jsonOut is stdout once stdoutForJSON has moved everything else to stderr; nil until then
+var jsonOut *os.File
+
+// stdoutForJSON keeps stdout for --json's document, sending everything else printed to
+// stderr from here on, and returns what undoes that
+func stdoutForJSON() (restore func()) {
+	jsonOut, os.Stdout = os.Stdout, os.Stderr
+	rewrites := stdoutRewrites
+	stdoutRewrites = stderrRewrites
+	return func() { os.Stdout, jsonOut, stdoutRewrites = jsonOut, nil, rewrites }
+}
+
+// jsonStdout is where --json output goes: stdout, even once the rest has moved to stderr
+func jsonStdout() *os.File {
+	if jsonOut != nil {
+		return jsonOut
+	}
+	return os.Stdout
+}
+
+// newProgressBar is a byte progress bar on stderr, or where stderr can't redraw it, a
+// single line announcing the work and a bar that draws nothing
+func newProgressBar(total int64, description string) *progressbar.ProgressBar {
+	if stderrRewrites {
+		return progressbar.DefaultBytes(total, description)
+	}
+	fmt.Fprintf(os.Stderr, "%s (%s)...\n", description, formatBytes(total))
+	return progressbar.DefaultBytesSilent(total, description)
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/term"
+)
+
+// canRewrite reports whether f is a terminal, which handles \r and ANSI codes
+func canRewrite(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
+}
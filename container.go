@@ -0,0 +1,83 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// containerPattern matches an app dumped from a device's bundle container, e.g.
+// "./private/var/containers/Bundle/Application/<UUID>/Foo.app/". The UUID folder also
+// holds iTunesMetadata.plist and BundleMetadata.plist next to the app.
+var containerPattern = regexp.MustCompile(`^(?:\./)?(?:private/)?var/containers/Bundle/Application/([0-9A-Fa-f]{8}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{12})/[^/]+\.app/$`)
+
+// containerDir returns the container folder ("…/Application/<UUID>/") and its UUID when
+// the app sits in a bundle container
+func containerDir(appDirPrefix string) (dir, uuid string, ok bool) {
+	m := containerPattern.FindStringSubmatch(appDirPrefix)
+	if m == nil {
+		return "", "", false
+	}
+	// Trimmed rather than path.Dir'd, so it stays a prefix of the tar names
+	return strings.TrimSuffix(appDirPrefix, path.Base(appDirPrefix)+"/"), m[1], true
+}
+
+// containerMetadata finds the iTunesMetadata.plist beside a containerized app
+func containerMetadata(files []*VirtualFile, dir string) *VirtualFile {
+	for _, vf := range files {
+		if vf.Name == dir+"iTunesMetadata.plist" && !vf.IsDir && !vf.IsLink {
+			return vf
+		}
+	}
+	return nil
+}
+
+// applyContainerOverrides writes --bundle-id, --app-version and --build-number into the
+// container's iTunesMetadata.plist, whose copies of them would otherwise describe the app
+// as it was before the overrides
+func applyContainerOverrides(vf *VirtualFile, opts Options, store *SpillStore) error {
+	values := make(map[string]any)
+	for _, o := range []struct{ key, value string }{
+		{"softwareVersionBundleId", opts.BundleID},
+		{"bundleShortVersionString", opts.AppVersion},
+		{"bundleVersion", opts.BuildNumber},
+	} {
+		if o.value != "" {
+			values[o.key] = o.value
+		}
+	}
+	if vf == nil || len(values) == 0 {
+		return nil
+	}
+	data, err := readAll(vf)
+	if err != nil {
+		return err
+	}
+	if data, err = setPlistKeys("iTunesMetadata.plist", data, values); err != nil {
+		return fmt.Errorf("updating iTunesMetadata.plist: %w", err)
+	}
+	return store.Replace(vf, data)
+}
+
+// writeContainerMetadata copies a container's iTunesMetadata.plist to the archive root,
+// where App Store IPAs keep it
+func writeContainerMetadata(zw *zip.Writer, vf *VirtualFile, manifest *Manifest) error {
+	header := &zip.FileHeader{Name: "iTunesMetadata.plist", Method: zip.Deflate, Modified: vf.ModTime}
+	header.SetMode(0644)
+	header.ExternalAttrs = (0x8000 | 0644) << 16
+	if err := writeZipFile(zw, header, vf, "", nil); err != nil {
+		return fmt.Errorf("writing iTunesMetadata.plist: %w", err)
+	}
+	if manifest != nil {
+		data, err := readAll(vf)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		manifest.add("", header, sum[:])
+	}
+	return nil
+}
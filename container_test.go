@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+// TestContainerOverrides sets the flags' values in a kept iTunesMetadata.plist, keeping
+// the keys no flag touches
+func TestContainerOverrides(t *testing.T) {
+	vf := &VirtualFile{Name: "iTunesMetadata.plist", Data: []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0"><dict>
+<key>softwareVersionBundleId</key><string>com.example.fixture</string>
+<key>bundleShortVersionString</key><string>1.0</string>
+<key>bundleVersion</key><string>1</string>
+<key>itemName</key><string>Fixture</string>
+</dict></plist>`)}
+	vf.Size = int64(len(vf.Data))
+	store := &SpillStore{Dir: t.TempDir()}
+	if err := applyContainerOverrides(vf, Options{BundleID: "com.example.other", AppVersion: "2.0"}, store); err != nil {
+		t.Fatal(err)
+	}
+	data, err := readAll(vf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{
+		"softwareVersionBundleId":  "com.example.other",
+		"bundleShortVersionString": "2.0",
+		"bundleVersion":            "1",
+		"itemName":                 "Fixture",
+	} {
+		if got := plistValue(data, key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// readControl extracts and parses the "control" file from a deb's control.tar member
+func readControl(name string, r io.Reader) (map[string]string, error) {
+	decompressed, err := decompress(name, r)
+	if err != nil {
+		return nil, err
+	}
+
+	tarReader := tar.NewReader(decompressed)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("control file not found in %s", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag == tar.TypeReg && path.Clean(header.Name) == "control" {
+			data, err := io.ReadAll(tarReader)
+			if err != nil {
+				return nil, err
+			}
+			return parseControl(data), nil
+		}
+	}
+}
+
+// parseControl parses a Debian control stanza. Continuation lines (leading whitespace)
+// are joined onto the previous field, with " ." standing for an empty line.
+func parseControl(data []byte) map[string]string {
+	fields := make(map[string]string)
+	var current string
+
+	for _, line := range strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n") {
+		if line == "" {
+			// A blank line ends the first (and for debs, only) stanza
+			if len(fields) > 0 {
+				break
+			}
+			continue
+		}
+
+		if line[0] == ' ' || line[0] == '\t' {
+			if current == "" {
+				continue
+			}
+			cont := strings.TrimSpace(line)
+			if cont == "." {
+				cont = ""
+			}
+			fields[current] += "\n" + cont
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		current = strings.TrimSpace(key)
+		fields[current] = strings.TrimSpace(value)
+	}
+
+	return fields
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// --- Debug info: .dSYM folders and debug segments in binaries ---
+// Neither runs on the device. A .dSYM bundle is left out whole with --strip-debug; DWARF
+// inside a Mach-O is only measured, and bitcode is removed by --strip-bitcode (see bitcode.go).
+
+// Kinds of DebugContent
+const (
+	DebugDSYM    = "dsym"    // A .dSYM folder
+	DebugDWARF   = "dwarf"   // __DWARF segment sections in a binary
+	DebugBitcode = "bitcode" // The __LLVM segment embedded bitcode lives in
+)
+
+// DebugContent is debug info found in the bundle
+type DebugContent struct {
+	Path    string `json:"path"`    // The .dSYM folder, or the binary
+	Kind    string `json:"kind"`    // One of the Debug* constants
+	Bytes   int64  `json:"bytes"`   // Files in the folder, or the segment's sections across slices
+	Removed bool   `json:"removed"` // Left out by --strip-debug
+}
+
+// DebugResult is what findDebugContent found and --strip-debug removed
+type DebugResult struct {
+	Found        []DebugContent `json:"found"`
+	RemovedBytes int64          `json:"removedBytes,omitempty"`
+}
+
+// dsymDir returns the outermost .dSYM folder a path is in (or is)
+func dsymDir(relPath string) (string, bool) {
+	segments := strings.Split(relPath, "/")
+	for i, s := range segments {
+		if strings.HasSuffix(s, ".dSYM") {
+			return strings.Join(segments[:i+1], "/"), true
+		}
+	}
+	return "", false
+}
+
+// findDebugContent measures the .dSYM folders, and with scanBinaries the DWARF and
+// bitcode segments of every Mach-O outside them
+func findDebugContent(entries []BundleEntry, scanBinaries bool) *DebugResult {
+	dsyms := make(map[string]int64)
+	var found []DebugContent
+	for _, entry := range entries {
+		vf := entry.File
+		if dir, ok := dsymDir(entry.RelPath); ok {
+			if _, seen := dsyms[dir]; !seen {
+				found = append(found, DebugContent{Path: dir, Kind: DebugDSYM})
+				dsyms[dir] = 0
+			}
+			if !vf.IsDir && !vf.IsLink {
+				dsyms[dir] += vf.Size
+			}
+			continue
+		}
+		if !scanBinaries || vf.IsDir || vf.IsLink || !sniffMachO(vf) {
+			continue
+		}
+
+		dwarf, bitcode := debugSegmentSizes(vf)
+		if dwarf > 0 {
+			found = append(found, DebugContent{Path: entry.RelPath, Kind: DebugDWARF, Bytes: dwarf})
+		}
+		if bitcode > 0 {
+			found = append(found, DebugContent{Path: entry.RelPath, Kind: DebugBitcode, Bytes: bitcode})
+		}
+	}
+	if len(found) == 0 {
+		return nil
+	}
+	for i := range found {
+		if found[i].Kind == DebugDSYM {
+			found[i].Bytes = dsyms[found[i].Path]
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool { return found[i].Bytes > found[j].Bytes })
+	return &DebugResult{Found: found}
+}
+
+// debugSegmentSizes sums the sections of the __DWARF and __LLVM segments across a binary's
+// slices; zero for files that don't parse
+func debugSegmentSizes(vf *VirtualFile) (dwarf, bitcode int64) {
+	r, done, err := readerAt(vf)
+	if err != nil {
+		return 0, 0
+	}
+	defer done()
+	slices, err := machoSlices(r)
+	if err != nil {
+		return 0, 0
+	}
+	for _, f := range slices {
+		for _, s := range f.Sections {
+			switch s.Seg {
+			case "__DWARF":
+				dwarf += int64(s.Size)
+			case "__LLVM":
+				bitcode += int64(s.Size)
+			}
+		}
+	}
+	return dwarf, bitcode
+}
+
+// stripDebug leaves out the .dSYM folders found, marking them removed
+func stripDebug(entries []BundleEntry, debug *DebugResult) []BundleEntry {
+	strip := false
+	for i := range debug.Found {
+		if debug.Found[i].Kind == DebugDSYM {
+			debug.Found[i].Removed = true
+			debug.RemovedBytes += debug.Found[i].Bytes
+			strip = true
+		}
+	}
+	if !strip {
+		return entries
+	}
+	kept := entries[:0]
+	for _, entry := range entries {
+		if _, ok := dsymDir(entry.RelPath); !ok {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
+
+// printDebugContent lists the debug info found and what --strip-debug did with it
+func printDebugContent(debug *DebugResult, strip bool, warnings *warningLog) {
+	if debug == nil {
+		return
+	}
+	removed := 0
+	for _, d := range debug.Found {
+		switch {
+		case d.Removed:
+			removed++
+			fmt.Printf("   Removed debug symbols %s (%s)\n", d.Path, formatBytes(d.Bytes))
+		case strip && d.Kind == DebugDWARF:
+			warnings.add("debug-not-stripped", d.Path, "%s carries %s of DWARF in its __DWARF segment, which --strip-debug can't remove; run strip -S on it before packaging",
+				d.Path, formatBytes(d.Bytes))
+		case d.Kind == DebugDSYM:
+			fmt.Printf("   Debug symbols %s (%s); --strip-debug leaves them out\n", d.Path, formatBytes(d.Bytes))
+		case d.Kind == DebugDWARF:
+			fmt.Printf("   %s carries %s of DWARF debug info\n", d.Path, formatBytes(d.Bytes))
+		case d.Kind == DebugBitcode:
+			fmt.Printf("   %s carries %s of embedded bitcode\n", d.Path, formatBytes(d.Bytes))
+		}
+	}
+	if removed > 0 {
+		fmt.Printf("   Stripped %d .dSYM folder(s), saving %s\n", removed, formatBytes(debug.RemovedBytes))
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Dedupe modes for --dedupe
+const (
+	DedupeReport = "report" // List duplicate sets and potential savings
+	DedupeLink   = "link"   // Also replace duplicates with relative symlinks to one copy
+)
+
+// dedupeFlag is --dedupe: bare it means "report", --dedupe=link replaces duplicates
+type dedupeFlag string
+
+func (d *dedupeFlag) String() string   { return string(*d) }
+func (d *dedupeFlag) IsBoolFlag() bool { return true }
+func (d *dedupeFlag) Set(v string) error {
+	switch v {
+	case "true", DedupeReport:
+		*d = DedupeReport
+	case "false":
+		*d = ""
+	case DedupeLink:
+		*d = DedupeLink
+	default:
+		return fmt.Errorf("want --dedupe, --dedupe=report or --dedupe=link")
+	}
+	return nil
+}
+
+// DedupeResult describes the byte-identical files found in the bundle
+type DedupeResult struct {
+	Sets             []DuplicateSet `json:"sets"`
+	PotentialSavings int64          `json:"potentialSavings"`
+	ActualSavings    int64          `json:"actualSavings"` // Non-zero only with --dedupe=link
+}
+
+// dedupeEntries hashes regular files that share a size with another file and groups identical ones.
+// In link mode every copy but the first (by path) becomes a relative symlink to it, except
+// that the main executable, when it's one of them, is the copy the others point at, and
+// Mach-O files are never linked: dyld and codesign want them to be real files.
+func dedupeEntries(entries []BundleEntry, mode, executableName string) (*DedupeResult, error) {
+	// Only files whose size collides can be duplicates, so most files are never hashed
+	bySize := make(map[int64][]int)
+	for i, entry := range entries {
+		vf := entry.File
+		if vf.IsDir || vf.IsLink || vf.Size == 0 {
+			continue
+		}
+		bySize[vf.Size] = append(bySize[vf.Size], i)
+	}
+
+	result := &DedupeResult{}
+	for size, indexes := range bySize {
+		if len(indexes) < 2 {
+			continue
+		}
+
+		byHash := make(map[string][]int)
+		for _, i := range indexes {
+			sum, err := hashFile(entries[i].File)
+			if err != nil {
+				return nil, err
+			}
+			byHash[sum] = append(byHash[sum], i)
+		}
+
+		for sum, group := range byHash {
+			if len(group) < 2 {
+				continue
+			}
+			sort.Slice(group, func(a, b int) bool { return entries[group[a]].RelPath < entries[group[b]].RelPath })
+
+			set := DuplicateSet{SHA256: sum, Size: size}
+			for _, i := range group {
+				set.Paths = append(set.Paths, entries[i].RelPath)
+			}
+			result.Sets = append(result.Sets, set)
+			result.PotentialSavings += size * int64(len(group)-1)
+
+			if mode != DedupeLink || sniffMachO(entries[group[0]].File) {
+				continue
+			}
+			canonical := entries[group[0]].RelPath
+			for _, i := range group {
+				if entries[i].RelPath == executableName {
+					canonical = executableName
+				}
+			}
+			for _, i := range group {
+				if entries[i].RelPath == canonical {
+					continue
+				}
+				vf := entries[i].File
+				entries[i].File = &VirtualFile{
+					Name:     vf.Name,
+					Mode:     0777,
+					ModTime:  vf.ModTime,
+					IsLink:   true,
+					LinkDest: relativeLink(entries[i].RelPath, canonical),
+				}
+				result.ActualSavings += size
+			}
+		}
+	}
+
+	sort.Slice(result.Sets, func(i, j int) bool {
+		return result.Sets[i].Size*int64(len(result.Sets[i].Paths)) > result.Sets[j].Size*int64(len(result.Sets[j].Paths))
+	})
+	return result, nil
+}
+
+// relativeLink returns the symlink target that makes from (a bundle-relative path) point at to
+func relativeLink(from, to string) string {
+	fromDir := strings.Split(path.Dir(from), "/")
+	toParts := strings.Split(to, "/")
+	if fromDir[0] == "." {
+		fromDir = nil
+	}
+
+	common := 0
+	for common < len(fromDir) && common < len(toParts)-1 && fromDir[common] == toParts[common] {
+		common++
+	}
+
+	var parts []string
+	for range fromDir[common:] {
+		parts = append(parts, "..")
+	}
+	parts = append(parts, toParts[common:]...)
+	return strings.Join(parts, "/")
+}
+
+// printDedupe summarizes duplicate sets and savings
+func printDedupe(result *DedupeResult) {
+	if len(result.Sets) == 0 {
+		fmt.Println("   No duplicate files found")
+		return
+	}
+	fmt.Printf("   Duplicate files (%d set(s), %s potential savings):\n", len(result.Sets), formatBytes(result.PotentialSavings))
+	for _, set := range result.Sets {
+		fmt.Printf("     %s x%d\n", formatBytes(set.Size), len(set.Paths))
+		for _, p := range set.Paths {
+			fmt.Printf("       - %s\n", p)
+		}
+	}
+	if result.ActualSavings > 0 {
+		fmt.Printf("   Replaced duplicates with symlinks, saving %s\n", formatBytes(result.ActualSavings))
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+// TestDedupeLinkKeepsExecutables links copies of a plain file to the main executable's
+// path, however they sort, and leaves identical Mach-O files as they are
+func TestDedupeLinkKeepsExecutables(t *testing.T) {
+	script := []byte("#!/bin/sh\nexec true\n")
+	file := func(name string, data []byte) BundleEntry {
+		return BundleEntry{RelPath: name, File: &VirtualFile{Name: "Applications/Fixture.app/" + name, Mode: 0755, Size: int64(len(data)), Data: data}}
+	}
+	entries := []BundleEntry{
+		file("A/copy.sh", script),
+		file("run", script),
+		file("Frameworks/Copy.dylib", fixtureMachO),
+		file("Fixture", fixtureMachO),
+	}
+
+	result, err := dedupeEntries(entries, DedupeLink, "run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Sets) != 2 {
+		t.Fatalf("%d duplicate sets, want 2: %+v", len(result.Sets), result.Sets)
+	}
+	if e := entries[1].File; e.IsLink {
+		t.Errorf("main executable run became a link to %s", e.LinkDest)
+	}
+	if e := entries[0].File; !e.IsLink || e.LinkDest != "../run" {
+		t.Errorf("A/copy.sh: link %v to %q, want a link to ../run", e.IsLink, e.LinkDest)
+	}
+	for _, e := range entries[2:] {
+		if e.File.IsLink {
+			t.Errorf("Mach-O %s became a link to %s", e.RelPath, e.File.LinkDest)
+		}
+	}
+	if want := int64(len(script)); result.ActualSavings != want {
+		t.Errorf("ActualSavings = %d, want %d, the script copy only", result.ActualSavings, want)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"deb-to-ipa/pkg/schema"
+)
+
+// --- Depictions: a Sileo native depiction for the converted app ---
+// Sileo renders a package page from a tree of views; sites that list converted apps can use
+// the same file. The tabs are what Sileo reads; Info carries the same values flat, for
+// generators that would rather not walk the views. Values the conversion doesn't know are
+// left out, never written as "Unknown".
+
+// depictionFlag is --depiction: bare it writes <ipa>.depiction.json beside the IPA,
+// --depiction=path writes there
+type depictionFlag string
+
+// depictionBesideIPA is the value a bare --depiction stands for
+const depictionBesideIPA = "true"
+
+func (d *depictionFlag) String() string   { return string(*d) }
+func (d *depictionFlag) IsBoolFlag() bool { return true }
+func (d *depictionFlag) Set(v string) error {
+	if v == "false" {
+		v = ""
+	}
+	*d = depictionFlag(v)
+	return nil
+}
+
+// Depiction is a Sileo native depiction (a DepictionTabView) plus the values it shows
+type Depiction struct {
+	Schema     string          `json:"schema"`     // schema.Depiction; Sileo ignores it
+	MinVersion string          `json:"minVersion"` // Of Sileo's depiction format, always "0.1"
+	Class      string          `json:"class"`      // "DepictionTabView"
+	Tabs       []DepictionView `json:"tabs"`       // Details, then Changelog
+	Info       DepictionInfo   `json:"info"`       // Ignored by Sileo
+}
+
+// DepictionView is one Sileo view; only the fields its class uses are set
+type DepictionView struct {
+	Class    string          `json:"class"`
+	TabName  string          `json:"tabname,omitempty"`  // DepictionStackView as a tab
+	Views    []DepictionView `json:"views,omitempty"`    // DepictionStackView
+	Title    string          `json:"title,omitempty"`    // DepictionTableTextView, DepictionSubheaderView
+	Text     string          `json:"text,omitempty"`     // DepictionTableTextView
+	Markdown string          `json:"markdown,omitempty"` // DepictionMarkdownView
+}
+
+// DepictionInfo is where each depicted value comes from
+type DepictionInfo struct {
+	Name        string `json:"name"`                  // CFBundleDisplayName, else CFBundleName, else the .app folder's name
+	BundleID    string `json:"bundleID,omitempty"`    // CFBundleIdentifier, after --bundle-id
+	Version     string `json:"version,omitempty"`     // CFBundleShortVersionString, else CFBundleVersion
+	Build       string `json:"build,omitempty"`       // CFBundleVersion
+	Size        int64  `json:"size,omitempty"`        // The IPA's size in bytes; absent with --extract-to
+	MinOS       string `json:"minOS,omitempty"`       // The executable's load commands, else MinimumOSVersion
+	Icon        string `json:"icon,omitempty"`        // The --export-icon PNG, when one was written
+	Description string `json:"description,omitempty"` // The control file's Description
+	Author      string `json:"author,omitempty"`      // Its Author, else Maintainer, without the email
+	Package     string `json:"package,omitempty"`     // Its Package
+}
+
+// depictionChangelogPlaceholder stands in for release notes a deb doesn't carry
+const depictionChangelogPlaceholder = "No release notes yet."
+
+// buildDepiction lays out a conversion's metadata as a Sileo depiction
+func buildDepiction(result *Result, iconPath string) *Depiction {
+	info := DepictionInfo{
+		Name:        appDisplayName(result),
+		Build:       plistValue(result.InfoPlist, "CFBundleVersion"),
+		MinOS:       valueOr(result.BinaryMinOS, result.MinOS),
+		Icon:        iconPath,
+		Description: strings.TrimSpace(result.Control["Description"]),
+		Author:      controlAuthor(result.Control),
+		Package:     result.Control["Package"],
+	}
+	if result.BundleID != "Unknown" {
+		info.BundleID = result.BundleID
+	}
+	if result.Version != "Unknown" {
+		info.Version = result.Version
+	}
+	if result.OutputPath != "" {
+		if stat, err := os.Stat(result.OutputPath); err == nil {
+			info.Size = stat.Size()
+		}
+	}
+
+	details := []DepictionView{}
+	if info.Description != "" {
+		details = append(details, DepictionView{Class: "DepictionMarkdownView", Markdown: info.Description})
+	}
+	compatibility, size := "", ""
+	if info.MinOS != "" {
+		compatibility = "iOS " + info.MinOS + " or later"
+	}
+	if info.Size > 0 {
+		size = formatBytes(info.Size)
+	}
+	rows := [][2]string{{"Version", info.Version}, {"Build", info.Build}, {"Bundle ID", info.BundleID}, {"Developer", info.Author}, {"Compatibility", compatibility}, {"Size", size}}
+	for _, row := range rows {
+		if row[1] != "" {
+			details = append(details, DepictionView{Class: "DepictionTableTextView", Title: row[0], Text: row[1]})
+		}
+	}
+
+	changelog := []DepictionView{{Class: "DepictionMarkdownView", Markdown: depictionChangelogPlaceholder}}
+	if info.Version != "" {
+		changelog = append([]DepictionView{{Class: "DepictionSubheaderView", Title: info.Version}}, changelog...)
+	}
+
+	return &Depiction{
+		Schema:     schema.Depiction,
+		MinVersion: "0.1",
+		Class:      "DepictionTabView",
+		Tabs: []DepictionView{
+			{Class: "DepictionStackView", TabName: "Details", Views: details},
+			{Class: "DepictionStackView", TabName: "Changelog", Views: changelog},
+		},
+		Info: info,
+	}
+}
+
+// depictionPath is where --depiction writes: the given path, or beside the IPA (in the
+// --extract-to folder when there is none) named after it
+func depictionPath(result *Result, opts Options) string {
+	switch {
+	case opts.Depiction != depictionBesideIPA:
+		return opts.Depiction
+	case result.OutputPath != "":
+		return strings.TrimSuffix(result.OutputPath, filepath.Ext(result.OutputPath)) + ".depiction.json"
+	}
+	return filepath.Join(opts.ExtractTo, strings.TrimSuffix(result.AppName, path.Ext(result.AppName))+".depiction.json")
+}
+
+// writeDepiction saves the depiction, through a temporary file so a reader never sees half of it
+func writeDepiction(result *Result, opts Options) error {
+	depictionFile := depictionPath(result, opts)
+	iconPath := ""
+	if result.Icon != nil && opts.ExportIcon != "" {
+		if _, err := os.Stat(opts.ExportIcon); err == nil {
+			iconPath = opts.ExportIcon
+		}
+	}
+	out, err := json.MarshalIndent(buildDepiction(result, iconPath), "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := depictionFile + ".tmp"
+	if err := os.WriteFile(tmp, append(out, '\n'), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, depictionFile); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	fmt.Printf("   Wrote depiction %s\n", depictionFile)
+	return nil
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+
+	"deb-to-ipa/pkg/schema"
+)
+
+// --- diff subcommand ---
+// deb-to-ipa diff old.(deb|ipa) new.(deb|ipa) compares the app bundles inside two packages.
+// Exit status: 0 identical, 1 different, 2 error.
+
+// bundleFile is one entry of a bundle being compared
+type bundleFile struct {
+	Size     int64
+	SHA256   string // Empty for directories
+	IsDir    bool
+	LinkDest string
+}
+
+// bundleSnapshot is everything diff needs from one package
+type bundleSnapshot struct {
+	AppName   string
+	Files     map[string]bundleFile
+	InfoPlist map[string]any
+}
+
+// FileChange is an added, removed or modified bundle path
+type FileChange struct {
+	Path    string `json:"path"`
+	OldSize int64  `json:"oldSize,omitempty"`
+	NewSize int64  `json:"newSize,omitempty"`
+}
+
+// PlistChange is a top-level Info.plist key that differs
+type PlistChange struct {
+	Key string `json:"key"`
+	Old any    `json:"old,omitempty"`
+	New any    `json:"new,omitempty"`
+}
+
+// BundleDiff is the result of comparing two bundles
+type BundleDiff struct {
+	Schema     string        `json:"schema"` // schema.Diff
+	Old        string        `json:"old"`
+	New        string        `json:"new"`
+	OldVersion string        `json:"oldVersion"`
+	NewVersion string        `json:"newVersion"`
+	Added      []FileChange  `json:"added"`
+	Removed    []FileChange  `json:"removed"`
+	Modified   []FileChange  `json:"modified"`
+	InfoPlist  []PlistChange `json:"infoPlist"`
+	OldSize    int64         `json:"oldSize"`
+	NewSize    int64         `json:"newSize"`
+}
+
+// Identical reports whether nothing changed
+func (d *BundleDiff) Identical() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Modified) == 0 && len(d.InfoPlist) == 0
+}
+
+// runDiff implements the diff subcommand and returns the exit status
+func runDiff(args []string) int {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print the diff as JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: deb-to-ipa diff [--json] <old.deb|old.ipa> <new.deb|new.ipa>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+
+	oldSnap, err := loadSnapshot(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Error: %s: %v\n", fs.Arg(0), err)
+		return 2
+	}
+	newSnap, err := loadSnapshot(fs.Arg(1))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Error: %s: %v\n", fs.Arg(1), err)
+		return 2
+	}
+
+	d := diffSnapshots(oldSnap, newSnap)
+	d.Old, d.New = fs.Arg(0), fs.Arg(1)
+	if *asJSON {
+		out, _ := json.MarshalIndent(d, "", "  ")
+		fmt.Println(string(out))
+	} else {
+		printDiff(d)
+	}
+
+	if d.Identical() {
+		return 0
+	}
+	return 1
+}
+
+// loadSnapshot hashes every file of the app bundle in a deb or IPA
+func loadSnapshot(pkgPath string) (*bundleSnapshot, error) {
+	if strings.EqualFold(path.Ext(pkgPath), ".ipa") || strings.EqualFold(path.Ext(pkgPath), ".zip") {
+		return loadIPASnapshot(pkgPath)
+	}
+
+	tempDir, err := os.MkdirTemp("", "ipa-diff")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tempDir)
+
+	deb, err := readDeb(pkgPath, &SpillStore{Dir: tempDir}, readOptions{Quiet: true})
+	if err != nil {
+		return nil, err
+	}
+	if deb.AppDirPrefix == "" {
+		return nil, fmt.Errorf("no .app directory inside deb")
+	}
+	entries, err := selectBundleEntries(deb.Files, deb.AppDirPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	snap := &bundleSnapshot{AppName: deb.appFolderName(), Files: make(map[string]bundleFile)}
+	for _, entry := range entries {
+		if entry.RelPath == "" {
+			continue
+		}
+		vf := entry.File
+		file := bundleFile{Size: vf.Size, IsDir: vf.IsDir, LinkDest: vf.LinkDest}
+		if !vf.IsDir && !vf.IsLink {
+			if file.SHA256, err = hashFile(vf); err != nil {
+				return nil, err
+			}
+		}
+		if entry.RelPath == "Info.plist" || entry.RelPath == macOSInfoPlist && snap.InfoPlist == nil {
+			data, err := readAll(vf)
+			if err != nil {
+				return nil, err
+			}
+			snap.InfoPlist = parsePlistDict(data)
+		}
+		snap.Files[entry.RelPath] = file
+	}
+	return snap, nil
+}
+
+// loadIPASnapshot hashes the bundle under Payload/<App>.app/ in an IPA
+func loadIPASnapshot(ipaPath string) (*bundleSnapshot, error) {
+	zr, err := zip.OpenReader(ipaPath)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	snap := &bundleSnapshot{Files: make(map[string]bundleFile)}
+	prefix := ipaAppPrefix(zr.File)
+	if prefix == "" {
+		return nil, fmt.Errorf("no Payload/<App>.app inside IPA")
+	}
+	snap.AppName = path.Base(prefix)
+
+	for _, f := range zr.File {
+		if !strings.HasPrefix(f.Name, prefix) {
+			continue
+		}
+		relPath := strings.TrimSuffix(strings.TrimPrefix(f.Name, prefix), "/")
+		if relPath == "" {
+			continue
+		}
+
+		mode := f.Mode()
+		file := bundleFile{Size: int64(f.UncompressedSize64), IsDir: mode.IsDir()}
+		if !file.IsDir {
+			rc, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+			if mode&os.ModeSymlink != 0 || relPath == "Info.plist" {
+				data, err := io.ReadAll(rc)
+				rc.Close()
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", f.Name, err)
+				}
+				if mode&os.ModeSymlink != 0 {
+					file.LinkDest, file.Size = string(data), 0 // Debs record links as size 0
+				} else {
+					snap.InfoPlist = parsePlistDict(data)
+					file.SHA256, _ = hashFile(&VirtualFile{Data: data})
+				}
+			} else {
+				h := sha256.New()
+				_, err := io.Copy(h, rc)
+				rc.Close()
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", f.Name, err)
+				}
+				file.SHA256 = hex.EncodeToString(h.Sum(nil))
+			}
+		}
+		snap.Files[relPath] = file
+	}
+	return snap, nil
+}
+
+// diffSnapshots compares two bundles file by file and Info.plist key by key
+func diffSnapshots(oldSnap, newSnap *bundleSnapshot) *BundleDiff {
+	d := &BundleDiff{
+		Schema:     schema.Diff,
+		OldVersion: snapshotVersion(oldSnap.InfoPlist),
+		NewVersion: snapshotVersion(newSnap.InfoPlist),
+		Added:      []FileChange{},
+		Removed:    []FileChange{},
+		Modified:   []FileChange{},
+		InfoPlist:  []PlistChange{},
+	}
+
+	for p, oldFile := range oldSnap.Files {
+		d.OldSize += oldFile.Size
+		newFile, ok := newSnap.Files[p]
+		switch {
+		case !ok:
+			d.Removed = append(d.Removed, FileChange{Path: p, OldSize: oldFile.Size})
+		case oldFile.IsDir != newFile.IsDir || oldFile.SHA256 != newFile.SHA256 || oldFile.LinkDest != newFile.LinkDest:
+			d.Modified = append(d.Modified, FileChange{Path: p, OldSize: oldFile.Size, NewSize: newFile.Size})
+		}
+	}
+	for p, newFile := range newSnap.Files {
+		d.NewSize += newFile.Size
+		if _, ok := oldSnap.Files[p]; !ok {
+			d.Added = append(d.Added, FileChange{Path: p, NewSize: newFile.Size})
+		}
+	}
+	for _, list := range [][]FileChange{d.Added, d.Removed, d.Modified} {
+		sort.Slice(list, func(i, j int) bool { return list[i].Path < list[j].Path })
+	}
+
+	keys := make(map[string]bool)
+	for k := range oldSnap.InfoPlist {
+		keys[k] = true
+	}
+	for k := range newSnap.InfoPlist {
+		keys[k] = true
+	}
+	for k := range keys {
+		oldValue, newValue := oldSnap.InfoPlist[k], newSnap.InfoPlist[k]
+		if !reflect.DeepEqual(oldValue, newValue) {
+			d.InfoPlist = append(d.InfoPlist, PlistChange{Key: k, Old: oldValue, New: newValue})
+		}
+	}
+	sort.Slice(d.InfoPlist, func(i, j int) bool { return d.InfoPlist[i].Key < d.InfoPlist[j].Key })
+	return d
+}
+
+// snapshotVersion renders "1.2.3 (457)" from CFBundleShortVersionString and CFBundleVersion
+func snapshotVersion(info map[string]any) string {
+	short, _ := info["CFBundleShortVersionString"].(string)
+	build, _ := info["CFBundleVersion"].(string)
+	switch {
+	case short != "" && build != "":
+		return fmt.Sprintf("%s (%s)", short, build)
+	case short != "":
+		return short
+	case build != "":
+		return build
+	}
+	return "Unknown"
+}
+
+// printDiff renders a diff for humans
+func printDiff(d *BundleDiff) {
+	fmt.Printf("🔍 %s -> %s\n", d.Old, d.New)
+	if d.Identical() {
+		fmt.Println("   ✅ Bundles are identical")
+		return
+	}
+
+	if d.OldVersion != d.NewVersion {
+		fmt.Printf("   Version: %s -> %s\n", d.OldVersion, d.NewVersion)
+	}
+
+	if len(d.InfoPlist) > 0 {
+		fmt.Printf("\n   Info.plist (%d key(s) changed):\n", len(d.InfoPlist))
+		for _, c := range d.InfoPlist {
+			switch {
+			case c.Old == nil:
+				fmt.Printf("     + %s = %s\n", c.Key, plistSummary(c.New))
+			case c.New == nil:
+				fmt.Printf("     - %s (was %s)\n", c.Key, plistSummary(c.Old))
+			default:
+				fmt.Printf("     ~ %s: %s -> %s\n", c.Key, plistSummary(c.Old), plistSummary(c.New))
+			}
+		}
+	}
+
+	for _, section := range []struct {
+		title string
+		mark  string
+		list  []FileChange
+	}{{"Added", "+", d.Added}, {"Removed", "-", d.Removed}, {"Modified", "~", d.Modified}} {
+		if len(section.list) == 0 {
+			continue
+		}
+		fmt.Printf("\n   %s (%d):\n", section.title, len(section.list))
+		for _, c := range section.list {
+			switch section.mark {
+			case "+":
+				fmt.Printf("     + %s (%s)\n", c.Path, formatBytes(c.NewSize))
+			case "-":
+				fmt.Printf("     - %s (%s)\n", c.Path, formatBytes(c.OldSize))
+			default:
+				fmt.Printf("     ~ %s (%s -> %s)\n", c.Path, formatBytes(c.OldSize), formatBytes(c.NewSize))
+			}
+		}
+	}
+
+	fmt.Printf("\n   Size: %s -> %s (%s)\n", formatBytes(d.OldSize), formatBytes(d.NewSize), formatDelta(d.NewSize-d.OldSize))
+}
+
+// plistSummary renders a plist value on one line, abbreviating containers
+func plistSummary(v any) string {
+	switch v := v.(type) {
+	case map[string]any:
+		return fmt.Sprintf("{%d keys}", len(v))
+	case []any:
+		return fmt.Sprintf("[%d items]", len(v))
+	case []byte:
+		return fmt.Sprintf("<%d bytes>", len(v))
+	case string:
+		return fmt.Sprintf("%q", v)
+	}
+	return fmt.Sprint(v)
+}
+
+// formatDelta renders a signed byte difference, e.g. "+1.2 MB"
+func formatDelta(n int64) string {
+	if n < 0 {
+		return "-" + formatBytes(-n)
+	}
+	return "+" + formatBytes(n)
+}
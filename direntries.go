@@ -0,0 +1,45 @@
+package main
+
+// --- Directory entries: which folders get one of their own ---
+// A zip doesn't need an entry for a folder: unzippers create it for the files inside.
+// IPAs have always carried one per folder, with its mode, and older installd paths want
+// them; some signing tools (a popular zsign fork among them) create such paths twice.
+// --dir-entries never leaves them all out, and auto keeps only the empty folders, which
+// nothing else would bring back. A folder without an entry loses its mode: whatever
+// extracts it picks its own, which --verbose notes folder by folder.
+
+// Directory entry policies for --dir-entries
+const (
+	DirEntriesAlways = "always" // One per folder
+	DirEntriesNever  = "never"  // None; the entries inside imply the folders
+	DirEntriesAuto   = "auto"   // Only for folders with nothing inside
+)
+
+// omittedDirs returns the bundle-relative folders policy writes no entry for; any policy
+// but never and auto is always. With never, an empty folder goes missing along with its
+// entry, which is warned about.
+func omittedDirs(entries []BundleEntry, policy string, warnings *warningLog) map[string]bool {
+	omitted := make(map[string]bool)
+	if policy != DirEntriesNever && policy != DirEntriesAuto {
+		return omitted
+	}
+	parents := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.RelPath != "" {
+			parents[parentDir(entry.RelPath)] = true
+		}
+	}
+	for _, entry := range entries {
+		if !entry.File.IsDir {
+			continue
+		}
+		switch {
+		case parents[entry.RelPath]:
+			omitted[entry.RelPath] = true
+		case policy == DirEntriesNever:
+			omitted[entry.RelPath] = true
+			warnings.add("dir-entries-empty", entry.RelPath, "The folder %s is empty; with --dir-entries never it isn't in the IPA at all", entry.RelPath)
+		}
+	}
+	return omitted
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package main
+
+// diskFree isn't known here; --compress-spill=auto leaves spill files uncompressed
+func diskFree(dir string) int64 {
+	return -1
+}
@@ -0,0 +1,17 @@
+package main
+
+import "golang.org/x/sys/windows"
+
+// diskFree returns the bytes available to this user on the volume holding dir, or -1 when
+// it can't be told
+func diskFree(dir string) int64 {
+	path, err := windows.UTF16PtrFromString(dir)
+	if err != nil {
+		return -1
+	}
+	var free uint64
+	if err := windows.GetDiskFreeSpaceEx(path, &free, nil, nil); err != nil {
+		return -1
+	}
+	return int64(free)
+}
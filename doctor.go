@@ -0,0 +1,541 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"deb-to-ipa/pkg/schema"
+	ar "github.com/erikgeiser/ar"
+)
+
+// --- Doctor: a conversion's every stage, instrumented, with nothing written ---
+// "It doesn't work" needs more than the last error line. doctor reads the input the way a
+// conversion does, stage by stage, and records what each one saw: the ar members and their
+// magic bytes, the decompressor, the first tar entries, every .app folder, each Info.plist,
+// the main binary and the modes key files would get. A failed stage records its error and
+// a code, and the stages needing its result are skipped. Everything lands in one text or
+// JSON blob to attach to an issue; --redact hides local paths.
+
+// doctorTarEntries is how many data.tar entries the report lists
+const doctorTarEntries = 50
+
+// doctorModeEntries is how many files the permissions stage lists
+const doctorModeEntries = 50
+
+// Doctor stage statuses
+const (
+	DoctorOK      = "ok"
+	DoctorFailed  = "failed"
+	DoctorSkipped = "skipped"
+)
+
+// DoctorReport is everything doctor found out about an input
+type DoctorReport struct {
+	Schema       string           `json:"schema"` // schema.Doctor
+	Input        string           `json:"input"`
+	Kind         string           `json:"kind,omitempty"`  // deb, tarball, zip or directory
+	Size         int64            `json:"size,omitempty"`  // Of the input file
+	Magic        string           `json:"magic,omitempty"` // Its first bytes, in hex
+	Members      []DoctorMember   `json:"arMembers,omitempty"`
+	Decompressor string           `json:"decompressor,omitempty"` // e.g. "xz", with its dictionary size
+	TarEntries   []DoctorTarEntry `json:"tarEntries,omitempty"`   // The first doctorTarEntries
+	TotalEntries int              `json:"totalEntries,omitempty"`
+	AppPrefixes  []string         `json:"appPrefixes,omitempty"` // Every .app folder, in archive order
+	AppPrefix    string           `json:"appPrefix,omitempty"`   // The one a conversion picks
+	InfoPlists   []DoctorPlist    `json:"infoPlists,omitempty"`
+	Executable   *DoctorBinary    `json:"executable,omitempty"`
+	Permissions  []DoctorMode     `json:"permissions,omitempty"` // The executable, Info.plist and Mach-O files
+	Warnings     []Warning        `json:"warnings,omitempty"`    // What the stages would have warned about
+	Stages       []DoctorStage    `json:"stages"`
+	Error        *DoctorStage     `json:"error,omitempty"` // The first stage that failed
+}
+
+// DoctorMember is one ar member of a deb
+type DoctorMember struct {
+	Name   string `json:"name"`
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+	Magic  string `json:"magic"` // First bytes of its data, in hex
+}
+
+// DoctorTarEntry is one data.tar header
+type DoctorTarEntry struct {
+	Name string `json:"name"`
+	Type string `json:"type"` // file, dir, symlink or the tar type flag
+	Size int64  `json:"size"`
+	Link string `json:"link,omitempty"`
+}
+
+// DoctorPlist is an Info.plist found in the input, and what parsing it gave
+type DoctorPlist struct {
+	Path       string `json:"path"`
+	Main       bool   `json:"main,omitempty"` // The app's own, at the bundle root
+	Format     string `json:"format"`         // xml or binary
+	Bytes      int64  `json:"bytes"`
+	Executable string `json:"executable,omitempty"`
+	BundleID   string `json:"bundleId,omitempty"`
+	Version    string `json:"version,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// DoctorBinary is the main executable as the conversion would resolve it
+type DoctorBinary struct {
+	Name   string        `json:"name"`
+	Source string        `json:"source"` // One of the ExecutableFrom* constants
+	Slices []DoctorSlice `json:"slices,omitempty"`
+	Error  string        `json:"error,omitempty"`
+}
+
+// DoctorSlice is one architecture of the main executable
+type DoctorSlice struct {
+	Arch      string `json:"arch"`
+	MinOS     string `json:"minOS,omitempty"`
+	Encrypted bool   `json:"encrypted,omitempty"` // FairPlay: won't run once converted
+}
+
+// DoctorMode is the mode a conversion gives a file
+type DoctorMode struct {
+	Path   string `json:"path"`
+	From   string `json:"from"` // As in the input, octal
+	To     string `json:"to"`
+	Reason string `json:"reason,omitempty"` // Why it's executable: by name, or its Mach-O header
+}
+
+// DoctorStage is one step and how it went
+type DoctorStage struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Code   string `json:"code,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// doctor runs the stages on one input; the report says where it stopped
+type doctor struct {
+	report DoctorReport
+	failed bool
+}
+
+// stage records a stage's outcome: skipped once an earlier one failed, else run's
+func (d *doctor) stage(name, code string, run func() error) {
+	if d.failed {
+		d.report.Stages = append(d.report.Stages, DoctorStage{Name: name, Status: DoctorSkipped})
+		return
+	}
+	stage := DoctorStage{Name: name, Status: DoctorOK}
+	if err := run(); err != nil {
+		stage.Status, stage.Code, stage.Error = DoctorFailed, code, err.Error()
+		if code == ResultCodeConversion {
+			stage.Code = conversionErrorCode(err)
+		}
+		d.failed = true
+		d.report.Error = &stage
+	}
+	d.report.Stages = append(d.report.Stages, stage)
+}
+
+// runDoctor implements the doctor subcommand: 0 when every stage passed, 1 when one
+// failed, 2 for usage errors
+func runDoctor(args []string) int {
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print the diagnosis as JSON")
+	redact := fs.Bool("redact", false, "hide local paths (the input's folder, the home and temp directories)")
+	output := fs.String("o", "", "write the diagnosis to this file instead of stdout")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: deb-to-ipa doctor [--json] [--redact] [-o file] <input.deb|tarball|.app folder|.ipa>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	report := diagnose(fs.Arg(0))
+	var blob []byte
+	if *asJSON {
+		out, _ := json.MarshalIndent(report, "", "  ")
+		blob = append(out, '\n')
+	} else {
+		blob = []byte(formatDiagnosis(report))
+	}
+	if *redact {
+		blob = redactPaths(blob, fs.Arg(0))
+	}
+	if *output != "" {
+		if err := os.WriteFile(*output, blob, 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Error: %v\n", err)
+			return 2
+		}
+		fmt.Printf("🩺 Wrote the diagnosis to %s\n", *output)
+	} else {
+		os.Stdout.Write(blob)
+	}
+	if report.Error != nil {
+		return 1
+	}
+	return 0
+}
+
+// diagnose runs every stage of a conversion on input, short of writing anything
+func diagnose(input string) *DoctorReport {
+	d := &doctor{report: DoctorReport{Schema: schema.Doctor, Input: input}}
+	r := &d.report
+
+	var f *os.File
+	var member arMember
+	d.stage("input", "input", func() error {
+		info, err := os.Stat(input)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			r.Kind = "directory"
+			return nil
+		}
+		r.Size = info.Size()
+		if f, err = os.Open(input); err != nil {
+			return err
+		}
+		r.Magic = magicHex(f, 0, 16)
+		switch name, tarball := plainTarName(f); {
+		case isZipArchive(f):
+			r.Kind = "zip"
+		case tarball:
+			r.Kind = "tarball"
+			member = arMember{Name: name, Size: info.Size()}
+		case strings.HasPrefix(r.Magic, hex.EncodeToString([]byte("!<arch>\n"))):
+			r.Kind = "deb"
+		default:
+			return fmt.Errorf("not a deb, tarball, zip or .app folder (starts with %s)", r.Magic)
+		}
+		return nil
+	})
+	if f != nil {
+		defer f.Close()
+	}
+
+	// The archive stages read data.tar themselves; zips and folders go straight to readDeb
+	archived := r.Kind == "deb" || r.Kind == "tarball"
+	if r.Kind == "deb" {
+		d.stage("ar-members", "archive", func() error {
+			position := &countingReader{r: f}
+			arReader, err := ar.NewReader(position)
+			if err != nil {
+				return fmt.Errorf("invalid deb archive: %w", err)
+			}
+			for {
+				header, err := arReader.Next()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					return err
+				}
+				m := arMember{Name: header.Name, Offset: position.n, Size: header.Size}
+				r.Members = append(r.Members, DoctorMember{Name: m.Name, Offset: m.Offset, Size: m.Size, Magic: magicHex(f, m.Offset, min(m.Size, 8))})
+				if err := m.checkSize(r.Size); err != nil {
+					return err
+				}
+				if member.Name == "" && strings.HasPrefix(header.Name, "data.tar") {
+					member = m
+				}
+			}
+			if member.Name == "" {
+				return fmt.Errorf("data.tar not found in deb")
+			}
+			return nil
+		})
+	}
+	if archived {
+		d.stage("decompress", "decompress", func() error {
+			r.Decompressor = strings.TrimPrefix(path.Ext(member.Name), ".")
+			if window := decompressorWindow(member.Name, member.open(f)); window > 0 {
+				r.Decompressor += fmt.Sprintf(" (%s dictionary)", formatBytes(window))
+			}
+			_, err := decompress(member.Name, member.open(f))
+			return err
+		})
+		d.stage("tar", "tar", func() error {
+			index, err := readTarIndex(member, f, InputLimits{}, nil)
+			r.TotalEntries = len(index)
+			for i, e := range index {
+				if i < doctorTarEntries {
+					r.TarEntries = append(r.TarEntries, DoctorTarEntry{Name: e.Name, Type: tarTypeName(e.Type), Size: e.Size, Link: e.Link})
+				}
+				r.addPrefix(e.Name)
+			}
+			return err
+		})
+	}
+
+	var deb *DebContents
+	var entries []BundleEntry
+	var appNameFolder string
+	var warnings warningLog
+	d.stage("read", ResultCodeConversion, func() error {
+		tempDir, err := os.MkdirTemp("", "ipa-doctor")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(tempDir)
+		if deb, err = readDeb(input, &SpillStore{Dir: tempDir}, readOptions{Quiet: true}); err != nil {
+			return err
+		}
+		if !archived {
+			for _, vf := range deb.Files {
+				r.addPrefix(vf.Name)
+			}
+			for _, e := range deb.Outside {
+				r.addPrefix(e.Name)
+			}
+		}
+		if r.AppPrefix = deb.AppDirPrefix; r.AppPrefix == "" {
+			return notAnApp(deb, BundleExtApp)
+		}
+		appNameFolder = deb.appFolderName()
+		checkPrefixStragglers(deb, &warnings)
+		entries, err = selectBundleEntries(deb.Files, deb.AppDirPrefix)
+		return err
+	})
+
+	var plistExecutable string
+	d.stage("info-plist", "plist", func() error {
+		for _, vf := range deb.Files {
+			if path.Base(vf.Name) != "Info.plist" || vf.IsDir || vf.IsLink {
+				continue
+			}
+			data, err := readAll(vf)
+			p := DoctorPlist{Path: vf.Name, Main: vf.Name == deb.AppDirPrefix+"Info.plist", Format: "xml", Bytes: vf.Size}
+			if isBinaryPlist(data) {
+				p.Format = "binary"
+			}
+			if err == nil {
+				_, err = parsePlist(data)
+			}
+			if err != nil {
+				p.Error = err.Error()
+			} else {
+				p.Executable, p.BundleID, p.Version = parseAppMetadata(data)
+			}
+			if p.Main {
+				plistExecutable = p.Executable
+			}
+			r.InfoPlists = append(r.InfoPlists, p)
+		}
+		if deb.InfoPlistData == nil {
+			return fmt.Errorf("no Info.plist at the root of %s", deb.AppDirPrefix)
+		}
+		return nil
+	})
+
+	var executableName string
+	d.stage("executable", "executable", func() error {
+		plistName, _, _, fixes := sanitizeAppMetadata(plistExecutable, "", "")
+		printMetadataFixes(fixes, &warnings)
+		name, source, err := resolveExecutable(entries, appNameFolder, plistName, "", &warnings)
+		if err != nil {
+			return err
+		}
+		executableName = name
+		binary := &DoctorBinary{Name: name, Source: source}
+		r.Executable = binary
+		if err := checkExecutableSize(entries, name, defaultMinExecutableSize, &warnings); err != nil {
+			binary.Error = err.Error()
+			return err
+		}
+		for _, entry := range entries {
+			if entry.RelPath != name || entry.File.IsDir || entry.File.IsLink {
+				continue
+			}
+			reader, done, err := readerAt(entry.File)
+			if err != nil {
+				binary.Error = err.Error()
+				return err
+			}
+			defer done()
+			slices, err := machoSlices(reader)
+			if err != nil {
+				binary.Error = "not a Mach-O binary: " + err.Error()
+				return fmt.Errorf("%s is %s", name, binary.Error)
+			}
+			for _, slice := range slices {
+				binary.Slices = append(binary.Slices, DoctorSlice{Arch: archName(slice.Cpu), MinOS: minOSVersion(slice), Encrypted: sliceEncrypted(slice)})
+			}
+		}
+		return nil
+	})
+
+	d.stage("permissions", "permissions", func() error {
+		for _, entry := range entries {
+			vf := entry.File
+			if vf.IsDir || vf.IsLink || len(r.Permissions) == doctorModeEntries {
+				continue
+			}
+			name := path.Join(appNameFolder, entry.RelPath)
+			if entry.RelPath != executableName && entry.RelPath != "Info.plist" && !sniffMachO(vf) {
+				continue
+			}
+			mode, reason := modeDecision(vf, name, executableName, nil)
+			r.Permissions = append(r.Permissions, DoctorMode{Path: entry.RelPath, From: fmt.Sprintf("%04o", vf.Mode), To: fmt.Sprintf("%04o", mode), Reason: reason})
+		}
+		return nil
+	})
+
+	r.Warnings = warnings.list
+	return r
+}
+
+// addPrefix notes the .app folder an entry sits in, once
+func (r *DoctorReport) addPrefix(name string) {
+	prefix := bundlePrefix(name, BundleExtApp)
+	if prefix == "" {
+		return
+	}
+	for _, seen := range r.AppPrefixes {
+		if seen == prefix {
+			return
+		}
+	}
+	r.AppPrefixes = append(r.AppPrefixes, prefix)
+}
+
+// magicHex is up to n bytes at off, in hex
+func magicHex(f io.ReaderAt, off, n int64) string {
+	buf := make([]byte, n)
+	read, _ := f.ReadAt(buf, off)
+	return hex.EncodeToString(buf[:read])
+}
+
+// tarTypeName names the tar type flags data.tar entries have
+func tarTypeName(typeflag byte) string {
+	switch typeflag {
+	case '0', 0:
+		return "file"
+	case '5':
+		return "dir"
+	case '2':
+		return "symlink"
+	case '1':
+		return "hardlink"
+	}
+	return fmt.Sprintf("type %q", typeflag)
+}
+
+// formatDiagnosis lays the report out as text for an issue
+func formatDiagnosis(r *DoctorReport) string {
+	var b strings.Builder
+	line := func(format string, args ...any) { fmt.Fprintf(&b, format+"\n", args...) }
+	line("🩺 deb-to-ipa doctor: %s", r.Input)
+	if r.Kind != "" {
+		line("   Input: %s, %s, starts with %s", r.Kind, formatBytes(r.Size), r.Magic)
+	}
+	if len(r.Members) > 0 {
+		line("\n   ar members:")
+		for _, m := range r.Members {
+			line("     %-20s %10d bytes at %-8d magic %s", m.Name, m.Size, m.Offset, m.Magic)
+		}
+	}
+	if r.Decompressor != "" {
+		line("   Decompressor: %s", r.Decompressor)
+	}
+	if len(r.TarEntries) > 0 {
+		line("\n   data.tar: %d entries, the first %d:", r.TotalEntries, len(r.TarEntries))
+		for _, e := range r.TarEntries {
+			link := ""
+			if e.Link != "" {
+				link = " -> " + e.Link
+			}
+			line("     %-7s %10d  %s%s", e.Type, e.Size, e.Name, link)
+		}
+	}
+	if len(r.AppPrefixes) > 0 {
+		line("\n   .app folders: %s", strings.Join(r.AppPrefixes, ", "))
+	}
+	if r.AppPrefix != "" {
+		line("   Converting: %s", r.AppPrefix)
+	}
+	if len(r.InfoPlists) > 0 {
+		line("\n   Info.plist files:")
+		for _, p := range r.InfoPlists {
+			what := fmt.Sprintf("executable %q, %s %s", p.Executable, p.BundleID, p.Version)
+			if p.Error != "" {
+				what = "unparseable: " + p.Error
+			}
+			main := ""
+			if p.Main {
+				main = " (main)"
+			}
+			line("     %s%s [%s, %d bytes]: %s", p.Path, main, p.Format, p.Bytes, what)
+		}
+	}
+	if x := r.Executable; x != nil {
+		line("\n   Executable: %s (%s)", x.Name, x.Source)
+		for _, s := range x.Slices {
+			encrypted := ""
+			if s.Encrypted {
+				encrypted = ", FairPlay encrypted"
+			}
+			line("     %s, minimum iOS %s%s", s.Arch, valueOr(s.MinOS, "unknown"), encrypted)
+		}
+		if x.Error != "" {
+			line("     %s", x.Error)
+		}
+	}
+	if len(r.Permissions) > 0 {
+		line("\n   Modes:")
+		for _, m := range r.Permissions {
+			reason := ""
+			if m.Reason != "" {
+				reason = " (" + m.Reason + ")"
+			}
+			line("     %s -> %s  %s%s", m.From, m.To, m.Path, reason)
+		}
+	}
+	if len(r.Warnings) > 0 {
+		line("\n   Warnings:")
+		for _, w := range r.Warnings {
+			line("     %s: %s", w.Code, w.Message)
+		}
+	}
+	line("\n   Stages:")
+	for _, s := range r.Stages {
+		icon := map[string]string{DoctorOK: "✅", DoctorFailed: "❌", DoctorSkipped: "–"}[s.Status]
+		detail := ""
+		if s.Error != "" {
+			detail = fmt.Sprintf(" [%s] %s", s.Code, s.Error)
+		}
+		line("     %s %s%s", icon, s.Name, detail)
+	}
+	return b.String()
+}
+
+// redactPaths replaces the input's folder, the home directory and the temp directory in
+// a diagnosis with placeholders, and the input with its base name
+func redactPaths(blob []byte, input string) []byte {
+	var pairs [][2]string
+	if abs, err := filepath.Abs(input); err == nil {
+		pairs = append(pairs, [2]string{abs, filepath.Base(abs)}, [2]string{filepath.Dir(abs), "<input-dir>"})
+	}
+	pairs = append(pairs, [2]string{input, filepath.Base(input)})
+	if home, err := os.UserHomeDir(); err == nil && home != "" {
+		pairs = append(pairs, [2]string{home, "~"})
+	}
+	pairs = append(pairs, [2]string{os.TempDir(), "<tmp>"})
+	for _, p := range pairs {
+		if p[0] != "" && p[0] != "." && p[0] != string(filepath.Separator) {
+			blob = bytes.ReplaceAll(blob, []byte(p[0]), []byte(p[1]))
+		}
+	}
+	return blob
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// --- Resumable downloads: a repo deb survives a dropped connection ---
+// Each download lives in its own cache entry, a folder named by the URL's hash holding the
+// partial deb and the validators it was fetched under. A rerun asks for the rest with a
+// Range request; If-Range makes the server send the whole file instead if it changed in
+// between. The entry is removed once the deb has been converted.
+
+// downloadMetaName is the cache entry's record of where its deb came from
+const downloadMetaName = "download.json"
+
+// downloadMeta is what a partial download was fetched under, to resume it safely
+type downloadMeta struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+// validator is the If-Range value for resuming, "" when there is none to trust.
+// Weak ETags aren't allowed in If-Range.
+func (m downloadMeta) validator() string {
+	if m.ETag != "" && !strings.HasPrefix(m.ETag, "W/") {
+		return m.ETag
+	}
+	return m.LastModified
+}
+
+// downloadedDeb is a deb fetched from a repo
+type downloadedDeb struct {
+	Path  string // Where it was saved
+	Name  string // Its file name in the repo
+	Cache string // Its cache entry; "" when it isn't cached (--no-resume, --keep-deb)
+}
+
+// discard removes the deb once the conversion is over. A cached one is kept when the
+// conversion failed, so the next attempt doesn't download it again.
+func (d *downloadedDeb) discard(converted bool) {
+	switch {
+	case d.Cache == "":
+		os.Remove(d.Path)
+	case converted:
+		os.RemoveAll(d.Cache)
+	default:
+		fmt.Printf("   The download stays in %s for the next attempt\n", d.Cache)
+	}
+}
+
+// downloadCacheDir is --download-cache, or deb-to-ipa/downloads in the user cache directory
+func downloadCacheDir(opts Options) string {
+	if opts.DownloadCache != "" {
+		return opts.DownloadCache
+	}
+	base, err := os.UserCacheDir()
+	if err != nil {
+		base = os.TempDir()
+	}
+	return filepath.Join(base, "deb-to-ipa", "downloads")
+}
+
+// downloadCacheEntry is the cache folder for a URL
+func downloadCacheEntry(cacheDir, u string) string {
+	sum := sha256.Sum256([]byte(u))
+	return filepath.Join(cacheDir, hex.EncodeToString(sum[:8]))
+}
+
+// fetchResumable downloads u to dest inside a cache entry, continuing a partial download
+// there when the server supports it. size is the expected length, -1 if unknown.
+func (c *repoClient) fetchResumable(u, entry, dest string, size int64) error {
+	if err := os.MkdirAll(entry, 0755); err != nil {
+		return fmt.Errorf("download cache: %w", err)
+	}
+	metaPath := filepath.Join(entry, downloadMetaName)
+
+	var meta downloadMeta
+	if data, err := os.ReadFile(metaPath); err == nil {
+		json.Unmarshal(data, &meta)
+	}
+	var offset int64
+	if info, err := os.Stat(dest); err == nil && meta.URL == u && meta.validator() != "" {
+		offset = info.Size()
+	}
+	if size >= 0 && offset > size {
+		offset = 0
+	}
+	if size >= 0 && offset == size {
+		fmt.Printf("   Using the download cached in %s\n", entry)
+		return nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header = c.headers.Clone()
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", meta.validator())
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("network error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0 && strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)):
+		fmt.Printf("   Resuming at %s\n", formatBytes(offset))
+		flags = os.O_WRONLY | os.O_APPEND
+	case resp.StatusCode < 200 || resp.StatusCode > 299 || resp.StatusCode == http.StatusPartialContent:
+		// A range answered from the wrong offset can't be appended; neither can an error
+		return &httpStatusError{URL: u, Code: resp.StatusCode}
+	case offset > 0:
+		fmt.Println("   The server sent the whole file (no range support, or it changed); starting over")
+	}
+
+	meta = downloadMeta{URL: u, ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(metaPath, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("download cache: %w", err)
+	}
+
+	out, err := os.OpenFile(dest, flags, 0644)
+	if err != nil {
+		return fmt.Errorf("download cache: %w", err)
+	}
+	_, err = io.Copy(out, resp.Body)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		if meta.validator() != "" {
+			return fmt.Errorf("%w (run again to resume from the cache)", err)
+		}
+		return fmt.Errorf("%w (the server gave no ETag or Last-Modified, so it can't be resumed)", err)
+	}
+	return nil
+}
+
+// verifyDownload checks a finished download's size and SHA256 against what's expected:
+// the index's Size and SHA256 and --expect-sha256, whichever are known
+func verifyDownload(debPath string, size int64, wantSHA256 ...string) (int64, error) {
+	f, err := os.Open(debPath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return 0, err
+	}
+	if size >= 0 && n != size {
+		return n, fmt.Errorf("downloaded %d bytes but the index lists %d", n, size)
+	}
+	got := hex.EncodeToString(h.Sum(nil))
+	for _, want := range wantSHA256 {
+		if want != "" && !strings.EqualFold(want, got) {
+			return n, fmt.Errorf("SHA256 mismatch: got %s, want %s", got, strings.ToLower(want))
+		}
+	}
+	return n, nil
+}
+
+// indexSize is a stanza's Size field, -1 when missing or malformed
+func indexSize(pkg map[string]string) int64 {
+	if size, err := strconv.ParseInt(pkg["Size"], 10, 64); err == nil {
+		return size
+	}
+	return -1
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"debug/macho"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// --- Root dylibs: theos packaging often drops them next to the executable ---
+// dyld only finds them there when the executable asks for @executable_path/Foo.dylib, or for
+// @rpath/Foo.dylib with an LC_RPATH pointing at the bundle root. Xcode-built apps only carry
+// @executable_path/Frameworks, so Frameworks/ is the one place @rpath reliably reaches.
+
+// Resolution of a root dylib against the main executable's load commands
+const (
+	DylibExecutablePath = "executable_path" // Loaded as @executable_path/<name>: found at the root
+	DylibRpathFound     = "rpath"           // Loaded as @rpath/<name>, and an LC_RPATH covers the root
+	DylibRpathMissing   = "rpath-missing"   // Loaded as @rpath/<name>, but no LC_RPATH covers the root
+	DylibAbsolute       = "absolute"        // Loaded by an absolute path outside the bundle, e.g. /Library/...
+	DylibUnreferenced   = "unreferenced"    // Not loaded by the main executable at all
+)
+
+// RootDylib is a .dylib at the top level of the bundle and how dyld would find it
+type RootDylib struct {
+	Path         string `json:"path"`                       // Bundle-relative, before any --relocate-dylibs
+	LoadPath     string `json:"loadPath,omitempty"`         // As the main executable's LC_LOAD_DYLIB names it
+	Status       string `json:"status"`                     // One of the Dylib* constants
+	RelocatedTo  string `json:"relocatedTo,omitempty"`      // With --relocate-dylibs
+	NeedsInstall bool   `json:"needsInstallName,omitempty"` // Relocated, but the load command still points elsewhere
+}
+
+// executableLoads returns the main executable's LC_LOAD_DYLIB names and LC_RPATHs, across slices
+func executableLoads(entries []BundleEntry, executableName string) (dylibs, rpaths []string) {
+	for _, entry := range entries {
+		if entry.RelPath != executableName || entry.File.IsDir || entry.File.IsLink {
+			continue
+		}
+		r, done, err := readerAt(entry.File)
+		if err != nil {
+			return nil, nil
+		}
+		defer done()
+		files, err := machoSlices(r)
+		if err != nil {
+			return nil, nil
+		}
+		for _, f := range files {
+			for _, load := range f.Loads {
+				switch l := load.(type) {
+				case *macho.Dylib:
+					dylibs = append(dylibs, l.Name)
+				case *macho.Rpath:
+					rpaths = append(rpaths, l.Path)
+				}
+			}
+		}
+		return dylibs, rpaths
+	}
+	return nil, nil
+}
+
+// checkRootDylibs finds .dylib files at the bundle root and resolves each against the main
+// executable. With relocate they move to Frameworks/, which is created if missing.
+func checkRootDylibs(entries []BundleEntry, executableName string, relocate bool, warnings *warningLog) ([]BundleEntry, []RootDylib) {
+	var roots []int
+	taken := make(map[string]bool)
+	hasFrameworks := false
+	for i, entry := range entries {
+		taken[entry.RelPath] = true
+		if entry.RelPath == "Frameworks" && entry.File.IsDir {
+			hasFrameworks = true
+		}
+		if !strings.Contains(entry.RelPath, "/") && strings.HasSuffix(entry.RelPath, ".dylib") && !entry.File.IsDir && !entry.File.IsLink {
+			roots = append(roots, i)
+		}
+	}
+	if len(roots) == 0 {
+		return entries, nil
+	}
+
+	loads, rpaths := executableLoads(entries, executableName)
+	rootRpath := rpathsInclude(rpaths, "")
+
+	var dylibs []RootDylib
+	var movedAt time.Time // Stamp for a Frameworks/ directory created for the relocated files
+	for _, i := range roots {
+		name := entries[i].RelPath
+		d := RootDylib{Path: name, Status: DylibUnreferenced}
+		for _, load := range loads {
+			if path.Base(load) != name {
+				continue
+			}
+			d.LoadPath = load
+			switch {
+			case load == "@executable_path/"+name || load == "@loader_path/"+name:
+				d.Status = DylibExecutablePath
+			case load == "@rpath/"+name && rootRpath:
+				d.Status = DylibRpathFound
+			case load == "@rpath/"+name:
+				d.Status = DylibRpathMissing
+			default:
+				d.Status = DylibAbsolute
+			}
+			break
+		}
+
+		if relocate {
+			dest := "Frameworks/" + name
+			if taken[dest] {
+				warnings.add("root-dylib-conflict", name, "Didn't relocate %s: %s already exists", name, dest)
+			} else {
+				entries[i].RelPath = dest
+				d.RelocatedTo = dest
+				movedAt = entries[i].File.ModTime
+				// Only @rpath loads follow the move, and only if an LC_RPATH reaches Frameworks/
+				d.NeedsInstall = d.LoadPath != "" && !(strings.HasPrefix(d.LoadPath, "@rpath/") && rpathsInclude(rpaths, "Frameworks"))
+			}
+		}
+		dylibs = append(dylibs, d)
+	}
+
+	if !movedAt.IsZero() && !hasFrameworks {
+		entries = append(entries, BundleEntry{
+			File:    &VirtualFile{Name: "Frameworks/", Mode: 0755, ModTime: movedAt, IsDir: true},
+			RelPath: "Frameworks",
+		})
+	}
+
+	sort.Slice(dylibs, func(a, b int) bool { return dylibs[a].Path < dylibs[b].Path })
+	return entries, dylibs
+}
+
+// rpathsInclude reports whether an LC_RPATH resolves to dir in the bundle ("" for its root)
+func rpathsInclude(rpaths []string, dir string) bool {
+	for _, rpath := range rpaths {
+		for _, prefix := range []string{"@executable_path", "@loader_path"} {
+			if rest, ok := strings.CutPrefix(rpath, prefix); ok && path.Clean("/"+rest) == path.Join("/", dir) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// printRootDylibs explains each root dylib's resolution, warning about the ones dyld won't find
+func printRootDylibs(dylibs []RootDylib, entries []BundleEntry, warnings *warningLog) {
+	hasFrameworks := false
+	for _, entry := range entries {
+		hasFrameworks = hasFrameworks || entry.RelPath == "Frameworks"
+	}
+
+	for _, d := range dylibs {
+		switch {
+		case d.RelocatedTo != "" && d.NeedsInstall:
+			warnings.add("root-dylib-load-path", d.RelocatedTo, "Moved %s to %s, but the executable loads it as %s; fix the load command, e.g. install_name_tool -change %s @rpath/%s, plus an @executable_path/Frameworks LC_RPATH",
+				d.Path, d.RelocatedTo, d.LoadPath, d.LoadPath, d.Path)
+		case d.RelocatedTo != "":
+			fmt.Printf("   Moved %s to %s\n", d.Path, d.RelocatedTo)
+		case d.Status == DylibExecutablePath || d.Status == DylibRpathFound:
+			fmt.Printf("   Root dylib: %s (loaded as %s)\n", d.Path, d.LoadPath)
+		case d.Status == DylibRpathMissing:
+			warnings.add("root-dylib-rpath", d.Path, "%s is loaded as %s, but no LC_RPATH reaches the bundle root; dyld won't find it (use --relocate-dylibs)", d.Path, d.LoadPath)
+		case d.Status == DylibAbsolute:
+			warnings.add("root-dylib-absolute", d.Path, "%s sits at the bundle root, but the executable loads %s, which doesn't exist on a stock device", d.Path, d.LoadPath)
+		default:
+			where := "the bundle root"
+			if hasFrameworks {
+				where += " next to Frameworks/"
+			}
+			warnings.add("root-dylib-unreferenced", d.Path, "%s sits at %s and the executable doesn't link it; only a tweak or dlopen would load it", d.Path, where)
+		}
+	}
+}
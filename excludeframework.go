@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// --- Excluded frameworks: known-bad libraries left out by name ---
+// Some bundled libraries (debugging servers like RevealServer and FLEX, analytics SDKs)
+// crash or get an app refused once sideloaded. --exclude-framework takes a glob on the
+// basename of a Frameworks/*.framework folder or a .dylib anywhere in the bundle, with or
+// without its extension, and leaves the whole thing out. A library the main executable
+// hard-links (a non-weak LC_LOAD_DYLIB) is still left out, as asked, but dyld aborts the
+// launch without it, so that's an error-severity warning, and with --strict a failure.
+
+// ExcludedFramework is a framework or dylib left out with --exclude-framework
+type ExcludedFramework struct {
+	Path     string `json:"path"`               // Bundle-relative, e.g. "Frameworks/FLEX.framework"
+	Pattern  string `json:"pattern"`            // The --exclude-framework glob it matched
+	Files    int    `json:"files"`              // Entries left out with it
+	Size     int64  `json:"size"`               // Their file data
+	LoadPath string `json:"loadPath,omitempty"` // The main executable's LC_LOAD_DYLIB for it, when it hard-links it
+}
+
+// checkFrameworkPatterns rejects --exclude-framework globs path.Match can't use
+func checkFrameworkPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil || strings.Contains(pattern, "/") {
+			return fmt.Errorf("--exclude-framework %q: want a glob on a framework or dylib name, e.g. FLEX or libReveal*", pattern)
+		}
+	}
+	return nil
+}
+
+// frameworkTarget is what --exclude-framework would leave out with an entry: the
+// Frameworks/*.framework folder holding it (tars don't always carry the folder's own entry),
+// or the entry itself when it's a .dylib file; "" for anything else
+func frameworkTarget(entry BundleEntry) string {
+	if rest, ok := strings.CutPrefix(entry.RelPath, "Frameworks/"); ok {
+		if name, _, _ := strings.Cut(rest, "/"); path.Ext(name) == ".framework" {
+			return "Frameworks/" + name
+		}
+	}
+	if !entry.File.IsDir && path.Ext(entry.RelPath) == ".dylib" {
+		return entry.RelPath
+	}
+	return ""
+}
+
+// frameworkPattern returns the first pattern matching a framework or dylib's name, with or
+// without its extension, or ""
+func frameworkPattern(target string, patterns []string) string {
+	base := path.Base(target)
+	ext := path.Ext(base)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, base); ok {
+			return pattern
+		}
+		if ok, _ := path.Match(pattern, strings.TrimSuffix(base, ext)); ok {
+			return pattern
+		}
+	}
+	return ""
+}
+
+// excludeFrameworks leaves out the frameworks and dylibs patterns match, framework folders
+// with everything inside, and notes which ones the main executable hard-links
+func excludeFrameworks(entries []BundleEntry, patterns []string, executableName string) ([]BundleEntry, []ExcludedFramework) {
+	if len(patterns) == 0 {
+		return entries, nil
+	}
+	patternOf := make(map[string]string)
+	for _, entry := range entries {
+		if target := frameworkTarget(entry); target != "" {
+			if pattern := frameworkPattern(target, patterns); pattern != "" {
+				patternOf[target] = pattern
+			}
+		}
+	}
+	if len(patternOf) == 0 {
+		return entries, nil
+	}
+	// excludedBy is the outermost excluded path holding relPath, itself included: a dylib
+	// inside an excluded framework goes with it
+	excludedBy := func(relPath string) string {
+		by := ""
+		for p := relPath; p != ""; p = parentDir(p) {
+			if patternOf[p] != "" {
+				by = p
+			}
+		}
+		return by
+	}
+
+	// debug/macho only parses LC_LOAD_DYLIB as a Dylib; weak and upward loads survive a
+	// missing library
+	loads, _ := executableLoads(entries, executableName)
+
+	var excluded []ExcludedFramework
+	index := make(map[string]int) // Excluded path to its place in excluded
+	kept := entries[:0]
+	for _, entry := range entries {
+		by := excludedBy(entry.RelPath)
+		if by == "" {
+			kept = append(kept, entry)
+			continue
+		}
+		i, ok := index[by]
+		if !ok {
+			i = len(excluded)
+			index[by] = i
+			excluded = append(excluded, ExcludedFramework{Path: by, Pattern: patternOf[by]})
+			for _, load := range loads {
+				if loadsExcluded(load, by) {
+					excluded[i].LoadPath = load
+					break
+				}
+			}
+		}
+		excluded[i].Files++
+		if !entry.File.IsDir && !entry.File.IsLink {
+			excluded[i].Size += entry.File.Size
+		}
+	}
+	sort.Slice(excluded, func(a, b int) bool { return excluded[a].Path < excluded[b].Path })
+	return kept, excluded
+}
+
+// loadsExcluded reports whether an LC_LOAD_DYLIB name points into an excluded framework
+// folder or at an excluded dylib, going by names since @rpath resolves at launch
+func loadsExcluded(load, excludedPath string) bool {
+	base := path.Base(excludedPath)
+	if path.Ext(base) == ".framework" {
+		return strings.Contains("/"+load, "/"+base+"/")
+	}
+	return path.Base(load) == base
+}
+
+// printExcludedFrameworks lists what --exclude-framework left out, warning about what the
+// app can't launch without
+func printExcludedFrameworks(excluded []ExcludedFramework, patterns []string, executableName string, warnings *warningLog) {
+	matched := make(map[string]bool)
+	for _, e := range excluded {
+		matched[e.Pattern] = true
+		size := formatBytes(e.Size)
+		if e.Files > 1 {
+			size = fmt.Sprintf("%s in %d entries", size, e.Files)
+		}
+		fmt.Printf("   Excluded %s (%s, --exclude-framework %s)\n", terminalSafe(e.Path), size, e.Pattern)
+		if e.LoadPath != "" {
+			warnings.add("excluded-framework-linked", e.Path, "%s hard-links %s (%s), which --exclude-framework %s left out; dyld will abort the app at launch",
+				executableName, e.Path, e.LoadPath, e.Pattern)
+		}
+	}
+	for _, pattern := range patterns {
+		if !matched[pattern] {
+			warnings.add("exclude-framework-unmatched", "", "--exclude-framework %s matched no framework or dylib in the bundle", pattern)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Where the main executable's name came from, for the summary and report
+const (
+	ExecutableFromPlist     = "Info.plist" // CFBundleExecutable, naming a file that exists
+	ExecutableFromHeuristic = "heuristic"  // guessExecutable's pick among the root Mach-O files
+	ExecutableFromFolder    = "folder"     // The .app folder name, with nothing better to go on
+	ExecutableFromOverride  = "override"   // --executable
+)
+
+// hasRootFile reports whether a regular file sits directly under the .app root with this name
+func hasRootFile(entries []BundleEntry, name string) bool {
+	for _, entry := range entries {
+		if entry.RelPath == name && !entry.File.IsDir && !entry.File.IsLink {
+			return true
+		}
+	}
+	return false
+}
+
+// guessExecutable picks the main binary among the Mach-O files directly under the .app
+// root: the one named like the folder, else the only one, else the largest. candidates
+// lists them all, largest first; name is "" when there are none.
+func guessExecutable(entries []BundleEntry, appNameFolder string) (name string, candidates []string) {
+	folderName := strings.TrimSuffix(appNameFolder, path.Ext(appNameFolder))
+	sizes := make(map[string]int64)
+	for _, entry := range entries {
+		vf := entry.File
+		if entry.RelPath == "" || strings.Contains(entry.RelPath, "/") || vf.IsDir || vf.IsLink || !sniffMachO(vf) {
+			continue
+		}
+		candidates = append(candidates, entry.RelPath)
+		sizes[entry.RelPath] = vf.Size
+	}
+	if len(candidates) == 0 {
+		return "", nil
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return sizes[candidates[i]] > sizes[candidates[j]] })
+
+	if _, ok := sizes[folderName]; ok {
+		return folderName, candidates
+	}
+	return candidates[0], candidates
+}
+
+// resolveExecutable settles on the main executable. --executable (override) beats everything;
+// then Info.plist's CFBundleExecutable, when it names a file at the bundle root; otherwise the
+// root Mach-O files are searched, so the real binary still gets its 0755 and its Mach-O checks.
+func resolveExecutable(entries []BundleEntry, appNameFolder, plistName, override string, warnings *warningLog) (name, source string, err error) {
+	if override != "" {
+		if !hasRootFile(entries, override) {
+			return "", "", fmt.Errorf("--executable %s: no such file directly under %s", override, appNameFolder)
+		}
+		if plistName != "" && plistName != override {
+			warnings.add("executable-override", override, "--executable %s differs from Info.plist's CFBundleExecutable %s, which is what iOS launches", override, plistName)
+		}
+		return override, ExecutableFromOverride, nil
+	}
+	if plistName != "" && hasRootFile(entries, plistName) {
+		return plistName, ExecutableFromPlist, nil
+	}
+
+	guess, candidates := guessExecutable(entries, appNameFolder)
+	reason := "Info.plist doesn't name an executable"
+	if plistName != "" {
+		reason = fmt.Sprintf("Info.plist names executable %q, which isn't in the bundle", plistName)
+	}
+	if guess == "" {
+		if plistName != "" {
+			warnings.add("executable-missing", plistName, "%s and no Mach-O sits at the bundle root; iOS won't find anything to launch", reason)
+			return plistName, ExecutableFromPlist, nil
+		}
+		name = strings.TrimSuffix(appNameFolder, path.Ext(appNameFolder))
+		warnings.add("executable-guessed", name, "%s and no Mach-O sits at the bundle root; assumed %q", reason, name)
+		return name, ExecutableFromFolder, nil
+	}
+
+	if len(candidates) == 1 {
+		warnings.add("executable-guessed", guess, "%s; used %s, the only Mach-O at the bundle root", reason, guess)
+	} else {
+		warnings.add("executable-guessed", guess, "%s; used %s among the Mach-O files at the bundle root: %s (--executable picks another)", reason, guess, strings.Join(candidates, ", "))
+	}
+	return guess, ExecutableFromHeuristic, nil
+}
+
+// minMachOSize is a Mach-O header's size (mach_header; mach_header_64 is 32 bytes). An
+// executable smaller than that can't be a binary at all.
+const minMachOSize = 28
+
+// defaultMinExecutableSize is --min-executable-size's default: real app binaries are
+// megabytes, and even an empty SwiftUI app is well over this
+const defaultMinExecutableSize = 16 << 10
+
+// ExecutableSizeError is returned when the main executable is too small to be a Mach-O,
+// like the zero-byte placeholders repos ship in place of a binary they may not distribute
+type ExecutableSizeError struct {
+	Name string
+	Size int64
+}
+
+func (e *ExecutableSizeError) Error() string {
+	return fmt.Sprintf("the main executable %s is %d bytes, too small to be a Mach-O (a placeholder for a binary the deb doesn't ship?); the IPA couldn't launch", e.Name, e.Size)
+}
+
+// checkExecutableSize fails on a main executable too small to be a Mach-O and warns on one
+// under warnBelow (0 for no warning), going by the tar header's size wherever the data went.
+// An executable that isn't in the bundle was already warned about.
+func checkExecutableSize(entries []BundleEntry, name string, warnBelow int64, warnings *warningLog) error {
+	for _, entry := range entries {
+		vf := entry.File
+		if entry.RelPath != name || vf.IsDir || vf.IsLink {
+			continue
+		}
+		switch {
+		case vf.Size < minMachOSize:
+			return &ExecutableSizeError{Name: name, Size: vf.Size}
+		case vf.Size < warnBelow:
+			warnings.add("executable-small", name, "The main executable %s is only %s (under --min-executable-size %s); an app binary is rarely this small, so check it isn't a stub",
+				name, formatBytes(vf.Size), formatBytes(warnBelow))
+		}
+		return nil
+	}
+	return nil
+}
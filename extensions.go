@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// bundleIDPattern is what iOS accepts for CFBundleIdentifier: alphanumerics, hyphens and periods
+var bundleIDPattern = regexp.MustCompile(`^[A-Za-z0-9-]+(\.[A-Za-z0-9-]+)*$`)
+
+// ExtensionID is an app extension's bundle ID, before and after --fix-extension-ids
+type ExtensionID struct {
+	Path     string `json:"path"`               // e.g. "PlugIns/Widget.appex"
+	Previous string `json:"previous,omitempty"` // Set when the ID was rewritten
+	BundleID string `json:"bundleId"`
+	Valid    bool   `json:"valid"` // Prefixed by the main app's ID
+}
+
+// isExtensionPlist reports whether relPath is the Info.plist of a PlugIns/ or Extensions/ appex
+func isExtensionPlist(relPath string) bool {
+	dir, file := path.Split(relPath)
+	dir = strings.TrimSuffix(dir, "/")
+	parent := path.Dir(dir)
+	return file == "Info.plist" && strings.HasSuffix(dir, ".appex") && (parent == "PlugIns" || parent == "Extensions")
+}
+
+// extensionSuffix is the part of an extension ID to keep under a new main ID: whatever
+// followed the original main ID, or else the last component ("com.other.share" -> "share")
+func extensionSuffix(id, originalMainID string) string {
+	if originalMainID != "" {
+		if rest, ok := strings.CutPrefix(id, originalMainID+"."); ok {
+			return rest
+		}
+	}
+	return id[strings.LastIndex(id, ".")+1:]
+}
+
+// checkExtensionIDs verifies every app extension's bundle ID is a child of mainID, which
+// installd requires. With fix, offenders are renamed to <mainID>.<suffix> in place.
+func checkExtensionIDs(entries []BundleEntry, mainID, originalMainID string, fix bool, store *SpillStore) ([]ExtensionID, error) {
+	var extensions []ExtensionID
+	for _, entry := range entries {
+		if !isExtensionPlist(entry.RelPath) || entry.File.IsDir || entry.File.IsLink {
+			continue
+		}
+		data, err := readAll(entry.File)
+		if err != nil {
+			return nil, err
+		}
+		ext := ExtensionID{Path: path.Dir(entry.RelPath), BundleID: plistValue(data, "CFBundleIdentifier")}
+		ext.Valid = mainID != "" && strings.HasPrefix(ext.BundleID, mainID+".")
+		if !ext.Valid && fix && mainID != "" && ext.BundleID != "" {
+			newID := mainID + "." + extensionSuffix(ext.BundleID, originalMainID)
+			updated, err := setPlistKeys(entry.RelPath, data, map[string]any{"CFBundleIdentifier": newID})
+			if err != nil {
+				return nil, fmt.Errorf("updating %s: %w", entry.RelPath, err)
+			}
+			if err := store.Replace(entry.File, updated); err != nil {
+				return nil, err
+			}
+			ext.Previous, ext.BundleID, ext.Valid = ext.BundleID, newID, true
+		}
+		extensions = append(extensions, ext)
+	}
+	sort.Slice(extensions, func(i, j int) bool { return extensions[i].Path < extensions[j].Path })
+	return extensions, nil
+}
+
+// printExtensionIDs lists the app extensions, flagging IDs installd will reject
+func printExtensionIDs(extensions []ExtensionID, mainID string, warnings *warningLog) {
+	for _, ext := range extensions {
+		switch {
+		case ext.Previous != "":
+			fmt.Printf("   Extension: %s -> %s (was %s)\n", terminalSafe(ext.Path), terminalSafe(ext.BundleID), terminalSafe(ext.Previous))
+		case ext.Valid:
+			fmt.Printf("   Extension: %s -> %s\n", terminalSafe(ext.Path), terminalSafe(ext.BundleID))
+		default:
+			warnings.add("extension-id", ext.Path, "Extension %s has ID %q, which isn't prefixed by %s; iOS will refuse to install the IPA (use --fix-extension-ids)",
+				ext.Path, ext.BundleID, mainID+".")
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// extractApp writes the selected bundle entries to opts.ExtractTo instead of zipping them.
+// It uses the same entry selection and permission logic as the IPA path, so what lands
+// on disk is exactly what would have been zipped.
+func extractApp(opts Options, entries []BundleEntry, appNameFolder, executableName string, execs *ExecPolicy, warnings *warningLog) error {
+	fmt.Println("=> [5/5] Extracting App Bundle...")
+
+	root, err := filepath.Abs(opts.ExtractTo)
+	if err != nil {
+		return err
+	}
+
+	bundleRoot := filepath.Join(root, appNameFolder)
+	switch {
+	case opts.NoPayloadDir:
+	case opts.Appex:
+		bundleRoot = filepath.Join(root, "Extensions", appNameFolder) // As in the --appex zip
+	default:
+		bundleRoot = filepath.Join(root, "Payload", appNameFolder)
+	}
+
+	bar := newProgressBar(bundleBytes(entries), "Extracting App")
+
+	// Symlinks are created last so no file is ever written through one,
+	// and directory mtimes are restored last so writing children doesn't bump them.
+	var links, dirs []BundleEntry
+	linkDests := make(map[string]string)
+	for _, entry := range entries {
+		if entry.File.IsLink {
+			linkDests[path.Clean(entry.RelPath)] = filepath.ToSlash(entry.File.LinkDest)
+		}
+	}
+
+	for _, entry := range entries {
+		vf := entry.File
+		target, err := safeExtractPath(bundleRoot, entry.RelPath)
+		if err != nil {
+			return err
+		}
+
+		perms, _, _ := entryPermissions(vf, path.Join(appNameFolder, entry.RelPath), executableName, execs)
+
+		switch {
+		case vf.IsLink:
+			links = append(links, entry)
+		case vf.IsDir:
+			// A symlink left here by an earlier --force run would be followed, chmod and all
+			if info, err := os.Lstat(target); err == nil && info.Mode()&os.ModeSymlink != 0 {
+				if err := os.Remove(target); err != nil {
+					return err
+				}
+			}
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			if err := os.Chmod(target, perms); err != nil {
+				return err
+			}
+			dirs = append(dirs, entry)
+		default:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := writeExtractedFile(target, vf, perms, bar); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, entry := range links {
+		if err := extractSymlink(bundleRoot, entry, linkDests, warnings); err != nil {
+			return err
+		}
+	}
+
+	// Deepest directories first, so restoring a parent's mtime is the final touch
+	for i := len(dirs) - 1; i >= 0; i-- {
+		target, _ := safeExtractPath(bundleRoot, dirs[i].RelPath)
+		if err := os.Chtimes(target, dirs[i].File.ModTime, dirs[i].File.ModTime); err != nil {
+			warnings.add("extract-mtime", dirs[i].RelPath, "Could not set the time of %s: %v", dirs[i].RelPath, err)
+		}
+	}
+
+	fmt.Printf("\n   Extracted to %s\n", bundleRoot)
+	return nil
+}
+
+// prepareExtractDir makes sure the target exists, refusing non-empty directories unless forced
+func prepareExtractDir(root string, force bool) error {
+	dirEntries, err := os.ReadDir(root)
+	if os.IsNotExist(err) {
+		return os.MkdirAll(root, 0755)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot use extract directory: %w", err)
+	}
+	if len(dirEntries) > 0 && !force {
+		return fmt.Errorf("extract directory %s is not empty (use --force to write into it anyway)", root)
+	}
+	return nil
+}
+
+// safeExtractPath joins a bundle-relative path onto bundleRoot, rejecting anything
+// that would land outside of it. This is the second line of defense after selectBundleEntries.
+// A folder on the way that's a symlink on disk, as a --force run into an earlier
+// extraction can find, is refused too: writing there would follow it.
+func safeExtractPath(bundleRoot, relPath string) (string, error) {
+	if relPath == "" {
+		return bundleRoot, nil
+	}
+	if !isLocalPath(relPath) {
+		return "", fmt.Errorf("refusing to extract outside the bundle: %s", relPath)
+	}
+	target := filepath.Join(bundleRoot, filepath.FromSlash(relPath))
+	rel, err := filepath.Rel(bundleRoot, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("refusing to extract outside the bundle: %s", relPath)
+	}
+	dir := bundleRoot
+	parts := strings.Split(rel, string(filepath.Separator))
+	for _, part := range parts[:len(parts)-1] {
+		dir = filepath.Join(dir, part)
+		if info, err := os.Lstat(dir); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("refusing to extract through the symlink %s: %s", dir, relPath)
+		}
+	}
+	return target, nil
+}
+
+func writeExtractedFile(target string, vf *VirtualFile, perms os.FileMode, bar io.Writer) error {
+	rc, err := vf.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	// Remove whatever was there first (possibly a symlink from a forced re-run)
+	os.Remove(target)
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_EXCL, perms)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(io.MultiWriter(f, bar), rc); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	// Chmod explicitly: the mode passed to OpenFile is filtered by the umask
+	if err := os.Chmod(target, perms); err != nil {
+		return err
+	}
+	return os.Chtimes(target, vf.ModTime, vf.ModTime)
+}
+
+// extractSymlink recreates a symlink entry. Links resolving outside the bundle, through
+// the other links (linkDests, by bundle path) included, are skipped.
+// On Windows, where creating symlinks needs a privilege most users lack, the link target
+// is copied in place instead.
+func extractSymlink(bundleRoot string, entry BundleEntry, linkDests map[string]string, warnings *warningLog) error {
+	vf := entry.File
+	target, err := safeExtractPath(bundleRoot, entry.RelPath)
+	if err != nil {
+		return err
+	}
+
+	linkDest := filepath.ToSlash(vf.LinkDest)
+	resolved := path.Join(path.Dir(entry.RelPath), linkDest)
+	if path.IsAbs(linkDest) || !isLocalPath(resolved) || !linkStaysInBundle(bundleRoot, entry.RelPath, linkDest, linkDests) {
+		warnings.add("symlink-outside", entry.RelPath, "Skipped symlink %s -> %s (points outside the bundle)", entry.RelPath, vf.LinkDest)
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	os.Remove(target)
+
+	err = os.Symlink(filepath.FromSlash(linkDest), target)
+	if err == nil {
+		if err := setLinkTimes(target, vf.ModTime); err != nil {
+			warnings.add("extract-mtime", entry.RelPath, "Could not set the time of %s: %v", entry.RelPath, err)
+		}
+		return nil
+	}
+	if runtime.GOOS != "windows" {
+		return err
+	}
+
+	// Windows fallback: copy what the link points at
+	src, _ := safeExtractPath(bundleRoot, resolved)
+	if err := copyTree(src, target); err != nil {
+		warnings.add("symlink-copy", entry.RelPath, "Could not create symlink %s -> %s: %v", entry.RelPath, vf.LinkDest, err)
+	}
+	return nil
+}
+
+// linkStaysInBundle resolves a link's target the way the file system will, following any
+// symlink it steps through (links, the bundle's link entries by path, or one on disk under
+// bundleRoot from an earlier run), and reports whether it stays in the bundle. The lexical
+// check alone passes b -> a/.. beside a -> ., which lands in the bundle's parent.
+func linkStaysInBundle(bundleRoot, relPath, linkDest string, links map[string]string) bool {
+	var parts []string
+	if dir := path.Dir(relPath); dir != "." {
+		parts = strings.Split(dir, "/")
+	}
+	steps := strings.Split(linkDest, "/")
+	for hops := 0; len(steps) > 0; {
+		step := steps[0]
+		steps = steps[1:]
+		switch step {
+		case "", ".":
+			continue
+		case "..":
+			if len(parts) == 0 {
+				return false
+			}
+			parts = parts[:len(parts)-1]
+			continue
+		}
+		parts = append(parts, step)
+		p := strings.Join(parts, "/")
+		dest, ok := links[p]
+		if !ok {
+			info, err := os.Lstat(filepath.Join(bundleRoot, filepath.FromSlash(p)))
+			if err != nil || info.Mode()&os.ModeSymlink == 0 {
+				continue
+			}
+			if dest, err = os.Readlink(filepath.Join(bundleRoot, filepath.FromSlash(p))); err != nil {
+				return false
+			}
+			dest = filepath.ToSlash(dest)
+		}
+		if hops++; hops > maxLinkHops || path.IsAbs(dest) {
+			return false
+		}
+		parts = parts[:len(parts)-1]
+		steps = append(strings.Split(dest, "/"), steps...)
+	}
+	return true
+}
+
+// copyTree copies a file or directory tree from src to dst, following symlinks
+func copyTree(src, dst string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		in, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, in); err != nil {
+			out.Close()
+			return err
+		}
+		if err := out.Close(); err != nil {
+			return err
+		}
+		return os.Chtimes(dst, info.ModTime(), info.ModTime())
+	}
+
+	if err := os.MkdirAll(dst, info.Mode().Perm()); err != nil {
+		return err
+	}
+	children, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		if err := copyTree(filepath.Join(src, child.Name()), filepath.Join(dst, child.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// TestExtractSymlinkEscapes extracts links that only get out of the bundle through
+// another symlink, a chained entry or one left by an earlier run, and checks nothing
+// lands outside it while a link through a link that stays inside is kept
+func TestExtractSymlinkEscapes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("links are copied on Windows")
+	}
+	now := time.Now()
+	dir := func(rel string) BundleEntry {
+		return BundleEntry{RelPath: rel, File: &VirtualFile{IsDir: true, Mode: 0755, ModTime: now}}
+	}
+	file := func(rel string) BundleEntry {
+		return BundleEntry{RelPath: rel, File: &VirtualFile{Mode: 0644, Size: 2, Data: []byte("hi"), ModTime: now}}
+	}
+	link := func(rel, dest string) BundleEntry {
+		return BundleEntry{RelPath: rel, File: &VirtualFile{IsLink: true, LinkDest: dest, ModTime: now}}
+	}
+	root := t.TempDir()
+	bundleRoot := filepath.Join(root, "Fixture.app")
+	extract := func(entries ...BundleEntry) (warningLog, error) {
+		var warnings warningLog
+		var err error
+		captureOutput(t, func() {
+			err = extractApp(Options{ExtractTo: root, NoPayloadDir: true}, entries, "Fixture.app", "Fixture", nil, &warnings)
+		})
+		return warnings, err
+	}
+
+	// a -> . is fine, but b -> a/.. resolves to the bundle's parent on disk
+	warnings, err := extract(dir(""), link("a", "."), link("b", "a/.."), link("c", "a/Info.plist"), file("Info.plist"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(filepath.Join(bundleRoot, "b")); !os.IsNotExist(err) {
+		t.Errorf("b -> a/.. was created (%v)", err)
+	}
+	if len(warnings.list) != 1 || warnings.list[0].Code != "symlink-outside" || warnings.list[0].Path != "b" {
+		t.Errorf("warnings %+v, want symlink-outside for b alone", warnings.list)
+	}
+	if data, err := os.ReadFile(filepath.Join(bundleRoot, "c")); err != nil || string(data) != "hi" {
+		t.Errorf("c -> a/Info.plist reads %q (%v), want the plist through a", data, err)
+	}
+
+	// A --force re-run finds a link from before; nothing is written through it
+	if err := os.Symlink("..", filepath.Join(bundleRoot, "up")); err != nil {
+		t.Skip("no symlinks here:", err)
+	}
+	if _, err := extract(dir(""), file("up/escaped")); err == nil {
+		t.Error("extracting up/escaped through up -> .. succeeded")
+	}
+	if _, err := os.Stat(filepath.Join(root, "escaped")); !os.IsNotExist(err) {
+		t.Errorf("wrote outside the bundle (%v)", err)
+	}
+	if _, err := extract(dir(""), link("d", "up/Fixture.app")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(filepath.Join(bundleRoot, "d")); !os.IsNotExist(err) {
+		t.Errorf("d -> up/Fixture.app was created (%v)", err)
+	}
+
+	// A directory entry where a link was is made a directory, not followed
+	if _, err := extract(dir(""), dir("up"), file("up/inside")); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Lstat(filepath.Join(bundleRoot, "up")); err != nil || !info.IsDir() {
+		t.Errorf("up is %v (%v), want a directory", info, err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "inside")); !os.IsNotExist(err) {
+		t.Errorf("wrote outside the bundle (%v)", err)
+	}
+}
+
+// TestExtractLinkTimes gives links and directories the entries' times, not the extraction's
+func TestExtractLinkTimes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("links are copied on Windows")
+	}
+	stamp := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	entries := []BundleEntry{
+		{RelPath: "", File: &VirtualFile{IsDir: true, Mode: 0755, ModTime: stamp}},
+		{RelPath: "Info.plist", File: &VirtualFile{Mode: 0644, Size: 2, Data: []byte("hi"), ModTime: stamp}},
+		{RelPath: "link", File: &VirtualFile{IsLink: true, LinkDest: "Info.plist", ModTime: stamp}},
+	}
+	root := t.TempDir()
+	var warnings warningLog
+	var err error
+	captureOutput(t, func() {
+		err = extractApp(Options{ExtractTo: root, NoPayloadDir: true}, entries, "Fixture.app", "Fixture", nil, &warnings)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings.list) != 0 {
+		t.Errorf("warnings %+v", warnings.list)
+	}
+	for _, name := range []string{"", "link"} {
+		info, err := os.Lstat(filepath.Join(root, "Fixture.app", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.ModTime().Equal(stamp) {
+			t.Errorf("%q: time %v, want the entry's %v", name, info.ModTime(), stamp)
+		}
+	}
+}
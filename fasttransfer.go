@@ -0,0 +1,105 @@
+package main
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"fmt"
+	"io"
+)
+
+// --- --fast-transfer: a stored IPA for fast links ---
+// Over fast Wi-Fi or USB, sending an IPA to a device takes less time than deflating it
+// here and inflating it there. --fast-transfer stores every entry and turns off the
+// optional work that costs CPU without changing what installs: PNG normalization, spill
+// compression and --verify-output's read-back. --store-glob/--deflate-glob still apply
+// on top of it, as does everything that changes the bundle's contents. The summary then
+// estimates what a deflated build would weigh, from a sample of the files compressed.
+
+// sizeSampleBudget is about how much file data the deflate estimate compresses
+const sizeSampleBudget = 4 << 20
+
+// sizeSampleMin is the least sampled from a file, so small files are represented at all
+const sizeSampleMin = 512
+
+// SizeEstimate compares the IPA written with what a deflated build would weigh
+type SizeEstimate struct {
+	Written      int64   `json:"written"`      // The IPA's size
+	FileBytes    int64   `json:"fileBytes"`    // Stored file data in it
+	Deflated     int64   `json:"deflated"`     // Estimated IPA size with that data deflated
+	SampledBytes int64   `json:"sampledBytes"` // File data compressed for the estimate
+	Ratio        float64 `json:"ratio"`        // Deflated / original bytes over the sample
+}
+
+// applyFastTransfer sets what --fast-transfer implies, saying which flags given it overrides
+func applyFastTransfer(opts *Options) {
+	if opts.NormalizePNGs {
+		fmt.Println("⚠️  --fast-transfer: skipping --normalize-pngs")
+	}
+	if opts.VerifyOutput {
+		fmt.Println("⚠️  --fast-transfer: skipping --verify-output")
+	}
+	opts.NormalizePNGs, opts.VerifyOutput = false, false
+	opts.CompressSpill = SpillCompressOff
+	// First, so any --store-glob or --deflate-glob given wins over it
+	opts.MethodGlobs = append([]methodGlob{{Pattern: "**", Method: zip.Store}}, opts.MethodGlobs...)
+}
+
+// estimateDeflated estimates the size of ipaSize bytes of IPA with its stored files
+// deflated. Each file gives the sample a share in proportion to its size, read from its
+// start, so the sample's ratio stands for the bundle's.
+func estimateDeflated(entries []BundleEntry, ipaSize int64) (*SizeEstimate, error) {
+	var total int64
+	for _, entry := range entries {
+		if vf := entry.File; !vf.IsDir && !vf.IsLink {
+			total += vf.Size
+		}
+	}
+	est := &SizeEstimate{Written: ipaSize, FileBytes: total, Deflated: ipaSize, Ratio: 1}
+	if total == 0 {
+		return est, nil
+	}
+
+	counter := &countingWriter{w: io.Discard}
+	fw, err := flate.NewWriter(counter, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		vf := entry.File
+		if vf.IsDir || vf.IsLink || vf.Size == 0 {
+			continue
+		}
+		share := vf.Size
+		if total > sizeSampleBudget {
+			share = min(vf.Size, max(vf.Size*sizeSampleBudget/total, sizeSampleMin))
+		}
+		r, err := vf.Open()
+		if err != nil {
+			return nil, err
+		}
+		fw.Reset(counter)
+		n, err := io.CopyN(fw, r, share)
+		r.Close()
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("%s: %w", entry.RelPath, err)
+		}
+		if err := fw.Close(); err != nil {
+			return nil, err
+		}
+		est.SampledBytes += n
+	}
+	if est.SampledBytes > 0 {
+		est.Ratio = min(float64(counter.n)/float64(est.SampledBytes), 1)
+	}
+	est.Deflated = ipaSize - total + int64(float64(total)*est.Ratio)
+	return est, nil
+}
+
+// printSizeEstimate puts the stored IPA's size beside the estimated deflated one
+func printSizeEstimate(est *SizeEstimate) {
+	if est == nil || est.SampledBytes == 0 {
+		return
+	}
+	fmt.Printf("   Stored: %s; with every file deflated it would be about %s (%.0f%% smaller), estimated from %s of the files\n",
+		formatBytes(est.Written), formatBytes(est.Deflated), 100*float64(est.Written-est.Deflated)/float64(est.Written), formatBytes(est.SampledBytes))
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// --- Include/Exclude Filters ---
+// Patterns are matched against the bundle-relative path ("Frameworks/Foo.framework/Foo") with
+// doublestar semantics: "*" stays within one path segment, "**" spans any number of segments,
+// and {a,b} alternatives are expanded. A pattern that matches a directory covers its subtree.
+
+// globRule is one --include or --exclude pattern
+type globRule struct {
+	Pattern  string
+	Flag     string // "include" or "exclude", for messages
+	Matches  int
+	variants [][]string // Brace-expanded pattern, split into segments
+}
+
+// prunedDir is an excluded directory nothing below which can be included
+type prunedDir struct {
+	dir  string
+	rule *globRule
+}
+
+// PathFilter decides which bundle entries are dropped. A nil *PathFilter keeps everything.
+type PathFilter struct {
+	Include []*globRule
+	Exclude []*globRule
+	pruned  []prunedDir
+	pending map[string]map[*globRule]int // Matches in bundle folders the app isn't chosen from yet, by folder
+}
+
+// newPathFilter compiles --include and --exclude patterns; nil when there are none
+func newPathFilter(include, exclude []string) (*PathFilter, error) {
+	if len(include) == 0 && len(exclude) == 0 {
+		return nil, nil
+	}
+	f := &PathFilter{}
+	for _, list := range []struct {
+		flag     string
+		patterns []string
+		rules    *[]*globRule
+	}{{"include", include, &f.Include}, {"exclude", exclude, &f.Exclude}} {
+		for _, pattern := range list.patterns {
+			rule, err := compileGlob(pattern, list.flag)
+			if err != nil {
+				return nil, err
+			}
+			*list.rules = append(*list.rules, rule)
+		}
+	}
+	return f, nil
+}
+
+// compileGlob expands and splits a pattern given to --flag
+func compileGlob(pattern, flag string) (*globRule, error) {
+	rule := &globRule{Pattern: pattern, Flag: flag}
+	for _, expanded := range expandBraces(strings.Trim(pattern, "/")) {
+		segments := strings.Split(expanded, "/")
+		for _, segment := range segments {
+			if _, err := path.Match(segment, ""); err != nil {
+				return nil, fmt.Errorf("bad --%s pattern %q: %w", flag, pattern, err)
+			}
+		}
+		rule.variants = append(rule.variants, segments)
+	}
+	return rule, nil
+}
+
+// Excluded reports whether the entry at relPath should be dropped, counting the match against
+// the deciding pattern
+func (f *PathFilter) Excluded(relPath string, isDir bool) bool {
+	rule, excluded := f.decide(relPath, isDir)
+	if rule != nil {
+		rule.Matches++
+	}
+	return excluded
+}
+
+// ExcludedIn is Excluded for an entry of the bundle folder at prefix, read before the app
+// folder is chosen: the match is held until settle says whether prefix is the app's
+func (f *PathFilter) ExcludedIn(prefix, relPath string, isDir bool) bool {
+	rule, excluded := f.decide(relPath, isDir)
+	if rule != nil {
+		if f.pending == nil {
+			f.pending = make(map[string]map[*globRule]int)
+		}
+		if f.pending[prefix] == nil {
+			f.pending[prefix] = make(map[*globRule]int)
+		}
+		f.pending[prefix][rule]++
+	}
+	return excluded
+}
+
+// settle counts the held matches of the app folder chosen at prefix, and drops the other
+// folders'; a decoy's entries aren't the app's, whatever the patterns did to them
+func (f *PathFilter) settle(prefix string) {
+	if f == nil {
+		return
+	}
+	for rule, n := range f.pending[prefix] {
+		rule.Matches += n
+	}
+	f.pending = nil
+}
+
+// decide returns the pattern deciding the entry at relPath, nil when none does, and whether
+// it's dropped. Include wins over exclude. An excluded directory that no include pattern can
+// reach into is remembered, so everything below it is dropped by a prefix check.
+func (f *PathFilter) decide(relPath string, isDir bool) (*globRule, bool) {
+	if f == nil || relPath == "" {
+		return nil, false
+	}
+	for _, p := range f.pruned {
+		if strings.HasPrefix(relPath, p.dir+"/") {
+			return p.rule, true
+		}
+	}
+
+	segments := strings.Split(relPath, "/")
+	if rule := matchRules(f.Include, segments); rule != nil {
+		return rule, false
+	}
+	rule := matchRules(f.Exclude, segments)
+	if rule == nil {
+		return nil, false
+	}
+	if isDir && !f.includeBelow(segments) {
+		f.pruned = append(f.pruned, prunedDir{dir: relPath, rule: rule})
+	}
+	return rule, true
+}
+
+// printSummary reports what each pattern matched and warns about patterns that matched nothing
+func (f *PathFilter) printSummary(warnings *warningLog) {
+	if f == nil {
+		return
+	}
+	for _, rule := range append(f.Include, f.Exclude...) {
+		if rule.Matches == 0 {
+			warnings.add("filter-unmatched", "", "--%s %q matched nothing", rule.Flag, rule.Pattern)
+			continue
+		}
+		verb := "Excluded"
+		if rule.Flag == "include" {
+			verb = "Kept"
+		}
+		fmt.Printf("   %s %d entr%s matching %q\n", verb, rule.Matches, map[bool]string{true: "y", false: "ies"}[rule.Matches == 1], rule.Pattern)
+	}
+}
+
+// includeBelow reports whether any include pattern could match something inside dir
+func (f *PathFilter) includeBelow(dir []string) bool {
+	for _, rule := range f.Include {
+		for _, variant := range rule.variants {
+			if couldMatchBelow(variant, dir) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchRules returns the first rule matching the path or one of its parent directories
+func matchRules(rules []*globRule, segments []string) *globRule {
+	for _, rule := range rules {
+		for _, variant := range rule.variants {
+			for n := len(segments); n > 0; n-- {
+				if matchSegments(variant, segments[:n]) {
+					return rule
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// matchSegments matches a split pattern against a split path
+func matchSegments(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	ok, _ := path.Match(pattern[0], segments[0])
+	return ok && matchSegments(pattern[1:], segments[1:])
+}
+
+// couldMatchBelow reports whether pattern can match some path that starts with dir and goes deeper
+func couldMatchBelow(pattern, dir []string) bool {
+	if len(dir) == 0 {
+		return len(pattern) > 0
+	}
+	if len(pattern) == 0 {
+		return false
+	}
+	if pattern[0] == "**" {
+		return true
+	}
+	ok, _ := path.Match(pattern[0], dir[0])
+	return ok && couldMatchBelow(pattern[1:], dir[1:])
+}
+
+// expandBraces turns "*.{mp4,mov}" into "*.mp4" and "*.mov". Nested braces are supported.
+func expandBraces(pattern string) []string {
+	start := strings.IndexByte(pattern, '{')
+	if start < 0 {
+		return []string{pattern}
+	}
+	depth, end := 0, -1
+	var alternatives []string
+	last := start + 1
+	for i := start; i < len(pattern) && end < 0; i++ {
+		switch pattern[i] {
+		case '{':
+			depth++
+		case ',':
+			if depth == 1 {
+				alternatives = append(alternatives, pattern[last:i])
+				last = i + 1
+			}
+		case '}':
+			depth--
+			if depth == 0 {
+				alternatives = append(alternatives, pattern[last:i])
+				end = i
+			}
+		}
+	}
+	if end < 0 {
+		return []string{pattern} // Unbalanced: treat literally
+	}
+
+	var out []string
+	for _, alt := range alternatives {
+		out = append(out, expandBraces(pattern[:start]+alt+pattern[end+1:])...)
+	}
+	return out
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"archive/zip"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// --- fix-perms: repairing an existing IPA's modes without the deb ---
+// IPAs from older versions of this tool, or from other converters, can carry external
+// attributes iOS refuses (a main executable without its x bits, no Unix attributes at all).
+// deb-to-ipa fix-perms app.ipa re-derives every entry's mode with the rules a conversion
+// uses (see Mode laundering) and rewrites only the headers: each entry's compressed bytes,
+// method, CRC32 and timestamps are copied through as they are. Running it twice changes
+// nothing the second time. A symlink stored without Unix attributes can't be told from a
+// file any more and stays one.
+
+// creatorUnix is the "made by" host in a zip header's CreatorVersion: without it, readers
+// ignore the Unix mode in ExternalAttrs
+const creatorUnix = 3
+
+// PermissionFix is one entry fix-perms gave a new mode
+type PermissionFix struct {
+	Name   string
+	From   uint32 // Unix mode from ExternalAttrs, 0 when the entry had none
+	To     uint32
+	Reason string // "executable" or "Mach-O" when it became executable, as in launderModes
+}
+
+// FixPermissions copies the zip in r to w with every entry's Unix mode set the way a
+// conversion sets it; nothing but the modes changes
+func FixPermissions(r io.ReaderAt, size int64, w io.Writer) error {
+	_, _, err := fixPermissions(r, size, w)
+	return err
+}
+
+// fixPermissions is FixPermissions, also returning the entries it changed and the total
+func fixPermissions(r io.ReaderAt, size int64, w io.Writer) ([]PermissionFix, int, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid zip archive: %w", err)
+	}
+	appPrefix, executableName := fixPermsApp(zr)
+
+	zw := zip.NewWriter(w)
+	if err := zw.SetComment(zr.Comment); err != nil {
+		return nil, 0, err
+	}
+	var fixes []PermissionFix
+	for _, zf := range zr.File {
+		header := zf.FileHeader
+		mode, unixFileType, reason, err := fixedMode(zf, appPrefix, executableName)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%s: %w", zf.Name, err)
+		}
+		attrs := (unixFileType | uint32(mode)) << 16
+		if header.CreatorVersion>>8 != creatorUnix || header.ExternalAttrs != attrs {
+			from := uint32(0)
+			if header.CreatorVersion>>8 == creatorUnix {
+				from = header.ExternalAttrs >> 16
+			}
+			fixes = append(fixes, PermissionFix{Name: zf.Name, From: from, To: attrs >> 16, Reason: reason})
+		}
+		header.CreatorVersion = creatorUnix<<8 | header.CreatorVersion&0xff
+		header.ExternalAttrs = attrs
+
+		dst, err := zw.CreateRaw(&header)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%s: %w", zf.Name, err)
+		}
+		if strings.HasSuffix(zf.Name, "/") {
+			continue // CreateRaw takes no data for a folder
+		}
+		src, err := zf.OpenRaw()
+		if err != nil {
+			return nil, 0, fmt.Errorf("%s: %w", zf.Name, err)
+		}
+		if _, err := io.Copy(dst, src); err != nil {
+			return nil, 0, fmt.Errorf("%s: %w", zf.Name, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, 0, err
+	}
+	return fixes, len(zr.File), nil
+}
+
+// fixPermsApp finds the archive's app folder and its CFBundleExecutable, either "" when
+// there's none
+func fixPermsApp(zr *zip.Reader) (appPrefix, executableName string) {
+	for _, zf := range zr.File {
+		if appPrefix = bundlePrefix(tarEntryName(zf.Name), BundleExtApp); appPrefix != "" {
+			break
+		}
+	}
+	if appPrefix == "" {
+		return "", ""
+	}
+	for _, zf := range zr.File {
+		if tarEntryName(zf.Name) == appPrefix+"Info.plist" {
+			if data, err := readZipEntry(zf); err == nil {
+				executableName = plistValue(data, "CFBundleExecutable")
+			}
+			break
+		}
+	}
+	return appPrefix, executableName
+}
+
+// fixedMode is the mode and Unix file type conversion would give a zip entry, with
+// modeDecision's reason. Only a regular file's first bytes are read, to sniff Mach-O.
+func fixedMode(zf *zip.File, appPrefix, executableName string) (os.FileMode, uint32, string, error) {
+	mode := zf.Mode()
+	vf := &VirtualFile{
+		Name:   zf.Name,
+		Mode:   int64(mode.Perm()),
+		IsDir:  mode.IsDir() || strings.HasSuffix(zf.Name, "/"),
+		IsLink: mode&os.ModeSymlink != 0,
+	}
+	if zf.CreatorVersion>>8 != creatorUnix {
+		vf.Mode = 0 // Go's 0666 for MS-DOS attributes isn't a mode anyone chose; launder from nothing
+	}
+	if !vf.IsDir && !vf.IsLink && vf.Mode&0111 == 0 {
+		rc, err := zf.Open()
+		if err != nil {
+			return 0, 0, "", err
+		}
+		vf.Data = make([]byte, 4)
+		n, _ := io.ReadFull(rc, vf.Data)
+		vf.Data = vf.Data[:n]
+		rc.Close()
+	}
+
+	// modeDecision wants the name from the app folder on, as the conversion passes it
+	name := tarEntryName(zf.Name)
+	if appPrefix != "" && inAppPrefix(name, appPrefix) {
+		name = path.Join(path.Base(appPrefix), appRelPath(name, appPrefix))
+	} else {
+		executableName = ""
+	}
+	perms, reason := modeDecision(vf, name, executableName, nil)
+	switch {
+	case vf.IsLink:
+		return perms, unixTypeLink, reason, nil
+	case vf.IsDir:
+		return perms, unixTypeDir, reason, nil
+	}
+	return perms, unixTypeReg, reason, nil
+}
+
+// runFixPerms implements the fix-perms subcommand: 0 fixed (or nothing to fix), 2 error
+func runFixPerms(args []string) int {
+	fs := flag.NewFlagSet("fix-perms", flag.ContinueOnError)
+	output := fs.String("o", "", "write the fixed IPA here instead of replacing the input")
+	verbose := fs.Bool("v", false, "list every entry whose mode changed")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: deb-to-ipa fix-perms [-v] [-o fixed.ipa] <app.ipa>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	input := fs.Arg(0)
+	dest := valueOr(*output, input)
+
+	fmt.Printf("🔎 Fixing permissions in %s\n", input)
+	fixes, total, err := fixPermsFile(input, dest)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Error: %s: %v\n", input, err)
+		return 2
+	}
+	if *verbose {
+		for _, fix := range fixes {
+			reason := ""
+			if fix.Reason != "" {
+				reason = " (" + fix.Reason + ")"
+			}
+			fmt.Printf("   mode %s: %06o -> %06o%s\n", terminalSafe(fix.Name), fix.From, fix.To, reason)
+		}
+	}
+	if len(fixes) == 0 && dest == input {
+		fmt.Printf("   ✅ All %d entries already had the right modes; %s is unchanged\n", total, input)
+	} else {
+		fmt.Printf("   ✅ Fixed %d of %d entries: %s\n", len(fixes), total, dest)
+	}
+	return 0
+}
+
+// fixPermsFile fixes input's modes into dest through a partial file beside it, so dest is
+// never seen half-written. An input already right is left alone rather than rewritten.
+func fixPermsFile(input, dest string) ([]PermissionFix, int, error) {
+	in, err := os.Open(input)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	dir := filepath.Dir(dest)
+	partial, err := os.CreateTemp(dir, "."+filepath.Base(dest)+".*.partial")
+	if err != nil {
+		return nil, 0, outputDirError(dir, err)
+	}
+	defer os.Remove(partial.Name()) // A no-op once moved into place
+	fixes, total, err := fixPermissions(in, info.Size(), partial)
+	if err == nil {
+		err = syncFile(partial)
+	}
+	if closeErr := partial.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil || (len(fixes) == 0 && dest == input) {
+		return fixes, total, err
+	}
+	if err := os.Chmod(partial.Name(), 0644); err != nil {
+		return nil, 0, err
+	}
+	in.Close() // Windows can't replace a file that's still open
+	return fixes, total, moveIntoPlace(partial.Name(), dest)
+}
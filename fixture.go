@@ -0,0 +1,703 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	ar "github.com/erikgeiser/ar"
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// --- Fixtures: synthetic debs for tests and demos ---
+// buildFixture writes a complete, valid deb from a FixtureSpec, so tests don't need
+// binary blobs in the repo. The hidden `deb-to-ipa mkfixture` command exposes it.
+
+// FixtureSpec describes the deb buildFixture produces. The zero value is a gzip deb
+// holding one small Fixture.app.
+type FixtureSpec struct {
+	Compression  string            // Member compression: gz, xz, lzma, zst or none (default gz)
+	ControlComp  string            // control.tar's compression, when it differs from Compression
+	DataFirst    bool              // Put data.tar before control.tar, like some hand-built debs
+	Package      string            // Control Package field (default com.example.fixture)
+	Version      string            // Control Version field (default 1.0)
+	Control      map[string]string // Extra control fields, e.g. Depends
+	Apps         []string          // .app folder names without the extension (default Fixture); several make a multi-app deb
+	Rootless     bool              // Install under var/jb/ like rootless jailbreaks
+	BinaryPlist  bool              // Write Info.plist in binary form
+	PlistPad     int               // Pad each app's Info.plist with a filler string past this many bytes
+	Framework    bool              // Add Frameworks/Fixture.framework with its own Info.plist and binary
+	FrameworkPad int               // Pad the framework binary with zeros to this many bytes
+	Symlinks     bool              // Add relative symlinks inside the bundle
+	EmptyDir     bool              // Add an empty Base.lproj folder to the bundle
+	AppClip      bool              // Add AppClips/<Name>Clip.app ahead of the rest of the app, its binary recorded 0644
+	Dylib        bool              // Add libFixture.dylib at the bundle root, recorded as mode 0644
+	Arm64e       bool              // Build the app's Mach-O files as arm64e instead of arm64
+	Build        string            // Info.plist CFBundleVersion (default 1)
+	Links        []string          // LC_LOAD_DYLIB names in the main executable, e.g. "@rpath/Fixture.framework/Fixture"; a "weak:" prefix makes one LC_LOAD_WEAK_DYLIB
+	Placeholder  bool              // Write the main executable as an empty file, like repos that strip a binary they may not distribute
+	ExecFolder   bool              // Put a resource folder named like the executable at the bundle root, holding a same-named file, and the Mach-O beside it as <Name>-bin
+	LargeFile    int64             // Size of a zero-filled large.bin, to exercise spilling; 0 for none
+	ExtraData    int64             // Size of a zero-filled usr/bin/fixture-data, outside the app; 0 for none
+	HostileNames bool              // Add entries with unicode, spaces, backslashes, "..", very long names and case collisions
+	ZeroModes    bool              // Record mode 0 on every data.tar entry, like some GUI packagers
+	UID          int64             // Record this uid and gid on every data.tar entry without user or group names, like tar --numeric-owner in a container; 0 for root:wheel
+	LongPaths    bool              // Add asset paths over 300 characters once inside Payload/<App>.app/
+	PAX          bool              // PAX extras: a global header, sub-second mtimes, xattr records and a long name without "./"
+	Launcher     string            // Install each app outside Applications/ behind a launcher symlink there: "before" (absolute link ahead of the bundle), "after" (relative link behind it) or "bare" (bundle folder under opt/ not named like one, link after)
+	Decoy        bool              // Add a theme's Decoy.app folder, neither an app nor under Applications/, ahead of the apps
+	HostilePlist bool              // Put escape sequences and path separators in every Info.plist string and the .app folder's name
+	AppSuffix    string            // Appended to each .app folder's name after the extension, e.g. "-1.3" for Foo.app-1.3
+	ModTime      time.Time         // Timestamp for every entry (default 2020-01-01 UTC)
+	Nested       int               // Wrap the deb this many times in installers without an app, each carrying the next as var/cache/<Package>/installer-<N>.deb
+}
+
+// fixtureHostileValue is appended to strings with HostilePlist: a window title change, a
+// color, a C1 control sequence introducer and a path climbing out with both separators
+const fixtureHostileValue = "\x1b]0;pwned\x07\x1b[31m\u009b2J/../..\\evil"
+
+// fixtureMachO is a minimal arm64 MH_EXECUTE header: enough for magic sniffing and debug/macho
+var fixtureMachO = func() []byte {
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, []uint32{0xfeedfacf, 0x0100000c, 0, 2, 0, 0, 0, 0})
+	return append(b.Bytes(), make([]byte, 64)...)
+}()
+
+// fixtureMachOLoading is machO with a dylib load command for each of links appended
+// ("weak:" before a name for LC_LOAD_WEAK_DYLIB), ncmds and sizeofcmds to match
+func fixtureMachOLoading(machO []byte, links []string) []byte {
+	var cmds bytes.Buffer
+	for _, link := range links {
+		cmd := uint32(0xc) // LC_LOAD_DYLIB
+		if name, ok := strings.CutPrefix(link, "weak:"); ok {
+			cmd, link = 0x80000018, name
+		}
+		name := append([]byte(link), 0)
+		name = append(name, make([]byte, (8-(24+len(name))%8)%8)...)
+		binary.Write(&cmds, binary.LittleEndian, []uint32{cmd, uint32(24 + len(name)), 24, 0, 0x10000, 0x10000})
+		cmds.Write(name)
+	}
+	out := append(bytes.Clone(machO[:32]), cmds.Bytes()...)
+	binary.LittleEndian.PutUint32(out[16:], uint32(len(links)))
+	binary.LittleEndian.PutUint32(out[20:], uint32(cmds.Len()))
+	return append(out, machO[32:]...)
+}
+
+// runMkFixture implements the hidden mkfixture subcommand
+func runMkFixture(args []string) int {
+	var spec FixtureSpec
+	var apps string
+	fs := flag.NewFlagSet("mkfixture", flag.ContinueOnError)
+	out := fs.String("o", "fixture.deb", "where to write the deb")
+	fs.StringVar(&spec.Compression, "compression", "gz", "member compression: gz, xz, lzma, zst or none")
+	fs.StringVar(&spec.ControlComp, "control-compression", "", "control.tar's compression, when it differs from --compression")
+	fs.BoolVar(&spec.DataFirst, "data-first", false, "put data.tar before control.tar")
+	fs.StringVar(&spec.Package, "package", "", "control Package field")
+	fs.StringVar(&spec.Version, "version", "", "control Version field")
+	fs.StringVar(&apps, "apps", "", "comma-separated .app names, e.g. One,Two")
+	fs.BoolVar(&spec.Rootless, "rootless", false, "install under /var/jb")
+	fs.BoolVar(&spec.BinaryPlist, "binary-plist", false, "write Info.plist in binary form")
+	fs.IntVar(&spec.PlistPad, "plist-pad", 0, "pad each app's Info.plist with a filler string past this many bytes")
+	fs.BoolVar(&spec.Framework, "framework", false, "add an embedded framework")
+	fs.IntVar(&spec.FrameworkPad, "framework-pad", 0, "pad the framework binary with zeros to this many bytes")
+	fs.BoolVar(&spec.Symlinks, "symlinks", false, "add symlinks inside the bundle")
+	fs.BoolVar(&spec.EmptyDir, "empty-dir", false, "add an empty folder inside the bundle")
+	fs.BoolVar(&spec.AppClip, "appclip", false, "add an App Clip in AppClips/, ahead of the rest of the app")
+	fs.BoolVar(&spec.Dylib, "dylib", false, "add a root dylib without its executable bit")
+	fs.BoolVar(&spec.Arm64e, "arm64e", false, "build the app's Mach-O files as arm64e")
+	fs.StringVar(&spec.Build, "build", "", "Info.plist CFBundleVersion (default 1)")
+	links := fs.String("links", "", "comma-separated libraries the main executable loads, \"weak:\" before one for a weak load")
+	fs.BoolVar(&spec.Placeholder, "placeholder", false, "write the main executable as an empty file")
+	fs.BoolVar(&spec.ExecFolder, "exec-folder", false, "replace the root executable with a same-named resource folder, and put the Mach-O at <Name>-bin")
+	fs.Int64Var(&spec.LargeFile, "large", 0, "add a zero-filled file of this many bytes")
+	fs.Int64Var(&spec.ExtraData, "extra", 0, "add a zero-filled file of this many bytes outside the app")
+	fs.BoolVar(&spec.HostileNames, "hostile", false, "add entries with hostile names")
+	fs.BoolVar(&spec.ZeroModes, "zero-modes", false, "record mode 0 on every data.tar entry")
+	fs.Int64Var(&spec.UID, "uid", 0, "record this numeric uid and gid on every data.tar entry, without names")
+	fs.BoolVar(&spec.LongPaths, "long-paths", false, "add asset paths over 300 characters")
+	fs.BoolVar(&spec.HostilePlist, "hostile-plist", false, "put terminal escapes and path separators in every Info.plist string and the .app folder name")
+	fs.StringVar(&spec.AppSuffix, "app-suffix", "", "append this to each .app folder's name, e.g. -1.3")
+	fs.StringVar(&spec.Launcher, "launcher", "", "install the apps outside Applications with a launcher symlink there: before, after or bare")
+	fs.BoolVar(&spec.Decoy, "decoy", false, "add a theme folder named Decoy.app ahead of the apps")
+	fs.IntVar(&spec.Nested, "nested", 0, "wrap the deb this many times in installer debs without an app")
+	fs.BoolVar(&spec.PAX, "pax", false, "add PAX global/xattr records, an AppleDouble file, sub-second mtimes and a long PAX path")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if apps != "" {
+		spec.Apps = strings.Split(apps, ",")
+	}
+	if *links != "" {
+		spec.Links = strings.Split(*links, ",")
+	}
+
+	f, err := os.Create(*out)
+	if err == nil {
+		err = buildFixture(f, spec)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Error: %v\n", err)
+		return 1
+	}
+	fmt.Printf("✅ Wrote %s\n", *out)
+	return 0
+}
+
+// buildFixture writes the deb described by spec to w
+func buildFixture(w io.Writer, spec FixtureSpec) error {
+	if spec.Compression == "" {
+		spec.Compression = "gz"
+	}
+	if spec.Package == "" {
+		spec.Package = "com.example.fixture"
+	}
+	if spec.Version == "" {
+		spec.Version = "1.0"
+	}
+	if len(spec.Apps) == 0 {
+		spec.Apps = []string{"Fixture"}
+	}
+	if spec.ModTime.IsZero() {
+		spec.ModTime = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	ext, err := fixtureExt(spec.Compression)
+	if err != nil {
+		return err
+	}
+	controlSpec := spec
+	controlSpec.Compression = valueOr(spec.ControlComp, spec.Compression)
+	controlExt, err := fixtureExt(controlSpec.Compression)
+	if err != nil {
+		return err
+	}
+
+	control, err := fixtureMember(controlSpec, func(tw *fixtureTar) error {
+		return tw.file("./control", 0644, []byte(fixtureControl(spec)))
+	})
+	if err != nil {
+		return err
+	}
+	data, err := fixtureMember(spec, func(tw *fixtureTar) error {
+		tw.zeroModes = spec.ZeroModes
+		tw.uid = spec.UID
+		tw.pax = spec.PAX
+		if spec.Nested > 0 {
+			return fixtureInstaller(tw, spec)
+		}
+		return fixtureData(tw, spec)
+	})
+	if err != nil {
+		return err
+	}
+
+	type member struct {
+		name string
+		data []byte
+	}
+	members := []member{{"debian-binary", []byte("2.0\n")}, {"control.tar" + controlExt, control}, {"data.tar" + ext, data}}
+	if spec.DataFirst {
+		members[1], members[2] = members[2], members[1]
+	}
+	aw := ar.NewWriter(w)
+	for _, m := range members {
+		if err := aw.WriteHeader(&ar.Header{Name: m.name, ModTime: spec.ModTime, Mode: 0644, Size: int64(len(m.data))}); err != nil {
+			return err
+		}
+		if _, err := aw.Write(m.data); err != nil {
+			return err
+		}
+	}
+	return aw.Close()
+}
+
+// fixtureExt is the member name extension for a fixture compression
+func fixtureExt(compression string) (string, error) {
+	ext, ok := map[string]string{"gz": ".gz", "xz": ".xz", "lzma": ".lzma", "zst": ".zst", "none": ""}[compression]
+	if !ok {
+		return "", fmt.Errorf("unsupported fixture compression %q (want gz, xz, lzma, zst or none; compress/bzip2 can't write)", compression)
+	}
+	return ext, nil
+}
+
+// fixtureControl renders the control file, extra fields after the standard ones
+func fixtureControl(spec FixtureSpec) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Package: %s\nVersion: %s\nArchitecture: iphoneos-arm64\nName: %s\nDescription: Generated test fixture\nMaintainer: Fixture <fixture@example.com>\n",
+		spec.Package, spec.Version, spec.Apps[0])
+	keys := make([]string, 0, len(spec.Control))
+	for k := range spec.Control {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s: %s\n", k, spec.Control[k])
+	}
+	return b.String()
+}
+
+// fixtureInstaller lays out an installer's data.tar: no app, just the deb one level in
+func fixtureInstaller(tw *fixtureTar, spec FixtureSpec) error {
+	inner := spec
+	inner.Nested--
+	var deb bytes.Buffer
+	if err := buildFixture(&deb, inner); err != nil {
+		return err
+	}
+	dir := "./var/cache/" + spec.Package + "/"
+	if err := tw.dirs("./var/", "./var/cache/", dir); err != nil {
+		return err
+	}
+	return tw.file(fmt.Sprintf("%sinstaller-%d.deb", dir, spec.Nested), 0644, deb.Bytes())
+}
+
+// fixtureData lays out the data.tar contents: one or more apps plus a file outside them
+func fixtureData(tw *fixtureTar, spec FixtureSpec) error {
+	root := "./"
+	if spec.Rootless {
+		root = "./var/jb/"
+		if err := tw.dirs("./var/", root); err != nil {
+			return err
+		}
+	}
+	if spec.PAX {
+		global := &tar.Header{Typeflag: tar.TypeXGlobalHeader, Name: "pax_global_header", PAXRecords: map[string]string{"comment": "fixture.app/ defaults"}}
+		if err := tw.w.WriteHeader(global); err != nil {
+			return err
+		}
+	}
+	if err := tw.dirs(root+"Applications/", root+"usr/", root+"usr/bin/"); err != nil {
+		return err
+	}
+	if err := tw.file(root+"usr/bin/fixture-tool", 0755, fixtureMachO); err != nil {
+		return err
+	}
+	if spec.ExtraData > 0 {
+		if err := tw.stream(root+"usr/bin/fixture-data", 0644, spec.ExtraData, io.LimitReader(zeroReader{}, spec.ExtraData)); err != nil {
+			return err
+		}
+	}
+	if spec.Decoy {
+		// Icon themes name folders after the apps they skin
+		decoy := root + "Library/Themes/Fixture.theme/Bundles/Decoy.app/"
+		if err := tw.dirs(root+"Library/", root+"Library/Themes/", root+"Library/Themes/Fixture.theme/", root+"Library/Themes/Fixture.theme/Bundles/", decoy); err != nil {
+			return err
+		}
+		if err := tw.file(decoy+"AppIcon60x60@2x.png", 0644, []byte("\x89PNG\r\n\x1a\n")); err != nil {
+			return err
+		}
+	}
+
+	machO := fixtureMachO
+	if spec.Arm64e {
+		machO = bytes.Clone(fixtureMachO)
+		binary.LittleEndian.PutUint32(machO[8:], cpuSubtypeArm64E)
+	}
+	executable := machO
+	if len(spec.Links) > 0 {
+		executable = fixtureMachOLoading(machO, spec.Links)
+	}
+	if spec.Launcher != "" {
+		if err := tw.dirs(root+"usr/share/", root+"opt/"); err != nil {
+			return err
+		}
+	}
+	for i, name := range spec.Apps {
+		folder := name
+		if spec.HostilePlist {
+			folder += "\x1b[2J\\..\\evil"
+		}
+		app := root + "Applications/" + folder + ".app" + spec.AppSuffix + "/"
+		launcher, launcherDest := "", ""
+		if spec.Launcher != "" {
+			var err error
+			if app, launcher, launcherDest, err = fixtureLauncher(tw, spec, root, folder); err != nil {
+				return err
+			}
+		}
+		if spec.Launcher == "before" {
+			if err := tw.link(launcher, launcherDest); err != nil {
+				return err
+			}
+		}
+		if spec.AppClip {
+			// Before the app's own folder entry, so its .app is the first one in data.tar
+			if err := fixtureAppClip(tw, spec, app, name, i, machO); err != nil {
+				return err
+			}
+		}
+		if err := tw.dirs(app); err != nil {
+			return err
+		}
+		info := map[string]any{
+			"CFBundleExecutable":         name,
+			"CFBundleIdentifier":         fmt.Sprintf("%s.app%d", spec.Package, i),
+			"CFBundleName":               name,
+			"CFBundleShortVersionString": spec.Version,
+			"CFBundleVersion":            valueOr(spec.Build, "1"),
+			"MinimumOSVersion":           "14.0",
+			"CFBundleDevelopmentRegion":  "en",
+		}
+		if spec.PlistPad > 0 {
+			info["FixturePadding"] = strings.Repeat("x", spec.PlistPad)
+		}
+		if spec.HostilePlist {
+			for key, value := range info {
+				info[key] = value.(string) + fixtureHostileValue
+			}
+		}
+		// XML can't carry control characters, so hostile values need the binary form
+		if err := tw.plist(app+"Info.plist", info, spec.BinaryPlist || spec.HostilePlist); err != nil {
+			return err
+		}
+		if spec.ExecFolder {
+			// CFBundleExecutable names a folder; the binary only a Mach-O scan finds, recorded 0644
+			if err := tw.dirs(app + name + "/"); err != nil {
+				return err
+			}
+			if err := tw.file(app+name+"/"+name, 0644, []byte("resource data, not a binary\n")); err != nil {
+				return err
+			}
+			if err := tw.file(app+name+"-bin", 0644, executable); err != nil {
+				return err
+			}
+		} else if spec.Placeholder {
+			if err := tw.file(app+name, 0755, nil); err != nil {
+				return err
+			}
+		} else if err := tw.file(app+name, 0755, executable); err != nil {
+			return err
+		}
+		if err := tw.dirs(app + "en.lproj/"); err != nil {
+			return err
+		}
+		if err := tw.file(app+"en.lproj/Localizable.strings", 0644, []byte("\"hello\" = \"Hello\";\n")); err != nil {
+			return err
+		}
+		if spec.EmptyDir {
+			if err := tw.dirs(app + "Base.lproj/"); err != nil {
+				return err
+			}
+		}
+
+		if spec.Framework {
+			fw := app + "Frameworks/Fixture.framework/"
+			if err := tw.dirs(app+"Frameworks/", fw); err != nil {
+				return err
+			}
+			fwInfo := map[string]any{"CFBundleExecutable": "Fixture", "CFBundleIdentifier": spec.Package + ".framework", "CFBundlePackageType": "FMWK"}
+			if err := tw.plist(fw+"Info.plist", fwInfo, spec.BinaryPlist); err != nil {
+				return err
+			}
+			fwBinary := machO
+			if spec.FrameworkPad > len(machO) {
+				fwBinary = append(bytes.Clone(machO), make([]byte, spec.FrameworkPad-len(machO))...)
+			}
+			if err := tw.file(fw+"Fixture", 0755, fwBinary); err != nil {
+				return err
+			}
+		}
+		if spec.Dylib {
+			if err := tw.file(app+"libFixture.dylib", 0644, machO); err != nil {
+				return err
+			}
+		}
+		if spec.Symlinks {
+			if err := tw.link(app+"Localizable.strings", "en.lproj/Localizable.strings"); err != nil {
+				return err
+			}
+			if spec.Framework {
+				if err := tw.link(app+"Frameworks/Current", "Fixture.framework"); err != nil {
+					return err
+				}
+			}
+		}
+		if spec.LargeFile > 0 {
+			if err := tw.stream(app+"large.bin", 0644, spec.LargeFile, io.LimitReader(zeroReader{}, spec.LargeFile)); err != nil {
+				return err
+			}
+		}
+		if spec.LongPaths {
+			dir := app
+			for i := 0; len(dir) < 300; i++ {
+				dir += fmt.Sprintf("Assets.bundle-nested-level-%02d/", i)
+				if err := tw.dirs(dir); err != nil {
+					return err
+				}
+			}
+			if err := tw.file(dir+"image@3x.png", 0644, []byte("not really a png\n")); err != nil {
+				return err
+			}
+		}
+		if spec.PAX {
+			// Over ustar's 100 bytes, so it goes in a PAX path record, and without the "./" of its siblings
+			long := strings.TrimPrefix(app, "./") + strings.Repeat("pax-long-name-", 8) + ".txt"
+			if err := tw.file(long, 0644, []byte("long PAX path\n")); err != nil {
+				return err
+			}
+			// The "._" sibling macOS tar writes when it copies attributes the old way
+			if err := tw.file(app+"._Info.plist", 0644, fixtureAppleDouble()); err != nil {
+				return err
+			}
+		}
+		if spec.HostileNames {
+			if err := fixtureHostile(tw, app); err != nil {
+				return err
+			}
+		}
+		if launcher != "" && spec.Launcher != "before" {
+			if err := tw.link(launcher, launcherDest); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// fixtureAppClip adds an App Clip to app: its ID the app's plus ".Clip", and its binary
+// recorded 0644 for its own Info.plist to make executable
+func fixtureAppClip(tw *fixtureTar, spec FixtureSpec, app, name string, i int, machO []byte) error {
+	clip := app + "AppClips/" + name + "Clip.app/"
+	if err := tw.dirs(app+"AppClips/", clip); err != nil {
+		return err
+	}
+	info := map[string]any{
+		"CFBundleExecutable": name + "Clip",
+		"CFBundleIdentifier": fmt.Sprintf("%s.app%d.Clip", spec.Package, i),
+		"MinimumOSVersion":   "14.0",
+	}
+	if err := tw.plist(clip+"Info.plist", info, spec.BinaryPlist); err != nil {
+		return err
+	}
+	return tw.file(clip+name+"Clip", 0644, machO)
+}
+
+// fixtureLauncher places an app outside Applications/ for spec.Launcher, returning its
+// bundle folder and the launcher symlink's name and target. The real folder's name is
+// lowercase, so only the launcher carries the app's own.
+func fixtureLauncher(tw *fixtureTar, spec FixtureSpec, root, folder string) (app, link, dest string, err error) {
+	link = root + "Applications/" + folder + ".app" + spec.AppSuffix
+	pkg := root + "usr/share/" + spec.Package + "/"
+	app = pkg + strings.ToLower(folder) + ".app" + spec.AppSuffix + "/"
+	if spec.Launcher == "bare" {
+		pkg = root + "opt/" + spec.Package + "/"
+		app = pkg + strings.ToLower(folder) + "/"
+	}
+	if err := tw.dirs(pkg); err != nil {
+		return "", "", "", err
+	}
+	switch spec.Launcher {
+	case "before":
+		dest = "/" + strings.TrimPrefix(strings.TrimSuffix(app, "/"), "./")
+	case "after", "bare":
+		dest = "../" + strings.TrimPrefix(strings.TrimSuffix(app, "/"), root)
+	default:
+		return "", "", "", fmt.Errorf("unknown fixture launcher %q (want before, after or bare)", spec.Launcher)
+	}
+	return app, link, dest, nil
+}
+
+// fixtureAppleDouble is an AppleDouble file holding Finder info and no resource fork
+func fixtureAppleDouble() []byte {
+	const finderInfo = 9
+	data := make([]byte, 26+12+32)
+	binary.BigEndian.PutUint32(data, appleDoubleMagic)
+	binary.BigEndian.PutUint32(data[4:], 0x00020000) // Version 2
+	copy(data[8:], "Mac OS X        ")
+	binary.BigEndian.PutUint16(data[24:], 1)
+	binary.BigEndian.PutUint32(data[26:], finderInfo)
+	binary.BigEndian.PutUint32(data[30:], 26+12)
+	binary.BigEndian.PutUint32(data[34:], 32)
+	return data
+}
+
+// fixtureHostile adds entries that have broken naive path handling at some point
+func fixtureHostile(tw *fixtureTar, app string) error {
+	long := strings.Repeat("very-long-directory-name/", 10)
+	if err := tw.dirs(app+"Ünïcødé 文件/", app+strings.TrimSuffix(long, "/")+"/"); err != nil {
+		return err
+	}
+	for _, name := range []string{
+		"Ünïcødé 文件/naïve résumé.txt",
+		"spaces and 'quotes' & $vars.txt",
+		`back\slash.txt`,
+		"README", "readme",
+		long + strings.Repeat("x", 120) + ".txt",
+		"../escaped.txt",
+	} {
+		if err := tw.file(app+name, 0644, []byte(name+"\n")); err != nil {
+			return err
+		}
+	}
+	return tw.link(app+"absolute-link", "/etc/passwd")
+}
+
+// fixtureMember builds one compressed tar member in memory
+func fixtureMember(spec FixtureSpec, fill func(*fixtureTar) error) ([]byte, error) {
+	var buf bytes.Buffer
+	cw, err := fixtureCompressor(spec.Compression, &buf)
+	if err != nil {
+		return nil, err
+	}
+	tw := &fixtureTar{w: tar.NewWriter(cw), modTime: spec.ModTime}
+	if err := fill(tw); err != nil {
+		return nil, err
+	}
+	if err := tw.w.Close(); err != nil {
+		return nil, err
+	}
+	if err := cw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// fixtureCompressor wraps w in the writer matching the member extension decompress expects
+func fixtureCompressor(compression string, w io.Writer) (io.WriteCloser, error) {
+	switch compression {
+	case "gz":
+		return gzip.NewWriter(w), nil
+	case "xz":
+		return xz.NewWriter(w)
+	case "lzma":
+		return lzma.NewWriter(w)
+	case "zst":
+		return &zstdRawWriter{w: w}, nil
+	default:
+		return nopWriteCloser{w}, nil
+	}
+}
+
+// fixtureTar adds entries to a fixture's tar with fixed ownership and timestamps
+type fixtureTar struct {
+	w         *tar.Writer
+	modTime   time.Time
+	zeroModes bool
+	pax       bool
+	uid       int64 // Numeric owner for every entry; 0 for root:wheel
+}
+
+func (t *fixtureTar) header(name string, typeflag byte, mode, size int64) *tar.Header {
+	if t.zeroModes {
+		mode = 0
+	}
+	h := &tar.Header{Name: name, Typeflag: typeflag, Mode: mode, Size: size, ModTime: t.modTime, Uname: "root", Gname: "wheel", Format: tar.FormatPAX}
+	if t.uid != 0 {
+		// Past ustar's 8 octal digits, so archive/tar writes PAX uid and gid records
+		h.Uname, h.Gname, h.Uid, h.Gid = "", "", int(t.uid), int(t.uid)
+	}
+	if t.pax {
+		// What bsdtar on macOS records: nanosecond mtimes and extended attributes
+		h.ModTime = h.ModTime.Add(123456789 * time.Nanosecond)
+		h.PAXRecords = map[string]string{"SCHILY.xattr.com.apple.quarantine": "0081;5e0be600;Safari;"}
+	}
+	return h
+}
+
+func (t *fixtureTar) dirs(names ...string) error {
+	for _, name := range names {
+		if err := t.w.WriteHeader(t.header(name, tar.TypeDir, 0755, 0)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *fixtureTar) file(name string, mode int64, data []byte) error {
+	return t.stream(name, mode, int64(len(data)), bytes.NewReader(data))
+}
+
+func (t *fixtureTar) stream(name string, mode, size int64, r io.Reader) error {
+	if err := t.w.WriteHeader(t.header(name, tar.TypeReg, mode, size)); err != nil {
+		return err
+	}
+	_, err := io.Copy(t.w, r)
+	return err
+}
+
+func (t *fixtureTar) link(name, target string) error {
+	h := t.header(name, tar.TypeSymlink, 0755, 0)
+	h.Linkname = target
+	return t.w.WriteHeader(h)
+}
+
+func (t *fixtureTar) plist(name string, value map[string]any, binaryForm bool) error {
+	encode := encodeXMLPlist
+	if binaryForm {
+		encode = encodeBinaryPlist
+	}
+	data, err := encode(value)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path.Base(name), err)
+	}
+	return t.file(name, 0644, data)
+}
+
+// zstdRawWriter writes a valid zstd frame made of uncompressed (raw) blocks. That's all a
+// fixture needs, and it avoids a zstd encoder dependency.
+type zstdRawWriter struct {
+	w       io.Writer
+	pending []byte
+	started bool
+}
+
+// zstdMaxBlock is the largest raw block allowed in a frame
+const zstdMaxBlock = 128 << 10
+
+func (z *zstdRawWriter) Write(p []byte) (int, error) {
+	z.pending = append(z.pending, p...)
+	for len(z.pending) > zstdMaxBlock {
+		if err := z.block(z.pending[:zstdMaxBlock], false); err != nil {
+			return 0, err
+		}
+		z.pending = z.pending[zstdMaxBlock:]
+	}
+	return len(p), nil
+}
+
+func (z *zstdRawWriter) Close() error {
+	return z.block(z.pending, true)
+}
+
+func (z *zstdRawWriter) block(data []byte, last bool) error {
+	if !z.started {
+		// Magic, frame header descriptor (no flags), window descriptor (2 MB)
+		if _, err := z.w.Write([]byte{0x28, 0xb5, 0x2f, 0xfd, 0x00, 0x58}); err != nil {
+			return err
+		}
+		z.started = true
+	}
+	header := uint32(len(data)) << 3 // Block type 0: raw
+	if last {
+		header |= 1
+	}
+	if _, err := z.w.Write([]byte{byte(header), byte(header >> 8), byte(header >> 16)}); err != nil {
+		return err
+	}
+	_, err := z.w.Write(data)
+	return err
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// zeroReader is an endless stream of zero bytes
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// --- File handles: a bounded pool for files read from disk ---
+// Spilled files and --add files are read through the pool rather than os.Open, so however
+// many readers are open at once, only a bounded number of descriptors are. The least
+// recently read file gives up its descriptor when the bound is reached, and reopens on its
+// next read. macOS starts processes with a soft limit of 256.
+
+// fileHandles is the pool every VirtualFile on disk is read through
+var fileHandles = newHandlePool(handleLimit(openFileLimit()))
+
+// handleLimit leaves half the process's descriptors to everything else: the deb, the
+// output archive, temp files and network connections
+func handleLimit(soft int) int {
+	switch {
+	case soft <= 0:
+		return 512 // Unknown; Windows has no small per-process limit
+	case soft/2 < 8:
+		return 8
+	case soft/2 > 1024:
+		return 1024
+	}
+	return soft / 2
+}
+
+// handlePool hands out readers over files, keeping at most limit descriptors open
+type handlePool struct {
+	mu    sync.Mutex
+	limit int
+	files map[string]*pooledFile
+	lru   *list.List // Files holding a descriptor, most recently read first
+	peak  int        // Most descriptors held at once
+}
+
+// pooledFile is a file with open readers, and its descriptor when it holds one
+type pooledFile struct {
+	path    string
+	f       *os.File // nil while evicted
+	elem    *list.Element
+	readers int // Open pooledReaders
+	pins    int // Reads in progress, which keep the descriptor from being evicted
+}
+
+func newHandlePool(limit int) *handlePool {
+	return &handlePool{limit: limit, files: make(map[string]*pooledFile), lru: list.New()}
+}
+
+// open returns a reader over the file at path. It opens the file straight away, so a
+// missing file fails here rather than on the first read.
+func (p *handlePool) open(path string) (*pooledReader, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pf := p.files[path]
+	if pf == nil {
+		pf = &pooledFile{path: path}
+	}
+	if _, err := p.acquire(pf); err != nil {
+		return nil, err
+	}
+	p.files[path] = pf
+	pf.readers++
+	return &pooledReader{pool: p, file: pf}, nil
+}
+
+// acquire returns pf's descriptor, opening it again if it was evicted. p.mu must be held.
+func (p *handlePool) acquire(pf *pooledFile) (*os.File, error) {
+	if pf.f != nil {
+		p.lru.MoveToFront(pf.elem)
+		return pf.f, nil
+	}
+	for e := p.lru.Back(); e != nil && p.lru.Len() >= p.limit; {
+		prev := e.Prev()
+		if victim := e.Value.(*pooledFile); victim.pins == 0 {
+			p.release(victim)
+		}
+		e = prev
+	}
+
+	f, err := os.Open(pf.path)
+	if err != nil {
+		return nil, tooManyFilesError(err)
+	}
+	pf.f = f
+	pf.elem = p.lru.PushFront(pf)
+	p.peak = max(p.peak, p.lru.Len())
+	return f, nil
+}
+
+// release closes pf's descriptor. p.mu must be held.
+func (p *handlePool) release(pf *pooledFile) {
+	if pf.f == nil {
+		return
+	}
+	pf.f.Close()
+	pf.f = nil
+	p.lru.Remove(pf.elem)
+	pf.elem = nil
+}
+
+// peakOpen is the most descriptors the pool has held at once
+func (p *handlePool) peakOpen() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.peak
+}
+
+// pooledReader reads a pooled file from its own offset, so readers of one file don't
+// disturb each other and a reopened descriptor carries on where the last one stopped
+type pooledReader struct {
+	pool   *handlePool
+	file   *pooledFile
+	off    int64
+	closed bool
+}
+
+func (r *pooledReader) ReadAt(b []byte, off int64) (int, error) {
+	p := r.pool
+	p.mu.Lock()
+	if r.closed {
+		p.mu.Unlock()
+		return 0, os.ErrClosed
+	}
+	f, err := p.acquire(r.file)
+	if err != nil {
+		p.mu.Unlock()
+		return 0, err
+	}
+	r.file.pins++
+	p.mu.Unlock()
+
+	n, err := f.ReadAt(b, off)
+
+	p.mu.Lock()
+	r.file.pins--
+	p.mu.Unlock()
+	return n, err
+}
+
+func (r *pooledReader) Read(b []byte) (int, error) {
+	n, err := r.ReadAt(b, r.off)
+	r.off += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil // Reported by the next Read, as io.Reader callers expect
+	}
+	return n, err
+}
+
+// Close gives up the reader; the descriptor is closed with the file's last reader
+func (r *pooledReader) Close() error {
+	p := r.pool
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	if r.file.readers--; r.file.readers == 0 {
+		p.release(r.file)
+		delete(p.files, r.file.path)
+	}
+	return nil
+}
+
+// tooManyFilesError names the descriptor limit when the process has run out anyway
+func tooManyFilesError(err error) error {
+	if !errors.Is(err, syscall.EMFILE) && !errors.Is(err, syscall.ENFILE) {
+		return err
+	}
+	if soft := openFileLimit(); soft > 0 {
+		return fmt.Errorf("%w: the limit is %d open files (raise it with ulimit -n)", err, soft)
+	}
+	return fmt.Errorf("%w: the system's open file limit was reached", err)
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"image/png"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// AppIcon is the best icon found in a bundle
+type AppIcon struct {
+	Entry  BundleEntry
+	Data   []byte // The PNG as stored in the bundle (possibly CgBI)
+	Width  int
+	Height int
+}
+
+// iconNames lists the icon base names Info.plist declares, most specific first:
+// CFBundleIcons -> CFBundlePrimaryIcon -> CFBundleIconFiles, then the legacy keys.
+func iconNames(info map[string]any) []string {
+	var names []string
+	names = append(names, plistStrings(plistPath(info, "CFBundleIcons", "CFBundlePrimaryIcon", "CFBundleIconFiles"))...)
+	names = append(names, plistStrings(plistPath(info, "CFBundleIcons~ipad", "CFBundlePrimaryIcon", "CFBundleIconFiles"))...)
+	names = append(names, plistStrings(info["CFBundleIconFiles"])...)
+	names = append(names, plistStrings(info["CFBundleIconFile"])...)
+	return names
+}
+
+// iconSource looks for the app icon once per conversion, for the Assets.car check, the
+// report and --itunes-artwork alike: the catalog can run to tens of MiB, and each
+// candidate PNG is read whole to size it
+type iconSource struct {
+	entries  []BundleEntry
+	info     map[string]any
+	warnings *warningLog
+
+	carDone  bool
+	carEntry *BundleEntry  // Assets.car, nil without one
+	car      *AssetCatalog // Nil when it couldn't be read
+	iconDone bool
+	icon     *AppIcon
+}
+
+// newIconSource looks in entries as they are now; later steps reorder and compact the slice
+// in place, so it keeps its own copy
+func newIconSource(entries []BundleEntry, infoPlistData []byte, warnings *warningLog) *iconSource {
+	return &iconSource{entries: slices.Clone(entries), info: parsePlistDict(infoPlistData), warnings: warnings}
+}
+
+// catalog reads and parses Assets.car the first time it's asked for
+func (s *iconSource) catalog() (*BundleEntry, *AssetCatalog) {
+	if s.carDone {
+		return s.carEntry, s.car
+	}
+	s.carDone = true
+	for i, entry := range s.entries {
+		if entry.RelPath != "Assets.car" || entry.File.IsDir || entry.File.IsLink {
+			continue
+		}
+		s.carEntry = &s.entries[i]
+		data, err := readAll(entry.File)
+		if err == nil {
+			s.car, err = parseAssetCatalog(data, true)
+		}
+		if err != nil {
+			s.warnings.add("assets-car", "Assets.car", "Could not read Assets.car: %v", err)
+		}
+		break
+	}
+	return s.carEntry, s.car
+}
+
+// appIcon picks the largest icon PNG at the bundle root, preferring files named by
+// Info.plist and falling back to the AppIcon*.png naming Xcode uses, then the icon set in
+// Assets.car. Returns nil if none.
+func (s *iconSource) appIcon() *AppIcon {
+	if s.iconDone {
+		return s.icon
+	}
+	s.iconDone = true
+	if s.icon = s.pngIcon(); s.icon == nil {
+		s.icon = s.carIcon()
+	}
+	if s.car != nil {
+		s.car.Renditions = nil // Only the icon was wanted of them; the names stay for catalog
+	}
+	return s.icon
+}
+
+// pngIcon is the largest icon PNG at the bundle root, or nil
+func (s *iconSource) pngIcon() *AppIcon {
+	var prefixes []string
+	for _, name := range iconNames(s.info) {
+		prefixes = append(prefixes, strings.TrimSuffix(name, ".png"))
+	}
+
+	// First pass uses the declared names; only if none match do we guess
+	for _, candidates := range [][]string{prefixes, {"AppIcon", "Icon"}} {
+		var best *AppIcon
+		for _, entry := range s.entries {
+			if entry.File.IsDir || entry.File.IsLink || strings.Contains(entry.RelPath, "/") || !strings.HasSuffix(entry.RelPath, ".png") {
+				continue
+			}
+			if !hasAnyPrefix(entry.RelPath, candidates) {
+				continue
+			}
+
+			data, err := readAll(entry.File)
+			if err != nil {
+				continue
+			}
+			width, height, err := pngDimensions(data)
+			if err != nil {
+				continue
+			}
+			if best == nil || width*height > best.Width*best.Height {
+				best = &AppIcon{Entry: entry, Data: data, Width: width, Height: height}
+			}
+		}
+		if best != nil {
+			return best
+		}
+	}
+	return nil
+}
+
+// iconSetName is the asset catalog icon set Info.plist points at ("AppIcon" by Xcode default)
+func iconSetName(info map[string]any) string {
+	if name, ok := plistPath(info, "CFBundleIcons", "CFBundlePrimaryIcon", "CFBundleIconName").(string); ok && name != "" {
+		return name
+	}
+	return "AppIcon"
+}
+
+// carIcon extracts the largest rendition of the app icon set from Assets.car, or nil
+func (s *iconSource) carIcon() *AppIcon {
+	entry, catalog := s.catalog()
+	if catalog == nil {
+		return nil
+	}
+	img, rendition, err := carAppIcon(catalog, iconSetName(s.info))
+	if err != nil {
+		s.warnings.add("assets-car", "Assets.car", "Assets.car: %v", err)
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil
+	}
+	return &AppIcon{Entry: *entry, Data: buf.Bytes(), Width: rendition.Width, Height: rendition.Height}
+}
+
+// validateIconCatalog warns when Info.plist names an icon set that Assets.car doesn't contain
+func validateIconCatalog(icons *iconSource) {
+	name, ok := plistPath(icons.info, "CFBundleIcons", "CFBundlePrimaryIcon", "CFBundleIconName").(string)
+	if !ok || name == "" {
+		return
+	}
+	entry, catalog := icons.catalog()
+	switch {
+	case entry == nil:
+		icons.warnings.add("icon-name", "Info.plist", "CFBundleIconName %q is set but the bundle has no Assets.car", name)
+	case catalog != nil:
+		if _, ok := catalog.Names[name]; !ok {
+			icons.warnings.add("icon-name", "Assets.car", "CFBundleIconName %q is not in Assets.car; the app will have no icon", name)
+		}
+	}
+}
+
+// writeITunesArtwork adds the legacy 512x512 "iTunesArtwork" PNG at the archive root
+func writeITunesArtwork(zipWriter *zip.Writer, icon *AppIcon, modTime time.Time, manifest *Manifest) error {
+	img, err := decodePNG(icon.Data)
+	if err != nil {
+		return fmt.Errorf("decoding %s: %w", icon.Entry.RelPath, err)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, scaleImage(img, 512, 512)); err != nil {
+		return err
+	}
+
+	header := &zip.FileHeader{
+		Name:     "iTunesArtwork",
+		Method:   zip.Store,
+		Modified: modTime,
+	}
+	header.SetMode(0644)
+	header.ExternalAttrs = (0x8000 | 0644) << 16
+
+	if err := writeZipFile(zipWriter, header, &VirtualFile{Data: buf.Bytes(), Size: int64(buf.Len())}, "", nil); err != nil {
+		return err
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	manifest.add("", header, sum[:])
+	return nil
+}
+
+// readAll returns a VirtualFile's contents, wherever they were stored
+func readAll(vf *VirtualFile) ([]byte, error) {
+	if vf.DiskPath == "" {
+		return vf.Data, nil
+	}
+	rc, err := vf.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+func hasAnyPrefix(name string, prefixes []string) bool {
+	base := path.Base(name)
+	for _, p := range prefixes {
+		if p != "" && strings.HasPrefix(base, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// IconInfo describes the app icon for the report
+type IconInfo struct {
+	Path      string `json:"path,omitempty"` // Bundle-relative, e.g. "AppIcon60x60@3x.png"
+	Width     int    `json:"width,omitempty"`
+	Height    int    `json:"height,omitempty"`
+	CgBI      bool   `json:"cgbi,omitempty"`      // Stored in Apple's optimized PNG format
+	AssetsCar bool   `json:"assetsCar,omitempty"` // Taken from (or only available inside) Assets.car
+	Thumbnail string `json:"thumbnail,omitempty"` // Base64 128x128 PNG, with --report-icon
+}
+
+// inspectIcon locates the app icon, exporting it with --export-icon and describing it for the report.
+// Apps whose icons only live in Assets.car are reported as such instead of silently exporting nothing.
+func inspectIcon(icons *iconSource, opts Options) *IconInfo {
+	warnings := icons.warnings
+	icon := icons.appIcon()
+	if icon == nil {
+		if entry, _ := icons.catalog(); entry != nil {
+			warnings.add("icon-missing", "Assets.car", "The app icon only exists inside Assets.car, in a format that can't be extracted")
+			return &IconInfo{AssetsCar: true}
+		}
+		warnings.add("icon-missing", "", "No app icon found")
+		return nil
+	}
+
+	info := &IconInfo{
+		Path:      icon.Entry.RelPath,
+		Width:     icon.Width,
+		Height:    icon.Height,
+		CgBI:      isCgBI(icon.Data),
+		AssetsCar: icon.Entry.RelPath == "Assets.car",
+	}
+
+	needsImage := opts.ExportIcon != "" || opts.ReportIcon
+	if !needsImage {
+		return info
+	}
+	img, err := decodePNG(icon.Data)
+	if err != nil {
+		warnings.add("icon-decode", icon.Entry.RelPath, "Could not decode icon %s: %v", icon.Entry.RelPath, err)
+		return info
+	}
+
+	if opts.ExportIcon != "" {
+		var buf bytes.Buffer
+		err := png.Encode(&buf, img)
+		if err == nil {
+			err = os.WriteFile(opts.ExportIcon, buf.Bytes(), 0644)
+		}
+		if err != nil {
+			warnings.add("icon-export", icon.Entry.RelPath, "Could not export icon: %v", err)
+		} else {
+			fmt.Printf("   Icon: %s (%dx%d) -> %s\n", icon.Entry.RelPath, icon.Width, icon.Height, opts.ExportIcon)
+		}
+	}
+
+	if opts.ReportIcon {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, scaleImage(img, 128, 128)); err == nil {
+			info.Thumbnail = base64.StdEncoding.EncodeToString(buf.Bytes())
+		}
+	}
+
+	return info
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"os"
+	"testing"
+)
+
+// TestIconSourceReadsOnce finds the icon among spilled PNGs, then again for the report
+// once the spill files are emptied: the second lookup reuses what the first read
+func TestIconSourceReadsOnce(t *testing.T) {
+	store := &SpillStore{Dir: t.TempDir()}
+	var entries []BundleEntry
+	for _, c := range []struct {
+		name string
+		size int
+	}{{"AppIcon60x60@2x.png", 120}, {"AppIcon60x60@3x.png", 180}} {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, c.size, c.size))); err != nil {
+			t.Fatal(err)
+		}
+		vf := &VirtualFile{Name: c.name, Mode: 0644, Size: int64(buf.Len())}
+		if err := store.spill(vf, bytes.NewReader(buf.Bytes())); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, BundleEntry{RelPath: c.name, File: vf})
+	}
+
+	var warnings warningLog
+	icons := newIconSource(entries, nil, &warnings)
+	if icon := icons.appIcon(); icon == nil || icon.Width != 180 {
+		t.Fatalf("icon %+v, want the 180x180 one", icon)
+	}
+	for _, entry := range entries {
+		if err := os.Truncate(entry.File.DiskPath, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if info := inspectIcon(icons, Options{}); info == nil || info.Path != "AppIcon60x60@3x.png" || info.Width != 180 {
+		t.Errorf("report icon %+v, want AppIcon60x60@3x.png at 180x180", info)
+	}
+	if len(warnings.list) != 0 {
+		t.Errorf("warnings %+v", warnings.list)
+	}
+}
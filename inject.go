@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+)
+
+// --- Tweak injection: loading merged MobileSubstrate dylibs from the main executable ---
+// On a jailbroken device a tweak's dylib is loaded by the substitution framework, into the
+// processes its filter plist names. An IPA has no such framework, so each dylib --merge
+// carries into Frameworks/ whose filter takes in the app gets an LC_LOAD_DYLIB in the main
+// executable instead, and the rest are left out. The command goes in the padding linkers
+// leave between the load commands and the first section; a slice without room is refused.
+// The code signature is invalidated; the IPA has to be signed again.
+
+// lcLoadDylib is LC_LOAD_DYLIB; lcLoadCommands lists it with the commands naming a dylib the
+// same way, so one already loaded isn't added twice
+const lcLoadDylib = 0xc
+
+var lcLoadCommands = []uint32{lcLoadDylib, 0x20, 0x80000018, 0x8000001f, 0x80000023} // Load, lazy, weak, reexport, upward
+
+// dylibCommandSize is a dylib_command up to its name: cmd, cmdsize, name offset, timestamp,
+// current and compatibility versions
+const dylibCommandSize = 24
+
+// tweakFilterApps are bundles a filter names to load into every app
+var tweakFilterApps = []string{"com.apple.UIKit", "com.apple.Foundation", "com.apple.CoreFoundation"}
+
+// openStepToken is a bare or quoted word in an old-style (OpenStep) plist, which most
+// filter plists are and parsePlist doesn't read
+var openStepToken = regexp.MustCompile(`"(?:[^"\\]|\\.)*"|[A-Za-z0-9._$/:-]+`)
+
+// TweakDylib is a MobileSubstrate dylib a --merge deb carried into Frameworks/
+type TweakDylib struct {
+	Path   string // Bundle-relative, e.g. "Frameworks/Foo.dylib"
+	Filter []byte // Its filter plist, nil when the deb had none
+}
+
+// tweakFilterTakes reports whether a filter plist loads its dylib into the app: without a
+// Bundles or Executables list, or with one naming the app, its executable or a framework
+// every app links. An OpenStep plist is matched by its words.
+func tweakFilterTakes(filter []byte, bundleID, executableName string) bool {
+	if filter == nil {
+		return true
+	}
+	wants := append([]string{bundleID, executableName}, tweakFilterApps...)
+	root := parsePlistDict(filter)
+	if root == nil {
+		for _, token := range openStepToken.FindAll(filter, -1) {
+			if slices.Contains(wants, string(bytes.Trim(token, `"`))) {
+				return true
+			}
+		}
+		return !bytes.Contains(filter, []byte("Bundles")) && !bytes.Contains(filter, []byte("Executables"))
+	}
+	dict, _ := root["Filter"].(map[string]any)
+	listed := false
+	for _, key := range []string{"Bundles", "Executables"} {
+		list, ok := dict[key].([]any)
+		listed = listed || ok
+		for _, v := range list {
+			if s, _ := v.(string); slices.Contains(wants, s) {
+				return true
+			}
+		}
+	}
+	return !listed
+}
+
+// injectTweaks loads each merged tweak dylib the app is a target of from the main
+// executable, and leaves out the others
+func injectTweaks(entries []BundleEntry, tweaks []TweakDylib, executableName, bundleID string, store *SpillStore, warnings *warningLog) ([]BundleEntry, error) {
+	var loads []string
+	drop := make(map[string]bool)
+	for _, tweak := range tweaks {
+		if !tweakFilterTakes(tweak.Filter, bundleID, executableName) {
+			warnings.add("merge-tweak-filtered", tweak.Path, "%s is filtered to other processes than %s; left it out", path.Base(tweak.Path), bundleID)
+			drop[tweak.Path] = true
+			continue
+		}
+		if load := "@executable_path/" + tweak.Path; !slices.Contains(loads, load) {
+			loads = append(loads, load)
+		}
+	}
+	entries = slices.DeleteFunc(entries, func(e BundleEntry) bool { return drop[e.RelPath] })
+	if len(loads) == 0 {
+		return entries, nil
+	}
+
+	i := slices.IndexFunc(entries, func(e BundleEntry) bool {
+		return e.RelPath == executableName && !e.File.IsDir && !e.File.IsLink
+	})
+	if i < 0 {
+		return nil, fmt.Errorf("injecting tweak dylibs: no main executable %s", executableName)
+	}
+	data, err := readAll(entries[i].File)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", executableName, err)
+	}
+	out, err := injectLoadsFile(data, loads)
+	if err != nil {
+		return nil, fmt.Errorf("injecting tweak dylibs into %s: %w", executableName, err)
+	}
+	if err := store.Replace(entries[i].File, out); err != nil {
+		return nil, err
+	}
+	fmt.Printf("   Injected %d tweak dylib(s) into %s; the IPA has to be signed again\n", len(loads), executableName)
+	for _, load := range loads {
+		fmt.Printf("     - %s\n", terminalSafe(load))
+	}
+	return entries, nil
+}
+
+// injectLoadsFile adds the load commands to every slice of a thin or fat binary, which
+// keeps its size: the commands fill padding
+func injectLoadsFile(data []byte, loads []string) ([]byte, error) {
+	out := bytes.Clone(data)
+	if len(out) < fatHeaderSize || binary.BigEndian.Uint32(out) != fatMagic {
+		return out, injectLoadsSlice(out, loads)
+	}
+	n := int(binary.BigEndian.Uint32(out[4:]))
+	if fatHeaderSize+n*fatArchHeaderSize > len(out) {
+		return nil, errors.New("truncated fat header")
+	}
+	for i := range n {
+		h := out[fatHeaderSize+i*fatArchHeaderSize:]
+		off, size := binary.BigEndian.Uint32(h[8:]), binary.BigEndian.Uint32(h[12:])
+		if uint64(off)+uint64(size) > uint64(len(out)) {
+			return nil, errors.New("slice past the end of the file")
+		}
+		if err := injectLoadsSlice(out[off:off+size], loads); err != nil {
+			return nil, fmt.Errorf("slice %d: %w", i, err)
+		}
+	}
+	return out, nil
+}
+
+// injectLoadsSlice appends an LC_LOAD_DYLIB for each name the slice doesn't load yet,
+// rewriting it in place
+func injectLoadsSlice(data []byte, loads []string) error {
+	le := binary.LittleEndian
+	if len(data) < machoHeaderSize64 {
+		return errors.New("not a Mach-O")
+	}
+	var is64 bool
+	switch le.Uint32(data) {
+	case 0xfeedface:
+	case 0xfeedfacf:
+		is64 = true
+	default:
+		return errors.New("not a little-endian Mach-O")
+	}
+	headerSize, segHeader, sectHeader, nsectsAt, sectOffsetAt, align := machoHeaderSize32, machoSegmentHeader32, machoSectionHeader32, 48, 40, 4
+	if is64 {
+		headerSize, segHeader, sectHeader, nsectsAt, sectOffsetAt, align = machoHeaderSize64, machoSegmentHeader64, machoSectionHeader64, 64, 48, 8
+	}
+	ncmds, sizeofcmds := le.Uint32(data[16:]), le.Uint32(data[20:])
+	cmdsEnd := headerSize + int(sizeofcmds)
+	if cmdsEnd > len(data) {
+		return errors.New("truncated load commands")
+	}
+
+	// The first section's data ends the room for more commands
+	room := len(data)
+	loaded := make(map[string]bool)
+	for i, off := 0, headerSize; i < int(ncmds); i++ {
+		if off+8 > cmdsEnd {
+			return errors.New("truncated load commands")
+		}
+		cmd, size := le.Uint32(data[off:]), int(le.Uint32(data[off+4:]))
+		if size < 8 || off+size > cmdsEnd {
+			return fmt.Errorf("malformed load command %#x", cmd)
+		}
+		c := data[off : off+size]
+		switch {
+		case cmd == lcSegment || cmd == lcSegment64:
+			if nsectsAt+4 > size {
+				return fmt.Errorf("malformed load command %#x", cmd)
+			}
+			for j := range int(le.Uint32(c[nsectsAt:])) {
+				s := segHeader + j*sectHeader
+				if s+sectHeader > size {
+					return fmt.Errorf("malformed load command %#x", cmd)
+				}
+				// Zero-fill sections have no data in the file, and an offset of 0
+				if o := int(le.Uint32(c[s+sectOffsetAt:])); o > 0 && o < room {
+					room = o
+				}
+			}
+		case slices.Contains(lcLoadCommands, cmd) && size > dylibCommandSize:
+			if nameAt := int(le.Uint32(c[8:])); nameAt < size {
+				name, _, _ := bytes.Cut(c[nameAt:], []byte{0})
+				loaded[string(name)] = true
+			}
+		}
+		off += size
+	}
+
+	for _, load := range loads {
+		if loaded[load] {
+			continue
+		}
+		size := (dylibCommandSize + len(load) + 1 + align - 1) / align * align
+		if cmdsEnd+size > room {
+			return fmt.Errorf("no room for a load command for %s (%d bytes free after the load commands)", load, room-cmdsEnd)
+		}
+		c := data[cmdsEnd : cmdsEnd+size]
+		clear(c)
+		le.PutUint32(c, lcLoadDylib)
+		le.PutUint32(c[4:], uint32(size))
+		le.PutUint32(c[8:], dylibCommandSize)
+		le.PutUint32(c[12:], 2)       // Timestamp, as ld writes it
+		le.PutUint32(c[16:], 0x10000) // Current version 1.0.0
+		le.PutUint32(c[20:], 0x10000) // Compatibility version 1.0.0
+		copy(c[dylibCommandSize:], load)
+		cmdsEnd += size
+		ncmds++
+		loaded[load] = true
+	}
+	le.PutUint32(data[16:], ncmds)
+	le.PutUint32(data[20:], uint32(cmdsEnd-headerSize))
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestTweakFilterTakes reads filter plists in XML and OpenStep form
+func TestTweakFilterTakes(t *testing.T) {
+	xmlFilter := func(bundle string) []byte {
+		return []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0"><dict><key>Filter</key><dict><key>Bundles</key><array><string>` + bundle + `</string></array></dict></dict></plist>`)
+	}
+	for _, c := range []struct {
+		name   string
+		filter []byte
+		want   bool
+	}{
+		{"none", nil, true},
+		{"xml app", xmlFilter("com.example.fixture"), true},
+		{"xml UIKit", xmlFilter("com.apple.UIKit"), true},
+		{"xml springboard", xmlFilter("com.apple.springboard"), false},
+		{"openstep app", []byte(`{ Filter = { Bundles = ( "com.example.fixture" ); }; }`), true},
+		{"openstep springboard", []byte(`{ Filter = { Bundles = ( "com.apple.springboard" ); }; }`), false},
+		{"openstep executable", []byte(`{ Filter = { Executables = ( Fixture ); }; }`), true},
+	} {
+		if got := tweakFilterTakes(c.filter, "com.example.fixture", "Fixture"); got != c.want {
+			t.Errorf("%s: takes the app %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+// TestInjectTweaks loads a merged dylib from the executable once, however often it's
+// injected, and refuses one whose load command doesn't fit
+func TestInjectTweaks(t *testing.T) {
+	store := &SpillStore{Dir: t.TempDir()}
+	executable := &VirtualFile{Name: "Fixture", Data: fixtureMachO, Size: int64(len(fixtureMachO))}
+	entries := []BundleEntry{
+		{RelPath: "Fixture", File: executable},
+		{RelPath: "Frameworks/T.dylib", File: &VirtualFile{Data: fixtureMachO, Size: int64(len(fixtureMachO))}},
+		{RelPath: "Frameworks/SB.dylib", File: &VirtualFile{Data: fixtureMachO, Size: int64(len(fixtureMachO))}},
+	}
+	tweaks := []TweakDylib{
+		{Path: "Frameworks/T.dylib"},
+		{Path: "Frameworks/SB.dylib", Filter: []byte(`{ Filter = { Bundles = ( "com.apple.springboard" ); }; }`)},
+	}
+	var warnings warningLog
+	var err error
+	for range 2 {
+		captureOutput(t, func() {
+			entries, err = injectTweaks(entries, tweaks, "Fixture", "com.example.fixture", store, &warnings)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if dylibs, _ := executableLoads(entries, "Fixture"); !slices.Equal(dylibs, []string{"@executable_path/Frameworks/T.dylib"}) {
+		t.Errorf("executable loads %q, want T.dylib once", dylibs)
+	}
+	if slices.ContainsFunc(entries, func(e BundleEntry) bool { return e.RelPath == "Frameworks/SB.dylib" }) {
+		t.Error("SB.dylib, filtered to SpringBoard, was kept")
+	}
+	if len(warnings.list) != 1 || warnings.list[0].Code != "merge-tweak-filtered" {
+		t.Errorf("warnings %+v, want merge-tweak-filtered for SB.dylib", warnings.list)
+	}
+
+	long := []TweakDylib{{Path: "Frameworks/" + strings.Repeat("x", 64) + ".dylib"}}
+	captureOutput(t, func() {
+		_, err = injectTweaks(entries, long, "Fixture", "com.example.fixture", store, &warnings)
+	})
+	if err == nil || !strings.Contains(err.Error(), "no room") {
+		t.Errorf("injecting past the padding: %v", err)
+	}
+}
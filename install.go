@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// installdErrors explains the installd error codes users actually run into
+var installdErrors = map[string]string{
+	"ApplicationVerificationFailed":              "the app is not signed (or the signature is invalid). Sign it first, or install through TrollStore/AppSync which accept unsigned apps.",
+	"MismatchedApplicationIdentifierEntitlement": "an app with this bundle ID is already installed with a different signing identity. Delete it from the device first.",
+	"DeviceOSVersionTooLow":                      "the device's iOS version is lower than the app's MinimumOSVersion.",
+	"IncorrectArchitecture":                      "the executable has no slice that can run on this device.",
+	"PackageExtractionFailed":                    "the device could not unpack the IPA. Check free space on the device.",
+	"PackagePatchFailed":                         "installd failed to update the existing installation. Try deleting the old app first.",
+	"APIInternalError":                           "installd hit an internal error. Rebooting the device usually clears this.",
+}
+
+// installIPA pushes and installs an IPA on a USB-connected device using ideviceinstaller
+// from libimobiledevice, streaming installd's progress as it goes.
+func installIPA(ipaPath, udid string) error {
+	fmt.Println("\n=> Installing on device...")
+
+	tool, err := exec.LookPath("ideviceinstaller")
+	if err != nil {
+		return fmt.Errorf("ideviceinstaller not found in PATH (install libimobiledevice / ideviceinstaller)")
+	}
+
+	if err := checkDeviceAttached(udid); err != nil {
+		return err
+	}
+
+	args := []string{"-i", ipaPath}
+	if udid != "" {
+		args = append([]string{"-u", udid}, args...)
+	}
+	cmd := exec.Command(tool, args...)
+
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	// Stream output, remembering the first installd error code we recognise
+	var errorCode string
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		scanner := bufio.NewScanner(pr)
+		for scanner.Scan() {
+			line := scanner.Text()
+			fmt.Printf("   %s\n", line)
+			if errorCode == "" {
+				errorCode = matchInstalldError(line)
+			}
+		}
+	}()
+
+	waitErr := cmd.Wait()
+	pw.Close()
+	<-done
+
+	if errorCode != "" {
+		return fmt.Errorf("%s: %s", errorCode, installdErrors[errorCode])
+	}
+	if waitErr != nil {
+		return fmt.Errorf("ideviceinstaller failed: %w", waitErr)
+	}
+
+	fmt.Println("   ✅ Installed")
+	return nil
+}
+
+// checkDeviceAttached gives a friendly error when no (or not the requested) device is connected.
+// Without idevice_id we can't tell, so ideviceinstaller gets to report it instead.
+func checkDeviceAttached(udid string) error {
+	tool, err := exec.LookPath("idevice_id")
+	if err != nil {
+		return nil
+	}
+	out, err := exec.Command(tool, "-l").Output()
+	if err != nil {
+		return nil
+	}
+
+	devices := strings.Fields(string(out))
+	if len(devices) == 0 {
+		return fmt.Errorf("no device attached. Connect one over USB, unlock it and tap \"Trust\"")
+	}
+	if udid == "" {
+		return nil
+	}
+	for _, d := range devices {
+		if d == udid {
+			return nil
+		}
+	}
+	return fmt.Errorf("device %s is not attached (attached: %s)", udid, strings.Join(devices, ", "))
+}
+
+// matchInstalldError returns the known installd error code mentioned in a line, if any
+func matchInstalldError(line string) string {
+	for code := range installdErrors {
+		if strings.Contains(line, code) {
+			return code
+		}
+	}
+	return ""
+}
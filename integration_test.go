@@ -0,0 +1,1347 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"deb-to-ipa/pkg/schema"
+)
+
+// --- Integration tests: fixture debs converted end to end ---
+// Each test builds a deb with buildFixture, runs convert on it as the command line would
+// and reads the IPA back from memory. The fixture's app is Fixture.app: an Info.plist, the
+// Fixture executable and en.lproj/Localizable.strings, with usr/bin/fixture-tool beside
+// it outside the app.
+
+// fixtureApp is where the fixture's app lands in a payload-layout IPA
+const fixtureApp = "Payload/Fixture.app/"
+
+// convertFixture converts the deb spec describes with opts, which default as the flags do,
+// and returns the result and the archive written
+func convertFixture(t *testing.T, spec FixtureSpec, opts Options) (*Result, *zip.Reader) {
+	t.Helper()
+	result, data, err := tryConvertFixture(t, spec, opts)
+	if err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("the output doesn't open as a zip: %v", err)
+	}
+	return result, zr
+}
+
+// tryConvertFixture is convertFixture for conversions that may fail, returning the output's
+// bytes, nil with --extract-to
+func tryConvertFixture(t *testing.T, spec FixtureSpec, opts Options) (*Result, []byte, error) {
+	t.Helper()
+	dir := t.TempDir()
+	debPath := filepath.Join(dir, "fixture.deb")
+	f, err := os.Create(debPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := buildFixture(f, spec); err != nil {
+		t.Fatalf("buildFixture: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if opts.Layout == "" {
+		opts.Layout = LayoutPayload
+	}
+	if opts.Order == "" {
+		opts.Order = OrderTar
+	}
+	if opts.DirEntries == "" {
+		opts.DirEntries = DirEntriesAlways
+	}
+	if opts.CompressSpill == "" {
+		opts.CompressSpill = SpillCompressAuto
+	}
+	if opts.Output == "" {
+		opts.Output = filepath.Join(dir, "out.ipa")
+	}
+	opts.MaxWarnings = -1
+	result, err := convert(debPath, opts)
+	if err != nil || opts.ExtractTo != "" {
+		return result, nil, err
+	}
+	data, err := os.ReadFile(opts.Output)
+	if err != nil {
+		t.Fatalf("reading the output: %v", err)
+	}
+	return result, data, nil
+}
+
+// zipEntries indexes an archive's entries by name
+func zipEntries(zr *zip.Reader) map[string]*zip.File {
+	entries := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		entries[f.Name] = f
+	}
+	return entries
+}
+
+// readZipFile returns an entry's contents
+func readZipFile(t *testing.T, f *zip.File) []byte {
+	t.Helper()
+	rc, err := f.Open()
+	if err != nil {
+		t.Fatalf("%s: %v", f.Name, err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("%s: %v", f.Name, err)
+	}
+	return data
+}
+
+// checkFixtureApp checks the fixture's app is under prefix with its plist, executable and
+// folders, typed as they were written
+func checkFixtureApp(t *testing.T, zr *zip.Reader, prefix string) {
+	t.Helper()
+	entries := zipEntries(zr)
+	if prefix != "" {
+		if dir := entries[prefix]; dir == nil || !dir.Mode().IsDir() {
+			t.Errorf("no %s directory entry", prefix)
+		}
+	}
+	plist := entries[prefix+"Info.plist"]
+	if plist == nil {
+		t.Fatalf("no %sInfo.plist", prefix)
+	}
+	if id := plistValue(readZipFile(t, plist), "CFBundleIdentifier"); id != "com.example.fixture.app0" {
+		t.Errorf("Info.plist CFBundleIdentifier = %q", id)
+	}
+	checkUnixMode(t, entries, prefix+"Fixture", unixTypeReg|0755)
+	checkUnixMode(t, entries, prefix+"en.lproj/", unixTypeDir|0755)
+	checkUnixMode(t, entries, prefix+"en.lproj/Localizable.strings", unixTypeReg|0644)
+}
+
+// checkUnixMode checks the mode in an entry's ExternalAttrs, and that archive/zip agrees on its type
+func checkUnixMode(t *testing.T, entries map[string]*zip.File, name string, want uint32) {
+	t.Helper()
+	f := entries[name]
+	if f == nil {
+		t.Errorf("no %s", name)
+		return
+	}
+	if got := f.ExternalAttrs >> 16; got != want {
+		t.Errorf("%s: ExternalAttrs mode %06o, want %06o", name, got, want)
+	}
+	mode := f.Mode()
+	switch want & unixTypeMask {
+	case unixTypeDir:
+		if !mode.IsDir() {
+			t.Errorf("%s: archive/zip sees %s, want a directory", name, mode)
+		}
+	case unixTypeLink:
+		if mode&os.ModeSymlink == 0 {
+			t.Errorf("%s: archive/zip sees %s, want a symlink", name, mode)
+		}
+	default:
+		if !mode.IsRegular() {
+			t.Errorf("%s: archive/zip sees %s, want a regular file", name, mode)
+		}
+	}
+}
+
+func TestConvertCompressions(t *testing.T) {
+	for _, compression := range []string{"gz", "xz", "lzma", "none"} {
+		t.Run(compression, func(t *testing.T) {
+			_, zr := convertFixture(t, FixtureSpec{Compression: compression}, Options{})
+			checkFixtureApp(t, zr, fixtureApp)
+		})
+	}
+}
+
+func TestConvertUnsupportedCompression(t *testing.T) {
+	_, _, err := tryConvertFixture(t, FixtureSpec{Compression: "zst"}, Options{})
+	if err == nil || !strings.Contains(err.Error(), "unsupported compression") {
+		t.Fatalf("zstd data.tar: got %v, want an unsupported compression error", err)
+	}
+}
+
+func TestConvertVariants(t *testing.T) {
+	for name, spec := range map[string]FixtureSpec{
+		"rootless":     {Rootless: true},
+		"binary-plist": {BinaryPlist: true},
+		"pax":          {PAX: true},
+		"zero-modes":   {ZeroModes: true},
+		"decoy":        {Decoy: true},
+		"data-first":   {DataFirst: true, ControlComp: "xz"},
+		"mixed":        {Compression: "xz", ControlComp: "none"},
+		"launcher":     {Launcher: "after", Rootless: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, zr := convertFixture(t, spec, Options{})
+			checkFixtureApp(t, zr, fixtureApp)
+		})
+	}
+}
+
+// TestConvertFromPipe reads debs through a pipe, as from "cat app.deb | deb-to-ipa
+// /dev/stdin": nothing can be read at an offset, and control.tar may come after data.tar
+func TestConvertFromPipe(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no /dev/fd")
+	}
+	for name, spec := range map[string]FixtureSpec{
+		"control-first": {},
+		"data-first":    {DataFirst: true, Compression: "xz", ControlComp: "gz"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var deb bytes.Buffer
+			if err := buildFixture(&deb, spec); err != nil {
+				t.Fatal(err)
+			}
+			r, w, err := os.Pipe()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			go func() {
+				w.Write(deb.Bytes())
+				w.Close()
+			}()
+
+			output := filepath.Join(t.TempDir(), "out.ipa")
+			opts := Options{Layout: LayoutPayload, Order: OrderTar, DirEntries: DirEntriesAlways, CompressSpill: SpillCompressAuto, Output: output, MaxWarnings: -1}
+			result, err := convert(fmt.Sprintf("/dev/fd/%d", r.Fd()), opts)
+			if err != nil {
+				t.Fatalf("convert from a pipe: %v", err)
+			}
+			if result.Control["Package"] != "com.example.fixture" {
+				t.Errorf("control fields %v, want the fixture's", result.Control)
+			}
+			zr, err := zip.OpenReader(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer zr.Close()
+			checkFixtureApp(t, &zr.Reader, fixtureApp)
+		})
+	}
+}
+
+// TestReadDebLauncher reads a bundle installed outside Applications/ in one pass and in
+// two, with the launcher symlink before and after it: the result mustn't depend on the order
+func TestReadDebLauncher(t *testing.T) {
+	for launcher, prefix := range map[string]string{
+		"before": "usr/share/com.example.fixture/fixture.app/",
+		"after":  "usr/share/com.example.fixture/fixture.app/",
+		"bare":   "opt/com.example.fixture/fixture/",
+	} {
+		debPath := filepath.Join(t.TempDir(), "fixture.deb")
+		f, err := os.Create(debPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := buildFixture(f, FixtureSpec{Launcher: launcher}); err != nil {
+			t.Fatalf("buildFixture: %v", err)
+		}
+		f.Close()
+		for pass, twoPass := range map[string]bool{"one-pass": false, "two-pass": true} {
+			t.Run(launcher+"/"+pass, func(t *testing.T) {
+				deb, err := readDeb(debPath, &SpillStore{Dir: t.TempDir()}, readOptions{Quiet: true, TwoPass: twoPass})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if deb.AppDirPrefix != prefix || deb.appFolderName() != "Fixture.app" {
+					t.Errorf("app folder %q named %q, want %q named Fixture.app", deb.AppDirPrefix, deb.appFolderName(), prefix)
+				}
+				if deb.InfoPlistData == nil {
+					t.Error("the real folder's Info.plist wasn't read")
+				}
+			})
+		}
+	}
+}
+
+func TestReadNestedDebs(t *testing.T) {
+	debPath := filepath.Join(t.TempDir(), "installer.deb")
+	f, err := os.Create(debPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := buildFixture(f, FixtureSpec{Nested: 2}); err != nil {
+		t.Fatalf("buildFixture: %v", err)
+	}
+	f.Close()
+	// Spilled, the nested deb is read from its spill file, or from a copy when compressed
+	for _, c := range []struct {
+		name    string
+		twoPass bool
+		spill   string
+	}{{"one-pass", false, ""}, {"two-pass", true, ""}, {"spilled", true, SpillCompressOff}, {"spilled-compressed", true, SpillCompressOn}} {
+		t.Run(c.name, func(t *testing.T) {
+			store := &SpillStore{Dir: t.TempDir(), Compress: c.spill}
+			ro := readOptions{Quiet: true, TwoPass: c.twoPass, Nested: true}
+			deb, err := readDeb(debPath, store, ro)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, vf := range nestedDebs(deb) {
+				if c.spill != "" {
+					if err := store.spill(vf, bytes.NewReader(vf.Data)); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			var warnings warningLog
+			deb, chain, err := readNestedDebs(deb, store.Dir, store, ro, &warnings)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []string{"var/cache/com.example.fixture/installer-2.deb", "var/cache/com.example.fixture/installer-1.deb"}
+			if deb.AppDirPrefix != "Applications/Fixture.app/" || !reflect.DeepEqual(chain, want) {
+				t.Errorf("app folder %q through %q, want Applications/Fixture.app/ through %q", deb.AppDirPrefix, chain, want)
+			}
+		})
+	}
+}
+
+func TestConvertNested(t *testing.T) {
+	_, _, err := tryConvertFixture(t, FixtureSpec{Nested: 1}, Options{})
+	var notApp *NotAnAppError
+	if !errors.As(err, &notApp) || notApp.Kinds[0] != DebKindNested || !strings.Contains(err.Error(), "--recurse-nested") {
+		t.Errorf("converting an installer: %v, want a nested-deb NotAnAppError pointing at --recurse-nested", err)
+	}
+
+	result, zr := convertFixture(t, FixtureSpec{Nested: 1}, Options{RecurseNested: true})
+	checkFixtureApp(t, zr, fixtureApp)
+	if len(result.Nested) != 1 || result.Nested[0] != "var/cache/com.example.fixture/installer-1.deb" {
+		t.Errorf("Result.Nested = %q, want the installer's deb", result.Nested)
+	}
+
+	if _, _, err := tryConvertFixture(t, FixtureSpec{Nested: maxNestedDepth + 1}, Options{RecurseNested: true}); err == nil || !strings.Contains(err.Error(), "nested more than") {
+		t.Errorf("converting debs nested %d deep: %v, want the depth refused", maxNestedDepth+1, err)
+	}
+}
+
+func TestConvertLayouts(t *testing.T) {
+	for layout, prefix := range map[string]string{LayoutPayload: fixtureApp, LayoutApp: "Fixture.app/", LayoutFlat: ""} {
+		t.Run(layout, func(t *testing.T) {
+			opts := Options{Layout: layout}
+			if layout != LayoutPayload {
+				opts.Output = filepath.Join(t.TempDir(), "out.zip")
+			}
+			_, zr := convertFixture(t, FixtureSpec{}, opts)
+			checkFixtureApp(t, zr, prefix)
+		})
+	}
+}
+
+// TestConvertNumericOwner converts a deb tarred with --numeric-owner in a container, whose
+// uid and gid only fit PAX records: the IPA opens in archive/zip and Info-ZIP, carries no
+// uid/gid extra field, and the ownership report still has the exact IDs
+func TestConvertNumericOwner(t *testing.T) {
+	const uid int64 = 4000000000
+	result, data, err := tryConvertFixture(t, FixtureSpec{UID: uid}, Options{})
+	if err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	ipaPath := filepath.Join(t.TempDir(), "out.ipa")
+	if err := os.WriteFile(ipaPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.OpenReader(ipaPath)
+	if err != nil {
+		t.Fatalf("the output doesn't open: %v", err)
+	}
+	defer zr.Close()
+	checkFixtureApp(t, &zr.Reader, fixtureApp)
+	for _, f := range zr.File {
+		for extra := f.Extra; len(extra) >= 4; {
+			if id := binary.LittleEndian.Uint16(extra); id == 0x7875 || id == 0x7855 {
+				t.Errorf("%s has a uid/gid extra field %#04x", f.Name, id)
+			}
+			extra = extra[min(len(extra), 4+int(binary.LittleEndian.Uint16(extra[2:]))):]
+		}
+	}
+	if len(result.Ownership) != 1 || result.Ownership[0].UID != uid || result.Ownership[0].GID != uid {
+		t.Errorf("ownership %+v, want everything owned by %d:%d", result.Ownership, uid, uid)
+	}
+	// Info-ZIP reads the same archive, when installed
+	if tree, want := unzipTree(t, data), zipTree(t, data); tree != nil && !reflect.DeepEqual(tree, want) {
+		t.Errorf("unzips to\n%v\nwant\n%v", tree, want)
+	}
+}
+
+// TestConvertDirEntries converts with each --dir-entries and extracts the archives with
+// archive/zip and, when installed, Info-ZIP's unzip: the trees must be the same
+func TestConvertDirEntries(t *testing.T) {
+	spec := FixtureSpec{Framework: true, Symlinks: true}
+	var want map[string]string
+	for _, policy := range []string{DirEntriesAlways, DirEntriesNever, DirEntriesAuto} {
+		_, data, err := tryConvertFixture(t, spec, Options{DirEntries: policy})
+		if err != nil {
+			t.Fatalf("--dir-entries %s: %v", policy, err)
+		}
+		tree := zipTree(t, data)
+		if dirs := zipDirEntries(t, data); policy != DirEntriesAlways && len(dirs) != 0 {
+			t.Errorf("--dir-entries %s wrote folder entries %v for a bundle with no empty folders", policy, dirs)
+		}
+		if want == nil {
+			want = tree
+		} else if !reflect.DeepEqual(tree, want) {
+			t.Errorf("--dir-entries %s extracts to\n%v\nwant\n%v", policy, tree, want)
+		}
+		if unzipTree := unzipTree(t, data); unzipTree != nil && !reflect.DeepEqual(unzipTree, want) {
+			t.Errorf("--dir-entries %s unzips to\n%v\nwant\n%v", policy, unzipTree, want)
+		}
+	}
+
+	// auto keeps the one folder nothing else implies; never loses it, with a warning
+	spec.EmptyDir = true
+	result, data, err := tryConvertFixture(t, spec, Options{DirEntries: DirEntriesAuto})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dirs := zipDirEntries(t, data); !reflect.DeepEqual(dirs, []string{fixtureApp + "Base.lproj/"}) {
+		t.Errorf("--dir-entries auto wrote folder entries %v, want just the empty Base.lproj", dirs)
+	}
+	if len(result.Warnings) != 0 {
+		t.Errorf("--dir-entries auto warned: %v", result.Warnings)
+	}
+	result, _, err = tryConvertFixture(t, spec, Options{DirEntries: DirEntriesNever})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Warnings) != 1 || result.Warnings[0].Code != "dir-entries-empty" {
+		t.Errorf("--dir-entries never with an empty folder warned %v, want dir-entries-empty", result.Warnings)
+	}
+}
+
+// zipDirEntries lists an archive's folder entries
+func zipDirEntries(t *testing.T, data []byte) []string {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dirs []string
+	for _, f := range zr.File {
+		if strings.HasSuffix(f.Name, "/") {
+			dirs = append(dirs, f.Name)
+		}
+	}
+	return dirs
+}
+
+// zipTree is what an archive extracts to, read with archive/zip: by path, "dir", the
+// file's contents or "-> target" for a symlink. Folders implied by their contents count.
+func zipTree(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := make(map[string]string)
+	for _, f := range zr.File {
+		name := strings.TrimSuffix(f.Name, "/")
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			tree[dir] = "dir"
+		}
+		switch {
+		case f.Mode().IsDir():
+			tree[name] = "dir"
+		case f.Mode()&os.ModeSymlink != 0:
+			tree[name] = "-> " + string(readZipFile(t, f))
+		default:
+			tree[name] = string(readZipFile(t, f))
+		}
+	}
+	return tree
+}
+
+// unzipTree is zipTree for the tree Info-ZIP's unzip extracts, nil when it isn't installed
+func unzipTree(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	unzip, err := exec.LookPath("unzip")
+	if err != nil {
+		return nil
+	}
+	dir := t.TempDir()
+	ipaPath := filepath.Join(dir, "out.ipa")
+	if err := os.WriteFile(ipaPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(dir, "x")
+	if out, err := exec.Command(unzip, "-q", ipaPath, "-d", root).CombinedOutput(); err != nil {
+		t.Fatalf("unzip: %v\n%s", err, out)
+	}
+	tree := make(map[string]string)
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || p == root {
+			return err
+		}
+		rel, _ := filepath.Rel(root, p)
+		rel = filepath.ToSlash(rel)
+		switch {
+		case d.Type()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(p)
+			tree[rel] = "-> " + target
+			return err
+		case d.IsDir():
+			tree[rel] = "dir"
+		default:
+			data, err := os.ReadFile(p)
+			tree[rel] = string(data)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+// TestCIGitHub runs --ci github's presentation over a conversion with warnings, the
+// runner's environment faked
+func TestCIGitHub(t *testing.T) {
+	dir := t.TempDir()
+	env := map[string]string{
+		"GITHUB_STEP_SUMMARY": filepath.Join(dir, "summary.md"),
+		"GITHUB_OUTPUT":       filepath.Join(dir, "output"),
+	}
+	for mode, active := range map[string]bool{"": false, CIAuto: false, CIGitHub: true} {
+		c, err := newCIOutput(mode, io.Discard, func(key string) string { return env[key] })
+		if err != nil || (c != nil) != active {
+			t.Errorf("--ci %q outside Actions: got %v, %v; want active %v", mode, c, err, active)
+		}
+	}
+	if _, err := newCIOutput("gitlab", io.Discard, os.Getenv); err == nil {
+		t.Error("--ci gitlab was accepted")
+	}
+
+	env["GITHUB_ACTIONS"] = "true"
+	var out bytes.Buffer
+	c, err := newCIOutput(CIAuto, &out, func(key string) string { return env[key] })
+	if err != nil || c == nil {
+		t.Fatalf("--ci auto in Actions: got %v, %v", c, err)
+	}
+	result, _, err := tryConvertFixture(t, FixtureSpec{UID: 1234}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.annotate(result.Warnings)
+	if err := c.finish("fixture.deb", result); err != nil {
+		t.Fatal(err)
+	}
+	if want := "::warning file=Fixture,title=unusual-owner::The main executable was owned by 1234:1234"; !strings.Contains(out.String(), want) {
+		t.Errorf("annotations:\n%s\nwant a line starting %q", out.String(), want)
+	}
+
+	_, sha, err := fileSHA256(result.OutputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputs, _ := os.ReadFile(env["GITHUB_OUTPUT"])
+	for _, line := range []string{"ipa=" + result.OutputPath, "bundle-id=com.example.fixture.app0", "version=1.0", "sha256=" + sha} {
+		if !strings.Contains(string(outputs), line+"\n") {
+			t.Errorf("$GITHUB_OUTPUT:\n%s\nwant %q", outputs, line)
+		}
+	}
+	summary, _ := os.ReadFile(env["GITHUB_STEP_SUMMARY"])
+	for _, row := range []string{"| Bundle ID | `com.example.fixture.app0` |", "| SHA256 | `" + sha + "` |", "| warning | `unusual-owner` | Fixture |"} {
+		if !strings.Contains(string(summary), row) {
+			t.Errorf("$GITHUB_STEP_SUMMARY:\n%s\nwant a row with %q", summary, row)
+		}
+	}
+
+	out.Reset()
+	c.fail(ResultCodeNotAnApp, errors.New("no .app folder: 100% sure"))
+	if want := "::error title=deb-to-ipa%3A not-an-app::no .app folder: 100%25 sure\n"; out.String() != want {
+		t.Errorf("failure annotation %q, want %q", out.String(), want)
+	}
+}
+
+// TestConvertAppClip converts a deb whose App Clip comes first in data.tar: the app is
+// still the outer bundle, the clip stays nested with its binary made executable, and
+// --strip-appclips leaves it out
+func TestConvertAppClip(t *testing.T) {
+	clip := fixtureApp + "AppClips/FixtureClip.app/"
+	result, zr := convertFixture(t, FixtureSpec{AppClip: true}, Options{})
+	entries := zipEntries(zr)
+	checkFixtureApp(t, zr, fixtureApp)
+	checkUnixMode(t, entries, clip+"FixtureClip", unixTypeReg|0755)
+	if entries[clip+"Info.plist"] == nil {
+		t.Errorf("no %sInfo.plist", clip)
+	}
+	want := []AppClip{{Path: "AppClips/FixtureClip.app", BundleID: "com.example.fixture.app0.Clip", Executable: "FixtureClip", MinOS: "14.0", ValidID: true}}
+	if !reflect.DeepEqual(result.AppClips, want) || len(result.Warnings) != 0 {
+		t.Errorf("App Clips %+v with warnings %v, want %+v and none", result.AppClips, result.Warnings, want)
+	}
+	if prefix := bundlePrefix("AppClips/FixtureClip.app/Info.plist", BundleExtApp); prefix != "" {
+		t.Errorf("a stray App Clip was taken for the app: %q", prefix)
+	}
+
+	result, zr = convertFixture(t, FixtureSpec{AppClip: true}, Options{StripAppClips: true})
+	for name := range zipEntries(zr) {
+		if strings.Contains(name, "AppClips") {
+			t.Errorf("--strip-appclips left %s", name)
+		}
+	}
+	if len(result.AppClips) != 1 || !result.AppClips[0].Removed {
+		t.Errorf("--strip-appclips: App Clips %+v, want the one marked removed", result.AppClips)
+	}
+
+	result, _ = convertFixture(t, FixtureSpec{AppClip: true}, Options{BundleID: "com.other.app"})
+	if len(result.Warnings) != 1 || result.Warnings[0].Code != "appclip-id" {
+		t.Errorf("--bundle-id with an App Clip warned %v, want appclip-id", result.Warnings)
+	}
+}
+
+func TestConvertModes(t *testing.T) {
+	_, zr := convertFixture(t, FixtureSpec{Symlinks: true, Framework: true, Dylib: true, ZeroModes: true}, Options{})
+	entries := zipEntries(zr)
+	checkFixtureApp(t, zr, fixtureApp)
+	// Recorded as 0644 (or 0 with ZeroModes), laundered to executable
+	checkUnixMode(t, entries, fixtureApp+"libFixture.dylib", unixTypeReg|0755)
+	checkUnixMode(t, entries, fixtureApp+"Frameworks/Fixture.framework/Fixture", unixTypeReg|0755)
+	checkUnixMode(t, entries, fixtureApp+"Frameworks/", unixTypeDir|0755)
+	checkUnixMode(t, entries, fixtureApp+"Frameworks/Fixture.framework/", unixTypeDir|0755)
+
+	for link, target := range map[string]string{
+		fixtureApp + "Localizable.strings": "en.lproj/Localizable.strings",
+		fixtureApp + "Frameworks/Current":  "Fixture.framework",
+	} {
+		checkUnixMode(t, entries, link, unixTypeLink|0777)
+		if f := entries[link]; f != nil {
+			if got := string(readZipFile(t, f)); got != target {
+				t.Errorf("%s points at %q, want %q", link, got, target)
+			}
+		}
+	}
+}
+
+func TestConvertStoragePolicy(t *testing.T) {
+	spec := FixtureSpec{Framework: true, FrameworkPad: 2 << 20, Dylib: true}
+	framework, dylib := fixtureApp+"Frameworks/Fixture.framework/Fixture", fixtureApp+"libFixture.dylib"
+	for _, tc := range []struct {
+		name            string
+		opts            Options
+		frameworkMethod uint16
+		dylibMode       uint32
+	}{
+		{"default", Options{StoreMachOMin: defaultStoreMachOMin}, zip.Store, 0755},
+		{"main executable only", Options{}, zip.Deflate, 0755},
+		{"no-exec-glob", Options{StoreMachOMin: defaultStoreMachOMin, ExecGlobs: []execGlob{{Pattern: "*.dylib"}}}, zip.Store, 0644},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, zr := convertFixture(t, spec, tc.opts)
+			entries := zipEntries(zr)
+			// The large framework binary is stored, the tiny dylib deflated; both executable
+			// unless a glob says otherwise
+			checkUnixMode(t, entries, framework, unixTypeReg|0755)
+			checkUnixMode(t, entries, dylib, unixTypeReg|tc.dylibMode)
+			checkUnixMode(t, entries, fixtureApp+"Fixture", unixTypeReg|0755)
+			for name, want := range map[string]uint16{framework: tc.frameworkMethod, dylib: zip.Deflate, fixtureApp + "Fixture": zip.Store} {
+				if f := entries[name]; f != nil && f.Method != want {
+					t.Errorf("%s: method %s, want %s", name, methodName(f.Method), methodName(want))
+				}
+			}
+		})
+	}
+}
+
+func TestConvertOutputBudget(t *testing.T) {
+	spec := FixtureSpec{Framework: true, FrameworkPad: 2 << 20}
+	dir := t.TempDir()
+	output := filepath.Join(dir, "over.ipa")
+	_, _, err := tryConvertFixture(t, spec, Options{StoreMachOMin: defaultStoreMachOMin, MaxOutputSize: 1 << 20, Output: output})
+	var budget *OutputBudgetError
+	if !errors.As(err, &budget) {
+		t.Fatalf("got %v, want an *OutputBudgetError", err)
+	}
+	if !budget.Exceeded || budget.Written > budget.Budget || len(budget.Largest) == 0 || budget.Largest[0].Path != "Frameworks/" {
+		t.Errorf("budget %+v, want it exceeded within the budget and Frameworks/ suggested first", budget.OutputBudget)
+	}
+	if left, _ := os.ReadDir(dir); len(left) != 0 {
+		t.Errorf("left behind after tripping the budget: %v", left)
+	}
+
+	result, data, err := tryConvertFixture(t, spec, Options{StoreMachOMin: defaultStoreMachOMin, MaxOutputSize: 4 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := result.OutputBudget; b == nil || b.Exceeded || b.Written != int64(len(data)) || b.Estimate < b.Written {
+		t.Errorf("budget %+v for a %d-byte IPA, want it counted exactly and estimated at no less", b, len(data))
+	}
+}
+
+func TestConvertSkipsContent(t *testing.T) {
+	_, zr := convertFixture(t, FixtureSpec{Decoy: true, PAX: true}, Options{Exclude: []string{"en.lproj/**"}})
+	for _, f := range zr.File {
+		switch {
+		case strings.Contains(f.Name, "fixture-tool"):
+			t.Errorf("%s: outside the app, but in the IPA", f.Name)
+		case strings.Contains(f.Name, "Decoy.app"):
+			t.Errorf("%s: from the decoy theme folder, but in the IPA", f.Name)
+		case strings.Contains(f.Name, "en.lproj/Localizable.strings"):
+			t.Errorf("%s: excluded, but in the IPA", f.Name)
+		case strings.Contains(f.Name, "._"):
+			t.Errorf("%s: an AppleDouble file, but in the IPA", f.Name)
+		}
+	}
+}
+
+// TestReadFilterCountsApp reads a deb with a decoy bundle folder ahead of the app: --exclude
+// matches there are dropped with the decoy, in either pass
+func TestReadFilterCountsApp(t *testing.T) {
+	debPath := filepath.Join(t.TempDir(), "fixture.deb")
+	f, err := os.Create(debPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := buildFixture(f, FixtureSpec{Decoy: true}); err != nil {
+		t.Fatalf("buildFixture: %v", err)
+	}
+	f.Close()
+	for pass, twoPass := range map[string]bool{"one-pass": false, "two-pass": true} {
+		t.Run(pass, func(t *testing.T) {
+			filter, err := newPathFilter(nil, []string{"AppIcon*.png", "en.lproj/**"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := readDeb(debPath, &SpillStore{Dir: t.TempDir()}, readOptions{Quiet: true, TwoPass: twoPass, Filter: filter}); err != nil {
+				t.Fatal(err)
+			}
+			if icons, lproj := filter.Exclude[0].Matches, filter.Exclude[1].Matches; icons != 0 || lproj == 0 {
+				t.Errorf("matched %d icons and %d en.lproj entries, want only the app's en.lproj", icons, lproj)
+			}
+		})
+	}
+}
+
+func TestConvertResult(t *testing.T) {
+	spec := FixtureSpec{Package: "com.example.result", Version: "2.5", BinaryPlist: true, DataFirst: true}
+	result, _ := convertFixture(t, spec, Options{EmbedOrigin: true})
+	for _, c := range []struct{ field, got, want string }{
+		{"AppName", result.AppName, "Fixture.app"},
+		{"BundleID", result.BundleID, "com.example.result.app0"},
+		{"Version", result.Version, "2.5"},
+		{"Executable", result.Executable, "Fixture"},
+		{"MinOS", result.MinOS, "14.0"},
+	} {
+		if c.got != c.want {
+			t.Errorf("Result.%s = %q, want %q", c.field, c.got, c.want)
+		}
+	}
+	// control.tar comes after data.tar here, and is still read
+	if result.Origin == nil || result.Origin.Package != "com.example.result" {
+		t.Errorf("Result.Origin = %+v, want package com.example.result from control.tar", result.Origin)
+	}
+}
+
+func TestConvertUniversal(t *testing.T) {
+	dir := t.TempDir()
+	arm64e := filepath.Join(dir, "arm64e.deb")
+	f, err := os.Create(arm64e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := buildFixture(f, FixtureSpec{Framework: true, Arm64e: true}); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	result, zr := convertFixture(t, FixtureSpec{Framework: true}, Options{Universal: arm64e})
+	if result.Universal == nil || len(result.Universal.Merged) != 2 {
+		t.Fatalf("Result.Universal = %+v, want the executable and framework merged", result.Universal)
+	}
+	entries := zipEntries(zr)
+	checkUnixMode(t, entries, fixtureApp+"Fixture", unixTypeReg|0755)
+	for _, name := range []string{fixtureApp + "Fixture", fixtureApp + "Frameworks/Fixture.framework/Fixture"} {
+		slices, err := fatSlices(readZipFile(t, entries[name]))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		var arches []string
+		for _, s := range slices {
+			arches = append(arches, s.arch())
+		}
+		if strings.Join(arches, ",") != "arm64,arm64e" {
+			t.Errorf("%s has %v, want arm64 and arm64e", name, arches)
+		}
+	}
+
+	if _, _, err := tryConvertFixture(t, FixtureSpec{Build: "2"}, Options{Universal: arm64e}); err == nil || !strings.Contains(err.Error(), "CFBundleVersion") {
+		t.Errorf("mismatched builds: got %v, want a CFBundleVersion error", err)
+	}
+}
+
+func TestConvertExecutableFolder(t *testing.T) {
+	result, zr := convertFixture(t, FixtureSpec{ExecFolder: true}, Options{})
+	if result.Executable != "Fixture-bin" || result.ExecutableSource != ExecutableFromHeuristic {
+		t.Errorf("executable %q from %s, want Fixture-bin found by the Mach-O scan", result.Executable, result.ExecutableSource)
+	}
+	entries := zipEntries(zr)
+	checkUnixMode(t, entries, fixtureApp+"Fixture/", unixTypeDir|0755)
+	checkUnixMode(t, entries, fixtureApp+"Fixture/Fixture", unixTypeReg|0644)
+	checkUnixMode(t, entries, fixtureApp+"Fixture-bin", unixTypeReg|0755)
+	if f := entries[fixtureApp+"Fixture/Fixture"]; f != nil && f.Method != zip.Deflate {
+		t.Errorf("the resource named like the executable is stored (method %d), not deflated", f.Method)
+	}
+	if f := entries[fixtureApp+"Fixture-bin"]; f != nil && f.Method != zip.Store {
+		t.Errorf("the main executable is deflated (method %d), not stored", f.Method)
+	}
+}
+
+func TestConvertExecutableSize(t *testing.T) {
+	_, _, err := tryConvertFixture(t, FixtureSpec{Placeholder: true}, Options{})
+	var tiny *ExecutableSizeError
+	if !errors.As(err, &tiny) || tiny.Name != "Fixture" || tiny.Size != 0 || conversionErrorCode(err) != ResultCodeExecutable {
+		t.Errorf("converting a zero-byte executable: %v, want an ExecutableSizeError for Fixture", err)
+	}
+
+	// The fixture's binary is a bare header, far under the default threshold
+	result, _ := convertFixture(t, FixtureSpec{}, Options{MinExecutable: defaultMinExecutableSize})
+	warned := false
+	for _, w := range result.Warnings {
+		warned = warned || w.Code == "executable-small" && w.Path == "Fixture"
+	}
+	if !warned {
+		t.Errorf("warnings %+v, want executable-small for Fixture", result.Warnings)
+	}
+}
+
+func TestConvertExcludeFramework(t *testing.T) {
+	spec := FixtureSpec{Framework: true, Dylib: true, Links: []string{"@rpath/Fixture.framework/Fixture", "weak:@executable_path/libFixture.dylib"}}
+	result, zr := convertFixture(t, spec, Options{ExcludeFrameworks: []string{"Fixture", "libFix*"}})
+	for name := range zipEntries(zr) {
+		if strings.Contains(name, "Fixture.framework") || strings.HasSuffix(name, ".dylib") {
+			t.Errorf("%s: excluded, but in the IPA", name)
+		}
+	}
+	if len(result.Excluded) != 2 {
+		t.Fatalf("Result.Excluded = %+v, want the framework and the dylib", result.Excluded)
+	}
+	if fw := result.Excluded[0]; fw.Path != "Frameworks/Fixture.framework" || fw.Files < 3 || fw.LoadPath != "@rpath/Fixture.framework/Fixture" {
+		t.Errorf("excluded framework %+v, want Frameworks/Fixture.framework and its contents, hard-linked", fw)
+	}
+	if dylib := result.Excluded[1]; dylib.Path != "libFixture.dylib" || dylib.Size != int64(len(fixtureMachO)) || dylib.LoadPath != "" {
+		t.Errorf("excluded dylib %+v, want libFixture.dylib, only weakly linked", dylib)
+	}
+	linked := 0
+	for _, w := range result.Warnings {
+		if w.Code == "excluded-framework-linked" {
+			linked++
+			if w.Path != "Frameworks/Fixture.framework" || w.Severity != SeverityError {
+				t.Errorf("warning %+v, want an error about the framework", w)
+			}
+		}
+	}
+	if linked != 1 {
+		t.Errorf("%d excluded-framework-linked warnings, want 1: %+v", linked, result.Warnings)
+	}
+}
+
+func TestConvertNotAnApp(t *testing.T) {
+	_, _, err := tryConvertFixture(t, FixtureSpec{AppSuffix: "-1.3"}, Options{})
+	var notApp *NotAnAppError
+	if !errors.As(err, &notApp) {
+		t.Fatalf("versioned .app folder: got %v, want a NotAnAppError", err)
+	}
+	if len(notApp.Versioned) != 1 || notApp.Versioned[0] != "Applications/Fixture.app-1.3" {
+		t.Errorf("NotAnAppError.Versioned = %q", notApp.Versioned)
+	}
+}
+
+func TestFixPermissions(t *testing.T) {
+	_, data, err := tryConvertFixture(t, FixtureSpec{Framework: true, Dylib: true}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Break it as older converters did: every file a plain 0644, or no Unix attributes at all
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var broken bytes.Buffer
+	zw := zip.NewWriter(&broken)
+	for i, f := range zr.File {
+		header := f.FileHeader
+		if !strings.HasSuffix(f.Name, "/") {
+			header.ExternalAttrs = (unixTypeReg | 0644) << 16
+			if i%2 == 0 {
+				header.CreatorVersion, header.ExternalAttrs = 20, 0
+			}
+		}
+		w, err := zw.CreateRaw(&header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if raw, err := f.OpenRaw(); err == nil && !strings.HasSuffix(f.Name, "/") {
+			io.Copy(w, raw)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var fixed bytes.Buffer
+	fixes, _, err := fixPermissions(bytes.NewReader(broken.Bytes()), int64(broken.Len()), &fixed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fixes) == 0 {
+		t.Fatal("nothing fixed in an IPA with broken modes")
+	}
+	fzr, err := zip.NewReader(bytes.NewReader(fixed.Bytes()), int64(fixed.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := zipEntries(fzr)
+	checkFixtureApp(t, fzr, fixtureApp)
+	checkUnixMode(t, entries, fixtureApp+"Fixture", unixTypeReg|0755)
+	checkUnixMode(t, entries, fixtureApp+"libFixture.dylib", unixTypeReg|0755)
+	checkUnixMode(t, entries, fixtureApp+"Frameworks/Fixture.framework/Fixture", unixTypeReg|0755)
+	checkUnixMode(t, entries, fixtureApp+"Info.plist", unixTypeReg|0644)
+	for _, f := range zr.File {
+		if g := entries[f.Name]; g != nil && (g.CRC32 != f.CRC32 || g.Method != f.Method || g.CompressedSize64 != f.CompressedSize64) {
+			t.Errorf("%s: payload changed: method %d -> %d, CRC32 %08x -> %08x", f.Name, f.Method, g.Method, f.CRC32, g.CRC32)
+		}
+	}
+
+	var again bytes.Buffer
+	fixes, _, err = fixPermissions(bytes.NewReader(fixed.Bytes()), int64(fixed.Len()), &again)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fixes) != 0 || !bytes.Equal(again.Bytes(), fixed.Bytes()) {
+		t.Errorf("a second fix-perms changed %d entries, want none and the same bytes", len(fixes))
+	}
+}
+
+// ipcFrontEnd connects ipc to one end of a socketpair, as --ipc would to a front end, and
+// collects the events sent to the other until ipc closes
+func ipcFrontEnd(t *testing.T) (net.Conn, <-chan []IPCEvent) {
+	t.Helper()
+	tool, frontEnd := net.Pipe()
+	ipc = newIPCConn(tool)
+	t.Cleanup(func() { ipc = nil; frontEnd.Close() })
+	events := make(chan []IPCEvent, 1)
+	go func() {
+		var got []IPCEvent
+		for {
+			data, err := readIPCMessage(frontEnd, ipcMaxEvent)
+			if err != nil {
+				events <- got
+				return
+			}
+			var event IPCEvent
+			if err := json.Unmarshal(data, &event); err != nil {
+				t.Errorf("event %q: %v", data, err)
+			}
+			got = append(got, event)
+		}
+	}()
+	return frontEnd, events
+}
+
+func TestIPCEvents(t *testing.T) {
+	_, events := ipcFrontEnd(t)
+	result, _ := convertFixture(t, FixtureSpec{Dylib: true}, Options{})
+	ipc.close(IPCEvent{Status: ResultStatusOK, Result: result})
+	if err := ipc.err(); err != nil {
+		t.Errorf("err() after a normal close is %v, want nil: later reads would fail as cancelled", err)
+	}
+
+	got := <-events
+	phases := map[string]bool{}
+	warned := false
+	for _, event := range got {
+		switch event.Event {
+		case "phase":
+			phases[event.Phase] = true
+		case "warning":
+			warned = warned || event.Warning != nil && event.Warning.Code != ""
+		}
+	}
+	if !phases["Extracting"] || !phases["Writing IPA"] {
+		t.Errorf("phases %v, want Extracting and Writing IPA", phases)
+	}
+	if !warned {
+		t.Error("no warning event for the unlinked root dylib")
+	}
+	if len(got) == 0 || got[len(got)-1].Event != "result" {
+		t.Fatalf("last event isn't the result: %+v", got)
+	}
+	if last := got[len(got)-1]; last.Status != ResultStatusOK || last.Result == nil || last.Result.BundleID != result.BundleID {
+		t.Errorf("result event %+v, want status ok and the result", last)
+	}
+}
+
+// TestConvertProgressComplete converts a deb that's 70% content outside the app: the
+// Writing IPA bar is sized from the app alone and ends full, never going backwards
+func TestConvertProgressComplete(t *testing.T) {
+	_, events := ipcFrontEnd(t)
+	spec := FixtureSpec{LargeFile: 3 << 20, ExtraData: 7 << 20, Symlinks: true, EmptyDir: true}
+	result, zr := convertFixture(t, spec, Options{})
+	ipc.close(IPCEvent{Status: ResultStatusOK, Result: result})
+
+	var appBytes int64
+	for _, f := range zr.File {
+		if !f.Mode().IsDir() && f.Mode()&os.ModeSymlink == 0 {
+			appBytes += int64(f.UncompressedSize64)
+		}
+	}
+	var total, done int64 = -1, 0
+	for _, event := range <-events {
+		if event.Phase != "Writing IPA" {
+			continue
+		}
+		switch event.Event {
+		case "phase":
+			total = event.Total
+		case "progress":
+			if event.Done < done {
+				t.Errorf("progress went back from %d to %d", done, event.Done)
+			}
+			done = event.Done
+		}
+	}
+	if total != appBytes {
+		t.Errorf("Writing IPA sized for %d bytes, want the app's %d", total, appBytes)
+	}
+	if done != total {
+		t.Errorf("Writing IPA ended at %d of %d bytes", done, total)
+	}
+}
+
+func TestIPCCancel(t *testing.T) {
+	frontEnd, _ := ipcFrontEnd(t)
+	cancel, _ := json.Marshal(IPCCommand{Command: "cancel"})
+	if err := writeIPCMessage(frontEnd, cancel); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ipc.err() == nil; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the cancel command didn't cancel")
+		}
+	}
+
+	_, _, err := tryConvertFixture(t, FixtureSpec{}, Options{})
+	if err == nil {
+		t.Fatal("a cancelled conversion succeeded")
+	}
+	if code := conversionErrorCode(err); code != ResultCodeCancelled {
+		t.Errorf("code %q for %v, want %q", code, err, ResultCodeCancelled)
+	}
+}
+
+// checkSchemaRoundTrip decodes a document's JSON into its pkg/schema struct and encodes it
+// again: every field the struct declares must come back as written, and when exact,
+// the struct must declare everything written
+func checkSchemaRoundTrip(t *testing.T, data []byte, doc any, id string, exact bool) {
+	t.Helper()
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if exact {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(doc); err != nil {
+		t.Errorf("%s: decoding into %T: %v", id, doc, err)
+		return
+	}
+	again, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatalf("%s: %v", id, err)
+	}
+	var written, read map[string]any
+	if err := json.Unmarshal(data, &written); err != nil {
+		t.Fatalf("%s: %v", id, err)
+	}
+	if err := json.Unmarshal(again, &read); err != nil {
+		t.Fatalf("%s: %v", id, err)
+	}
+	if written["schema"] != id {
+		t.Errorf("schema %v, want %s", written["schema"], id)
+	}
+	if exact && !reflect.DeepEqual(written, read) {
+		t.Errorf("%s: %s came back as %s", id, data, again)
+	}
+	for key, value := range read {
+		if !jsonSubset(written[key], value) {
+			t.Errorf("%s: %q was %v, came back as %v", id, key, written[key], value)
+		}
+	}
+}
+
+// jsonSubset reports whether decoded JSON read holds only what written does, object keys
+// perhaps fewer but every value the same
+func jsonSubset(written, read any) bool {
+	switch read := read.(type) {
+	case map[string]any:
+		w, ok := written.(map[string]any)
+		if !ok {
+			return false
+		}
+		for key, value := range read {
+			if !jsonSubset(w[key], value) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		w, ok := written.([]any)
+		if !ok || len(w) != len(read) {
+			return false
+		}
+		for i := range read {
+			if !jsonSubset(w[i], read[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(written, read)
+}
+
+func TestSchemaRoundTrip(t *testing.T) {
+	_, events := ipcFrontEnd(t)
+	spec := FixtureSpec{Package: "com.example.schema", Version: "3.1", Dylib: true}
+	result, zr := convertFixture(t, spec, Options{EmbedOrigin: true, Manifest: true, SizeReport: true})
+	dir := t.TempDir()
+
+	reportPath := filepath.Join(dir, "report.json")
+	if err := writeReport(reportPath, result); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(reportPath)
+	checkSchemaRoundTrip(t, data, &schema.ReportDocument{}, schema.Report, true)
+
+	manifestPath := filepath.Join(dir, "out.ipa.manifest.json")
+	if err := writeManifest(manifestPath, result.Manifest); err != nil {
+		t.Fatal(err)
+	}
+	data, _ = os.ReadFile(manifestPath)
+	checkSchemaRoundTrip(t, data, &schema.ManifestDocument{}, schema.Manifest, true)
+	for _, p := range []string{manifestPath, reportPath} {
+		if m, err := readManifest(p); err != nil || len(m.Entries) != len(result.Manifest.Entries) {
+			t.Errorf("readManifest(%s) = %v, want the manifest back", filepath.Base(p), err)
+		}
+	}
+
+	data = readZipFile(t, zipEntries(zr)[fixtureApp+OriginFileName])
+	checkSchemaRoundTrip(t, data, &schema.OriginDocument{}, schema.Origin, true)
+
+	data, _ = json.Marshal(buildDepiction(result, ""))
+	checkSchemaRoundTrip(t, data, &schema.DepictionDocument{}, schema.Depiction, true)
+
+	debPath := filepath.Join(dir, "fixture.deb")
+	f, err := os.Create(debPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := buildFixture(f, spec); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	data, _ = json.Marshal(diagnose(debPath))
+	checkSchemaRoundTrip(t, data, &schema.DoctorDocument{}, schema.Doctor, true)
+
+	newSpec := spec
+	newSpec.Version, newSpec.Framework = "3.2", true
+	newPath := filepath.Join(dir, "new.deb")
+	if f, err = os.Create(newPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := buildFixture(f, newSpec); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	stdout, _ := captureOutput(t, func() { runDiff([]string{"--json", debPath, newPath}) })
+	checkSchemaRoundTrip(t, []byte(stdout), &schema.DiffDocument{}, schema.Diff, true)
+
+	stdout, _ = captureOutput(t, func() { printSizeReport(result.SizeReport, true) })
+	checkSchemaRoundTrip(t, []byte(stdout), &schema.SizeReportDocument{}, schema.SizeReport, true)
+
+	baseline := filepath.Join(dir, "bench.json")
+	captureOutput(t, func() { runBench([]string{"-n", "1", "--save", baseline, debPath}) })
+	data, _ = os.ReadFile(baseline)
+	checkSchemaRoundTrip(t, data, &schema.BenchDocument{}, schema.Bench, true)
+	stdout, _ = captureOutput(t, func() { runBench([]string{"-n", "1", "--json", "--compare", baseline, "--threshold", "-100", debPath}) })
+	checkSchemaRoundTrip(t, []byte(stdout), &schema.BenchDocument{}, schema.Bench, true)
+
+	// Closed only now, after diff and bench have read their debs
+	ipc.close(IPCEvent{Status: ResultStatusOK, Result: result})
+	got := <-events
+	if len(got) == 0 {
+		t.Fatal("no events")
+	}
+	for _, event := range got {
+		data, _ = json.Marshal(event)
+		checkSchemaRoundTrip(t, data, &schema.EventDocument{}, schema.Event, true)
+	}
+	if last := got[len(got)-1]; last.Result == nil || last.Result.Schema != schema.Report {
+		t.Errorf("the result event's result has schema %q, want %s", last.Result.Schema, schema.Report)
+	}
+}
+
+func TestSchemaCheck(t *testing.T) {
+	for _, doc := range schema.All {
+		if _, _, err := schema.Parse(doc.ID); err != nil {
+			t.Errorf("schema.All: %v", err)
+		}
+	}
+	for _, c := range []struct {
+		id   string
+		want []string
+		ok   bool
+	}{
+		{"", []string{schema.Manifest}, true}, // Written before documents carried one
+		{schema.Manifest, []string{schema.Manifest}, true},
+		{schema.Report, []string{schema.Manifest, schema.Report}, true},
+		{"debtoipa.manifest/2", []string{schema.Manifest}, false},
+		{schema.Doctor, []string{schema.Report, schema.Origin}, false},
+		{"report/1", []string{schema.Report}, false},
+	} {
+		if err := schema.Check(c.id, c.want...); (err == nil) != c.ok {
+			t.Errorf("Check(%q, %v) = %v, want ok %v", c.id, c.want, err, c.ok)
+		}
+	}
+}
+
+func TestRecipe(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	yamlRecipe := write("app.yaml", `---
+schema: debtoipa.recipe/1 # The version this was written for
+description: "Needs #2 patched"
+match:
+  package: com.example.*
+options:
+  min-os: "14.0"
+  exclude: ["**/*.car", 'it''s']
+  exclude-framework:
+  - FLEX
+  strict: true
+`)
+	jsonRecipe := write("app.json", `{
+  "schema": "debtoipa.recipe/1",
+  "description": "Needs #2 patched",
+  "match": {"package": "com.example.*"},
+  "options": {
+    "min-os": "14.0",
+    "exclude": ["**/*.car", "it's"],
+    "exclude-framework": ["FLEX"],
+    "strict": true
+  }
+}`)
+	for _, p := range []string{yamlRecipe, jsonRecipe} {
+		recipe, err := loadRecipe(p)
+		if err != nil {
+			t.Fatalf("loadRecipe(%s): %v", filepath.Base(p), err)
+		}
+		var got []string
+		for _, option := range recipe.Options {
+			got = append(got, option.Flag+"="+strings.Join(option.Values, ","))
+		}
+		if want := "min-os=14.0 exclude=**/*.car,it's exclude-framework=FLEX strict=true"; strings.Join(got, " ") != want || recipe.Description != "Needs #2 patched" {
+			t.Errorf("%s: options %q, description %q; want %q", filepath.Base(p), got, recipe.Description, want)
+		}
+		if !recipe.Match.matches("com.example.app", "") || recipe.Match.matches("org.other", "") {
+			t.Errorf("%s: match %+v", filepath.Base(p), recipe.Match)
+		}
+	}
+
+	// Flags given on the command line win; the rest are set through the flag set
+	var opts Options
+	fs := flag.NewFlagSet("recipe", flag.ContinueOnError)
+	fs.StringVar(&opts.MinOS, "min-os", "", "")
+	fs.Var((*stringList)(&opts.Exclude), "exclude", "")
+	fs.Var((*stringList)(&opts.ExcludeFrameworks), "exclude-framework", "")
+	fs.BoolVar(&opts.Strict, "strict", false, "")
+	rec := recordOptions(fs)
+	if err := fs.Parse([]string{"--min-os", "13.0"}); err != nil {
+		t.Fatal(err)
+	}
+	recipe, _ := loadRecipe(yamlRecipe)
+	if err := applyRecipe(fs, recipe); err != nil {
+		t.Fatal(err)
+	}
+	if opts.MinOS != "13.0" || len(opts.Exclude) != 2 || opts.Exclude[1] != "it's" || !opts.Strict || len(rec.options) != 5 {
+		t.Errorf("after applying: %+v, recorded %v", opts, rec.options)
+	}
+
+	for _, c := range []struct{ name, content, want string }{
+		{"tabs.yaml", "options:\n\tstrict: true\n", "tabs.yaml:2: "},
+		{"indent.yaml", "options:\n  strict: true\n   min-os: 1\n", "indent.yaml:3: "},
+		{"twice.yaml", "options:\n  strict: true\n  strict: false\n", "twice.yaml:3: strict: given twice"},
+		{"field.yaml", "match:\n  package: a\n  name: b\n", "field.yaml:3: match.name: unknown field"},
+		{"anchor.yaml", "options:\n  min-os: &v 14.0\n", "anchor.yaml:2: "},
+		{"newer.json", `{"schema": "debtoipa.recipe/2"}`, "newer.json:1: schema: "},
+		{"comma.json", "{\n  \"options\": {\n    \"strict\": true,\n  }\n}", "comma.json:3: "},
+		{"list.json", "{\"options\": {\n  \"min-os\": [\"13.0\", \"14.0\"]\n}}", "list.json:2: options.min-os: --min-os takes one value"},
+		{"flag.yaml", "options:\n  min-os: 14.0\n  no-such-flag: 1\n", "flag.yaml:3: options.no-such-flag: no such flag"},
+		{"dashes.yaml", "options:\n  --strict: true\n", "dashes.yaml:2: options.--strict: "},
+		{"report.yaml", "options:\n  report: r.json\n", "report.yaml:2: options.report: "},
+	} {
+		p := write(c.name, c.content)
+		recipe, err := loadRecipe(p)
+		if err == nil {
+			fs := flag.NewFlagSet("recipe", flag.ContinueOnError)
+			fs.String("min-os", "", "")
+			fs.String("report", "", "")
+			err = applyRecipe(fs, recipe)
+		}
+		var re *RecipeError
+		if !errors.As(err, &re) || !strings.HasPrefix(err.Error(), p[:len(p)-len(c.name)]+c.want) {
+			t.Errorf("%s: got %v, want a RecipeError starting %q", c.name, err, c.want)
+		}
+	}
+
+	// batch --recipes needs each recipe to say what it's for, and picks the first match
+	if _, err := loadRecipeDir(dir); err == nil {
+		t.Error("loadRecipeDir: a directory with bad and matchless recipes loaded")
+	}
+	recipesDir := t.TempDir()
+	os.WriteFile(filepath.Join(recipesDir, "a.yaml"), []byte("match:\n  bundleId: com.example.fixture\n"), 0644)
+	os.WriteFile(filepath.Join(recipesDir, "b.json"), []byte(`{"match": {"package": "com.example.*"}}`), 0644)
+	recipes, err := loadRecipeDir(recipesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct{ pkg, bundleID, want string }{
+		{"com.example.fixture", "com.example.fixture", "a.yaml"},
+		{"com.example.other", "com.other", "b.json"},
+		{"org.other", "org.other", ""},
+	} {
+		got := ""
+		if recipe := pickRecipe(recipes, c.pkg, c.bundleID); recipe != nil {
+			got = filepath.Base(recipe.Path)
+		}
+		if got != c.want {
+			t.Errorf("pickRecipe(%s, %s) = %q, want %q", c.pkg, c.bundleID, got, c.want)
+		}
+	}
+}
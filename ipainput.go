@@ -0,0 +1,161 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// --- IPA input: repacking an app that's already zipped ---
+// An IPA (or any zip holding a .app) goes through the same steps as a deb: plist edits,
+// mode laundering, junk stripping, then a fresh zip. That fixes an IPA with broken modes
+// using the flags that fix a deb. The zip's central directory names the app up front, so
+// only the bundle's files are read; the rest (SwiftSupport/, iTunesMetadata.plist...) is
+// noted in Outside and left out like anything else outside the app.
+
+// isZipArchive recognizes a zip by its magic: a local file header, or the end of central
+// directory record of an empty one
+func isZipArchive(f io.ReaderAt) bool {
+	head := make([]byte, 4)
+	if n, _ := f.ReadAt(head, 0); n < 4 {
+		return false
+	}
+	return bytes.Equal(head, []byte("PK\x03\x04")) || bytes.Equal(head, []byte("PK\x05\x06"))
+}
+
+// readIPA reads a zip's app into DebContents, as readDeb does a deb's data.tar
+func readIPA(f *os.File, store *SpillStore, ro readOptions) (*DebContents, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zip.NewReader(f, info.Size())
+	if err != nil {
+		return nil, fmt.Errorf("invalid zip archive: %w", err)
+	}
+	deb := &DebContents{Repack: true, AppDirPrefix: ro.AppPrefix}
+	if deb.AppDirPrefix == "" {
+		for _, zf := range zr.File {
+			if deb.AppDirPrefix = bundlePrefix(tarEntryName(zf.Name), ro.bundleExt()); deb.AppDirPrefix != "" {
+				break
+			}
+		}
+	}
+	if !ro.Quiet {
+		fmt.Printf("=> [2/5] Found a zip archive (%d entries). Reading %s to repack...\n", len(zr.File), valueOr(deb.AppDirPrefix, "it"))
+	}
+
+	limiter := newEntryLimiter(ro.Limits)
+	for _, zf := range zr.File {
+		// archive/zip takes zip64 sizes up to 2^64-1; past 2^63 they'd read as negative
+		if zf.UncompressedSize64 > math.MaxInt64 {
+			return nil, fmt.Errorf("invalid zip archive: %s claims %d bytes", zf.Name, zf.UncompressedSize64)
+		}
+		if err := limiter.check(zf.Name, zf.Mode()&os.ModeSymlink != 0, int64(zf.UncompressedSize64)); err != nil {
+			return nil, err
+		}
+		name := tarEntryName(zf.Name)
+		if name == "" {
+			continue
+		}
+		mode := zf.Mode()
+		vf := &VirtualFile{Name: name, Mode: int64(mode.Perm()), Size: int64(zf.UncompressedSize64), ModTime: zf.Modified, IsDir: mode.IsDir()}
+		if deb.AppDirPrefix == "" || !inAppPrefix(name, deb.AppDirPrefix) {
+			// Nothing outside the app is repacked; its names still explain a zip without one
+			entry := indexEntry{Name: name, Size: vf.Size, Type: tar.TypeReg}
+			if vf.IsDir {
+				entry.Type = tar.TypeDir
+			}
+			deb.Outside = append(deb.Outside, entry)
+			continue
+		}
+		if ro.Filter != nil && ro.Filter.Excluded(appRelPath(name, deb.AppDirPrefix), vf.IsDir) {
+			continue
+		}
+
+		switch {
+		case vf.IsDir:
+			if !strings.HasSuffix(vf.Name, "/") {
+				vf.Name += "/"
+			}
+		case mode&os.ModeSymlink != 0:
+			target, err := readZipEntry(zf)
+			if err != nil {
+				return nil, err
+			}
+			vf.IsLink = true
+			vf.LinkDest = string(target)
+			vf.Size = 0
+		default:
+			if err := readZipData(zf, vf, store); err != nil {
+				return nil, err
+			}
+			if name == deb.AppDirPrefix+"Info.plist" && vf.Data != nil {
+				deb.InfoPlistData = vf.Data
+			}
+		}
+		store.track(vf)
+		deb.Files = append(deb.Files, vf)
+	}
+	return deb, nil
+}
+
+// readZipData buffers a zip entry's contents in RAM, or spills them, as the storage policy decides
+func readZipData(zf *zip.File, vf *VirtualFile, store *SpillStore) error {
+	rc, err := zf.Open()
+	if err != nil {
+		return fmt.Errorf("%s: %w", zf.Name, err)
+	}
+	defer rc.Close()
+	if storage, _ := store.policy(false).Decide(StorageEntry{Name: vf.Name, Size: vf.Size, HasData: true}); storage == StoreRAM {
+		data := make([]byte, vf.Size)
+		if _, err := io.ReadFull(rc, data); err != nil {
+			return fmt.Errorf("%s: %w", zf.Name, err)
+		}
+		vf.Data = data
+		store.charge(vf.Size)
+		return nil
+	}
+	return store.spill(vf, rc)
+}
+
+// readZipEntry reads a small zip entry, a symlink's target
+func readZipEntry(zf *zip.File) ([]byte, error) {
+	rc, err := zf.Open()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", zf.Name, err)
+	}
+	defer rc.Close()
+	return io.ReadAll(io.LimitReader(rc, 4096))
+}
+
+// printRepackOutside warns about what an IPA held besides its app, which a repack drops
+func printRepackOutside(deb *DebContents, warnings *warningLog) {
+	tops := make(map[string]bool)
+	for _, e := range deb.Outside {
+		top, _, _ := strings.Cut(e.Name, "/")
+		if top == "Payload" {
+			continue
+		}
+		if strings.Contains(e.Name, "/") {
+			top += "/"
+		}
+		tops[top] = true
+	}
+	if len(tops) == 0 {
+		return
+	}
+	names := make([]string, 0, len(tops))
+	for top := range tops {
+		names = append(names, top)
+	}
+	sort.Strings(names)
+	warnings.add("repack-outside-app", "", "Left out what the IPA held besides %s: %s", path.Base(strings.TrimSuffix(deb.AppDirPrefix, "/")), strings.Join(names, ", "))
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"archive/zip"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestReadIPAHugeSize reads an IPA whose zip64 entry claims 2^63 bytes, which would be a
+// negative int64: it's refused as corrupt rather than allocated
+func TestReadIPAHugeSize(t *testing.T) {
+	ipaPath := filepath.Join(t.TempDir(), "huge.ipa")
+	f, err := os.Create(ipaPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	w, err := zw.CreateRaw(&zip.FileHeader{Name: "Payload/Fixture.app/Info.plist", Method: zip.Store, CompressedSize64: 1, UncompressedSize64: math.MaxInt64 + 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte{0})
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	if _, err := readDeb(ipaPath, &SpillStore{Dir: t.TempDir()}, readOptions{Quiet: true}); err == nil {
+		t.Error("an entry of 2^63 bytes was read")
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"deb-to-ipa/pkg/schema"
+	"github.com/schollz/progressbar/v3"
+)
+
+// --- IPC: progress and the result for a desktop front end, without parsing stdout ---
+// --ipc path connects to the Unix socket (on Windows, the named pipe, e.g.
+// \\.\pipe\deb-to-ipa) a front end listens on and sends the run there as events, while the
+// console output carries on as usual. Every message, either way, is a 4-byte big-endian
+// length, then that many bytes of JSON:
+//
+//	{"schema":"debtoipa.event/1","event":"start","time":"...","tool":"deb-to-ipa 1.4","args":["App.deb"]}
+//	{"schema":"debtoipa.event/1","event":"phase","time":"...","phase":"Extracting","total":1048576}
+//	{"schema":"debtoipa.event/1","event":"progress","time":"...","phase":"Extracting","done":524288,"total":1048576}
+//	{"schema":"debtoipa.event/1","event":"warning","time":"...","warning":{"code":"...","message":"...","severity":"warning"}}
+//	{"schema":"debtoipa.event/1","event":"result","time":"...","status":"ok","result":{...as in --report...}}
+//
+// The result event is always the last; a failed run's has status "error", its RESULT
+// line code and the error instead of a result. The tool then shuts its sending side and
+// waits a moment for the front end to hang up, so nothing in flight is lost.
+//
+// The front end may send {"command":"cancel"}: the conversion stops at its next read or
+// write, removes its partial output and fails with code "cancelled". Other commands are
+// ignored, so later ones can be added.
+
+// ipcMaxMessage bounds a command's length; anything longer is a peer speaking something else
+const ipcMaxMessage = 64 << 10
+
+// ipcMaxEvent bounds an event read by batch: a result event carries the whole report
+const ipcMaxEvent = 64 << 20
+
+// ipcHangUpWait is how long the tool waits for the front end to hang up after the result
+const ipcHangUpWait = time.Second
+
+// IPCEvent is one message to the front end; fields are only set for the events that use them
+type IPCEvent struct {
+	Schema  string   `json:"schema"` // schema.Event
+	Event   string   `json:"event"`  // start, phase, progress, warning or result
+	Time    string   `json:"time"`   // RFC 3339 UTC, to the millisecond
+	Tool    string   `json:"tool,omitempty"`
+	Args    []string `json:"args,omitempty"`
+	Phase   string   `json:"phase,omitempty"` // The progress bar's description, e.g. "Writing IPA"
+	Done    int64    `json:"done,omitempty"`  // Bytes through the phase so far
+	Total   int64    `json:"total,omitempty"` // Bytes the phase will move
+	Warning *Warning `json:"warning,omitempty"`
+	Status  string   `json:"status,omitempty"` // ok, warnings or error
+	Code    string   `json:"code,omitempty"`   // A failed run's RESULT code
+	Error   string   `json:"error,omitempty"`
+	Result  *Result  `json:"result,omitempty"`
+}
+
+// IPCCommand is one message from the front end
+type IPCCommand struct {
+	Command string `json:"command"` // "cancel"
+}
+
+// ipcListener is the front end's side, as batch listens for each of its jobs: listenIPC
+// opens one where dialIPC would connect
+type ipcListener interface {
+	Accept() (io.ReadWriteCloser, error)
+	Close() error
+}
+
+// errCancelled is what every read and write of the conversion fails with once cancelled
+var errCancelled = errors.New("cancelled over --ipc")
+
+// ipc is the run's front-end connection, nil (sending nothing) without --ipc
+var ipc *ipcConn
+
+// ipcConn sends events and takes commands; a nil ipcConn does neither
+type ipcConn struct {
+	mu     sync.Mutex
+	conn   io.ReadWriteCloser
+	broken bool // A write failed: the front end is gone, and the conversion goes on without it
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	done   chan struct{} // Closed when readCommands returns
+}
+
+// openIPC connects to the front end and announces the run
+func openIPC(path string) (*ipcConn, error) {
+	conn, err := dialIPC(path)
+	if err != nil {
+		return nil, fmt.Errorf("--ipc: %w", err)
+	}
+	c := newIPCConn(conn)
+	c.send(IPCEvent{Event: "start", Tool: toolVersion(), Args: os.Args[1:]})
+	return c, nil
+}
+
+// newIPCConn starts taking commands from conn
+func newIPCConn(conn io.ReadWriteCloser) *ipcConn {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	c := &ipcConn{conn: conn, ctx: ctx, cancel: cancel, done: make(chan struct{})}
+	go c.readCommands()
+	return c
+}
+
+// readCommands acts on commands until the connection closes, from either end
+func (c *ipcConn) readCommands() {
+	defer close(c.done)
+	for {
+		data, err := readIPCMessage(c.conn, ipcMaxMessage)
+		if err != nil {
+			return
+		}
+		var cmd IPCCommand
+		if json.Unmarshal(data, &cmd) == nil && cmd.Command == "cancel" {
+			c.cancel(errCancelled)
+		}
+	}
+}
+
+// err is errCancelled once the front end has cancelled, otherwise nil
+func (c *ipcConn) err() error {
+	if c == nil {
+		return nil
+	}
+	return context.Cause(c.ctx)
+}
+
+// send writes one event, stamped with the time
+func (c *ipcConn) send(event IPCEvent) {
+	if c == nil {
+		return
+	}
+	event.Schema = schema.Event
+	event.Time = time.Now().UTC().Format("2006-01-02T15:04:05.000Z07:00")
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.broken && writeIPCMessage(c.conn, data) != nil {
+		c.broken = true
+	}
+}
+
+// phase announces a phase and the bytes it will move, from its progress bar (may be nil)
+func (c *ipcConn) phase(description string, bar *progressbar.ProgressBar) {
+	if c == nil || description == "" {
+		return
+	}
+	event := IPCEvent{Event: "phase", Phase: description}
+	if bar != nil {
+		event.Total = max(bar.State().Max, 0)
+	}
+	c.send(event)
+}
+
+// progress reports how far the phase's progress bar has got
+func (c *ipcConn) progress(description string, bar *progressbar.ProgressBar) {
+	if c == nil || bar == nil {
+		return
+	}
+	state := bar.State()
+	c.send(IPCEvent{Event: "progress", Phase: description, Done: state.CurrentNum, Total: max(state.Max, 0)})
+}
+
+// close sends the result event and hangs up
+func (c *ipcConn) close(event IPCEvent) {
+	if c == nil {
+		return
+	}
+	event.Event = "result"
+	if event.Result != nil {
+		doc := *event.Result
+		doc.Schema = schema.Report
+		event.Result = &doc
+	}
+	c.send(event)
+	// Closing a socket with a command still unread resets it, which can drop the result
+	if cw, ok := c.conn.(interface{ CloseWrite() error }); ok && cw.CloseWrite() == nil {
+		select {
+		case <-c.done:
+		case <-time.After(ipcHangUpWait):
+		}
+	}
+	// The context isn't cancelled: it has no parent to let go of, and a cause set here
+	// would read as the front end cancelling to every conversion still to come
+	c.conn.Close()
+}
+
+// writeIPCMessage writes data with its length in front
+func writeIPCMessage(w io.Writer, data []byte) error {
+	msg := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(msg, uint32(len(data)))
+	copy(msg[4:], data)
+	_, err := w.Write(msg)
+	return err
+}
+
+// readIPCMessage reads one length-prefixed message of up to limit bytes
+func readIPCMessage(r io.Reader, limit uint32) ([]byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(length[:])
+	if n > limit {
+		return nil, fmt.Errorf("message of %d bytes, over the %d allowed", n, limit)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,39 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+)
+
+// dialIPC connects to the front end's Unix socket
+func dialIPC(path string) (io.ReadWriteCloser, error) {
+	return net.Dial("unix", path)
+}
+
+// ipcAddress is where the batch listens for job n's events: a socket in dir, which is the
+// batch's own, under a short name as socket paths are limited to about 100 bytes
+func ipcAddress(dir string, n int) string {
+	return filepath.Join(dir, fmt.Sprintf("%d.sock", n))
+}
+
+// unixListener takes one front-end connection on a Unix socket, removed on Close
+type unixListener struct {
+	net.Listener
+}
+
+// listenIPC listens at path for a conversion run with --ipc path, as batch does
+func listenIPC(path string) (ipcListener, error) {
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	return unixListener{l}, nil
+}
+
+func (l unixListener) Accept() (io.ReadWriteCloser, error) {
+	return l.Listener.Accept()
+}
@@ -0,0 +1,110 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// procPeekNamedPipe isn't wrapped by x/sys/windows
+var procPeekNamedPipe = windows.NewLazySystemDLL("kernel32.dll").NewProc("PeekNamedPipe")
+
+// ipcPollInterval is how often a pipe with nothing to read is checked again
+const ipcPollInterval = 50 * time.Millisecond
+
+// pipeConn is the client end of a named pipe. The handle is synchronous, and Windows runs
+// one call at a time on a synchronous handle, so a read left waiting for the front end
+// would hold every event back behind it; reads only start once PeekNamedPipe sees data.
+type pipeConn struct {
+	*os.File
+}
+
+// dialIPC opens the front end's named pipe
+func dialIPC(path string) (io.ReadWriteCloser, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	return pipeConn{f}, nil
+}
+
+func (p pipeConn) Read(b []byte) (int, error) {
+	for {
+		var available uint32
+		ok, _, err := procPeekNamedPipe.Call(p.Fd(), 0, 0, 0, uintptr(unsafe.Pointer(&available)), 0)
+		if ok == 0 {
+			return 0, err // Closed by either end
+		}
+		if available > 0 {
+			return p.File.Read(b[:min(len(b), int(available))])
+		}
+		time.Sleep(ipcPollInterval)
+	}
+}
+
+// ipcAddress is the named pipe the batch listens on for job n's events; dir is unused, as
+// pipes live in their own namespace, so the batch's pid keeps two batches apart
+func ipcAddress(dir string, n int) string {
+	return fmt.Sprintf(`\\.\pipe\deb-to-ipa-batch-%d-%d`, os.Getpid(), n)
+}
+
+// pipeListener is the server end of a named pipe taking a single client
+type pipeListener struct {
+	path string
+
+	mu       sync.Mutex
+	h        windows.Handle
+	accepted bool // The handle went to the connection Accept returned
+	closed   bool
+}
+
+// listenIPC creates the named pipe at path for a conversion run with --ipc path, as batch does
+func listenIPC(path string) (ipcListener, error) {
+	name, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	h, err := windows.CreateNamedPipe(name, windows.PIPE_ACCESS_DUPLEX, windows.PIPE_TYPE_BYTE|windows.PIPE_READMODE_BYTE|windows.PIPE_WAIT,
+		1, ipcMaxMessage, ipcMaxMessage, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &pipeListener{path: path, h: h}, nil
+}
+
+// Accept waits for the client to open the pipe
+func (l *pipeListener) Accept() (io.ReadWriteCloser, error) {
+	if err := windows.ConnectNamedPipe(l.h, nil); err != nil && err != windows.ERROR_PIPE_CONNECTED {
+		return nil, err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil, os.ErrClosed
+	}
+	l.accepted = true
+	return os.NewFile(uintptr(l.h), l.path), nil
+}
+
+// Close releases a pipe no client opened. ConnectNamedPipe on a synchronous handle can't
+// be cancelled, so a waiting Accept is let go by opening the pipe as the client would.
+func (l *pipeListener) Close() error {
+	l.mu.Lock()
+	if l.accepted || l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	l.mu.Unlock()
+	if f, err := os.OpenFile(l.path, os.O_RDWR, 0); err == nil {
+		f.Close()
+	}
+	return windows.CloseHandle(l.h)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// defaultJBPaths are path prefixes that only exist on jailbroken devices. Binaries that
+// hardcode them tend to crash or silently misbehave once sideloaded into the sandbox.
+var defaultJBPaths = []string{
+	"/var/jb",
+	"/Library/MobileSubstrate",
+	"/usr/lib/substrate",
+	"/usr/lib/libsubstrate",
+	"/usr/lib/TweakInject",
+	"/Library/PreferenceLoader",
+}
+
+// jbScanChunk is how much of a binary is read at a time
+const jbScanChunk = 1 << 20
+
+// JBPathHit is a binary that references jailbreak paths
+type JBPathHit struct {
+	Path    string         `json:"path"`
+	Matches map[string]int `json:"matches"` // Prefix -> occurrences
+	Total   int            `json:"total"`
+}
+
+// isMachO reports whether data starts with a Mach-O (thin or fat) magic number
+func isMachO(data []byte) bool {
+	if len(data) < 4 {
+		return false
+	}
+	switch string(data[:4]) {
+	case "\xfe\xed\xfa\xce", "\xce\xfa\xed\xfe", "\xfe\xed\xfa\xcf", "\xcf\xfa\xed\xfe", "\xca\xfe\xba\xbe":
+		return true
+	}
+	return false
+}
+
+// scanJBPaths looks for jailbreak path prefixes in every Mach-O in the bundle, streaming
+// each file so spilled entries are never loaded whole
+func scanJBPaths(entries []BundleEntry, prefixes []string) ([]JBPathHit, error) {
+	if len(prefixes) == 0 {
+		prefixes = defaultJBPaths
+	}
+	overlap := 0
+	for _, p := range prefixes {
+		overlap = max(overlap, len(p)-1)
+	}
+
+	var hits []JBPathHit
+	for _, entry := range entries {
+		vf := entry.File
+		if vf.IsDir || vf.IsLink || vf.Size < 4 {
+			continue
+		}
+		matches, err := scanFile(vf, prefixes, overlap)
+		if err != nil {
+			return nil, fmt.Errorf("scanning %s: %w", entry.RelPath, err)
+		}
+		if len(matches) == 0 {
+			continue
+		}
+		hit := JBPathHit{Path: entry.RelPath, Matches: matches}
+		for _, n := range matches {
+			hit.Total += n
+		}
+		hits = append(hits, hit)
+	}
+
+	sort.Slice(hits, func(i, j int) bool { return hits[i].Path < hits[j].Path })
+	return hits, nil
+}
+
+// scanFile counts prefix occurrences in one file, or returns nil if it isn't a Mach-O.
+// Each window is the previous window's last overlap bytes plus a new chunk; a match is
+// counted only if it ends in the new chunk, so matches spanning chunks count exactly once.
+func scanFile(vf *VirtualFile, prefixes []string, overlap int) (map[string]int, error) {
+	rc, err := vf.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	matches := make(map[string]int)
+	buf := make([]byte, 0, overlap+jbScanChunk)
+	first := true
+	for {
+		carried := len(buf)
+		n, err := io.ReadFull(rc, buf[carried:carried+jbScanChunk])
+		buf = buf[:carried+n]
+		if first {
+			if !isMachO(buf) {
+				return nil, nil
+			}
+			first = false
+		}
+
+		for _, p := range prefixes {
+			needle := []byte(p)
+			for offset := 0; ; {
+				i := bytes.Index(buf[offset:], needle)
+				if i < 0 {
+					break
+				}
+				if offset+i+len(needle) > carried {
+					matches[p]++
+				}
+				offset += i + 1
+			}
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		keep := min(overlap, len(buf))
+		copy(buf, buf[len(buf)-keep:])
+		buf = buf[:keep]
+	}
+
+	if len(matches) == 0 {
+		return nil, nil
+	}
+	return matches, nil
+}
+
+// printJBPaths reports the scan, warning about each binary referencing jailbreak paths
+func printJBPaths(hits []JBPathHit, warnings *warningLog) {
+	if len(hits) == 0 {
+		fmt.Println("   No jailbreak paths found in binaries")
+		return
+	}
+	fmt.Printf("   Jailbreak paths referenced by %d binar%s\n", len(hits), map[bool]string{true: "y", false: "ies"}[len(hits) == 1])
+	for _, hit := range hits {
+		warnings.add("jb-paths", hit.Path, "%s references jailbreak paths (%s); it may crash or misbehave when sideloaded", hit.Path, formatJBMatches(hit.Matches))
+	}
+}
+
+func formatJBMatches(matches map[string]int) string {
+	prefixes := make([]string, 0, len(matches))
+	for p := range matches {
+		prefixes = append(prefixes, p)
+	}
+	sort.Strings(prefixes)
+	parts := make([]string, len(prefixes))
+	for i, p := range prefixes {
+		parts[i] = fmt.Sprintf("%s x%d", p, matches[p])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// jbPathFindings turns scan hits into lint warnings
+func jbPathFindings(hits []JBPathHit) []LintFinding {
+	var findings []LintFinding
+	for _, hit := range hits {
+		findings = append(findings, LintFinding{
+			Severity: SeverityWarning,
+			Check:    "jailbreak-paths",
+			Path:     hit.Path,
+			Message:  "references jailbreak paths: " + formatJBMatches(hit.Matches),
+			Hint:     "the binary expects a jailbroken filesystem and may fail in the sandbox",
+		})
+	}
+	return findings
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"strings"
+)
+
+// --- Input limits: guardrails for untrusted debs ---
+// A deb from an upload can hold millions of entries, one enormous file, or symlinks and
+// absolute paths aimed outside the bundle. --max-entries, --max-entry-size, --deny-symlinks
+// and --deny-absolute-paths stop the read at the first entry past a limit, checked on each
+// header as it streams by (in the indexing pass, when there is one), so nothing much is
+// decompressed beyond it. All are off by default.
+
+// InputLimits bounds what a conversion will read; the zero value limits nothing
+type InputLimits struct {
+	MaxEntries   int   // Entries in data.tar (or the zip or folder); 0 for no limit
+	MaxEntrySize int64 // Bytes in any one file; 0 for no limit
+	DenySymlinks bool  // Fail on any symlink
+	DenyAbsolute bool  // Fail on entry names starting with "/" (before they're made relative)
+}
+
+// LimitError is returned when an input breaks one of its InputLimits
+type LimitError struct {
+	Limit string // The flag, e.g. "max-entries"
+	Entry string // The entry that broke it
+	Value int64  // Entries or bytes seen, for the numeric limits
+	Max   int64
+}
+
+func (e *LimitError) Error() string {
+	switch e.Limit {
+	case "max-entries":
+		return fmt.Sprintf("input has more than --max-entries %d entries (at %s)", e.Max, e.Entry)
+	case "max-entry-size":
+		return fmt.Sprintf("%s is %s, over --max-entry-size %s", e.Entry, formatBytes(e.Value), formatBytes(e.Max))
+	case "deny-symlinks":
+		return fmt.Sprintf("%s is a symlink, refused with --deny-symlinks", e.Entry)
+	}
+	return fmt.Sprintf("%s is an absolute path, refused with --deny-absolute-paths", e.Entry)
+}
+
+// entryLimiter applies InputLimits to one pass over an archive; a nil one checks nothing
+type entryLimiter struct {
+	InputLimits
+	seen int
+}
+
+// newEntryLimiter returns a limiter for limits, nil when they limit nothing
+func newEntryLimiter(limits InputLimits) *entryLimiter {
+	if limits == (InputLimits{}) {
+		return nil
+	}
+	return &entryLimiter{InputLimits: limits}
+}
+
+// check counts an entry, by its name as stored, and fails it if it breaks a limit
+func (l *entryLimiter) check(rawName string, isLink bool, size int64) error {
+	if l == nil {
+		return nil
+	}
+	l.seen++
+	switch {
+	case l.MaxEntries > 0 && l.seen > l.MaxEntries:
+		return &LimitError{Limit: "max-entries", Entry: rawName, Value: int64(l.seen), Max: int64(l.MaxEntries)}
+	case l.MaxEntrySize > 0 && size > l.MaxEntrySize:
+		return &LimitError{Limit: "max-entry-size", Entry: rawName, Value: size, Max: l.MaxEntrySize}
+	case l.DenySymlinks && isLink:
+		return &LimitError{Limit: "deny-symlinks", Entry: rawName}
+	case l.DenyAbsolute && (strings.HasPrefix(rawName, "/") || strings.HasPrefix(rawName, "\\")):
+		return &LimitError{Limit: "deny-absolute-paths", Entry: rawName}
+	}
+	return nil
+}
+
+// checkHeader is check for a tar header, before nextTarEntry cleans its name
+func (l *entryLimiter) checkHeader(header *tar.Header) error {
+	return l.check(header.Name, header.Typeflag == tar.TypeSymlink, header.Size)
+}
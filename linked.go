@@ -0,0 +1,219 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// --- Linked resources: app bundles split between Applications/ and Library/ ---
+// Some older debs install a slim .app and put its resources under /Library/<App>/, with
+// symlinks from the bundle pointing there. Converting just the .app leaves those links
+// dangling on a stock device. --bundle-linked-resources copies the targets into the
+// bundle in place of the links.
+
+// maxLinkHops bounds how many symlinks are followed to reach one target
+const maxLinkHops = 16
+
+// LinkedResource is a symlink in the bundle pointing at something the deb installs outside it
+type LinkedResource struct {
+	Path    string `json:"path"`             // Bundle-relative symlink
+	Target  string `json:"target"`           // Path in the deb after following links, e.g. "Library/MyApp/Resources"
+	Files   int    `json:"files"`            // Regular files at or under the target
+	Bytes   int64  `json:"bytes"`            // Their size
+	Bundled bool   `json:"bundled"`          // Replaced by the target's contents
+	LinkTo  string `json:"linkTo,omitempty"` // Bundled earlier under this path; the symlink now points there
+}
+
+// debNode is one data.tar entry, with its contents when they were extracted
+type debNode struct {
+	file *VirtualFile // nil for entries only known by their header
+	size int64
+	dir  bool
+	link string
+}
+
+// debTree is every entry of a deb by name (directories without the trailing slash)
+type debTree struct {
+	nodes map[string]debNode
+	names []string // Sorted, for finding everything under a directory
+}
+
+func newDebTree(deb *DebContents) *debTree {
+	t := &debTree{nodes: make(map[string]debNode)}
+	for _, vf := range deb.Files {
+		n := debNode{file: vf, size: vf.Size, dir: vf.IsDir}
+		if vf.IsLink {
+			n.link = vf.LinkDest
+		}
+		t.nodes[strings.TrimSuffix(vf.Name, "/")] = n
+	}
+	for _, e := range deb.Outside {
+		t.nodes[strings.TrimSuffix(e.Name, "/")] = debNode{size: e.Size, dir: e.Type == tar.TypeDir, link: e.Link}
+	}
+	for name := range t.nodes {
+		t.names = append(t.names, name)
+	}
+	sort.Strings(t.names)
+	return t
+}
+
+// under lists the entries inside dir, in name order
+func (t *debTree) under(dir string) []string {
+	prefix := dir + "/"
+	i := sort.SearchStrings(t.names, prefix)
+	var names []string
+	for ; i < len(t.names) && strings.HasPrefix(t.names[i], prefix); i++ {
+		names = append(names, t.names[i])
+	}
+	return names
+}
+
+// resolveLink turns a symlink at name into the deb path it points at, "" if it leaves the deb
+func resolveLink(name, dest string) string {
+	var target string
+	if path.IsAbs(dest) {
+		target = path.Clean(dest)
+	} else {
+		target = path.Join("/", path.Dir(name), dest)
+	}
+	target = strings.TrimPrefix(target, "/")
+	if target == "" || target == "." {
+		return ""
+	}
+	return target
+}
+
+// follow resolves a chain of symlinks in the deb, returning the final target, or "" when
+// it leaves the deb, isn't in it, or goes round in circles
+func (t *debTree) follow(name, dest string) string {
+	target := resolveLink(name, dest)
+	for hops := 0; target != ""; hops++ {
+		n, ok := t.nodes[target]
+		if !ok && len(t.under(target)) > 0 {
+			return target // A directory the tar only implies
+		}
+		if !ok || hops == maxLinkHops {
+			return ""
+		}
+		if n.link == "" {
+			return target
+		}
+		target = resolveLink(target, n.link)
+	}
+	return ""
+}
+
+// bundleLinkedResources finds the bundle's symlinks into the rest of the deb and, with
+// bundle, replaces each with a copy of what it points at. Links inside the copied trees are
+// followed too; a target already copied once becomes a relative link to that copy instead,
+// which is also what ends circular references.
+func bundleLinkedResources(entries []BundleEntry, deb *DebContents, appPrefix string, bundle bool) ([]BundleEntry, []LinkedResource) {
+	hasLinks := false
+	for _, entry := range entries {
+		hasLinks = hasLinks || entry.File.IsLink
+	}
+	if !hasLinks {
+		return entries, nil
+	}
+
+	tree := newDebTree(deb)
+	appRoot := strings.TrimSuffix(appPrefix, "/")
+	copied := make(map[string]string) // Target -> bundle path it was copied to
+
+	var resources []LinkedResource
+	for i := 0; i < len(entries); i++ { // Grows as linked trees are copied in
+		entry := entries[i]
+		if !entry.File.IsLink {
+			continue
+		}
+		target := tree.follow(path.Join(appRoot, entry.RelPath), entry.File.LinkDest)
+		if target == "" || target == appRoot || strings.HasPrefix(target, appPrefix) {
+			continue // Inside the bundle, or nothing the deb ships
+		}
+
+		r := LinkedResource{Path: entry.RelPath, Target: target}
+		contents := tree.under(target)
+		if n := tree.nodes[target]; !n.dir && len(contents) == 0 {
+			r.Files, r.Bytes = 1, n.size
+		}
+		for _, name := range contents {
+			if n := tree.nodes[name]; !n.dir && n.link == "" {
+				r.Files++
+				r.Bytes += n.size
+			}
+		}
+
+		if bundle {
+			if dest, ok := copied[target]; ok {
+				link := *entry.File
+				link.LinkDest = relativeLink(entry.RelPath, dest)
+				entries[i].File = &link
+				r.Bundled, r.LinkTo = true, dest
+			} else if added, ok := copyLinkedTarget(tree, target, contents, entry); ok {
+				copied[target] = entry.RelPath
+				entries[i] = added[0]
+				entries = append(entries, added[1:]...)
+				r.Bundled = true
+			}
+		}
+		resources = append(resources, r)
+	}
+	return entries, resources
+}
+
+// copyLinkedTarget builds the entries replacing the symlink entry: the target itself, then
+// everything under it. ok is false when some contents weren't extracted.
+func copyLinkedTarget(tree *debTree, target string, contents []string, link BundleEntry) (added []BundleEntry, ok bool) {
+	root := tree.nodes[target]
+	switch {
+	case root.file != nil && !root.dir:
+		file := *root.file
+		return []BundleEntry{{File: &file, RelPath: link.RelPath}}, true
+	case root.file != nil:
+		dir := *root.file
+		added = append(added, BundleEntry{File: &dir, RelPath: link.RelPath})
+	case len(contents) > 0:
+		// Only implied by its contents; the link's stamp will do for the directory
+		added = append(added, BundleEntry{
+			File:    &VirtualFile{Name: target + "/", Mode: 0755, ModTime: link.File.ModTime, IsDir: true},
+			RelPath: link.RelPath,
+		})
+	default:
+		return nil, false
+	}
+
+	for _, name := range contents {
+		n := tree.nodes[name]
+		if n.file == nil {
+			return nil, false
+		}
+		file := *n.file
+		added = append(added, BundleEntry{File: &file, RelPath: link.RelPath + strings.TrimPrefix(name, target)})
+	}
+	return added, true
+}
+
+// printLinkedResources lists the links into the rest of the deb, and what was folded in
+func printLinkedResources(resources []LinkedResource, warnings *warningLog) {
+	var files int
+	var bytes int64
+	for _, r := range resources {
+		switch {
+		case r.LinkTo != "":
+			fmt.Printf("   Linked resource: %s -> /%s (already bundled at %s)\n", r.Path, r.Target, r.LinkTo)
+		case r.Bundled:
+			fmt.Printf("   Bundled linked resource: %s <- /%s (%d file(s), %s)\n", r.Path, r.Target, r.Files, formatBytes(r.Bytes))
+			files += r.Files
+			bytes += r.Bytes
+		default:
+			warnings.add("linked-resource", r.Path, "%s links to /%s, which the deb installs outside the app (%d file(s), %s); the IPA won't have it (use --bundle-linked-resources)",
+				r.Path, r.Target, r.Files, formatBytes(r.Bytes))
+		}
+	}
+	if files > 0 {
+		fmt.Printf("   Folded %d file(s), %s, from outside the app into the bundle\n", files, formatBytes(bytes))
+	}
+}
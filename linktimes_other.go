@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "time"
+
+// setLinkTimes does nothing: there's no way here to time a symlink rather than its
+// target, so links keep the time they were made
+func setLinkTimes(path string, t time.Time) error {
+	return nil
+}
@@ -0,0 +1,15 @@
+//go:build unix
+
+package main
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// setLinkTimes sets a symlink's own access and modification times, not its target's
+func setLinkTimes(path string, t time.Time) error {
+	tv := unix.NsecToTimeval(t.UnixNano())
+	return unix.Lutimes(path, []unix.Timeval{tv, tv})
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"debug/macho"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// --- Lint: checks for the things that make installs or launches fail ---
+
+// Lint severities
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// LintFinding is one problem found in an IPA
+type LintFinding struct {
+	Severity string `json:"severity"`
+	Check    string `json:"check"`
+	Path     string `json:"path,omitempty"`
+	Message  string `json:"message"`
+	Hint     string `json:"hint,omitempty"`
+}
+
+// ipaRootEntries are the top-level archive entries App Store and sideloading tools know
+var ipaRootEntries = map[string]bool{
+	"Payload": true, "iTunesArtwork": true, "iTunesArtwork@2x": true, "iTunesMetadata.plist": true,
+	"SwiftSupport": true, "Symbols": true, "WatchKitSupport": true, "WatchKitSupport2": true, "META-INF": true,
+}
+
+// ipaAppPrefix returns "Payload/<App>.app/" for the first app in an IPA, or ""
+func ipaAppPrefix(files []*zip.File) string {
+	for _, f := range files {
+		if rest, ok := strings.CutPrefix(f.Name, "Payload/"); ok {
+			if i := strings.Index(rest, ".app/"); i != -1 && !strings.Contains(rest[:i], "/") {
+				return f.Name[:len("Payload/")+i+5]
+			}
+		}
+	}
+	return ""
+}
+
+type linter struct {
+	files    map[string]*zip.File // By archive name, without trailing slash for directories
+	findings []LintFinding
+}
+
+func (l *linter) add(severity, check, p, message, hint string) {
+	l.findings = append(l.findings, LintFinding{Severity: severity, Check: check, Path: p, Message: message, Hint: hint})
+}
+
+func (l *linter) read(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// lintIPA runs every check on an IPA. The error is for IPAs that can't be read at all.
+func lintIPA(ipaPath string) ([]LintFinding, error) {
+	zr, err := zip.OpenReader(ipaPath)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	l := &linter{files: make(map[string]*zip.File)}
+	for _, f := range zr.File {
+		l.files[strings.TrimSuffix(f.Name, "/")] = f
+	}
+
+	// Archive structure: entry names, flags and the Payload layout
+	apps := make(map[string]bool)
+	for _, f := range zr.File {
+		if strings.Contains(f.Name, "\\") {
+			l.add(SeverityError, "paths", f.Name, "entry name contains a backslash", "rebuild the archive with forward-slash paths")
+		}
+		if f.Flags&0x8 != 0 {
+			l.add(SeverityWarning, "data-descriptor", f.Name, "entry uses a data descriptor", "some installers can't stream these; re-zip without streaming")
+		}
+		root, rest, _ := strings.Cut(f.Name, "/")
+		if !ipaRootEntries[root] {
+			l.add(SeverityWarning, "structure", f.Name, "unexpected entry at the archive root", "only Payload/ (and iTunes metadata) belong at the root")
+		}
+		if root == "Payload" {
+			if i := strings.Index(rest, ".app/"); i != -1 && !strings.Contains(rest[:i], "/") {
+				apps[rest[:i+4]] = true
+			} else if rest != "" && !strings.HasSuffix(rest, ".app") {
+				l.add(SeverityError, "structure", f.Name, "entry in Payload/ outside any .app", "Payload/ must contain exactly one <App>.app folder")
+			}
+		}
+	}
+	prefix := ipaAppPrefix(zr.File)
+	if prefix == "" {
+		l.add(SeverityError, "structure", "", "no Payload/<App>.app folder", "the IPA must contain Payload/<App>.app/")
+		return l.findings, nil
+	}
+	if len(apps) > 1 {
+		l.add(SeverityError, "structure", "Payload/", fmt.Sprintf("%d apps in Payload/", len(apps)), "installers only take one app per IPA")
+	}
+
+	// Info.plist
+	appRoot := strings.TrimSuffix(prefix, "/")
+	infoFile := l.files[appRoot+"/Info.plist"]
+	if infoFile == nil {
+		l.add(SeverityError, "info-plist", appRoot+"/Info.plist", "Info.plist is missing", "")
+		return l.findings, nil
+	}
+	data, err := l.read(infoFile)
+	if err != nil {
+		return nil, err
+	}
+	info := parsePlistDict(data)
+	if info == nil {
+		l.add(SeverityError, "info-plist", infoFile.Name, "Info.plist can't be parsed", "")
+		return l.findings, nil
+	}
+	for _, key := range []string{"CFBundleIdentifier", "CFBundleExecutable", "CFBundleVersion"} {
+		if s, _ := info[key].(string); s == "" {
+			l.add(SeverityError, "info-plist", infoFile.Name, "missing required key "+key, "installd rejects bundles without it")
+		}
+	}
+	for _, key := range []string{"CFBundleShortVersionString", "MinimumOSVersion"} {
+		if s, _ := info[key].(string); s == "" {
+			l.add(SeverityWarning, "info-plist", infoFile.Name, "missing key "+key, "")
+		}
+	}
+	if _, ok := info["UISupportedDevices"]; ok {
+		l.add(SeverityWarning, "supported-devices", infoFile.Name, "UISupportedDevices restricts installation to specific device models",
+			"remove the key unless the target device is listed")
+	}
+
+	// Main executable
+	if executable, _ := info["CFBundleExecutable"].(string); executable != "" {
+		l.checkExecutable(appRoot+"/"+executable, true)
+	}
+
+	// Nested bundles, executable bits and symlinks
+	bundleIDs := make(map[string][]string)
+	if id, _ := info["CFBundleIdentifier"].(string); id != "" {
+		bundleIDs[id] = append(bundleIDs[id], appRoot)
+	}
+	var names []string
+	for name := range l.files {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := l.files[name]
+		dir := path.Dir(name)
+		if name == prefix+OriginFileName {
+			continue // Only ever a note about where the app came from
+		}
+
+		if path.Base(name) == "Info.plist" && dir != appRoot && isBundleDir(dir) {
+			data, err := l.read(f)
+			if err != nil {
+				return nil, err
+			}
+			nested := parsePlistDict(data)
+			if id, _ := nested["CFBundleIdentifier"].(string); id != "" {
+				bundleIDs[id] = append(bundleIDs[id], dir)
+				if mainID, _ := info["CFBundleIdentifier"].(string); isExtensionPlist(strings.TrimPrefix(name, prefix)) && !strings.HasPrefix(id, mainID+".") {
+					l.add(SeverityError, "extension-ids", dir, "extension ID "+id+" isn't prefixed by "+mainID+".",
+						"convert with --fix-extension-ids")
+				}
+				if mainID, _ := info["CFBundleIdentifier"].(string); isAppClipPlist(strings.TrimPrefix(name, prefix)) && !strings.HasPrefix(id, mainID+".") {
+					l.add(SeverityError, "appclip-ids", dir, "App Clip ID "+id+" isn't prefixed by "+mainID+".",
+						"give the clip the app's ID plus a suffix, or convert with --strip-appclips")
+				}
+			}
+			if executable, _ := nested["CFBundleExecutable"].(string); executable != "" {
+				l.checkExecutable(dir+"/"+executable, false)
+			}
+			if minOS, _ := nested["MinimumOSVersion"].(string); isAppClipPlist(strings.TrimPrefix(name, prefix)) && minOS == "" {
+				l.add(SeverityWarning, "appclip-min-os", dir, "App Clip has no MinimumOSVersion of its own",
+					"set MinimumOSVersion in the clip's Info.plist, "+appClipMinOS+" or later")
+			}
+		}
+
+		if strings.HasSuffix(name, ".dylib") && f.Mode().IsRegular() {
+			l.checkExecutable(name, false)
+		}
+
+		if f.Mode()&os.ModeSymlink != 0 {
+			target, err := l.read(f)
+			if err != nil {
+				return nil, err
+			}
+			l.checkSymlink(name, string(target), appRoot)
+		}
+	}
+
+	ids := make([]string, 0, len(bundleIDs))
+	for id := range bundleIDs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if dirs := bundleIDs[id]; len(dirs) > 1 {
+			l.add(SeverityError, "bundle-ids", strings.Join(dirs, ", "), "bundle ID "+id+" is used by more than one bundle",
+				"every framework and extension needs its own CFBundleIdentifier")
+		}
+	}
+	return l.findings, nil
+}
+
+// isBundleDir reports whether a path names a nested bundle folder
+func isBundleDir(dir string) bool {
+	for _, ext := range []string{".framework", ".appex", ".app", ".bundle"} {
+		if strings.HasSuffix(dir, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkExecutable verifies a Mach-O is present, has its executable bit, contains arm64
+// and isn't FairPlay encrypted. main is the app's own executable, where problems are fatal.
+func (l *linter) checkExecutable(name string, main bool) {
+	severity := SeverityWarning
+	if main {
+		severity = SeverityError
+	}
+
+	f := l.files[name]
+	if f == nil {
+		l.add(severity, "executable", name, "CFBundleExecutable points at a missing file", "check the executable name in Info.plist")
+		return
+	}
+	if f.Mode().IsRegular() && f.Mode().Perm()&0111 == 0 {
+		l.add(severity, "permissions", name, "executable has no executable bit in the archive", "store it with mode 0755")
+	}
+
+	data, err := l.read(f)
+	if err != nil {
+		l.add(severity, "executable", name, "can't read executable: "+err.Error(), "")
+		return
+	}
+
+	slices, err := machoSlices(bytes.NewReader(data))
+	if err != nil {
+		l.add(severity, "executable", name, "not a Mach-O binary", "the deb may ship a wrapper script or a corrupt binary")
+		return
+	}
+
+	hasARM64 := false
+	for _, slice := range slices {
+		if slice.Cpu == macho.CpuArm64 {
+			hasARM64 = true
+		}
+		if sliceEncrypted(slice) {
+			l.add(SeverityError, "encryption", name, fmt.Sprintf("%s slice is FairPlay encrypted", archName(slice.Cpu)),
+				"use a decrypted binary; encrypted apps only run for the account that bought them")
+		}
+	}
+	if !hasARM64 {
+		l.add(severity, "architecture", name, "no arm64 slice", "64-bit devices can't run 32-bit-only binaries")
+	}
+}
+
+// checkSymlink verifies a link resolves to an entry inside the bundle
+func (l *linter) checkSymlink(name, target, appRoot string) {
+	if path.IsAbs(target) {
+		l.add(SeverityWarning, "symlinks", name, "symlink points at absolute path "+target, "absolute links don't resolve inside the app container")
+		return
+	}
+	resolved := path.Join(path.Dir(name), target)
+	if resolved != appRoot && !strings.HasPrefix(resolved, appRoot+"/") {
+		l.add(SeverityWarning, "symlinks", name, "symlink escapes the bundle: "+target, "")
+		return
+	}
+	if l.files[resolved] == nil {
+		l.add(SeverityWarning, "symlinks", name, "symlink target "+target+" is not in the bundle", "")
+	}
+}
+
+// lintFailed reports whether findings should fail the run
+func lintFailed(findings []LintFinding, strict bool) bool {
+	for _, f := range findings {
+		if f.Severity == SeverityError || strict {
+			return true
+		}
+	}
+	return false
+}
+
+// printLint renders findings for humans
+func printLint(findings []LintFinding) {
+	if len(findings) == 0 {
+		fmt.Println("   ✅ No problems found")
+		return
+	}
+	for _, f := range findings {
+		icon := "⚠️ "
+		if f.Severity == SeverityError {
+			icon = "❌"
+		}
+		fmt.Printf("   %s [%s] %s", icon, f.Check, terminalSafe(f.Message))
+		if f.Path != "" {
+			fmt.Printf(" (%s)", terminalSafe(f.Path))
+		}
+		fmt.Println()
+		if f.Hint != "" {
+			fmt.Printf("      → %s\n", f.Hint)
+		}
+	}
+}
+
+// runLint implements the lint subcommand: 0 clean, 1 failed, 2 unreadable
+func runLint(args []string) int {
+	fs := flag.NewFlagSet("lint", flag.ContinueOnError)
+	strict := fs.Bool("strict", false, "fail on warnings too")
+	asJSON := fs.Bool("json", false, "print findings as JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: deb-to-ipa lint [--strict] [--json] <app.ipa>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	findings, err := lintIPA(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Error: %s: %v\n", fs.Arg(0), err)
+		return 2
+	}
+	if *asJSON {
+		if findings == nil {
+			findings = []LintFinding{}
+		}
+		out, _ := json.MarshalIndent(findings, "", "  ")
+		fmt.Println(string(out))
+	} else {
+		fmt.Printf("🔎 Linting %s\n", fs.Arg(0))
+		if origin, err := readIPAOrigin(fs.Arg(0)); err != nil {
+			fmt.Printf("   ⚠️  Unreadable %s: %v\n", OriginFileName, err)
+		} else if origin != nil {
+			printOrigin(origin)
+		}
+		printLint(findings)
+	}
+
+	if lintFailed(findings, *strict) {
+		return 1
+	}
+	return 0
+}
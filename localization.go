@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// LocalizationResult summarizes what --keep-localizations/--strip-localizations removed
+type LocalizationResult struct {
+	Kept         []string `json:"kept"`
+	RemovedDirs  int      `json:"removedDirs"`
+	RemovedBytes int64    `json:"removedBytes"`
+}
+
+// lprojDir finds the innermost .lproj directory a path is in (or is) and its language,
+// e.g. "Frameworks/Foo.framework/de.lproj/Foo.strings" -> "Frameworks/Foo.framework/de.lproj", "de"
+func lprojDir(relPath string) (dir, lang string, ok bool) {
+	segments := strings.Split(relPath, "/")
+	for i := len(segments) - 1; i >= 0; i-- {
+		if strings.HasSuffix(segments[i], ".lproj") {
+			return strings.Join(segments[:i+1], "/"), strings.TrimSuffix(segments[i], ".lproj"), true
+		}
+	}
+	return "", "", false
+}
+
+// stripLocalizations drops every .lproj directory, in the app and in nested frameworks and
+// extensions, whose language isn't in keep. Base.lproj always stays.
+func stripLocalizations(entries []BundleEntry, keep []string) ([]BundleEntry, *LocalizationResult) {
+	keepSet := map[string]bool{"base": true}
+	for _, lang := range keep {
+		keepSet[strings.ToLower(strings.TrimSpace(lang))] = true
+	}
+
+	result := &LocalizationResult{Kept: keep}
+	removed := make(map[string]bool) // Debs don't always carry directory entries, so count paths
+	kept := entries[:0]
+	for _, entry := range entries {
+		dir, lang, ok := lprojDir(entry.RelPath)
+		if !ok || keepSet[strings.ToLower(lang)] {
+			kept = append(kept, entry)
+			continue
+		}
+
+		removed[dir] = true
+		if !entry.File.IsDir && !entry.File.IsLink {
+			result.RemovedBytes += entry.File.Size
+		}
+	}
+	result.RemovedDirs = len(removed)
+
+	fmt.Printf("   Removed %d localization director%s (%s), keeping %s\n",
+		result.RemovedDirs, map[bool]string{true: "y", false: "ies"}[result.RemovedDirs == 1],
+		formatBytes(result.RemovedBytes), strings.Join(append([]string{"Base"}, keep...), ", "))
+	return kept, result
+}
+
+// localizationsToKeep returns the languages named by --keep-localizations, or with
+// --strip-localizations just CFBundleDevelopmentRegion. nil means leave .lproj folders alone.
+func localizationsToKeep(opts Options, infoPlistData []byte) []string {
+	var keep []string
+	for _, lang := range strings.Split(opts.KeepLocalizations, ",") {
+		if lang = strings.TrimSpace(lang); lang != "" {
+			keep = append(keep, lang)
+		}
+	}
+	if len(keep) > 0 {
+		return keep
+	}
+	if !opts.StripLocalizations {
+		return nil
+	}
+	keep = []string{}
+	if region := plistValue(infoPlistData, "CFBundleDevelopmentRegion"); region != "" {
+		keep = append(keep, region)
+	}
+	return keep
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Output locking: one conversion per output path at a time ---
+// <output>.lock is held with an OS file lock (flock, LockFileEx), so the kernel drops it
+// when the holder exits, however it exits: there are no stale locks to take over, and so no
+// race between two processes taking one over. The file holds the writer's pid, for the
+// message a second conversion fails fast with, or with --wait-lock prints while it polls.
+// A lock file still holding a pid when its lock is taken was left by a run that crashed,
+// and its half-written output is removed.
+
+// lockPollInterval is how often --wait-lock retries
+const lockPollInterval = 250 * time.Millisecond
+
+// outputLock is a held lock on an output path
+type outputLock struct {
+	path string
+	f    *os.File // Open, holding the OS lock, until release
+}
+
+// errOutputLocked is returned when another live process holds the lock
+var errOutputLocked = errors.New("output is being written by another process")
+
+// lockOutput takes the lock for outputPath, waiting for the holder to finish if wait is set
+func lockOutput(outputPath string, wait bool) (*outputLock, error) {
+	lockPath := outputPath + ".lock"
+	waiting := false
+	for {
+		lock, holder, err := tryLockOutput(lockPath)
+		if err != nil {
+			return nil, fmt.Errorf("locking %s: %w", outputPath, err)
+		}
+		if lock != nil {
+			if holder != 0 {
+				// A crashed run, and whatever it left half-written
+				fmt.Printf("   Cleaning up after pid %d, which never released %s\n", holder, filepath.Base(lockPath))
+				partials, _ := filepath.Glob(filepath.Join(filepath.Dir(outputPath), "."+filepath.Base(outputPath)+".*.partial"))
+				for _, p := range partials {
+					os.Remove(p)
+				}
+			}
+			return lock, nil
+		}
+		if !wait {
+			return nil, fmt.Errorf("%w (%s): %s (use --wait-lock to wait for it)", errOutputLocked, describeHolder(holder), outputPath)
+		}
+		if !waiting {
+			fmt.Printf("   Waiting for %s to finish writing %s...\n", describeHolder(holder), outputPath)
+			waiting = true
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// tryLockOutput makes one attempt at the lock file. It returns the lock when it got it,
+// with the pid a crashed holder left in the file (0 for none), or else the holder's pid
+// (0 when it hasn't written it yet).
+func tryLockOutput(lockPath string) (*outputLock, int, error) {
+	f, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, 0, err
+	}
+	locked, err := tryLockFile(f)
+	if err != nil || !locked {
+		f.Close()
+		return nil, lockHolder(lockPath), err
+	}
+	// The holder before may have removed the file between our open and our lock, leaving
+	// us holding a lock on a file no one else will open: start over on the one at the path
+	opened, err := f.Stat()
+	if err == nil {
+		current, statErr := os.Stat(lockPath)
+		if os.IsNotExist(statErr) || statErr == nil && !os.SameFile(opened, current) {
+			f.Close()
+			return tryLockOutput(lockPath)
+		}
+		err = statErr
+	}
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+
+	data, _ := io.ReadAll(f)
+	crashed, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err := writeLockPid(f); err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return &outputLock{path: lockPath, f: f}, crashed, nil
+}
+
+// writeLockPid replaces the lock file's contents with this process's pid
+func writeLockPid(f *os.File) error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	_, err := f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	return err
+}
+
+// lockHolder reads the pid from a lock file, 0 if it can't or none is written yet
+func lockHolder(lockPath string) int {
+	data, err := os.ReadFile(lockPath)
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	return pid
+}
+
+// describeHolder names the process holding a lock
+func describeHolder(pid int) string {
+	if pid == 0 {
+		return "another process"
+	}
+	return "pid " + strconv.Itoa(pid)
+}
+
+// release drops the lock; nil-safe. The file is removed while still locked, so no one
+// can lock it in between and then lose it; the pid is cleared first, so a lock file that
+// outlives its lock (Windows won't remove a file that's open) isn't taken for a crashed run's.
+func (l *outputLock) release() {
+	if l == nil {
+		return
+	}
+	l.f.Truncate(0)
+	os.Remove(l.path)
+	l.f.Close()
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestLockHelperProcess is not a test: lock tests run it in a child process to hold the
+// lock on $DEBTOIPA_LOCK_OUTPUT until its stdin closes or it is killed
+func TestLockHelperProcess(t *testing.T) {
+	output := os.Getenv("DEBTOIPA_LOCK_OUTPUT")
+	if output == "" {
+		t.Skip("run by the lock tests")
+	}
+	lock, err := lockOutput(output, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(filepath.Dir(output), "."+filepath.Base(output)+".1.partial"), []byte("half"), 0644)
+	os.Stdout.WriteString("locked\n")
+	bufio.NewReader(os.Stdin).ReadString('\n')
+	lock.release()
+}
+
+// lockHolderProcess starts a child process holding the lock on output, returning it once
+// it does, with the pipe whose closing makes it release the lock
+func lockHolderProcess(t *testing.T, output string) (*exec.Cmd, *os.File) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestLockHelperProcess$")
+	cmd.Env = append(os.Environ(), "DEBTOIPA_LOCK_OUTPUT="+output)
+	stdin, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd.Stdin = stdin
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	stdin.Close()
+	t.Cleanup(func() { w.Close(); cmd.Process.Kill(); cmd.Wait() })
+	if line, _ := bufio.NewReader(out).ReadString('\n'); line != "locked\n" {
+		t.Fatalf("lock holder: %q", line)
+	}
+	return cmd, w
+}
+
+func TestLockOutputHeld(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "app.ipa")
+	holder, release := lockHolderProcess(t, output)
+
+	_, err := lockOutput(output, false)
+	if !errors.Is(err, errOutputLocked) || !strings.Contains(err.Error(), "pid "+strconv.Itoa(holder.Process.Pid)) {
+		t.Fatalf("second lock: %v, want errOutputLocked naming pid %d", err, holder.Process.Pid)
+	}
+
+	// --wait-lock gets it once the holder lets go
+	got := make(chan error, 1)
+	go func() {
+		lock, err := lockOutput(output, true)
+		lock.release()
+		got <- err
+	}()
+	select {
+	case err := <-got:
+		t.Fatalf("--wait-lock returned %v while the lock was held", err)
+	case <-time.After(2 * lockPollInterval):
+	}
+	release.Close()
+	select {
+	case err := <-got:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("--wait-lock still waiting after the holder released")
+	}
+}
+
+// TestLockOutputCrashed kills the holder: the OS drops its lock, and the next conversion
+// takes it at once and removes the partial output it left
+func TestLockOutputCrashed(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "app.ipa")
+	holder, _ := lockHolderProcess(t, output)
+	holder.Process.Kill()
+	holder.Wait()
+
+	lock, err := lockOutput(output, false)
+	if err != nil {
+		t.Fatalf("lock after the holder was killed: %v", err)
+	}
+	defer lock.release()
+	if partials, _ := filepath.Glob(filepath.Join(filepath.Dir(output), ".app.ipa.*.partial")); len(partials) != 0 {
+		t.Errorf("crashed run's partials left: %v", partials)
+	}
+	if pid := lockHolder(output + ".lock"); pid != os.Getpid() {
+		t.Errorf("lock file holds pid %d, want ours, %d", pid, os.Getpid())
+	}
+}
+
+// TestLockOutputEmptyPid doesn't take a lock file with no pid yet for a stale one
+func TestLockOutputEmptyPid(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "app.ipa")
+	f, err := os.OpenFile(output+".lock", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if locked, err := tryLockFile(f); !locked || err != nil {
+		t.Skipf("no file locks here: %v", err)
+	}
+	if _, err := lockOutput(output, false); !errors.Is(err, errOutputLocked) {
+		t.Fatalf("lock held with no pid written: got %v, want errOutputLocked", err)
+	}
+}
+
+// TestConvertConcurrent races two conversions of one fixture to one output with
+// --wait-lock: they take turns, and the IPA left is whole
+func TestConvertConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	debPath := filepath.Join(dir, "fixture.deb")
+	f, err := os.Create(debPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := buildFixture(f, FixtureSpec{LargeFile: 4 << 20}); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	output := filepath.Join(dir, "out.ipa")
+	opts := Options{Layout: LayoutPayload, Order: OrderTar, DirEntries: DirEntriesAlways, CompressSpill: SpillCompressAuto, Output: output, MaxWarnings: -1, WaitLock: true}
+
+	var wg sync.WaitGroup
+	var failed atomic.Int32
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := convert(debPath, opts); err != nil {
+				t.Errorf("convert: %v", err)
+				failed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if failed.Load() > 0 {
+		return
+	}
+	zr, err := zip.OpenReader(output)
+	if err != nil {
+		t.Fatalf("the IPA two conversions wrote doesn't open: %v", err)
+	}
+	defer zr.Close()
+	checkFixtureApp(t, &zr.Reader, fixtureApp)
+	if _, err := os.Stat(output + ".lock"); !os.IsNotExist(err) {
+		t.Errorf("lock file left behind: %v", err)
+	}
+}
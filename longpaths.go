@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// defaultPathWarnLength is the longest archive entry name that extracts everywhere: Windows
+// Explorer and older unzip builds fail somewhere past 255 characters
+const defaultPathWarnLength = 255
+
+// maxPathComponent is the longest single file name most filesystems accept
+const maxPathComponent = 255
+
+// LongPath is an archive entry name likely to break extraction somewhere
+type LongPath struct {
+	Name   string `json:"name"`
+	Length int    `json:"length"`
+	Reason string `json:"reason"`
+}
+
+// findLongPaths measures every entry's final name in the archive, longest first. Names over
+// limit (0 disables that check) and single components over maxPathComponent are returned.
+func findLongPaths(entries []BundleEntry, layout, appNameFolder string, limit int) []LongPath {
+	var long []LongPath
+	for _, entry := range entries {
+		name := zipEntryName(layout, appNameFolder, entry.RelPath)
+		if name == "" {
+			continue
+		}
+		component := 0
+		for _, part := range strings.Split(name, "/") {
+			component = max(component, len(part))
+		}
+		switch {
+		case component > maxPathComponent:
+			long = append(long, LongPath{name, len(name), fmt.Sprintf("a path component is %d bytes", component)})
+		case limit > 0 && len(name) > limit:
+			long = append(long, LongPath{name, len(name), fmt.Sprintf("longer than %d characters", limit)})
+		}
+	}
+	sort.SliceStable(long, func(i, j int) bool { return long[i].Length > long[j].Length })
+	return long
+}
+
+// warnLongPaths warns about each long entry name, longest first
+func warnLongPaths(long []LongPath, warnings *warningLog) {
+	for _, l := range long {
+		warnings.add("long-path", l.Name, "%s (%d, %s); Windows and older unzip may fail to extract it", truncateMiddle(l.Name, 100), l.Length, l.Reason)
+	}
+}
+
+// truncateMiddle shortens s to about n characters by eliding its middle
+func truncateMiddle(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	half := (n - 3) / 2
+	return string(r[:half]) + "..." + string(r[len(r)-half:])
+}
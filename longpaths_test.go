@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFindLongPaths(t *testing.T) {
+	entry := func(rel string) BundleEntry { return BundleEntry{RelPath: rel, File: &VirtualFile{}} }
+	short := "Assets/icon.png"
+	long := strings.Repeat("nested/", 40) + "image.png"              // 289 bytes, over 255 once prefixed
+	component := "Assets/" + strings.Repeat("x", maxPathComponent+1) // One name no filesystem takes
+	entries := []BundleEntry{entry(short), entry(long), entry(component)}
+
+	got := findLongPaths(entries, LayoutPayload, "Fixture.app", defaultPathWarnLength)
+	if len(got) != 2 {
+		t.Fatalf("%d long paths, want 2: %+v", len(got), got)
+	}
+	if got[0].Name != fixtureApp+long || got[0].Length != len(fixtureApp+long) || !strings.Contains(got[0].Reason, "longer than 255") {
+		t.Errorf("longest first: %+v", got[0])
+	}
+	if got[1].Name != fixtureApp+component || !strings.Contains(got[1].Reason, "component is 256 bytes") {
+		t.Errorf("long component: %+v", got[1])
+	}
+
+	// 0 turns the length check off, but not the component one
+	if got := findLongPaths(entries, LayoutPayload, "Fixture.app", 0); len(got) != 1 || got[0].Name != fixtureApp+component {
+		t.Errorf("--path-warn-length 0: %+v", got)
+	}
+}
+
+// TestConvertLongPaths converts asset paths over 300 characters: they're warned about,
+// and come back whole, with their data, from the archive
+func TestConvertLongPaths(t *testing.T) {
+	result, zr := convertFixture(t, FixtureSpec{LongPaths: true}, Options{PathWarnLength: defaultPathWarnLength})
+	var long []string
+	for _, f := range zr.File {
+		if len(f.Name) > 300 {
+			long = append(long, f.Name)
+		}
+	}
+	if len(long) == 0 {
+		t.Fatal("no entry name over 300 characters in the IPA")
+	}
+	image := zipEntries(zr)[long[len(long)-1]]
+	if !strings.HasSuffix(image.Name, "/image@3x.png") || string(readZipFile(t, image)) != "not really a png\n" {
+		t.Errorf("longest entry %s doesn't read back as the fixture's image", image.Name)
+	}
+	checkFixtureApp(t, zr, fixtureApp)
+
+	warned := 0
+	for _, w := range result.Warnings {
+		if w.Code == "long-path" {
+			warned++
+		}
+	}
+	if warned == 0 || warned != len(result.LongPaths) {
+		t.Errorf("%d long-path warnings for %d long paths", warned, len(result.LongPaths))
+	}
+	entries := zipEntries(zr)
+	for _, l := range result.LongPaths {
+		// Folders are measured without the slash their entry ends in
+		if l.Length != len(l.Name) || entries[l.Name] == nil && entries[l.Name+"/"] == nil {
+			t.Errorf("long path %q (%d) isn't an entry of that length", l.Name, l.Length)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"debug/macho"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Mach-O load commands not exposed by debug/macho
+const (
+	lcEncryptionInfo      = 0x21
+	lcVersionMinIPhoneOS  = 0x25
+	lcBuildVersion        = 0x32
+	lcEncryptionInfo64    = 0x2C
+	platformIOS           = 2
+	platformIOSSimulator  = 7
+	platformMacCatalyst   = 6
+	machoVersionFieldMask = 0xff
+)
+
+// MachOSlice describes one architecture of a binary
+type MachOSlice struct {
+	Arch      string `json:"arch"`
+	MinOS     string `json:"minOS,omitempty"`     // From LC_BUILD_VERSION or LC_VERSION_MIN_IPHONEOS
+	Encrypted bool   `json:"encrypted,omitempty"` // FairPlay encrypted, see sliceEncrypted
+}
+
+// machoSlices parses a thin or fat Mach-O into its per-architecture files
+func machoSlices(r io.ReaderAt) ([]*macho.File, error) {
+	if fat, err := macho.NewFatFile(r); err == nil {
+		slices := make([]*macho.File, len(fat.Arches))
+		for i, arch := range fat.Arches {
+			slices[i] = arch.File
+		}
+		return slices, nil
+	}
+	thin, err := macho.NewFile(r)
+	if err != nil {
+		return nil, err
+	}
+	return []*macho.File{thin}, nil
+}
+
+// archName renders a CPU type the way lipo does: "arm64", "arm", "x86_64"...
+func archName(cpu macho.Cpu) string {
+	switch cpu {
+	case macho.CpuAmd64:
+		return "x86_64"
+	case macho.CpuArm:
+		return "armv7"
+	}
+	return strings.ToLower(strings.TrimPrefix(cpu.String(), "Cpu"))
+}
+
+// minOSVersion reads a slice's minimum iOS version from LC_BUILD_VERSION (iOS platforms
+// only) or the older LC_VERSION_MIN_IPHONEOS, "" if it has neither
+func minOSVersion(f *macho.File) string {
+	for _, load := range f.Loads {
+		raw := load.Raw()
+		if len(raw) < 16 {
+			continue
+		}
+		switch f.ByteOrder.Uint32(raw) {
+		case lcBuildVersion:
+			switch f.ByteOrder.Uint32(raw[8:]) {
+			case platformIOS, platformIOSSimulator, platformMacCatalyst:
+				return formatMachOVersion(f.ByteOrder.Uint32(raw[12:]))
+			}
+		case lcVersionMinIPhoneOS:
+			return formatMachOVersion(f.ByteOrder.Uint32(raw[8:]))
+		}
+	}
+	return ""
+}
+
+// sliceEncrypted reports whether a slice's LC_ENCRYPTION_INFO has a non-zero cryptid,
+// i.e. it's FairPlay encrypted
+func sliceEncrypted(f *macho.File) bool {
+	for _, load := range f.Loads {
+		raw := load.Raw()
+		if len(raw) < 20 {
+			continue
+		}
+		cmd := f.ByteOrder.Uint32(raw)
+		if (cmd == lcEncryptionInfo || cmd == lcEncryptionInfo64) && f.ByteOrder.Uint32(raw[16:20]) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// formatMachOVersion decodes xxxx.yy.zz nibble-packed versions, dropping a zero patch
+func formatMachOVersion(v uint32) string {
+	major, minor, patch := v>>16, (v>>8)&machoVersionFieldMask, v&machoVersionFieldMask
+	if patch == 0 {
+		return fmt.Sprintf("%d.%d", major, minor)
+	}
+	return fmt.Sprintf("%d.%d.%d", major, minor, patch)
+}
+
+// compareDottedVersions orders "14.0" < "14.0.1" < "15" numerically, component by component
+func compareDottedVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < max(len(as), len(bs)); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// readerAt gives random access to a VirtualFile, which debug/macho needs
+func readerAt(vf *VirtualFile) (io.ReaderAt, func(), error) {
+	if vf.DiskPath == "" {
+		return bytes.NewReader(vf.Data), func() {}, nil
+	}
+	if vf.Compressed {
+		// Deflate can't seek: the binary is inflated into memory for the duration
+		data, err := readAll(vf)
+		if err != nil {
+			return nil, nil, err
+		}
+		return bytes.NewReader(data), func() {}, nil
+	}
+	r, err := fileHandles.open(vf.DiskPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return r, func() { r.Close() }, nil
+}
+
+// executableSlices lists the architectures and minimum OS of the main executable
+func executableSlices(entries []BundleEntry, executableName string) []MachOSlice {
+	for _, entry := range entries {
+		if entry.RelPath != executableName || entry.File.IsDir || entry.File.IsLink {
+			continue
+		}
+		r, done, err := readerAt(entry.File)
+		if err != nil {
+			return nil
+		}
+		defer done()
+		files, err := machoSlices(r)
+		if err != nil {
+			return nil
+		}
+		slices := make([]MachOSlice, len(files))
+		for i, f := range files {
+			slices[i] = MachOSlice{Arch: fatArchName(uint32(f.Cpu), f.SubCpu), MinOS: minOSVersion(f), Encrypted: sliceEncrypted(f)}
+		}
+		return slices
+	}
+	return nil
+}
+
+// checkMinimumOS prints the binary's real minimum OS next to Info.plist's and warns when
+// the plist claims support for iOS versions a slice can't run on. Returns the highest
+// slice minimum, which is what the binary actually requires on current devices.
+func checkMinimumOS(slices []MachOSlice, plistMinOS string, warnings *warningLog) string {
+	binaryMinOS := ""
+	for _, s := range slices {
+		if s.MinOS != "" && (binaryMinOS == "" || compareDottedVersions(s.MinOS, binaryMinOS) > 0) {
+			binaryMinOS = s.MinOS
+		}
+	}
+	if binaryMinOS == "" {
+		return ""
+	}
+
+	var parts []string
+	for _, s := range slices {
+		parts = append(parts, s.Arch+" "+s.MinOS)
+	}
+	fmt.Printf("   MinOS: %s (binary: %s)\n", valueOr(plistMinOS, "unset"), strings.Join(parts, ", "))
+
+	for _, s := range slices {
+		if s.MinOS != "" && (plistMinOS == "" || compareDottedVersions(plistMinOS, s.MinOS) < 0) {
+			warnings.add("min-os", "Info.plist", "MinimumOSVersion %s is lower than the %s binary's %s; it will crash on older iOS",
+				valueOr(plistMinOS, "(unset)"), s.Arch, s.MinOS)
+		}
+	}
+	return binaryMinOS
+}
+
+// checkEncryption warns about FairPlay-encrypted slices of the main executable: an App
+// Store binary copied off a device undecrypted converts fine and never launches
+func checkEncryption(slices []MachOSlice, executableName string, warnings *warningLog) {
+	var encrypted []string
+	for _, s := range slices {
+		if s.Encrypted {
+			encrypted = append(encrypted, s.Arch)
+		}
+	}
+	if len(encrypted) > 0 {
+		warnings.add("executable-encrypted", executableName, "%s is FairPlay encrypted (%s); it only runs for the account that bought it, so use a decrypted binary",
+			executableName, strings.Join(encrypted, ", "))
+	}
+}
+
+// valueOr returns s, or fallback when s is empty
+func valueOr(s, fallback string) string {
+	if s == "" {
+		return fallback
+	}
+	return s
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// --- macOS bundles: Foo.app/Contents/MacOS/Foo instead of an iOS app ---
+// A Mac app keeps everything under Contents/, with its Info.plist there and its binary in
+// Contents/MacOS. Detection finds the .app folder all the same, but the IPA it makes has
+// no Info.plist at the bundle root and nothing iOS can launch, and installing it fails
+// without a word on why. Such bundles are refused unless --force-macos-layout.
+
+// macOSInfoPlist is where a macOS bundle keeps its Info.plist
+const macOSInfoPlist = "Contents/Info.plist"
+
+// macOSLayout reports whether the bundle is laid out as a macOS app: a Contents/MacOS
+// folder, or Contents/Info.plist without an Info.plist at the root
+func macOSLayout(entries []BundleEntry) bool {
+	rootPlist, contentsPlist := false, false
+	for _, entry := range entries {
+		switch {
+		case entry.RelPath == "Info.plist":
+			rootPlist = true
+		case entry.RelPath == macOSInfoPlist:
+			contentsPlist = true
+		case entry.RelPath == "Contents/MacOS", strings.HasPrefix(entry.RelPath, "Contents/MacOS/"):
+			return true
+		}
+	}
+	return contentsPlist && !rootPlist
+}
+
+// macOSInfoPlistData reads Contents/Info.plist, nil when the bundle has none
+func macOSInfoPlistData(entries []BundleEntry) []byte {
+	for _, entry := range entries {
+		if entry.RelPath == macOSInfoPlist && !entry.File.IsDir && !entry.File.IsLink {
+			if data, err := readAll(entry.File); err == nil {
+				return data
+			}
+		}
+	}
+	return nil
+}
+
+// macOSLayoutError explains why a macOS bundle can't become an IPA, naming the app from
+// its Contents/Info.plist when it has one
+func macOSLayoutError(appNameFolder string, infoPlistData []byte) error {
+	what := appNameFolder
+	if _, id, version := parseAppMetadata(infoPlistData); id != "Unknown" {
+		what = fmt.Sprintf("%s (%s %s)", appNameFolder, id, version)
+	}
+	return fmt.Errorf("%s is a macOS app (Contents/MacOS, Contents/Info.plist), not an iOS bundle: iOS wants Info.plist and the executable at the bundle root, so the IPA would fail to install. --force-macos-layout converts it anyway", what)
+}
@@ -3,11 +3,16 @@ package main
 import (
 	"archive/tar"
 	"archive/zip"
+	"bufio"
 	"bytes"
 	"compress/bzip2"
+	"compress/flate"
 	"compress/gzip"
-	"encoding/xml"
+	"crypto/sha256"
+	"errors"
+	"flag"
 	"fmt"
+	"hash"
 	"io"
 	"os"
 	"path"
@@ -16,115 +21,1538 @@ import (
 	"time"
 
 	ar "github.com/erikgeiser/ar"
-	"github.com/schollz/progressbar/v3"
 	"github.com/ulikunitz/xz"
 	"github.com/ulikunitz/xz/lzma"
 )
 
 // --- Configuration ---
+
+// MaxMemoryUsage is the RAM budget: past it, files are spilled to disk instead of held.
+// It covers file data and entry metadata, which are counted exactly, plus room held back
+// for the decompressor's dictionary and the zip writer's buffers (see SpillStore.budget).
+// It doesn't cover the Go runtime's slack or garbage not yet collected, so the process can
+// peak above it; the summary's measured peak heap says by how much.
 const MaxMemoryUsage = 2 * 1024 * 1024 * 1024 // 2GB RAM Limit
 
-// --- Structures ---
+// --- Structures ---
+
+// VirtualFile acts as the bridge between the extracted tar and the final zip
+type VirtualFile struct {
+	Name       string
+	Data       []byte
+	DiskPath   string
+	Size       int64
+	Mode       int64
+	ModTime    time.Time
+	IsDir      bool
+	IsLink     bool
+	LinkDest   string
+	Compressed bool   // DiskPath holds deflated data, spilled with --compress-spill
+	Owner      uint32 // Index into the store's owner table from the tar header; 0 when unknown
+}
+
+// Archive layouts for --layout
+const (
+	LayoutPayload = "payload" // Payload/MyApp.app/... (a regular IPA)
+	LayoutApp     = "app"     // MyApp.app/...
+	LayoutFlat    = "flat"    // The bundle contents at the archive root
+
+	LayoutExtension = "extension" // Extensions/MyExt.appex/..., what --appex makes of payload
+)
+
+// Options holds the command line configuration for a conversion
+type Options struct {
+	Output string // Output archive path, defaults to the deb path with the extension swapped
+	Layout string // One of the Layout* constants
+	Order  string // Entry order in the archive, one of the Order* constants
+
+	DirEntries string // Which folders get an archive entry, one of the DirEntries* constants
+
+	ExtractTo    string // Write the .app to this directory instead of zipping an IPA
+	NoPayloadDir bool   // With ExtractTo: write <App>.app directly, without the Payload folder
+	Force        bool   // Allow writing into a non-empty ExtractTo directory
+
+	Merge              []string // Extra debs overlaid on the base app, later ones winning
+	AllowPlistOverride bool     // Let a merged deb replace the app's Info.plist
+
+	Universal            string // Deb of the same app for other architectures, lipo-merged in; see universal.go
+	AllowVersionMismatch bool   // Merge a Universal deb whose CFBundleVersion differs
+
+	Install bool   // Install the IPA on a USB-connected device afterwards
+	UDID    string // Device to install to, when several are attached
+
+	AltStoreSource   string // AltStore source JSON to add this version to
+	DownloadURL      string // Where the IPA will be served from, for the source entry
+	AltStoreIconURL  string // Where the app's icon is served from; by default --export-icon's file beside the IPA
+	PrintSourceEntry bool   // Print the AltStore app entry to stdout
+
+	ITunesArtwork bool   // Add a 512x512 iTunesArtwork PNG at the archive root
+	ExportIcon    string // Write the app icon as a standard PNG to this path
+	Depiction     string // Write a Sileo depiction here; depictionBesideIPA for <ipa>.depiction.json
+	NormalizePNGs bool   // Rewrite Apple-optimized (CgBI) PNGs as standard PNGs
+
+	KeepContainerMetadata bool // Copy iTunesMetadata.plist from a dumped app's bundle container
+	BundleLinkedResources bool // Replace symlinks into the deb outside the app with what they point at
+	EmbedOrigin           bool // Record the deb's control fields and SHA256 in <App>.app/_deb_origin.json
+
+	Report       string         // Write a JSON report of the conversion to this path
+	ReportIcon   bool           // Include a base64 icon thumbnail in the report
+	Manifest     bool           // Record size, CRC32 and SHA256 of every archive entry
+	VerifyOutput bool           // Read every file of the written IPA back, checking its CRC32
+	OptionsFrom  string         // A report or origin file whose recorded options to replay
+	Recipe       string         // A recipe file whose options were applied
+	RecipeMatch  RecipeMatch    // Its match, checked against the package converted
+	Replay       []ReplayOption // The flags given or replayed, recorded in the report and origin
+
+	Add          []string // local/path:Bundle/Relative/Dest files to insert
+	AddOverwrite bool     // Let --add replace existing bundle entries
+
+	BundleID    string // Replaces CFBundleIdentifier
+	AppVersion  string // Replaces CFBundleShortVersionString
+	BuildNumber string // Replaces CFBundleVersion
+	MinOS       string // Replaces MinimumOSVersion
+
+	AppPrefix         string   // The app folder inside data.tar, e.g. "Applications/MyApp.app/", instead of detecting it
+	Appex             bool     // Convert a bare app extension (*.appex outside any .app) instead of an app
+	Executable        string   // Main binary name, overriding Info.plist and the Mach-O heuristics
+	ExcludeFrameworks []string // Globs on framework and dylib names to leave out; see excludeframework.go
+	MinExecutable     int64    // Warn when the main executable is smaller; 0 for no warning (under a Mach-O header always fails)
+	PayloadDirName    string   // The .app folder's name in the archive, instead of the deb's
+	SanitizeAppName   bool     // Name the .app folder in the archive with safe characters only
+	FixExtensionIDs   bool     // Rename app extensions whose IDs aren't children of the main app's
+	RelocateDylibs    bool     // Move .dylib files at the bundle root into Frameworks/
+
+	ProvisioningProfile string // .mobileprovision to embed as embedded.mobileprovision
+
+	Repo           string   // APT repo to download --package from
+	Package        string   // Package ID to fetch from --repo
+	PackageVersion string   // Exact version to fetch instead of the newest
+	RepoHeaders    []string // Extra "Name: value" request headers, e.g. X-Machine
+	KeepDeb        bool     // Keep the downloaded deb in the working directory
+	ExpectSHA256   string   // The deb's checksum, checked as well as the index's
+	DownloadCache  string   // Where partial downloads are kept to resume, instead of the user cache directory
+	NoResume       bool     // Download to a temp file in one go, without the cache
+
+	Include []string // Globs kept even when an --exclude matches
+	Exclude []string // Globs of bundle paths to leave out
+
+	MethodGlobs   []methodGlob // --store-glob and --deflate-glob, in the order given
+	StoreMachOMin int64        // Store Mach-O files at least this big; 0 stores only the main executable
+	ExecGlobs     []execGlob   // --exec-glob and --no-exec-glob, in the order given
+
+	KeepLocalizations  string // Comma-separated languages whose .lproj folders survive (plus Base)
+	StripLocalizations bool   // Keep only Base.lproj and the development region's .lproj
+	StripDebug         bool   // Leave out .dSYM folders
+	StripAppClips      bool   // Leave out AppClips/*.app
+	RecurseNested      bool   // Convert the deb inside a deb without an app; see nested.go
+	StripBitcode       bool   // Cut the __LLVM segment out of every Mach-O
+	KeepAppleDouble    bool   // Keep "._" AppleDouble and .DS_Store files instead of leaving them out
+	ForceMacOSLayout   bool   // Convert a macOS bundle (Contents/MacOS) instead of refusing it
+
+	ScanJBPaths bool     // Look for hardcoded jailbreak paths in binaries
+	JBPaths     []string // Prefixes to look for instead of defaultJBPaths
+
+	TempDir       string      // Where files over the RAM budget are spilled, instead of the system temp dir
+	Staging       string      // Write the IPA here first, then copy it to the output (see staging.go)
+	MaxSpillSize  int64       // Most bytes one conversion may spill; 0 for no limit
+	MaxOutputSize int64       // Most bytes the IPA may take; 0 for no limit
+	CompressSpill string      // SpillCompressAuto, SpillCompressOn or SpillCompressOff
+	TwoPass       bool        // Index data.tar before extracting, whatever the deb's size
+	Limits        InputLimits // --max-entries, --max-entry-size, --deny-symlinks and --deny-absolute-paths
+	Verbose       bool        // Print every adjustment, e.g. each permission fixed
+	Trace         string      // Append every decision, timestamped, to this file (see trace.go)
+	IPC           string      // Send events to a front end over this Unix socket or named pipe (see ipc.go)
+	CI            string      // Annotate the run for a CI system, one of the CI* constants (see ci.go)
+	WaitLock      bool        // Wait for another conversion writing the same output instead of failing
+
+	MTime    string // Timestamp for every entry (RFC 3339 or Unix seconds); SOURCE_DATE_EPOCH when empty
+	MTimeMax bool   // Only pull entries newer than MTime back to it, leaving older ones alone
+
+	PathWarnLength      int  // Warn about archive entry names longer than this; 0 disables
+	AllowCaseCollisions bool // Keep one of each set of colliding paths instead of failing
+
+	Clock *benchClock // Stage timings for the bench subcommand; nil otherwise
+
+	Lint        bool   // Check the written IPA for install/launch problems
+	Strict      bool   // Fail on any conversion warning, and with --lint on lint warnings too
+	MaxWarnings int    // Fail when the conversion warns more than this often; negative never fails
+	WarnExit    string // WarnExitNever, WarnExitWarning or WarnExitError: exit 10 on warnings at that level
+
+	Dedupe       string // "" (off), DedupeReport or DedupeLink
+	SizeReport   bool   // Print a breakdown of where the bundle's bytes go
+	FastTransfer bool   // Store every entry and skip optional CPU-heavy steps; see fasttransfer.go
+	JSON         bool   // Print machine-readable output (the size report) as JSON
+}
+
+// Result describes a finished conversion
+type Result struct {
+	Schema           string           `json:"schema,omitempty"`          // schema.Report, in the report file and the result event
+	Status           string           `json:"status"`                    // ResultStatusOK, ResultStatusWarnings, or ResultStatusError in a failed run's report
+	OutputPath       string           `json:"output,omitempty"`          // The IPA written, empty with --extract-to
+	AppName          string           `json:"appName"`                   // The .app folder name, e.g. "MyApp.app"
+	OriginalAppName  string           `json:"originalAppName,omitempty"` // The deb's name for it, when --payload-dir-name or --sanitize-app-name renamed it
+	BundleID         string           `json:"bundleId"`
+	Version          string           `json:"version"`
+	Executable       string           `json:"executable"`
+	ExecutableSource string           `json:"executableSource"`                 // One of the ExecutableFrom* constants
+	MinOS            string           `json:"minimumOSVersion,omitempty"`       // Info.plist MinimumOSVersion, after --min-os
+	BinaryMinOS      string           `json:"binaryMinimumOSVersion,omitempty"` // Highest LC_BUILD_VERSION/LC_VERSION_MIN_IPHONEOS across slices
+	Slices           []MachOSlice     `json:"slices,omitempty"`                 // Architectures of the main executable
+	Universal        *UniversalResult `json:"universal,omitempty"`              // Files --universal merged into fat binaries
+	Icon             *IconInfo        `json:"icon,omitempty"`
+	Entries          int              `json:"entries"`       // Tar entries read, merged debs included
+	MetadataBytes    int64            `json:"metadataBytes"` // Estimated memory held by entry metadata, counted against the RAM limit
+
+	NormalizedPNGs   int                 `json:"normalizedPngs,omitempty"`     // CgBI PNGs rewritten with --normalize-pngs
+	Added            []string            `json:"added,omitempty"`              // Bundle paths written by --add
+	VersionOverrides []VersionOverride   `json:"versionOverrides,omitempty"`   // From --bundle-id/--app-version/--build-number/--min-os
+	MetadataFixes    []MetadataFix       `json:"metadataFixes,omitempty"`      // Info.plist values cleaned up before use
+	Extensions       []ExtensionID       `json:"extensions,omitempty"`         // PlugIns/*.appex IDs, before and after --fix-extension-ids
+	AppClips         []AppClip           `json:"appClips,omitempty"`           // AppClips/*.app, checked or left out with --strip-appclips
+	RootDylibs       []RootDylib         `json:"rootDylibs,omitempty"`         // .dylib files at the bundle root and whether dyld finds them
+	LinkedResources  []LinkedResource    `json:"linkedResources,omitempty"`    // Symlinks from the bundle into the rest of the deb
+	Excluded         []ExcludedFramework `json:"excludedFrameworks,omitempty"` // Left out with --exclude-framework
+	Profile          *ProfileInfo        `json:"provisioningProfile,omitempty"`
+	Localizations    *LocalizationResult `json:"localizations,omitempty"`
+	Debug            *DebugResult        `json:"debug,omitempty"`       // .dSYM folders, and DWARF and bitcode in binaries with --strip-debug or --size-report
+	Bitcode          []BitcodeStrip      `json:"bitcode,omitempty"`     // Binaries --strip-bitcode rewrote or skipped
+	AppleDouble      *AppleDoubleResult  `json:"appleDouble,omitempty"` // "._" files left out, xattrs dropped and resource forks lost
+	JBPaths          []JBPathHit         `json:"jailbreakPaths,omitempty"`
+	LongPaths        []LongPath          `json:"longPaths,omitempty"` // Entry names past --path-warn-length
+	PathCollisions   []PathCollision     `json:"pathCollisions,omitempty"`
+	Lint             []LintFinding       `json:"lint,omitempty"`
+	Manifest         *Manifest           `json:"manifest,omitempty"` // Per-entry checksums, with --manifest
+	SizeReport       *SizeReport         `json:"sizeReport,omitempty"`
+	SizeEstimate     *SizeEstimate       `json:"sizeEstimate,omitempty"` // With --fast-transfer, the deflated size it traded away
+	OutputBudget     *OutputBudget       `json:"outputBudget,omitempty"` // With --max-output-size, how the IPA measured up
+	Dedupe           *DedupeResult       `json:"dedupe,omitempty"`
+	Spill            *SpillInfo          `json:"spill,omitempty"`     // Files over the RAM budget, written to the spill directory
+	Ownership        []OwnerGroup        `json:"ownership,omitempty"` // Tar owners of the bundle's entries, most common first
+	Memory           *MemoryInfo         `json:"memory,omitempty"`    // Peak memory, counted and sampled
+	Staging          *StagingInfo        `json:"staging,omitempty"`   // The copy from --staging
+	Throughput       *ThroughputInfo     `json:"throughput,omitempty"`
+	Origin           *DebOrigin          `json:"origin,omitempty"`  // As embedded with --embed-origin
+	Options          []ReplayOption      `json:"options,omitempty"` // The flags used, for --options-from
+	Warnings         []Warning           `json:"warnings,omitempty"`
+	TwoPass          bool                `json:"twoPass,omitempty"`    // data.tar was indexed first and only the app extracted
+	Nested           []string            `json:"nestedDebs,omitempty"` // With --recurse-nested, the debs converted instead of the input, outermost first
+	Recipe           string              `json:"recipe,omitempty"`     // The --recipe file applied
+	MTime            *time.Time          `json:"mtime,omitempty"`      // Stamp from --mtime or SOURCE_DATE_EPOCH
+	NotAnApp         *NotAnAppError      `json:"notAnApp,omitempty"`   // What the deb holds instead, when it has no .app (the conversion failed)
+
+	InfoPlist []byte            `json:"-"`                 // Raw main Info.plist, for anything needing more keys
+	Control   map[string]string `json:"control,omitempty"` // Fields from the deb's control file, if present
+}
+
+// stringList is a repeatable string flag
+type stringList []string
+
+func (l *stringList) String() string     { return strings.Join(*l, ",") }
+func (l *stringList) Set(v string) error { *l = append(*l, v); return nil }
+
+// BundleEntry is a file selected for output, with its path relative to the .app root
+type BundleEntry struct {
+	File    *VirtualFile
+	RelPath string // "" for the .app directory itself
+}
+
+// Open returns a reader over the file contents, wherever they were stored. Files on disk
+// are read through fileHandles, which bounds the descriptors open at once.
+func (vf *VirtualFile) Open() (io.ReadCloser, error) {
+	if vf.DiskPath != "" {
+		f, err := fileHandles.open(vf.DiskPath)
+		if err != nil || !vf.Compressed {
+			return f, err
+		}
+		return compressedReader{ReadCloser: flate.NewReader(f), file: f}, nil
+	}
+	return io.NopCloser(bytes.NewReader(vf.Data)), nil
+}
+
+func main() {
+	// Subcommands come first; everything else is a conversion
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "diff":
+			os.Exit(runDiff(os.Args[2:]))
+		case "lint":
+			os.Exit(runLint(os.Args[2:]))
+		case "bench":
+			os.Exit(runBench(os.Args[2:]))
+		case "batch":
+			os.Exit(runBatch(os.Args[2:]))
+		case "doctor":
+			os.Exit(runDoctor(os.Args[2:]))
+		case "verify-manifest":
+			os.Exit(runVerifyManifest(os.Args[2:]))
+		case "fix-perms":
+			os.Exit(runFixPerms(os.Args[2:]))
+		case "mkfixture": // Hidden: synthetic debs for tests and demos
+			os.Exit(runMkFixture(os.Args[2:]))
+		}
+	}
+
+	var opts Options
+	flag.StringVar(&opts.Output, "o", "", "output path (default: next to the deb, .ipa or .zip depending on --layout)")
+	flag.StringVar(&opts.Layout, "layout", LayoutPayload, "archive root: payload (Payload/<App>.app), app (<App>.app) or flat (bundle contents)")
+	flag.StringVar(&opts.Order, "order", OrderTar, "archive entry order: tar (as in the deb) or path (sorted); directories always precede their contents")
+	flag.StringVar(&opts.DirEntries, "dir-entries", DirEntriesAlways, "folder entries in the archive: always, never (the files inside imply them) or auto (only empty folders); folders without one lose their mode")
+	flag.StringVar(&opts.ExtractTo, "extract-to", "", "write the .app bundle to this directory instead of creating an IPA")
+	flag.BoolVar(&opts.NoPayloadDir, "no-payload-dir", false, "with --extract-to, write <App>.app without the Payload folder")
+	flag.BoolVar(&opts.Force, "force", false, "allow --extract-to into a non-empty directory")
+	flag.Var((*stringList)(&opts.Merge), "merge", "overlay another deb (e.g. a tweak) onto the app; repeatable")
+	flag.BoolVar(&opts.AllowPlistOverride, "allow-plist-override", false, "let a --merge deb replace the app's Info.plist")
+	flag.StringVar(&opts.Universal, "universal", "", "merge the main executable and frameworks of this deb, the same app built for other architectures, into universal binaries")
+	flag.BoolVar(&opts.AllowVersionMismatch, "allow-version-mismatch", false, "let --universal merge a deb whose CFBundleVersion differs")
+	flag.BoolVar(&opts.Install, "install", false, "install the IPA on a USB-connected device via ideviceinstaller")
+	flag.StringVar(&opts.UDID, "udid", "", "with --install, the UDID of the target device")
+	flag.StringVar(&opts.AltStoreSource, "altstore-source", "", "add the IPA to this AltStore source JSON file (needs --download-url)")
+	flag.StringVar(&opts.DownloadURL, "download-url", "", "URL the IPA will be served from, for --altstore-source")
+	flag.StringVar(&opts.AltStoreIconURL, "altstore-icon-url", "", "URL the app's icon is served from, for --altstore-source (default: --export-icon's file beside --download-url)")
+	flag.BoolVar(&opts.PrintSourceEntry, "print-source-entry", false, "print the AltStore source app entry as JSON")
+	flag.BoolVar(&opts.ITunesArtwork, "itunes-artwork", false, "add a 512x512 iTunesArtwork generated from the app icon")
+	flag.BoolVar(&opts.BundleLinkedResources, "bundle-linked-resources", false, "replace symlinks from the app into files the deb installs elsewhere (e.g. /Library/<App>/) with copies of them")
+	flag.BoolVar(&opts.KeepContainerMetadata, "keep-container-metadata", false, "for apps dumped from var/containers/Bundle/Application/<UUID>/, put the container's iTunesMetadata.plist at the IPA root")
+	flag.BoolVar(&opts.EmbedOrigin, "embed-origin", true, "write the deb's control fields and SHA256 to <App>.app/"+OriginFileName+" (safe to delete; --embed-origin=false leaves it out)")
+	flag.StringVar(&opts.ExportIcon, "export-icon", "", "write the app icon as a standard PNG to this path")
+	flag.Var((*depictionFlag)(&opts.Depiction), "depiction", "write a Sileo native depiction JSON beside the IPA, or with --depiction=path there")
+	flag.BoolVar(&opts.NormalizePNGs, "normalize-pngs", false, "rewrite Apple-optimized (CgBI) PNGs in the bundle as standard PNGs")
+	flag.Var((*stringList)(&opts.Add), "add", "insert a local file or directory into the bundle, as local/path:Bundle/Relative/Dest; repeatable")
+	flag.BoolVar(&opts.AddOverwrite, "add-overwrite", false, "let --add replace files already in the bundle")
+	flag.StringVar(&opts.BundleID, "bundle-id", "", "set CFBundleIdentifier, e.g. com.example.app")
+	flag.BoolVar(&opts.FixExtensionIDs, "fix-extension-ids", false, "rename app extension bundle IDs to <main ID>.<suffix> when they aren't children of the main app's")
+	flag.BoolVar(&opts.Appex, "appex", false, "convert a deb holding an app extension without its host app; the zip gets Extensions/<Name>.appex")
+	flag.StringVar(&opts.AppPrefix, "app-prefix", "", "the .app folder inside the deb, e.g. Applications/MyApp.app/, instead of the first one found")
+	flag.StringVar(&opts.PayloadDirName, "payload-dir-name", "", "name the .app folder in the archive this (e.g. MyApp.app) instead of what the deb calls it")
+	flag.BoolVar(&opts.SanitizeAppName, "sanitize-app-name", false, "name the .app folder in the archive with ASCII letters, digits, '.', '-' and '_' only, e.g. Foo-2.app for Foo 2.app")
+	flag.StringVar(&opts.Executable, "executable", "", "name of the main binary at the bundle root, overriding Info.plist and detection")
+	opts.MinExecutable = defaultMinExecutableSize
+	flag.Var((*byteSize)(&opts.MinExecutable), "min-executable-size", "warn when the main executable is smaller than this, e.g. 64K; 0 turns the warning off (one too small to be a Mach-O always fails) (default 16K)")
+	flag.BoolVar(&opts.RelocateDylibs, "relocate-dylibs", false, "move .dylib files at the bundle root into Frameworks/, where @rpath finds them")
+	flag.StringVar(&opts.AppVersion, "app-version", "", "set CFBundleShortVersionString, e.g. 1.2.3")
+	flag.StringVar(&opts.BuildNumber, "build-number", "", "set CFBundleVersion, e.g. 457")
+	flag.StringVar(&opts.MinOS, "min-os", "", "set MinimumOSVersion, e.g. 13.0")
+	flag.StringVar(&opts.ProvisioningProfile, "provisioning-profile", "", "embed this .mobileprovision as embedded.mobileprovision (no signing)")
+	flag.StringVar(&opts.Repo, "repo", "", "download --package from this APT repo instead of reading a local deb")
+	flag.StringVar(&opts.Package, "package", "", "with --repo, the package ID to convert")
+	flag.StringVar(&opts.PackageVersion, "package-version", "", "with --repo, fetch this version instead of the newest")
+	flag.Var((*stringList)(&opts.RepoHeaders), "repo-header", "extra request header for --repo, e.g. \"X-Machine: iPhone10,3\"; repeatable")
+	flag.BoolVar(&opts.KeepDeb, "keep-deb", false, "keep the deb downloaded with --repo in the working directory")
+	flag.StringVar(&opts.ExpectSHA256, "expect-sha256", "", "with --repo, fail unless the downloaded deb has this SHA256 (checked as well as the index's)")
+	flag.StringVar(&opts.DownloadCache, "download-cache", "", "with --repo, keep partial downloads here to resume them (default: the user cache directory)")
+	flag.BoolVar(&opts.NoResume, "no-resume", false, "with --repo, download in one go to a temp file instead of the resumable cache")
+	flag.Var((*stringList)(&opts.Exclude), "exclude", "leave out bundle paths matching this glob (** spans directories); repeatable")
+	flag.Var((*stringList)(&opts.ExcludeFrameworks), "exclude-framework", "leave out Frameworks/*.framework folders and .dylib files whose name, with or without the extension, matches this glob (e.g. FLEX, RevealServer); warns when the main executable hard-links one; repeatable")
+	flag.Var((*stringList)(&opts.Include), "include", "keep bundle paths matching this glob even if excluded; repeatable")
+	flag.Var(methodGlobFlag{&opts.MethodGlobs, zip.Store}, "store-glob", "store bundle paths matching this glob uncompressed; the last of --store-glob/--deflate-glob to match wins; repeatable")
+	flag.Var(methodGlobFlag{&opts.MethodGlobs, zip.Deflate}, "deflate-glob", "deflate bundle paths matching this glob, even the main executable; repeatable")
+	opts.StoreMachOMin = defaultStoreMachOMin
+	flag.Var((*byteSize)(&opts.StoreMachOMin), "store-macho-min", "store Mach-O files (framework binaries, dylibs) at least this big uncompressed, e.g. 4M; 0 stores only the main executable (default 1M)")
+	flag.Var(execGlobFlag{&opts.ExecGlobs, true}, "exec-glob", "mark bundle paths matching this glob executable (0755); the last of --exec-glob/--no-exec-glob to match wins; repeatable")
+	flag.Var(execGlobFlag{&opts.ExecGlobs, false}, "no-exec-glob", "mark bundle paths matching this glob not executable (0644), even dylibs and Mach-O files; never the main executable; repeatable")
+	flag.StringVar(&opts.KeepLocalizations, "keep-localizations", "", "drop every .lproj folder except Base and these languages, e.g. en,de")
+	flag.BoolVar(&opts.StripLocalizations, "strip-localizations", false, "drop every .lproj folder except Base and the development region")
+	flag.BoolVar(&opts.StripAppClips, "strip-appclips", false, "leave out the App Clips in AppClips/")
+	flag.BoolVar(&opts.RecurseNested, "recurse-nested", false, "when the deb has no app but carries another deb (an installer's payload), convert that one instead")
+	flag.BoolVar(&opts.StripDebug, "strip-debug", false, "leave out .dSYM folders, and warn about DWARF debug info left in binaries")
+	flag.BoolVar(&opts.StripBitcode, "strip-bitcode", false, "remove embedded bitcode (the __LLVM segment) from every binary; the IPA must be re-signed")
+	flag.BoolVar(&opts.KeepAppleDouble, "keep-appledouble", false, "keep the \"._\" AppleDouble and .DS_Store files macOS adds, instead of leaving them out")
+	flag.BoolVar(&opts.ForceMacOSLayout, "force-macos-layout", false, "convert a macOS app (Contents/MacOS, Contents/Info.plist) instead of refusing it")
+	flag.BoolVar(&opts.ScanJBPaths, "scan-jb-paths", false, "report binaries that reference jailbreak-only paths like /var/jb")
+	flag.Var((*stringList)(&opts.JBPaths), "jb-path", "with --scan-jb-paths, a path prefix to look for instead of the defaults; repeatable")
+	flag.BoolVar(&opts.Lint, "lint", false, "check the written IPA for problems that break installing or launching")
+	flag.BoolVar(&opts.Strict, "strict", false, "fail if the conversion warns about anything, and with --lint on lint warnings too")
+	flag.IntVar(&opts.MaxWarnings, "max-warnings", -1, "fail if the conversion warns more than this many times (-1: no limit)")
+	flag.StringVar(&opts.WarnExit, "warn-exit-level", WarnExitNever, "exit with status 10 when the conversion succeeds with warnings at this severity or above: error, warning or never")
+	flag.Var((*dedupeFlag)(&opts.Dedupe), "dedupe", "report byte-identical files; --dedupe=link also replaces copies with symlinks")
+	flag.BoolVar(&opts.SizeReport, "size-report", false, "print the largest directories, files and categories in the bundle")
+	flag.IntVar(&opts.PathWarnLength, "path-warn-length", defaultPathWarnLength, "warn about archive entry names longer than this many characters (0 disables)")
+	flag.BoolVar(&opts.TwoPass, "two-pass", false, "index data.tar before extracting it, even for debs over 1 GB (smaller ones always are); needs a seekable input")
+	flag.StringVar(&opts.MTime, "mtime", "", "set every entry's timestamp to this time, RFC 3339 or Unix seconds (default: $SOURCE_DATE_EPOCH if set)")
+	flag.BoolVar(&opts.MTimeMax, "mtime-max", false, "with --mtime or SOURCE_DATE_EPOCH, only clamp entries newer than it, keeping older timestamps")
+	flag.BoolVar(&opts.AllowCaseCollisions, "allow-case-collisions", false, "when paths differ only in case (or a file sits where a directory goes), keep one with a warning instead of failing")
+	opts.CompressSpill = SpillCompressAuto
+	flag.Var((*spillCompression)(&opts.CompressSpill), "compress-spill", "compress files spilled to disk: auto (when the spill won't fit in the free space), on or off")
+	flag.Var((*byteSize)(&opts.MaxOutputSize), "max-output-size", "fail a conversion as soon as the IPA passes this size, e.g. 200M, removing the partial file; warns up front when it likely will (default: no limit)")
+	flag.Var((*byteSize)(&opts.MaxSpillSize), "max-spill-size", "fail a conversion that would spill more than this to disk, e.g. 4G (default: no limit)")
+	flag.IntVar(&opts.Limits.MaxEntries, "max-entries", 0, "fail an input with more than this many entries, for untrusted debs (default: no limit)")
+	flag.Var((*byteSize)(&opts.Limits.MaxEntrySize), "max-entry-size", "fail an input with any file bigger than this, e.g. 512M (default: no limit)")
+	flag.BoolVar(&opts.Limits.DenySymlinks, "deny-symlinks", false, "fail an input containing any symlink")
+	flag.BoolVar(&opts.Limits.DenyAbsolute, "deny-absolute-paths", false, "fail an input with entry names starting with /")
+	flag.StringVar(&opts.Staging, "staging", "", "write the IPA to this local folder first, then copy it to the output with verification, resuming a failed copy on the next run")
+	flag.StringVar(&opts.TempDir, "temp-dir", "", "spill files that don't fit in memory here instead of the system temp directory")
+	flag.BoolVar(&opts.WaitLock, "wait-lock", false, "if another conversion is writing the same output, wait for it instead of failing")
+	flag.BoolVar(&opts.Verbose, "verbose", false, "print every adjustment made to the bundle, such as permission fixes")
+	flag.BoolVar(&opts.JSON, "json", false, "print the size report as JSON, alone on stdout; everything else goes to stderr")
+	flag.StringVar(&opts.Report, "report", "", "write a JSON report of the conversion to this path")
+	schemaVersions := flag.Bool("schema-versions", false, "print the schema ID of every JSON document this version writes, then exit")
+	flag.StringVar(&opts.CI, "ci", "", "github: annotate warnings and errors as GitHub Actions workflow commands, with a step summary and outputs (ipa, bundle-id, version, sha256); auto: the same only when GITHUB_ACTIONS is set")
+	flag.StringVar(&opts.IPC, "ipc", "", "send progress, warnings and the result as length-prefixed JSON to a front end listening on this Unix socket (named pipe on Windows), which may send a cancel command")
+	flag.StringVar(&opts.Trace, "trace", "", "append a timestamped line for every entry, decision, edit and archive entry to this file, for bug reports (nothing is redacted)")
+	flag.BoolVar(&opts.ReportIcon, "report-icon", false, "include a base64 icon thumbnail in --report")
+	flag.BoolVar(&opts.Manifest, "manifest", false, "record every entry's size, CRC32 and SHA256 in --report, or in <output>.manifest.json")
+	flag.BoolVar(&opts.FastTransfer, "fast-transfer", false, "store every entry uncompressed and skip optional CPU-heavy steps, for a bigger IPA that's quicker to send and install; prints the deflated size it would have had")
+	flag.BoolVar(&opts.VerifyOutput, "verify-output", false, "read every file of the written IPA back before keeping it, checking its CRC32 (entry modes are always checked)")
+	flag.StringVar(&opts.OptionsFrom, "options-from", "", "apply the options recorded in a previous --report or "+OriginFileName+"; flags given here win")
+	flag.StringVar(&opts.Recipe, "recipe", "", "apply a conversion recipe (JSON or YAML: flag names to values, with an optional match on package or bundle ID); flags given here win, and it wins over --options-from")
+	recorder := recordOptions(flag.CommandLine)
+	flag.Usage = func() {
+		recorder.unwrap(flag.CommandLine)
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: deb-to-ipa [flags] <path-to-deb-file, tarball, .app folder or IPA to repack>")
+		fmt.Fprintln(flag.CommandLine.Output(), "       deb-to-ipa [flags] --repo <url> --package <id>")
+		fmt.Fprintln(flag.CommandLine.Output(), "       deb-to-ipa diff [--json] <old.deb|.ipa> <new.deb|.ipa>")
+		fmt.Fprintln(flag.CommandLine.Output(), "       deb-to-ipa lint [--strict] [--json] <app.ipa>")
+		fmt.Fprintln(flag.CommandLine.Output(), "       deb-to-ipa verify-manifest <app.ipa> <manifest.json>")
+		fmt.Fprintln(flag.CommandLine.Output(), "       deb-to-ipa fix-perms [-v] [-o fixed.ipa] <app.ipa>")
+		fmt.Fprintln(flag.CommandLine.Output(), "       deb-to-ipa doctor [--json] [--redact] [-o file] <input>")
+		fmt.Fprintln(flag.CommandLine.Output(), "       deb-to-ipa bench [-n runs] [--compare baseline.json] <deb> [deb...]")
+		fmt.Fprintln(flag.CommandLine.Output(), "       deb-to-ipa batch [--jobs N] [--out-dir dir] <dir|deb>... [-- conversion flags]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *schemaVersions {
+		printSchemaVersions()
+		os.Exit(0)
+	}
+	if opts.Recipe != "" {
+		recipe, err := loadRecipe(opts.Recipe)
+		if err == nil {
+			err = applyRecipe(flag.CommandLine, recipe)
+		}
+		if err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			os.Exit(1)
+		}
+		opts.RecipeMatch = recipe.Match
+	}
+	if opts.OptionsFrom != "" {
+		if err := replayOptions(flag.CommandLine, opts.OptionsFrom); err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	opts.Replay = recorder.options
+	if opts.FastTransfer {
+		applyFastTransfer(&opts)
+	}
+	if opts.JSON {
+		stdoutForJSON()
+	}
+
+	if flag.NArg() < 1 && opts.Repo == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	debPath := flag.Arg(0)
+	if opts.Layout != LayoutPayload && opts.Layout != LayoutApp && opts.Layout != LayoutFlat {
+		fmt.Printf("❌ Error: unknown --layout %q (want payload, app or flat)\n", opts.Layout)
+		os.Exit(1)
+	}
+	if opts.Appex {
+		// An extension isn't an IPA: installers, lint and AltStore all expect Payload/<App>.app
+		if opts.Lint || opts.Install || opts.AltStoreSource != "" || opts.PrintSourceEntry || opts.ITunesArtwork || opts.KeepContainerMetadata {
+			fmt.Println("❌ Error: --appex makes a bare extension archive, not an IPA; --lint, --install, --altstore-source, --print-source-entry, --itunes-artwork and --keep-container-metadata don't apply")
+			os.Exit(1)
+		}
+		if opts.Layout == LayoutPayload {
+			opts.Layout = LayoutExtension
+		}
+	}
+	if err := checkFrameworkPatterns(opts.ExcludeFrameworks); err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
+		os.Exit(1)
+	}
+	if strings.ContainsAny(opts.Executable, "/\\") {
+		fmt.Println("❌ Error: --executable takes a file name at the bundle root, not a path")
+		os.Exit(1)
+	}
+	if opts.PayloadDirName != "" && opts.SanitizeAppName {
+		fmt.Println("❌ Error: --payload-dir-name and --sanitize-app-name both name the .app folder; use one")
+		os.Exit(1)
+	}
+	if opts.PayloadDirName != "" {
+		wantExt := BundleExtApp
+		if opts.Appex {
+			wantExt = BundleExtAppex
+		}
+		if err := checkPayloadDirName(opts.PayloadDirName, wantExt); err != nil {
+			fmt.Printf("❌ Error: --payload-dir-name: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if opts.WarnExit != WarnExitNever && opts.WarnExit != WarnExitWarning && opts.WarnExit != WarnExitError {
+		fmt.Printf("❌ Error: unknown --warn-exit-level %q (want error, warning or never)\n", opts.WarnExit)
+		os.Exit(1)
+	}
+	if opts.Order != OrderTar && opts.Order != OrderPath {
+		fmt.Printf("❌ Error: unknown --order %q (want tar or path)\n", opts.Order)
+		os.Exit(1)
+	}
+	if opts.DirEntries != DirEntriesAlways && opts.DirEntries != DirEntriesNever && opts.DirEntries != DirEntriesAuto {
+		fmt.Printf("❌ Error: unknown --dir-entries %q (want always, never or auto)\n", opts.DirEntries)
+		os.Exit(1)
+	}
+	if opts.DirEntries != DirEntriesAlways && opts.ExtractTo != "" {
+		fmt.Println("❌ Error: --dir-entries shapes an archive and cannot be combined with --extract-to")
+		os.Exit(1)
+	}
+	if opts.Lint && opts.ExtractTo != "" {
+		fmt.Println("❌ Error: --lint checks an IPA and cannot be combined with --extract-to")
+		os.Exit(1)
+	}
+	if opts.Manifest && opts.ExtractTo != "" {
+		fmt.Println("❌ Error: --manifest describes an IPA and cannot be combined with --extract-to")
+		os.Exit(1)
+	}
+	if opts.MaxOutputSize > 0 && opts.ExtractTo != "" {
+		fmt.Println("❌ Error: --max-output-size limits an IPA and cannot be combined with --extract-to")
+		os.Exit(1)
+	}
+	if opts.Install && opts.ExtractTo != "" {
+		fmt.Println("❌ Error: --install needs an IPA and cannot be combined with --extract-to")
+		os.Exit(1)
+	}
+	if (opts.AltStoreSource != "" || opts.PrintSourceEntry) && (opts.DownloadURL == "" || opts.ExtractTo != "") {
+		fmt.Println("❌ Error: --altstore-source and --print-source-entry need --download-url and an IPA output")
+		os.Exit(1)
+	}
+	if (opts.AltStoreSource != "" || opts.PrintSourceEntry) && opts.AltStoreIconURL == "" && opts.ExportIcon == "" {
+		fmt.Println("❌ Error: --altstore-source and --print-source-entry need --altstore-icon-url, or --export-icon to serve the icon beside the IPA")
+		os.Exit(1)
+	}
+	if (opts.Repo == "") != (opts.Package == "") {
+		fmt.Println("❌ Error: --repo and --package go together")
+		os.Exit(1)
+	}
+	if err := validateVersionFlags(opts); err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if opts.Trace != "" {
+		var err error
+		if trace, err = openTrace(opts.Trace); err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if opts.IPC != "" {
+		var err error
+		if ipc, err = openIPC(opts.IPC); err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if c, err := newCIOutput(opts.CI, os.Stdout, os.Getenv); err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
+		os.Exit(1)
+	} else {
+		ci = c
+	}
+
+	fmt.Println("📱 DebToIPA")
+	fmt.Println("------------------------------------------")
+
+	start := time.Now()
+	input := debPath // For the RESULT line
+
+	// --- APT Repo: fetch the deb first ---
+	var download *downloadedDeb
+	if opts.Repo != "" {
+		var err error
+		input = opts.Package
+		if download, err = downloadPackage(opts); err != nil {
+			fmt.Printf("\n❌ Error: %v\n", err)
+			exitFailed(ResultCodeDownload, err, time.Since(start))
+		}
+		debPath = download.Path
+		if !opts.KeepDeb {
+			// Name the output after the package, in the working directory, not the cache file
+			opts.Output = outputPathFor(download.Name, opts)
+		}
+	}
+
+	// Matches Swift: ContentView.swift -> convert(url:)
+	result, err := convert(debPath, opts)
+	if err != nil {
+		fmt.Printf("\n❌ Error: %s\n", terminalSafe(err.Error()))
+	}
+	if download != nil && !opts.KeepDeb {
+		download.discard(err == nil)
+	}
+	if err != nil {
+		// A report still tells wrappers what a deb without an app holds, or how far over
+		// the spill quota it went
+		var notApp *NotAnAppError
+		var quota *SpillQuotaError
+		var budget *OutputBudgetError
+		var failed *Result
+		switch {
+		case errors.As(err, &notApp):
+			failed = &Result{Status: ResultStatusError, NotAnApp: notApp}
+		case errors.As(err, &quota):
+			failed = &Result{Status: ResultStatusError, Spill: quota.info()}
+		case errors.As(err, &budget):
+			failed = &Result{Status: ResultStatusError, OutputBudget: budget.info()}
+		}
+		if failed != nil && opts.Report != "" {
+			if err := writeReport(opts.Report, failed); err != nil {
+				fmt.Printf("\n❌ Report: %v\n", err)
+			}
+		}
+		// Matches Swift: ConversionError handling
+		exitFailed(conversionErrorCode(err), err, time.Since(start))
+	}
+
+	if opts.ExtractTo != "" {
+		fmt.Printf("\n✅ Successfully extracted app in %s!\n", time.Since(start).Round(time.Second))
+	} else if opts.Appex {
+		fmt.Printf("\n✅ Successfully converted to an extension archive in %s!\n", time.Since(start).Round(time.Second))
+	} else {
+		fmt.Printf("\n✅ Successfully converted to IPA in %s!\n", time.Since(start).Round(time.Second))
+	}
+
+	printWarnings(result.Warnings)
+	ci.annotate(result.Warnings)
+	warningFailure := warningsExceeded(result.Warnings, opts.MaxWarnings, opts.Strict)
+	warnExit := warnExitTriggers(result.Warnings, opts.WarnExit)
+	result.Status = ResultStatusOK
+	if len(warnExit) > 0 {
+		result.Status = ResultStatusWarnings
+	}
+
+	lintFailure := false
+	if opts.Lint {
+		fmt.Println("\n🔎 Linting IPA...")
+		if result.Lint, err = lintIPA(result.OutputPath); err != nil {
+			fmt.Printf("\n❌ Lint: %v\n", err)
+			exitFailed(ResultCodeLint, err, time.Since(start))
+		}
+		result.Lint = append(result.Lint, jbPathFindings(result.JBPaths)...)
+		printLint(result.Lint)
+		lintFailure = lintFailed(result.Lint, opts.Strict)
+	}
+
+	if opts.Report != "" {
+		if err := writeReport(opts.Report, result); err != nil {
+			fmt.Printf("\n❌ Report: %v\n", err)
+			exitFailed(ResultCodeReport, err, time.Since(start))
+		}
+	}
+
+	if lintFailure {
+		fmt.Println("\n❌ Lint failed")
+		exitFailed(ResultCodeLint, errors.New("lint failed"), time.Since(start))
+	}
+	if warningFailure {
+		failure := fmt.Errorf("%d warnings, more than --max-warnings %d", len(result.Warnings), opts.MaxWarnings)
+		if opts.Strict {
+			failure = fmt.Errorf("%d warning(s) with --strict", len(result.Warnings))
+		}
+		fmt.Printf("\n❌ %v\n", failure)
+		exitFailed(ResultCodeWarnings, failure, time.Since(start))
+	}
+
+	if opts.AltStoreSource != "" || opts.PrintSourceEntry {
+		if err := publishAltStoreEntry(result, opts); err != nil {
+			fmt.Printf("\n❌ AltStore source: %v\n", err)
+			exitFailed(ResultCodeAltStore, err, time.Since(start))
+		}
+	}
+
+	if opts.Depiction != "" {
+		if err := writeDepiction(result, opts); err != nil {
+			fmt.Printf("\n❌ Depiction: %v\n", err)
+			exitFailed(ResultCodeDepiction, err, time.Since(start))
+		}
+	}
+
+	if opts.Install {
+		if err := installIPA(result.OutputPath, opts.UDID); err != nil {
+			fmt.Printf("\n❌ Install failed: %v\n", err)
+			fmt.Printf("   The IPA was kept at %s\n", result.OutputPath)
+			exitFailed(ResultCodeInstall, err, time.Since(start))
+		}
+	}
+
+	if len(warnExit) > 0 {
+		printWarnExit(warnExit, opts.WarnExit)
+	}
+	if err := ci.finish(input, result); err != nil {
+		fmt.Printf("\n❌ CI: %v\n", err)
+		exitFailed(ResultCodeCI, err, time.Since(start))
+	}
+	printResultOK(input, valueOr(result.OutputPath, opts.ExtractTo), result, warnExit, time.Since(start))
+	trace.close(result.Status, nil, time.Since(start))
+	ipc.close(IPCEvent{Status: valueOr(result.Status, ResultStatusOK), Result: result})
+	if len(warnExit) > 0 {
+		os.Exit(exitWarnings)
+	}
+}
+
+// outputPathFor returns where the archive for a deb is written: -o if given, otherwise
+// next to the deb or tarball with the extension swapped (.zip for layouts that aren't an
+// IPA). A repacked IPA gets ".fixed" added, so the input isn't overwritten.
+func outputPathFor(debPath string, opts Options) string {
+	if opts.Output != "" {
+		return opts.Output
+	}
+	ext := ".ipa"
+	if opts.Layout != LayoutPayload {
+		ext = ".zip"
+	}
+	return packageBasePath(debPath) + ext
+}
+
+// zipEntryName places a bundle-relative path in the archive according to the layout.
+// It returns "" for entries that don't exist in that layout (the bundle root when flat).
+func zipEntryName(layout, appNameFolder, relPath string) string {
+	switch layout {
+	case LayoutApp:
+		// "MyApp.app/Info.plist"
+		return path.Join(appNameFolder, relPath)
+	case LayoutFlat:
+		// "Info.plist"
+		return relPath
+	case LayoutExtension:
+		// "Extensions/MyExt.appex/Info.plist"
+		return path.Join("Extensions", appNameFolder, relPath)
+	default:
+		// Construct Payload path: "Payload/MyApp.app/Info.plist"
+		return path.Join("Payload", appNameFolder, relPath)
+	}
+}
+
+func convert(debPath string, opts Options) (*Result, error) {
+	bundleExt := BundleExtApp
+	if opts.Appex {
+		bundleExt = BundleExtAppex
+	}
+	appPrefix, err := normalizeAppPrefix(opts.AppPrefix, bundleExt)
+	if err != nil {
+		return nil, err
+	}
+	stamp, stamped, err := resolveMTime(opts.MTime)
+	if err != nil {
+		return nil, err
+	}
+	if opts.MTimeMax && !stamped {
+		return nil, fmt.Errorf("--mtime-max needs --mtime or SOURCE_DATE_EPOCH")
+	}
+
+	// Check the extract target up front rather than after minutes of work
+	if opts.ExtractTo != "" {
+		if err := prepareExtractDir(opts.ExtractTo, opts.Force); err != nil {
+			return nil, err
+		}
+	} else {
+		// A read-only, full or missing target should fail now, not after the conversion
+		if err := probeOutputDir(outputPathFor(debPath, opts)); err != nil {
+			return nil, err
+		}
+		// Two conversions racing to one output would interleave their writes
+		lock, err := lockOutput(outputPathFor(debPath, opts), opts.WaitLock)
+		if err != nil {
+			return nil, err
+		}
+		defer lock.release()
+	}
+
+	var staged *stagedCopy
+	if opts.Staging != "" && opts.ExtractTo == "" {
+		if staged, err = newStagedCopy(opts.Staging, debPath, outputPathFor(debPath, opts)); err != nil {
+			return nil, err
+		}
+		if staged.resumable() {
+			return resumeStagedCopy(staged, opts)
+		}
+		if err := checkStagingDir(staged); err != nil {
+			return nil, err
+		}
+	}
+
+	// Matches Swift: cleanup() logic (via defer)
+	outputDir := opts.ExtractTo
+	if outputDir == "" {
+		outputDir = filepath.Dir(outputPathFor(debPath, opts))
+	}
+	var warnings warningLog
+	tempDir, err := makeSpillDir(opts.TempDir, outputDir, &warnings)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tempDir) // This handles the "Clean after running" toggle logic
+
+	store := &SpillStore{Dir: tempDir, Quota: opts.MaxSpillSize, Compress: opts.CompressSpill}
+	sampler := startMemorySampler()
+	defer sampler.finish()
+	store.flow.start()
+	defer store.flow.finish()
+
+	filter, err := newPathFilter(opts.Include, opts.Exclude)
+	if err != nil {
+		return nil, err
+	}
+	methods, err := newMethodOverrides(opts.MethodGlobs, opts.StoreMachOMin)
+	if err != nil {
+		return nil, err
+	}
+	execs, err := newExecPolicy(opts.ExecGlobs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Matches Swift: DebToIPA.swift -> extractDeb() -> Reading .deb
+	fmt.Println("=> [1/5] Opening Deb Archive...")
+	ro := readOptions{Filter: filter, TwoPass: useTwoPass(debPath, opts.TwoPass), AppPrefix: appPrefix, BundleExt: bundleExt, KeepOutside: opts.BundleLinkedResources, Nested: opts.RecurseNested, Limits: opts.Limits, Verbose: opts.Verbose}
+	deb, err := readDeb(debPath, store, ro)
+	if err != nil {
+		return nil, err
+	}
+	var nested []string
+	if opts.RecurseNested {
+		if deb, nested, err = readNestedDebs(deb, tempDir, store, ro, &warnings); err != nil {
+			return nil, err
+		}
+	}
+	opts.Clock.mark("read")
+	trace.stage("read")
+	trace.event("metadata", "step", "app-folder", "prefix", deb.AppDirPrefix, "two-pass", fmt.Sprint(deb.Plan != nil))
+	store.flow.phase(nil, "")
+	files := deb.Files
+	appDirPrefix := deb.AppDirPrefix
+	infoPlistData := deb.InfoPlistData
+
+	// Matches Swift: ConversionError.unsupportedApp, saying what the deb is instead
+	if appDirPrefix == "" {
+		return nil, notAnApp(deb, bundleExt)
+	}
+	printDecoys(appDirPrefix, deb.Decoys)
+	if deb.Repack {
+		printRepackOutside(deb, &warnings)
+	}
+	checkPrefixStragglers(deb, &warnings)
+
+	cleanAppPrefix := filepath.ToSlash(appDirPrefix) // e.g. "Applications/MyApp.app/"
+	appNameFolder := deb.appFolderName()             // "MyApp.app"
+	if deb.Launcher != "" {
+		fmt.Printf("   %s is installed outside Applications/; converting it as %s, its launcher's name there\n", terminalSafe(cleanAppPrefix), terminalSafe(deb.Launcher))
+	}
+	if safe := safeFileName(appNameFolder); safe != appNameFolder {
+		// It names the folder in the IPA and, with --extract-to, one on disk
+		warnings.add("app-folder-sanitized", "", "The app folder %s has control characters or backslashes in its name; writing it as %s", displayMetadataValue(appNameFolder), safe)
+		appNameFolder = safe
+	}
+	if name := versionedBundleName(appNameFolder, bundleExt); name != "" {
+		// iOS only takes a Payload folder ending in the bundle extension
+		warnings.add("app-folder-version", "", "The app folder %s has a version after %s; writing it as %s", appNameFolder, bundleExt, name)
+		appNameFolder = name
+	}
+
+	// Apps dumped from a device sit in a container folder named by UUID. Only the .app
+	// is converted; the container's iTunesMetadata.plist can come along to the IPA root.
+	var containerMeta *VirtualFile
+	if dir, uuid, ok := containerDir(cleanAppPrefix); ok {
+		fmt.Printf("   Bundle container %s\n", uuid)
+		if opts.KeepContainerMetadata {
+			if containerMeta = containerMetadata(files, dir); containerMeta == nil {
+				warnings.add("container-metadata", "", "No iTunesMetadata.plist in the container, nothing to keep")
+			}
+		}
+	} else if opts.KeepContainerMetadata {
+		warnings.add("container-metadata", "", "--keep-container-metadata: the app isn't in a var/containers bundle folder")
+	}
+
+	entries, err := selectBundleEntries(files, cleanAppPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	// --- macOS Bundles: Contents/MacOS makes an IPA iOS refuses to install ---
+	if macOSLayout(entries) {
+		if infoPlistData == nil {
+			infoPlistData = macOSInfoPlistData(entries)
+		}
+		if !opts.ForceMacOSLayout {
+			return nil, macOSLayoutError(appNameFolder, infoPlistData)
+		}
+		warnings.add("macos-layout", "", "%s is laid out as a macOS app; converted anyway (--force-macos-layout), but iOS won't install it", appNameFolder)
+	}
+
+	// --- Linked Resources: symlinks from the bundle into the rest of the deb ---
+	var linked []LinkedResource
+	entries, linked = bundleLinkedResources(entries, deb, cleanAppPrefix, opts.BundleLinkedResources)
+	printLinkedResources(linked, &warnings)
+
+	// --- Tweak Bundling: overlay extra debs on top of the base app ---
+	var tweaks []TweakDylib
+	if len(opts.Merge) > 0 {
+		var plistOverride []byte
+		entries, plistOverride, tweaks, err = mergeDebs(entries, appNameFolder, opts, store, filter, &warnings)
+		if err != nil {
+			return nil, err
+		}
+		if plistOverride != nil {
+			infoPlistData = plistOverride
+		}
+	}
+	filter.printSummary(&warnings)
+
+	// --- AppleDouble: "._" files and xattrs from debs packed on a Mac ---
+	var appleDouble *AppleDoubleResult
+	entries, appleDouble = stripAppleDouble(entries, deb.Xattrs, opts.KeepAppleDouble)
+	printAppleDouble(appleDouble, &warnings)
+
+	// --- Extra Files: --add local:dest ---
+	var added []string
+	if len(opts.Add) > 0 {
+		var plistOverride []byte
+		entries, added, plistOverride, err = addFiles(entries, opts)
+		if err != nil {
+			return nil, err
+		}
+		if plistOverride != nil {
+			infoPlistData = plistOverride
+		}
+	}
+
+	// --- Metadata Parsing (Matches Swift: SavedIpa struct logic) ---
+	fmt.Println("=> [4/5] Parsing App Metadata...")
+
+	originalBundleID := plistValue(infoPlistData, "CFBundleIdentifier")
+	if opts.Recipe != "" && !opts.RecipeMatch.matches(deb.Control["Package"], originalBundleID) {
+		warnings.add("recipe-mismatch", "", "--recipe %s is for %s, not this package (%s)", opts.Recipe, opts.RecipeMatch, valueOr(deb.Control["Package"], originalBundleID))
+	}
+	originalPlist := infoPlistData // --universal compares builds before --build-number
+	var versionOverrides []VersionOverride
+	infoPlistData, versionOverrides, err = applyVersionOverrides(entries, infoPlistData, opts, store)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyContainerOverrides(containerMeta, opts, store); err != nil {
+		return nil, err
+	}
+
+	plistExecutable, bundleID, version := parseAppMetadata(infoPlistData)
+	plistExecutable, bundleID, version, metadataFixes := sanitizeAppMetadata(plistExecutable, bundleID, version)
+
+	// Fallback: when Info.plist fails us, find the binary among the bundle's Mach-O files
+	executableName, executableSource, err := resolveExecutable(entries, appNameFolder, plistExecutable, opts.Executable, &warnings)
+	if err != nil {
+		return nil, err
+	}
+	// A placeholder binary makes an IPA that installs and never launches; stop before zipping
+	if err := checkExecutableSize(entries, executableName, opts.MinExecutable, &warnings); err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("   Name: %s\n   ID:   %s\n   Ver:  %s\n   Exec: %s (%s)\n",
+		appNameFolder, bundleID, version, executableName, executableSource)
+	trace.event("metadata", "step", "identity", "app", appNameFolder, "bundle-id", bundleID, "version", version, "executable", executableName, "executable-source", executableSource)
+
+	// The archive's folder can be named apart from the deb's; the executable is matched by
+	// its own name, so nothing else changes
+	originalAppName := ""
+	payloadDirName := opts.PayloadDirName
+	if opts.SanitizeAppName {
+		payloadDirName = sanitizedAppName(appNameFolder, bundleExt)
+	}
+	if payloadDirName != "" && payloadDirName != appNameFolder {
+		originalAppName, appNameFolder = appNameFolder, payloadDirName
+		printPayloadDirRename(originalAppName, appNameFolder, infoPlistData, &warnings)
+	}
+	fmt.Printf("   Entries: %d (~%s of metadata in memory)\n", store.Entries, formatBytes(store.MetaUsage))
+	printVersionOverrides(versionOverrides)
+	printMetadataFixes(metadataFixes, &warnings)
+
+	// Merged tweaks are loaded by the executable, as nothing on a stock device loads them
+	if entries, err = injectTweaks(entries, tweaks, executableName, bundleID, store, &warnings); err != nil {
+		return nil, err
+	}
+
+	// Known-bad libraries go first, so nothing below merges, strips or checks them
+	var excludedFrameworks []ExcludedFramework
+	entries, excludedFrameworks = excludeFrameworks(entries, opts.ExcludeFrameworks, executableName)
+	printExcludedFrameworks(excludedFrameworks, opts.ExcludeFrameworks, executableName, &warnings)
+
+	// --- Universal: slices of the same app's other-architecture deb ---
+	var universal *UniversalResult
+	if opts.Universal != "" {
+		if entries, universal, err = mergeUniversal(entries, executableName, originalPlist, opts, store, &warnings); err != nil {
+			return nil, err
+		}
+		printUniversal(universal, &warnings)
+	}
+
+	// Bitcode goes first, so the checks below read the binaries as they'll ship
+	var bitcode []BitcodeStrip
+	if opts.StripBitcode {
+		if bitcode, err = stripBitcode(entries, store); err != nil {
+			return nil, err
+		}
+		printBitcodeStrips(bitcode, &warnings)
+	}
+
+	// The binary's load commands are the real minimum OS, whatever Info.plist says
+	minOS := plistValue(infoPlistData, "MinimumOSVersion")
+	slices := executableSlices(entries, executableName)
+	binaryMinOS := checkMinimumOS(slices, minOS, &warnings)
+	checkEncryption(slices, executableName, &warnings)
+
+	// installd rejects extensions whose IDs aren't children of the app's, common after --bundle-id
+	extensions, err := checkExtensionIDs(entries, bundleID, originalBundleID, opts.FixExtensionIDs, store)
+	if err != nil {
+		return nil, err
+	}
+	printExtensionIDs(extensions, bundleID, &warnings)
+
+	// App Clips stay nested like extensions, with IDs installd checks the same way
+	appClips, err := findAppClips(entries, bundleID)
+	if err != nil {
+		return nil, err
+	}
+	if opts.StripAppClips {
+		entries = stripAppClips(entries, appClips)
+	}
+	printAppClips(appClips, bundleID, &warnings)
+
+	// dyld won't look at the bundle root for @rpath dylibs unless an LC_RPATH says so
+	var rootDylibs []RootDylib
+	entries, rootDylibs = checkRootDylibs(entries, executableName, opts.RelocateDylibs, &warnings)
+	printRootDylibs(rootDylibs, entries, &warnings)
+
+	result := &Result{
+		AppName:         appNameFolder,
+		OriginalAppName: originalAppName,
+		BundleID:        bundleID,
+		Version:         version,
+		Executable:      executableName,
+		InfoPlist:       infoPlistData,
+		Control:         deb.Control,
+		Options:         opts.Replay,
+		Added:           added,
+
+		ExecutableSource: executableSource,
+
+		VersionOverrides: versionOverrides,
+		MetadataFixes:    metadataFixes,
+		MinOS:            minOS,
+		BinaryMinOS:      binaryMinOS,
+		Slices:           slices,
+		Extensions:       extensions,
+		AppClips:         appClips,
+		RootDylibs:       rootDylibs,
+		LinkedResources:  linked,
+		Excluded:         excludedFrameworks,
+		Universal:        universal,
+		Bitcode:          bitcode,
+		AppleDouble:      appleDouble,
+		Entries:          store.Entries,
+		TwoPass:          deb.Plan != nil,
+		Nested:           nested,
+		Recipe:           opts.Recipe,
+		MetadataBytes:    store.MetaUsage,
+	}
+	if result.Ownership = buildOwnership(entries, &store.owners, executableName, &warnings); result.Ownership != nil {
+		printOwnership(result.Ownership, opts.Verbose)
+	}
+
+	// --- Provisioning Profile: ready the bundle for signing downstream ---
+	if opts.ProvisioningProfile != "" {
+		if entries, result.Profile, err = embedProvisioningProfile(entries, opts, bundleID, &warnings); err != nil {
+			return nil, err
+		}
+	}
+
+	// --- Localization Stripping ---
+	if keep := localizationsToKeep(opts, infoPlistData); keep != nil {
+		entries, result.Localizations = stripLocalizations(entries, keep)
+	}
+
+	// --- Debug Info: .dSYM folders always, binaries when someone is counting ---
+	if result.Debug = findDebugContent(entries, opts.StripDebug || opts.SizeReport); result.Debug != nil {
+		if opts.StripDebug {
+			entries = stripDebug(entries, result.Debug)
+		}
+		printDebugContent(result.Debug, opts.StripDebug, &warnings)
+	}
+
+	icons := newIconSource(entries, infoPlistData, &warnings)
+	validateIconCatalog(icons)
+
+	if opts.ExportIcon != "" || opts.Report != "" {
+		result.Icon = inspectIcon(icons, opts)
+	}
+
+	if opts.NormalizePNGs {
+		result.NormalizedPNGs = normalizePNGs(entries, store, &warnings)
+	}
+
+	if opts.Dedupe != "" {
+		if result.Dedupe, err = dedupeEntries(entries, opts.Dedupe, executableName); err != nil {
+			return nil, err
+		}
+		printDedupe(result.Dedupe)
+	}
+
+	if opts.ScanJBPaths {
+		if result.JBPaths, err = scanJBPaths(entries, opts.JBPaths); err != nil {
+			return nil, err
+		}
+		printJBPaths(result.JBPaths, &warnings)
+	}
+
+	// --- Origin: the package this app came from, for tracing the IPA later ---
+	if opts.EmbedOrigin {
+		if result.Origin, err = newDebOrigin(debPath, deb.Control); err != nil {
+			return nil, err
+		}
+		result.Origin.Options = opts.Replay
+		if entries, err = embedOrigin(entries, result.Origin); err != nil {
+			return nil, err
+		}
+	}
+
+	if n := markAppClipExecutables(entries, appClips, opts.Verbose) + launderModes(entries, appNameFolder, executableName, execs, opts.Verbose); n > 0 && !opts.Verbose {
+		fmt.Printf("   Fixed permissions on %d entr%s (--verbose lists them)\n", n, map[bool]string{true: "y", false: "ies"}[n == 1])
+	}
+	execs.printSummary(&warnings)
+
+	result.LongPaths = findLongPaths(entries, opts.Layout, appNameFolder, opts.PathWarnLength)
+	warnLongPaths(result.LongPaths, &warnings)
+
+	// Names that only differ in case extract as one file on macOS and Windows
+	if entries, result.PathCollisions, err = checkPathCollisions(entries, opts.Layout, appNameFolder, opts.AllowCaseCollisions, &warnings); err != nil {
+		return nil, err
+	}
+
+	// --- Timestamps: one fixed stamp, for reproducible archives ---
+	if stamped {
+		result.MTime = &stamp
+		n := stampEntries(entries, stamp, opts.MTimeMax)
+		if containerMeta != nil && (!opts.MTimeMax || containerMeta.ModTime.After(stamp)) {
+			containerMeta.ModTime = stamp
+		}
+		verb := "Set"
+		if opts.MTimeMax {
+			verb = "Clamped"
+		}
+		fmt.Printf("   %s %d timestamp(s) to %s\n", verb, n, stamp.Format(time.RFC3339))
+	}
+
+	entries = orderEntries(entries, opts.Order)
+	result.Spill = store.info()
+	printSpill(result.Spill)
+	opts.Clock.mark("process")
+	trace.stage("process")
 
-// VirtualFile acts as the bridge between the extracted tar and the final zip
-type VirtualFile struct {
-	Name     string
-	Data     []byte
-	DiskPath string
-	Mode     int64
-	ModTime  time.Time
-	IsDir    bool
-	IsLink   bool
-	LinkDest string
-}
+	if opts.ExtractTo != "" {
+		if containerMeta != nil {
+			warnings.add("container-metadata", "", "iTunesMetadata.plist only belongs in an IPA, not kept with --extract-to")
+		}
+		if err := extractApp(opts, entries, appNameFolder, executableName, execs, &warnings); err != nil {
+			return nil, err
+		}
+		trace.stage("extract")
+		if opts.SizeReport {
+			if result.SizeReport, err = buildSizeReport(entries, executableName, "", opts.Layout, appNameFolder, result.Debug); err != nil {
+				return nil, err
+			}
+			printSizeReport(result.SizeReport, opts.JSON)
+		}
+		result.Memory = store.memory(sampler.finish())
+		printMemory(result.Memory)
+		result.Throughput = store.flow.finish()
+		printThroughput(result.Throughput)
+		result.Warnings = warnings.list
+		return result, nil
+	}
+
+	// --- IPA Construction (Matches Swift: Create .ipa archive) ---
+	ipaPath := outputPathFor(debPath, opts)
+	result.OutputPath = ipaPath
+	fmt.Println("=> [5/5] Zipping Payload...")
+
+	budget := newOutputBudget(opts.MaxOutputSize, entries)
+	stored := func(entry BundleEntry) bool {
+		return opts.FastTransfer || entry.RelPath == executableName || methods.storesMachO(entry.File)
+	}
+	if err := budget.precheck(stored, opts.Layout, appNameFolder, &warnings); err != nil {
+		return nil, err
+	}
+
+	// Written under a temporary name and renamed into place, so a failed or interrupted
+	// run never leaves a truncated IPA at the output path
+	partialDir := filepath.Dir(ipaPath)
+	if staged != nil {
+		partialDir = opts.Staging
+	}
+	ipaFile, err := os.CreateTemp(partialDir, "."+filepath.Base(ipaPath)+".*.partial")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(ipaFile.Name())
+	defer ipaFile.Close()
+
+	zipWriter := zip.NewWriter(budget.writer(store.flow.writer(flowZip, ipaFile)))
+	defer zipWriter.Close()
+
+	// Sized from the entries actually written, now that filtering and edits are final
+	bar := newProgressBar(bundleBytes(entries), "Writing IPA")
+	store.flow.phase(bar, "Writing IPA", flowZip)
+
+	if opts.Manifest {
+		result.Manifest = &Manifest{Output: ipaPath}
+	}
+	modeCheck := newArchiveModeCheck()
+	omitted := omittedDirs(entries, opts.DirEntries, &warnings)
+
+	for _, entry := range entries {
+		vf := entry.File
+
+		finalPath := zipEntryName(opts.Layout, appNameFolder, entry.RelPath)
+		if finalPath == "" {
+			continue
+		}
+
+		if vf.IsDir {
+			finalPath += "/"
+		}
+
+		header := &zip.FileHeader{
+			Name:     finalPath,
+			Method:   zip.Deflate,
+			Modified: vf.ModTime,
+		}
+
+		perms, unixFileType, storeEntry := entryPermissions(vf, path.Join(appNameFolder, entry.RelPath), executableName, execs)
+		if omitted[entry.RelPath] && vf.IsDir {
+			if opts.Verbose {
+				fmt.Printf("   %-7s %04o %s (--dir-entries %s: no entry, so the mode is lost)\n", "omitted", perms, terminalSafe(finalPath), opts.DirEntries)
+			}
+			continue
+		}
+		budget.entry(finalPath)
+		if storeEntry {
+			header.Method = zip.Store
+		}
+		why := ""
+		if !vf.IsDir && !vf.IsLink {
+			if !storeEntry && methods.storesMachO(vf) {
+				header.Method = zip.Store
+				why = fmt.Sprintf(" (Mach-O of %s, --store-macho-min %s)", formatBytes(vf.Size), formatBytes(methods.storeMachOMin))
+			}
+			if method, rule := methods.method(entry.RelPath); rule != nil {
+				header.Method = method
+				why = fmt.Sprintf(" (--%s %q)", rule.Flag, rule.Pattern)
+			}
+		}
+		if opts.Verbose && !vf.IsDir {
+			fmt.Printf("   %-7s %04o %s%s\n", methodName(header.Method), perms, terminalSafe(finalPath), why)
+		}
+		switch {
+		case vf.IsLink:
+			header.SetMode(os.ModeSymlink | perms)
+		case vf.IsDir:
+			header.SetMode(os.ModeDir | perms)
+		default:
+			header.SetMode(perms) // SetMode for regular files just takes perms
+		}
+
+		// **THE FIX**: Set the Unix External Attribute (mode << 16)
+		// This tells iOS/ldid that this file is a link/dir/executable.
+		header.ExternalAttrs = (unixFileType | uint32(perms)) << 16
+		modeCheck.expect(finalPath, vf, entry.RelPath == executableName)
+
+		if vf.IsDir {
+			if _, err := zipWriter.CreateHeader(header); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		// The manifest's SHA256 rides along on the same pass that compresses the file
+		progress := io.Writer(bar)
+		var hasher hash.Hash
+		if result.Manifest != nil {
+			hasher = sha256.New()
+			progress = io.MultiWriter(bar, hasher)
+		}
+		if err := writeZipFile(zipWriter, header, vf, tempDir, progress); err != nil {
+			return nil, fmt.Errorf("writing %s: %w", finalPath, err)
+		}
+		if hasher != nil {
+			if vf.IsLink {
+				hasher.Write([]byte(vf.LinkDest))
+			}
+			result.Manifest.add(entry.RelPath, header, hasher.Sum(nil))
+		}
+	}
+
+	methods.printSummary(&warnings)
+	budget.entry("")
+
+	if containerMeta != nil {
+		if opts.Layout != LayoutPayload {
+			warnings.add("container-metadata", "", "iTunesMetadata.plist only belongs in an IPA (--layout payload), left it out")
+		} else if err := writeContainerMetadata(zipWriter, containerMeta, result.Manifest); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.ITunesArtwork {
+		artworkTime := time.Now()
+		if stamped {
+			artworkTime = stamp
+		}
+		if icon := icons.appIcon(); icon == nil {
+			warnings.add("itunes-artwork", "", "No app icon found, left out iTunesArtwork")
+		} else if err := writeITunesArtwork(zipWriter, icon, artworkTime, result.Manifest); err != nil {
+			warnings.add("itunes-artwork", icon.Entry.RelPath, "Could not create iTunesArtwork: %v", err)
+		}
+	}
+
+	// Close explicitly so the IPA is complete for anything that reads it afterwards
+	if err := zipWriter.Close(); err != nil {
+		return nil, err
+	}
+	store.flow.phase(nil, "")
+	result.OutputBudget = budget.finish()
+	printOutputBudget(result.OutputBudget)
+	if err := syncFile(ipaFile); err != nil {
+		return nil, err
+	}
+	if err := ipaFile.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(ipaFile.Name(), 0644); err != nil {
+		return nil, err
+	}
+	if err := modeCheck.verify(ipaFile.Name(), opts.VerifyOutput); err != nil {
+		return nil, err
+	}
+	trace.archive(ipaFile.Name())
+	if opts.VerifyOutput {
+		fmt.Println("   Verified: every entry read back with a matching CRC32 (--verify-output)")
+	}
+	if staged != nil {
+		if err := staged.stage(ipaFile.Name(), result.Manifest); err != nil {
+			return nil, fmt.Errorf("--staging: %w", err)
+		}
+		if result.Staging, err = staged.deliver(false); err != nil {
+			return nil, err
+		}
+		printStaging(result.Staging)
+	} else if err := moveIntoPlace(ipaFile.Name(), ipaPath); err != nil {
+		return nil, err
+	}
+	if result.Manifest != nil && opts.Report == "" {
+		if err := writeManifest(manifestPathFor(ipaPath), result.Manifest); err != nil {
+			return nil, err
+		}
+		fmt.Printf("\n   Manifest: %s (%d entries)\n", manifestPathFor(ipaPath), len(result.Manifest.Entries))
+	}
+	opts.Clock.mark("write")
+	trace.stage("write")
+	opts.Clock.spilled(store.SpillCount)
+
+	if opts.FastTransfer {
+		info, err := os.Stat(ipaPath)
+		if err != nil {
+			return nil, err
+		}
+		if result.SizeEstimate, err = estimateDeflated(entries, info.Size()); err != nil {
+			return nil, fmt.Errorf("estimating the deflated size: %w", err)
+		}
+		printSizeEstimate(result.SizeEstimate)
+	}
+
+	if opts.SizeReport {
+		if result.SizeReport, err = buildSizeReport(entries, executableName, ipaPath, opts.Layout, appNameFolder, result.Debug); err != nil {
+			return nil, err
+		}
+		printSizeReport(result.SizeReport, opts.JSON)
+	}
 
-// Plist structures for parsing Info.plist (Matches Swift's Info.plist reading)
-type Plist struct {
-	Dict PlistDict `xml:"dict"`
+	result.Memory = store.memory(sampler.finish())
+	printMemory(result.Memory)
+	result.Throughput = store.flow.finish()
+	printThroughput(result.Throughput)
+	result.Warnings = warnings.list
+	return result, nil
 }
-type PlistDict struct {
-	Keys   []string `xml:"key"`
-	String []string `xml:"string"`
+
+// SpillStore tracks RAM usage and the files spilled to disk, shared by every deb in a conversion.
+// Spill files are numbered, never named after entries, so long or hostile names can't collide.
+type SpillStore struct {
+	Dir             string
+	RamUsage        int64 // File data plus MetaUsage
+	PeakRam         int64 // Highest RamUsage reached
+	Window          int64 // Largest decompressor dictionary, held back from the budget
+	SpillCount      int
+	SpillBytes      int64  // Written to spill files, which are never shrunk
+	SpillRaw        int64  // What SpillBytes held before compression
+	Quota           int64  // Most SpillBytes may reach, from --max-spill-size; 0 for no limit
+	Compress        string // --compress-spill: SpillCompressAuto, On or Off
+	ExpectedSpill   int64  // Estimated file data to spill, for auto compression; 0 when not known
+	SpillCompressed int    // Spill files written compressed
+	Entries         int    // Entries read from every deb
+	MetaUsage       int64  // Estimated memory held by the entries themselves (see track)
+	names           nameArena
+	owners          ownerTable
+	flow            throughputMeter // Bytes in, decompressed, spilled and zipped
 }
 
-func main() {
-	if len(os.Args) < 2 {
-		fmt.Println("Usage: deb-to-ipa <path-to-deb-file>")
-		os.Exit(1)
+// Replace swaps a file's contents, keeping it in RAM when it fits and spilling it otherwise
+func (s *SpillStore) Replace(vf *VirtualFile, data []byte) error {
+	size := int64(len(data))
+	if vf.DiskPath == "" {
+		s.charge(-int64(len(vf.Data)))
 	}
 
-	debPath := os.Args[1]
-	fmt.Println("📱 DebToIPA")
-	fmt.Println("------------------------------------------")
+	vf.Size = size
+	if storage, _ := s.policy(false).Decide(StorageEntry{Name: vf.Name, Size: size, HasData: true}); storage == StoreRAM {
+		vf.Data = data
+		vf.DiskPath = ""
+		vf.Compressed = false
+		s.charge(size)
+		return nil
+	}
+	return s.spill(vf, bytes.NewReader(data))
+}
 
-	start := time.Now()
+// DebContents is everything gathered from a deb's data.tar
+type DebContents struct {
+	Files         []*VirtualFile
+	AppDirPrefix  string // e.g. "Applications/MyApp.app/"
+	Launcher      string // Set when AppDirPrefix is a launcher symlink's target: its name, e.g. "MyApp.app"
+	InfoPlistData []byte // To parse BundleID/ExecName
+	Control       map[string]string
+	Plan          *debPlan         // The indexing pass's decisions, when read in two passes
+	Outside       []indexEntry     // Headers of the entries left out of Files for being outside the app
+	Xattrs        map[string]int64 // Entries in Files with PAX xattr records, by name: their resource fork's size
+	PlainTar      bool             // Read from a tarball without the deb wrapper, so without Control
+	Repack        bool             // Read from an IPA or other zip, also without Control
+	Decoys        []string         // Bundle folders passed over as not apps (see bundlerank.go)
+}
 
-	// Matches Swift: ContentView.swift -> convert(url:)
-	err := convert(debPath)
-	if err != nil {
-		fmt.Printf("\n❌ Error: %v\n", err)
-		// Matches Swift: ConversionError handling
-		os.Exit(1)
-	}
+// readOptions tunes readDeb. The zero value reads everything, printing the stages.
+type readOptions struct {
+	Quiet     bool        // No stage output, for debs read alongside the main one
+	Filter    *PathFilter // Entries inside the app it excludes are skipped without being buffered; may be nil
+	TwoPass   bool        // Index data.tar first, then extract only what planDeb keeps
+	AppPrefix string      // The app folder, from normalizeAppPrefix, instead of detecting it
+	BundleExt string      // Extension of the bundle folder to look for; "" for BundleExtApp
 
-	fmt.Printf("\n✅ Successfully converted to IPA in %s!\n", time.Since(start).Round(time.Second))
+	KeepOutside bool        // Extract files outside the app even when it's known up front
+	Nested      bool        // With no app found, extract the debs data.tar carries (see nested.go)
+	Limits      InputLimits // Stop at the first entry past these; see limits.go
+	Verbose     bool        // Log where entries go when it isn't RAM within budget; see storage.go
 }
 
-func convert(debPath string) error {
-	// Matches Swift: DebToIPA.swift -> extractDeb() -> Reading .deb
-	fmt.Println("=> [1/5] Opening Deb Archive...")
+// bundleExt is the extension of the bundle folder the read is after
+func (ro readOptions) bundleExt() string {
+	return valueOr(ro.BundleExt, BundleExtApp)
+}
+
+// readDeb opens a deb (or a tarball, a bundle folder or an IPA: see readAppDir and
+// readIPA), decompresses its data.tar and extracts it to RAM/Spillover. When the app
+// folder is known before extracting (two passes, or --app-prefix), files outside it are
+// skipped and left out of Files, except a bundle container's iTunesMetadata.plist.
+func readDeb(debPath string, store *SpillStore, ro readOptions) (*DebContents, error) {
+	if isDirectory(debPath) {
+		return readAppDir(debPath, store, ro)
+	}
 	debFile, err := os.Open(debPath)
 	if err != nil {
-		return fmt.Errorf("no permission or file not found: %w", err)
+		return nil, fmt.Errorf("no permission or file not found: %w", err)
 	}
 	defer debFile.Close()
-
-	arReader, err := ar.NewReader(debFile)
-	if err != nil {
-		return fmt.Errorf("invalid deb archive: %w", err)
+	if isZipArchive(debFile) {
+		return readIPA(debFile, store, ro)
 	}
 
-	// Matches Swift: "data.tar" detection loop
+	deb := &DebContents{}
 	var dataTar io.Reader
 	foundData := false
 
-	for {
+	// A plain tarball, e.g. a device's filesystem exported as is, is data.tar without the ar
+	// wrapper or control.tar around it
+	if name, ok := plainTarName(debFile); ok {
+		info, err := debFile.Stat()
+		if err != nil {
+			return nil, err
+		}
+		deb.PlainTar = true
+		foundData = true
+		member := arMember{Name: name, Size: info.Size()}
+		if dataTar, err = openDataTar(member, "a plain "+strings.TrimPrefix(name, "data.")+" archive", debFile, nil, deb, store, ro); err != nil {
+			return nil, err
+		}
+	}
+
+	// The ar reader doesn't buffer, so the bytes it consumed are the current member's offset
+	position := &countingReader{r: debFile}
+	var arReader *ar.Reader
+	var pendingAr *ar.Reader // A pipe's ar stream, carried on with once data.tar is extracted
+	fileSize := int64(-1)    // Unknown for pipes, whose members go unchecked
+	if !deb.PlainTar {
+		if arReader, err = ar.NewReader(position); err != nil {
+			return nil, fmt.Errorf("invalid deb archive: %w", err)
+		}
+		if info, err := debFile.Stat(); err == nil && info.Mode().IsRegular() {
+			fileSize = info.Size()
+		}
+	}
+
+	// Matches Swift: "data.tar" detection loop. Every member is visited, in whatever order
+	// the deb has them: control.tar usually precedes data.tar, but hand-built debs put it
+	// last, and each member may be compressed its own way.
+	for arReader != nil {
 		header, err := arReader.Next()
-		if err == io.EOF {
-			break
+		if errors.Is(err, io.EOF) {
+			break // The ar reader wraps it when the deb ends after the last member
 		}
 		if err != nil {
-			return err
+			return nil, err
+		}
+		member := arMember{Name: header.Name, Offset: position.n, Size: header.Size}
+		if err := member.checkSize(fileSize); err != nil {
+			return nil, err
 		}
+		trace.arMember(member, debFile)
 
-		if strings.HasPrefix(header.Name, "data.tar") {
+		// control.tar is tiny: parse it on the way past, before data.tar or after it
+		if strings.HasPrefix(header.Name, "control.tar") {
+			deb.readControlMember(header.Name, arReader)
+			continue
+		}
+
+		if strings.HasPrefix(header.Name, "data.tar") && !foundData {
 			foundData = true
-			fmt.Printf("=> [2/5] Found %s. Decompressing...\n", header.Name)
-
-			// Matches Swift: DecompressionMethod switch (lzma, gz, bzip2, xz)
-			switch {
-			case strings.HasSuffix(header.Name, ".gz"):
-				dataTar, err = gzip.NewReader(arReader)
-			case strings.HasSuffix(header.Name, ".lzma"):
-				dataTar, err = lzma.NewReader(arReader)
-			case strings.HasSuffix(header.Name, ".bzip2"):
-				dataTar = bzip2.NewReader(arReader)
-			case strings.HasSuffix(header.Name, ".xz"):
-				dataTar, err = xz.NewReader(arReader)
-			default:
-				// Matches Swift: ConversionError.unsupportedCompression
-				return fmt.Errorf("unsupported compression method: %s", header.Name)
+			// A pipe can't be read at an offset: its data.tar streams straight from the ar
+			// reader, and the members after it wait until it has been extracted
+			if fileSize < 0 {
+				if dataTar, err = openDataTar(member, header.Name, debFile, arReader, deb, store, ro); err != nil {
+					return nil, err
+				}
+				pendingAr = arReader
+				break
 			}
-			if err != nil {
-				return fmt.Errorf("decompression failed: %w", err)
+			if dataTar, err = openDataTar(member, header.Name, debFile, nil, deb, store, ro); err != nil {
+				return nil, err
+			}
+			// data.tar is read through its own reader; carry on past it to the members
+			// after it without reading it a second time
+			if arReader, err = resumeArAfter(debFile, member, fileSize, position); err != nil {
+				return nil, err
 			}
-			break
 		}
 	}
 
 	// Matches Swift: ConversionError.noDataFound
 	if !foundData {
-		return fmt.Errorf("data.tar not found in deb")
+		return nil, fmt.Errorf("data.tar not found in deb")
 	}
 
 	// --- Extraction Logic ---
@@ -132,256 +1560,391 @@ func convert(debPath string) error {
 	// to perform the same logic but faster and cross-platform.
 
 	tarReader := tar.NewReader(dataTar)
+	body := io.Reader(tarReader)
+	plan := deb.Plan
 
-	// Matches Swift: cleanup() logic (via defer)
-	tempDir, err := os.MkdirTemp("", "ipa-spill")
-	if err != nil {
-		return err
+	if !ro.Quiet && plan == nil {
+		fmt.Print("=> [3/5] Extracting and Analyzing Files... ")
+		if ro.Verbose {
+			fmt.Println() // Storage decisions are logged a line each
+		}
+	}
+	if plan != nil {
+		// The index knows what's coming, so the bytes to extract make a real progress bar
+		deb.AppDirPrefix, deb.Launcher = plan.AppDirPrefix, plan.Launcher
+		if !ro.Quiet {
+			fmt.Println("=> [3/5] Extracting App Files...")
+			bar := newProgressBar(plan.KeptBytes, "Extracting")
+			defer bar.Finish()
+			body = io.TeeReader(tarReader, bar)
+			store.flow.phase(bar, "Extracting", flowDebIn, flowTarOut, flowSpill)
+		}
+	} else if !ro.Quiet {
+		store.flow.phase(nil, "Extracting", flowDebIn, flowTarOut, flowSpill)
 	}
-	defer os.RemoveAll(tempDir) // This handles the "Clean after running" toggle logic
-
-	var files []*VirtualFile
-	var currentRamUsage int64 = 0
-	var totalSize int64 = 0
-
-	// State for app detection
-	var appDirPrefix string
-	var infoPlistData []byte // To parse BundleID/ExecName
 
-	fmt.Print("=> [3/5] Extracting and Analyzing Files... ")
+	// With --app-prefix the app folder is known before reading, so nothing outside it
+	// needs buffering either
+	containerMeta := ""
+	seen := make(appPrefixesSeen)
+	matched := false
+	if plan == nil && ro.AppPrefix != "" {
+		deb.AppDirPrefix = ro.AppPrefix
+		if dir, _, ok := containerDir(ro.AppPrefix); ok {
+			containerMeta = dir + "iTunesMetadata.plist"
+		}
+	}
 
 	fileCount := 0
-	spillCount := 0
+	entryIndex := -1
+	limiter := newEntryLimiter(ro.Limits)
+	policy := store.policy(ro.Verbose)
+	var candidates *bundleCandidates // Ranked once everything is read, without a plan or --app-prefix
+	if plan == nil && ro.AppPrefix == "" {
+		candidates = newBundleCandidates(ro.bundleExt())
+	}
 
 	for {
-		header, err := tarReader.Next()
+		header, err := nextTarEntry(tarReader, limiter)
 		if err == io.EOF {
 			break
 		}
+		var limit *LimitError
+		if errors.As(err, &limit) {
+			return nil, err
+		}
 		if err != nil {
-			return fmt.Errorf("tar read error: %w", err)
+			return nil, fmt.Errorf("tar read error: %w", err)
+		}
+
+		entryIndex++
+		if plan != nil && entryIndex > plan.last {
+			break // Nothing the conversion needs comes later; don't decompress the rest
+		}
+
+		entry := StorageEntry{Name: header.Name, Type: header.Typeflag, Size: header.Size, HasData: header.Typeflag == tar.TypeReg || header.Typeflag == tar.TypeGNUSparse}
+		entry.Unplanned = plan != nil && !plan.keep[entryIndex]
+		if plan == nil && ro.AppPrefix != "" {
+			seen.note(header.Name, ro.bundleExt())
+			if inAppPrefix(header.Name, ro.AppPrefix) {
+				matched = true
+			} else {
+				entry.Outside = header.Name != containerMeta && !ro.KeepOutside
+			}
+		}
+		// --exclude: drop the entry (and with it, the work of reading its data). Until the
+		// app folder is chosen, each bundle folder's entries are filtered as the app's, their
+		// matches counted once it's known which folder that is.
+		if plan == nil && ro.Filter != nil && !entry.Outside {
+			isDir := header.Typeflag == tar.TypeDir
+			if deb.AppDirPrefix != "" {
+				entry.Excluded = inAppPrefix(header.Name, deb.AppDirPrefix) && ro.Filter.Excluded(appRelPath(header.Name, deb.AppDirPrefix), isDir)
+			} else if prefix := bundlePrefix(header.Name, ro.bundleExt()); prefix != "" {
+				entry.Excluded = inAppPrefix(header.Name, prefix) && ro.Filter.ExcludedIn(prefix, appRelPath(header.Name, prefix), isDir)
+			}
+		}
+		storage := policy.decide(entry)
+		if storage == StoreSkip {
+			if entry.Outside {
+				deb.Outside = append(deb.Outside, indexEntry{Name: header.Name, Size: header.Size, Type: header.Typeflag, Link: header.Linkname})
+			}
+			continue
 		}
 
 		fileCount++
-		if fileCount%100 == 0 {
+		if fileCount%100 == 0 && !ro.Quiet && stdoutRewrites && plan == nil {
 			fmt.Printf("\r=> [3/5] Analyzing Files... (%d scanned)", fileCount)
 		}
 
-		// Matches Swift: Checking for "Applications/" folder structure
-		// We also support root-level .app (common in tweaked debs)
-		if appDirPrefix == "" {
-			if idx := strings.Index(header.Name, ".app/"); idx != -1 {
-				// Capture "Applications/MyApp.app/" or "./MyApp.app/"
-				appDirPrefix = header.Name[:idx+5]
+		if fork, ok := paxXattrs(header.PAXRecords); ok {
+			if deb.Xattrs == nil {
+				deb.Xattrs = make(map[string]int64)
 			}
+			deb.Xattrs[header.Name] = fork
 		}
 
 		vFile := &VirtualFile{
 			Name:    header.Name,
 			Mode:    header.Mode,
-			// **FIXED HERE**: Removed the "Size" field
+			Size:    header.Size,
 			ModTime: header.ModTime,
 			IsDir:   header.Typeflag == tar.TypeDir,
+			Owner:   store.owners.intern(Owner{User: header.Uname, Group: header.Gname, UID: tarOwnerID(header.Uid, header.PAXRecords, "uid"), GID: tarOwnerID(header.Gid, header.PAXRecords, "gid")}),
 		}
 
 		if header.Typeflag == tar.TypeSymlink {
 			// Matches Swift: entry.info.type == .symbolicLink
 			vFile.IsLink = true
 			vFile.LinkDest = header.Linkname
-			files = append(files, vFile)
-		} else if header.Typeflag == tar.TypeReg {
-			// Matches Swift: entry.info.type == .regular
-			totalSize += header.Size
+			candidates.noteLink(header.Name, header.Linkname)
+			store.track(vFile)
+			deb.Files = append(deb.Files, vFile)
+		} else if header.Typeflag == tar.TypeReg || header.Typeflag == tar.TypeGNUSparse {
+			// Matches Swift: entry.info.type == .regular. archive/tar expands sparse files
+			// (old GNU and PAX formats) to their full size as we read them.
 
-			// RAM vs Disk decision
+			// RAM vs Disk decision (see storage.go)
 			var data []byte
-			if currentRamUsage+header.Size < MaxMemoryUsage {
-				data, err = io.ReadAll(tarReader)
-				if err != nil {
-					return err
+			if storage == StoreRAM {
+				// Sized up front: io.ReadAll would grow the buffer up to twice the file
+				data = make([]byte, header.Size)
+				if _, err = io.ReadFull(body, data); err != nil {
+					return nil, err
 				}
 				vFile.Data = data
-				currentRamUsage += int64(len(data))
+				store.charge(int64(len(data)))
 			} else {
 				// Spill to disk (simulating Swift's extract to tempDir)
-				spillCount++
-				tempPath := filepath.Join(tempDir, fmt.Sprintf("spill_%d", spillCount))
-				f, err := os.Create(tempPath)
-				if err != nil {
-					return err
+				if err = store.spill(vFile, body); err != nil {
+					return nil, err
 				}
-				_, err = io.Copy(f, tarReader)
-				f.Close()
-				vFile.DiskPath = tempPath
 			}
 
-			// Capture Info.plist for parsing (Matches Swift's logic to read Plist)
-			if strings.HasSuffix(header.Name, "Info.plist") && len(data) > 0 {
-				infoPlistData = data
+			// Capture Info.plist for parsing (Matches Swift's logic to read Plist). Only the
+			// app's own: PlugIns/*.appex and frameworks carry Info.plists too.
+			if deb.AppDirPrefix != "" && header.Name == deb.AppDirPrefix+"Info.plist" && len(data) > 0 {
+				deb.InfoPlistData = data
 			}
 
-			files = append(files, vFile)
+			if err := candidates.noteFile(vFile); err != nil {
+				return nil, err
+			}
+			store.track(vFile)
+			deb.Files = append(deb.Files, vFile)
 		} else if header.Typeflag == tar.TypeDir {
 			// Matches Swift: entry.info.type == .directory
-			files = append(files, vFile)
+			candidates.note(header.Name, nil)
+			store.track(vFile)
+			deb.Files = append(deb.Files, vFile)
 		}
 	}
-	fmt.Println()
-
-	// Matches Swift: ConversionError.unsupportedApp
-	if appDirPrefix == "" {
-		return fmt.Errorf("unsupported app: could not find .app directory inside deb")
-	}
-
-	// --- Metadata Parsing (Matches Swift: SavedIpa struct logic) ---
-	fmt.Println("=> [4/5] Parsing App Metadata...")
-
-	executableName := ""
-	bundleID := "Unknown"
-	version := "Unknown"
-
-	if len(infoPlistData) > 0 {
-		var plist Plist
-		if err := xml.Unmarshal(infoPlistData, &plist); err == nil {
-			// Iterate keys to find values
-			for i, key := range plist.Dict.Keys {
-				if i >= len(plist.Dict.String) {
-					break
-				}
-
-				if key == "CFBundleExecutable" {
-					executableName = plist.Dict.String[i]
-				}
-				if key == "CFBundleIdentifier" {
-					bundleID = plist.Dict.String[i]
-				}
-				if key == "CFBundleVersion" || key == "CFBundleShortVersionString" {
-					version = plist.Dict.String[i]
-				}
+	if candidates != nil {
+		// Matches Swift: Checking for "Applications/" folder structure, though ranked
+		// rather than first come (see bundlerank.go); root-level .app is common in tweaked debs
+		deb.AppDirPrefix, deb.Decoys = candidates.choose()
+		deb.Launcher = candidates.launcher(deb.AppDirPrefix)
+		ro.Filter.settle(deb.AppDirPrefix)
+		for _, vf := range deb.Files {
+			if deb.AppDirPrefix != "" && vf.Name == deb.AppDirPrefix+"Info.plist" && len(vf.Data) > 0 {
+				deb.InfoPlistData = vf.Data
 			}
 		}
 	}
-
-	// Fallback: guess executable name from folder name if Plist failed
-	cleanAppPrefix := filepath.ToSlash(appDirPrefix) // e.g. "./Applications/MyApp.app/"
-	appNameFolder := path.Base(cleanAppPrefix)       // "MyApp.app"
-	if executableName == "" {
-		executableName = strings.TrimSuffix(appNameFolder, ".app")
+	if !ro.Quiet && plan == nil {
+		fmt.Println()
+	}
+	// The members a pipe has after data.tar: control.tar, in hand-built debs. Next skips
+	// whatever of data.tar the extraction left unread.
+	for pendingAr != nil {
+		header, err := pendingAr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(header.Name, "control.tar") {
+			deb.readControlMember(header.Name, pendingAr)
+		}
+	}
+	if plan == nil && ro.AppPrefix != "" && !matched {
+		return nil, unmatchedAppPrefix(ro.AppPrefix, ro.bundleExt(), seen)
 	}
 
-	fmt.Printf("   Name: %s\n   ID:   %s\n   Ver:  %s\n   Exec: %s\n",
-		appNameFolder, bundleID, version, executableName)
-
-	// --- IPA Construction (Matches Swift: Create .ipa archive) ---
-	ipaPath := strings.TrimSuffix(debPath, ".deb") + ".ipa"
-	fmt.Println("=> [5/5] Zipping Payload...")
+	return deb, nil
+}
 
-	ipaFile, err := os.Create(ipaPath)
+// openDataTar returns a decompressing reader over the data.tar member, announced as what.
+// The member is read from f at its offset, or straight through from stream when that's set,
+// for a pipe, which can't be read twice or at an offset. With ro.TwoPass (never for a pipe:
+// see useTwoPass) it indexes the member first and sets deb.Plan. The decompressor's
+// dictionary is held back from the store's budget before anything is read into it.
+func openDataTar(member arMember, what string, f io.ReaderAt, stream io.Reader, deb *DebContents, store *SpillStore, ro readOptions) (io.Reader, error) {
+	var data io.Reader
+	if stream != nil {
+		buffered := bufio.NewReader(stream)
+		head, _ := buffered.Peek(64)
+		store.reserveWindow(decompressorWindow(member.Name, bytes.NewReader(head)))
+		data, ro.TwoPass = buffered, false
+	} else {
+		store.reserveWindow(decompressorWindow(member.Name, member.open(f)))
+		data = member.open(f)
+	}
+	if ro.TwoPass {
+		if !ro.Quiet {
+			fmt.Printf("=> [2/5] Found %s. Indexing...\n", what)
+		}
+		var candidates *bundleCandidates
+		if ro.AppPrefix == "" {
+			candidates = newBundleCandidates(ro.bundleExt())
+		}
+		index, err := readTarIndex(member, f, ro.Limits, candidates)
+		if err != nil {
+			return nil, err
+		}
+		if deb.Plan, err = planDeb(index, candidates, ro); err != nil {
+			return nil, err
+		}
+		deb.Outside, deb.Decoys = deb.Plan.Outside, deb.Plan.Decoys
+		// What won't fit in the budget left is what --compress-spill=auto plans for
+		store.ExpectedSpill += max(deb.Plan.KeptBytes-(store.budget()-store.RamUsage), 0)
+		if !ro.Quiet {
+			fmt.Printf("   Indexed %d entries: extracting %d (%s), skipping %s outside the app or excluded\n",
+				deb.Plan.Entries, deb.Plan.Kept, formatBytes(deb.Plan.KeptBytes), formatBytes(deb.Plan.SkippedBytes))
+		}
+		// The member is read again from the top, for real this time
+	} else if !ro.Quiet {
+		fmt.Printf("=> [2/5] Found %s. Decompressing...\n", what)
+	}
+	dataTar, err := decompress(member.Name, store.flow.reader(flowDebIn, data))
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("decompression failed: %w", err)
 	}
-	defer ipaFile.Close()
+	return store.flow.reader(flowTarOut, dataTar), nil
+}
 
-	zipWriter := zip.NewWriter(ipaFile)
-	defer zipWriter.Close()
+// readControlMember parses a control.tar member into deb.Control
+func (deb *DebContents) readControlMember(name string, r io.Reader) {
+	if control, err := readControl(name, r); err == nil {
+		deb.Control = control
+	}
+}
 
-	bar := progressbar.DefaultBytes(totalSize, "Writing IPA")
+// decompress wraps an ar member in the decompressor matching its extension
+func decompress(name string, r io.Reader) (io.Reader, error) {
+	trace.event("decompressor", "member", name, "method", strings.TrimPrefix(path.Ext(name), "."))
+	// Matches Swift: DecompressionMethod switch (lzma, gz, bzip2, xz)
+	switch {
+	case strings.HasSuffix(name, ".gz"):
+		return gzip.NewReader(r)
+	case strings.HasSuffix(name, ".lzma"):
+		return lzma.NewReader(r)
+	case strings.HasSuffix(name, ".bzip2"), strings.HasSuffix(name, ".bz2"):
+		return bzip2.NewReader(r), nil
+	case strings.HasSuffix(name, ".xz"):
+		return xz.NewReader(r)
+	case strings.HasSuffix(name, ".tar"):
+		return r, nil // Uncompressed, which dpkg allows too
+	default:
+		// Matches Swift: ConversionError.unsupportedCompression
+		return nil, fmt.Errorf("unsupported compression method: %s", name)
+	}
+}
 
-	for _, vf := range files {
-		cleanName := filepath.ToSlash(vf.Name)
+// parseAppMetadata reads the executable name, bundle ID and version from Info.plist data.
+// Missing values come back as "" for the executable and "Unknown" for the rest.
+func parseAppMetadata(infoPlistData []byte) (executableName, bundleID, version string) {
+	bundleID = "Unknown"
+	version = "Unknown"
 
-		// Filter: Only process files inside the detected .app folder
-		if !strings.HasPrefix(cleanName, cleanAppPrefix) {
-			continue
+	// Matches Swift: Info.plist reading, by key rather than by position so nested
+	// dicts and arrays (CFBundleIcons, UIDeviceFamily...) don't misalign values
+	executableName = plistValue(infoPlistData, "CFBundleExecutable")
+	if id := plistValue(infoPlistData, "CFBundleIdentifier"); id != "" {
+		bundleID = id
+	}
+	for _, key := range []string{"CFBundleShortVersionString", "CFBundleVersion"} {
+		if v := plistValue(infoPlistData, key); v != "" {
+			version = v
+			break
 		}
+	}
 
-		// Logic: Relativize path.
-		// "Applications/MyApp.app/Info.plist" -> "Info.plist"
-		relPath := strings.TrimPrefix(cleanName, cleanAppPrefix)
-
-		// Construct Payload path: "Payload/MyApp.app/Info.plist"
-		finalPath := path.Join("Payload", appNameFolder, relPath)
+	return executableName, bundleID, version
+}
 
-		if vf.IsDir {
-			finalPath += "/"
+// tarEntryName drops the leading "./" and "/" archivers put on tar entry names, and the
+// empty and "." segments inside them, so "./Applications/X.app/Info.plist" and
+// "Applications//X.app/./Info.plist" compare equal to "Applications/X.app/Info.plist".
+// ".." segments are kept for selectBundleEntries to refuse, as is a directory's trailing "/".
+func tarEntryName(name string) string {
+	if !strings.Contains(name, "/") && name != "." {
+		return name
+	}
+	segments := strings.Split(name, "/")
+	kept := segments[:0]
+	for _, segment := range segments {
+		if segment != "" && segment != "." {
+			kept = append(kept, segment)
 		}
+	}
+	cleaned := strings.Join(kept, "/")
+	if cleaned != "" && strings.HasSuffix(name, "/") {
+		cleaned += "/"
+	}
+	return cleaned
+}
 
-		header := &zip.FileHeader{
-			Name:     finalPath,
-			Method:   zip.Deflate,
-			Modified: vf.ModTime,
+// bundleBytes is the data the progress bars count: regular files only, directories and
+// symlinks having none to copy
+func bundleBytes(entries []BundleEntry) int64 {
+	var total int64
+	for _, entry := range entries {
+		if !entry.File.IsDir && !entry.File.IsLink {
+			total += entry.File.Size
 		}
+	}
+	return total
+}
 
-		// --- PERMISSION FIXES (Crucial for Ldid/TrollStore) ---
-		// This is the new, correct logic that mimics 7-Zip and the Swift Zip library.
-
-		// Get the 9-bit permission (e.g., 0755, 0644) from the tar header
-		perms := os.FileMode(vf.Mode) & 0777
-		var unixFileType uint32
-
-		// 1. Handle Symlinks
-		if vf.IsLink {
-			header.Method = zip.Store
-			unixFileType = 0xA000 // S_IFLNK (Symbolic Link)
-			perms = 0777         // Symlinks are typically 777
-			header.SetMode(os.ModeSymlink | perms)
-
-			// 2. Handle Directories
-		} else if vf.IsDir {
-			header.Method = zip.Store
-			unixFileType = 0x4000 // S_IFDIR (Directory)
-			if perms == 0 {
-				perms = 0755
-			} // Ensure dirs are at least 0755
-			header.SetMode(os.ModeDir | perms)
+// selectBundleEntries filters files down to those inside the detected .app folder
+// and relativizes their paths: "Applications/MyApp.app/Info.plist" -> "Info.plist"
+func selectBundleEntries(files []*VirtualFile, cleanAppPrefix string) ([]BundleEntry, error) {
+	var entries []BundleEntry
+	appRoot := strings.TrimSuffix(cleanAppPrefix, "/")
 
-			// 3. Handle Regular Files
-		} else {
-			unixFileType = 0x8000 // S_IFREG (Regular File)
+	for _, vf := range files {
+		cleanName := filepath.ToSlash(vf.Name)
 
-			// Check if this file is the Main Binary
-			isMainBinary := false
-			if path.Base(finalPath) == executableName {
-				isMainBinary = true
-			}
+		// The .app directory entry itself has no trailing slash in some tars
+		if vf.IsDir && cleanName == appRoot {
+			cleanName = cleanAppPrefix
+		}
 
-			// 3a. Force Executable Permissions
-			// The .deb might have 0644. iOS NEEDS 0755 for the binary.
-			if isMainBinary || strings.HasSuffix(finalPath, ".dylib") || strings.Contains(finalPath, "/bin/") {
-				perms = 0755 // rwxr-xr-x
-			} else if perms == 0 {
-				perms = 0644 // Default for non-exec files
-			}
+		// Filter: Only process files inside the detected .app folder
+		if !strings.HasPrefix(cleanName, cleanAppPrefix) {
+			continue
+		}
 
-			// 3b. Optimization: Store binary uncompressed
-			if isMainBinary {
-				header.Method = zip.Store
-			}
+		relPath := strings.TrimSuffix(strings.TrimPrefix(cleanName, cleanAppPrefix), "/")
 
-			header.SetMode(perms) // SetMode for regular files just takes perms
+		// Path traversal protection: nothing may climb out of the bundle
+		if relPath != "" && !isLocalPath(relPath) {
+			return nil, fmt.Errorf("refusing entry escaping the app bundle: %s", vf.Name)
 		}
 
-		// **THE FIX**: Set the Unix External Attribute (mode << 16)
-		// This tells iOS/ldid that this file is a link/dir/executable.
-		header.ExternalAttrs = (unixFileType | uint32(perms)) << 16
+		entries = append(entries, BundleEntry{File: vf, RelPath: relPath})
+	}
 
-		w, err := zipWriter.CreateHeader(header)
-		if err != nil {
-			return err
-		}
+	return entries, nil
+}
 
-		if vf.IsLink {
-			w.Write([]byte(vf.LinkDest))
-		} else if !vf.IsDir {
-			if vf.DiskPath != "" {
-				f, _ := os.Open(vf.DiskPath)
-				io.Copy(io.MultiWriter(w, bar), f)
-				f.Close()
-			} else {
-				io.Copy(io.MultiWriter(w, bar), bytes.NewReader(vf.Data))
-			}
-		}
+// isLocalPath reports whether a slash-separated path stays below its root
+func isLocalPath(p string) bool {
+	if path.IsAbs(p) || strings.Contains(p, "\\") {
+		return false
 	}
+	clean := path.Clean(p)
+	return clean != ".." && !strings.HasPrefix(clean, "../")
+}
 
-	return nil
+// entryPermissions decides the mode and unix file type of a bundle entry, and whether it's
+// stored for being the main executable (other Mach-O files are stored by size instead).
+// name is the path including the app folder, e.g. "MyApp.app/Frameworks/Foo.dylib".
+//
+// --- PERMISSION FIXES (Crucial for Ldid/TrollStore) ---
+// This mimics 7-Zip and the Swift Zip library.
+func entryPermissions(vf *VirtualFile, name, executableName string, execs *ExecPolicy) (perms os.FileMode, unixFileType uint32, store bool) {
+	// launderModes has normally run already; this keeps any entry it missed safe too
+	isMainBinary := !vf.IsDir && !vf.IsLink && isMainExecutable(name, executableName)
+	perms, _ = execs.mode(vf, name, executableName, executableByName(name, executableName))
+
+	switch {
+	case vf.IsLink:
+		return perms, 0xA000, true // S_IFLNK (Symbolic Link)
+	case vf.IsDir:
+		return perms, 0x4000, true // S_IFDIR (Directory)
+	}
+	// Optimization: Store binary uncompressed
+	return perms, 0x8000, isMainBinary // S_IFREG (Regular File)
 }
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"deb-to-ipa/pkg/schema"
+)
+
+// --- Manifest: per-entry checksums for verifying an IPA downstream ---
+
+// ManifestEntry is one file or symlink written to the archive. Directories carry no
+// data and are left out.
+type ManifestEntry struct {
+	Path   string `json:"path,omitempty"` // Bundle-relative, empty for entries outside the bundle (iTunesArtwork)
+	Name   string `json:"name"`           // Archive entry name
+	Size   uint64 `json:"size"`
+	Method string `json:"method"` // "store" or "deflate", as written to the central directory
+	CRC32  string `json:"crc32"`
+	SHA256 string `json:"sha256"`
+}
+
+// Manifest lists every entry of a written archive, in archive order
+type Manifest struct {
+	Schema  string          `json:"schema,omitempty"` // schema.Manifest when standalone, empty in a report
+	Output  string          `json:"output"`
+	Entries []ManifestEntry `json:"entries"`
+}
+
+// add records an entry once writeZipFile has filled in its CRC and size; nil-safe
+func (m *Manifest) add(relPath string, header *zip.FileHeader, sum []byte) {
+	if m == nil {
+		return
+	}
+	m.Entries = append(m.Entries, ManifestEntry{
+		Path:   relPath,
+		Name:   header.Name,
+		Size:   header.UncompressedSize64,
+		Method: methodName(header.Method),
+		CRC32:  fmt.Sprintf("%08x", header.CRC32),
+		SHA256: hex.EncodeToString(sum),
+	})
+}
+
+// manifestPathFor is where a standalone manifest goes when there's no --report to hold it
+func manifestPathFor(outputPath string) string {
+	return outputPath + ".manifest.json"
+}
+
+// writeManifest saves a standalone manifest as indented JSON
+func writeManifest(manifestPath string, m *Manifest) error {
+	doc := *m
+	doc.Schema = schema.Manifest
+	out, err := json.MarshalIndent(&doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(manifestPath, append(out, '\n'), 0644)
+}
+
+// readManifest loads a standalone manifest, or the manifest inside a --report JSON
+func readManifest(manifestPath string) (*Manifest, error) {
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	var report struct {
+		Schema   string    `json:"schema"`
+		Manifest *Manifest `json:"manifest"`
+	}
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, err
+	}
+	if err := schema.Check(report.Schema, schema.Manifest, schema.Report); err != nil {
+		return nil, err
+	}
+	if report.Manifest != nil {
+		return report.Manifest, nil
+	}
+	// A report's "entries" is a count, so only a standalone manifest is read as one
+	var m Manifest
+	if report.Schema != schema.Report {
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, err
+		}
+	}
+	if m.Entries == nil {
+		return nil, fmt.Errorf("no manifest entries (convert with --manifest)")
+	}
+	return &m, nil
+}
+
+// ManifestMismatch is an archive entry that doesn't match its manifest record
+type ManifestMismatch struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// verifyManifest checks every manifest entry against the IPA: presence, size, CRC32 and
+// a fresh SHA256 of the data. Files in the IPA the manifest doesn't know are reported too.
+func verifyManifest(ipaPath string, m *Manifest) ([]ManifestMismatch, error) {
+	zr, err := zip.OpenReader(ipaPath)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	files := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	var mismatches []ManifestMismatch
+	expected := make(map[string]bool)
+	for _, e := range m.Entries {
+		expected[e.Name] = true
+		f := files[e.Name]
+		switch {
+		case f == nil:
+			mismatches = append(mismatches, ManifestMismatch{e.Name, "missing from the IPA"})
+			continue
+		case f.UncompressedSize64 != e.Size:
+			mismatches = append(mismatches, ManifestMismatch{e.Name, fmt.Sprintf("size %d, manifest says %d", f.UncompressedSize64, e.Size)})
+			continue
+		case fmt.Sprintf("%08x", f.CRC32) != e.CRC32:
+			mismatches = append(mismatches, ManifestMismatch{e.Name, fmt.Sprintf("CRC32 %08x, manifest says %s", f.CRC32, e.CRC32)})
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, rc)
+		rc.Close()
+		if err != nil {
+			mismatches = append(mismatches, ManifestMismatch{e.Name, "unreadable: " + err.Error()})
+		} else if sum := hex.EncodeToString(h.Sum(nil)); sum != e.SHA256 {
+			mismatches = append(mismatches, ManifestMismatch{e.Name, "SHA256 " + sum + ", manifest says " + e.SHA256})
+		}
+	}
+
+	var extra []string
+	for name, f := range files {
+		if !expected[name] && !f.Mode().IsDir() && !strings.HasSuffix(name, "/") {
+			extra = append(extra, name)
+		}
+	}
+	sort.Strings(extra)
+	for _, name := range extra {
+		mismatches = append(mismatches, ManifestMismatch{name, "not in the manifest"})
+	}
+	return mismatches, nil
+}
+
+// runVerifyManifest implements the verify-manifest subcommand: 0 matches, 1 mismatches, 2 error
+func runVerifyManifest(args []string) int {
+	fs := flag.NewFlagSet("verify-manifest", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print mismatches as JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: deb-to-ipa verify-manifest [--json] <app.ipa> <manifest.json|report.json>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+
+	m, err := readManifest(fs.Arg(1))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Error: %s: %v\n", fs.Arg(1), err)
+		return 2
+	}
+	mismatches, err := verifyManifest(fs.Arg(0), m)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Error: %s: %v\n", fs.Arg(0), err)
+		return 2
+	}
+
+	if *asJSON {
+		if mismatches == nil {
+			mismatches = []ManifestMismatch{}
+		}
+		out, _ := json.MarshalIndent(mismatches, "", "  ")
+		fmt.Println(string(out))
+	} else {
+		fmt.Printf("🔎 Verifying %s against %s\n", fs.Arg(0), fs.Arg(1))
+		if len(mismatches) == 0 {
+			fmt.Printf("   ✅ All %d entries match\n", len(m.Entries))
+		}
+		for _, mm := range mismatches {
+			fmt.Printf("   ❌ %s: %s\n", mm.Name, mm.Reason)
+		}
+	}
+
+	if len(mismatches) > 0 {
+		return 1
+	}
+	return 0
+}
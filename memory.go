@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// --- Entry metadata accounting ---
+// Icon-pack debs carry hundreds of thousands of tiny files, where the VirtualFiles and
+// their names cost more than the data. Their estimated size counts against MaxMemoryUsage
+// like file data does, so big archives spill sooner instead of running out of memory.
+
+// entryOverhead is the fixed cost of one entry: the VirtualFile, its slot in deb.Files
+// and the BundleEntry selectBundleEntries makes for it. Names are counted on top.
+const entryOverhead = int64(unsafe.Sizeof(VirtualFile{})) + int64(unsafe.Sizeof(uintptr(0))) + int64(unsafe.Sizeof(BundleEntry{}))
+
+// nameChunkSize is how much the name arena allocates at a time
+const nameChunkSize = 256 << 10
+
+// nameArena packs entry names into large shared buffers, so each name costs its length
+// rather than its own allocation. Strings handed out are never written to again.
+type nameArena struct {
+	buf []byte
+}
+
+// intern returns a copy of s that lives in the arena
+func (a *nameArena) intern(s string) string {
+	if s == "" {
+		return ""
+	}
+	if len(s) > cap(a.buf)-len(a.buf) {
+		if len(s) > nameChunkSize/4 {
+			// Not worth wasting the rest of a chunk on
+			return string([]byte(s))
+		}
+		a.buf = make([]byte, 0, nameChunkSize)
+	}
+	start := len(a.buf)
+	a.buf = append(a.buf, s...)
+	return unsafe.String(&a.buf[start], len(s))
+}
+
+// track moves an entry's names into the arena and charges its metadata to the store
+func (s *SpillStore) track(vf *VirtualFile) {
+	vf.Name = s.names.intern(vf.Name)
+	vf.LinkDest = s.names.intern(vf.LinkDest)
+	size := entryOverhead + int64(len(vf.Name)+len(vf.LinkDest))
+	s.Entries++
+	s.MetaUsage += size
+	s.charge(size)
+}
+
+// --- Peak memory ---
+// RamUsage only counts what the conversion chose to hold: file data and entries. The
+// process holds more besides: the decompressor's window (an xz deb can ask for hundreds
+// of MB), the zip writer's buffers, and the Go runtime's own slack. Those windows are held
+// back from the budget up front, and the heap is sampled while converting, so the peak
+// reported is what the process actually used rather than what was counted.
+
+// memorySampleInterval is how often the heap is sampled during a conversion
+const memorySampleInterval = 100 * time.Millisecond
+
+// zipWriterMemory is held back from the budget for writing the IPA: one file compressed
+// in RAM (see deflateInMemoryLimit) and a pooled deflate writer's state
+const zipWriterMemory = deflateInMemoryLimit + 1<<20
+
+// gzipWindow is what a gzip reader keeps of the stream behind it
+const gzipWindow = 32 << 10
+
+// MemoryInfo is how much memory a conversion used, counted and measured
+type MemoryInfo struct {
+	Budget      int64 `json:"budget"`            // MaxMemoryUsage
+	Reserved    int64 `json:"reserved"`          // Held back from it for the decompressor window and zip buffers
+	Window      int64 `json:"window"`            // The largest decompressor's dictionary, part of Reserved
+	PeakTracked int64 `json:"peakTracked"`       // Most file data and entry metadata held at once
+	PeakHeap    int64 `json:"peakHeap"`          // Most heap in use (runtime.MemStats.HeapInuse), sampled
+	PeakRSS     int64 `json:"peakRSS,omitempty"` // The process's peak resident set, where the OS reports it
+}
+
+// memorySampler polls the heap in the background, keeping the highest HeapInuse seen
+type memorySampler struct {
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+	peak int64
+}
+
+// startMemorySampler samples once now and then every memorySampleInterval until finish
+func startMemorySampler() *memorySampler {
+	m := &memorySampler{stop: make(chan struct{}), done: make(chan struct{})}
+	m.sample()
+	go func() {
+		defer close(m.done)
+		ticker := time.NewTicker(memorySampleInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.sample()
+			case <-m.stop:
+				return
+			}
+		}
+	}()
+	return m
+}
+
+func (m *memorySampler) sample() {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	if heap := int64(stats.HeapInuse); heap > m.peak {
+		m.peak = heap
+	}
+}
+
+// finish stops sampling, takes a last sample and returns the peak. Calling it again is harmless.
+func (m *memorySampler) finish() int64 {
+	m.once.Do(func() {
+		close(m.stop)
+		<-m.done
+	})
+	m.sample()
+	return m.peak
+}
+
+// memoryLimit is MaxMemoryUsage; tests lower it to make small fixtures spill
+var memoryLimit int64 = MaxMemoryUsage
+
+// budget is what file data and entries may use: MaxMemoryUsage less what's held back
+func (s *SpillStore) budget() int64 {
+	return memoryLimit - s.reserved()
+}
+
+// reserved is held back from MaxMemoryUsage for the buffers the budget doesn't count
+func (s *SpillStore) reserved() int64 {
+	return zipWriterMemory + s.Window
+}
+
+// fits reports whether size more bytes can be held in RAM within the budget
+func (s *SpillStore) fits(size int64) bool {
+	return s.RamUsage+size < s.budget()
+}
+
+// charge adds n bytes (less when negative) to RamUsage, keeping its peak
+func (s *SpillStore) charge(n int64) {
+	s.RamUsage += n
+	if s.RamUsage > s.PeakRam {
+		s.PeakRam = s.RamUsage
+	}
+}
+
+// reserveWindow holds back a decompressor's dictionary. Debs are read one at a time, so
+// only the largest is held.
+func (s *SpillStore) reserveWindow(n int64) {
+	if n > s.Window {
+		s.Window = n
+	}
+}
+
+// memory summarizes the conversion's memory use, from the store and the heap's sampled peak
+func (s *SpillStore) memory(peakHeap int64) *MemoryInfo {
+	return &MemoryInfo{Budget: memoryLimit, Reserved: s.reserved(), Window: s.Window, PeakTracked: s.PeakRam, PeakHeap: peakHeap, PeakRSS: peakRSS()}
+}
+
+// decompressorWindow is the dictionary a data.tar member's decompressor allocates, as its
+// format documents it and read from the stream's header; 0 when that can't be told
+func decompressorWindow(name string, r io.Reader) int64 {
+	head := make([]byte, 64)
+	n, _ := io.ReadFull(r, head)
+	head = head[:n]
+	switch {
+	case strings.HasSuffix(name, ".gz"):
+		return gzipWindow
+	case strings.HasSuffix(name, ".bzip2"), strings.HasSuffix(name, ".bz2"):
+		// "BZh1".."BZh9": blocks of 100k to 900k, each decoded into 4 bytes per byte
+		if len(head) >= 4 && head[3] >= '1' && head[3] <= '9' {
+			return int64(head[3]-'0') * 100000 * 4
+		}
+	case strings.HasSuffix(name, ".lzma"):
+		// A properties byte, then the dictionary size, little-endian
+		if len(head) >= 5 {
+			return int64(binary.LittleEndian.Uint32(head[1:5]))
+		}
+	case strings.HasSuffix(name, ".xz"):
+		return xzDictionarySize(head)
+	}
+	return 0
+}
+
+// xzDictionarySize reads the LZMA2 dictionary size from an xz stream's first block
+// header, 0 when there is no block or no LZMA2 filter in it
+func xzDictionarySize(head []byte) int64 {
+	const streamHeaderSize = 12
+	if len(head) < streamHeaderSize+2 || head[streamHeaderSize] == 0 {
+		return 0 // An index straight away: an empty stream
+	}
+	block := head[streamHeaderSize:]
+	flags := block[1]
+	pos := 2
+	varint := func() (uint64, bool) {
+		v, n := binary.Uvarint(block[min(pos, len(block)):])
+		if n <= 0 {
+			return 0, false
+		}
+		pos += n
+		return v, true
+	}
+	for _, present := range []bool{flags&0x40 != 0, flags&0x80 != 0} {
+		if present {
+			if _, ok := varint(); !ok {
+				return 0
+			}
+		}
+	}
+	for filter := 0; filter <= int(flags&0x03); filter++ {
+		id, ok := varint()
+		if !ok {
+			return 0
+		}
+		size, ok := varint()
+		if !ok || pos+int(size) > len(block) {
+			return 0
+		}
+		if id == 0x21 && size == 1 {
+			bits := block[pos]
+			if bits == 40 {
+				return 1<<32 - 1
+			}
+			if bits > 40 {
+				return 0
+			}
+			return int64(2|bits&1) << (bits/2 + 11)
+		}
+		pos += int(size)
+	}
+	return 0
+}
+
+// printMemory sets what the conversion counted against what the heap actually peaked at
+func printMemory(info *MemoryInfo) {
+	rss := ""
+	if info.PeakRSS > 0 {
+		rss = fmt.Sprintf(", resident %s", formatBytes(info.PeakRSS))
+	}
+	fmt.Printf("   Memory: peak heap %s%s; file data and entries peaked at %s of the %s budget (%s held back for decompression and zipping)\n",
+		formatBytes(info.PeakHeap), rss, formatBytes(info.PeakTracked), formatBytes(info.Budget-info.Reserved), formatBytes(info.Reserved))
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// substrateDylibDir is where tweak debs install their dylibs
+const substrateDylibDir = "Library/MobileSubstrate/DynamicLibraries/"
+
+// mergeDebs overlays the files of every --merge deb onto the base app's entries.
+// Files inside the merged deb's own copy of the .app replace or extend the base bundle,
+// and MobileSubstrate dylibs are carried into Frameworks/, returned with their filter
+// plists for injectTweaks. Later debs win on conflicts.
+//
+// The returned plist data is non-nil only when a merged deb replaced Info.plist and
+// --allow-plist-override is set.
+func mergeDebs(entries []BundleEntry, appNameFolder string, opts Options, store *SpillStore, filter *PathFilter, warnings *warningLog) ([]BundleEntry, []byte, []TweakDylib, error) {
+	index := make(map[string]int, len(entries))
+	for i, entry := range entries {
+		index[entry.RelPath] = i
+	}
+
+	var overlaid, conflicts []string
+	var plistOverride []byte
+	var tweaks []TweakDylib
+
+	for _, mergePath := range opts.Merge {
+		fmt.Printf("=> Merging %s...\n", filepath.Base(mergePath))
+		deb, err := readDeb(mergePath, store, readOptions{Quiet: true, Limits: opts.Limits})
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("merge %s: %w", mergePath, err)
+		}
+
+		// A dylib's filter plist says which processes it's loaded into
+		filters := make(map[string]*VirtualFile)
+		for _, vf := range deb.Files {
+			if name := filepath.ToSlash(vf.Name); strings.Contains(name, substrateDylibDir) && strings.HasSuffix(name, ".plist") && !vf.IsDir && !vf.IsLink {
+				filters[strings.TrimSuffix(path.Base(name), ".plist")] = vf
+			}
+		}
+
+		for _, vf := range deb.Files {
+			relPath, fromSubstrate, ok := mergedRelPath(filepath.ToSlash(vf.Name), appNameFolder)
+			if !ok {
+				continue
+			}
+			if relPath != "" && !isLocalPath(relPath) {
+				return nil, nil, nil, fmt.Errorf("merge %s: refusing entry escaping the app bundle: %s", mergePath, vf.Name)
+			}
+			if filter.Excluded(relPath, vf.IsDir) {
+				continue
+			}
+			if fromSubstrate {
+				tweak := TweakDylib{Path: relPath}
+				if f := filters[strings.TrimSuffix(path.Base(relPath), ".dylib")]; f != nil {
+					if tweak.Filter, err = readAll(f); err != nil {
+						return nil, nil, nil, fmt.Errorf("merge %s: %s: %w", mergePath, f.Name, err)
+					}
+				}
+				tweaks = append(tweaks, tweak)
+			}
+
+			// Matches base behaviour: Info.plist always comes from the base app unless allowed
+			if relPath == "Info.plist" {
+				if !opts.AllowPlistOverride {
+					warnings.add("merge-plist-ignored", relPath, "Ignored Info.plist from %s (use --allow-plist-override)", filepath.Base(mergePath))
+					continue
+				}
+				if plistOverride, err = readAll(vf); err != nil {
+					return nil, nil, nil, fmt.Errorf("merge %s: Info.plist: %w", mergePath, err)
+				}
+			}
+
+			entry := BundleEntry{File: vf, RelPath: relPath}
+			if i, exists := index[relPath]; exists {
+				// Two directories at the same path aren't a conflict, keep the base one
+				if vf.IsDir && entries[i].File.IsDir {
+					continue
+				}
+				entries[i] = entry
+				conflicts = append(conflicts, relPath)
+				continue
+			}
+
+			index[relPath] = len(entries)
+			entries = append(entries, entry)
+			if !vf.IsDir {
+				overlaid = append(overlaid, relPath)
+			}
+		}
+	}
+
+	printPathList("Overlaid", overlaid)
+	printPathList("Replaced (conflicts)", conflicts)
+
+	return entries, plistOverride, tweaks, nil
+}
+
+// mergedRelPath maps a path from a merged deb to a path relative to the base .app,
+// reporting false for files that have no place in the bundle.
+func mergedRelPath(name, appNameFolder string) (relPath string, fromSubstrate, ok bool) {
+	// Anything inside a bundle with the same folder name, wherever the deb puts it
+	// (rootful Applications/, rootless var/jb/Applications/, or the deb root)
+	if idx := strings.Index(name, appNameFolder+"/"); idx != -1 && (idx == 0 || name[idx-1] == '/') {
+		return strings.TrimSuffix(name[idx+len(appNameFolder)+1:], "/"), false, true
+	}
+
+	// Tweak dylibs: Library/MobileSubstrate/DynamicLibraries/Foo.dylib -> Frameworks/Foo.dylib
+	if idx := strings.Index(name, substrateDylibDir); idx != -1 && strings.HasSuffix(name, ".dylib") {
+		return path.Join("Frameworks", path.Base(name)), true, true
+	}
+
+	return "", false, false
+}
+
+// printPathList prints a titled list of bundle paths, if there are any
+func printPathList(title string, paths []string) {
+	if len(paths) == 0 {
+		return
+	}
+	fmt.Printf("   %s (%d):\n", title, len(paths))
+	for _, p := range paths {
+		fmt.Printf("     - %s\n", p)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestMergePlistOverrideSpilled merges a deb whose Info.plist is too big to be held and
+// spills to disk: with --allow-plist-override its plist still replaces the base app's
+func TestMergePlistOverrideSpilled(t *testing.T) {
+	lowerMemoryLimit(t, 1<<20)
+	mergePath := filepath.Join(t.TempDir(), "tweak.deb")
+	f, err := os.Create(mergePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := buildFixture(f, FixtureSpec{Package: "com.example.tweak", Version: "2.5", PlistPad: storageForcedLimit + 1}); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	result, _, err := tryConvertFixture(t, FixtureSpec{}, Options{Merge: []string{mergePath}, AllowPlistOverride: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Spill == nil || result.Spill.Files == 0 {
+		t.Fatalf("spilled %+v, want the merged Info.plist on disk", result.Spill)
+	}
+	if result.Version != "2.5" {
+		t.Errorf("version %q, want the merged plist's 2.5", result.Version)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// --- Mode laundering: every entry leaves with a mode installers and ldid accept ---
+// Some GUI packagers record mode 0 (or 0600, 0700...) on every tar entry. Whatever the
+// deb says, directories get at least 0755, files at least 0644, executables exactly 0755
+// and symlinks 0777, in both the IPA and --extract-to.
+//
+// This is the permission policy; whether an entry is stored or deflated is decided apart
+// (see Compression method). Executables are the main binary, dylibs, anything in a bin/
+// folder and any other Mach-O file. --exec-glob and --no-exec-glob override that for
+// matching bundle paths, the last to match winning, except for the main executable,
+// which iOS can't launch without its x bits.
+
+// executableByName reports whether a bundle path is executable by convention: the main
+// binary, dylibs and anything in a bin/ folder. name includes the app folder. The origin
+// record never is, whatever the executable is called.
+func executableByName(name, executableName string) bool {
+	if path.Base(name) == OriginFileName {
+		return false
+	}
+	return isMainExecutable(name, executableName) || strings.HasSuffix(name, ".dylib") || strings.Contains(name, "/bin/")
+}
+
+// isMainExecutable reports whether name (which includes the app folder) is the main
+// executable: exactly <App>.app/<executableName>. A file of that name deeper down, e.g.
+// in a resource folder that shares the executable's name, isn't.
+func isMainExecutable(name, executableName string) bool {
+	_, relPath, ok := strings.Cut(name, "/")
+	return ok && relPath == executableName
+}
+
+// execGlob is one --exec-glob or --no-exec-glob, in command-line order
+type execGlob struct {
+	Pattern    string
+	Executable bool
+}
+
+// execGlobFlag appends to the shared list, so the two flags interleave
+type execGlobFlag struct {
+	globs      *[]execGlob
+	executable bool
+}
+
+func (f execGlobFlag) String() string {
+	if f.globs == nil {
+		return ""
+	}
+	var patterns []string
+	for _, g := range *f.globs {
+		if g.Executable == f.executable {
+			patterns = append(patterns, g.Pattern)
+		}
+	}
+	return strings.Join(patterns, ",")
+}
+
+func (f execGlobFlag) Set(v string) error {
+	*f.globs = append(*f.globs, execGlob{Pattern: v, Executable: f.executable})
+	return nil
+}
+
+// execRule is a compiled execGlob
+type execRule struct {
+	rule       *globRule
+	executable bool
+}
+
+// ExecPolicy makes bundle paths matching a glob executable or not, whatever the name and
+// Mach-O rules say. A nil *ExecPolicy overrides nothing.
+type ExecPolicy struct {
+	rules []execRule
+}
+
+// newExecPolicy compiles the globs; nil when there are none
+func newExecPolicy(globs []execGlob) (*ExecPolicy, error) {
+	if len(globs) == 0 {
+		return nil, nil
+	}
+	p := &ExecPolicy{}
+	for _, g := range globs {
+		flagName := "no-exec-glob"
+		if g.Executable {
+			flagName = "exec-glob"
+		}
+		rule, err := compileGlob(g.Pattern, flagName)
+		if err != nil {
+			return nil, err
+		}
+		p.rules = append(p.rules, execRule{rule: rule, executable: g.Executable})
+	}
+	return p, nil
+}
+
+// mode is launderedMode for a file (name includes the app folder) the other rules judged
+// executable or not, unless a glob matches it. The glob that decided is returned too, nil
+// when none did. The main executable is never overridden.
+func (p *ExecPolicy) mode(vf *VirtualFile, name, executableName string, executable bool) (os.FileMode, *globRule) {
+	if p == nil || vf.IsDir || vf.IsLink || isMainExecutable(name, executableName) {
+		return launderedMode(vf, executable), nil
+	}
+	_, relPath, _ := strings.Cut(name, "/")
+	segments := strings.Split(relPath, "/")
+	for i := len(p.rules) - 1; i >= 0; i-- {
+		r := p.rules[i]
+		if matchRules([]*globRule{r.rule}, segments) == nil {
+			continue
+		}
+		r.rule.Matches++
+		mode := launderedMode(vf, r.executable)
+		if !r.executable {
+			mode &^= 0111
+		}
+		return mode, r.rule
+	}
+	return launderedMode(vf, executable), nil
+}
+
+// printSummary warns about globs that matched nothing
+func (p *ExecPolicy) printSummary(warnings *warningLog) {
+	if p == nil {
+		return
+	}
+	for _, r := range p.rules {
+		if r.rule.Matches == 0 {
+			warnings.add("exec-glob-unmatched", "", "--%s %q matched nothing", r.rule.Flag, r.rule.Pattern)
+		}
+	}
+}
+
+// launderedMode is the permission bits an entry should be written with
+func launderedMode(vf *VirtualFile, executable bool) os.FileMode {
+	perms := os.FileMode(vf.Mode) & 0777
+	switch {
+	case vf.IsLink:
+		return 0777
+	case vf.IsDir:
+		return perms | 0755
+	case executable:
+		return 0755
+	}
+	return perms | 0644
+}
+
+// sniffMachO reports whether a file starts with a Mach-O magic number, reading only the header
+func sniffMachO(vf *VirtualFile) bool {
+	if vf.DiskPath == "" {
+		return isMachO(vf.Data)
+	}
+	rc, err := vf.Open()
+	if err != nil {
+		return false
+	}
+	defer rc.Close()
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(rc, magic); err != nil {
+		return false
+	}
+	return isMachO(magic)
+}
+
+// modeDecision is the mode launderModes gives an entry (name includes the app folder), and
+// why it's executable when it is: "executable" by name, "Mach-O" by its header, or the
+// --exec-glob or --no-exec-glob that decided either way
+func modeDecision(vf *VirtualFile, name, executableName string, execs *ExecPolicy) (os.FileMode, string) {
+	reason := ""
+	executable := false
+	if !vf.IsDir && !vf.IsLink {
+		switch {
+		case executableByName(name, executableName):
+			executable, reason = true, "executable"
+		case vf.Mode&0111 == 0 && sniffMachO(vf):
+			executable, reason = true, "Mach-O"
+		}
+	}
+	mode, rule := execs.mode(vf, name, executableName, executable)
+	if rule != nil {
+		reason = fmt.Sprintf("--%s %q", rule.Flag, rule.Pattern)
+	}
+	return mode, reason
+}
+
+// launderModes rewrites every entry's mode to its laundered value, also marking Mach-O
+// files the name heuristics miss (framework and extension binaries) as executable.
+// Each change is printed when verbose; the number of entries changed is returned.
+func launderModes(entries []BundleEntry, appNameFolder, executableName string, execs *ExecPolicy, verbose bool) int {
+	changed := 0
+	for _, entry := range entries {
+		vf := entry.File
+		mode, reason := modeDecision(vf, path.Join(appNameFolder, entry.RelPath), executableName, execs)
+		if int64(mode) == vf.Mode {
+			continue
+		}
+		changed++
+		if verbose {
+			if reason == "" && vf.Mode&0777 == 0 {
+				reason = "mode 0"
+			}
+			if reason != "" {
+				reason = " (" + reason + ")"
+			}
+			fmt.Printf("   mode %s: %04o -> %04o%s\n", terminalSafe(valueOr(entry.RelPath, appNameFolder)), vf.Mode, mode, reason)
+		}
+		vf.Mode = int64(mode)
+	}
+	return changed
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Timestamp override: --mtime and SOURCE_DATE_EPOCH ---
+// The archive otherwise carries each tar entry's mtime, and the time of the run for anything
+// generated. A fixed stamp makes two conversions of the same deb write the same timestamps;
+// file contents are left alone.
+
+// zipEpoch is the earliest time the MS-DOS fields in a zip header can hold
+var zipEpoch = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// resolveMTime returns the stamp for every entry: --mtime (RFC 3339, or seconds since the
+// epoch), else SOURCE_DATE_EPOCH when set. ok is false when neither is given.
+func resolveMTime(value string) (stamp time.Time, ok bool, err error) {
+	source := "--mtime"
+	if value == "" {
+		value = strings.TrimSpace(os.Getenv("SOURCE_DATE_EPOCH"))
+		source = "SOURCE_DATE_EPOCH"
+	}
+	if value == "" {
+		return time.Time{}, false, nil
+	}
+
+	if secs, err := strconv.ParseInt(value, 10, 64); err == nil {
+		stamp = time.Unix(secs, 0)
+	} else if stamp, err = time.Parse(time.RFC3339, value); err != nil {
+		return time.Time{}, false, fmt.Errorf("%s %q: want RFC 3339 (e.g. 2024-01-01T00:00:00Z) or seconds since 1970", source, value)
+	}
+	stamp = stamp.UTC()
+	if stamp.Before(zipEpoch) {
+		fmt.Printf("   %s %s predates zip timestamps, using %s\n", source, stamp.Format(time.RFC3339), zipEpoch.Format(time.RFC3339))
+		stamp = zipEpoch
+	}
+	return stamp, true, nil
+}
+
+// stampEntries sets every entry's mtime to stamp, or with clampOnly only those newer than
+// it, returning how many changed. VirtualFiles shared between entries get the same result
+// either way.
+func stampEntries(entries []BundleEntry, stamp time.Time, clampOnly bool) int {
+	changed := 0
+	for _, entry := range entries {
+		vf := entry.File
+		if vf.ModTime.Equal(stamp) || (clampOnly && !vf.ModTime.After(stamp)) {
+			continue
+		}
+		vf.ModTime = stamp
+		changed++
+	}
+	return changed
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResolveMTime(t *testing.T) {
+	newYear := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		value, env string
+		want       time.Time
+		ok         bool
+		fails      bool
+	}{
+		{"", "", time.Time{}, false, false},
+		{"2024-01-01T00:00:00Z", "", newYear, true, false},
+		{"2024-01-01T02:00:00+02:00", "", newYear, true, false},
+		{"1704067200", "", newYear, true, false},
+		{"", "1704067200", newYear, true, false},
+		{"", " 1704067200\n", newYear, true, false},
+		{"2024-01-01T00:00:00Z", "0", newYear, true, false}, // The flag wins over the environment
+		{"0", "", zipEpoch, true, false},                    // Before 1980, which zip can't hold
+		{"yesterday", "", time.Time{}, false, true},
+		{"", "2024-01-01", time.Time{}, false, true},
+	} {
+		t.Setenv("SOURCE_DATE_EPOCH", c.env)
+		got, ok, err := resolveMTime(c.value)
+		if (err != nil) != c.fails || ok != c.ok || !got.Equal(c.want) {
+			t.Errorf("resolveMTime(%q) with SOURCE_DATE_EPOCH=%q = %v, %v, %v; want %v, %v, failing %v", c.value, c.env, got, ok, err, c.want, c.ok, c.fails)
+		}
+	}
+}
+
+// TestConvertMTime reads every entry's timestamp back from the archive, the extended
+// timestamp field being where archive/zip's Modified comes from
+func TestConvertMTime(t *testing.T) {
+	tarTime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	before, after := tarTime.AddDate(-1, 0, 0), tarTime.AddDate(4, 0, 0)
+	spec := FixtureSpec{Framework: true, Symlinks: true, EmptyDir: true, ModTime: tarTime}
+	for _, c := range []struct {
+		name string
+		opts Options
+		env  string
+		want time.Time
+	}{
+		{"none", Options{}, "", tarTime},
+		{"--mtime", Options{MTime: after.Format(time.RFC3339)}, "", after},
+		{"SOURCE_DATE_EPOCH", Options{}, "1704067200", time.Unix(1704067200, 0)},
+		{"--mtime-max newer", Options{MTime: after.Format(time.RFC3339), MTimeMax: true}, "", tarTime},
+		{"--mtime-max older", Options{MTime: before.Format(time.RFC3339), MTimeMax: true}, "", before},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			t.Setenv("SOURCE_DATE_EPOCH", c.env)
+			result, zr := convertFixture(t, spec, c.opts)
+			for _, f := range zr.File {
+				if !f.Modified.Equal(c.want) {
+					t.Errorf("%s: modified %v, want %v", f.Name, f.Modified, c.want)
+				}
+			}
+			if stamped := c.opts.MTime != "" || c.env != ""; stamped != (result.MTime != nil) {
+				t.Errorf("result mtime %v", result.MTime)
+			}
+		})
+	}
+}
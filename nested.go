@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// --- Nested debs: installers carrying the real package inside ---
+// Some "installer" packages ship the app as another deb somewhere in data.tar (e.g.
+// var/cache/<pkg>/App.deb) and install it from their postinst. The outer deb has no .app,
+// so the not-an-app error lists what's nested; --recurse-nested converts the inner deb
+// instead. Its bytes come from the entry already read into RAM or the spill directory and go
+// back through readDeb from there, never to a path beside the input. Each level that has no
+// app of its own goes one deeper, up to maxNestedDepth, and the report records the chain.
+
+// maxNestedDepth bounds how many debs deep --recurse-nested goes, against a deb that
+// carries itself
+const maxNestedDepth = 3
+
+// isNestedDeb reports whether a data.tar entry name is a deb
+func isNestedDeb(name string) bool {
+	return strings.EqualFold(path.Ext(name), ".deb")
+}
+
+// nestedDebs returns the deb's nested debs that were read, in archive order
+func nestedDebs(deb *DebContents) []*VirtualFile {
+	var found []*VirtualFile
+	for _, vf := range deb.Files {
+		if !vf.IsDir && !vf.IsLink && isNestedDeb(vf.Name) {
+			found = append(found, vf)
+		}
+	}
+	return found
+}
+
+// openNestedDeb gives readDeb a path to a nested deb: its spill file when that holds it as
+// is, else a copy in the private spill directory
+func openNestedDeb(vf *VirtualFile, tempDir string, depth int) (string, error) {
+	if vf.DiskPath != "" && !vf.Compressed {
+		return vf.DiskPath, nil
+	}
+	src, err := vf.Open()
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	nestedPath := filepath.Join(tempDir, fmt.Sprintf("nested-%d.deb", depth))
+	dst, err := os.Create(nestedPath)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return "", err
+	}
+	return nestedPath, dst.Close()
+}
+
+// readNestedDebs follows a deb without an app into the first deb it carries, and that one's
+// when it has none either, returning the innermost read and the chain of entry names taken
+func readNestedDebs(deb *DebContents, tempDir string, store *SpillStore, ro readOptions, warnings *warningLog) (*DebContents, []string, error) {
+	var chain []string
+	for deb.AppDirPrefix == "" {
+		found := nestedDebs(deb)
+		if len(found) == 0 {
+			break
+		}
+		if len(chain) == maxNestedDepth {
+			return nil, chain, fmt.Errorf("--recurse-nested: debs nested more than %d deep (%s)", maxNestedDepth, strings.Join(append(chain, found[0].Name), " > "))
+		}
+		if len(found) > 1 {
+			var others []string
+			for _, vf := range found[1:] {
+				others = append(others, vf.Name)
+			}
+			warnings.add("nested-deb-choice", found[0].Name, "The deb carries %d debs; converting %s, the first, and not %s", len(found), found[0].Name, strings.Join(others, ", "))
+		}
+		nestedPath, err := openNestedDeb(found[0], tempDir, len(chain))
+		if err != nil {
+			return nil, chain, fmt.Errorf("--recurse-nested: %s: %w", found[0].Name, err)
+		}
+		chain = append(chain, found[0].Name)
+		if !ro.Quiet {
+			fmt.Printf("   No app here, but %s is a deb; converting it instead (--recurse-nested)\n", terminalSafe(found[0].Name))
+		}
+		ro.TwoPass = useTwoPass(nestedPath, false)
+		if deb, err = readDeb(nestedPath, store, ro); err != nil {
+			return nil, chain, fmt.Errorf("%s: %w", strings.Join(chain, " > "), err)
+		}
+	}
+	return deb, chain, nil
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// --- Not an app: explaining debs that hold no .app ---
+// Plenty of debs are jailbreak add-ons rather than apps: Settings panes, Control Center
+// modules, tweaks, fonts, themes, or extensions shipped without their app (see --appex). None can become an IPA, and "no .app directory" alone
+// doesn't say why, so the layout is classified and the error says what the deb holds.
+
+// Kinds of deb content a NotAnAppError reports
+const (
+	DebKindExtension        = "extension"         // *.appex outside any .app, which --appex converts
+	DebKindApp              = "app"               // *.app, when --appex was looking for an extension
+	DebKindNested           = "nested-deb"        // *.deb files, an installer's payload, which --recurse-nested converts
+	DebKindPreferenceBundle = "preference-bundle" // Library/PreferenceBundles/*.bundle, a Settings pane
+	DebKindControlCenter    = "control-center"    // Library/ControlCenter/Bundles/*.bundle
+	DebKindTweak            = "tweak"             // Library/MobileSubstrate/DynamicLibraries/*.dylib and the like
+	DebKindTheme            = "theme"             // Library/Themes/*
+	DebKindFonts            = "fonts"             // .ttf/.otf/.ttc files
+	DebKindTools            = "command-line"      // Executables in bin/ folders
+	DebKindEmpty            = "empty"             // No files at all
+	DebKindUnknown          = "unknown"           // None of the above
+)
+
+// debKindDescriptions say what each kind is and where it runs, for the error message
+var debKindDescriptions = map[string]string{
+	DebKindExtension:        "an app extension without its host app",
+	DebKindApp:              "an app",
+	DebKindNested:           "another deb, which its install scripts would install",
+	DebKindPreferenceBundle: "a Settings pane, which PreferenceLoader loads into the Settings app",
+	DebKindControlCenter:    "a Control Center module, which SpringBoard loads",
+	DebKindTweak:            "a tweak, which a substitution framework injects into other processes",
+	DebKindTheme:            "a theme, which a theming tweak applies",
+	DebKindFonts:            "fonts, which are installed system-wide",
+	DebKindTools:            "command-line tools",
+	DebKindEmpty:            "no files",
+	DebKindUnknown:          "no app bundle",
+}
+
+// NotAnAppError is returned when a deb has no .app folder. It lists what the deb holds
+// instead, so wrappers can tell the cases apart without parsing the message.
+type NotAnAppError struct {
+	Kinds     []string `json:"kinds"`               // DebKind* constants, most telling first
+	Items     []string `json:"items,omitempty"`     // The bundles and files recognized, e.g. "Library/PreferenceBundles/Foo.bundle"
+	TopLevel  []string `json:"topLevel"`            // Top-level directories of data.tar
+	Wanted    string   `json:"wanted"`              // The bundle folder looked for, BundleExtApp or BundleExtAppex
+	Decoys    []string `json:"decoys,omitempty"`    // Folders named like one that aren't (see bundlerank.go)
+	Versioned []string `json:"versioned,omitempty"` // Wanted folders renamed with a version, e.g. Applications/Foo.app-1.3
+}
+
+func (e *NotAnAppError) Error() string {
+	var what []string
+	for _, kind := range e.Kinds {
+		what = append(what, debKindDescriptions[kind])
+	}
+	msg := fmt.Sprintf("unsupported app: could not find %s directory inside deb; it contains %s", e.Wanted, strings.Join(what, ", and "))
+	if len(e.Decoys) > 0 {
+		msg = fmt.Sprintf("unsupported app: no %s directory inside deb is an app; it contains %s", e.Wanted, strings.Join(what, ", and "))
+	}
+	if len(e.Items) > 0 {
+		items := e.Items
+		if len(items) > 5 {
+			items = append(items[:5:5], fmt.Sprintf("and %d more", len(e.Items)-5))
+		}
+		msg += " (" + strings.Join(items, ", ") + ")"
+	}
+	switch e.Kinds[0] {
+	case DebKindExtension:
+		msg += ". --appex converts an extension on its own"
+	case DebKindApp:
+		msg += ". Convert it without --appex"
+	case DebKindNested:
+		msg += ". --recurse-nested converts the deb inside instead"
+	case DebKindEmpty, DebKindUnknown:
+	default:
+		msg += ". It extends the system rather than running on its own, so there is nothing to put in an IPA"
+	}
+	if len(e.Decoys) > 0 {
+		msg += fmt.Sprintf(". Passed over %s: not under Applications/, no Info.plist naming an executable and no Mach-O at the root; --app-prefix converts one anyway",
+			strings.Join(e.Decoys, ", "))
+	}
+	if len(e.Versioned) > 0 {
+		msg += fmt.Sprintf(". %s has a version after %s, so it isn't taken for the app; --app-prefix %s/ converts it",
+			strings.Join(e.Versioned, ", "), e.Wanted, e.Versioned[0])
+	}
+	if len(e.TopLevel) > 0 {
+		msg += ". Top-level directories: " + strings.Join(e.TopLevel, ", ")
+	}
+	return msg
+}
+
+// Kinds in the order they're reported; an add-on's own bundle says more than its fonts
+var debKindOrder = []string{DebKindExtension, DebKindApp, DebKindNested, DebKindPreferenceBundle, DebKindControlCenter, DebKindTweak, DebKindTheme, DebKindFonts, DebKindTools}
+
+// classifyNotAnApp describes a deb without a wanted (BundleExtApp or BundleExtAppex) folder
+// from its entry names, directories with a trailing slash. Rootless packages (var/jb/...)
+// are recognized alike. Decoys are wanted folders that were passed over; what's in them
+// is classified by where it sits, not as a bundle.
+func classifyNotAnApp(names []string, wanted string, decoys []string) *NotAnAppError {
+	e := &NotAnAppError{Wanted: wanted}
+	for _, decoy := range decoys {
+		e.Decoys = append(e.Decoys, strings.TrimSuffix(decoy, "/"))
+	}
+	items := make(map[string][]string)
+	seenItem := make(map[string]bool)
+	topLevel := make(map[string]bool)
+	files := 0
+	for _, name := range names {
+		if top, _, ok := strings.Cut(name, "/"); ok {
+			topLevel[top] = true
+		}
+		if strings.HasSuffix(name, "/") {
+			continue
+		}
+		files++
+
+		if prefix := versionedBundlePrefix(name, wanted); prefix != "" && !slices.Contains(e.Versioned, strings.TrimSuffix(prefix, "/")) {
+			e.Versioned = append(e.Versioned, strings.TrimSuffix(prefix, "/"))
+		}
+		rel := strings.TrimPrefix(name, "var/jb/")
+		root := name[:len(name)-len(rel)]
+		kind, item := debItemKind(rel)
+		if slices.ContainsFunc(decoys, func(decoy string) bool { return strings.HasPrefix(name, decoy) }) {
+			kind, item = debLayoutKind(rel)
+		}
+		if kind != "" && !seenItem[root+item] {
+			seenItem[root+item] = true
+			items[kind] = append(items[kind], root+item)
+		}
+	}
+
+	for _, kind := range debKindOrder {
+		if len(items[kind]) > 0 {
+			e.Kinds = append(e.Kinds, kind)
+			e.Items = append(e.Items, items[kind]...)
+		}
+	}
+	switch {
+	case files == 0:
+		e.Kinds = []string{DebKindEmpty}
+	case len(e.Kinds) == 0:
+		e.Kinds = []string{DebKindUnknown}
+	}
+	for dir := range topLevel {
+		e.TopLevel = append(e.TopLevel, dir)
+	}
+	sort.Strings(e.TopLevel)
+	return e
+}
+
+// debItemKind recognizes one file of a rootful layout, returning its kind and the bundle,
+// folder or file that identifies it
+func debItemKind(name string) (kind, item string) {
+	if prefix := bundlePrefix(name, BundleExtAppex); prefix != "" {
+		return DebKindExtension, strings.TrimSuffix(prefix, "/")
+	}
+	if prefix := bundlePrefix(name, BundleExtApp); prefix != "" {
+		return DebKindApp, strings.TrimSuffix(prefix, "/")
+	}
+	return debLayoutKind(name)
+}
+
+// debLayoutKind is debItemKind going by folders and extensions alone, not bundles
+func debLayoutKind(name string) (kind, item string) {
+	parts := strings.Split(name, "/")
+	under := func(prefix ...string) bool {
+		if len(parts) <= len(prefix) {
+			return false
+		}
+		for i, p := range prefix {
+			if !strings.EqualFold(parts[i], p) {
+				return false
+			}
+		}
+		return true
+	}
+	switch {
+	case under("Library", "PreferenceBundles"):
+		return DebKindPreferenceBundle, path.Join(parts[:3]...)
+	case under("Library", "PreferenceLoader", "Preferences"):
+		return DebKindPreferenceBundle, path.Join(parts[:4]...)
+	case under("Library", "ControlCenter", "Bundles"):
+		return DebKindControlCenter, path.Join(parts[:4]...)
+	case under("Library", "MobileSubstrate", "DynamicLibraries"), under("Library", "TweakInject"):
+		if strings.HasSuffix(name, ".dylib") {
+			return DebKindTweak, name
+		}
+	case under("Library", "Themes"):
+		return DebKindTheme, path.Join(parts[:3]...)
+	}
+	switch strings.ToLower(path.Ext(name)) {
+	case ".ttf", ".otf", ".ttc":
+		return DebKindFonts, name
+	case ".deb":
+		return DebKindNested, name
+	}
+	if len(parts) >= 2 && (parts[len(parts)-2] == "bin" || parts[len(parts)-2] == "sbin") {
+		return DebKindTools, name
+	}
+	return "", ""
+}
+
+// notAnApp classifies a deb whose read found no wanted folder
+func notAnApp(deb *DebContents, wanted string) *NotAnAppError {
+	var names []string
+	for _, vf := range deb.Files {
+		names = append(names, vf.Name)
+	}
+	for _, e := range deb.Outside {
+		names = append(names, e.Name)
+	}
+	return classifyNotAnApp(names, wanted, deb.Decoys)
+}
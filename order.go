@@ -0,0 +1,70 @@
+package main
+
+import (
+	"path"
+	"sort"
+)
+
+// Entry orders for --order
+const (
+	OrderTar  = "tar"  // As the deb's data.tar lists them, merged and added files last
+	OrderPath = "path" // Sorted by path, byte-wise
+)
+
+// orderEntries puts entries in the order they're written to the archive. Either way a
+// directory comes before everything inside it: with OrderTar, a directory the tar lists
+// after its contents is moved up to just before the first of them. archive/zip writes the
+// central directory in the order entries were created, so it always matches.
+func orderEntries(entries []BundleEntry, order string) []BundleEntry {
+	if order == OrderPath {
+		// A parent's path is a prefix of its children's, so it always sorts first
+		sort.SliceStable(entries, func(i, j int) bool { return entries[i].RelPath < entries[j].RelPath })
+		return entries
+	}
+
+	dirs := make(map[string]int)
+	for i, entry := range entries {
+		if entry.File.IsDir {
+			dirs[entry.RelPath] = i
+		}
+	}
+
+	ordered := make([]BundleEntry, 0, len(entries))
+	written := make([]bool, len(entries))
+	// emit writes relPath's directory entry, and before it those of its parents
+	var emit func(relPath string)
+	emit = func(relPath string) {
+		i, ok := dirs[relPath]
+		if ok && written[i] {
+			return // And so were its parents
+		}
+		if relPath != "" {
+			emit(parentDir(relPath))
+		}
+		if ok {
+			written[i] = true
+			ordered = append(ordered, entries[i])
+		}
+	}
+	for i, entry := range entries {
+		if written[i] {
+			continue
+		}
+		if entry.RelPath != "" {
+			emit(parentDir(entry.RelPath))
+		}
+		if !written[i] {
+			written[i] = true
+			ordered = append(ordered, entry)
+		}
+	}
+	return ordered
+}
+
+// parentDir is the bundle-relative parent of relPath, "" for the bundle root
+func parentDir(relPath string) string {
+	if parent := path.Dir(relPath); parent != "." {
+		return parent
+	}
+	return ""
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestOrderEntries(t *testing.T) {
+	entry := func(rel string, dir bool) BundleEntry {
+		return BundleEntry{RelPath: rel, File: &VirtualFile{IsDir: dir}}
+	}
+	// As a packager might write the tar: contents before their folders, folders last
+	entries := []BundleEntry{
+		entry("Info.plist", false),
+		entry("b/c/file", false),
+		entry("a.txt", false),
+		entry("b/c", true),
+		entry("b", true),
+		entry("b/d", true),
+	}
+	relPaths := func(entries []BundleEntry) string {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.RelPath)
+		}
+		return strings.Join(names, " ")
+	}
+
+	tar := orderEntries(slices.Clone(entries), OrderTar)
+	if got, want := relPaths(tar), "Info.plist b b/c b/c/file a.txt b/d"; got != want {
+		t.Errorf("--order tar: %s, want %s", got, want)
+	}
+	byPath := orderEntries(slices.Clone(entries), OrderPath)
+	if got, want := relPaths(byPath), "Info.plist a.txt b b/c b/c/file b/d"; got != want {
+		t.Errorf("--order path: %s, want %s", got, want)
+	}
+}
+
+// TestConvertOrders converts one fixture in each order: the archives hold the same
+// entries with the same data, each directory ahead of its contents, and the central
+// directory lists entries in the order their local headers were written
+func TestConvertOrders(t *testing.T) {
+	spec := FixtureSpec{Framework: true, Dylib: true, LongPaths: true}
+	contents := make(map[string]map[string]uint32)
+	for _, order := range []string{OrderTar, OrderPath} {
+		_, zr := convertFixture(t, spec, Options{Order: order})
+		checkFixtureApp(t, zr, fixtureApp)
+
+		crcs := make(map[string]uint32)
+		seen := make(map[string]bool)
+		var offset int64 = -1
+		for i, f := range zr.File {
+			crcs[f.Name] = f.CRC32
+			seen[f.Name] = true
+			if parent := zipParent(f.Name); parent != "" && !seen[parent] && zipEntries(zr)[parent] != nil {
+				t.Errorf("--order %s: %s comes before its folder %s", order, f.Name, parent)
+			}
+			dataOffset, err := f.DataOffset()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dataOffset <= offset {
+				t.Errorf("--order %s: central directory entry %d, %s, was written before the one ahead of it", order, i, f.Name)
+			}
+			offset = dataOffset
+			if order == OrderPath && i > 0 && strings.TrimSuffix(f.Name, "/") < strings.TrimSuffix(zr.File[i-1].Name, "/") {
+				t.Errorf("--order path: %s sorts before %s", f.Name, zr.File[i-1].Name)
+			}
+		}
+		contents[order] = crcs
+	}
+	if tar, byPath := contents[OrderTar], contents[OrderPath]; len(tar) != len(byPath) {
+		t.Errorf("%d entries with --order tar, %d with --order path", len(tar), len(byPath))
+	} else {
+		for name, crc := range tar {
+			if other, ok := byPath[name]; !ok || other != crc {
+				t.Errorf("%s differs between the orders", name)
+			}
+		}
+	}
+}
+
+// zipParent is the directory entry name holding a zip entry, "" at the root
+func zipParent(name string) string {
+	name = strings.TrimSuffix(name, "/")
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		return name[:i+1]
+	}
+	return ""
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"time"
+
+	"deb-to-ipa/pkg/schema"
+)
+
+// --- Deb origin: which package an IPA was converted from ---
+// A small JSON file at the .app root records the deb's control fields and checksum, so an
+// IPA found later can be traced back to its source. Nothing on iOS reads it; deleting it
+// (before signing, or by converting with --embed-origin=false) changes nothing else.
+
+// OriginFileName is the origin record's name at the bundle root
+const OriginFileName = "_deb_origin.json"
+
+// originNote is written into the record for whoever finds it in a bundle
+const originNote = "Written by deb-to-ipa to trace this app to the package it was converted from. Nothing reads it; it is safe to delete."
+
+// DebOrigin is the source package of a converted app
+type DebOrigin struct {
+	Schema      string            `json:"schema,omitempty"` // schema.Origin in the bundle, empty in a report
+	Note        string            `json:"note"`
+	Package     string            `json:"package,omitempty"`
+	Version     string            `json:"version,omitempty"`
+	Maintainer  string            `json:"maintainer,omitempty"`
+	Control     map[string]string `json:"control,omitempty"` // Every field of the control file
+	Deb         string            `json:"deb"`               // The deb's file name
+	SHA256      string            `json:"sha256,omitempty"`  // Of the whole deb; unknown when it was piped in
+	ConvertedAt time.Time         `json:"convertedAt"`
+	Tool        string            `json:"tool"`              // deb-to-ipa and its build version
+	Options     []ReplayOption    `json:"options,omitempty"` // The flags used, for --options-from
+}
+
+// toolVersion describes this build: the module version, or the VCS revision for local builds
+func toolVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "deb-to-ipa"
+	}
+	version := info.Main.Version
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" && (version == "" || version == "(devel)") {
+			version = s.Value
+		}
+	}
+	return "deb-to-ipa " + valueOr(version, "(devel)")
+}
+
+// newDebOrigin describes debPath for the origin record. A pipe has been read already and
+// can't be hashed, so its record has no SHA256.
+func newDebOrigin(debPath string, control map[string]string) (*DebOrigin, error) {
+	origin := &DebOrigin{
+		Note:        originNote,
+		Package:     control["Package"],
+		Version:     control["Version"],
+		Maintainer:  control["Maintainer"],
+		Control:     control,
+		Deb:         filepath.Base(debPath),
+		ConvertedAt: time.Now().UTC().Truncate(time.Second),
+		Tool:        toolVersion(),
+	}
+	if info, err := os.Stat(debPath); err != nil || !info.Mode().IsRegular() {
+		return origin, nil
+	}
+
+	f, err := os.Open(debPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, fmt.Errorf("hashing %s: %w", debPath, err)
+	}
+	origin.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return origin, nil
+}
+
+// embedOrigin writes the origin record at the bundle root, replacing any a previous
+// conversion left there
+func embedOrigin(entries []BundleEntry, origin *DebOrigin) ([]BundleEntry, error) {
+	doc := *origin
+	doc.Schema = schema.Origin
+	data, err := json.MarshalIndent(&doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	vf := &VirtualFile{Name: OriginFileName, Data: append(data, '\n'), Mode: 0644, ModTime: origin.ConvertedAt}
+	vf.Size = int64(len(vf.Data))
+	for i, entry := range entries {
+		if entry.RelPath == OriginFileName {
+			entries[i].File = vf
+			return entries, nil
+		}
+	}
+	return append(entries, BundleEntry{File: vf, RelPath: OriginFileName}), nil
+}
+
+// readIPAOrigin returns the origin record inside an IPA's app, nil if it has none
+func readIPAOrigin(ipaPath string) (*DebOrigin, error) {
+	zr, err := zip.OpenReader(ipaPath)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	prefix := ipaAppPrefix(zr.File)
+	for _, f := range zr.File {
+		if prefix == "" || f.Name != prefix+OriginFileName {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		var origin DebOrigin
+		if err := json.NewDecoder(rc).Decode(&origin); err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		if err := schema.Check(origin.Schema, schema.Origin); err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		return &origin, nil
+	}
+	return nil, nil
+}
+
+// printOrigin shows where an app came from
+func printOrigin(origin *DebOrigin) {
+	fmt.Printf("   Origin: %s %s", valueOr(origin.Package, "(unknown package)"), origin.Version)
+	if origin.Maintainer != "" {
+		fmt.Printf(" by %s", origin.Maintainer)
+	}
+	fmt.Printf("\n           %s, SHA256 %s\n", origin.Deb, valueOr(origin.SHA256, "unknown"))
+	fmt.Printf("           converted %s by %s\n", origin.ConvertedAt.Format(time.RFC3339), origin.Tool)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// --- Output budget: --max-output-size stops an IPA that won't fit ---
+// Stores, MDM and OTA hosts cap IPA sizes (4 GB; 200 MB over cellular). With
+// --max-output-size, the bundle is weighed against the budget before zipping, from its
+// uncompressed size and a sampled deflate ratio, and a likely overrun is warned about.
+// While zipping, every byte written to the IPA counts, and the first one past the budget
+// stops the conversion with an *OutputBudgetError instead of finishing a file nobody can
+// use; the partial IPA is removed like any failed run's. The error names the largest
+// folders, the first candidates for --exclude.
+
+const (
+	// budgetMargin pads the estimate's deflated bytes: the sample is each file's start, which
+	// can compress better than the rest
+	budgetMargin = 1.1
+	// zipEntryOverhead is about what a zip spends per entry besides the name, which it
+	// stores twice: the local header, the central directory record and their extra fields
+	zipEntryOverhead = 100
+	// budgetSuggestions is how many folders the error suggests excluding
+	budgetSuggestions = 3
+)
+
+// OutputBudget is how the IPA measured up to --max-output-size
+type OutputBudget struct {
+	Budget   int64      `json:"budget"`
+	Estimate int64      `json:"estimate"`           // Before zipping: stored files whole, the rest at the sampled deflate ratio
+	Written  int64      `json:"written"`            // IPA bytes flushed to the file; all of them when it fit
+	Exceeded bool       `json:"exceeded,omitempty"` // The conversion stopped at the budget
+	At       string     `json:"at,omitempty"`       // The entry being written when it did
+	Entries  int        `json:"entries,omitempty"`  // Entries written before that one
+	Total    int        `json:"total,omitempty"`    // Entries there were to write
+	Largest  []SizeItem `json:"largest,omitempty"`  // The biggest folders, uncompressed, to --exclude
+}
+
+// OutputBudgetError is returned when writing the IPA passes --max-output-size
+type OutputBudgetError struct {
+	OutputBudget
+}
+
+func (e *OutputBudgetError) Error() string {
+	msg := fmt.Sprintf("the IPA passed --max-output-size %s at %s, %s written (%d of %d entries done)",
+		formatBytes(e.Budget), valueOr(e.At, "the end of the archive"), formatBytes(e.Written), e.Entries, e.Total)
+	if len(e.Largest) == 0 {
+		return msg
+	}
+	var folders []string
+	for _, item := range e.Largest {
+		folders = append(folders, fmt.Sprintf("%s (%s)", item.Path, formatBytes(item.Size)))
+	}
+	return msg + "; the largest folders, which --exclude could leave out: " + strings.Join(folders, ", ")
+}
+
+// info is the budget a report carries for a conversion stopped by it
+func (e *OutputBudgetError) info() *OutputBudget {
+	b := e.OutputBudget
+	return &b
+}
+
+// outputBudget counts the IPA's bytes against --max-output-size; a nil one counts nothing
+type outputBudget struct {
+	OutputBudget
+	entries []BundleEntry // For the largest folders, should it trip
+}
+
+// newOutputBudget returns a budget of limit bytes for entries, nil when limit is 0
+func newOutputBudget(limit int64, entries []BundleEntry) *outputBudget {
+	if limit <= 0 {
+		return nil
+	}
+	return &outputBudget{OutputBudget: OutputBudget{Budget: limit, Total: len(entries)}, entries: entries}
+}
+
+// precheck estimates the IPA's size, warning when it's likely over the budget. stored
+// tells the files written uncompressed, counted whole; the rest are deflated at the ratio
+// of a sample. A bundle that fits uncompressed isn't sampled at all.
+func (b *outputBudget) precheck(stored func(BundleEntry) bool, layout, appNameFolder string, warnings *warningLog) error {
+	if b == nil {
+		return nil
+	}
+	var storedBytes, deflatableBytes int64
+	var deflatable []BundleEntry
+	for _, entry := range b.entries {
+		b.Estimate += zipEntryOverhead + 2*int64(len(zipEntryName(layout, appNameFolder, entry.RelPath)))
+		if vf := entry.File; vf.IsDir || vf.IsLink {
+			continue
+		}
+		if stored(entry) {
+			storedBytes += entry.File.Size
+		} else {
+			deflatableBytes += entry.File.Size
+			deflatable = append(deflatable, entry)
+		}
+	}
+	b.Estimate += storedBytes
+	if b.Estimate+deflatableBytes <= b.Budget {
+		b.Estimate += deflatableBytes
+		return nil
+	}
+
+	est, err := estimateDeflated(deflatable, deflatableBytes)
+	if err != nil {
+		return fmt.Errorf("estimating the IPA's size: %w", err)
+	}
+	b.Estimate += int64(float64(est.Deflated) * budgetMargin)
+	fmt.Printf("   Estimated IPA size: about %s, against --max-output-size %s\n", formatBytes(b.Estimate), formatBytes(b.Budget))
+	if b.Estimate > b.Budget {
+		warnings.add("output-budget-estimate", "", "The IPA will likely be about %s, over --max-output-size %s; the conversion stops once it passes it",
+			formatBytes(b.Estimate), formatBytes(b.Budget))
+	}
+	return nil
+}
+
+// entry notes that the next bytes written belong to name
+func (b *outputBudget) entry(name string) {
+	if b == nil {
+		return
+	}
+	if b.At != "" {
+		b.Entries++
+	}
+	b.At = name
+}
+
+// writer counts what's written through w, failing the write that would pass the budget
+func (b *outputBudget) writer(w io.Writer) io.Writer {
+	if b == nil {
+		return w
+	}
+	return &budgetWriter{w: w, b: b}
+}
+
+// finish ends the count once the IPA is complete; it's what the report shows
+func (b *outputBudget) finish() *OutputBudget {
+	if b == nil {
+		return nil
+	}
+	b.At, b.Entries = "", b.Total
+	return &b.OutputBudget
+}
+
+// budgetWriter is outputBudget's writer
+type budgetWriter struct {
+	w io.Writer
+	b *outputBudget
+}
+
+func (bw *budgetWriter) Write(p []byte) (int, error) {
+	b := bw.b
+	if b.Written+int64(len(p)) > b.Budget {
+		e := &OutputBudgetError{OutputBudget: b.OutputBudget}
+		e.Exceeded = true
+		dirs := directorySizes(bundleSizeItems(b.entries))
+		e.Largest = dirs[:min(len(dirs), budgetSuggestions)]
+		return 0, e
+	}
+	n, err := bw.w.Write(p)
+	b.Written += int64(n)
+	return n, err
+}
+
+// printOutputBudget shows how much of the budget the IPA used
+func printOutputBudget(b *OutputBudget) {
+	if b == nil {
+		return
+	}
+	fmt.Printf("   Output: %s of the %s --max-output-size (%.0f%%; estimated %s before zipping)\n",
+		formatBytes(b.Written), formatBytes(b.Budget), 100*float64(b.Written)/float64(b.Budget), formatBytes(b.Estimate))
+}
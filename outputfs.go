@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// --- Output file systems: SD cards, network shares, read-only and full mounts ---
+// The IPA is written to a partial file beside the output and renamed into place. exFAT
+// cards, SMB shares and FUSE mounts don't all play along, so the target is probed before
+// converting and the rename has a copying fallback.
+
+// outputProbeSize is written to the output directory before converting, so a read-only or
+// full target fails in seconds rather than after the whole conversion
+const outputProbeSize = 64 << 10
+
+// renameFile is os.Rename, replaceable to simulate file systems that refuse renames
+var renameFile = os.Rename
+
+// probeOutputDir checks the output path's directory takes a new file of a little data
+func probeOutputDir(outputPath string) error {
+	dir := filepath.Dir(outputPath)
+	f, err := os.CreateTemp(dir, "."+filepath.Base(outputPath)+".*.probe")
+	if err != nil {
+		return outputDirError(dir, err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(make([]byte, outputProbeSize))
+	if err == nil {
+		err = syncFile(f)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return outputDirError(dir, err)
+	}
+	return nil
+}
+
+// outputDirError names the output directory problems worth telling apart
+func outputDirError(dir string, err error) error {
+	switch {
+	case errors.Is(err, syscall.EROFS):
+		return fmt.Errorf("output directory %s is on a read-only file system: %w", dir, err)
+	case errors.Is(err, syscall.ENOSPC), errors.Is(err, syscall.EDQUOT):
+		return fmt.Errorf("output directory %s is full or over quota: %w", dir, err)
+	case errors.Is(err, fs.ErrPermission):
+		return fmt.Errorf("output directory %s is not writable: %w", dir, err)
+	case errors.Is(err, fs.ErrNotExist):
+		return fmt.Errorf("output directory %s does not exist", dir)
+	}
+	return fmt.Errorf("output directory %s: %w", dir, err)
+}
+
+// syncFile flushes f to stable storage. Some network and FUSE file systems don't
+// implement fsync at all; the data still lands on close, so that isn't an error.
+func syncFile(f *os.File) error {
+	err := f.Sync()
+	if err == nil || errors.Is(err, syscall.EINVAL) || errors.Is(err, syscall.ENOTSUP) || errors.Is(err, errors.ErrUnsupported) {
+		return nil
+	}
+	return err
+}
+
+// moveIntoPlace renames a finished partial file to dest. A rename across devices (a
+// download cache elsewhere) answers EXDEV: then the data is copied to a fresh partial beside
+// dest, which is renamed instead, so dest is still never seen half-written. Some SMB and
+// FUSE mounts answer EXDEV even within one directory, where no rename will do: there dest
+// is written in place.
+func moveIntoPlace(partial, dest string) error {
+	err := renameFile(partial, dest)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	fmt.Printf("   %s can't be renamed on this file system (%v); copying it into place\n", filepath.Base(partial), err)
+	dir := filepath.Dir(dest)
+	if filepath.Clean(filepath.Dir(partial)) != filepath.Clean(dir) {
+		staged, err := os.CreateTemp(dir, "."+filepath.Base(dest)+".*.partial")
+		if err != nil {
+			return outputDirError(dir, err)
+		}
+		defer os.Remove(staged.Name()) // A no-op once renamed
+		if err := copyInto(staged, partial); err != nil {
+			return fmt.Errorf("copying %s into place: %w", dest, err)
+		}
+		err = renameFile(staged.Name(), dest)
+		if err == nil {
+			return os.Remove(partial)
+		}
+		if !errors.Is(err, syscall.EXDEV) {
+			return fmt.Errorf("copying %s into place: %w", dest, err)
+		}
+		// dest's own file system refuses renames too
+	}
+
+	f, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err == nil {
+		err = copyInto(f, partial)
+	}
+	if err != nil {
+		return fmt.Errorf("writing %s in place: %w", dest, err)
+	}
+	return os.Remove(partial)
+}
+
+// copyInto copies the file at src into dst, then syncs, closes and makes it 0644
+func copyInto(dst *os.File, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		dst.Close()
+		return err
+	}
+	defer in.Close()
+	_, err = io.Copy(dst, in)
+	if err == nil {
+		err = syncFile(dst)
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(dst.Name(), 0644)
+	}
+	return err
+}
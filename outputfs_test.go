@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// TestMoveIntoPlaceEXDEV moves a partial into place on file systems that refuse renames:
+// across devices only, and even within one directory
+func TestMoveIntoPlaceEXDEV(t *testing.T) {
+	defer func(rename func(string, string) error) { renameFile = rename }(renameFile)
+	exdev := func(from, to string) error {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: syscall.EXDEV}
+	}
+
+	for name, c := range map[string]struct {
+		rename  func(from, to string) error
+		sameDir bool // The partial is beside dest, not in a directory of its own
+	}{
+		"across devices": {rename: func(from, to string) error {
+			if filepath.Dir(from) != filepath.Dir(to) {
+				return exdev(from, to)
+			}
+			return os.Rename(from, to)
+		}},
+		"no renames, across devices": {rename: exdev},
+		"no renames, one directory":  {rename: exdev, sameDir: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			renameFile = c.rename
+			destDir, partialDir := t.TempDir(), t.TempDir()
+			if c.sameDir {
+				partialDir = destDir
+			}
+			partial := filepath.Join(partialDir, ".app.ipa.1.partial")
+			dest := filepath.Join(destDir, "app.ipa")
+			if err := os.WriteFile(partial, []byte("finished"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(dest, []byte("an older IPA, longer than the new one"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := moveIntoPlace(partial, dest); err != nil {
+				t.Fatalf("moveIntoPlace: %v", err)
+			}
+			if data, err := os.ReadFile(dest); err != nil || string(data) != "finished" {
+				t.Errorf("dest holds %q (%v), want the partial's contents", data, err)
+			}
+			if info, err := os.Stat(dest); err == nil && info.Mode().Perm() != 0644 && os.PathSeparator == '/' {
+				t.Errorf("dest mode %v, want 0644", info.Mode().Perm())
+			}
+			if _, err := os.Stat(partial); !os.IsNotExist(err) {
+				t.Errorf("partial left behind: %v", err)
+			}
+			if left, _ := filepath.Glob(filepath.Join(destDir, ".app.ipa.*.partial")); len(left) != 0 {
+				t.Errorf("copies left beside dest: %v", left)
+			}
+		})
+	}
+}
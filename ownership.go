@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+)
+
+// --- Ownership: who the deb meant the app's files to belong to ---
+// A zip has no owners, so an IPA can't carry the mobile:mobile or root:wheel a deb's tar
+// headers give its files. When a converted app misbehaves it helps to know what the deb
+// intended, so the owners are kept per entry (as an index into a small table, shared by
+// every entry with the same one) and reported grouped. Bundle folders read from disk and
+// --add files have no deb owner and are left out.
+//
+// Owners never reach the IPA: no uid/gid extra field is written, so the huge numeric IDs
+// of containerized builds (tar --numeric-owner, uid 4000000000) can't overflow the 16-bit
+// fields some unzippers expect. They're only reported, exactly.
+
+// Owner is a tar header's ownership
+type Owner struct {
+	User  string `json:"user,omitempty"`  // Uname, when the tar recorded one
+	Group string `json:"group,omitempty"` // Gname
+	UID   int64  `json:"uid"`
+	GID   int64  `json:"gid"`
+}
+
+// String is "user:group (uid:gid)", or just the IDs when there are no names
+func (o Owner) String() string {
+	ids := fmt.Sprintf("%d:%d", o.UID, o.GID)
+	if o.User == "" && o.Group == "" {
+		return ids
+	}
+	return fmt.Sprintf("%s:%s (%s)", valueOr(o.User, "?"), valueOr(o.Group, "?"), ids)
+}
+
+// tarOwnerID is a tar header's uid or gid. archive/tar parses them into an int, which
+// wraps past 2^31 on 32-bit builds; the PAX record tar writes for an ID that big still
+// has it exactly.
+func tarOwnerID(id int, paxRecords map[string]string, key string) int64 {
+	if v, err := strconv.ParseInt(paxRecords[key], 10, 64); err == nil {
+		return v
+	}
+	return int64(id)
+}
+
+// usual reports whether the owner is root or mobile, which iOS apps are installed as
+func (o Owner) usual() bool {
+	switch o.User {
+	case "root", "mobile":
+		return true
+	case "":
+		return o.UID == 0 || o.UID == 501
+	}
+	return false
+}
+
+// ownerTable interns owners; index 0 is "not known"
+type ownerTable struct {
+	owners []Owner
+	index  map[Owner]uint32
+}
+
+// intern returns the owner's index, adding it on first sight
+func (t *ownerTable) intern(o Owner) uint32 {
+	if t.index == nil {
+		t.owners = []Owner{{}}
+		t.index = make(map[Owner]uint32)
+	}
+	i, ok := t.index[o]
+	if !ok {
+		i = uint32(len(t.owners))
+		t.owners = append(t.owners, o)
+		t.index[o] = i
+	}
+	return i
+}
+
+// OwnerGroup is the bundle paths one owner had
+type OwnerGroup struct {
+	Owner
+	Paths []string `json:"paths"` // Bundle-relative; "" is the .app folder itself
+}
+
+// buildOwnership groups the bundle's entries by the owner their tar headers gave, the most
+// common owner first; nil when no entry had one. The main executable and the folders it
+// sits in are flagged when owned by anyone but root or mobile: the deb likely relied on a
+// postinst chown the IPA can't replay.
+func buildOwnership(entries []BundleEntry, table *ownerTable, executableName string, warnings *warningLog) []OwnerGroup {
+	byOwner := make(map[uint32]*OwnerGroup)
+	var groups []*OwnerGroup
+	for _, entry := range entries {
+		i := entry.File.Owner
+		if i == 0 {
+			continue
+		}
+		group := byOwner[i]
+		if group == nil {
+			group = &OwnerGroup{Owner: table.owners[i]}
+			byOwner[i] = group
+			groups = append(groups, group)
+		}
+		group.Paths = append(group.Paths, entry.RelPath)
+	}
+	if len(groups) == 0 {
+		return nil
+	}
+
+	if executableName != "" {
+		for _, entry := range entries {
+			if entry.File.Owner == 0 || !isOnExecutablePath(entry.RelPath, executableName) {
+				continue
+			}
+			if owner := table.owners[entry.File.Owner]; !owner.usual() {
+				what := "The main executable"
+				switch entry.RelPath {
+				case executableName:
+				case "":
+					what = "The .app folder"
+				default:
+					what = fmt.Sprintf("The folder %q holding the main executable", entry.RelPath)
+				}
+				warnings.add("unusual-owner", entry.RelPath, "%s was owned by %s in the deb, not root or mobile; it may expect a postinst chown an IPA can't do", what, owner)
+			}
+		}
+	}
+
+	sort.SliceStable(groups, func(a, b int) bool { return len(groups[a].Paths) > len(groups[b].Paths) })
+	result := make([]OwnerGroup, len(groups))
+	for i, group := range groups {
+		result[i] = *group
+	}
+	return result
+}
+
+// isOnExecutablePath reports whether relPath is the main executable or a folder it's in
+func isOnExecutablePath(relPath, executableName string) bool {
+	for p := executableName; ; p = path.Dir(p) {
+		if p == "." {
+			p = ""
+		}
+		if relPath == p {
+			return true
+		}
+		if p == "" {
+			return false
+		}
+	}
+}
+
+// printOwnership lists each owner and, with --verbose, its paths
+func printOwnership(groups []OwnerGroup, verbose bool) {
+	if len(groups) < 2 && !verbose {
+		return
+	}
+	fmt.Println("   Ownership in the deb (not kept in the IPA):")
+	for _, group := range groups {
+		fmt.Printf("     %s: %d entr%s\n", terminalSafe(group.Owner.String()), len(group.Paths), map[bool]string{true: "y", false: "ies"}[len(group.Paths) == 1])
+		if verbose {
+			for _, p := range group.Paths {
+				fmt.Printf("       %s\n", terminalSafe(valueOr(p, ".")))
+			}
+		}
+	}
+}
@@ -0,0 +1,632 @@
+// Code generated by TestSchemaGenerated from the types deb-to-ipa encodes; DO NOT EDIT.
+// Run go generate in the repository root to update it.
+
+package schema
+
+import "time"
+
+// ManifestDocument lists every entry of a written archive, in archive order
+//
+// Its schema ID is Manifest.
+type ManifestDocument struct {
+	Schema  string          `json:"schema,omitempty"` // schema.Manifest when standalone, empty in a report
+	Output  string          `json:"output"`
+	Entries []ManifestEntry `json:"entries"`
+}
+
+// ManifestEntry is one file or symlink written to the archive. Directories carry no
+// data and are left out.
+type ManifestEntry struct {
+	Path   string `json:"path,omitempty"` // Bundle-relative, empty for entries outside the bundle (iTunesArtwork)
+	Name   string `json:"name"`           // Archive entry name
+	Size   uint64 `json:"size"`
+	Method string `json:"method"` // "store" or "deflate", as written to the central directory
+	CRC32  string `json:"crc32"`
+	SHA256 string `json:"sha256"`
+}
+
+// OriginDocument is the source package of a converted app
+//
+// Its schema ID is Origin.
+type OriginDocument struct {
+	Schema      string            `json:"schema,omitempty"` // schema.Origin in the bundle, empty in a report
+	Note        string            `json:"note"`
+	Package     string            `json:"package,omitempty"`
+	Version     string            `json:"version,omitempty"`
+	Maintainer  string            `json:"maintainer,omitempty"`
+	Control     map[string]string `json:"control,omitempty"` // Every field of the control file
+	Deb         string            `json:"deb"`               // The deb's file name
+	SHA256      string            `json:"sha256,omitempty"`  // Of the whole deb; unknown when it was piped in
+	ConvertedAt time.Time         `json:"convertedAt"`
+	Tool        string            `json:"tool"`              // deb-to-ipa and its build version
+	Options     []Option          `json:"options,omitempty"` // The flags used, for --options-from
+}
+
+// Option is one flag as given, e.g. {"flag": "exclude", "value": "**/*.car"}
+type Option struct {
+	Flag  string `json:"flag"`
+	Value string `json:"value"`
+}
+
+// SizeReportDocument breaks down where the bytes in a bundle go
+//
+// Its schema ID is SizeReport.
+type SizeReportDocument struct {
+	Schema      string           `json:"schema,omitempty"` // schema.SizeReport when printed, empty in a report
+	Total       int64            `json:"total"`
+	Compressed  int64            `json:"compressed,omitempty"`
+	Categories  map[string]int64 `json:"categories"`
+	Directories []SizeItem       `json:"directories"`
+	Files       []SizeItem       `json:"files"`
+	Duplicates  []DuplicateSet   `json:"duplicates,omitempty"`
+	Debug       []DebugContent   `json:"debug,omitempty"` // Debug info found, including what --strip-debug removed
+}
+
+// SizeItem is one file or directory in the size report
+type SizeItem struct {
+	Path       string `json:"path"`
+	Size       int64  `json:"size"`
+	Compressed int64  `json:"compressed,omitempty"` // 0 when not zipped (--extract-to)
+}
+
+// DuplicateSet is a group of byte-identical binaries
+type DuplicateSet struct {
+	SHA256 string   `json:"sha256"`
+	Size   int64    `json:"size"`
+	Paths  []string `json:"paths"`
+}
+
+// DebugContent is debug info found in the bundle
+type DebugContent struct {
+	Path    string `json:"path"`    // The .dSYM folder, or the binary
+	Kind    string `json:"kind"`    // One of the Debug* constants
+	Bytes   int64  `json:"bytes"`   // Files in the folder, or the segment's sections across slices
+	Removed bool   `json:"removed"` // Left out by --strip-debug
+}
+
+// ReportDocument describes a finished conversion
+//
+// Its schema ID is Report.
+type ReportDocument struct {
+	Schema           string              `json:"schema,omitempty"`          // schema.Report, in the report file and the result event
+	Status           string              `json:"status"`                    // ResultStatusOK, ResultStatusWarnings, or ResultStatusError in a failed run's report
+	OutputPath       string              `json:"output,omitempty"`          // The IPA written, empty with --extract-to
+	AppName          string              `json:"appName"`                   // The .app folder name, e.g. "MyApp.app"
+	OriginalAppName  string              `json:"originalAppName,omitempty"` // The deb's name for it, when --payload-dir-name or --sanitize-app-name renamed it
+	BundleID         string              `json:"bundleId"`
+	Version          string              `json:"version"`
+	Executable       string              `json:"executable"`
+	ExecutableSource string              `json:"executableSource"`                 // One of the ExecutableFrom* constants
+	MinOS            string              `json:"minimumOSVersion,omitempty"`       // Info.plist MinimumOSVersion, after --min-os
+	BinaryMinOS      string              `json:"binaryMinimumOSVersion,omitempty"` // Highest LC_BUILD_VERSION/LC_VERSION_MIN_IPHONEOS across slices
+	Slices           []Slice             `json:"slices,omitempty"`                 // Architectures of the main executable
+	Universal        *UniversalResult    `json:"universal,omitempty"`              // Files --universal merged into fat binaries
+	Icon             *IconInfo           `json:"icon,omitempty"`
+	Entries          int                 `json:"entries"`                      // Tar entries read, merged debs included
+	MetadataBytes    int64               `json:"metadataBytes"`                // Estimated memory held by entry metadata, counted against the RAM limit
+	NormalizedPNGs   int                 `json:"normalizedPngs,omitempty"`     // CgBI PNGs rewritten with --normalize-pngs
+	Added            []string            `json:"added,omitempty"`              // Bundle paths written by --add
+	VersionOverrides []VersionOverride   `json:"versionOverrides,omitempty"`   // From --bundle-id/--app-version/--build-number/--min-os
+	MetadataFixes    []MetadataFix       `json:"metadataFixes,omitempty"`      // Info.plist values cleaned up before use
+	Extensions       []ExtensionID       `json:"extensions,omitempty"`         // PlugIns/*.appex IDs, before and after --fix-extension-ids
+	AppClips         []AppClip           `json:"appClips,omitempty"`           // AppClips/*.app, checked or left out with --strip-appclips
+	RootDylibs       []RootDylib         `json:"rootDylibs,omitempty"`         // .dylib files at the bundle root and whether dyld finds them
+	LinkedResources  []LinkedResource    `json:"linkedResources,omitempty"`    // Symlinks from the bundle into the rest of the deb
+	Excluded         []ExcludedFramework `json:"excludedFrameworks,omitempty"` // Left out with --exclude-framework
+	Profile          *ProfileInfo        `json:"provisioningProfile,omitempty"`
+	Localizations    *LocalizationResult `json:"localizations,omitempty"`
+	Debug            *DebugResult        `json:"debug,omitempty"`       // .dSYM folders, and DWARF and bitcode in binaries with --strip-debug or --size-report
+	Bitcode          []BitcodeStrip      `json:"bitcode,omitempty"`     // Binaries --strip-bitcode rewrote or skipped
+	AppleDouble      *AppleDoubleResult  `json:"appleDouble,omitempty"` // "._" files left out, xattrs dropped and resource forks lost
+	JBPaths          []JBPathHit         `json:"jailbreakPaths,omitempty"`
+	LongPaths        []LongPath          `json:"longPaths,omitempty"` // Entry names past --path-warn-length
+	PathCollisions   []PathCollision     `json:"pathCollisions,omitempty"`
+	Lint             []LintFinding       `json:"lint,omitempty"`
+	Manifest         *ManifestDocument   `json:"manifest,omitempty"` // Per-entry checksums, with --manifest
+	SizeReport       *SizeReportDocument `json:"sizeReport,omitempty"`
+	SizeEstimate     *SizeEstimate       `json:"sizeEstimate,omitempty"` // With --fast-transfer, the deflated size it traded away
+	OutputBudget     *OutputBudget       `json:"outputBudget,omitempty"` // With --max-output-size, how the IPA measured up
+	Dedupe           *DedupeResult       `json:"dedupe,omitempty"`
+	Spill            *SpillInfo          `json:"spill,omitempty"`     // Files over the RAM budget, written to the spill directory
+	Ownership        []OwnerGroup        `json:"ownership,omitempty"` // Tar owners of the bundle's entries, most common first
+	Memory           *MemoryInfo         `json:"memory,omitempty"`    // Peak memory, counted and sampled
+	Staging          *StagingInfo        `json:"staging,omitempty"`   // The copy from --staging
+	Throughput       *ThroughputInfo     `json:"throughput,omitempty"`
+	Origin           *OriginDocument     `json:"origin,omitempty"`  // As embedded with --embed-origin
+	Options          []Option            `json:"options,omitempty"` // The flags used, for --options-from
+	Warnings         []Warning           `json:"warnings,omitempty"`
+	TwoPass          bool                `json:"twoPass,omitempty"`    // data.tar was indexed first and only the app extracted
+	Nested           []string            `json:"nestedDebs,omitempty"` // With --recurse-nested, the debs converted instead of the input, outermost first
+	Recipe           string              `json:"recipe,omitempty"`     // The --recipe file applied
+	MTime            *time.Time          `json:"mtime,omitempty"`      // Stamp from --mtime or SOURCE_DATE_EPOCH
+	NotAnApp         *NotAnAppError      `json:"notAnApp,omitempty"`   // What the deb holds instead, when it has no .app (the conversion failed)
+	Control          map[string]string   `json:"control,omitempty"`    // Fields from the deb's control file, if present
+}
+
+// Slice describes one architecture of a binary
+type Slice struct {
+	Arch      string `json:"arch"`
+	MinOS     string `json:"minOS,omitempty"`     // From LC_BUILD_VERSION or LC_VERSION_MIN_IPHONEOS
+	Encrypted bool   `json:"encrypted,omitempty"` // FairPlay encrypted, see sliceEncrypted
+}
+
+// UniversalResult is what --universal merged, and what it left alone
+type UniversalResult struct {
+	Deb    string           `json:"deb"`              // The --universal deb's file name
+	Merged []UniversalMerge `json:"merged,omitempty"` // Files now fat, with their architectures
+	AsIs   []UniversalAsIs  `json:"asIs,omitempty"`   // Mach-O files taken from one deb only
+}
+
+// IconInfo describes the app icon for the report
+type IconInfo struct {
+	Path      string `json:"path,omitempty"` // Bundle-relative, e.g. "AppIcon60x60@3x.png"
+	Width     int    `json:"width,omitempty"`
+	Height    int    `json:"height,omitempty"`
+	CgBI      bool   `json:"cgbi,omitempty"`      // Stored in Apple's optimized PNG format
+	AssetsCar bool   `json:"assetsCar,omitempty"` // Taken from (or only available inside) Assets.car
+	Thumbnail string `json:"thumbnail,omitempty"` // Base64 128x128 PNG, with --report-icon
+}
+
+// VersionOverride records an Info.plist version key replaced by a flag
+type VersionOverride struct {
+	Key      string `json:"key"`
+	Previous string `json:"previous,omitempty"` // Empty if the key was absent
+	Value    string `json:"value"`
+}
+
+// MetadataFix is an Info.plist value that had to be cleaned up
+type MetadataFix struct {
+	Key      string `json:"key"`      // e.g. "CFBundleExecutable"; "version" for whichever version key was read
+	Original string `json:"original"` // As found, quoted and cut short for display
+	Value    string `json:"value"`    // As used; "" when it was rejected
+	Reason   string `json:"reason"`
+}
+
+// ExtensionID is an app extension's bundle ID, before and after --fix-extension-ids
+type ExtensionID struct {
+	Path     string `json:"path"`               // e.g. "PlugIns/Widget.appex"
+	Previous string `json:"previous,omitempty"` // Set when the ID was rewritten
+	BundleID string `json:"bundleId"`
+	Valid    bool   `json:"valid"` // Prefixed by the main app's ID
+}
+
+// AppClip is an App Clip nested in the app
+type AppClip struct {
+	Path       string `json:"path"` // e.g. "AppClips/Clip.app"
+	BundleID   string `json:"bundleId"`
+	Executable string `json:"executable,omitempty"`       // From its own Info.plist
+	MinOS      string `json:"minimumOSVersion,omitempty"` // Likewise
+	ValidID    bool   `json:"validId"`                    // Prefixed by the app's ID
+	Removed    bool   `json:"removed,omitempty"`          // Left out with --strip-appclips
+}
+
+// RootDylib is a .dylib at the top level of the bundle and how dyld would find it
+type RootDylib struct {
+	Path         string `json:"path"`                       // Bundle-relative, before any --relocate-dylibs
+	LoadPath     string `json:"loadPath,omitempty"`         // As the main executable's LC_LOAD_DYLIB names it
+	Status       string `json:"status"`                     // One of the Dylib* constants
+	RelocatedTo  string `json:"relocatedTo,omitempty"`      // With --relocate-dylibs
+	NeedsInstall bool   `json:"needsInstallName,omitempty"` // Relocated, but the load command still points elsewhere
+}
+
+// LinkedResource is a symlink in the bundle pointing at something the deb installs outside it
+type LinkedResource struct {
+	Path    string `json:"path"`             // Bundle-relative symlink
+	Target  string `json:"target"`           // Path in the deb after following links, e.g. "Library/MyApp/Resources"
+	Files   int    `json:"files"`            // Regular files at or under the target
+	Bytes   int64  `json:"bytes"`            // Their size
+	Bundled bool   `json:"bundled"`          // Replaced by the target's contents
+	LinkTo  string `json:"linkTo,omitempty"` // Bundled earlier under this path; the symlink now points there
+}
+
+// ExcludedFramework is a framework or dylib left out with --exclude-framework
+type ExcludedFramework struct {
+	Path     string `json:"path"`               // Bundle-relative, e.g. "Frameworks/FLEX.framework"
+	Pattern  string `json:"pattern"`            // The --exclude-framework glob it matched
+	Files    int    `json:"files"`              // Entries left out with it
+	Size     int64  `json:"size"`               // Their file data
+	LoadPath string `json:"loadPath,omitempty"` // The main executable's LC_LOAD_DYLIB for it, when it hard-links it
+}
+
+// ProfileInfo is what the report records about an embedded provisioning profile
+type ProfileInfo struct {
+	Name            string    `json:"name,omitempty"`
+	UUID            string    `json:"uuid,omitempty"`
+	TeamID          string    `json:"teamId,omitempty"`
+	AppID           string    `json:"appId,omitempty"` // application-identifier, e.g. "ABCDE12345.com.example.*"
+	Expires         time.Time `json:"expires"`
+	Expired         bool      `json:"expired,omitempty"`
+	Devices         int       `json:"devices,omitempty"` // ProvisionedDevices count, 0 for enterprise/App Store
+	MatchesBundleID bool      `json:"matchesBundleId"`
+}
+
+// LocalizationResult summarizes what --keep-localizations/--strip-localizations removed
+type LocalizationResult struct {
+	Kept         []string `json:"kept"`
+	RemovedDirs  int      `json:"removedDirs"`
+	RemovedBytes int64    `json:"removedBytes"`
+}
+
+// DebugResult is what findDebugContent found and --strip-debug removed
+type DebugResult struct {
+	Found        []DebugContent `json:"found"`
+	RemovedBytes int64          `json:"removedBytes,omitempty"`
+}
+
+// BitcodeStrip is one binary --strip-bitcode rewrote or had to skip
+type BitcodeStrip struct {
+	Path    string `json:"path"`
+	Slices  int    `json:"slices"`            // Slices the segment was removed from
+	Saved   int64  `json:"saved"`             // Bytes the file shrank by
+	Skipped string `json:"skipped,omitempty"` // Why a slice with bitcode was left alone
+}
+
+// AppleDoubleResult is what the bundle carried of macOS metadata
+type AppleDoubleResult struct {
+	Removed       int            `json:"removed"`                 // "._" files left out
+	RemovedBytes  int64          `json:"removedBytes"`            // Their size, with the .DS_Store files
+	Kept          int            `json:"kept,omitempty"`          // "._" files kept by --keep-appledouble
+	DSStore       int            `json:"dsStore,omitempty"`       // .DS_Store files left out
+	Xattrs        int            `json:"xattrs,omitempty"`        // Entries whose PAX xattr records the IPA can't hold
+	ResourceForks []ResourceFork `json:"resourceForks,omitempty"` // Non-empty forks, which the IPA loses either way
+}
+
+// JBPathHit is a binary that references jailbreak paths
+type JBPathHit struct {
+	Path    string         `json:"path"`
+	Matches map[string]int `json:"matches"` // Prefix -> occurrences
+	Total   int            `json:"total"`
+}
+
+// LongPath is an archive entry name likely to break extraction somewhere
+type LongPath struct {
+	Name   string `json:"name"`
+	Length int    `json:"length"`
+	Reason string `json:"reason"`
+}
+
+// PathCollision is an entry that collides with another
+type PathCollision struct {
+	Path    string `json:"path"`    // Archive entry name of the entry left out, or that would be
+	Other   string `json:"other"`   // The entry it collides with, which is kept
+	Kind    string `json:"kind"`    // One of the Collision* constants
+	Dropped bool   `json:"dropped"` // Left out of the output; directories colliding by case stay
+}
+
+// LintFinding is one problem found in an IPA
+type LintFinding struct {
+	Severity string `json:"severity"`
+	Check    string `json:"check"`
+	Path     string `json:"path,omitempty"`
+	Message  string `json:"message"`
+	Hint     string `json:"hint,omitempty"`
+}
+
+// SizeEstimate compares the IPA written with what a deflated build would weigh
+type SizeEstimate struct {
+	Written      int64   `json:"written"`      // The IPA's size
+	FileBytes    int64   `json:"fileBytes"`    // Stored file data in it
+	Deflated     int64   `json:"deflated"`     // Estimated IPA size with that data deflated
+	SampledBytes int64   `json:"sampledBytes"` // File data compressed for the estimate
+	Ratio        float64 `json:"ratio"`        // Deflated / original bytes over the sample
+}
+
+// OutputBudget is how the IPA measured up to --max-output-size
+type OutputBudget struct {
+	Budget   int64      `json:"budget"`
+	Estimate int64      `json:"estimate"`           // Before zipping: stored files whole, the rest at the sampled deflate ratio
+	Written  int64      `json:"written"`            // IPA bytes flushed to the file; all of them when it fit
+	Exceeded bool       `json:"exceeded,omitempty"` // The conversion stopped at the budget
+	At       string     `json:"at,omitempty"`       // The entry being written when it did
+	Entries  int        `json:"entries,omitempty"`  // Entries written before that one
+	Total    int        `json:"total,omitempty"`    // Entries there were to write
+	Largest  []SizeItem `json:"largest,omitempty"`  // The biggest folders, uncompressed, to --exclude
+}
+
+// DedupeResult describes the byte-identical files found in the bundle
+type DedupeResult struct {
+	Sets             []DuplicateSet `json:"sets"`
+	PotentialSavings int64          `json:"potentialSavings"`
+	ActualSavings    int64          `json:"actualSavings"` // Non-zero only with --dedupe=link
+}
+
+// SpillInfo is where spilled files went and how much room they took, for planning --temp-dir
+type SpillInfo struct {
+	Dir        string `json:"dir"`
+	Files      int    `json:"files"`
+	Bytes      int64  `json:"bytes"`                // On disk
+	RawBytes   int64  `json:"rawBytes"`             // Before --compress-spill; Bytes when nothing was compressed
+	Compressed int    `json:"compressed,omitempty"` // Files written compressed
+	PeakOpen   int    `json:"peakOpen"`             // Most files on disk open at once while reading them
+	OpenLimit  int    `json:"openLimit"`            // The bound on that, from the process's descriptor limit
+	Quota      int64  `json:"quota,omitempty"`      // --max-spill-size; 0 when unlimited
+	Exceeded   bool   `json:"exceeded,omitempty"`   // The conversion stopped at the quota
+}
+
+// OwnerGroup is the bundle paths one owner had
+type OwnerGroup struct {
+	Owner
+	Paths []string `json:"paths"` // Bundle-relative; "" is the .app folder itself
+}
+
+// MemoryInfo is how much memory a conversion used, counted and measured
+type MemoryInfo struct {
+	Budget      int64 `json:"budget"`            // MaxMemoryUsage
+	Reserved    int64 `json:"reserved"`          // Held back from it for the decompressor window and zip buffers
+	Window      int64 `json:"window"`            // The largest decompressor's dictionary, part of Reserved
+	PeakTracked int64 `json:"peakTracked"`       // Most file data and entry metadata held at once
+	PeakHeap    int64 `json:"peakHeap"`          // Most heap in use (runtime.MemStats.HeapInuse), sampled
+	PeakRSS     int64 `json:"peakRSS,omitempty"` // The process's peak resident set, where the OS reports it
+}
+
+// StagingInfo describes the copy of a staged IPA to its output, for --report
+type StagingInfo struct {
+	Dir      string `json:"dir"`
+	Bytes    int64  `json:"bytes"`
+	SHA256   string `json:"sha256"`
+	Attempts int    `json:"attempts"`          // Copies tried this run, resumed or from the start
+	Resumed  bool   `json:"resumed,omitempty"` // Copied from a previous run's staged IPA, without converting
+}
+
+// ThroughputInfo is the average rate of each stream a conversion used
+type ThroughputInfo struct {
+	DebIn  *Throughput `json:"debIn,omitempty"`  // Compressed data.tar bytes read from the deb
+	TarOut *Throughput `json:"tarOut,omitempty"` // Bytes out of the decompressor
+	Spill  *Throughput `json:"spill,omitempty"`  // Bytes written to spill files
+	Zip    *Throughput `json:"zip,omitempty"`    // Bytes written to the IPA
+}
+
+// Warning is one problem found during a conversion
+type Warning struct {
+	Code     string `json:"code"`           // Stable kebab-case identifier, e.g. "root-dylib-rpath"
+	Message  string `json:"message"`        // Human-readable, complete on its own
+	Path     string `json:"path,omitempty"` // The bundle path (or archive entry name) it is about, if any
+	Severity string `json:"severity"`       // SeverityError or SeverityWarning, by code
+}
+
+// NotAnAppError is returned when a deb has no .app folder. It lists what the deb holds
+// instead, so wrappers can tell the cases apart without parsing the message.
+type NotAnAppError struct {
+	Kinds     []string `json:"kinds"`               // DebKind* constants, most telling first
+	Items     []string `json:"items,omitempty"`     // The bundles and files recognized, e.g. "Library/PreferenceBundles/Foo.bundle"
+	TopLevel  []string `json:"topLevel"`            // Top-level directories of data.tar
+	Wanted    string   `json:"wanted"`              // The bundle folder looked for, BundleExtApp or BundleExtAppex
+	Decoys    []string `json:"decoys,omitempty"`    // Folders named like one that aren't (see bundlerank.go)
+	Versioned []string `json:"versioned,omitempty"` // Wanted folders renamed with a version, e.g. Applications/Foo.app-1.3
+}
+
+// UniversalMerge is one file lipo-merged from both debs
+type UniversalMerge struct {
+	Path   string   `json:"path"`
+	Arches []string `json:"arches"` // Primary's slices first
+}
+
+// UniversalAsIs is a Mach-O file --universal couldn't or didn't need to merge
+type UniversalAsIs struct {
+	Path   string `json:"path"`
+	From   string `json:"from"` // "primary" or "secondary"
+	Reason string `json:"reason"`
+}
+
+// ResourceFork is a file's resource fork found in an AppleDouble file or an xattr record
+type ResourceFork struct {
+	Path  string `json:"path"`  // The file the fork belongs to
+	Bytes int64  `json:"bytes"` // The fork's size
+}
+
+// Owner is a tar header's ownership
+type Owner struct {
+	User  string `json:"user,omitempty"`  // Uname, when the tar recorded one
+	Group string `json:"group,omitempty"` // Gname
+	UID   int64  `json:"uid"`
+	GID   int64  `json:"gid"`
+}
+
+// Throughput is one stream's bytes and its average rate while it was moving
+type Throughput struct {
+	Bytes   int64   `json:"bytes"`
+	Seconds float64 `json:"seconds"` // From its first byte to its last
+	MBps    float64 `json:"mbps"`    // Bytes / Seconds, in MB (10^6) per second
+}
+
+// EventDocument is one message to the front end; fields are only set for the events that use them
+//
+// Its schema ID is Event.
+type EventDocument struct {
+	Schema  string          `json:"schema"` // schema.Event
+	Event   string          `json:"event"`  // start, phase, progress, warning or result
+	Time    string          `json:"time"`   // RFC 3339 UTC, to the millisecond
+	Tool    string          `json:"tool,omitempty"`
+	Args    []string        `json:"args,omitempty"`
+	Phase   string          `json:"phase,omitempty"` // The progress bar's description, e.g. "Writing IPA"
+	Done    int64           `json:"done,omitempty"`  // Bytes through the phase so far
+	Total   int64           `json:"total,omitempty"` // Bytes the phase will move
+	Warning *Warning        `json:"warning,omitempty"`
+	Status  string          `json:"status,omitempty"` // ok, warnings or error
+	Code    string          `json:"code,omitempty"`   // A failed run's RESULT code
+	Error   string          `json:"error,omitempty"`
+	Result  *ReportDocument `json:"result,omitempty"`
+}
+
+// DepictionDocument is a Sileo native depiction (a DepictionTabView) plus the values it shows
+//
+// Its schema ID is Depiction.
+type DepictionDocument struct {
+	Schema     string          `json:"schema"`     // schema.Depiction; Sileo ignores it
+	MinVersion string          `json:"minVersion"` // Of Sileo's depiction format, always "0.1"
+	Class      string          `json:"class"`      // "DepictionTabView"
+	Tabs       []DepictionView `json:"tabs"`       // Details, then Changelog
+	Info       DepictionInfo   `json:"info"`       // Ignored by Sileo
+}
+
+// DepictionView is one Sileo view; only the fields its class uses are set
+type DepictionView struct {
+	Class    string          `json:"class"`
+	TabName  string          `json:"tabname,omitempty"`  // DepictionStackView as a tab
+	Views    []DepictionView `json:"views,omitempty"`    // DepictionStackView
+	Title    string          `json:"title,omitempty"`    // DepictionTableTextView, DepictionSubheaderView
+	Text     string          `json:"text,omitempty"`     // DepictionTableTextView
+	Markdown string          `json:"markdown,omitempty"` // DepictionMarkdownView
+}
+
+// DepictionInfo is where each depicted value comes from
+type DepictionInfo struct {
+	Name        string `json:"name"`                  // CFBundleDisplayName, else CFBundleName, else the .app folder's name
+	BundleID    string `json:"bundleID,omitempty"`    // CFBundleIdentifier, after --bundle-id
+	Version     string `json:"version,omitempty"`     // CFBundleShortVersionString, else CFBundleVersion
+	Build       string `json:"build,omitempty"`       // CFBundleVersion
+	Size        int64  `json:"size,omitempty"`        // The IPA's size in bytes; absent with --extract-to
+	MinOS       string `json:"minOS,omitempty"`       // The executable's load commands, else MinimumOSVersion
+	Icon        string `json:"icon,omitempty"`        // The --export-icon PNG, when one was written
+	Description string `json:"description,omitempty"` // The control file's Description
+	Author      string `json:"author,omitempty"`      // Its Author, else Maintainer, without the email
+	Package     string `json:"package,omitempty"`     // Its Package
+}
+
+// DoctorDocument is everything doctor found out about an input
+//
+// Its schema ID is Doctor.
+type DoctorDocument struct {
+	Schema       string           `json:"schema"` // schema.Doctor
+	Input        string           `json:"input"`
+	Kind         string           `json:"kind,omitempty"`  // deb, tarball, zip or directory
+	Size         int64            `json:"size,omitempty"`  // Of the input file
+	Magic        string           `json:"magic,omitempty"` // Its first bytes, in hex
+	Members      []DoctorMember   `json:"arMembers,omitempty"`
+	Decompressor string           `json:"decompressor,omitempty"` // e.g. "xz", with its dictionary size
+	TarEntries   []DoctorTarEntry `json:"tarEntries,omitempty"`   // The first doctorTarEntries
+	TotalEntries int              `json:"totalEntries,omitempty"`
+	AppPrefixes  []string         `json:"appPrefixes,omitempty"` // Every .app folder, in archive order
+	AppPrefix    string           `json:"appPrefix,omitempty"`   // The one a conversion picks
+	InfoPlists   []DoctorPlist    `json:"infoPlists,omitempty"`
+	Executable   *DoctorBinary    `json:"executable,omitempty"`
+	Permissions  []DoctorMode     `json:"permissions,omitempty"` // The executable, Info.plist and Mach-O files
+	Warnings     []Warning        `json:"warnings,omitempty"`    // What the stages would have warned about
+	Stages       []DoctorStage    `json:"stages"`
+	Error        *DoctorStage     `json:"error,omitempty"` // The first stage that failed
+}
+
+// DoctorMember is one ar member of a deb
+type DoctorMember struct {
+	Name   string `json:"name"`
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+	Magic  string `json:"magic"` // First bytes of its data, in hex
+}
+
+// DoctorTarEntry is one data.tar header
+type DoctorTarEntry struct {
+	Name string `json:"name"`
+	Type string `json:"type"` // file, dir, symlink or the tar type flag
+	Size int64  `json:"size"`
+	Link string `json:"link,omitempty"`
+}
+
+// DoctorPlist is an Info.plist found in the input, and what parsing it gave
+type DoctorPlist struct {
+	Path       string `json:"path"`
+	Main       bool   `json:"main,omitempty"` // The app's own, at the bundle root
+	Format     string `json:"format"`         // xml or binary
+	Bytes      int64  `json:"bytes"`
+	Executable string `json:"executable,omitempty"`
+	BundleID   string `json:"bundleId,omitempty"`
+	Version    string `json:"version,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// DoctorBinary is the main executable as the conversion would resolve it
+type DoctorBinary struct {
+	Name   string        `json:"name"`
+	Source string        `json:"source"` // One of the ExecutableFrom* constants
+	Slices []DoctorSlice `json:"slices,omitempty"`
+	Error  string        `json:"error,omitempty"`
+}
+
+// DoctorMode is the mode a conversion gives a file
+type DoctorMode struct {
+	Path   string `json:"path"`
+	From   string `json:"from"` // As in the input, octal
+	To     string `json:"to"`
+	Reason string `json:"reason,omitempty"` // Why it's executable: by name, or its Mach-O header
+}
+
+// DoctorStage is one step and how it went
+type DoctorStage struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Code   string `json:"code,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// DoctorSlice is one architecture of the main executable
+type DoctorSlice struct {
+	Arch      string `json:"arch"`
+	MinOS     string `json:"minOS,omitempty"`
+	Encrypted bool   `json:"encrypted,omitempty"` // FairPlay: won't run once converted
+}
+
+// DiffDocument is the result of comparing two bundles
+//
+// Its schema ID is Diff.
+type DiffDocument struct {
+	Schema     string        `json:"schema"` // schema.Diff
+	Old        string        `json:"old"`
+	New        string        `json:"new"`
+	OldVersion string        `json:"oldVersion"`
+	NewVersion string        `json:"newVersion"`
+	Added      []FileChange  `json:"added"`
+	Removed    []FileChange  `json:"removed"`
+	Modified   []FileChange  `json:"modified"`
+	InfoPlist  []PlistChange `json:"infoPlist"`
+	OldSize    int64         `json:"oldSize"`
+	NewSize    int64         `json:"newSize"`
+}
+
+// FileChange is an added, removed or modified bundle path
+type FileChange struct {
+	Path    string `json:"path"`
+	OldSize int64  `json:"oldSize,omitempty"`
+	NewSize int64  `json:"newSize,omitempty"`
+}
+
+// PlistChange is a top-level Info.plist key that differs
+type PlistChange struct {
+	Key string `json:"key"`
+	Old any    `json:"old,omitempty"`
+	New any    `json:"new,omitempty"`
+}
+
+// BenchDocument is a whole bench run, as saved with --save and read by --compare
+//
+// Its schema ID is Bench.
+type BenchDocument struct {
+	Schema      string            `json:"schema,omitempty"` // schema.Bench; empty in baselines saved before it
+	GoVersion   string            `json:"goVersion"`
+	OS          string            `json:"os"`
+	Arch        string            `json:"arch"`
+	Results     []BenchResult     `json:"results"`
+	Regressions []BenchRegression `json:"regressions,omitempty"` // With --compare, printed with --json; never saved
+}
+
+// BenchResult is the averaged measurements for one input
+type BenchResult struct {
+	Input      string             `json:"input"`
+	Runs       int                `json:"runs"`
+	InputBytes int64              `json:"inputBytes"`
+	WallMS     float64            `json:"wallMs"`   // Mean per run
+	StagesMS   map[string]float64 `json:"stagesMs"` // Mean per run
+	PeakRSS    int64              `json:"peakRss,omitempty"`
+	Allocs     uint64             `json:"allocs"`     // Mean per run
+	AllocBytes uint64             `json:"allocBytes"` // Mean per run
+	Spills     int                `json:"spills"`
+	MBPerSec   float64            `json:"mbPerSec"` // Input bytes over mean wall time
+}
+
+// BenchRegression is a metric that got worse than --threshold allows
+type BenchRegression struct {
+	Input  string  `json:"input"`
+	Metric string  `json:"metric"`
+	Old    float64 `json:"old"`
+	New    float64 `json:"new"`
+	Change float64 `json:"change"` // Percent
+}
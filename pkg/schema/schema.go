@@ -0,0 +1,104 @@
+// Package schema names and describes the JSON documents deb-to-ipa writes, for programs
+// that read them. Every document carries its schema ID in a top-level "schema" field,
+// "debtoipa.<name>/<version>"; the version goes up only when a change would break a
+// reader (a field removed, renamed or given a new meaning), never for a field added.
+//
+// The document structs in documents.go are generated from the types the tool encodes, so
+// they declare every field it writes; RecipeDocument, which the tool reads, is kept by
+// hand. Bare JSON arrays (lint --json, verify-manifest --json) have nowhere to carry an
+// ID and are not versioned.
+package schema
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The schema ID of each document
+const (
+	Report     = "debtoipa.report/1"      // --report, and a result event's result
+	Depiction  = "debtoipa.depiction/1"   // --depiction
+	Manifest   = "debtoipa.manifest/1"    // <output>.manifest.json from --manifest
+	Event      = "debtoipa.event/1"       // Each --ipc message
+	Doctor     = "debtoipa.doctor/1"      // doctor --json
+	Origin     = "debtoipa.origin/1"      // _deb_origin.json in the bundle, with --embed-origin
+	Diff       = "debtoipa.diff/1"        // diff --json
+	SizeReport = "debtoipa.size-report/1" // --size-report --json
+	Bench      = "debtoipa.bench/1"       // bench --save and --json
+	Recipe     = "debtoipa.recipe/1"      // --recipe and batch --recipes, read rather than written
+)
+
+// Document is one kind of document and where it comes from
+type Document struct {
+	ID          string
+	Description string
+}
+
+// All lists every versioned document, as --schema-versions prints them
+var All = []Document{
+	{Report, "conversion report (--report; the result of a --ipc result event)"},
+	{Depiction, "Sileo native depiction (--depiction)"},
+	{Manifest, "standalone entry manifest (--manifest without --report)"},
+	{Event, "front-end message (--ipc)"},
+	{Doctor, "diagnosis (doctor --json)"},
+	{Origin, "origin record embedded in the bundle (--embed-origin)"},
+	{Diff, "bundle comparison (diff --json)"},
+	{SizeReport, "size breakdown (--size-report --json)"},
+	{Bench, "benchmark run (bench --save, bench --json)"},
+	{Recipe, "conversion recipe, JSON or plain YAML (--recipe, batch --recipes)"},
+}
+
+// Parse splits a schema ID into its name and version
+func Parse(id string) (name string, version int, err error) {
+	name, v, ok := strings.Cut(id, "/")
+	if ok && strings.HasPrefix(name, "debtoipa.") {
+		if version, err = strconv.Atoi(v); err == nil && version > 0 {
+			return name, version, nil
+		}
+	}
+	return "", 0, fmt.Errorf("malformed schema ID %q", id)
+}
+
+// Check accepts a document whose schema field is id when it is one of the wanted IDs, or
+// an older version of one; an empty id, from before documents carried one, passes too
+func Check(id string, want ...string) error {
+	if id == "" {
+		return nil
+	}
+	name, version, err := Parse(id)
+	if err != nil {
+		return err
+	}
+	for _, w := range want {
+		wantName, wantVersion, err := Parse(w)
+		if err != nil || wantName != name {
+			continue
+		}
+		if version > wantVersion {
+			return fmt.Errorf("%s is newer than this deb-to-ipa reads (%s); update deb-to-ipa", id, w)
+		}
+		return nil
+	}
+	return fmt.Errorf("a %s document, not %s", id, strings.Join(want, " or "))
+}
+
+// Header is the field every document starts with, for telling them apart before decoding
+type Header struct {
+	Schema string `json:"schema"`
+}
+
+// RecipeMatch says which packages a recipe is for; each field is a glob, and an empty one
+// matches any
+type RecipeMatch struct {
+	Package  string `json:"package,omitempty"`  // control's Package
+	BundleID string `json:"bundleId,omitempty"` // Info.plist's CFBundleIdentifier
+}
+
+// RecipeDocument is a conversion recipe (Recipe) as JSON; a YAML recipe has the same fields
+type RecipeDocument struct {
+	Schema      string         `json:"schema,omitempty"`
+	Description string         `json:"description,omitempty"`
+	Match       *RecipeMatch   `json:"match,omitempty"`   // Needed in a batch --recipes directory
+	Options     map[string]any `json:"options,omitempty"` // Flag names, without dashes, to a value, or a list for a repeatable flag
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// plistValue returns the string value of a top-level key in an XML Info.plist, or "".
+// Unlike the Plist struct it walks the tokens, so non-string values (arrays, bools,
+// nested dicts) between keys don't shift keys and values out of line.
+func plistValue(infoPlistData []byte, key string) string {
+	if isBinaryPlist(infoPlistData) {
+		value, _ := parsePlistDict(infoPlistData)[key].(string)
+		return value
+	}
+	decoder := xml.NewDecoder(bytes.NewReader(infoPlistData))
+	depth := 0 // Element depth, where the top-level dict's children sit at 3 (plist > dict > key)
+	wantNext := false
+
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return ""
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			if depth != 3 {
+				continue
+			}
+			if t.Name.Local == "key" {
+				var k string
+				if err := decoder.DecodeElement(&k, &t); err != nil {
+					return ""
+				}
+				depth--
+				wantNext = strings.TrimSpace(k) == key
+				continue
+			}
+			if wantNext {
+				if t.Name.Local != "string" {
+					return ""
+				}
+				var v string
+				if err := decoder.DecodeElement(&v, &t); err != nil {
+					return ""
+				}
+				return v
+			}
+		case xml.EndElement:
+			depth--
+		}
+	}
+}
+
+// parsePlist decodes an XML or binary plist into Go values: dict -> map[string]any, array -> []any,
+// string -> string, date -> time.Time, integer -> int64, real -> float64, true/false -> bool,
+// data -> []byte.
+func parsePlist(data []byte) (any, error) {
+	if isBinaryPlist(data) {
+		return parseBinaryPlist(data)
+	}
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		if start, ok := token.(xml.StartElement); ok && start.Name.Local != "plist" {
+			return decodePlistValue(decoder, start)
+		}
+	}
+}
+
+// parsePlistDict decodes a plist whose root is a dict, returning nil if it isn't one
+func parsePlistDict(data []byte) map[string]any {
+	value, err := parsePlist(data)
+	if err != nil {
+		return nil
+	}
+	dict, _ := value.(map[string]any)
+	return dict
+}
+
+func decodePlistValue(decoder *xml.Decoder, start xml.StartElement) (any, error) {
+	switch start.Name.Local {
+	case "dict":
+		dict := make(map[string]any)
+		key := ""
+		for {
+			token, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			switch t := token.(type) {
+			case xml.StartElement:
+				if t.Name.Local == "key" {
+					if err := decoder.DecodeElement(&key, &t); err != nil {
+						return nil, err
+					}
+					continue
+				}
+				value, err := decodePlistValue(decoder, t)
+				if err != nil {
+					return nil, err
+				}
+				dict[key] = value
+			case xml.EndElement:
+				return dict, nil
+			}
+		}
+	case "array":
+		array := []any{}
+		for {
+			token, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			switch t := token.(type) {
+			case xml.StartElement:
+				value, err := decodePlistValue(decoder, t)
+				if err != nil {
+					return nil, err
+				}
+				array = append(array, value)
+			case xml.EndElement:
+				return array, nil
+			}
+		}
+	case "true", "false":
+		if err := decoder.Skip(); err != nil {
+			return nil, err
+		}
+		return start.Name.Local == "true", nil
+	}
+
+	var text string
+	if err := decoder.DecodeElement(&text, &start); err != nil {
+		return nil, err
+	}
+	switch start.Name.Local {
+	case "integer":
+		return strconv.ParseInt(strings.TrimSpace(text), 10, 64)
+	case "real":
+		return strconv.ParseFloat(strings.TrimSpace(text), 64)
+	case "data":
+		return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(text), ""))
+	case "date":
+		if t, err := time.Parse(time.RFC3339, strings.TrimSpace(text)); err == nil {
+			return t, nil
+		}
+		return text, nil
+	default:
+		return text, nil
+	}
+}
+
+// plistPath walks nested dicts by key, e.g. plistPath(info, "CFBundleIcons", "CFBundlePrimaryIcon")
+func plistPath(value any, keys ...string) any {
+	for _, key := range keys {
+		dict, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		value = dict[key]
+	}
+	return value
+}
+
+// plistStrings returns the strings in a plist array value (or a lone string)
+func plistStrings(value any) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		var out []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// setPlistKeys sets top-level keys of the dict plist at bundle path name, keeping its
+// format (XML or binary). The document is re-encoded, so XML comes back with Xcode-style
+// formatting and sorted keys.
+func setPlistKeys(name string, data []byte, values map[string]any) ([]byte, error) {
+	root, err := parsePlist(data)
+	if err != nil {
+		return nil, err
+	}
+	dict, ok := root.(map[string]any)
+	if !ok {
+		return nil, errors.New("plist root is not a dict")
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys) // So the trace lists edits in a stable order
+	for _, key := range keys {
+		value := values[key]
+		old := "" // Left out of the trace when the key wasn't set
+		if v, ok := dict[key]; ok {
+			old = fmt.Sprint(v)
+		}
+		trace.event("plist-edit", "path", name, "key", key, "old", old, "new", fmt.Sprint(value))
+		dict[key] = value
+	}
+	if isBinaryPlist(data) {
+		return encodeBinaryPlist(dict)
+	}
+	return encodeXMLPlist(dict)
+}
+
+// encodeXMLPlist writes a value (of the types parsePlist produces) as an XML plist
+func encodeXMLPlist(value any) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	buf.WriteString(`<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">` + "\n")
+	buf.WriteString(`<plist version="1.0">` + "\n")
+	if err := encodeXMLPlistValue(&buf, value, 0); err != nil {
+		return nil, err
+	}
+	buf.WriteString("</plist>\n")
+	return buf.Bytes(), nil
+}
+
+func encodeXMLPlistValue(buf *bytes.Buffer, value any, depth int) error {
+	indent := strings.Repeat("\t", depth)
+	element := func(name, text string) {
+		buf.WriteString(indent + "<" + name + ">")
+		xml.EscapeText(buf, []byte(text))
+		buf.WriteString("</" + name + ">\n")
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		if len(v) == 0 {
+			buf.WriteString(indent + "<dict/>\n")
+			return nil
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		buf.WriteString(indent + "<dict>\n")
+		for _, k := range keys {
+			buf.WriteString(indent + "\t<key>")
+			xml.EscapeText(buf, []byte(k))
+			buf.WriteString("</key>\n")
+			if err := encodeXMLPlistValue(buf, v[k], depth+1); err != nil {
+				return err
+			}
+		}
+		buf.WriteString(indent + "</dict>\n")
+	case []any:
+		if len(v) == 0 {
+			buf.WriteString(indent + "<array/>\n")
+			return nil
+		}
+		buf.WriteString(indent + "<array>\n")
+		for _, item := range v {
+			if err := encodeXMLPlistValue(buf, item, depth+1); err != nil {
+				return err
+			}
+		}
+		buf.WriteString(indent + "</array>\n")
+	case string:
+		element("string", v)
+	case bool:
+		if v {
+			buf.WriteString(indent + "<true/>\n")
+		} else {
+			buf.WriteString(indent + "<false/>\n")
+		}
+	case int:
+		element("integer", strconv.Itoa(v))
+	case int64:
+		element("integer", strconv.FormatInt(v, 10))
+	case float64:
+		element("real", strconv.FormatFloat(v, 'g', -1, 64))
+	case time.Time:
+		element("date", v.UTC().Format("2006-01-02T15:04:05Z"))
+	case []byte:
+		element("data", base64.StdEncoding.EncodeToString(v))
+	default:
+		return fmt.Errorf("plist: can't encode %T", value)
+	}
+	return nil
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"strings"
+)
+
+// --- Apple "optimized" PNGs (CgBI) ---
+// Xcode's pngcrush rewrites PNGs with a CgBI chunk before IHDR, raw deflate IDAT data
+// (no zlib header or checksum) and premultiplied BGRA pixels. Only Apple's decoder reads them.
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+type pngChunk struct {
+	Type string
+	Data []byte
+}
+
+// isCgBI reports whether data is an Apple-optimized PNG
+func isCgBI(data []byte) bool {
+	return len(data) >= 16 && bytes.Equal(data[:8], pngSignature) && string(data[12:16]) == "CgBI"
+}
+
+// decodePNG decodes a standard or CgBI PNG
+func decodePNG(data []byte) (image.Image, error) {
+	if isCgBI(data) {
+		return decodeCgBI(data)
+	}
+	return png.Decode(bytes.NewReader(data))
+}
+
+// pngDimensions returns a PNG's size from its IHDR without decoding pixels
+func pngDimensions(data []byte) (width, height int, err error) {
+	chunks, err := readPNGChunks(data)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, c := range chunks {
+		if c.Type == "IHDR" && len(c.Data) >= 8 {
+			return int(binary.BigEndian.Uint32(c.Data[0:4])), int(binary.BigEndian.Uint32(c.Data[4:8])), nil
+		}
+	}
+	return 0, 0, errors.New("png: missing IHDR")
+}
+
+// normalizeCgBI converts an Apple-optimized PNG into a standard one
+func normalizeCgBI(data []byte) ([]byte, error) {
+	img, err := decodeCgBI(data)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := (&png.Encoder{CompressionLevel: png.BestCompression}).Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func readPNGChunks(data []byte) ([]pngChunk, error) {
+	if len(data) < 8 || !bytes.Equal(data[:8], pngSignature) {
+		return nil, errors.New("png: bad signature")
+	}
+	var chunks []pngChunk
+	pos := 8
+	for pos+8 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[pos : pos+4]))
+		if length < 0 || pos+12+length > len(data) {
+			return nil, errors.New("png: truncated chunk")
+		}
+		chunk := pngChunk{Type: string(data[pos+4 : pos+8]), Data: data[pos+8 : pos+8+length]}
+		chunks = append(chunks, chunk)
+		pos += 12 + length // length + type + data + crc
+		if chunk.Type == "IEND" {
+			break
+		}
+	}
+	return chunks, nil
+}
+
+// decodeCgBI inflates, unfilters and un-premultiplies a CgBI PNG into a standard image
+func decodeCgBI(data []byte) (image.Image, error) {
+	chunks, err := readPNGChunks(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var width, height int
+	var bitDepth, colorType, interlace byte
+	var idat bytes.Buffer
+	for _, c := range chunks {
+		switch c.Type {
+		case "IHDR":
+			if len(c.Data) < 13 {
+				return nil, errors.New("png: short IHDR")
+			}
+			width = int(binary.BigEndian.Uint32(c.Data[0:4]))
+			height = int(binary.BigEndian.Uint32(c.Data[4:8]))
+			bitDepth, colorType, interlace = c.Data[8], c.Data[9], c.Data[12]
+		case "IDAT":
+			idat.Write(c.Data)
+		}
+	}
+
+	if bitDepth != 8 || (colorType != 6 && colorType != 2) {
+		return nil, fmt.Errorf("png: unsupported CgBI format (depth %d, color type %d)", bitDepth, colorType)
+	}
+	if interlace != 0 {
+		return nil, errors.New("png: interlaced CgBI images are not supported")
+	}
+	if width <= 0 || height <= 0 || width > 1<<14 || height > 1<<14 {
+		return nil, fmt.Errorf("png: implausible size %dx%d", width, height)
+	}
+
+	bpp := 4
+	if colorType == 2 {
+		bpp = 3
+	}
+	stride := width * bpp
+
+	// Each row is a filter byte and stride bytes; more than that is a bomb, not an icon
+	size := height * (stride + 1)
+	raw, err := io.ReadAll(io.LimitReader(flate.NewReader(&idat), int64(size)+1))
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, fmt.Errorf("png: inflating CgBI data: %w", err)
+	}
+	if len(raw) < size {
+		return nil, errors.New("png: CgBI image data is truncated")
+	}
+	if len(raw) > size {
+		return nil, errors.New("png: CgBI image data inflates past its dimensions")
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	prev := make([]byte, stride)
+	for y := 0; y < height; y++ {
+		row := raw[y*(stride+1) : (y+1)*(stride+1)]
+		line := row[1:]
+		if err := unfilterPNGRow(row[0], line, prev, bpp); err != nil {
+			return nil, err
+		}
+
+		out := img.Pix[y*img.Stride:]
+		for x := 0; x < width; x++ {
+			b, g, r := line[x*bpp], line[x*bpp+1], line[x*bpp+2]
+			a := byte(255)
+			if bpp == 4 {
+				a = line[x*bpp+3]
+			}
+			// Premultiplied -> straight alpha
+			if a != 0 && a != 255 {
+				r = byte(min(255, int(r)*255/int(a)))
+				g = byte(min(255, int(g)*255/int(a)))
+				b = byte(min(255, int(b)*255/int(a)))
+			}
+			out[x*4], out[x*4+1], out[x*4+2], out[x*4+3] = r, g, b, a
+		}
+		prev = line
+	}
+
+	return img, nil
+}
+
+// unfilterPNGRow reverses the PNG filter on one scanline in place
+func unfilterPNGRow(filter byte, line, prev []byte, bpp int) error {
+	switch filter {
+	case 0: // None
+	case 1: // Sub
+		for i := bpp; i < len(line); i++ {
+			line[i] += line[i-bpp]
+		}
+	case 2: // Up
+		for i := range line {
+			line[i] += prev[i]
+		}
+	case 3: // Average
+		for i := range line {
+			var left byte
+			if i >= bpp {
+				left = line[i-bpp]
+			}
+			line[i] += byte((int(left) + int(prev[i])) / 2)
+		}
+	case 4: // Paeth
+		for i := range line {
+			var a, c byte
+			if i >= bpp {
+				a, c = line[i-bpp], prev[i-bpp]
+			}
+			line[i] += paeth(a, prev[i], c)
+		}
+	default:
+		return fmt.Errorf("png: unknown filter type %d", filter)
+	}
+	return nil
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	if pa <= pb && pa <= pc {
+		return a
+	}
+	if pb <= pc {
+		return b
+	}
+	return c
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// scaleImage resizes an image with bilinear filtering
+func scaleImage(src image.Image, width, height int) *image.NRGBA {
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+
+	sample := func(x, y int) [4]float64 {
+		r, g, bl, a := src.At(b.Min.X+x, b.Min.Y+y).RGBA()
+		return [4]float64{float64(r), float64(g), float64(bl), float64(a)}
+	}
+
+	for y := 0; y < height; y++ {
+		fy := (float64(y)+0.5)*float64(sh)/float64(height) - 0.5
+		y0 := max(0, min(sh-1, int(fy)))
+		y1 := min(sh-1, y0+1)
+		dy := max(0, fy-float64(y0))
+
+		for x := 0; x < width; x++ {
+			fx := (float64(x)+0.5)*float64(sw)/float64(width) - 0.5
+			x0 := max(0, min(sw-1, int(fx)))
+			x1 := min(sw-1, x0+1)
+			dx := max(0, fx-float64(x0))
+
+			p00, p10, p01, p11 := sample(x0, y0), sample(x1, y0), sample(x0, y1), sample(x1, y1)
+			var px [4]float64
+			for i := range px {
+				top := p00[i]*(1-dx) + p10[i]*dx
+				bottom := p01[i]*(1-dx) + p11[i]*dx
+				px[i] = top*(1-dy) + bottom*dy
+			}
+
+			// RGBA() is premultiplied 16-bit; NRGBA wants straight 8-bit
+			i := dst.PixOffset(x, y)
+			a := px[3]
+			if a > 0 {
+				dst.Pix[i] = uint8(min(255, px[0]*255/a))
+				dst.Pix[i+1] = uint8(min(255, px[1]*255/a))
+				dst.Pix[i+2] = uint8(min(255, px[2]*255/a))
+			}
+			dst.Pix[i+3] = uint8(a / 257)
+		}
+	}
+	return dst
+}
+
+// normalizePNGs rewrites every Apple-optimized PNG in the bundle as a standard PNG,
+// returning how many were converted. Broken PNGs are left as they are, with a warning.
+func normalizePNGs(entries []BundleEntry, store *SpillStore, warnings *warningLog) int {
+	converted := 0
+	var before, after int64
+
+	for _, entry := range entries {
+		vf := entry.File
+		if vf.IsDir || vf.IsLink || !strings.HasSuffix(strings.ToLower(entry.RelPath), ".png") {
+			continue
+		}
+
+		// Sniff the header first so spilled files aren't read in full for nothing
+		rc, err := vf.Open()
+		if err != nil {
+			continue
+		}
+		head := make([]byte, 16)
+		n, _ := io.ReadFull(rc, head)
+		rc.Close()
+		if !isCgBI(head[:n]) {
+			continue
+		}
+
+		data, err := readAll(vf)
+		if err != nil {
+			warnings.add("png-normalize", entry.RelPath, "Could not read %s: %v", entry.RelPath, err)
+			continue
+		}
+		normalized, err := normalizeCgBI(data)
+		if err != nil {
+			warnings.add("png-normalize", entry.RelPath, "Left %s as is: %v", entry.RelPath, err)
+			continue
+		}
+		if err := store.Replace(vf, normalized); err != nil {
+			warnings.add("png-normalize", entry.RelPath, "Left %s as is: %v", entry.RelPath, err)
+			continue
+		}
+
+		converted++
+		before += int64(len(data))
+		after += int64(len(normalized))
+	}
+
+	if converted > 0 {
+		fmt.Printf("   Normalized %d CgBI PNG(s) (%d -> %d bytes)\n", converted, before, after)
+	}
+	return converted
+}
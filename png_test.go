@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// cgbiPNG builds a 4x4 RGBA CgBI PNG whose IDAT inflates to raw
+func cgbiPNG(raw []byte) []byte {
+	var b bytes.Buffer
+	chunk := func(typ string, data []byte) {
+		binary.Write(&b, binary.BigEndian, uint32(len(data)))
+		b.WriteString(typ)
+		b.Write(data)
+		b.Write([]byte{0, 0, 0, 0}) // CRC, not checked
+	}
+	b.Write(pngSignature)
+	chunk("CgBI", []byte{0x50, 0, 0x20, 0x06})
+	chunk("IHDR", []byte{0, 0, 0, 4, 0, 0, 0, 4, 8, 6, 0, 0, 0})
+	var idat bytes.Buffer
+	fw, _ := flate.NewWriter(&idat, flate.BestCompression)
+	fw.Write(raw)
+	fw.Close()
+	chunk("IDAT", idat.Bytes())
+	chunk("IEND", nil)
+	return b.Bytes()
+}
+
+// TestCgBIInflateLimit decodes CgBI PNGs whose pixel data is exactly the size the header
+// says and far past it: only the first decodes
+func TestCgBIInflateLimit(t *testing.T) {
+	if _, err := decodeCgBI(cgbiPNG(make([]byte, 4*(4*4+1)))); err != nil {
+		t.Fatalf("a 4x4 CgBI PNG: %v", err)
+	}
+	_, err := decodeCgBI(cgbiPNG(make([]byte, 8<<20)))
+	if err == nil || !strings.Contains(err.Error(), "inflates past") {
+		t.Errorf("a 4x4 CgBI PNG inflating to 8 MiB: %v, want it refused", err)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// ProfileInfo is what the report records about an embedded provisioning profile
+type ProfileInfo struct {
+	Name            string    `json:"name,omitempty"`
+	UUID            string    `json:"uuid,omitempty"`
+	TeamID          string    `json:"teamId,omitempty"`
+	AppID           string    `json:"appId,omitempty"` // application-identifier, e.g. "ABCDE12345.com.example.*"
+	Expires         time.Time `json:"expires"`
+	Expired         bool      `json:"expired,omitempty"`
+	Devices         int       `json:"devices,omitempty"` // ProvisionedDevices count, 0 for enterprise/App Store
+	MatchesBundleID bool      `json:"matchesBundleId"`
+}
+
+// profileContent pulls the signed plist out of a .mobileprovision (CMS SignedData).
+// Profiles are sometimes BER with indefinite lengths, which encoding/asn1 rejects, so
+// failing a DER parse we fall back to locating the XML plist in the raw bytes.
+func profileContent(data []byte) ([]byte, error) {
+	var contentInfo struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue `asn1:"explicit,tag:0"`
+	}
+	if _, err := asn1.Unmarshal(data, &contentInfo); err == nil {
+		var signedData struct {
+			Version          int
+			DigestAlgorithms asn1.RawValue
+			EncapContentInfo struct {
+				ContentType asn1.ObjectIdentifier
+				Content     []byte `asn1:"explicit,tag:0"`
+			}
+			Rest asn1.RawValue `asn1:"optional"`
+		}
+		if _, err := asn1.Unmarshal(contentInfo.Content.Bytes, &signedData); err == nil && len(signedData.EncapContentInfo.Content) > 0 {
+			return signedData.EncapContentInfo.Content, nil
+		}
+	}
+
+	start := bytes.Index(data, []byte("<?xml"))
+	end := bytes.Index(data, []byte("</plist>"))
+	if start < 0 || end < start {
+		return nil, errors.New("no plist found in provisioning profile")
+	}
+	return data[start : end+len("</plist>")], nil
+}
+
+// readProvisioningProfile loads a .mobileprovision and checks it against the app's bundle ID
+func readProvisioningProfile(profilePath, bundleID string) ([]byte, *ProfileInfo, error) {
+	data, err := os.ReadFile(profilePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	content, err := profileContent(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", profilePath, err)
+	}
+	profile := parsePlistDict(content)
+	if profile == nil {
+		return nil, nil, fmt.Errorf("%s: profile plist is not a dict", profilePath)
+	}
+
+	info := &ProfileInfo{}
+	info.Name, _ = profile["Name"].(string)
+	info.UUID, _ = profile["UUID"].(string)
+	if teams := plistStrings(profile["TeamIdentifier"]); len(teams) > 0 {
+		info.TeamID = teams[0]
+	}
+	info.AppID, _ = plistPath(profile, "Entitlements", "application-identifier").(string)
+	if expires, ok := profile["ExpirationDate"].(time.Time); ok {
+		info.Expires = expires
+		info.Expired = time.Now().After(expires)
+	}
+	if devices, ok := profile["ProvisionedDevices"].([]any); ok {
+		info.Devices = len(devices)
+	}
+	info.MatchesBundleID = appIDMatches(info.AppID, info.TeamID, bundleID)
+
+	return data, info, nil
+}
+
+// appIDMatches reports whether an application-identifier ("TEAMID.com.example.app", or a
+// wildcard like "TEAMID.com.example.*" or "TEAMID.*") covers bundleID
+func appIDMatches(appID, teamID, bundleID string) bool {
+	pattern := appID
+	if teamID != "" && strings.HasPrefix(pattern, teamID+".") {
+		pattern = strings.TrimPrefix(pattern, teamID+".")
+	} else if i := strings.Index(pattern, "."); i >= 0 {
+		pattern = pattern[i+1:]
+	}
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(bundleID, strings.TrimSuffix(pattern, "*"))
+	}
+	return pattern == bundleID
+}
+
+// embedProvisioningProfile places --provisioning-profile at the bundle root as
+// embedded.mobileprovision, replacing any profile the deb shipped, and warns about
+// profiles that won't install this app
+func embedProvisioningProfile(entries []BundleEntry, opts Options, bundleID string, warnings *warningLog) ([]BundleEntry, *ProfileInfo, error) {
+	data, info, err := readProvisioningProfile(opts.ProvisioningProfile, bundleID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fmt.Printf("   Profile: %s (team %s, app ID %s, expires %s)\n",
+		info.Name, info.TeamID, info.AppID, info.Expires.Format("2006-01-02"))
+	if info.Expired {
+		warnings.add("profile-expired", "embedded.mobileprovision", "The provisioning profile expired on %s", info.Expires.Format("2006-01-02"))
+	}
+	if !info.MatchesBundleID {
+		warnings.add("profile-app-id", "embedded.mobileprovision", "Profile app ID %s doesn't cover bundle ID %s", info.AppID, bundleID)
+	}
+
+	vf := &VirtualFile{Name: opts.ProvisioningProfile, Data: data, Size: int64(len(data)), Mode: 0644, ModTime: time.Now()}
+	for i, entry := range entries {
+		if entry.RelPath == "embedded.mobileprovision" {
+			fmt.Println("   Replacing the deb's embedded.mobileprovision")
+			entries[i].File = vf
+			return entries, info, nil
+		}
+	}
+	return append(entries, BundleEntry{File: vf, RelPath: "embedded.mobileprovision"}), info, nil
+}
@@ -0,0 +1,723 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"deb-to-ipa/pkg/schema"
+)
+
+// --- Recipes: a package's conversion settings in a file ---
+// A recipe holds the transforms one app needs (plist edits, excludes, injections, renames,
+// signing options) so they live beside its debs instead of in a shell script:
+//
+//	schema: debtoipa.recipe/1
+//	description: Sideload build for iOS 14
+//	match:
+//	  package: com.example.app   # control's Package, a glob
+//	  bundleId: com.example.*    # Info.plist's CFBundleIdentifier, a glob
+//	options:
+//	  min-os: "14.0"
+//	  bundle-id: com.me.example
+//	  exclude: ["**/*.car", PlugIns/Widget.appex]
+//	  exclude-framework:
+//	    - FLEX
+//	  strict: true
+//
+// Options are flag names with the values they'd take on the command line, a list for a
+// repeatable flag, and are set through the same flag set that fills Options: whatever a
+// flag can do a recipe can, and a new flag needs nothing here. Flags on the command line
+// win, and a recipe wins over --options-from. Recipes are JSON, or the plain part of YAML
+// shown above: mappings, lists (block or [a, b]), quoted or plain scalars, # comments.
+// One that doesn't parse, or names a flag or value the tool refuses, fails the run before
+// the input is opened, with its file, line and field. batch --recipes <dir> gives each
+// input the recipe whose match fits it; --recipe warns when its match doesn't.
+
+// RecipeError is a recipe that can't be used, and where
+type RecipeError struct {
+	Path  string
+	Line  int
+	Field string // e.g. "options.min-os"; "" for a syntax error
+	Err   error
+}
+
+func (e *RecipeError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("%s:%d: %v", e.Path, e.Line, e.Err)
+	}
+	return fmt.Sprintf("%s:%d: %s: %v", e.Path, e.Line, e.Field, e.Err)
+}
+
+func (e *RecipeError) Unwrap() error { return e.Err }
+
+// Recipe is a --recipe file, read and checked
+type Recipe struct {
+	Path        string
+	Description string
+	Match       RecipeMatch
+	Options     []recipeOption
+}
+
+// RecipeMatch says which packages a recipe is for; an empty field matches any
+type RecipeMatch struct {
+	Package  string // Glob on control's Package
+	BundleID string // Glob on Info.plist's CFBundleIdentifier
+}
+
+// matches reports whether the package with this control Package and bundle ID is one the
+// recipe is for
+func (m RecipeMatch) matches(pkg, bundleID string) bool {
+	return recipeGlobMatch(m.Package, pkg) && recipeGlobMatch(m.BundleID, bundleID)
+}
+
+func (m RecipeMatch) String() string {
+	var parts []string
+	if m.Package != "" {
+		parts = append(parts, "package "+m.Package)
+	}
+	if m.BundleID != "" {
+		parts = append(parts, "bundle ID "+m.BundleID)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// recipeGlobMatch matches a RecipeMatch field; bundle IDs and package names have no "/",
+// so path.Match's "*" spans their dots
+func recipeGlobMatch(pattern, s string) bool {
+	if pattern == "" {
+		return true
+	}
+	ok, _ := path.Match(pattern, s)
+	return ok
+}
+
+// recipeOption is one flag of a recipe and the values to set it to, in order
+type recipeOption struct {
+	Flag   string
+	Values []string
+	Line   int
+}
+
+// loadRecipe reads and checks a recipe: its syntax, fields and match globs. Its options
+// are checked against the flags when applied.
+func loadRecipe(recipePath string) (*Recipe, error) {
+	data, err := os.ReadFile(recipePath)
+	if err != nil {
+		return nil, fmt.Errorf("--recipe: %w", err)
+	}
+	data = bytes.TrimPrefix(data, []byte("\ufeff"))
+	var root *recipeNode
+	switch ext := strings.ToLower(filepath.Ext(recipePath)); {
+	case ext == ".json", ext != ".yaml" && ext != ".yml" && bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")):
+		root, err = parseRecipeJSON(data)
+	default:
+		root, err = parseRecipeYAML(data)
+	}
+	if err == nil {
+		var recipe *Recipe
+		if recipe, err = decodeRecipe(root); err == nil {
+			recipe.Path = recipePath
+			return recipe, nil
+		}
+	}
+	if re, ok := err.(*RecipeError); ok {
+		re.Path = recipePath
+	}
+	return nil, err
+}
+
+// decodeRecipe checks a parsed recipe's fields and takes out what they say
+func decodeRecipe(root *recipeNode) (*Recipe, error) {
+	if root.Fields == nil {
+		return nil, &RecipeError{Line: root.Line, Err: errors.New("a recipe is a mapping of schema, description, match and options")}
+	}
+	recipe := &Recipe{}
+	for _, field := range root.Fields {
+		switch field.Key {
+		case "schema":
+			id, err := field.Value.scalar(field.Key)
+			if err == nil {
+				err = schema.Check(id, schema.Recipe)
+			}
+			if err != nil {
+				return nil, &RecipeError{Line: field.Line, Field: field.Key, Err: err}
+			}
+		case "description":
+			var err error
+			if recipe.Description, err = field.Value.scalar(field.Key); err != nil {
+				return nil, err
+			}
+		case "match":
+			if err := decodeRecipeMatch(field.Value, &recipe.Match); err != nil {
+				return nil, err
+			}
+		case "options":
+			if field.Value.Fields == nil && !field.Value.Null {
+				return nil, &RecipeError{Line: field.Line, Field: field.Key, Err: errors.New("want a mapping of flag names to values")}
+			}
+			for _, option := range field.Value.Fields {
+				name := "options." + option.Key
+				if strings.HasPrefix(option.Key, "-") {
+					return nil, &RecipeError{Line: option.Line, Field: name, Err: fmt.Errorf("give the flag's name without dashes, e.g. %s", strings.TrimLeft(option.Key, "-"))}
+				}
+				values, err := option.Value.values(name)
+				if err != nil {
+					return nil, err
+				}
+				recipe.Options = append(recipe.Options, recipeOption{Flag: option.Key, Values: values, Line: option.Line})
+			}
+		default:
+			return nil, &RecipeError{Line: field.Line, Field: field.Key, Err: errors.New("unknown field; a recipe has schema, description, match and options")}
+		}
+	}
+	return recipe, nil
+}
+
+// decodeRecipeMatch reads a recipe's match mapping into m
+func decodeRecipeMatch(node *recipeNode, m *RecipeMatch) error {
+	if node.Fields == nil {
+		return &RecipeError{Line: node.Line, Field: "match", Err: errors.New("want a mapping with package and/or bundleId")}
+	}
+	for _, field := range node.Fields {
+		name := "match." + field.Key
+		var target *string
+		switch field.Key {
+		case "package":
+			target = &m.Package
+		case "bundleId":
+			target = &m.BundleID
+		default:
+			return &RecipeError{Line: field.Line, Field: name, Err: errors.New("unknown field; match has package and bundleId")}
+		}
+		pattern, err := field.Value.scalar(name)
+		if err != nil {
+			return err
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return &RecipeError{Line: field.Line, Field: name, Err: fmt.Errorf("bad glob %q: %w", pattern, err)}
+		}
+		*target = pattern
+	}
+	return nil
+}
+
+// applyRecipe sets the recipe's options on fs, skipping flags already set on the command
+// line. Every flag name is checked before any is set; a value its flag refuses fails there.
+func applyRecipe(fs *flag.FlagSet, recipe *Recipe) error {
+	for _, option := range recipe.Options {
+		field := "options." + option.Flag
+		f := fs.Lookup(option.Flag)
+		switch {
+		case f == nil:
+			return &RecipeError{Path: recipe.Path, Line: option.Line, Field: field, Err: fmt.Errorf("no such flag --%s", option.Flag)}
+		case unreplayedFlags[option.Flag]:
+			return &RecipeError{Path: recipe.Path, Line: option.Line, Field: field, Err: fmt.Errorf("--%s is about one run, not how a package converts; give it on the command line", option.Flag)}
+		case len(option.Values) != 1 && !repeatableFlag(f):
+			return &RecipeError{Path: recipe.Path, Line: option.Line, Field: field, Err: fmt.Errorf("--%s takes one value, not a list", option.Flag)}
+		}
+	}
+
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	applied := 0
+	for _, option := range recipe.Options {
+		if given[option.Flag] {
+			continue
+		}
+		for _, value := range option.Values {
+			if err := fs.Set(option.Flag, value); err != nil {
+				return &RecipeError{Path: recipe.Path, Line: option.Line, Field: "options." + option.Flag, Err: fmt.Errorf("%q: %w", value, err)}
+			}
+		}
+		applied++
+	}
+	fmt.Printf("=> Applied %d of %d options from recipe %s\n", applied, len(recipe.Options), recipe.Path)
+	return nil
+}
+
+// repeatableFlag reports whether each use of a flag adds a value rather than replacing it
+func repeatableFlag(f *flag.Flag) bool {
+	v := f.Value
+	if recorded, ok := v.(*recordedValue); ok {
+		v = recorded.Value
+	}
+	switch v.(type) {
+	case *stringList, methodGlobFlag, execGlobFlag:
+		return true
+	}
+	return false
+}
+
+// --- Recipe directories: batch --recipes ---
+
+// loadRecipeDir reads every recipe in dir, in name order. Each needs a match to be picked by.
+func loadRecipeDir(dir string) ([]*Recipe, error) {
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("--recipes: %w", err)
+	}
+	var recipes []*Recipe
+	for _, de := range dirEntries {
+		switch strings.ToLower(filepath.Ext(de.Name())) {
+		case ".yaml", ".yml", ".json":
+		default:
+			continue
+		}
+		if de.IsDir() {
+			continue
+		}
+		recipe, err := loadRecipe(filepath.Join(dir, de.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if recipe.Match == (RecipeMatch{}) {
+			return nil, &RecipeError{Path: recipe.Path, Line: 1, Field: "match", Err: errors.New("a recipe in --recipes needs a match.package or match.bundleId to be picked by")}
+		}
+		recipes = append(recipes, recipe)
+	}
+	if len(recipes) == 0 {
+		return nil, fmt.Errorf("--recipes: no .yaml, .yml or .json recipes in %s", dir)
+	}
+	sort.Slice(recipes, func(a, b int) bool { return recipes[a].Path < recipes[b].Path })
+	return recipes, nil
+}
+
+// packageIdentity reads the control Package and bundle ID a recipe is matched by, reading
+// the input the way a conversion would, quietly and into a spill directory of its own
+func packageIdentity(input string) (pkg, bundleID string, err error) {
+	tempDir, err := os.MkdirTemp("", "ipa-recipe")
+	if err != nil {
+		return "", "", err
+	}
+	defer os.RemoveAll(tempDir)
+
+	deb, err := readDeb(input, &SpillStore{Dir: tempDir}, readOptions{Quiet: true, TwoPass: useTwoPass(input, false)})
+	if err != nil {
+		return "", "", err
+	}
+	return deb.Control["Package"], plistValue(deb.InfoPlistData, "CFBundleIdentifier"), nil
+}
+
+// pickRecipe returns the first recipe, in name order, matching a package, or nil
+func pickRecipe(recipes []*Recipe, pkg, bundleID string) *Recipe {
+	for _, recipe := range recipes {
+		if recipe.Match.matches(pkg, bundleID) {
+			return recipe
+		}
+	}
+	return nil
+}
+
+// --- Recipe syntax: JSON and plain YAML ---
+// Both parse to the same tree of recipeNodes, each knowing its line for errors.
+
+// recipeNode is a parsed value: a scalar, a list or a mapping
+type recipeNode struct {
+	Line   int
+	Null   bool          // null, ~ or nothing after "key:"
+	Scalar string        // A scalar's text; true, false and numbers as written
+	IsList bool          // A list, of List
+	List   []*recipeNode //
+	Fields []recipeField // A mapping's fields, in order; nil for a scalar or list
+}
+
+// recipeField is one key of a mapping and its value
+type recipeField struct {
+	Key   string
+	Line  int
+	Value *recipeNode
+}
+
+// scalar is the node's text, when it is a scalar
+func (n *recipeNode) scalar(field string) (string, error) {
+	if n.Null || n.IsList || n.Fields != nil {
+		return "", &RecipeError{Line: n.Line, Field: field, Err: errors.New("want a single value")}
+	}
+	return n.Scalar, nil
+}
+
+// values is a scalar's text, or the texts of a list of scalars
+func (n *recipeNode) values(field string) ([]string, error) {
+	if !n.IsList {
+		s, err := n.scalar(field)
+		return []string{s}, err
+	}
+	values := make([]string, 0, len(n.List))
+	for _, item := range n.List {
+		s, err := item.scalar(field)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, s)
+	}
+	return values, nil
+}
+
+// addField adds a mapping field, refusing a key given twice
+func (n *recipeNode) addField(key string, line int, value *recipeNode) error {
+	for _, field := range n.Fields {
+		if field.Key == key {
+			return &RecipeError{Line: line, Field: key, Err: fmt.Errorf("given twice (first on line %d)", field.Line)}
+		}
+	}
+	n.Fields = append(n.Fields, recipeField{Key: key, Line: line, Value: value})
+	return nil
+}
+
+// parseRecipeJSON parses a JSON recipe token by token, keeping key order and lines
+func parseRecipeJSON(data []byte) (*recipeNode, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	// lineAt is the line of the next token at or after offset, past the separators Token skips
+	lineAt := func(offset int64) int {
+		for offset < int64(len(data)) && strings.IndexByte(" \t\r\n:,", data[offset]) >= 0 {
+			offset++
+		}
+		return 1 + bytes.Count(data[:offset], []byte("\n"))
+	}
+	syntaxError := func(err error) error {
+		offset := int64(len(data))
+		if se, ok := err.(*json.SyntaxError); ok {
+			offset = min(se.Offset, offset)
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return &RecipeError{Line: 1 + bytes.Count(data[:offset], []byte("\n")), Err: err}
+	}
+
+	var value func() (*recipeNode, error)
+	value = func() (*recipeNode, error) {
+		line := lineAt(dec.InputOffset())
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, syntaxError(err)
+		}
+		node := &recipeNode{Line: line}
+		switch t := tok.(type) {
+		case json.Delim:
+			if t == '[' {
+				node.IsList = true
+			} else {
+				node.Fields = []recipeField{}
+			}
+			for dec.More() {
+				if node.IsList {
+					item, err := value()
+					if err != nil {
+						return nil, err
+					}
+					node.List = append(node.List, item)
+					continue
+				}
+				keyLine := lineAt(dec.InputOffset())
+				keyTok, err := dec.Token()
+				if err != nil {
+					return nil, syntaxError(err)
+				}
+				fieldValue, err := value()
+				if err != nil {
+					return nil, err
+				}
+				if err := node.addField(keyTok.(string), keyLine, fieldValue); err != nil {
+					return nil, err
+				}
+			}
+			if _, err := dec.Token(); err != nil {
+				return nil, syntaxError(err)
+			}
+		case string:
+			node.Scalar = t
+		case json.Number:
+			node.Scalar = t.String()
+		case bool:
+			node.Scalar = strconv.FormatBool(t)
+		case nil:
+			node.Null = true
+		}
+		return node, nil
+	}
+
+	root, err := value()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, &RecipeError{Line: lineAt(dec.InputOffset()), Err: errors.New("more after the recipe's closing }")}
+	}
+	return root, nil
+}
+
+// yamlLine is a YAML line with content
+type yamlLine struct {
+	Num    int
+	Indent int
+	Text   string // Without its indent or a comment
+}
+
+// yamlParser reads the block structure of yamlLines, one node per indent level
+type yamlParser struct {
+	lines []yamlLine
+	i     int
+}
+
+// parseRecipeYAML parses the plain part of YAML a recipe may be written in. Anchors, tags,
+// flow mappings, multi-line scalars and more than one document are refused, not guessed at.
+func parseRecipeYAML(data []byte) (*recipeNode, error) {
+	var lines []yamlLine
+	for i, raw := range strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n") {
+		num := i + 1
+		text := strings.TrimRight(stripYAMLComment(raw), " \t")
+		body := strings.TrimLeft(text, " ")
+		if body == "" {
+			continue
+		}
+		if body[0] == '\t' {
+			return nil, &RecipeError{Line: num, Err: errors.New("YAML is indented with spaces, not tabs")}
+		}
+		if body == "---" || body == "..." {
+			if len(lines) == 0 && body == "---" {
+				continue
+			}
+			return nil, &RecipeError{Line: num, Err: errors.New("a recipe is one YAML document")}
+		}
+		lines = append(lines, yamlLine{Num: num, Indent: len(text) - len(body), Text: body})
+	}
+	if len(lines) == 0 {
+		return nil, &RecipeError{Line: 1, Err: errors.New("empty recipe")}
+	}
+	p := &yamlParser{lines: lines}
+	root, err := p.block(lines[0].Indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.i < len(lines) {
+		return nil, &RecipeError{Line: lines[p.i].Num, Err: errors.New("indentation doesn't line up with the lines above")}
+	}
+	return root, nil
+}
+
+// block parses the list or mapping whose lines start at indent
+func (p *yamlParser) block(indent int) (*recipeNode, error) {
+	if isYAMLListItem(p.lines[p.i].Text) {
+		return p.list(indent)
+	}
+	node := &recipeNode{Line: p.lines[p.i].Num, Fields: []recipeField{}}
+	for p.i < len(p.lines) && p.lines[p.i].Indent == indent {
+		line := p.lines[p.i]
+		if isYAMLListItem(line.Text) {
+			return nil, &RecipeError{Line: line.Num, Err: errors.New("a list item where a key: value was expected")}
+		}
+		key, rest, err := cutYAMLKey(line)
+		if err != nil {
+			return nil, err
+		}
+		p.i++
+		var value *recipeNode
+		switch next := p.next(); {
+		case rest != "":
+			value, err = yamlValue(rest, line.Num)
+		case next != nil && next.Indent > indent:
+			value, err = p.block(next.Indent)
+		case next != nil && next.Indent == indent && isYAMLListItem(next.Text):
+			value, err = p.list(indent) // YAML lets a key's list sit at the key's own indent
+		default:
+			value = &recipeNode{Line: line.Num, Null: true}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := node.addField(key, line.Num, value); err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
+
+// list parses the "- item" lines at indent
+func (p *yamlParser) list(indent int) (*recipeNode, error) {
+	node := &recipeNode{Line: p.lines[p.i].Num, IsList: true}
+	for next := p.next(); next != nil && next.Indent == indent && isYAMLListItem(next.Text); next = p.next() {
+		item := strings.TrimSpace(strings.TrimPrefix(next.Text, "-"))
+		if item == "" || isYAMLListItem(item) || strings.HasPrefix(item, "[") {
+			return nil, &RecipeError{Line: next.Num, Err: errors.New("a recipe's list items are single values")}
+		}
+		if item[0] != '"' && item[0] != '\'' && (strings.Contains(item, ": ") || strings.HasSuffix(item, ":")) {
+			return nil, &RecipeError{Line: next.Num, Err: fmt.Errorf("a recipe's list items are single values; quote %q to mean it as one", item)}
+		}
+		value, err := yamlScalar(item, next.Num)
+		if err != nil {
+			return nil, err
+		}
+		node.List = append(node.List, value)
+		p.i++
+	}
+	return node, nil
+}
+
+// next is the line the parser is at, or nil at the end
+func (p *yamlParser) next() *yamlLine {
+	if p.i < len(p.lines) {
+		return &p.lines[p.i]
+	}
+	return nil
+}
+
+// isYAMLListItem reports whether a line's text is a block list item
+func isYAMLListItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// cutYAMLKey splits "key: rest" or "key:", the key plain or quoted
+func cutYAMLKey(line yamlLine) (key, rest string, err error) {
+	text := line.Text
+	if text[0] == '"' || text[0] == '\'' {
+		end := quotedEnd(text)
+		if end < 0 {
+			return "", "", &RecipeError{Line: line.Num, Err: errors.New("unterminated quoted key")}
+		}
+		node, err := yamlScalar(text[:end], line.Num)
+		if err != nil {
+			return "", "", err
+		}
+		key, text = node.Scalar, text[end:]
+		if !strings.HasPrefix(text, ":") {
+			return "", "", &RecipeError{Line: line.Num, Err: errors.New(`want "key: value"`)}
+		}
+		return key, strings.TrimSpace(text[1:]), nil
+	}
+	i := strings.Index(text, ": ")
+	if i < 0 && strings.HasSuffix(text, ":") {
+		i = len(text) - 1
+	}
+	if i <= 0 {
+		return "", "", &RecipeError{Line: line.Num, Err: fmt.Errorf(`want "key: value", not %q`, text)}
+	}
+	return strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:]), nil
+}
+
+// yamlValue parses what follows "key:" on its line: a flow list or a scalar
+func yamlValue(text string, line int) (*recipeNode, error) {
+	switch text[0] {
+	case '[':
+		if !strings.HasSuffix(text, "]") {
+			return nil, &RecipeError{Line: line, Err: errors.New("a [list] has to close on its line")}
+		}
+		node := &recipeNode{Line: line, IsList: true}
+		inner := strings.TrimSpace(text[1 : len(text)-1])
+		if inner == "" {
+			return node, nil
+		}
+		for _, item := range splitYAMLFlow(inner) {
+			item = strings.TrimSpace(item)
+			if item == "" || strings.ContainsAny(item[:1], "[{") {
+				return nil, &RecipeError{Line: line, Err: errors.New("a recipe's list items are single values")}
+			}
+			value, err := yamlScalar(item, line)
+			if err != nil {
+				return nil, err
+			}
+			node.List = append(node.List, value)
+		}
+		return node, nil
+	case '{':
+		if text == "{}" {
+			return &recipeNode{Line: line, Fields: []recipeField{}}, nil
+		}
+		fallthrough
+	case '|', '>', '&', '*', '!':
+		return nil, &RecipeError{Line: line, Err: fmt.Errorf("%q isn't supported in a YAML recipe; write a plain value, or the recipe as JSON", text[:1])}
+	}
+	return yamlScalar(text, line)
+}
+
+// yamlScalar parses a plain, 'single' or "double" quoted scalar
+func yamlScalar(text string, line int) (*recipeNode, error) {
+	node := &recipeNode{Line: line}
+	switch {
+	case text[0] == '"':
+		s, err := strconv.Unquote(text)
+		if err != nil {
+			return nil, &RecipeError{Line: line, Err: fmt.Errorf("bad double-quoted string %s", text)}
+		}
+		node.Scalar = s
+	case text[0] == '\'':
+		if len(text) < 2 || quotedEnd(text) != len(text) {
+			return nil, &RecipeError{Line: line, Err: fmt.Errorf("bad single-quoted string %s", text)}
+		}
+		node.Scalar = strings.ReplaceAll(text[1:len(text)-1], "''", "'")
+	case text == "~" || text == "null":
+		node.Null = true
+	default:
+		node.Scalar = text
+	}
+	return node, nil
+}
+
+// quotedEnd is the index just past the quoted string text starts with, or -1
+func quotedEnd(text string) int {
+	quote := text[0]
+	for i := 1; i < len(text); i++ {
+		switch {
+		case quote == '"' && text[i] == '\\':
+			i++
+		case text[i] == quote && quote == '\'' && i+1 < len(text) && text[i+1] == '\'':
+			i++ // '' is a quote inside single quotes
+		case text[i] == quote:
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// splitYAMLFlow splits a flow list's inside at the commas outside quotes
+func splitYAMLFlow(inner string) []string {
+	var items []string
+	start := 0
+	for i := 0; i < len(inner); i++ {
+		switch inner[i] {
+		case '"', '\'':
+			if end := quotedEnd(inner[i:]); end > 0 {
+				i += end - 1
+			}
+		case ',':
+			items = append(items, inner[start:i])
+			start = i + 1
+		}
+	}
+	return append(items, inner[start:])
+}
+
+// stripYAMLComment cuts a line at a "#" starting a comment: at the start or after a
+// space, outside quotes
+func stripYAMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			if i == 0 || strings.IndexByte(" \t[,:-", line[i-1]) >= 0 {
+				quote = c
+			}
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
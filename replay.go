@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"deb-to-ipa/pkg/schema"
+)
+
+// --- Replaying options: a report as a conversion recipe ---
+// The flags a conversion ran with are recorded, in the order given, in --report and the
+// embedded origin. --options-from reads them back from either, so the next version of a
+// deb gets the same bundle ID, excludes and patches without writing a recipe (recipe.go).
+// Flags on the command line, and a --recipe's, win: one given there replaces every
+// recorded use of it.
+
+// unreplayedFlags are about one run rather than the conversion: where the input comes
+// from and where things go. They are neither recorded nor replayed.
+var unreplayedFlags = map[string]bool{
+	"o": true, "extract-to": true, "force": true, "report": true, "options-from": true,
+	"install": true, "udid": true, "staging": true, "temp-dir": true, "wait-lock": true,
+	"repo": true, "package": true, "package-version": true, "repo-header": true,
+	"keep-deb": true, "expect-sha256": true, "download-cache": true, "no-resume": true,
+	"trace": true, "ipc": true, "ci": true, "schema-versions": true,
+	"recipe": true,
+}
+
+// ReplayOption is one flag as given, e.g. {"flag": "exclude", "value": "**/*.car"}
+type ReplayOption struct {
+	Flag  string `json:"flag"`
+	Value string `json:"value"`
+}
+
+// optionRecorder wraps every flag's Value so each use is recorded in order, repeatable
+// flags included, the way --options-from needs to set them again
+type optionRecorder struct {
+	options []ReplayOption
+}
+
+// recordedValue is a flag.Value whose Set calls are recorded
+type recordedValue struct {
+	flag.Value
+	name string
+	rec  *optionRecorder
+}
+
+func (v *recordedValue) Set(s string) error {
+	if err := v.Value.Set(s); err != nil {
+		return err
+	}
+	if !unreplayedFlags[v.name] {
+		v.rec.options = append(v.rec.options, ReplayOption{Flag: v.name, Value: s})
+	}
+	return nil
+}
+
+// IsBoolFlag keeps "--flag" without a value working for the bool flags wrapped
+func (v *recordedValue) IsBoolFlag() bool {
+	b, ok := v.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// recordOptions wraps every flag defined in fs; call it after defining them, before parsing
+func recordOptions(fs *flag.FlagSet) *optionRecorder {
+	rec := &optionRecorder{}
+	fs.VisitAll(func(f *flag.Flag) {
+		f.Value = &recordedValue{Value: f.Value, name: f.Name, rec: rec}
+	})
+	return rec
+}
+
+// unwrap puts back every flag's own Value. PrintDefaults needs it: it finds a flag's zero
+// value by making a new one of its type, and a new recordedValue wraps nothing.
+func (rec *optionRecorder) unwrap(fs *flag.FlagSet) {
+	fs.VisitAll(func(f *flag.Flag) {
+		if v, ok := f.Value.(*recordedValue); ok && v.rec == rec {
+			f.Value = v.Value
+		}
+	})
+}
+
+// replayOptions applies the options recorded in a report or origin file to fs, skipping
+// any flag already set on the command line. Options this version doesn't know, or no
+// longer accepts the value of, are skipped with a warning.
+func replayOptions(fs *flag.FlagSet, recipePath string) error {
+	data, err := os.ReadFile(recipePath)
+	if err != nil {
+		return fmt.Errorf("--options-from: %w", err)
+	}
+	var recipe struct {
+		Schema  string         `json:"schema"`
+		Options []ReplayOption `json:"options"`
+	}
+	if err := json.Unmarshal(data, &recipe); err != nil {
+		return fmt.Errorf("--options-from %s: not a report or origin file: %w", recipePath, err)
+	}
+	if err := schema.Check(recipe.Schema, schema.Report, schema.Origin); err != nil {
+		return fmt.Errorf("--options-from %s: %w", recipePath, err)
+	}
+
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	applied := 0
+	for _, option := range recipe.Options {
+		switch {
+		case given[option.Flag]:
+			continue
+		case fs.Lookup(option.Flag) == nil || unreplayedFlags[option.Flag]:
+			fmt.Printf("⚠️  --options-from: skipping --%s, which this version doesn't replay\n", option.Flag)
+			continue
+		}
+		if err := fs.Set(option.Flag, option.Value); err != nil {
+			fmt.Printf("⚠️  --options-from: skipping --%s=%s: %v\n", option.Flag, option.Value, err)
+			continue
+		}
+		applied++
+	}
+	fmt.Printf("=> Replayed %d of %d options from %s\n", applied, len(recipe.Options), recipePath)
+	return nil
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// --- APT Repository Download ---
+// Cydia-style repos are "flat": Packages(.bz2/.gz/.xz) sits at the repo root and each
+// stanza's Filename is relative to it.
+
+// errPackageNotFound is returned when the index has no matching package (or version)
+var errPackageNotFound = errors.New("package not found")
+
+// repoUserAgent is what Cydia sends; some repos refuse anything else
+const repoUserAgent = "Telesphoreo APT-HTTP/1.0.592"
+
+// packagesIndexNames are tried in order. Packages.zst is only probed, to explain the failure:
+// there is no zstd decoder in the standard library.
+var packagesIndexNames = []string{"Packages.bz2", "Packages.gz", "Packages.xz", "Packages"}
+
+// maxPackagesIndex bounds a decompressed package index; the biggest repos' are a few tens
+// of MiB, and a compressed one can inflate without end. A variable for the tests.
+var maxPackagesIndex int64 = 256 << 20
+
+// httpStatusError is a non-2xx response
+type httpStatusError struct {
+	URL  string
+	Code int
+}
+
+func (e *httpStatusError) Error() string {
+	if e.Code == http.StatusUnauthorized || e.Code == http.StatusForbidden {
+		return fmt.Sprintf("authentication failed fetching %s (HTTP %d); the repo may need --repo-header", e.URL, e.Code)
+	}
+	return fmt.Sprintf("fetching %s: HTTP %d", e.URL, e.Code)
+}
+
+// repoClient fetches from one repo with the configured headers
+type repoClient struct {
+	base    *url.URL
+	headers http.Header
+}
+
+func newRepoClient(repo string, headers []string) (*repoClient, error) {
+	base, err := url.Parse(strings.TrimSuffix(repo, "/") + "/")
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") {
+		return nil, fmt.Errorf("--repo %q: want an http(s) URL", repo)
+	}
+	c := &repoClient{base: base, headers: http.Header{"User-Agent": {repoUserAgent}}}
+	for _, h := range headers {
+		name, value, ok := strings.Cut(h, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("--repo-header %q: want \"Name: value\"", h)
+		}
+		c.headers.Set(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	return c, nil
+}
+
+// resolve turns a path relative to the repo into its URL
+func (c *repoClient) resolve(rel string) (string, error) {
+	ref, err := url.Parse(rel)
+	if err != nil {
+		return "", err
+	}
+	return c.base.ResolveReference(ref).String(), nil
+}
+
+// get requests a path relative to the repo, returning the body of a 2xx response
+func (c *repoClient) get(rel string) (*http.Response, error) {
+	u, err := c.resolve(rel)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = c.headers.Clone()
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("network error: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, &httpStatusError{URL: u, Code: resp.StatusCode}
+	}
+	return resp, nil
+}
+
+// fetchPackages downloads and decompresses the repo's package index
+func (c *repoClient) fetchPackages() ([]byte, error) {
+	for _, name := range packagesIndexNames {
+		resp, err := c.get(name)
+		var statusErr *httpStatusError
+		if errors.As(err, &statusErr) && statusErr.Code == http.StatusNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var r io.Reader = resp.Body
+		if name != "Packages" {
+			if r, err = decompress(name, resp.Body); err != nil {
+				resp.Body.Close()
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+		}
+		data, err := io.ReadAll(io.LimitReader(r, maxPackagesIndex+1))
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", name, err)
+		}
+		if int64(len(data)) > maxPackagesIndex {
+			return nil, fmt.Errorf("%s is over %s decompressed", name, formatBytes(maxPackagesIndex))
+		}
+		return data, nil
+	}
+
+	if resp, err := c.get("Packages.zst"); err == nil {
+		resp.Body.Close()
+		return nil, errors.New("the repo only offers Packages.zst, and zstd isn't supported")
+	}
+	return nil, fmt.Errorf("no Packages index at %s", c.base)
+}
+
+// parsePackages splits an APT index into its stanzas
+func parsePackages(data []byte) []map[string]string {
+	var stanzas []map[string]string
+	for _, chunk := range strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n\n") {
+		if fields := parseControl([]byte(chunk)); fields["Package"] != "" {
+			stanzas = append(stanzas, fields)
+		}
+	}
+	return stanzas
+}
+
+// findPackage picks the newest stanza for a package ID, or the one with exactly version
+func findPackage(stanzas []map[string]string, id, version string) (map[string]string, error) {
+	var best map[string]string
+	var versions []string
+	for _, s := range stanzas {
+		if s["Package"] != id {
+			continue
+		}
+		versions = append(versions, s["Version"])
+		if version != "" {
+			if s["Version"] == version {
+				return s, nil
+			}
+			continue
+		}
+		if best == nil || compareDebVersions(s["Version"], best["Version"]) > 0 {
+			best = s
+		}
+	}
+	if best == nil {
+		if len(versions) > 0 {
+			return nil, fmt.Errorf("%w: %s has no version %s (available: %s)", errPackageNotFound, id, version, strings.Join(versions, ", "))
+		}
+		return nil, fmt.Errorf("%w: %s is not in the repo", errPackageNotFound, id)
+	}
+	return best, nil
+}
+
+// downloadPackage fetches a package's deb, verifying Size and SHA256 from the index (and
+// --expect-sha256) once it's complete. The deb goes to a cache entry, resumed on the next
+// run if the connection drops, or with --no-resume to a temp file. With --keep-deb it ends
+// up in the working directory under its repo file name.
+func downloadPackage(opts Options) (*downloadedDeb, error) {
+	client, err := newRepoClient(opts.Repo, opts.RepoHeaders)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("=> Fetching package index from %s...\n", client.base)
+	index, err := client.fetchPackages()
+	if err != nil {
+		return nil, err
+	}
+	pkg, err := findPackage(parsePackages(index), opts.Package, opts.PackageVersion)
+	if err != nil {
+		return nil, err
+	}
+	if pkg["Filename"] == "" {
+		return nil, fmt.Errorf("%s %s has no Filename in the index", opts.Package, pkg["Version"])
+	}
+	fmt.Printf("   Found %s %s (%s)\n", opts.Package, terminalSafe(pkg["Version"]), terminalSafe(pkg["Filename"]))
+
+	u, err := client.resolve(pkg["Filename"])
+	if err != nil {
+		return nil, err
+	}
+	// The index names the file; "..", separators or escapes in it mustn't pick the path
+	deb := &downloadedDeb{Name: safeFileName(path.Base(pkg["Filename"]))}
+	if opts.NoResume {
+		if deb.Path, err = client.fetchToTemp(u, opts.KeepDeb, deb.Name); err != nil {
+			return nil, err
+		}
+	} else {
+		deb.Cache = downloadCacheEntry(downloadCacheDir(opts), u)
+		deb.Path = filepath.Join(deb.Cache, deb.Name)
+		if err := client.fetchResumable(u, deb.Cache, deb.Path, indexSize(pkg)); err != nil {
+			return nil, fmt.Errorf("downloading %s: %w", pkg["Filename"], err)
+		}
+	}
+
+	n, err := verifyDownload(deb.Path, indexSize(pkg), pkg["SHA256"], opts.ExpectSHA256)
+	if err != nil {
+		if deb.Cache != "" {
+			os.RemoveAll(deb.Cache)
+		} else {
+			os.Remove(deb.Path)
+		}
+		return nil, fmt.Errorf("%s: %w", pkg["Filename"], err)
+	}
+	if pkg["SHA256"] == "" && opts.ExpectSHA256 == "" {
+		fmt.Println("   ⚠️  The index lists no SHA256 for this package; only the size was checked")
+	}
+
+	if opts.KeepDeb && deb.Cache != "" {
+		if err := moveIntoPlace(deb.Path, deb.Name); err != nil {
+			return nil, err
+		}
+		os.RemoveAll(deb.Cache)
+		deb.Path, deb.Cache = deb.Name, ""
+	}
+
+	fmt.Printf("   Downloaded %s\n", formatBytes(n))
+	return deb, nil
+}
+
+// fetchToTemp downloads u in one go, into the working directory as name with keep, else
+// into a temp file
+func (c *repoClient) fetchToTemp(u string, keep bool, name string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header = c.headers.Clone()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("network error: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", &httpStatusError{URL: u, Code: resp.StatusCode}
+	}
+
+	var out *os.File
+	if keep {
+		out, err = os.Create(name)
+	} else {
+		out, err = os.CreateTemp("", "deb-to-ipa-*.deb")
+	}
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(out, resp.Body)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return "", fmt.Errorf("network error downloading %s: %w", name, err)
+	}
+	return out.Name(), nil
+}
+
+// compareDebVersions orders Debian versions ([epoch:]upstream[-revision]) the way dpkg does
+func compareDebVersions(a, b string) int {
+	epochA, restA := splitEpoch(a)
+	epochB, restB := splitEpoch(b)
+	if epochA != epochB {
+		if epochA < epochB {
+			return -1
+		}
+		return 1
+	}
+
+	upA, revA := splitRevision(restA)
+	upB, revB := splitRevision(restB)
+	if c := compareVersionPart(upA, upB); c != 0 {
+		return c
+	}
+	return compareVersionPart(revA, revB)
+}
+
+func splitEpoch(v string) (int, string) {
+	if e, rest, ok := strings.Cut(v, ":"); ok {
+		if n, err := strconv.Atoi(e); err == nil {
+			return n, rest
+		}
+	}
+	return 0, v
+}
+
+func splitRevision(v string) (string, string) {
+	if i := strings.LastIndex(v, "-"); i >= 0 {
+		return v[:i], v[i+1:]
+	}
+	return v, ""
+}
+
+// compareVersionPart is dpkg's verrevcmp: alternating non-digit runs (compared by
+// character, with letters before symbols and "~" before everything, even the end)
+// and digit runs (compared numerically)
+func compareVersionPart(a, b string) int {
+	order := func(s string, i int) int {
+		if i >= len(s) {
+			return 0
+		}
+		c := s[i]
+		switch {
+		case c == '~':
+			return -1
+		case c >= '0' && c <= '9':
+			return 0
+		case (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+			return int(c)
+		default:
+			return int(c) + 256
+		}
+	}
+	isDigit := func(s string, i int) bool { return i < len(s) && s[i] >= '0' && s[i] <= '9' }
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		for (i < len(a) && !isDigit(a, i)) || (j < len(b) && !isDigit(b, j)) {
+			if oa, ob := order(a, i), order(b, j); oa != ob {
+				if oa < ob {
+					return -1
+				}
+				return 1
+			}
+			i++
+			j++
+		}
+
+		for i < len(a) && a[i] == '0' {
+			i++
+		}
+		for j < len(b) && b[j] == '0' {
+			j++
+		}
+		diff := 0
+		for isDigit(a, i) && isDigit(b, j) {
+			if diff == 0 {
+				diff = int(a[i]) - int(b[j])
+			}
+			i++
+			j++
+		}
+		if isDigit(a, i) {
+			return 1
+		}
+		if isDigit(b, j) {
+			return -1
+		}
+		if diff != 0 {
+			if diff < 0 {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
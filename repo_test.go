@@ -0,0 +1,35 @@
+package main
+
+import (
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestFetchPackagesLimit refuses a package index that inflates past maxPackagesIndex
+func TestFetchPackagesLimit(t *testing.T) {
+	old := maxPackagesIndex
+	maxPackagesIndex = 1 << 20
+	t.Cleanup(func() { maxPackagesIndex = old })
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/Packages.gz" {
+			http.NotFound(w, r)
+			return
+		}
+		zw := gzip.NewWriter(w)
+		zw.Write(make([]byte, maxPackagesIndex+1))
+		zw.Close()
+	}))
+	defer server.Close()
+
+	c, err := newRepoClient(server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.fetchPackages(); err == nil || !strings.Contains(err.Error(), "decompressed") {
+		t.Errorf("fetching an index over the limit: %v", err)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"deb-to-ipa/pkg/schema"
+)
+
+//go:generate go test -run ^TestSchemaGenerated$ -update-schema .
+
+// writeReport saves the conversion Result as indented JSON
+func writeReport(reportPath string, result *Result) error {
+	doc := *result
+	doc.Schema = schema.Report
+	out, err := json.MarshalIndent(&doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(reportPath, append(out, '\n'), 0644)
+}
+
+// printSchemaVersions lists the JSON documents this version writes, with their schema IDs
+func printSchemaVersions() {
+	for _, doc := range schema.All {
+		fmt.Printf("%-24s %s\n", doc.ID, doc.Description)
+	}
+}
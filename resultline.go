@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- RESULT line: one machine-greppable summary per run ---
+// Container logs interleave output from many jobs, so every run that gets as far as
+// converting ends with a single line a log query can pick out, whatever else it printed:
+//
+//	RESULT status=ok input=App.deb output=App.ipa bundle_id=com.x.app version=1.2 size=1048576 duration=2.31s
+//	RESULT status=warnings input=App.deb output=App.ipa ... warnings=executable-encrypted
+//	RESULT status=error code=not-an-app message="no .app folder in the deb"
+//
+// Values with spaces, quotes or '=' are quoted Go-style; the rest are printed as is.
+
+// Statuses in the RESULT line and the report. "warnings" is a conversion that succeeded
+// with warnings at or above --warn-exit-level, and exits with exitWarnings.
+const (
+	ResultStatusOK       = "ok"
+	ResultStatusWarnings = "warnings"
+	ResultStatusError    = "error"
+)
+
+// Codes in a failed run's RESULT line, for the step that failed
+const (
+	ResultCodeDownload   = "download"
+	ResultCodeNotAnApp   = "not-an-app"
+	ResultCodeSpillQuota = "spill-quota"
+	ResultCodeLimit      = "limit"
+	ResultCodeSelfCheck  = "self-check"
+	ResultCodeConversion = "conversion"
+	ResultCodeLint       = "lint"
+	ResultCodeWarnings   = "warnings"
+	ResultCodeReport     = "report"
+	ResultCodeAltStore   = "altstore"
+	ResultCodeDepiction  = "depiction"
+	ResultCodeInstall    = "install"
+	ResultCodeCancelled  = "cancelled"
+	ResultCodeBudget     = "output-budget"
+	ResultCodeCI         = "ci"
+	ResultCodeExecutable = "executable-size"
+)
+
+// resultLine formats key/value pairs as a RESULT line, leaving out empty values
+func resultLine(pairs ...string) string {
+	var b strings.Builder
+	b.WriteString("RESULT")
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] == "" {
+			continue
+		}
+		b.WriteString(" " + pairs[i] + "=" + resultValue(pairs[i+1]))
+	}
+	return b.String()
+}
+
+// resultValue quotes a value that wouldn't survive splitting on spaces and '='
+func resultValue(v string) string {
+	if strings.ContainsAny(v, " \t\"=\\") || strings.ContainsFunc(v, func(r rune) bool { return !strconv.IsPrint(r) }) {
+		return strconv.Quote(v)
+	}
+	return v
+}
+
+// printResultOK ends a successful run; output is the IPA, or the --extract-to folder.
+// With status "warnings", the codes of warnExit, the warnings that set it, are listed.
+func printResultOK(input, output string, result *Result, warnExit []Warning, elapsed time.Duration) {
+	size := ""
+	if result.OutputPath != "" {
+		if stat, err := os.Stat(result.OutputPath); err == nil {
+			size = strconv.FormatInt(stat.Size(), 10)
+		}
+	}
+	var codes []string
+	for _, w := range warnExit {
+		if !slices.Contains(codes, w.Code) {
+			codes = append(codes, w.Code)
+		}
+	}
+	fmt.Println(resultLine("status", valueOr(result.Status, ResultStatusOK), "input", input, "output", output,
+		"bundle_id", result.BundleID, "version", result.Version, "size", size, "duration", resultDuration(elapsed),
+		"warnings", strings.Join(codes, ",")))
+}
+
+// exitFailed ends a failed run with its RESULT line and exit status 1
+func exitFailed(code string, err error, elapsed time.Duration) {
+	ci.fail(code, err)
+	fmt.Println(resultLine("status", ResultStatusError, "code", code, "message", err.Error(), "duration", resultDuration(elapsed)))
+	trace.close(ResultStatusError, err, elapsed)
+	ipc.close(IPCEvent{Status: ResultStatusError, Code: code, Error: err.Error()})
+	os.Exit(1)
+}
+
+// conversionErrorCode tells the conversion's typed errors apart for the RESULT line
+func conversionErrorCode(err error) string {
+	var notApp *NotAnAppError
+	var quota *SpillQuotaError
+	var limit *LimitError
+	var selfCheck *SelfCheckError
+	var budget *OutputBudgetError
+	var executable *ExecutableSizeError
+	switch {
+	case errors.Is(err, errCancelled) || ipc.err() != nil:
+		return ResultCodeCancelled
+	case errors.As(err, &notApp):
+		return ResultCodeNotAnApp
+	case errors.As(err, &quota):
+		return ResultCodeSpillQuota
+	case errors.As(err, &limit):
+		return ResultCodeLimit
+	case errors.As(err, &selfCheck):
+		return ResultCodeSelfCheck
+	case errors.As(err, &budget):
+		return ResultCodeBudget
+	case errors.As(err, &executable):
+		return ResultCodeExecutable
+	}
+	return ResultCodeConversion
+}
+
+// resultDuration is the elapsed time to the millisecond, e.g. "2.31s"
+func resultDuration(elapsed time.Duration) string {
+	return elapsed.Round(time.Millisecond).String()
+}
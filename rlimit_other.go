@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+// openFileLimit isn't known here; the handle pool uses its default
+func openFileLimit() int {
+	return 0
+}
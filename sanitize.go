@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// --- Metadata sanitizing: Info.plist values the conversion prints and uses ---
+// A malformed or hostile Info.plist can name an executable "MacOS/Foo" or "../x", or carry
+// escape sequences and megabytes of text in its version. The executable name is matched
+// against bundle paths and the rest reach the terminal and reports, so each value is
+// cleaned before use and every change is flagged: a plist that needed one is suspect.
+
+// maxMetadataLength bounds a plist value, in bytes; also the longest file name most systems allow
+const maxMetadataLength = 255
+
+// MetadataFix is an Info.plist value that had to be cleaned up
+type MetadataFix struct {
+	Key      string `json:"key"`      // e.g. "CFBundleExecutable"; "version" for whichever version key was read
+	Original string `json:"original"` // As found, quoted and cut short for display
+	Value    string `json:"value"`    // As used; "" when it was rejected
+	Reason   string `json:"reason"`
+}
+
+// sanitizeAppMetadata cleans the values parseAppMetadata read. The executable name loses
+// any folders in front of it, and is rejected if nothing usable is left, so the executable
+// is looked for as if Info.plist named none.
+func sanitizeAppMetadata(executableName, bundleID, version string) (string, string, string, []MetadataFix) {
+	var fixes []MetadataFix
+	clean := func(key, value string, isFileName bool) string {
+		cleaned, reasons := sanitizeMetadataValue(value)
+		if isFileName && strings.ContainsAny(cleaned, `/\`) {
+			cleaned = path.Base(strings.ReplaceAll(cleaned, `\`, "/"))
+			reasons = append(reasons, "had a path in front of the file name")
+		}
+		if isFileName && (cleaned == "." || cleaned == ".." || cleaned == "/") {
+			cleaned = ""
+			reasons = append(reasons, "isn't a file name")
+		}
+		if len(reasons) > 0 {
+			fixes = append(fixes, MetadataFix{Key: key, Original: displayMetadataValue(value), Value: cleaned, Reason: strings.Join(reasons, ", ")})
+		}
+		return cleaned
+	}
+	executableName = clean("CFBundleExecutable", executableName, true)
+	bundleID = clean("CFBundleIdentifier", bundleID, false)
+	version = clean("version", version, false)
+	return executableName, bundleID, version, fixes
+}
+
+// sanitizeMetadataValue strips control characters and cuts the value to maxMetadataLength,
+// returning what it did
+func sanitizeMetadataValue(value string) (string, []string) {
+	var reasons []string
+	if strings.IndexFunc(value, unicode.IsControl) >= 0 || !utf8.ValidString(value) {
+		value = strings.Map(func(r rune) rune {
+			if unicode.IsControl(r) || r == utf8.RuneError {
+				return -1
+			}
+			return r
+		}, value)
+		reasons = append(reasons, "had control characters")
+	}
+	if len(value) > maxMetadataLength {
+		cut := maxMetadataLength
+		for cut > 0 && !utf8.RuneStart(value[cut]) {
+			cut--
+		}
+		value = value[:cut]
+		reasons = append(reasons, fmt.Sprintf("was longer than %d bytes", maxMetadataLength))
+	}
+	return value, reasons
+}
+
+// displayMetadataValue quotes a raw value for the summary, with escapes made visible and
+// long values cut short
+func displayMetadataValue(value string) string {
+	if len(value) > 64 {
+		cut := 64
+		for cut > 0 && !utf8.RuneStart(value[cut]) {
+			cut--
+		}
+		return fmt.Sprintf("%q...", value[:cut])
+	}
+	return fmt.Sprintf("%q", value)
+}
+
+// printMetadataFixes flags the values cleaned up, which say the source plist is suspect
+func printMetadataFixes(fixes []MetadataFix, warnings *warningLog) {
+	for _, fix := range fixes {
+		used := fmt.Sprintf("using %q", fix.Value)
+		if fix.Value == "" {
+			used = "ignored it"
+		}
+		warnings.add("plist-value-sanitized", fix.Key, "Info.plist's %s %s %s; %s (the plist may be malformed or tampered with)",
+			fix.Key, fix.Original, fix.Reason, used)
+	}
+}
+
+// --- Terminal and file-name safety: every value from the deb, not just Info.plist's ---
+// Entry names, owner names, nested plists and repo indexes reach the terminal too, and the
+// app folder's name becomes a folder on disk with --extract-to. Printed values have their
+// control characters shown escaped; file names lose them, and their separators, outright.
+// Reports keep raw values: encoding/json escapes control characters itself.
+
+// terminalSafe shows a value from the deb with C0 and C1 control characters (ESC among
+// them) and invalid UTF-8 escaped, e.g. \x1b, so none reaches the terminal as a command
+func terminalSafe(s string) string {
+	if utf8.ValidString(s) && !strings.ContainsFunc(s, unicode.IsControl) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		r, width := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == utf8.RuneError && width == 1:
+			fmt.Fprintf(&b, `\x%02x`, s[i])
+		case unicode.IsControl(r):
+			quoted := strconv.QuoteRune(r)
+			b.WriteString(quoted[1 : len(quoted)-1])
+		default:
+			b.WriteRune(r)
+		}
+		i += width
+	}
+	return b.String()
+}
+
+// safeFileName makes a name from the deb usable as one file or folder name: control
+// characters and invalid UTF-8 dropped, path separators replaced with "_", and "", "."
+// and ".." replaced whole
+func safeFileName(name string) string {
+	name, _ = sanitizeMetadataValue(name)
+	name = strings.NewReplacer("/", "_", `\`, "_").Replace(name)
+	if name == "" || name == "." || name == ".." {
+		return "_"
+	}
+	return name
+}
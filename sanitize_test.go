@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode"
+)
+
+// captureOutput runs fn with stdout and stderr going to pipes, returning what each got
+func captureOutput(t *testing.T, fn func()) (stdout, stderr string) {
+	t.Helper()
+	capture := func(f **os.File) func() string {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		old := *f
+		*f = w
+		got := make(chan string)
+		go func() {
+			var b bytes.Buffer
+			io.Copy(&b, r)
+			r.Close()
+			got <- b.String()
+		}()
+		return func() string {
+			*f = old
+			w.Close()
+			return <-got
+		}
+	}
+	outDone, errDone := capture(&os.Stdout), capture(&os.Stderr)
+	defer func() { stdout, stderr = outDone(), errDone() }()
+	fn()
+	return
+}
+
+func TestTerminalSafe(t *testing.T) {
+	for in, want := range map[string]string{
+		"Fixture":               "Fixture",
+		"Café 🎉":                "Café 🎉",
+		"\x1b]0;pwned\x07":      `\x1b]0;pwned\a`,
+		"a\u009b2Jb":            `a\u009b2Jb`,
+		"tab\there\nline":       `tab\there\nline`,
+		"bad\xffutf8":           `bad\xffutf8`,
+		"../..\\evil/plain.txt": "../..\\evil/plain.txt", // Separators are fine to print
+	} {
+		if got := terminalSafe(in); got != want {
+			t.Errorf("terminalSafe(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestSafeFileName(t *testing.T) {
+	for in, want := range map[string]string{
+		"Fixture.app":              "Fixture.app",
+		"Fixture\x1b[2J.app":       "Fixture[2J.app",
+		`Fixture\..\evil.app`:      "Fixture_.._evil.app",
+		"../../etc/passwd":         ".._.._etc_passwd",
+		"..":                       "_",
+		"\x1b":                     "_",
+		"":                         "_",
+		"app_1.0_iphoneos-arm.deb": "app_1.0_iphoneos-arm.deb",
+	} {
+		if got := safeFileName(in); got != want {
+			t.Errorf("safeFileName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestSanitizeAppMetadata(t *testing.T) {
+	exe, id, version, fixes := sanitizeAppMetadata("MacOS/../Fixture\x1b[31m", "com.example\x07.fixture", strings.Repeat("1", 300))
+	if exe != "Fixture[31m" || id != "com.example.fixture" || len(version) != maxMetadataLength {
+		t.Errorf("cleaned to %q, %q, %d-byte version", exe, id, len(version))
+	}
+	if len(fixes) != 3 {
+		t.Fatalf("%d fixes, want one per value: %+v", len(fixes), fixes)
+	}
+	if exe, _, _, fixes := sanitizeAppMetadata("..", "", ""); exe != "" || len(fixes) != 1 || fixes[0].Value != "" {
+		t.Errorf("executable \"..\": %q, %+v; want it rejected", exe, fixes)
+	}
+}
+
+// hasControl reports whether s holds a control character or invalid UTF-8
+func hasControl(s string) bool {
+	return strings.ContainsFunc(s, func(r rune) bool {
+		return unicode.IsControl(r) && r != '\n' && r != '\r' || r == unicode.ReplacementChar
+	})
+}
+
+// TestConvertHostilePlist converts an app whose Info.plist strings and folder name carry
+// escape sequences and path separators: nothing printed carries a control character, the
+// app folder in the IPA and on disk is one safe name, and the report is clean JSON
+func TestConvertHostilePlist(t *testing.T) {
+	spec := FixtureSpec{HostilePlist: true}
+	var result *Result
+	stdout, stderr := captureOutput(t, func() {
+		result, _ = convertFixture(t, spec, Options{Verbose: true})
+		printWarnings(result.Warnings)
+	})
+	for name, out := range map[string]string{"stdout": stdout, "stderr": stderr} {
+		if hasControl(out) {
+			t.Errorf("%s carries control characters: %q", name, out)
+		}
+	}
+	if !strings.Contains(stdout, `\x1b`) {
+		t.Error("the escapes aren't shown escaped on stdout")
+	}
+	codes := map[string]bool{}
+	for _, w := range result.Warnings {
+		codes[w.Code] = true
+	}
+	if !codes["app-folder-sanitized"] || !codes["plist-value-sanitized"] {
+		t.Errorf("warnings %v, want app-folder-sanitized and plist-value-sanitized", codes)
+	}
+	if strings.ContainsAny(result.AppName, "/\\\x1b") {
+		t.Errorf("app folder %q", result.AppName)
+	}
+	// The report is JSON, whose encoding escapes C0 controls itself
+	report, err := json.Marshal(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i := bytes.IndexFunc(report, func(r rune) bool { return r < 0x20 }); i >= 0 {
+		t.Errorf("raw control character in the report at %d: %q", i, report[max(i-20, 0):i+1])
+	}
+
+	extractTo := filepath.Join(t.TempDir(), "out")
+	captureOutput(t, func() {
+		if _, _, err := tryConvertFixture(t, spec, Options{ExtractTo: extractTo}); err != nil {
+			t.Errorf("--extract-to: %v", err)
+		}
+	})
+	apps, _ := filepath.Glob(filepath.Join(extractTo, "Payload", "*"))
+	if len(apps) != 1 || filepath.Base(apps[0]) != result.AppName {
+		t.Errorf("--extract-to wrote %v, want one folder named %s", apps, result.AppName)
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// --- pkg/schema from the document types ---
+// pkg/schema/documents.go is generated from the types the tool encodes, so the structs a
+// reader decodes with can't drift from what's written. TestSchemaGenerated fails while the
+// file is out of date; go generate (or go test -run TestSchemaGenerated -update-schema)
+// rewrites it.
+
+var updateSchema = flag.Bool("update-schema", false, "rewrite pkg/schema/documents.go from the document types")
+
+// schemaDocumentsPath is the generated file, from the repository root
+const schemaDocumentsPath = "pkg/schema/documents.go"
+
+// schemaDocuments are the documents the tool writes, by the constant naming their schema
+// ID, each ahead of any document that holds it
+var schemaDocuments = []struct {
+	id  string
+	doc any
+}{
+	{"Manifest", Manifest{}},
+	{"Origin", DebOrigin{}},
+	{"SizeReport", SizeReport{}},
+	{"Report", Result{}},
+	{"Event", IPCEvent{}},
+	{"Depiction", Depiction{}},
+	{"Doctor", DoctorReport{}},
+	{"Diff", BundleDiff{}},
+	{"Bench", BenchReport{}},
+}
+
+// schemaTypeNames renames types for pkg/schema: each document is <ID>Document, and a
+// few types keep the shorter names the package gave them first
+var schemaTypeNames = map[string]string{
+	"Result":       "ReportDocument",
+	"Depiction":    "DepictionDocument",
+	"Manifest":     "ManifestDocument",
+	"IPCEvent":     "EventDocument",
+	"DoctorReport": "DoctorDocument",
+	"DebOrigin":    "OriginDocument",
+	"BundleDiff":   "DiffDocument",
+	"SizeReport":   "SizeReportDocument",
+	"BenchReport":  "BenchDocument",
+	"MachOSlice":   "Slice",
+	"ReplayOption": "Option",
+}
+
+// sourceComments holds the doc comments of the package's types and their fields, as
+// "Type" and "Type.Field"
+type sourceComments map[string]string
+
+// readSourceComments parses the package's non-test files for their type and field comments
+func readSourceComments(t *testing.T) sourceComments {
+	t.Helper()
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	comments := make(sourceComments)
+	fset := token.NewFileSet()
+	for _, name := range files {
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, name, nil, parser.ParseComments)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, decl := range f.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				ts := spec.(*ast.TypeSpec)
+				doc := ts.Doc
+				if doc == nil && len(gen.Specs) == 1 {
+					doc = gen.Doc
+				}
+				comments[ts.Name.Name] = doc.Text()
+				st, ok := ts.Type.(*ast.StructType)
+				if !ok {
+					continue
+				}
+				for _, field := range st.Fields.List {
+					text := field.Comment.Text()
+					if text == "" {
+						text = field.Doc.Text()
+					}
+					for _, name := range field.Names {
+						comments[ts.Name.Name+"."+name.Name] = text
+					}
+					if len(field.Names) == 0 {
+						comments[ts.Name.Name+"."+fmt.Sprint(field.Type)] = text
+					}
+				}
+			}
+		}
+	}
+	return comments
+}
+
+// schemaGenerator writes the pkg/schema structs for the types reachable from the documents
+type schemaGenerator struct {
+	comments sourceComments
+	pkgPath  string                // The package's import path, to tell its types from others
+	seen     map[reflect.Type]bool // Structs written or queued
+	queue    []reflect.Type
+	usesTime bool
+	err      error
+}
+
+// typeName is a struct's name in pkg/schema
+func (g *schemaGenerator) typeName(t reflect.Type) string {
+	if name, ok := schemaTypeNames[t.Name()]; ok {
+		return name
+	}
+	return t.Name()
+}
+
+// typeExpr is how pkg/schema spells t, queueing the package's structs it mentions
+func (g *schemaGenerator) typeExpr(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return "*" + g.typeExpr(t.Elem())
+	case reflect.Slice:
+		return "[]" + g.typeExpr(t.Elem())
+	case reflect.Map:
+		return "map[" + g.typeExpr(t.Key()) + "]" + g.typeExpr(t.Elem())
+	case reflect.Interface:
+		if t.NumMethod() > 0 {
+			g.fail("interface %s has no JSON form to declare", t)
+		}
+		return "any"
+	case reflect.Struct:
+		if t == reflect.TypeOf(time.Time{}) {
+			g.usesTime = true
+			return "time.Time"
+		}
+		if t.PkgPath() != g.pkgPath || !ast.IsExported(t.Name()) {
+			g.fail("%s: only the package's own exported structs can be declared", t)
+			return t.String()
+		}
+		if !g.seen[t] {
+			g.seen[t] = true
+			g.queue = append(g.queue, t)
+		}
+		return g.typeName(t)
+	}
+	if t.PkgPath() != "" {
+		g.fail("%s: a named %s, declare the field with the basic type", t, t.Kind())
+	}
+	return t.Kind().String()
+}
+
+func (g *schemaGenerator) fail(format string, args ...any) {
+	if g.err == nil {
+		g.err = fmt.Errorf(format, args...)
+	}
+}
+
+// writeComment writes text as a // comment, indented by indent
+func writeComment(b *bytes.Buffer, text, indent string) {
+	for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		fmt.Fprintf(b, "%s// %s\n", indent, line)
+	}
+}
+
+// writeStruct writes t's declaration, its doc comment naming it as pkg/schema does
+func (g *schemaGenerator) writeStruct(b *bytes.Buffer, t reflect.Type, id string) {
+	name := g.typeName(t)
+	doc := g.comments[t.Name()]
+	if rest, ok := strings.CutPrefix(doc, t.Name()+" "); ok {
+		doc = name + " " + rest
+	}
+	if doc == "" {
+		doc = name + " has no doc comment in the tool\n"
+	}
+	if id != "" {
+		doc += "\nIts schema ID is " + id + ".\n"
+	}
+	b.WriteString("\n")
+	writeComment(b, doc, "")
+	fmt.Fprintf(b, "type %s struct {\n", name)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag, hasTag := f.Tag.Lookup("json")
+		if !f.IsExported() || tag == "-" {
+			continue
+		}
+		comment := g.comments[t.Name()+"."+f.Name]
+		multiline := strings.Count(comment, "\n") > 1
+		if multiline {
+			writeComment(b, comment, "\t")
+		}
+		if f.Anonymous {
+			fmt.Fprintf(b, "\t%s", g.typeExpr(f.Type))
+		} else {
+			fmt.Fprintf(b, "\t%s %s", f.Name, g.typeExpr(f.Type))
+		}
+		if hasTag {
+			fmt.Fprintf(b, " `json:%q`", tag)
+		}
+		if comment != "" && !multiline {
+			fmt.Fprintf(b, " // %s", strings.TrimSpace(comment))
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("}\n")
+}
+
+// generateSchemaDocuments is the source of pkg/schema/documents.go
+func generateSchemaDocuments(t *testing.T) []byte {
+	t.Helper()
+	g := &schemaGenerator{comments: readSourceComments(t), pkgPath: reflect.TypeOf(Result{}).PkgPath(), seen: make(map[reflect.Type]bool)}
+	var body bytes.Buffer
+	for _, d := range schemaDocuments {
+		root := reflect.TypeOf(d.doc)
+		if g.seen[root] {
+			t.Fatalf("%s is held by an earlier document; list it first", root)
+		}
+		g.seen[root] = true
+		g.writeStruct(&body, root, d.id)
+		// Then every struct it reaches that an earlier document didn't, breadth first
+		for len(g.queue) > 0 {
+			next := g.queue[0]
+			g.queue = g.queue[1:]
+			g.writeStruct(&body, next, "")
+		}
+	}
+	if g.err != nil {
+		t.Fatal(g.err)
+	}
+
+	var b bytes.Buffer
+	b.WriteString("// Code generated by TestSchemaGenerated from the types deb-to-ipa encodes; DO NOT EDIT.\n")
+	b.WriteString("// Run go generate in the repository root to update it.\n\npackage schema\n")
+	if g.usesTime {
+		b.WriteString("\nimport \"time\"\n")
+	}
+	b.Write(body.Bytes())
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		t.Fatalf("generated source doesn't parse: %v\n%s", err, b.Bytes())
+	}
+	return src
+}
+
+// TestSchemaGenerated checks pkg/schema/documents.go matches the document types, and
+// rewrites it with -update-schema
+func TestSchemaGenerated(t *testing.T) {
+	src := generateSchemaDocuments(t)
+	if *updateSchema {
+		if err := os.WriteFile(schemaDocumentsPath, src, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	current, err := os.ReadFile(schemaDocumentsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(current, src) {
+		t.Errorf("%s is out of date with the document types; run go generate", schemaDocumentsPath)
+	}
+}
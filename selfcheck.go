@@ -0,0 +1,153 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// --- Self-check: the modes in the written IPA ---
+// The ExternalAttrs written for each entry (see PERMISSION FIXES) are what make iOS and
+// ldid see an executable, a symlink or a folder, and they've regressed before. After the
+// archive is closed its central directory is read back, with no file data, and every
+// directory, symlink and the main executable is checked for the type and mode it was
+// written with. An IPA that fails is useless, so the conversion fails with it.
+// --verify-output also reads every file back, which checks each one's CRC32.
+
+// Entry classes the self-check knows what to expect of
+const (
+	selfCheckFile = iota
+	selfCheckDir
+	selfCheckLink
+	selfCheckExecutable
+)
+
+// Unix file types in the upper half of ExternalAttrs
+const (
+	unixTypeMask = 0xF000
+	unixTypeReg  = 0x8000
+	unixTypeDir  = 0x4000
+	unixTypeLink = 0xA000
+)
+
+// SelfCheckError is an archive whose entries don't carry the modes they were written with
+type SelfCheckError struct {
+	Problems []string
+}
+
+func (e *SelfCheckError) Error() string {
+	problems := e.Problems
+	if len(problems) > 5 {
+		problems = append(problems[:5:5], fmt.Sprintf("and %d more", len(e.Problems)-5))
+	}
+	return fmt.Sprintf("self-check failed, the IPA's zip modes are wrong (a bug in deb-to-ipa's ExternalAttrs, please report it): %s", strings.Join(problems, "; "))
+}
+
+// archiveModeCheck collects what each archive entry was written as, to check after closing
+type archiveModeCheck struct {
+	classes    map[string]int
+	executable string // Entry name of the main executable, once written
+}
+
+func newArchiveModeCheck() *archiveModeCheck {
+	return &archiveModeCheck{classes: make(map[string]int)}
+}
+
+// expect records an entry as written under name
+func (c *archiveModeCheck) expect(name string, vf *VirtualFile, mainExecutable bool) {
+	switch {
+	case mainExecutable:
+		c.classes[name] = selfCheckExecutable
+		c.executable = name
+	case vf.IsDir:
+		c.classes[name] = selfCheckDir
+	case vf.IsLink:
+		c.classes[name] = selfCheckLink
+	default:
+		c.classes[name] = selfCheckFile
+	}
+}
+
+// verify reads the archive's central directory back and checks every entry expected;
+// with full, it also reads every file through, which fails on a CRC32 mismatch
+func (c *archiveModeCheck) verify(ipaPath string, full bool) error {
+	zr, err := zip.OpenReader(ipaPath)
+	if err != nil {
+		return fmt.Errorf("self-check: reopening the IPA: %w", err)
+	}
+	defer zr.Close()
+	var problems []string
+	seen, executableSeen := 0, false
+	for _, f := range zr.File {
+		class, ok := c.classes[f.Name]
+		if !ok {
+			continue
+		}
+		seen++
+		executableSeen = executableSeen || class == selfCheckExecutable
+		if problem := checkEntryMode(f, class); problem != "" {
+			problems = append(problems, problem)
+		}
+	}
+	if c.executable != "" && !executableSeen {
+		problems = append(problems, fmt.Sprintf("%s: main executable written but missing from the central directory", c.executable))
+		seen++
+	}
+	if seen != len(c.classes) {
+		problems = append(problems, fmt.Sprintf("%d of the %d entries written are missing from the central directory", len(c.classes)-seen, len(c.classes)))
+	}
+	if len(problems) > 0 {
+		return &SelfCheckError{Problems: problems}
+	}
+	if !full {
+		return nil
+	}
+	for _, f := range zr.File {
+		if err := readEntryThrough(f); err != nil {
+			return fmt.Errorf("--verify-output: %s: %w", f.Name, err)
+		}
+	}
+	return nil
+}
+
+// checkEntryMode describes how an entry's mode differs from what its class was written
+// with, "" when it doesn't
+func checkEntryMode(f *zip.File, class int) string {
+	unixMode := f.ExternalAttrs >> 16
+	mode := f.Mode()
+	switch class {
+	case selfCheckDir:
+		if unixMode&unixTypeMask != unixTypeDir || !mode.IsDir() {
+			return fmt.Sprintf("%s: directory with ExternalAttrs mode %06o, Go sees %s", f.Name, unixMode, mode)
+		}
+	case selfCheckLink:
+		if unixMode&unixTypeMask != unixTypeLink || mode&os.ModeSymlink == 0 {
+			return fmt.Sprintf("%s: symlink with ExternalAttrs mode %06o, Go sees %s", f.Name, unixMode, mode)
+		}
+	case selfCheckExecutable:
+		if unixMode&unixTypeMask == unixTypeLink || mode&os.ModeSymlink != 0 {
+			return fmt.Sprintf("%s: main executable is a symlink (ExternalAttrs mode %06o); iOS and ldid need the binary itself there", f.Name, unixMode)
+		}
+		if unixMode != unixTypeReg|0755 || !mode.IsRegular() {
+			return fmt.Sprintf("%s: main executable with ExternalAttrs mode %06o, want %06o (S_IFREG|0755)", f.Name, unixMode, unixTypeReg|0755)
+		}
+	default:
+		if unixMode&unixTypeMask != unixTypeReg || !mode.IsRegular() {
+			return fmt.Sprintf("%s: file with ExternalAttrs mode %06o, Go sees %s", f.Name, unixMode, mode)
+		}
+	}
+	return ""
+}
+
+// readEntryThrough reads a zip entry to its end, where archive/zip checks its CRC32
+func readEntryThrough(f *zip.File) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	_, err = io.Copy(io.Discard, rc)
+	return err
+}
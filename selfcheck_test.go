@@ -0,0 +1,136 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// modeEntry is one entry for writeModeZip: its name and the unix mode in ExternalAttrs
+type modeEntry struct {
+	name string
+	mode uint32
+}
+
+// writeModeZip writes a zip whose entries carry the given unix modes, as the zip loop does
+func writeModeZip(t *testing.T, entries []modeEntry) string {
+	t.Helper()
+	ipaPath := filepath.Join(t.TempDir(), "out.ipa")
+	f, err := os.Create(ipaPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for _, e := range entries {
+		header := &zip.FileHeader{Name: e.name, Method: zip.Store}
+		header.SetMode(0644)
+		header.ExternalAttrs = e.mode << 16
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.mode&unixTypeMask != unixTypeDir {
+			w.Write([]byte("data"))
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return ipaPath
+}
+
+func TestArchiveModeCheck(t *testing.T) {
+	const (
+		dir  = "Payload/Fixture.app/"
+		exe  = "Payload/Fixture.app/Fixture"
+		link = "Payload/Fixture.app/Current"
+		file = "Payload/Fixture.app/Info.plist"
+	)
+	// What the conversion wrote, before any entry is tampered with
+	expect := func(c *archiveModeCheck, executableIsLink bool) {
+		c.expect(dir, &VirtualFile{IsDir: true}, false)
+		c.expect(exe, &VirtualFile{IsLink: executableIsLink}, true)
+		c.expect(link, &VirtualFile{IsLink: true}, false)
+		c.expect(file, &VirtualFile{}, false)
+	}
+	good := []modeEntry{
+		{dir, unixTypeDir | 0755},
+		{exe, unixTypeReg | 0755},
+		{link, unixTypeLink | 0755},
+		{file, unixTypeReg | 0644},
+	}
+	with := func(name string, mode uint32) []modeEntry {
+		entries := make([]modeEntry, 0, len(good))
+		for _, e := range good {
+			if e.name == name {
+				if mode == 0 {
+					continue
+				}
+				e.mode = mode
+			}
+			entries = append(entries, e)
+		}
+		return entries
+	}
+
+	for _, c := range []struct {
+		name             string
+		entries          []modeEntry
+		executableIsLink bool
+		problem          string // Part of the failure, "" to pass
+	}{
+		{"all good", good, false, ""},
+		{"missing executable", with(exe, 0), false, "main executable written but missing"},
+		{"symlinked executable", with(exe, unixTypeLink|0755), true, "main executable is a symlink"},
+		{"executable written as a symlink", with(exe, unixTypeLink|0755), false, "main executable is a symlink"},
+		{"executable not executable", with(exe, unixTypeReg|0644), false, "want 100755"},
+		{"executable with no file type", with(exe, 0755), false, "want 100755"},
+		{"executable too open", with(exe, unixTypeReg|0777), false, "want 100755"},
+		{"broken symlink", with(link, unixTypeReg|0755), false, "symlink with ExternalAttrs mode 100755"},
+		{"symlink with no file type", with(link, 0755), false, "symlink with ExternalAttrs"},
+		{"directory written as a file", with(dir, unixTypeReg|0755), false, "directory with ExternalAttrs"},
+		{"file written as a symlink", with(file, unixTypeLink|0644), false, "file with ExternalAttrs"},
+		{"missing file", with(file, 0), false, "1 of the 4 entries written are missing"},
+	} {
+		check := newArchiveModeCheck()
+		expect(check, c.executableIsLink)
+		err := check.verify(writeModeZip(t, c.entries), false)
+		if c.problem == "" {
+			if err != nil {
+				t.Errorf("%s: %v", c.name, err)
+			}
+			continue
+		}
+		var selfCheck *SelfCheckError
+		if !errors.As(err, &selfCheck) || !strings.Contains(err.Error(), c.problem) {
+			t.Errorf("%s: %v, want a SelfCheckError with %q", c.name, err, c.problem)
+		}
+	}
+}
+
+// TestArchiveModeCheckCRC only catches a corrupt file with --verify-output
+func TestArchiveModeCheckCRC(t *testing.T) {
+	ipaPath := writeModeZip(t, []modeEntry{{"Payload/Fixture.app/Info.plist", unixTypeReg | 0644}})
+	data, err := os.ReadFile(ipaPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := strings.Index(string(data), "data")
+	copy(data[i:], "DATA")
+	if err := os.WriteFile(ipaPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	check := newArchiveModeCheck()
+	check.expect("Payload/Fixture.app/Info.plist", &VirtualFile{}, false)
+	if err := check.verify(ipaPath, false); err != nil {
+		t.Errorf("structural check read file data: %v", err)
+	}
+	if err := check.verify(ipaPath, true); !errors.Is(err, zip.ErrChecksum) {
+		t.Errorf("--verify-output: %v, want a checksum error", err)
+	}
+}
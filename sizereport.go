@@ -0,0 +1,279 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"deb-to-ipa/pkg/schema"
+)
+
+// sizeReportTopN is how many directories and files the size report lists
+const sizeReportTopN = 15
+
+// Size report categories
+const (
+	CategoryFrameworks    = "frameworks"
+	CategoryLocalizations = "localizations"
+	CategoryAssets        = "assets"
+	CategoryBinaries      = "binaries"
+	CategoryDebug         = "debug" // .dSYM folders
+	CategoryOther         = "other"
+)
+
+// SizeItem is one file or directory in the size report
+type SizeItem struct {
+	Path       string `json:"path"`
+	Size       int64  `json:"size"`
+	Compressed int64  `json:"compressed,omitempty"` // 0 when not zipped (--extract-to)
+}
+
+// DuplicateSet is a group of byte-identical binaries
+type DuplicateSet struct {
+	SHA256 string   `json:"sha256"`
+	Size   int64    `json:"size"`
+	Paths  []string `json:"paths"`
+}
+
+// SizeReport breaks down where the bytes in a bundle go
+type SizeReport struct {
+	Schema      string           `json:"schema,omitempty"` // schema.SizeReport when printed, empty in a report
+	Total       int64            `json:"total"`
+	Compressed  int64            `json:"compressed,omitempty"`
+	Categories  map[string]int64 `json:"categories"`
+	Directories []SizeItem       `json:"directories"`
+	Files       []SizeItem       `json:"files"`
+	Duplicates  []DuplicateSet   `json:"duplicates,omitempty"`
+	Debug       []DebugContent   `json:"debug,omitempty"` // Debug info found, including what --strip-debug removed
+}
+
+// sizeCategory classifies a bundle-relative path
+func sizeCategory(relPath, executableName string) string {
+	if _, ok := dsymDir(relPath); ok {
+		return CategoryDebug
+	}
+	switch {
+	case path.Base(relPath) == "Assets.car":
+		return CategoryAssets
+	case strings.HasSuffix(relPath, ".lproj") || strings.Contains(relPath, ".lproj/"):
+		return CategoryLocalizations
+	case strings.HasPrefix(relPath, "Frameworks/") || strings.Contains(relPath, "/Frameworks/"):
+		return CategoryFrameworks
+	case relPath == executableName || strings.HasSuffix(relPath, ".dylib"):
+		return CategoryBinaries
+	default:
+		return CategoryOther
+	}
+}
+
+// isFrameworkBinary reports whether a path is the binary of a .framework (Foo.framework/Foo) or a dylib
+func isFrameworkBinary(relPath string) bool {
+	if strings.HasSuffix(relPath, ".dylib") {
+		return true
+	}
+	dir := path.Dir(relPath)
+	return strings.HasSuffix(dir, ".framework") && path.Base(relPath) == strings.TrimSuffix(path.Base(dir), ".framework")
+}
+
+// buildSizeReport tallies the bundle by file, directory and category. When archivePath is set,
+// compressed sizes are read back from the written archive's central directory. debug (may
+// be nil) is findDebugContent's result, listed as found.
+func buildSizeReport(entries []BundleEntry, executableName, archivePath, layout, appNameFolder string, debug *DebugResult) (*SizeReport, error) {
+	compressed := make(map[string]int64)
+	if archivePath != "" {
+		zr, err := zip.OpenReader(archivePath)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range zr.File {
+			compressed[f.Name] = int64(f.CompressedSize64)
+		}
+		zr.Close()
+	}
+
+	report := &SizeReport{Categories: make(map[string]int64)}
+	if debug != nil {
+		report.Debug = debug.Found
+	}
+	var files []SizeItem
+	hashGroups := make(map[string]*DuplicateSet)
+
+	for _, entry := range entries {
+		vf := entry.File
+		if vf.IsDir || vf.IsLink {
+			continue
+		}
+
+		item := SizeItem{
+			Path:       entry.RelPath,
+			Size:       vf.Size,
+			Compressed: compressed[zipEntryName(layout, appNameFolder, entry.RelPath)],
+		}
+		files = append(files, item)
+		report.Total += item.Size
+		report.Compressed += item.Compressed
+		report.Categories[sizeCategory(entry.RelPath, executableName)] += item.Size
+
+		// Duplicate binaries: same size and same SHA256
+		if isFrameworkBinary(entry.RelPath) {
+			sum, err := hashFile(vf)
+			if err != nil {
+				return nil, err
+			}
+			key := fmt.Sprintf("%d:%s", vf.Size, sum)
+			if hashGroups[key] == nil {
+				hashGroups[key] = &DuplicateSet{SHA256: sum, Size: vf.Size}
+			}
+			hashGroups[key].Paths = append(hashGroups[key].Paths, entry.RelPath)
+		}
+	}
+
+	report.Directories = directorySizes(files)
+	report.Directories = report.Directories[:min(len(report.Directories), sizeReportTopN)]
+	sort.Slice(files, func(i, j int) bool { return files[i].Size > files[j].Size })
+	report.Files = files[:min(len(files), sizeReportTopN)]
+
+	for _, set := range hashGroups {
+		if len(set.Paths) > 1 {
+			report.Duplicates = append(report.Duplicates, *set)
+		}
+	}
+	sort.Slice(report.Duplicates, func(i, j int) bool { return report.Duplicates[i].Size > report.Duplicates[j].Size })
+
+	return report, nil
+}
+
+// bundleSizeItems lists the bundle's files with their uncompressed sizes
+func bundleSizeItems(entries []BundleEntry) []SizeItem {
+	var files []SizeItem
+	for _, entry := range entries {
+		if vf := entry.File; !vf.IsDir && !vf.IsLink {
+			files = append(files, SizeItem{Path: entry.RelPath, Size: vf.Size})
+		}
+	}
+	return files
+}
+
+// directorySizes totals files into every folder above them, largest first
+func directorySizes(files []SizeItem) []SizeItem {
+	dirSizes := make(map[string]*SizeItem)
+	for _, item := range files {
+		for dir := path.Dir(item.Path); dir != "." && dir != "/"; dir = path.Dir(dir) {
+			d, ok := dirSizes[dir]
+			if !ok {
+				d = &SizeItem{Path: dir + "/"}
+				dirSizes[dir] = d
+			}
+			d.Size += item.Size
+			d.Compressed += item.Compressed
+		}
+	}
+	dirs := make([]SizeItem, 0, len(dirSizes))
+	for _, d := range dirSizes {
+		dirs = append(dirs, *d)
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		if dirs[i].Size != dirs[j].Size {
+			return dirs[i].Size > dirs[j].Size
+		}
+		return dirs[i].Path < dirs[j].Path
+	})
+	return dirs
+}
+
+// hashFile returns the hex SHA256 of a VirtualFile, streaming spilled files
+func hashFile(vf *VirtualFile) (string, error) {
+	rc, err := vf.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, rc); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// printSizeReport renders the report as text, or as JSON when asJSON is set
+func printSizeReport(report *SizeReport, asJSON bool) {
+	if asJSON {
+		doc := *report
+		doc.Schema = schema.SizeReport
+		out, _ := json.MarshalIndent(&doc, "", "  ")
+		fmt.Fprintln(jsonStdout(), string(out))
+		return
+	}
+
+	fmt.Println("\n📦 Size Report")
+	fmt.Printf("   Total: %s", formatBytes(report.Total))
+	if report.Compressed > 0 {
+		fmt.Printf(" (%s compressed)", formatBytes(report.Compressed))
+	}
+	fmt.Println()
+
+	fmt.Println("\n   By category:")
+	for _, c := range []string{CategoryFrameworks, CategoryLocalizations, CategoryAssets, CategoryBinaries, CategoryDebug, CategoryOther} {
+		if size := report.Categories[c]; size > 0 {
+			fmt.Printf("     %-14s %10s  %5.1f%%\n", c, formatBytes(size), 100*float64(size)/float64(max(report.Total, 1)))
+		}
+	}
+
+	printSizeItems("Largest directories", report.Directories)
+	printSizeItems("Largest files", report.Files)
+
+	if len(report.Debug) > 0 {
+		fmt.Println("\n   Debug info:")
+		for _, d := range report.Debug {
+			removed := ""
+			if d.Removed {
+				removed = " (removed)"
+			}
+			fmt.Printf("     %10s  %-7s  %s%s\n", formatBytes(d.Bytes), d.Kind, d.Path, removed)
+		}
+	}
+
+	if len(report.Duplicates) > 0 {
+		fmt.Println("\n   ⚠️  Duplicate binaries:")
+		for _, set := range report.Duplicates {
+			fmt.Printf("     %s x%d (sha256 %s…)\n", formatBytes(set.Size), len(set.Paths), set.SHA256[:12])
+			for _, p := range set.Paths {
+				fmt.Printf("       - %s\n", p)
+			}
+		}
+	}
+}
+
+func printSizeItems(title string, items []SizeItem) {
+	if len(items) == 0 {
+		return
+	}
+	fmt.Printf("\n   %s:\n", title)
+	for _, item := range items {
+		if item.Compressed > 0 {
+			fmt.Printf("     %10s  %10s  %s\n", formatBytes(item.Size), formatBytes(item.Compressed), item.Path)
+		} else {
+			fmt.Printf("     %10s  %s\n", formatBytes(item.Size), item.Path)
+		}
+	}
+}
+
+// formatBytes renders a byte count for humans, e.g. "12.3 MB"
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"deb-to-ipa/pkg/schema"
+)
+
+// TestConvertSizeReportJSON decodes stdout with --size-report --json as the one JSON
+// document on it, the progress lines having gone to stderr
+func TestConvertSizeReportJSON(t *testing.T) {
+	var result *Result
+	stdout, stderr := captureOutput(t, func() {
+		defer stdoutForJSON()()
+		result, _ = convertFixture(t, FixtureSpec{Framework: true}, Options{SizeReport: true, JSON: true})
+	})
+
+	dec := json.NewDecoder(strings.NewReader(stdout))
+	dec.DisallowUnknownFields()
+	var report SizeReport
+	if err := dec.Decode(&report); err != nil {
+		t.Fatalf("stdout isn't a size report: %v\n%s", err, stdout)
+	}
+	if dec.More() {
+		t.Errorf("more than the size report on stdout: %s", stdout)
+	}
+	if report.Schema != schema.SizeReport || report.Total == 0 || report.Total != result.SizeReport.Total {
+		t.Errorf("size report %+v, want schema %s and the result's total %d", report, schema.SizeReport, result.SizeReport.Total)
+	}
+	if !strings.Contains(stderr, "=>") {
+		t.Errorf("no progress on stderr: %q", stderr)
+	}
+}
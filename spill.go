@@ -0,0 +1,268 @@
+package main
+
+import (
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// --- Spill directory: where files too big for the RAM budget go ---
+// By default a folder in the system temp dir, which is often a small tmpfs. --temp-dir
+// picks another; without it, a temp dir that can't be created falls back to the
+// output's directory. --max-spill-size caps what one conversion may write there, so a
+// pathological deb can't fill a volume other jobs share.
+//
+// With --compress-spill, spill files are deflated at the fastest level on the way out and
+// inflated again whenever they're read (VirtualFile.Open): a third way of keeping a file,
+// next to RAM and raw on disk, that trades CPU for temp space. Go ships no lz4 or snappy;
+// flate at BestSpeed comes closest. By default (auto) a file is compressed only when what's
+// expected to spill no longer fits in the free space.
+
+// Values of --compress-spill; a bare flag means on
+const (
+	SpillCompressAuto = "auto"
+	SpillCompressOn   = "on"
+	SpillCompressOff  = "off"
+)
+
+// spillCompression is the --compress-spill flag
+type spillCompression string
+
+func (c *spillCompression) String() string   { return string(*c) }
+func (c *spillCompression) IsBoolFlag() bool { return true }
+func (c *spillCompression) Set(v string) error {
+	switch v {
+	case "true", SpillCompressOn:
+		*c = SpillCompressOn
+	case "false", SpillCompressOff:
+		*c = SpillCompressOff
+	case SpillCompressAuto:
+		*c = SpillCompressAuto
+	default:
+		return fmt.Errorf("want auto, on or off, got %q", v)
+	}
+	return nil
+}
+
+// spillFlateWriters reuses the fast compressors spill files are written through
+var spillFlateWriters = sync.Pool{New: func() any {
+	fw, _ := flate.NewWriter(nil, flate.BestSpeed)
+	return fw
+}}
+
+// errSpillQuota stops a spill file's copy at the quota
+var errSpillQuota = errors.New("spill quota reached")
+
+// quotaWriter fails a write that would take the spill directory past the quota
+type quotaWriter struct {
+	w    io.Writer
+	left int64
+}
+
+func (q *quotaWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > q.left {
+		return 0, errSpillQuota
+	}
+	n, err := q.w.Write(p)
+	q.left -= int64(n)
+	return n, err
+}
+
+// compressedReader inflates a spill file, closing it along with the inflater
+type compressedReader struct {
+	io.ReadCloser // The inflater
+	file          io.Closer
+}
+
+func (c compressedReader) Close() error {
+	c.ReadCloser.Close()
+	return c.file.Close()
+}
+
+// SpillInfo is where spilled files went and how much room they took, for planning --temp-dir
+type SpillInfo struct {
+	Dir        string `json:"dir"`
+	Files      int    `json:"files"`
+	Bytes      int64  `json:"bytes"`                // On disk
+	RawBytes   int64  `json:"rawBytes"`             // Before --compress-spill; Bytes when nothing was compressed
+	Compressed int    `json:"compressed,omitempty"` // Files written compressed
+	PeakOpen   int    `json:"peakOpen"`             // Most files on disk open at once while reading them
+	OpenLimit  int    `json:"openLimit"`            // The bound on that, from the process's descriptor limit
+	Quota      int64  `json:"quota,omitempty"`      // --max-spill-size; 0 when unlimited
+	Exceeded   bool   `json:"exceeded,omitempty"`   // The conversion stopped at the quota
+}
+
+// SpillQuotaError is returned when spilling a file would take a conversion past --max-spill-size
+type SpillQuotaError struct {
+	Quota   int64 // The limit
+	Spilled int64 // Bytes in the spill directory before the file that didn't fit
+	Files   int   // Files spilled before it
+}
+
+func (e *SpillQuotaError) Error() string {
+	return fmt.Sprintf("spill quota exceeded: a file over the RAM budget would take the spill directory past --max-spill-size %s (%s in %d file(s) already)",
+		formatBytes(e.Quota), formatBytes(e.Spilled), e.Files)
+}
+
+// info is the spill summary a report carries for a conversion stopped by the quota
+func (e *SpillQuotaError) info() *SpillInfo {
+	return &SpillInfo{Files: e.Files, Bytes: e.Spilled, Quota: e.Quota, Exceeded: true}
+}
+
+// byteSize is a flag for sizes like 500M or 2G (powers of 1024), or a plain byte count
+type byteSize int64
+
+func (b *byteSize) String() string { return strconv.FormatInt(int64(*b), 10) }
+func (b *byteSize) Set(v string) error {
+	n, err := parseByteSize(v)
+	if err != nil {
+		return err
+	}
+	*b = byteSize(n)
+	return nil
+}
+
+// parseByteSize reads a byte count with an optional K, M, G or T suffix (KB, KiB and the
+// like work too)
+func parseByteSize(v string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(v))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+	shift := 0
+	if s != "" {
+		if i := strings.IndexByte("KMGT", s[len(s)-1]); i >= 0 {
+			shift = 10 * (i + 1)
+			s = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("want a size like 500M, 2G or 1048576, got %q", v)
+	}
+	return int64(n * float64(int64(1)<<shift)), nil
+}
+
+// makeSpillDir creates the conversion's spill directory: in tempDir when given, else the
+// system temp dir, else beside the output
+func makeSpillDir(tempDir, outputDir string, warnings *warningLog) (string, error) {
+	if tempDir != "" {
+		dir, err := os.MkdirTemp(tempDir, "ipa-spill")
+		if err != nil {
+			return "", fmt.Errorf("--temp-dir: %w", err)
+		}
+		return dir, nil
+	}
+	dir, err := os.MkdirTemp("", "ipa-spill")
+	if err == nil {
+		return dir, nil
+	}
+	fallback, fallbackErr := os.MkdirTemp(outputDir, ".ipa-spill")
+	if fallbackErr != nil {
+		return "", fmt.Errorf("creating a spill directory: %w (and in %s: %v; use --temp-dir)", err, outputDir, fallbackErr)
+	}
+	warnings.add("spill-fallback", "", "Can't create a temp directory (%v); spilled to %s instead", err, fallback)
+	return fallback, nil
+}
+
+// spill writes r to a new numbered file in the spill directory and points vf at it,
+// compressed when shouldCompress says so. A file that would break the quota is removed as
+// soon as it does, with a *SpillQuotaError.
+func (s *SpillStore) spill(vf *VirtualFile, r io.Reader) error {
+	compress := s.shouldCompress(vf.Size)
+	s.SpillCount++
+	tempPath := filepath.Join(s.Dir, fmt.Sprintf("spill_%d", s.SpillCount))
+	f, err := os.OpenFile(tempPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return spillDirError(s.Dir, err)
+	}
+	written := &countingWriter{w: s.flow.writer(flowSpill, f)}
+	dst := io.Writer(written)
+	if s.Quota > 0 {
+		dst = &quotaWriter{w: written, left: s.Quota - s.SpillBytes}
+	}
+	var n int64
+	if compress {
+		fw := spillFlateWriters.Get().(*flate.Writer)
+		fw.Reset(dst)
+		if n, err = io.Copy(fw, r); err == nil {
+			err = fw.Close()
+		}
+		spillFlateWriters.Put(fw)
+	} else {
+		n, err = io.Copy(dst, r)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if errors.Is(err, errSpillQuota) {
+		os.Remove(tempPath)
+		return &SpillQuotaError{Quota: s.Quota, Spilled: s.SpillBytes, Files: s.SpillCount - 1}
+	}
+	s.SpillBytes += written.n
+	s.SpillRaw += n
+	if err != nil {
+		return spillDirError(s.Dir, err)
+	}
+	if compress {
+		s.SpillCompressed++
+	}
+	vf.Data = nil
+	vf.DiskPath = tempPath
+	vf.Compressed = compress
+	return nil
+}
+
+// shouldCompress decides whether a file of size bytes is spilled compressed: always or
+// never when --compress-spill says so, and by default when the free space left is less
+// than what's expected to spill from here on (at least this file)
+func (s *SpillStore) shouldCompress(size int64) bool {
+	switch s.Compress {
+	case SpillCompressOn:
+		return true
+	case SpillCompressOff:
+		return false
+	}
+	free := diskFree(s.Dir)
+	return free >= 0 && max(s.ExpectedSpill-s.SpillRaw, size) > free
+}
+
+// info summarizes the spill directory's use, nil when nothing was spilled
+func (s *SpillStore) info() *SpillInfo {
+	if s.SpillCount == 0 {
+		return nil
+	}
+	return &SpillInfo{Dir: s.Dir, Files: s.SpillCount, Bytes: s.SpillBytes, RawBytes: s.SpillRaw, Compressed: s.SpillCompressed,
+		PeakOpen: fileHandles.peakOpen(), OpenLimit: fileHandles.limit, Quota: s.Quota}
+}
+
+// spillDirError turns a full disk or running out of descriptors into advice; other errors
+// pass through unchanged
+func spillDirError(dir string, err error) error {
+	if errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT) {
+		return fmt.Errorf("spill directory %s is full; re-run with --temp-dir pointing at a larger disk: %w", dir, err)
+	}
+	return tooManyFilesError(err)
+}
+
+// printSpill tells where spilled files went and how much room they needed
+func printSpill(info *SpillInfo) {
+	if info == nil {
+		return
+	}
+	quota := ""
+	if info.Quota > 0 {
+		quota = fmt.Sprintf(" of the %s quota", formatBytes(info.Quota))
+	}
+	size := formatBytes(info.Bytes)
+	if info.Compressed > 0 {
+		size = fmt.Sprintf("%s compressed to %s (%d file(s) compressed)", formatBytes(info.RawBytes), formatBytes(info.Bytes), info.Compressed)
+	}
+	fmt.Printf("   Spilled %d file(s), %s%s, to %s (up to %d open at once, of %d allowed)\n",
+		info.Files, size, quota, info.Dir, info.PeakOpen, info.OpenLimit)
+}
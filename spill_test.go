@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// lowerMemoryLimit makes files past about budget bytes spill for the rest of the test
+func lowerMemoryLimit(t *testing.T, budget int64) {
+	old := memoryLimit
+	memoryLimit = zipWriterMemory + budget
+	t.Cleanup(func() { memoryLimit = old })
+}
+
+// TestConvertCompressSpill spills with and without --compress-spill: the IPAs are byte
+// for byte the same, and the compressed spill takes less disk than the data it holds
+func TestConvertCompressSpill(t *testing.T) {
+	lowerMemoryLimit(t, 1<<20)
+	spec := FixtureSpec{Compression: "gz", Framework: true, FrameworkPad: 3 << 20, LargeFile: 6 << 20}
+	outputs := make(map[string][]byte)
+	for _, compress := range []string{SpillCompressOff, SpillCompressOn} {
+		result, data, err := tryConvertFixture(t, spec, Options{CompressSpill: compress})
+		if err != nil {
+			t.Fatalf("--compress-spill %s: %v", compress, err)
+		}
+		spill := result.Spill
+		if spill == nil || spill.Files < 2 {
+			t.Fatalf("--compress-spill %s: spilled %+v, want the framework binary and large.bin", compress, spill)
+		}
+		switch {
+		case compress == SpillCompressOff && (spill.Compressed != 0 || spill.Bytes != spill.RawBytes):
+			t.Errorf("--compress-spill off: %d of %d files compressed, %d bytes on disk for %d", spill.Compressed, spill.Files, spill.Bytes, spill.RawBytes)
+		case compress == SpillCompressOn && (spill.Compressed != spill.Files || spill.Bytes >= spill.RawBytes/10):
+			t.Errorf("--compress-spill on: %d of %d files compressed, %d bytes on disk for %d", spill.Compressed, spill.Files, spill.Bytes, spill.RawBytes)
+		}
+		outputs[compress] = data
+	}
+	if !bytes.Equal(outputs[SpillCompressOff], outputs[SpillCompressOn]) {
+		t.Error("the IPA differs with --compress-spill")
+	}
+}
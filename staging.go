@@ -0,0 +1,271 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// --- Staging: build the IPA locally, then copy it out ---
+// Writing a multi-gigabyte IPA straight to an NFS or SMB share fails partway often enough
+// to hurt, and the whole conversion then starts over. With --staging DIR the IPA is
+// written to fast local storage, then copied to the output a chunk at a time, checked by
+// size and SHA256, and only then renamed into place. A state file beside the staged IPA
+// records how far the copy got: a failed copy is retried from there, and if every try
+// fails, running the same command again resumes the copy without converting again.
+
+// stagedCopyChunk is how much is copied between updates of the state file
+const stagedCopyChunk = 8 << 20
+
+// stagedCopyAttempts is how often one run tries the copy before giving up on it
+const stagedCopyAttempts = 3
+
+// stagedCopyBackoff is the wait before the second try, doubled for each after that
+var stagedCopyBackoff = 2 * time.Second
+
+// errStagedCopySpace is a destination without room for the rest of the copy, not worth retrying
+var errStagedCopySpace = errors.New("not enough free space")
+
+// StagingInfo describes the copy of a staged IPA to its output, for --report
+type StagingInfo struct {
+	Dir      string `json:"dir"`
+	Bytes    int64  `json:"bytes"`
+	SHA256   string `json:"sha256"`
+	Attempts int    `json:"attempts"`          // Copies tried this run, resumed or from the start
+	Resumed  bool   `json:"resumed,omitempty"` // Copied from a previous run's staged IPA, without converting
+}
+
+// stagedCopy is the state file kept beside a staged IPA, and how far its copy got
+type stagedCopy struct {
+	Output       string    `json:"output"`
+	Input        string    `json:"input"` // The deb, by path, size and time, so a changed one is converted afresh
+	InputSize    int64     `json:"inputSize"`
+	InputModTime time.Time `json:"inputModTime"`
+	Args         []string  `json:"args"` // The command line, so different flags convert afresh too
+	Staged       string    `json:"staged"`
+	Size         int64     `json:"size"`
+	SHA256       string    `json:"sha256"`
+	Copied       int64     `json:"copied"` // Bytes of Staged known to be in the destination's partial file
+	Manifest     *Manifest `json:"manifest,omitempty"`
+
+	dir   string
+	state string // This file's path
+}
+
+// newStagedCopy describes staging the conversion of debPath to ipaPath in dir
+func newStagedCopy(dir, debPath, ipaPath string) (*stagedCopy, error) {
+	info, err := os.Stat(debPath)
+	if err != nil {
+		return nil, err
+	}
+	output, err := filepath.Abs(ipaPath)
+	if err != nil {
+		return nil, err
+	}
+	// Named after the output, with a hash of its full path for outputs of one name in different folders
+	sum := sha256.Sum256([]byte(output))
+	stem := filepath.Join(dir, fmt.Sprintf("%s-%s", filepath.Base(output), hex.EncodeToString(sum[:4])))
+	return &stagedCopy{Output: output, Input: debPath, InputSize: info.Size(), InputModTime: info.ModTime(), Args: os.Args[1:],
+		Staged: stem + ".staged", dir: dir, state: stem + ".staged.json"}, nil
+}
+
+// checkStagingDir fails up front when dir can't take a staged IPA. Its size isn't known
+// yet; the input's is the estimate, as an IPA is rarely much smaller than its deb.
+func checkStagingDir(c *stagedCopy) error {
+	if err := probeOutputDir(c.Staged); err != nil {
+		return fmt.Errorf("--staging: %w", err)
+	}
+	if free := diskFree(c.dir); free >= 0 && free < c.InputSize {
+		return fmt.Errorf("--staging: %s has %s free, less than the %s input", c.dir, formatBytes(free), formatBytes(c.InputSize))
+	}
+	return nil
+}
+
+// resumable loads the state a previous run left for the same conversion, or returns false
+// when there is none. A state file for the same output that doesn't match is stale, and
+// it goes along with its staged IPA.
+func (c *stagedCopy) resumable() bool {
+	data, err := os.ReadFile(c.state)
+	if err != nil {
+		return false
+	}
+	var prev stagedCopy
+	if json.Unmarshal(data, &prev) == nil && prev.Output == c.Output && prev.Input == c.Input && prev.InputSize == c.InputSize &&
+		prev.InputModTime.Equal(c.InputModTime) && slices.Equal(prev.Args, c.Args) && prev.Staged == c.Staged && prev.SHA256 != "" {
+		if info, err := os.Stat(prev.Staged); err == nil && info.Size() == prev.Size {
+			prev.dir, prev.state = c.dir, c.state
+			*c = prev
+			return true
+		}
+	}
+	fmt.Printf("   Discarding a staged IPA for %s from a different conversion\n", filepath.Base(c.Output))
+	os.Remove(c.Staged)
+	os.Remove(c.state)
+	return false
+}
+
+// stage moves the finished IPA at partial to the staged path, hashes it and records it
+func (c *stagedCopy) stage(partial string, manifest *Manifest) error {
+	if err := os.Rename(partial, c.Staged); err != nil {
+		return err
+	}
+	size, sum, err := fileSHA256(c.Staged)
+	if err != nil {
+		return err
+	}
+	c.Size, c.SHA256, c.Copied, c.Manifest = size, sum, 0, manifest
+	return c.save()
+}
+
+// save writes the state file, replacing it whole so an interruption can't leave half of one
+func (c *stagedCopy) save() error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := c.state + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.state)
+}
+
+// deliver copies the staged IPA to the output, retrying from where a failed try stopped.
+// On success the staged IPA and its state go; on failure both stay for the next run.
+func (c *stagedCopy) deliver(resumed bool) (*StagingInfo, error) {
+	info := &StagingInfo{Dir: c.dir, Bytes: c.Size, SHA256: c.SHA256, Resumed: resumed}
+	backoff := stagedCopyBackoff
+	var err error
+	for info.Attempts < stagedCopyAttempts {
+		if info.Attempts > 0 {
+			fmt.Printf("   Copy failed (%v); retrying in %s from %s of %s\n", err, backoff, formatBytes(c.Copied), formatBytes(c.Size))
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		info.Attempts++
+		if err = c.copyOut(); err == nil || errors.Is(err, errStagedCopySpace) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("copying the staged IPA to %s: %w (it's kept in %s; run the same command again to resume the copy)", c.Output, err, c.dir)
+	}
+	os.Remove(c.Staged)
+	os.Remove(c.state)
+	return info, nil
+}
+
+// destPartial is where the copy collects beside the output. It doesn't end in .partial:
+// lockOutput removes those after a crashed run, and this one is what the next run resumes.
+func (c *stagedCopy) destPartial() string {
+	return filepath.Join(filepath.Dir(c.Output), "."+filepath.Base(c.Output)+".staged")
+}
+
+// copyOut makes one try at copying the staged IPA into a partial file beside the output,
+// picking up after the bytes already there, then checks the whole copy against the
+// staged IPA's size and SHA256 before renaming it into place. A copy that doesn't match
+// is thrown away, so the next try starts from scratch.
+func (c *stagedCopy) copyOut() error {
+	destDir := filepath.Dir(c.Output)
+	partial := c.destPartial()
+	dst, err := os.OpenFile(partial, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return outputDirError(destDir, err)
+	}
+	defer dst.Close()
+	if info, err := dst.Stat(); err != nil {
+		return err
+	} else if info.Size() < c.Copied {
+		c.Copied = info.Size() // The destination lost what it had acknowledged
+	}
+	if free := diskFree(destDir); free >= 0 && free < c.Size-c.Copied {
+		return fmt.Errorf("%s has %s free, %s short of the rest of the IPA: %w", destDir, formatBytes(free), formatBytes(c.Size-c.Copied-free), errStagedCopySpace)
+	}
+
+	src, err := os.Open(c.Staged)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if err := dst.Truncate(c.Copied); err != nil {
+		return err
+	}
+	if _, err := dst.Seek(c.Copied, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := src.Seek(c.Copied, io.SeekStart); err != nil {
+		return err
+	}
+	bar := newProgressBar(c.Size, "Copying IPA")
+	bar.Set64(c.Copied)
+	for c.Copied < c.Size {
+		n, err := io.CopyN(io.MultiWriter(dst, bar), src, min(stagedCopyChunk, c.Size-c.Copied))
+		if err != nil {
+			return err
+		}
+		// Only what the destination has flushed counts as copied
+		if err := syncFile(dst); err != nil {
+			return err
+		}
+		c.Copied += n
+		if err := c.save(); err != nil {
+			return err
+		}
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+
+	size, sum, err := fileSHA256(partial)
+	if err != nil {
+		return err
+	}
+	if size != c.Size || sum != c.SHA256 {
+		os.Remove(partial)
+		c.Copied = 0
+		c.save()
+		return fmt.Errorf("the copy doesn't match the staged IPA (%s, SHA256 %.12s; want %s, %.12s)", formatBytes(size), sum, formatBytes(c.Size), c.SHA256)
+	}
+	return moveIntoPlace(partial, c.Output)
+}
+
+// printStaging reports the copy of a staged IPA
+func printStaging(info *StagingInfo) {
+	if info == nil {
+		return
+	}
+	how := "Copied"
+	if info.Resumed {
+		how = "Resumed copying"
+	}
+	tries := ""
+	if info.Attempts > 1 {
+		tries = fmt.Sprintf(" after %d tries", info.Attempts)
+	}
+	fmt.Printf("   %s the IPA (%s) from %s%s, SHA256 verified\n", how, formatBytes(info.Bytes), info.Dir, tries)
+}
+
+// resumeStagedCopy finishes a previous run's copy in place of converting. The result
+// carries only what the state file kept: the output, its manifest and the copy itself.
+func resumeStagedCopy(c *stagedCopy, opts Options) (*Result, error) {
+	fmt.Printf("=> Found the IPA staged by a previous run, %s of %s copied. Resuming the copy...\n", formatBytes(c.Copied), formatBytes(c.Size))
+	info, err := c.deliver(true)
+	if err != nil {
+		return nil, err
+	}
+	printStaging(info)
+	result := &Result{OutputPath: c.Output, Manifest: c.Manifest, Staging: info}
+	if result.Manifest != nil && opts.Report == "" {
+		if err := writeManifest(manifestPathFor(c.Output), result.Manifest); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// stageTestIPA stages data as the IPA converted from a stand-in deb, returning its copy
+// state and the folder the output goes to
+func stageTestIPA(t *testing.T, data []byte) (*stagedCopy, string) {
+	t.Helper()
+	old := stagedCopyBackoff
+	stagedCopyBackoff = 0
+	t.Cleanup(func() { stagedCopyBackoff = old })
+
+	dir := t.TempDir()
+	debPath, stagingDir, outDir := filepath.Join(dir, "app.deb"), filepath.Join(dir, "staging"), filepath.Join(dir, "out")
+	for _, d := range []string{stagingDir, outDir} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(debPath, []byte("deb"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := newStagedCopy(stagingDir, debPath, filepath.Join(outDir, "app.ipa"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	partial := filepath.Join(stagingDir, ".app.ipa.partial")
+	if err := os.WriteFile(partial, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.stage(partial, nil); err != nil {
+		t.Fatal(err)
+	}
+	return c, outDir
+}
+
+// stagingData is an IPA's worth of bytes over a few copy chunks
+func stagingData() []byte {
+	data := make([]byte, 2*stagedCopyChunk+12345)
+	rand.New(rand.NewSource(1)).Read(data)
+	return data
+}
+
+// checkDelivered checks the output holds data and the staged IPA and its state are gone
+func checkDelivered(t *testing.T, c *stagedCopy, data []byte) {
+	t.Helper()
+	if got, err := os.ReadFile(c.Output); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("output: %d bytes, %v; want the staged %d", len(got), err, len(data))
+	}
+	for _, p := range []string{c.Staged, c.state, c.destPartial()} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("%s left behind: %v", filepath.Base(p), err)
+		}
+	}
+}
+
+// writeDestPartial leaves a previous copy's partial file in the output folder
+func writeDestPartial(t *testing.T, c *stagedCopy, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(c.destPartial(), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestStagedCopyResume picks up a copy a previous run left a chunk into, without copying
+// that chunk again
+func TestStagedCopyResume(t *testing.T) {
+	data := stagingData()
+	c, _ := stageTestIPA(t, data)
+	writeDestPartial(t, c, data[:stagedCopyChunk])
+	c.Copied = stagedCopyChunk
+	if err := c.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	next, err := newStagedCopy(c.dir, c.Input, c.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !next.resumable() || next.Copied != stagedCopyChunk {
+		t.Fatalf("state not picked up: copied %d, want %d", next.Copied, stagedCopyChunk)
+	}
+	info, err := next.deliver(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.Resumed || info.Attempts != 1 || info.Bytes != int64(len(data)) {
+		t.Errorf("staging info %+v", info)
+	}
+	checkDelivered(t, next, data)
+}
+
+// TestStagedCopyResumeAfterKill kills a run holding the output's lock a chunk into its
+// copy: the next run's cleanup of the crashed run's partials keeps the copy, which resumes
+func TestStagedCopyResumeAfterKill(t *testing.T) {
+	data := stagingData()
+	c, _ := stageTestIPA(t, data)
+	holder, _ := lockHolderProcess(t, c.Output)
+	writeDestPartial(t, c, data[:stagedCopyChunk])
+	c.Copied = stagedCopyChunk
+	if err := c.save(); err != nil {
+		t.Fatal(err)
+	}
+	holder.Process.Kill()
+	holder.Wait()
+
+	lock, err := lockOutput(c.Output, false)
+	if err != nil {
+		t.Fatalf("lock after the holder was killed: %v", err)
+	}
+	defer lock.release()
+	if partials, _ := filepath.Glob(filepath.Join(filepath.Dir(c.Output), ".app.ipa.*.partial")); len(partials) != 0 {
+		t.Errorf("crashed run's partials left: %v", partials)
+	}
+	if info, err := os.Stat(c.destPartial()); err != nil || info.Size() != stagedCopyChunk {
+		t.Fatalf("the copy's partial didn't survive the cleanup: %v", err)
+	}
+
+	next, err := newStagedCopy(c.dir, c.Input, c.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !next.resumable() || next.Copied != stagedCopyChunk {
+		t.Fatalf("state not picked up: copied %d, want %d", next.Copied, stagedCopyChunk)
+	}
+	info, err := next.deliver(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.Resumed || info.Attempts != 1 {
+		t.Errorf("staging info %+v, want one resumed attempt", info)
+	}
+	checkDelivered(t, next, data)
+}
+
+// TestStagedCopyShortPartial copies again what the destination lost of what it acknowledged
+func TestStagedCopyShortPartial(t *testing.T) {
+	data := stagingData()
+	c, _ := stageTestIPA(t, data)
+	writeDestPartial(t, c, data[:1000])
+	c.Copied = stagedCopyChunk
+	if _, err := c.deliver(false); err != nil {
+		t.Fatal(err)
+	}
+	checkDelivered(t, c, data)
+}
+
+// TestStagedCopyHashMismatch throws away a copy that doesn't match and starts over
+func TestStagedCopyHashMismatch(t *testing.T) {
+	data := stagingData()
+	c, _ := stageTestIPA(t, data)
+	corrupt := bytes.Clone(data[:stagedCopyChunk])
+	corrupt[100] ^= 0xff
+	writeDestPartial(t, c, corrupt)
+	c.Copied = stagedCopyChunk
+
+	info, err := c.deliver(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Attempts != 2 {
+		t.Errorf("%d attempts, want 2: the mismatched copy, then one from scratch", info.Attempts)
+	}
+	checkDelivered(t, c, data)
+}
+
+// TestStagedCopyDestinationGone keeps the staged IPA when the output folder vanishes, and
+// the next run copies it once the folder is back
+func TestStagedCopyDestinationGone(t *testing.T) {
+	data := stagingData()
+	c, outDir := stageTestIPA(t, data)
+	if err := os.RemoveAll(outDir); err != nil {
+		t.Fatal(err)
+	}
+	_, err := c.deliver(false)
+	if err == nil || !strings.Contains(err.Error(), "run the same command again") {
+		t.Fatalf("deliver to a vanished folder: %v", err)
+	}
+	if info, err := os.Stat(c.Staged); err != nil || info.Size() != int64(len(data)) {
+		t.Fatalf("staged IPA not kept: %v", err)
+	}
+
+	if err := os.Mkdir(outDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	next, err := newStagedCopy(c.dir, c.Input, c.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !next.resumable() {
+		t.Fatal("the kept staged IPA isn't resumable")
+	}
+	if _, err := next.deliver(true); err != nil {
+		t.Fatal(err)
+	}
+	checkDelivered(t, next, data)
+}
+
+// TestStagedCopyStale discards a staged IPA from a conversion with other flags
+func TestStagedCopyStale(t *testing.T) {
+	c, _ := stageTestIPA(t, []byte("ipa"))
+	c.Args = append(c.Args, "--bundle-id", "com.example.other")
+	if err := c.save(); err != nil {
+		t.Fatal(err)
+	}
+	next, err := newStagedCopy(c.dir, c.Input, c.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.resumable() {
+		t.Fatal("resumed a staged IPA converted with other flags")
+	}
+	for _, p := range []string{c.Staged, c.state} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("stale %s left: %v", filepath.Base(p), err)
+		}
+	}
+}
+
+// TestConvertStaging converts through a staging folder, which is left empty
+func TestConvertStaging(t *testing.T) {
+	staging := t.TempDir()
+	result, zr := convertFixture(t, FixtureSpec{}, Options{Staging: staging})
+	checkFixtureApp(t, zr, fixtureApp)
+	if result.Staging == nil || result.Staging.Attempts != 1 || result.Staging.Resumed {
+		t.Errorf("staging info %+v", result.Staging)
+	}
+	if left, _ := os.ReadDir(staging); len(left) != 0 {
+		t.Errorf("staging folder not emptied: %v", left)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"path"
+)
+
+// --- Storage policy: RAM, spill or skip ---
+// Every entry read from an input is held in RAM, spilled to disk or skipped without its
+// data being read. Those rules used to be spread through the read loops; they live here,
+// in order:
+//
+//	left out by the two-pass index                  skip
+//	outside the app folder (--app-prefix)           skip, unless --bundle-linked-resources
+//	excluded by --exclude                           skip
+//	a directory or symlink                          RAM (metadata only, charged by track)
+//	a bundle's Info.plist up to storageForcedLimit  RAM, even past the budget
+//	fits in what's left of the budget               RAM
+//	anything else                                   spill
+//
+// A huge single file therefore spills while the plists naming the app stay readable, and
+// many tiny files spill once their data and metadata (see memory.go) use the budget up.
+
+// storageForcedLimit is the largest bundle Info.plist kept in RAM whatever the budget:
+// it's parsed to identify the app, and the read loop only captures it from RAM
+const storageForcedLimit = candidatePlistLimit
+
+// Storage is where an entry's data goes
+type Storage int
+
+const (
+	StoreRAM Storage = iota
+	StoreSpill
+	StoreSkip
+)
+
+func (s Storage) String() string {
+	return [...]string{"ram", "spill", "skip"}[s]
+}
+
+// StorageEntry is what the policy decides on: an entry's header, and what the reader
+// already knows about it
+type StorageEntry struct {
+	Name      string
+	Type      byte // tar type flag, for the trace; 0 when not from a tar
+	Size      int64
+	HasData   bool // A regular file; directories and symlinks have only metadata
+	Unplanned bool // Left out by the two-pass index
+	Outside   bool // Outside the app folder, and not kept for --bundle-linked-resources
+	Excluded  bool // Matched by --exclude
+}
+
+// StoragePolicy decides where each entry of a conversion goes, against its store's budget
+type StoragePolicy struct {
+	store   *SpillStore
+	verbose bool // Log every decision but the routine ones: RAM within budget, metadata
+}
+
+// policy is the store's storage policy; with verbose, decisions are logged as they're made
+func (s *SpillStore) policy(verbose bool) StoragePolicy {
+	return StoragePolicy{store: s, verbose: verbose}
+}
+
+// Decide returns where e goes and why
+func (p StoragePolicy) Decide(e StorageEntry) (Storage, string) {
+	switch {
+	case e.Unplanned:
+		return StoreSkip, "left out by the index"
+	case e.Outside:
+		return StoreSkip, "outside the app folder"
+	case e.Excluded:
+		return StoreSkip, "excluded by --exclude"
+	case !e.HasData:
+		return StoreRAM, "metadata only"
+	case isBundleInfoPlist(e.Name) && e.Size <= storageForcedLimit:
+		return StoreRAM, "a bundle's Info.plist, always held"
+	case p.store.fits(e.Size):
+		return StoreRAM, "fits in the RAM budget"
+	}
+	left := max(p.store.budget()-p.store.RamUsage, 0)
+	return StoreSpill, fmt.Sprintf("%s is over the %s left of the RAM budget", formatBytes(e.Size), formatBytes(left))
+}
+
+// decide is Decide, logging the decision with --verbose and to the trace
+func (p StoragePolicy) decide(e StorageEntry) Storage {
+	storage, reason := p.Decide(e)
+	trace.entry(e, storage, reason)
+	if p.verbose && (storage != StoreRAM || (e.HasData && !p.store.fits(e.Size))) {
+		fmt.Printf("   %-7s %s (%s)\n", storage, terminalSafe(e.Name), reason)
+	}
+	return storage
+}
+
+// isBundleInfoPlist reports whether name is the Info.plist at the root of a bundle folder
+func isBundleInfoPlist(name string) bool {
+	dir := path.Dir(name)
+	return path.Base(name) == "Info.plist" && dir != "." && path.Ext(dir) != ""
+}
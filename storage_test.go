@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStoragePolicyDecide(t *testing.T) {
+	budget := (&SpillStore{}).budget()
+	const plist = "Applications/Fixture.app/Info.plist"
+	for _, c := range []struct {
+		name     string
+		ramUsage int64 // Already held
+		window   int64 // Decompressor dictionary held back
+		entry    StorageEntry
+		want     Storage
+		reason   string // Part of the reason given
+	}{
+		{"small file", 0, 0, StorageEntry{Name: "a", Size: 1 << 20, HasData: true}, StoreRAM, "fits"},
+		{"just under the budget", budget - 100, 0, StorageEntry{Name: "a", Size: 99, HasData: true}, StoreRAM, "fits"},
+		{"exactly the budget", budget - 100, 0, StorageEntry{Name: "a", Size: 100, HasData: true}, StoreSpill, "over the 100 B left"},
+		{"bigger than the budget", 0, 0, StorageEntry{Name: "a", Size: budget + 1, HasData: true}, StoreSpill, "over"},
+		{"budget used up", budget, 0, StorageEntry{Name: "a", Size: 1, HasData: true}, StoreSpill, "over the 0 B left"},
+		{"budget overshot", budget + 1<<20, 0, StorageEntry{Name: "a", Size: 1, HasData: true}, StoreSpill, "over the 0 B left"},
+		{"window held back", budget - 100, 50, StorageEntry{Name: "a", Size: 60, HasData: true}, StoreSpill, "over the 50 B left"},
+		{"directory past the budget", budget, 0, StorageEntry{Name: "Applications/", Type: '5'}, StoreRAM, "metadata only"},
+		{"symlink past the budget", budget, 0, StorageEntry{Name: "l", Type: '2'}, StoreRAM, "metadata only"},
+		{"Info.plist past the budget", budget, 0, StorageEntry{Name: plist, Size: storageForcedLimit, HasData: true}, StoreRAM, "always held"},
+		{"huge Info.plist", budget, 0, StorageEntry{Name: plist, Size: storageForcedLimit + 1, HasData: true}, StoreSpill, "over"},
+		{"Info.plist outside a bundle", budget, 0, StorageEntry{Name: "var/Info.plist", Size: 10, HasData: true}, StoreSpill, "over"},
+		{"unplanned", 0, 0, StorageEntry{Name: plist, Size: 10, HasData: true, Unplanned: true, Excluded: true}, StoreSkip, "left out by the index"},
+		{"outside the app", 0, 0, StorageEntry{Name: "var/a", Size: 10, HasData: true, Outside: true, Excluded: true}, StoreSkip, "outside the app folder"},
+		{"excluded", 0, 0, StorageEntry{Name: "Applications/Fixture.app/a.car", Size: 10, HasData: true, Excluded: true}, StoreSkip, "--exclude"},
+		{"excluded directory", 0, 0, StorageEntry{Name: "Applications/Fixture.app/x/", Excluded: true}, StoreSkip, "--exclude"},
+	} {
+		store := &SpillStore{RamUsage: c.ramUsage, Window: c.window}
+		got, reason := store.policy(false).Decide(c.entry)
+		if got != c.want || !strings.Contains(reason, c.reason) {
+			t.Errorf("%s: %s (%s), want %s (%s)", c.name, got, reason, c.want, c.reason)
+		}
+	}
+}
+
+func TestIsBundleInfoPlist(t *testing.T) {
+	for name, want := range map[string]bool{
+		"Applications/Fixture.app/Info.plist":                        true,
+		"Applications/Fixture.app/PlugIns/Widget.appex/Info.plist":   true,
+		"Applications/Fixture.app/Frameworks/F.framework/Info.plist": true,
+		"Info.plist": false,
+		"Applications/Fixture.app/Resources/Info.plist": false,
+		"Applications/Fixture.app/Info.plist.bak":       false,
+	} {
+		if got := isBundleInfoPlist(name); got != want {
+			t.Errorf("isBundleInfoPlist(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// --- Plain tarballs: an app tree without the deb around it ---
+// Devices are often backed up as a tarball of the filesystem rather than a deb. That is a
+// data.tar with no ar wrapper and no control.tar, so it goes straight to extraction, app
+// detection and zipping; only the control metadata is missing.
+
+// tarballSuffixes are stripped from a tarball's name for the output's, longest first
+var tarballSuffixes = []string{".tar.gz", ".tar.xz", ".tar.bz2", ".tar.lzma", ".tgz", ".txz", ".tbz2", ".tar"}
+
+// plainTarName recognizes a tarball by its magic, returning the data.tar member name that
+// picks its decompressor. A deb (an ar archive) or anything unrecognized isn't one.
+func plainTarName(f io.ReaderAt) (string, bool) {
+	head := make([]byte, 512)
+	n, _ := f.ReadAt(head, 0)
+	head = head[:n]
+	switch {
+	case bytes.HasPrefix(head, []byte("!<arch>\n")):
+		return "", false
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		return "data.tar.gz", true
+	case bytes.HasPrefix(head, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}):
+		return "data.tar.xz", true
+	case bytes.HasPrefix(head, []byte("BZh")):
+		return "data.tar.bz2", true
+	case bytes.HasPrefix(head, []byte{0x5d, 0x00, 0x00}):
+		return "data.tar.lzma", true // LZMA "alone" has no magic; this is its usual properties byte and dictionary size
+	case len(head) >= 262 && bytes.Equal(head[257:262], []byte("ustar")):
+		return "data.tar", true
+	}
+	return "", false
+}
+
+// packageBasePath is a deb, tarball or bundle folder path without its extension, to name
+// the output after; an IPA or zip's gets ".fixed" in its place, to not overwrite it
+func packageBasePath(pkgPath string) string {
+	if isDirectory(pkgPath) {
+		pkgPath = filepath.Clean(pkgPath)
+		return strings.TrimSuffix(pkgPath, filepath.Ext(pkgPath))
+	}
+	if strings.HasSuffix(pkgPath, ".deb") {
+		return strings.TrimSuffix(pkgPath, ".deb")
+	}
+	for _, suffix := range []string{".ipa", ".zip"} {
+		if strings.HasSuffix(pkgPath, suffix) {
+			return strings.TrimSuffix(pkgPath, suffix) + ".fixed"
+		}
+	}
+	for _, suffix := range tarballSuffixes {
+		if strings.HasSuffix(pkgPath, suffix) {
+			return strings.TrimSuffix(pkgPath, suffix)
+		}
+	}
+	return pkgPath
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/schollz/progressbar/v3"
+)
+
+// --- Throughput ---
+// A slow conversion can be waiting on xz, on the spill disk or on the output's file
+// system. Bytes are counted at four points, compressed in from the deb, out of the
+// decompressor, into spill files and into the IPA, and the progress bar of each phase
+// shows their rate over the last second beside their average. The averages, over the
+// time each stream was moving, go in the summary and --report.
+
+// throughputInterval is how often the rates on the progress bar are refreshed
+const throughputInterval = time.Second
+
+// throughputLogEvery is, in intervals, how often the rates are logged instead where
+// stderr can't redraw a bar, or a phase has none
+const throughputLogEvery = 10
+
+// Streams a throughputMeter counts
+const (
+	flowDebIn = iota
+	flowTarOut
+	flowSpill
+	flowZip
+	flowCount
+)
+
+// flowNames label the streams, on the progress bar and in the summary
+var flowNames = [flowCount]string{"in", "decompressed", "spilled", "zipped"}
+
+// Throughput is one stream's bytes and its average rate while it was moving
+type Throughput struct {
+	Bytes   int64   `json:"bytes"`
+	Seconds float64 `json:"seconds"` // From its first byte to its last
+	MBps    float64 `json:"mbps"`    // Bytes / Seconds, in MB (10^6) per second
+}
+
+// ThroughputInfo is the average rate of each stream a conversion used
+type ThroughputInfo struct {
+	DebIn  *Throughput `json:"debIn,omitempty"`  // Compressed data.tar bytes read from the deb
+	TarOut *Throughput `json:"tarOut,omitempty"` // Bytes out of the decompressor
+	Spill  *Throughput `json:"spill,omitempty"`  // Bytes written to spill files
+	Zip    *Throughput `json:"zip,omitempty"`    // Bytes written to the IPA
+}
+
+// flowMeter counts one stream's bytes and when they first and last moved
+type flowMeter struct {
+	n     atomic.Int64
+	first atomic.Int64 // UnixNano; 0 until the first byte
+	last  atomic.Int64
+}
+
+func (f *flowMeter) add(n int) {
+	if n <= 0 {
+		return
+	}
+	now := time.Now().UnixNano()
+	f.first.CompareAndSwap(0, now)
+	f.last.Store(now)
+	f.n.Add(int64(n))
+}
+
+// average is the stream's Throughput, nil if nothing moved
+func (f *flowMeter) average() *Throughput {
+	n := f.n.Load()
+	if n == 0 {
+		return nil
+	}
+	seconds := time.Duration(f.last.Load() - f.first.Load()).Seconds()
+	t := &Throughput{Bytes: n, Seconds: seconds}
+	if seconds > 0 {
+		t.MBps = float64(n) / seconds / 1e6
+	}
+	return t
+}
+
+// throughputMeter counts a conversion's streams. Its zero value counts without showing
+// anything; start shows the rates on the bar of each phase.
+type throughputMeter struct {
+	flows [flowCount]flowMeter
+
+	mu          sync.Mutex
+	bar         *progressbar.ProgressBar // The current phase's bar; nil for a phase without one
+	description string
+	shown       []int // The flows the phase moves
+	stop        chan struct{}
+	done        chan struct{}
+	once        sync.Once
+}
+
+// meteredReader counts what's read through it into a flowMeter
+type meteredReader struct {
+	r io.Reader
+	f *flowMeter
+}
+
+func (m *meteredReader) Read(p []byte) (int, error) {
+	if err := ipc.err(); err != nil {
+		return 0, err
+	}
+	n, err := m.r.Read(p)
+	m.f.add(n)
+	return n, err
+}
+
+// meteredWriter counts what's written through it into a flowMeter
+type meteredWriter struct {
+	w io.Writer
+	f *flowMeter
+}
+
+func (m *meteredWriter) Write(p []byte) (int, error) {
+	if err := ipc.err(); err != nil {
+		return 0, err
+	}
+	n, err := m.w.Write(p)
+	m.f.add(n)
+	return n, err
+}
+
+// reader counts what's read through r as flow
+func (t *throughputMeter) reader(flow int, r io.Reader) io.Reader {
+	return &meteredReader{r: r, f: &t.flows[flow]}
+}
+
+// writer counts what's written through w as flow
+func (t *throughputMeter) writer(flow int, w io.Writer) io.Writer {
+	return &meteredWriter{w: w, f: &t.flows[flow]}
+}
+
+// phase makes bar (may be nil), described as description, show the rates of flows. The
+// phase it ends gets a last progress event, so front ends see where its bar finished.
+func (t *throughputMeter) phase(bar *progressbar.ProgressBar, description string, flows ...int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.bar != nil && t.bar != bar {
+		ipc.progress(t.description, t.bar)
+	}
+	t.bar, t.description, t.shown = bar, description, flows
+	ipc.phase(description, bar)
+}
+
+// start refreshes the current phase's rates every throughputInterval until finish
+func (t *throughputMeter) start() {
+	t.stop, t.done = make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(t.done)
+		ticker := time.NewTicker(throughputInterval)
+		defer ticker.Stop()
+		var prev [flowCount]int64
+		for tick := 1; ; tick++ {
+			select {
+			case <-ticker.C:
+			case <-t.stop:
+				return
+			}
+			var rates []string
+			t.mu.Lock()
+			for _, flow := range t.shown {
+				n := t.flows[flow].n.Load()
+				rate := float64(n-prev[flow]) / throughputInterval.Seconds()
+				if avg := t.flows[flow].average(); avg != nil && avg.MBps > 0 {
+					rates = append(rates, fmt.Sprintf("%s %s (avg %s)", flowNames[flow], formatRate(rate), formatRate(avg.MBps*1e6)))
+				}
+			}
+			for flow := range prev {
+				prev[flow] = t.flows[flow].n.Load()
+			}
+			ipc.progress(t.description, t.bar)
+			switch {
+			case len(rates) == 0:
+			case t.bar != nil && stderrRewrites:
+				t.bar.Describe(t.description + ": " + strings.Join(rates, ", "))
+			case tick%throughputLogEvery == 0:
+				fmt.Fprintf(os.Stderr, "   %s: %s\n", t.description, strings.Join(rates, ", "))
+			}
+			t.mu.Unlock()
+		}
+	}()
+}
+
+// finish stops refreshing and returns the averages. Calling it again is harmless.
+func (t *throughputMeter) finish() *ThroughputInfo {
+	if t.stop != nil {
+		t.once.Do(func() {
+			close(t.stop)
+			<-t.done
+		})
+	}
+	info := &ThroughputInfo{DebIn: t.flows[flowDebIn].average(), TarOut: t.flows[flowTarOut].average(),
+		Spill: t.flows[flowSpill].average(), Zip: t.flows[flowZip].average()}
+	if *info == (ThroughputInfo{}) {
+		return nil
+	}
+	return info
+}
+
+// printThroughput prints the average rate of each stream, where there was time to measure one
+func printThroughput(info *ThroughputInfo) {
+	if info == nil {
+		return
+	}
+	var rates []string
+	for i, t := range []*Throughput{info.DebIn, info.TarOut, info.Spill, info.Zip} {
+		if t != nil && t.MBps > 0 {
+			rates = append(rates, fmt.Sprintf("%s %s", flowNames[i], formatRate(t.MBps*1e6)))
+		}
+	}
+	if len(rates) > 0 {
+		fmt.Printf("   Throughput: %s (averages while each was moving)\n", strings.Join(rates, ", "))
+	}
+}
+
+// formatRate is a rate in bytes per second, e.g. "106.1 MB/s"
+func formatRate(bytesPerSecond float64) string {
+	return formatBytes(int64(bytesPerSecond)) + "/s"
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- Trace: a timestamped log of every decision, for bug reports ---
+// --trace trace.log appends one line per event, whatever the console verbosity:
+//
+//	2026-10-16T09:30:01.123456Z 4121 ar-member name=data.tar.xz size=10240 magic=fd377a585a00
+//	2026-10-16T09:30:01.125002Z 4121 entry name=./Applications/Foo.app/Foo type=file size=70 storage=ram reason="fits in the RAM budget"
+//	2026-10-16T09:30:01.140311Z 4121 stage name=read duration=16.855ms
+//
+// Each line is a UTC timestamp, the process ID (batch jobs can share a file), an event name,
+// then key=value pairs quoted as in the RESULT line. Events and keys are only ever added
+// to. Unlike doctor --redact, nothing is redacted: the trace holds every path in the deb.
+//
+// Events: start, ar-member, decompressor, entry, metadata, plist-edit, warning, zip-entry,
+// stage and end.
+
+// trace is the run's tracer, nil (recording nothing) without --trace
+var trace *tracer
+
+// tracer appends events to the trace file; a nil tracer records nothing
+type tracer struct {
+	mu        sync.Mutex
+	file      *os.File
+	pid       string
+	lastStage time.Time
+}
+
+// openTrace opens path for appending and starts the run's trace with its arguments
+func openTrace(path string) (*tracer, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("--trace: %w", err)
+	}
+	t := &tracer{file: f, pid: strconv.Itoa(os.Getpid()), lastStage: time.Now()}
+	t.event("start", "tool", toolVersion(), "args", strings.Join(os.Args[1:], " "))
+	return t, nil
+}
+
+// event writes one line: the event name, then key/value pairs with empty values left out
+func (t *tracer) event(name string, pairs ...string) {
+	if t == nil {
+		return
+	}
+	var b strings.Builder
+	b.WriteString(time.Now().UTC().Format("2006-01-02T15:04:05.000000Z"))
+	b.WriteString(" " + t.pid + " " + name)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] != "" {
+			b.WriteString(" " + pairs[i] + "=" + resultValue(pairs[i+1]))
+		}
+	}
+	b.WriteByte('\n')
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.file.WriteString(b.String()) // A trace that can't be written mustn't fail the conversion
+}
+
+// stage records a stage ending, with the time since the previous one ended
+func (t *tracer) stage(name string) {
+	if t == nil {
+		return
+	}
+	now := time.Now()
+	t.event("stage", "name", name, "duration", now.Sub(t.lastStage).String())
+	t.lastStage = now
+}
+
+// arMember records an ar member and its first bytes, which tell its real format
+func (t *tracer) arMember(m arMember, f io.ReaderAt) {
+	if t == nil {
+		return
+	}
+	magic := make([]byte, 6)
+	n, _ := f.ReadAt(magic, m.Offset)
+	t.event("ar-member", "name", m.Name, "offset", strconv.FormatInt(m.Offset, 10), "size", strconv.FormatInt(m.Size, 10), "magic", fmt.Sprintf("%x", magic[:min(n, int(m.Size))]))
+}
+
+// entry records an input entry's storage decision
+func (t *tracer) entry(e StorageEntry, storage Storage, reason string) {
+	if t == nil {
+		return
+	}
+	t.event("entry", "name", e.Name, "type", traceEntryType(e.Type), "size", strconv.FormatInt(e.Size, 10), "storage", storage.String(), "reason", reason)
+}
+
+// traceEntryType names a tar type flag
+func traceEntryType(typeflag byte) string {
+	switch typeflag {
+	case tar.TypeReg, tar.TypeGNUSparse:
+		return "file"
+	case tar.TypeDir:
+		return "dir"
+	case tar.TypeSymlink:
+		return "symlink"
+	case tar.TypeLink:
+		return "hardlink"
+	case 0:
+		return "" // Not from a tar: a zip or folder input, or a rewritten file
+	}
+	return "type-" + string(typeflag)
+}
+
+// archive records every entry of the written archive from its central directory: the
+// final path, method, mode and CRC32 as installers will read them
+func (t *tracer) archive(path string) {
+	if t == nil {
+		return
+	}
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		t.event("zip-entry", "error", err.Error())
+		return
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		t.event("zip-entry", "name", f.Name, "method", methodName(f.Method), "mode", fmt.Sprintf("%06o", f.ExternalAttrs>>16),
+			"crc32", fmt.Sprintf("%08x", f.CRC32), "size", strconv.FormatUint(f.UncompressedSize64, 10), "compressed", strconv.FormatUint(f.CompressedSize64, 10))
+	}
+}
+
+// close ends the trace with the run's outcome
+func (t *tracer) close(status string, err error, elapsed time.Duration) {
+	if t == nil {
+		return
+	}
+	message := ""
+	if err != nil {
+		message = err.Error()
+	}
+	t.event("end", "status", status, "error", message, "duration", elapsed.String())
+	t.file.Close()
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	ar "github.com/erikgeiser/ar"
+)
+
+// --- Two-pass reading: index data.tar, then extract only what the conversion needs ---
+// The first pass decompresses data.tar reading headers only. With the full entry list known,
+// the app prefix, the filter and the byte totals are settled before any file data is read;
+// the second pass re-reads the member from its offset in the deb, skipping everything
+// outside the app and stopping after the last entry kept. Only seekable (regular file)
+// inputs can be read twice.
+
+// twoPassAutoSize is the largest deb read in two passes without --two-pass: decompressing
+// twice costs less than buffering files the conversion throws away, up to a point
+const twoPassAutoSize = 1 << 30
+
+// arMember locates an ar member's data in the deb file, so it can be read again
+type arMember struct {
+	Name   string
+	Offset int64 // Of the member's data, past its header
+	Size   int64
+}
+
+// open returns a fresh reader over the member's data
+func (m arMember) open(f io.ReaderAt) io.Reader {
+	return io.NewSectionReader(f, m.Offset, m.Size)
+}
+
+// checkSize fails when the member claims more bytes than the deb has left past its offset,
+// so a truncated download is caught before minutes of decompression rather than deep in
+// them. fileSize is negative for pipes and devices, whose length isn't known: no check.
+func (m arMember) checkSize(fileSize int64) error {
+	if fileSize < 0 {
+		return nil
+	}
+	if remaining := max(fileSize-m.Offset, 0); m.Size > remaining {
+		return fmt.Errorf("deb is truncated: member %s claims %d bytes but only %d remain", m.Name, m.Size, remaining)
+	}
+	return nil
+}
+
+// countingReader counts the bytes read through it, which gives the ar reader's position
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// resumeArAfter returns an ar reader over the members after m, starting past its data and
+// padding instead of reading through them, with position counting from the deb's start
+func resumeArAfter(f io.ReaderAt, m arMember, fileSize int64, position *countingReader) (*ar.Reader, error) {
+	end := min(m.Offset+m.Size+m.Size%2, fileSize)
+	const magic = "!<arch>\n"
+	position.r = io.MultiReader(strings.NewReader(magic), io.NewSectionReader(f, end, fileSize-end))
+	position.n = end - int64(len(magic))
+	return ar.NewReader(position)
+}
+
+// useTwoPass decides whether the deb is indexed first: always with --two-pass (force),
+// otherwise for files up to twoPassAutoSize. Pipes and devices can't be read twice.
+func useTwoPass(debPath string, force bool) bool {
+	info, err := os.Stat(debPath)
+	if err != nil || !info.Mode().IsRegular() {
+		if force {
+			fmt.Println("   --two-pass: the input can't be read twice, reading it in one pass")
+		}
+		return false
+	}
+	return force || info.Size() <= twoPassAutoSize
+}
+
+// nextTarEntry returns the next data.tar entry with its name cleaned up, skipping the
+// entries that aren't files: PAX global headers and the archive root. Both passes read
+// through it, so they count entries alike, and limits (may be nil) sees every one.
+func nextTarEntry(tr *tar.Reader, limits *entryLimiter) (*tar.Header, error) {
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		// A PAX global header only carries defaults for later entries (archive/tar has already
+		// applied them); its name is no path and mustn't take part in prefix detection
+		if header.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+		if err := limits.checkHeader(header); err != nil {
+			return nil, err
+		}
+
+		// Archivers disagree on "./" prefixes, and a single tar can mix them when PAX path
+		// records (long names) are written differently from the ustar names around them
+		header.Name = tarEntryName(header.Name)
+		if header.Name == "" {
+			continue // The archive root itself
+		}
+		return header, nil
+	}
+}
+
+// indexEntry is one data.tar entry as the indexing pass saw it: the header, no body
+type indexEntry struct {
+	Name string
+	Size int64
+	Type byte
+	Link string // Symlink target
+}
+
+// readTarIndex lists data.tar's entries without reading their contents, beyond what
+// candidates (may be nil) needs to rank the bundle folders
+func readTarIndex(member arMember, f io.ReaderAt, limits InputLimits, candidates *bundleCandidates) ([]indexEntry, error) {
+	dataTar, err := decompress(member.Name, member.open(f))
+	if err != nil {
+		return nil, fmt.Errorf("decompression failed: %w", err)
+	}
+	tr := tar.NewReader(dataTar)
+	limiter := newEntryLimiter(limits)
+	var index []indexEntry
+	for {
+		header, err := nextTarEntry(tr, limiter)
+		if err == io.EOF {
+			return index, nil
+		}
+		var limit *LimitError
+		if errors.As(err, &limit) {
+			return nil, err
+		}
+		if err != nil {
+			return nil, fmt.Errorf("tar read error: %w", err)
+		}
+		index = append(index, indexEntry{Name: header.Name, Size: header.Size, Type: header.Typeflag, Link: header.Linkname})
+		if header.Typeflag == tar.TypeSymlink {
+			candidates.noteLink(header.Name, header.Linkname)
+		} else if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeGNUSparse {
+			candidates.note(header.Name, nil)
+		} else if err := candidates.noteReader(header.Name, header.Size, tr); err != nil {
+			return nil, fmt.Errorf("tar read error: %w", err)
+		}
+	}
+}
+
+// debPlan is what the indexing pass decided before any file data is read
+type debPlan struct {
+	AppDirPrefix string
+	Launcher     string       // The Applications/ symlink's name for the app folder, when it has one
+	Entries      int          // In data.tar
+	Kept         int          // Entries the second pass reads
+	KeptBytes    int64        // File data the second pass reads
+	SkippedBytes int64        // File data outside the app or excluded, never read
+	Outside      []indexEntry // Entries skipped for being outside the app, headers only
+	Decoys       []string     // Bundle folders passed over as not apps (see bundlerank.go)
+
+	keep []bool // By entry index
+	last int    // Index of the last kept entry; -1 when none
+}
+
+// planDeb settles the app prefix, ro.AppPrefix if given (see normalizeAppPrefix), else the
+// best of candidates as a single pass chooses it, and marks the entries to extract: the app's,
+// minus ro.Filter exclusions, plus a bundle container's iTunesMetadata.plist, or with
+// ro.KeepOutside everything outside the app.
+func planDeb(index []indexEntry, candidates *bundleCandidates, ro readOptions) (*debPlan, error) {
+	filter, appPrefix := ro.Filter, ro.AppPrefix
+	plan := &debPlan{Entries: len(index), keep: make([]bool, len(index)), last: -1, AppDirPrefix: appPrefix}
+	if appPrefix == "" {
+		plan.AppDirPrefix, plan.Decoys = candidates.choose()
+		plan.Launcher = candidates.launcher(plan.AppDirPrefix)
+	} else {
+		seen := make(appPrefixesSeen)
+		matched := false
+		for _, entry := range index {
+			seen.note(entry.Name, ro.bundleExt())
+			matched = matched || inAppPrefix(entry.Name, appPrefix)
+		}
+		if !matched {
+			return nil, unmatchedAppPrefix(appPrefix, ro.bundleExt(), seen)
+		}
+	}
+
+	containerMeta := ""
+	if dir, _, ok := containerDir(plan.AppDirPrefix); ok {
+		containerMeta = dir + "iTunesMetadata.plist"
+	}
+
+	for i, entry := range index {
+		isFile := entry.Type == tar.TypeReg || entry.Type == tar.TypeGNUSparse
+		keep, outside := false, false
+		switch {
+		case plan.AppDirPrefix != "" && inAppPrefix(entry.Name, plan.AppDirPrefix):
+			keep = !filter.Excluded(appRelPath(entry.Name, plan.AppDirPrefix), entry.Type == tar.TypeDir)
+		case entry.Name == containerMeta:
+			keep = true
+		case plan.AppDirPrefix == "" && ro.Nested && isFile && isNestedDeb(entry.Name):
+			keep = true
+		default:
+			keep, outside = ro.KeepOutside, !ro.KeepOutside
+		}
+		keep = keep && (isFile || entry.Type == tar.TypeDir || entry.Type == tar.TypeSymlink)
+		if outside {
+			plan.Outside = append(plan.Outside, entry)
+		}
+
+		if !keep {
+			if isFile {
+				plan.SkippedBytes += entry.Size
+			}
+			continue
+		}
+		plan.keep[i] = true
+		plan.last = i
+		plan.Kept++
+		if isFile {
+			plan.KeptBytes += entry.Size
+		}
+	}
+	return plan, nil
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"debug/macho"
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// --- Universal IPAs: one app from debs built for different architectures ---
+// Repos often publish the same app version twice, e.g. an arm64 deb and an arm64e one.
+// --universal other.deb converts the primary deb as usual, then reads the other one and
+// lipo-merges the main executable and every Mach-O under Frameworks/ the two have in
+// common into fat files. Each slice keeps its own code signature, so nothing is re-signed.
+// Files only one deb has are taken as they are; the Info.plist and everything else come
+// from the primary. The debs must have the same CFBundleVersion, unless
+// --allow-version-mismatch says otherwise.
+
+// Fat header constants not in bitcode.go
+const (
+	cpuSubtypeMask   = 0xff000000 // Capability bits in cpusubtype, not part of the architecture
+	cpuSubtypeArm64E = 2
+	fatAlignArm      = 14 // log2 of the slice alignment lipo uses: 16 KiB pages on ARM...
+	fatAlignOther    = 12 // ...4 KiB elsewhere
+	fatMaxOffset     = 1<<32 - 1
+)
+
+// UniversalResult is what --universal merged, and what it left alone
+type UniversalResult struct {
+	Deb    string           `json:"deb"`              // The --universal deb's file name
+	Merged []UniversalMerge `json:"merged,omitempty"` // Files now fat, with their architectures
+	AsIs   []UniversalAsIs  `json:"asIs,omitempty"`   // Mach-O files taken from one deb only
+}
+
+// UniversalMerge is one file lipo-merged from both debs
+type UniversalMerge struct {
+	Path   string   `json:"path"`
+	Arches []string `json:"arches"` // Primary's slices first
+}
+
+// UniversalAsIs is a Mach-O file --universal couldn't or didn't need to merge
+type UniversalAsIs struct {
+	Path   string `json:"path"`
+	From   string `json:"from"` // "primary" or "secondary"
+	Reason string `json:"reason"`
+}
+
+// fatSlice is one architecture of a binary, with its bytes
+type fatSlice struct {
+	Cpu, SubCpu uint32
+	Align       uint32 // log2
+	Data        []byte
+}
+
+// arch is the slice's architecture name, e.g. "arm64e"
+func (s fatSlice) arch() string {
+	return fatArchName(s.Cpu, s.SubCpu)
+}
+
+// sameArch reports whether two slices are the same architecture, capability bits aside
+func (s fatSlice) sameArch(o fatSlice) bool {
+	return s.Cpu == o.Cpu && s.SubCpu&^cpuSubtypeMask == o.SubCpu&^cpuSubtypeMask
+}
+
+// fatArchName names an architecture the way lipo does, telling arm64e apart from arm64
+func fatArchName(cpu, subCpu uint32) string {
+	if macho.Cpu(cpu) == macho.CpuArm64 && subCpu&^cpuSubtypeMask == cpuSubtypeArm64E {
+		return "arm64e"
+	}
+	return archName(macho.Cpu(cpu))
+}
+
+// mergeUniversal reads the --universal deb and merges its slices into the primary's main
+// executable and frameworks, replacing their data in the store
+func mergeUniversal(entries []BundleEntry, executableName string, infoPlistData []byte, opts Options, store *SpillStore, warnings *warningLog) ([]BundleEntry, *UniversalResult, error) {
+	fmt.Printf("=> Merging architectures from %s...\n", filepath.Base(opts.Universal))
+	deb, err := readDeb(opts.Universal, store, readOptions{Quiet: true, Limits: opts.Limits})
+	if err != nil {
+		return nil, nil, fmt.Errorf("--universal %s: %w", opts.Universal, err)
+	}
+	secondary, err := selectBundleEntries(deb.Files, filepath.ToSlash(deb.AppDirPrefix))
+	if err != nil {
+		return nil, nil, fmt.Errorf("--universal %s: %w", opts.Universal, err)
+	}
+
+	primaryBuild, secondaryBuild := plistValue(infoPlistData, "CFBundleVersion"), plistValue(deb.InfoPlistData, "CFBundleVersion")
+	if primaryBuild != secondaryBuild {
+		if !opts.AllowVersionMismatch {
+			return nil, nil, fmt.Errorf("--universal %s: CFBundleVersion %q, but the primary deb's is %q; they may not be the same build (--allow-version-mismatch merges them anyway)",
+				filepath.Base(opts.Universal), secondaryBuild, primaryBuild)
+		}
+		warnings.add("universal-version-mismatch", "Info.plist", "Merged %s with CFBundleVersion %q into a primary deb with %q (--allow-version-mismatch)",
+			filepath.Base(opts.Universal), secondaryBuild, primaryBuild)
+	}
+
+	result := &UniversalResult{Deb: filepath.Base(opts.Universal)}
+	index := make(map[string]int, len(entries))
+	for i, entry := range entries {
+		index[entry.RelPath] = i
+	}
+	others := make(map[string]*VirtualFile, len(secondary))
+	for _, entry := range secondary {
+		others[entry.RelPath] = entry.File
+	}
+
+	for _, entry := range entries {
+		if !universalCandidate(entry, executableName) {
+			continue
+		}
+		other := others[entry.RelPath]
+		if other == nil || other.IsDir || other.IsLink || !sniffMachO(other) {
+			result.AsIs = append(result.AsIs, UniversalAsIs{Path: entry.RelPath, From: "primary", Reason: "not in " + result.Deb})
+			continue
+		}
+		merged, arches, err := mergeUniversalFile(entry.File, other)
+		if err != nil {
+			return nil, nil, fmt.Errorf("--universal: %s: %w", entry.RelPath, err)
+		}
+		if merged == nil {
+			result.AsIs = append(result.AsIs, UniversalAsIs{Path: entry.RelPath, From: "primary", Reason: "both debs have the same architectures (" + strings.Join(arches, ", ") + ")"})
+			continue
+		}
+		if err := store.Replace(entry.File, merged); err != nil {
+			return nil, nil, err
+		}
+		result.Merged = append(result.Merged, UniversalMerge{Path: entry.RelPath, Arches: arches})
+	}
+
+	// Frameworks only the secondary ships come along whole, folders included
+	for _, entry := range secondary {
+		if _, exists := index[entry.RelPath]; exists || !strings.HasPrefix(entry.RelPath+"/", "Frameworks/") {
+			continue
+		}
+		index[entry.RelPath] = len(entries)
+		entries = append(entries, entry)
+		if universalCandidate(entry, executableName) {
+			result.AsIs = append(result.AsIs, UniversalAsIs{Path: entry.RelPath, From: "secondary", Reason: "not in the primary deb"})
+		}
+	}
+	return entries, result, nil
+}
+
+// universalCandidate reports whether --universal merges an entry: the main executable, or
+// a Mach-O file under Frameworks/
+func universalCandidate(entry BundleEntry, executableName string) bool {
+	vf := entry.File
+	if vf.IsDir || vf.IsLink || (entry.RelPath != executableName && !strings.HasPrefix(entry.RelPath, "Frameworks/")) {
+		return false
+	}
+	return sniffMachO(vf)
+}
+
+// mergeUniversalFile lipo-merges two binaries, the primary's slices first. It returns nil
+// when the secondary has no architecture the primary lacks, with the architectures either way.
+func mergeUniversalFile(primary, secondary *VirtualFile) ([]byte, []string, error) {
+	var parsed [2][]fatSlice
+	for i, vf := range []*VirtualFile{primary, secondary} {
+		data, err := readAll(vf)
+		if err != nil {
+			return nil, nil, err
+		}
+		if parsed[i], err = fatSlices(data); err != nil {
+			return nil, nil, err
+		}
+	}
+	slices := parsed[0]
+	for _, s := range parsed[1] {
+		if !containsArch(slices, s) {
+			slices = append(slices, s)
+		}
+	}
+	var arches []string
+	for _, s := range slices {
+		arches = append(arches, s.arch())
+	}
+	if len(slices) == len(parsed[0]) {
+		return nil, arches, nil
+	}
+	out, err := buildFat(slices)
+	return out, arches, err
+}
+
+// containsArch reports whether slices has one of s's architecture
+func containsArch(slices []fatSlice, s fatSlice) bool {
+	for _, have := range slices {
+		if have.sameArch(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// fatSlices splits a thin or fat Mach-O into its architectures
+func fatSlices(data []byte) ([]fatSlice, error) {
+	if len(data) >= fatHeaderSize && binary.BigEndian.Uint32(data) == fatMagic {
+		n := int(binary.BigEndian.Uint32(data[4:]))
+		if fatHeaderSize+n*fatArchHeaderSize > len(data) {
+			return nil, fmt.Errorf("fat header lists %d architectures, more than fit", n)
+		}
+		slices := make([]fatSlice, n)
+		for i := range slices {
+			h := data[fatHeaderSize+i*fatArchHeaderSize:]
+			offset, size := int64(binary.BigEndian.Uint32(h[8:])), int64(binary.BigEndian.Uint32(h[12:]))
+			if offset+size > int64(len(data)) {
+				return nil, fmt.Errorf("fat slice %d runs past the end of the file", i)
+			}
+			slices[i] = fatSlice{Cpu: binary.BigEndian.Uint32(h), SubCpu: binary.BigEndian.Uint32(h[4:]), Align: binary.BigEndian.Uint32(h[16:]), Data: data[offset : offset+size]}
+		}
+		return slices, nil
+	}
+	f, err := macho.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("not a Mach-O binary this can merge: %w", err)
+	}
+	align := uint32(fatAlignOther)
+	if f.Cpu == macho.CpuArm || f.Cpu == macho.CpuArm64 {
+		align = fatAlignArm
+	}
+	return []fatSlice{{Cpu: uint32(f.Cpu), SubCpu: f.SubCpu, Align: align, Data: data}}, nil
+}
+
+// buildFat writes slices as a fat Mach-O: the big-endian header and one fat_arch per
+// slice, then each slice at an offset aligned to 2^Align
+func buildFat(slices []fatSlice) ([]byte, error) {
+	offsets := make([]int64, len(slices))
+	end := int64(fatHeaderSize + len(slices)*fatArchHeaderSize)
+	for i, s := range slices {
+		align := int64(1) << s.Align
+		offsets[i] = (end + align - 1) &^ (align - 1)
+		end = offsets[i] + int64(len(s.Data))
+	}
+	if end > fatMaxOffset {
+		return nil, fmt.Errorf("the merged binary would be %s, past the 4 GiB a fat header can address", formatBytes(end))
+	}
+
+	out := make([]byte, end)
+	binary.BigEndian.PutUint32(out, fatMagic)
+	binary.BigEndian.PutUint32(out[4:], uint32(len(slices)))
+	for i, s := range slices {
+		h := out[fatHeaderSize+i*fatArchHeaderSize:]
+		binary.BigEndian.PutUint32(h, s.Cpu)
+		binary.BigEndian.PutUint32(h[4:], s.SubCpu)
+		binary.BigEndian.PutUint32(h[8:], uint32(offsets[i]))
+		binary.BigEndian.PutUint32(h[12:], uint32(len(s.Data)))
+		binary.BigEndian.PutUint32(h[16:], s.Align)
+		copy(out[offsets[i]:], s.Data)
+	}
+	return out, nil
+}
+
+// printUniversal lists the files merged into fat binaries and those taken from one deb
+func printUniversal(result *UniversalResult, warnings *warningLog) {
+	if result == nil {
+		return
+	}
+	if len(result.Merged) == 0 {
+		warnings.add("universal-nothing-merged", "", "--universal %s added no architecture to any binary; the IPA is as the primary deb alone would make it", result.Deb)
+	} else {
+		fmt.Printf("   Merged into universal binaries (%d):\n", len(result.Merged))
+		for _, m := range result.Merged {
+			fmt.Printf("     - %s (%s)\n", terminalSafe(m.Path), strings.Join(m.Arches, ", "))
+		}
+	}
+	if len(result.AsIs) > 0 {
+		fmt.Printf("   ℹ️  Taken as-is (%d):\n", len(result.AsIs))
+		for _, a := range result.AsIs {
+			fmt.Printf("     - %s, from the %s deb: %s\n", terminalSafe(a.Path), a.From, a.Reason)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// bundleVersionPattern is what iOS accepts for CFBundleShortVersionString and CFBundleVersion:
+// up to three period-separated integers, e.g. "1", "1.2", "1.2.3"
+var bundleVersionPattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+){0,2}$`)
+
+// validateVersionFlags rejects --bundle-id/--app-version/--build-number/--min-os values installers would choke on
+func validateVersionFlags(opts Options) error {
+	for _, f := range []struct{ flag, value string }{
+		{"app-version", opts.AppVersion}, {"build-number", opts.BuildNumber}, {"min-os", opts.MinOS},
+	} {
+		if f.value != "" && !bundleVersionPattern.MatchString(f.value) {
+			return fmt.Errorf("--%s %q: want up to three period-separated integers, e.g. 1.2.3", f.flag, f.value)
+		}
+	}
+	if opts.BundleID != "" && !bundleIDPattern.MatchString(opts.BundleID) {
+		return fmt.Errorf("--bundle-id %q: want letters, digits, hyphens and periods, e.g. com.example.app", opts.BundleID)
+	}
+	return nil
+}
+
+// VersionOverride records an Info.plist version key replaced by a flag
+type VersionOverride struct {
+	Key      string `json:"key"`
+	Previous string `json:"previous,omitempty"` // Empty if the key was absent
+	Value    string `json:"value"`
+}
+
+// applyVersionOverrides writes --bundle-id, --app-version, --build-number and --min-os into the bundle's Info.plist,
+// creating the keys if needed, and returns the new plist data
+func applyVersionOverrides(entries []BundleEntry, infoPlistData []byte, opts Options, store *SpillStore) ([]byte, []VersionOverride, error) {
+	var overrides []VersionOverride
+	values := make(map[string]any)
+	for _, o := range []struct{ key, value string }{
+		{"CFBundleIdentifier", opts.BundleID},
+		{"CFBundleShortVersionString", opts.AppVersion},
+		{"CFBundleVersion", opts.BuildNumber},
+		{"MinimumOSVersion", opts.MinOS},
+	} {
+		if o.value == "" {
+			continue
+		}
+		values[o.key] = o.value
+		overrides = append(overrides, VersionOverride{Key: o.key, Previous: plistValue(infoPlistData, o.key), Value: o.value})
+	}
+	if len(overrides) == 0 {
+		return infoPlistData, nil, nil
+	}
+
+	for _, entry := range entries {
+		if entry.RelPath != "Info.plist" || entry.File.IsDir || entry.File.IsLink {
+			continue
+		}
+		data, err := setPlistKeys(entry.RelPath, infoPlistData, values)
+		if err != nil {
+			return nil, nil, fmt.Errorf("updating Info.plist: %w", err)
+		}
+		if err := store.Replace(entry.File, data); err != nil {
+			return nil, nil, err
+		}
+		return data, overrides, nil
+	}
+	return nil, nil, fmt.Errorf("can't set the version: the bundle has no Info.plist")
+}
+
+// printVersionOverrides adds the overridden keys to the metadata summary
+func printVersionOverrides(overrides []VersionOverride) {
+	for _, o := range overrides {
+		previous := o.Previous
+		if previous == "" {
+			previous = "unset"
+		}
+		fmt.Printf("   %s: %s (overridden, was %s)\n", o.Key, o.Value, previous)
+	}
+}
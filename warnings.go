@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// --- Warnings: problems that didn't stop the conversion ---
+// Collected on the Result rather than printed as they happen, so library callers, the JSON
+// report and batch summaries see them too. The CLI prints them grouped after the conversion.
+//
+// Each code has a severity. Most are "warning": worth a look, but the IPA likely works.
+// The codes in errorWarningCodes are "error": the conversion finished, but the IPA is
+// almost certainly broken on a stock device. --warn-exit-level turns either into exit
+// status 10, so scripts can tell a clean conversion from one that needs a human.
+
+// Warning is one problem found during a conversion
+type Warning struct {
+	Code     string `json:"code"`           // Stable kebab-case identifier, e.g. "root-dylib-rpath"
+	Message  string `json:"message"`        // Human-readable, complete on its own
+	Path     string `json:"path,omitempty"` // The bundle path (or archive entry name) it is about, if any
+	Severity string `json:"severity"`       // SeverityError or SeverityWarning, by code
+}
+
+// errorWarningCodes are the warnings that mean the IPA almost certainly won't install or launch
+var errorWarningCodes = map[string]bool{
+	"executable-encrypted":      true, // FairPlay: only runs for the account that bought it
+	"executable-missing":        true, // Nothing at the bundle root for iOS to launch
+	"jb-paths":                  true, // Links or loads substrate and other jailbreak-only paths
+	"root-dylib-rpath":          true, // dyld won't find a dylib the executable loads
+	"root-dylib-absolute":       true,
+	"extension-id":              true, // installd refuses the whole IPA
+	"appclip-id":                true,
+	"excluded-framework-linked": true, // dyld aborts the launch without it
+}
+
+// warningSeverity is the severity of a warning code
+func warningSeverity(code string) string {
+	if errorWarningCodes[code] {
+		return SeverityError
+	}
+	return SeverityWarning
+}
+
+// warningLog accumulates a conversion's warnings
+type warningLog struct {
+	list []Warning
+	seen map[Warning]struct{} // What's in list, so a repeat is found without scanning it
+}
+
+// add records a warning; path may be "". A warning already recorded, e.g. by a helper that
+// runs twice, isn't repeated.
+func (w *warningLog) add(code, path, format string, args ...any) {
+	warning := Warning{Code: code, Message: fmt.Sprintf(format, args...), Path: path, Severity: warningSeverity(code)}
+	if _, dup := w.seen[warning]; dup {
+		return
+	}
+	if w.seen == nil {
+		w.seen = make(map[Warning]struct{})
+	}
+	w.seen[warning] = struct{}{}
+	w.list = append(w.list, warning)
+	trace.event("warning", "code", code, "severity", warning.Severity, "path", path, "message", warning.Message)
+	ipc.send(IPCEvent{Event: "warning", Warning: &warning})
+}
+
+// warningsPerCode is how many messages printWarnings shows for one code before summarizing
+const warningsPerCode = 5
+
+// printWarnings lists warnings grouped by code, codes in order of first appearance
+func printWarnings(warnings []Warning) {
+	if len(warnings) == 0 {
+		return
+	}
+	var codes []string
+	groups := make(map[string][]Warning)
+	for _, w := range warnings {
+		if _, seen := groups[w.Code]; !seen {
+			codes = append(codes, w.Code)
+		}
+		groups[w.Code] = append(groups[w.Code], w)
+	}
+
+	fmt.Printf("\n⚠️  Warnings (%d)\n", len(warnings))
+	for _, code := range codes {
+		group := groups[code]
+		if group[0].Severity == SeverityError {
+			fmt.Printf("   %s (%d, error)\n", code, len(group))
+		} else {
+			fmt.Printf("   %s (%d)\n", code, len(group))
+		}
+		for i, w := range group {
+			if i == warningsPerCode {
+				fmt.Printf("     ... and %d more\n", len(group)-i)
+				break
+			}
+			fmt.Printf("     %s\n", terminalSafe(w.Message))
+		}
+	}
+}
+
+// warningsExceeded reports whether a run with these warnings should fail: any warning with
+// strict, more than max otherwise (a negative max never fails)
+func warningsExceeded(warnings []Warning, max int, strict bool) bool {
+	if strict {
+		return len(warnings) > 0
+	}
+	return max >= 0 && len(warnings) > max
+}
+
+// Values of --warn-exit-level
+const (
+	WarnExitNever   = "never"   // Warnings never change the exit status
+	WarnExitWarning = "warning" // Any warning exits with exitWarnings
+	WarnExitError   = "error"   // Only error-severity warnings do
+)
+
+// exitWarnings is the exit status of a conversion that succeeded with warnings at or
+// above --warn-exit-level
+const exitWarnings = 10
+
+// warnExitTriggers returns the warnings at or above level, which make the run exit with exitWarnings
+func warnExitTriggers(warnings []Warning, level string) []Warning {
+	var triggers []Warning
+	for _, w := range warnings {
+		if level == WarnExitWarning || (level == WarnExitError && w.Severity == SeverityError) {
+			triggers = append(triggers, w)
+		}
+	}
+	return triggers
+}
+
+// printWarnExit says which warnings set the exit status, by code
+func printWarnExit(triggers []Warning, level string) {
+	var codes []string
+	counts := make(map[string]int)
+	for _, w := range triggers {
+		if counts[w.Code] == 0 {
+			codes = append(codes, w.Code)
+		}
+		counts[w.Code]++
+	}
+	for i, code := range codes {
+		codes[i] = fmt.Sprintf("%s (%d)", code, counts[code])
+	}
+	fmt.Printf("\n⚠️  Converted with issues: exit status %d for --warn-exit-level %s, from %s\n", exitWarnings, level, strings.Join(codes, ", "))
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"unicode/utf8"
+)
+
+// deflateInMemoryLimit is the largest file compressed into RAM before being written;
+// bigger ones are compressed into a temp file in the spill directory
+const deflateInMemoryLimit = 32 << 20
+
+// flateWriters reuses compressors across entries: each one holds about a megabyte of
+// state, which for icon packs of tiny files would otherwise be allocated per entry
+var flateWriters = sync.Pool{New: func() any {
+	fw, _ := flate.NewWriter(nil, flate.DefaultCompression)
+	return fw
+}}
+
+// writeZipFile writes a file or symlink entry with its CRC and sizes in the local header.
+// archive/zip's CreateHeader streams and so sets the data descriptor flag on every file,
+// which some installers refuse; CreateRaw doesn't, once we know the sizes up front.
+// progress (may be nil) receives the uncompressed bytes as they're processed.
+func writeZipFile(zw *zip.Writer, header *zip.FileHeader, vf *VirtualFile, spillDir string, progress io.Writer) error {
+	if progress == nil {
+		progress = io.Discard
+	}
+	prepareRawHeader(header)
+
+	if vf.IsLink {
+		data := []byte(vf.LinkDest)
+		header.Method = zip.Store
+		header.CRC32 = crc32.ChecksumIEEE(data)
+		header.CompressedSize64 = uint64(len(data))
+		header.UncompressedSize64 = uint64(len(data))
+		w, err := zw.CreateRaw(header)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}
+
+	if header.Method == zip.Store {
+		// Two passes over the source: checksum, then copy
+		rc, err := vf.Open()
+		if err != nil {
+			return err
+		}
+		crc := crc32.NewIEEE()
+		n, err := io.Copy(crc, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+		header.CRC32 = crc.Sum32()
+		header.CompressedSize64 = uint64(n)
+		header.UncompressedSize64 = uint64(n)
+
+		w, err := zw.CreateRaw(header)
+		if err != nil {
+			return err
+		}
+		if rc, err = vf.Open(); err != nil {
+			return err
+		}
+		defer rc.Close()
+		// A file read from where it lies (an --add source) may change between the passes,
+		// which would leave the header's CRC and sizes describing other data
+		again := crc32.NewIEEE()
+		copied, err := io.Copy(io.MultiWriter(w, again, progress), rc)
+		if err != nil {
+			return err
+		}
+		if copied != n || again.Sum32() != header.CRC32 {
+			return fmt.Errorf("%s changed while it was being written: %d bytes with CRC %08x, then %d with %08x", vf.Name, n, header.CRC32, copied, again.Sum32())
+		}
+		return nil
+	}
+
+	// Deflate into a buffer (or temp file), checksumming on the way, then copy it in raw
+	var compressed interface {
+		io.Writer
+		io.Reader
+	}
+	if vf.Size <= deflateInMemoryLimit {
+		compressed = &bytes.Buffer{}
+	} else {
+		tmp, err := os.CreateTemp(spillDir, "deflate_*")
+		if err != nil {
+			return spillDirError(spillDir, err)
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+		compressed = tmp
+	}
+
+	rc, err := vf.Open()
+	if err != nil {
+		return err
+	}
+	counter := &countingWriter{w: compressed}
+	fw := flateWriters.Get().(*flate.Writer)
+	defer flateWriters.Put(fw)
+	fw.Reset(counter)
+	crc := crc32.NewIEEE()
+	n, err := io.Copy(io.MultiWriter(fw, crc, progress), rc)
+	rc.Close()
+	if err == nil {
+		err = fw.Close()
+	}
+	if err != nil {
+		return spillDirError(spillDir, err)
+	}
+
+	header.CRC32 = crc.Sum32()
+	header.CompressedSize64 = uint64(counter.n)
+	header.UncompressedSize64 = uint64(n)
+	w, err := zw.CreateRaw(header)
+	if err != nil {
+		return err
+	}
+	if tmp, ok := compressed.(*os.File); ok {
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	_, err = io.Copy(w, compressed)
+	return err
+}
+
+// prepareRawHeader does the header bookkeeping CreateHeader does but CreateRaw leaves to
+// the caller: the UTF-8 name flag, the version fields and the modification time (MS-DOS
+// fields plus the Info-ZIP extended timestamp), without which entries read as 1980
+func prepareRawHeader(header *zip.FileHeader) {
+	if utf8.ValidString(header.Name) && !isASCII(header.Name) {
+		header.Flags |= 0x800
+	}
+	header.CreatorVersion = header.CreatorVersion&0xff00 | 20
+	header.ReaderVersion = 20
+
+	if header.Modified.IsZero() {
+		return
+	}
+	t := header.Modified
+	header.ModifiedDate = uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9)
+	header.ModifiedTime = uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+	extra := make([]byte, 9)
+	binary.LittleEndian.PutUint16(extra, 0x5455) // Extended timestamp
+	binary.LittleEndian.PutUint16(extra[2:], 5)
+	extra[4] = 1 // Flags: ModTime
+	binary.LittleEndian.PutUint32(extra[5:], uint32(t.Unix()))
+	header.Extra = append(header.Extra, extra...)
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// countingWriter counts the bytes passing through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}